@@ -1,68 +1,584 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/s3fs-fuse/s3fs-go/internal/cache"
 	"github.com/s3fs-fuse/s3fs-go/internal/credentials"
 	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/compress"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/encrypt"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/localfs"
 )
 
 func main() {
 	var (
-		bucket        = flag.String("bucket", "", "S3 bucket name")
+		bucket        = flag.String("bucket", "", "S3 bucket name; bucket:/path mounts the path prefix of the bucket, as -prefix does")
+		prefix        = flag.String("prefix", "", "Mount the objects under this key prefix, e.g. team-a/data, instead of the whole bucket; nothing outside it can be seen or written (default: whole bucket)")
 		mountpoint    = flag.String("mountpoint", "", "Mount point directory")
 		region        = flag.String("region", "us-east-1", "AWS region")
 		endpoint      = flag.String("endpoint", "", "S3 endpoint URL (for LocalStack or other S3-compatible services)")
 		passwdFile    = flag.String("passwd_file", "", "Path to passwd file")
+		profile       = flag.String("profile", "", "Profile to load from the shared credentials file (~/.aws/credentials)")
 		enableFileLock = flag.Bool("enable_file_lock", false, "Enable file-level advisory locking for stricter coordination (default: false, uses entity-level locking)")
+		retries       = flag.Int("retries", s3client.DefaultMaxRetries, "Number of times to retry a failed S3 request (0 disables retries)")
+		retryMaxDelay = flag.Duration("retry_max_delay", s3client.DefaultRetryMaxDelay, "Maximum delay between S3 request retries")
+		resumes       = flag.Int("download_resumes", s3client.DefaultMaxResumes, "Number of times a download cut short is continued from the last byte received before it is retried from the start (0 always restarts)")
+		requestTimeout   = flag.Duration("request_timeout", s3client.DefaultRequestTimeout, "Time limit on each attempt of an S3 request; a request that hangs longer is retried or fails with EIO (0 disables)")
+		multipartTimeout = flag.Duration("multipart_timeout", s3client.DefaultMultipartTimeout, "Time limit on uploading or copying one multipart part, completing an upload and server-side copies (0 disables)")
+		maxIdleConns     = flag.Int("max_idle_conns", s3client.DefaultMaxIdleConns, "Idle S3 connections kept open for reuse across all hosts (0 = no limit)")
+		maxIdleConnsHost = flag.Int("max_idle_conns_per_host", s3client.DefaultMaxIdleConnsPerHost, "Idle S3 connections kept open for reuse per host; set it to at least the number of S3 requests in flight at once")
+		idleConnTimeout  = flag.Duration("idle_conn_timeout", s3client.DefaultIdleConnTimeout, "How long an unused S3 connection is kept open (0 = no limit)")
+		allowOther    = flag.Bool("allow_other", false, "Allow users other than the mounting user to access the filesystem")
+		defaultPerms  = flag.Bool("default_permissions", false, "Let the kernel enforce permission checks based on file mode and ownership")
+		readOnly      = flag.Bool("ro", false, "Mount the filesystem read-only")
+		snapshotTime  = flag.String("snapshot_time", "", "Mount the bucket read-only as it was at this RFC 3339 time, e.g. 2024-05-01T12:00:00Z, reading the object versions current then; the bucket must be versioned (default: disabled)")
+		strictAtime   = flag.Bool("atime", false, "Store the access time of a file on every read, at the cost of a metadata update per read")
+		noAtime       = flag.Bool("noatime", false, "Never store access times on reads (the default)")
+		relAtime      = flag.Bool("relatime", false, "Store the access time on a read only if it is older than the last change or a day old, at the cost of a metadata update then")
+		forceUID      = flag.Int("uid", -1, "Report all files as owned by this uid (default: stored owner)")
+		forceGID      = flag.Int("gid", -1, "Report all files as owned by this gid (default: stored owner)")
+		fileMode      = flag.String("file_mode", "0644", "Octal mode reported for files without stored mode metadata")
+		dirMode       = flag.String("dir_mode", "0755", "Octal mode reported for directories without stored mode metadata")
+		umask         = flag.String("umask", "0000", "Octal permission bits to clear from the mode of every file and directory, e.g. 0022")
+		forbidMode    = flag.String("forbid_mode", "", "Octal mode bits chmod and file or directory creation may not set, e.g. 6002 for setuid, setgid and world write (default: none)")
+		rejectMode    = flag.Bool("reject_forbidden_mode", false, "Fail requests for -forbid_mode bits with EPERM instead of silently clearing them")
+		iamRole       = flag.String("iam_role", "", "IAM role ARN to assume; temporary credentials are refreshed automatically")
+		iamExternalID = flag.String("iam_role_external_id", "", "External ID to pass when assuming -iam_role")
+		stsEndpoint   = flag.String("sts_endpoint", "", "STS endpoint URL used with -iam_role (for LocalStack or other STS-compatible services)")
+		negativeTTL   = flag.Duration("negative_cache_ttl", 0, "How long to remember paths that do not exist (0 disables negative caching)")
+		sseMode       = flag.String("sse", "", "Server-side encryption for new objects: s3 (SSE-S3), kms (SSE-KMS) or c (SSE-C)")
+		sseKMSKeyID   = flag.String("sse_kms_key_id", "", "KMS key ID or ARN for -sse kms (default: AWS managed key)")
+		sseCKey       = flag.String("sse_c_key", "", "32-byte customer key for -sse c, raw or base64-encoded")
+		recursiveRmdir = flag.Bool("recursive_rmdir", false, "Let rmdir remove non-empty directories and everything under them using batch deletes")
+		rmdirFlush    = flag.Bool("rmdir_flush", false, "Make rmdir upload the buffered writes of files in the directory and decide from storage, instead of failing with ENOTEMPTY while any are buffered")
+		multipartCopySize = flag.Int64("multipart_copy_size", 5120, "Copy objects larger than this many MB with multipart copy when renaming (5-5120)")
+		multipartThreshold = flag.Int64("multipart_threshold", 5, "Upload files of at least this many MB in parts (5-5120)")
+		partSize      = flag.Int64("part_size", 5, "Size in MB of the parts of multipart uploads and copies (5-5120); grown automatically for objects that would need more than 10,000 parts")
+		mpuCleanupAge = flag.Duration("mpu_cleanup_age", 0, "Abort unfinished multipart uploads in the bucket older than this, at mount and then periodically (0 disables cleanup)")
+		serveStale    = flag.Bool("serve_stale_on_error", false, "Serve cached attributes and file data, even if expired, when S3 is unreachable instead of failing with EIO")
+		strictDirs    = flag.Bool("strict_dirs", false, "Fail with ENOENT when creating a file or directory whose parent directory does not exist, instead of creating it implicitly")
+		listNonCurrent = flag.Bool("list_noncurrent", false, "In a versioned bucket, also list files that only have non-current versions left")
+		listDeleteMarkers = flag.Bool("list_delete_markers", false, "In a versioned bucket, also list files that were deleted (their current version is a delete marker); they can't be read, see -show_versions")
+		showVersions  = flag.Bool("show_versions", false, "Make the earlier versions of each file, deleted files included, readable under DIR/.versions/NAME/VERSION_ID in a versioned bucket")
+		caseInsensitive = flag.Bool("case_insensitive", false, "Treat names differing only in case as the same object, for S3-compatible stores that ignore case; case-only renames then go through a temporary name")
+		dirMarker     = flag.String("dir_marker", "slash", "Kind of marker object new directories get: slash (\"dir/\", as C++ s3fs) or keep (\"dir/.keep\", as earlier versions); both are always recognized")
+		showMarkers   = flag.Bool("show_dir_markers", false, "List the \".keep\" directory markers of earlier versions as files, for debugging")
+		migrateDirs   = flag.Bool("migrate_dir_markers", false, "At mount, replace the \"dir/.keep\" directory markers created by earlier versions with \"dir/\" markers, keeping their metadata")
+		readAhead     = flag.Int64("readahead", 0, "Prefetch this many MB ahead of sequential reads of a file (0 disables readahead)")
+		statPrimeSize = flag.Int64("stat_prime_size", 0, "Fetch the content of files of up to this many KB when they are statted, so reading them next costs no further request (0 disables priming)")
+		pageCacheSize = flag.Int64("page_cache_size", 0, "Most MB of file data to keep in memory across all open files; least recently used clean pages are evicted first (0 = unlimited)")
+		maxCachedPages = flag.Int("max_cached_pages", cache.DefaultMaxPages, "Most pages (4 KB each) of file data to keep in memory per open file; pages with writes not yet uploaded are kept beyond it")
+		writeBack     = flag.Bool("write_back", false, "Buffer writes and upload them in the background instead of during each write; close and fsync still upload")
+		flushInterval = flag.Duration("flush_interval", fuse.DefaultFlushInterval, "How often -write_back uploads buffered data")
+		flushOnEvict  = flag.Bool("flush_on_evict", false, "Upload the buffered writes of files no longer open when the file cache is full, so they can be evicted")
+		cacheDir      = flag.String("cache_dir", "", "Keep a copy of each file read or written in this directory, reused while the object's ETag is unchanged (default: disabled)")
+		cacheMaxSize  = flag.Int64("cache_max_size", 1024, "Most MB of file data to keep in -cache_dir; least recently used files are deleted first")
+		trackUsage    = flag.Bool("track_usage", false, "Keep count of the bytes stored, reported by df and the metrics, starting from a scan of the bucket in the background; changes made by other clients are not counted")
+		quota         = flag.Int64("quota", 0, "Most MB the bucket may hold; writes that would exceed it fail with ENOSPC (0 = unlimited; implies -track_usage)")
+		attrTimeout   = flag.Duration("attr_cache_timeout", fuse.DefaultAttrCacheTimeout, "How long the kernel may cache file attributes before asking again (0 disables kernel attribute caching)")
+		entryTimeout  = flag.Duration("entry_cache_timeout", fuse.DefaultEntryCacheTimeout, "How long the kernel may cache name lookups before asking again (0 disables kernel lookup caching)")
+		dedupContent  = flag.Bool("dedup", false, "Store files with identical content once, under .s3fs-blobs/, with each path pointing at its content")
+		encryptionKey = flag.String("encryption_key_file", "", "Encrypt file contents client-side with AES-256-GCM under the master key in this file: 32 raw bytes or 64 hex digits (default: disabled)")
+		compression   = flag.String("compress", "", "Compress file contents with gzip or zstd before storing them; ranged reads of compressed files fetch the whole object (default: disabled)")
+		storageClass  = flag.String("storage_class", "", "Storage class for new objects, e.g. STANDARD_IA, INTELLIGENT_TIERING or GLACIER_IR (default: bucket default)")
+		contentType   = flag.Bool("detect_content_type", true, "Upload files with a Content-Type guessed from the file extension or content (S3 backend only)")
+		checksum      = flag.String("checksum", "", "Send a crc32c or sha256 checksum with uploads and verify it on whole-object downloads (default: disabled)")
+		logLevel      = flag.String("log_level", "info", "Log verbosity: debug, info, warn, error or off (debug logs every S3 request and cache eviction)")
+		backendType   = flag.String("backend", "s3", "Storage backend: s3, or local to keep files in the -local_root directory")
+		localRoot     = flag.String("local_root", "", "Directory holding the files for -backend local")
+		metricsAddr   = flag.String("metrics_addr", "", "Serve Prometheus metrics at http://<addr>/metrics, e.g. localhost:9100 (default: metrics disabled)")
 	)
+	var pathStyle optionalBool
+	flag.Var(&pathStyle, "path_style", "Address the bucket in the request path instead of the host name; -path_style=false forces virtual-host requests (default: path style only for localhost and IP address endpoints)")
 	flag.Parse()
 
-	if *bucket == "" {
-		log.Fatal("bucket is required")
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Invalid log_level: %v", err)
 	}
+	logging.SetOutput(os.Stderr, level)
+
 	if *mountpoint == "" {
 		log.Fatal("mountpoint is required")
 	}
 
+	defaultFileMode, err := parseMode(*fileMode)
+	if err != nil {
+		log.Fatalf("Invalid file_mode: %v", err)
+	}
+	defaultDirMode, err := parseMode(*dirMode)
+	if err != nil {
+		log.Fatalf("Invalid dir_mode: %v", err)
+	}
+	umaskMode, err := parseMode(*umask)
+	if err != nil {
+		log.Fatalf("Invalid umask: %v", err)
+	}
+	forbiddenMode, err := parseModeBits(*forbidMode)
+	if err != nil {
+		log.Fatalf("Invalid forbid_mode: %v", err)
+	}
+	compressAlgorithm, err := compress.ParseAlgorithm(*compression)
+	if err != nil {
+		log.Fatalf("Invalid compress: %v", err)
+	}
+	if name, path, ok := strings.Cut(*bucket, ":"); ok {
+		if *prefix != "" {
+			log.Fatal("Give the prefix in -bucket bucket:/path or with -prefix, not both")
+		}
+		*bucket, *prefix = name, path
+	}
+	mountPrefix, err := fuse.ParseBucketPrefix(*prefix)
+	if err != nil {
+		log.Fatalf("Invalid prefix: %v", err)
+	}
+	if mountPrefix != "" && *backendType != "s3" {
+		log.Fatal("prefix needs -backend s3")
+	}
+	if given := givenFlags(s3Flags); len(given) > 0 && *backendType != "s3" {
+		log.Fatalf("S3-only flags given with -backend %s: -%s", *backendType, strings.Join(given, ", -"))
+	}
+	dirMarkerStyle, err := fuse.ParseDirMarkerStyle(*dirMarker)
+	if err != nil {
+		log.Fatalf("Invalid dir_marker: %v", err)
+	}
+	if *migrateDirs && dirMarkerStyle == fuse.DirMarkerKeep {
+		log.Fatal("-migrate_dir_markers replaces \"dir/.keep\" markers and can't be used with -dir_marker=keep")
+	}
+	var masterKey []byte
+	if *encryptionKey != "" {
+		if masterKey, err = encrypt.LoadKeyFile(*encryptionKey); err != nil {
+			log.Fatalf("Invalid encryption_key_file: %v", err)
+		}
+	}
+	atimeMode := fuse.AtimeOff
+	switch {
+	case *strictAtime && (*noAtime || *relAtime), *noAtime && *relAtime:
+		log.Fatalf("Only one of -atime, -noatime and -relatime may be given")
+	case *strictAtime:
+		atimeMode = fuse.AtimeStrict
+	case *relAtime:
+		atimeMode = fuse.AtimeRelative
+	}
+
+	if *multipartCopySize < 5 || *multipartCopySize > 5120 {
+		log.Fatal("multipart_copy_size must be between 5 and 5120 MB")
+	}
+	if *readAhead < 0 {
+		log.Fatal("readahead must not be negative")
+	}
+	if *statPrimeSize < 0 {
+		log.Fatal("stat_prime_size must not be negative")
+	}
+	if *maxCachedPages <= 0 {
+		log.Fatal("max_cached_pages must be positive")
+	}
+	if *pageCacheSize < 0 {
+		log.Fatal("page_cache_size must not be negative")
+	}
+	var snapshotAt time.Time
+	if *snapshotTime != "" {
+		if snapshotAt, err = time.Parse(time.RFC3339, *snapshotTime); err != nil {
+			log.Fatalf("Invalid snapshot_time: %v", err)
+		}
+		if snapshotAt.After(time.Now()) {
+			log.Fatal("snapshot_time must not be in the future")
+		}
+		if *backendType != "s3" {
+			log.Fatal("snapshot_time needs -backend s3")
+		}
+		// A snapshot can't be written to
+		*readOnly = true
+	}
+	if *attrTimeout < 0 || *entryTimeout < 0 {
+		log.Fatal("attr_cache_timeout and entry_cache_timeout must not be negative")
+	}
+	if *cacheMaxSize <= 0 {
+		log.Fatal("cache_max_size must be positive")
+	}
+	if *quota < 0 {
+		log.Fatal("quota must not be negative")
+	}
+
+	// Mount options shared by all backends
+	options := fuse.MountOptions{
+		EnableFileLock:     *enableFileLock,
+		AllowOther:         *allowOther,
+		DefaultPermissions: *defaultPerms,
+		ReadOnly:           *readOnly,
+		Prefix:             mountPrefix,
+		DefaultFileMode:    defaultFileMode,
+		DefaultDirMode:     defaultDirMode,
+		Umask:              umaskMode,
+		ForbiddenMode:      forbiddenMode,
+		RejectForbidden:    *rejectMode,
+		NegativeCacheTTL:   *negativeTTL,
+		RecursiveRmdir:     *recursiveRmdir,
+		RmdirFlush:         *rmdirFlush,
+		MultipartCopySize:  *multipartCopySize * 1024 * 1024,
+		ServeStaleOnError:  *serveStale,
+		StrictDirs:         *strictDirs,
+		DirMarkerStyle:     dirMarkerStyle,
+		ShowDirMarkers:     *showMarkers,
+		MigrateDirMarkers:  *migrateDirs,
+		ShowVersions:       *showVersions,
+		ListNonCurrent:     *listNonCurrent,
+		ListDeleteMarkers:  *listDeleteMarkers,
+		CaseInsensitive:    *caseInsensitive,
+		ReadAheadSize:      *readAhead * 1024 * 1024,
+		StatPrimeSize:      *statPrimeSize * 1024,
+		PageCacheMemory:    *pageCacheSize * 1024 * 1024,
+		MaxCachedPages:     *maxCachedPages,
+		WriteBack:          *writeBack,
+		FlushInterval:      *flushInterval,
+		FlushOnEvict:       *flushOnEvict,
+		Dedup:              *dedupContent,
+		Compression:        compressAlgorithm,
+		Atime:              atimeMode,
+		EncryptionKey:      masterKey,
+		CacheDir:           *cacheDir,
+		CacheMaxSize:       *cacheMaxSize * 1024 * 1024,
+		TrackUsage:         *trackUsage,
+		Quota:              *quota * 1024 * 1024,
+		AttrCacheTimeout:   attrTimeout,
+		EntryCacheTimeout:  entryTimeout,
+	}
+	if *forceUID >= 0 {
+		uid := uint32(*forceUID)
+		options.ForceUID = &uid
+	}
+	if *forceGID >= 0 {
+		gid := uint32(*forceGID)
+		options.ForceGID = &gid
+	}
+	if *enableFileLock {
+		fmt.Println("File-level advisory locking enabled")
+	}
+	if *readOnly {
+		fmt.Println("Mounting read-only")
+	}
+	if *recursiveRmdir {
+		fmt.Println("Recursive rmdir enabled: removing a directory deletes its contents")
+	}
+	if *cacheDir != "" {
+		fmt.Printf("Caching file data in %s (up to %d MB)\n", *cacheDir, *cacheMaxSize)
+	}
+	if *quota > 0 {
+		fmt.Printf("Enforcing a quota of %d MB\n", *quota)
+	} else if *trackUsage {
+		fmt.Println("Tracking bucket usage")
+	}
+	if *dedupContent {
+		fmt.Println("Content deduplication enabled: identical files are stored once")
+	}
+	if masterKey != nil {
+		fmt.Println("Client-side encryption enabled")
+	}
+	if compressAlgorithm != "" {
+		fmt.Printf("Compressing file contents with %s\n", compressAlgorithm)
+	}
+
+	if *metricsAddr != "" {
+		if _, err := metrics.Serve(*metricsAddr); err != nil {
+			log.Fatalf("Failed to start metrics server: %v", err)
+		}
+		fmt.Printf("Serving metrics at http://%s/metrics\n", *metricsAddr)
+	}
+
+	switch *backendType {
+	case "s3":
+	case "local":
+		mountLocal(*localRoot, *mountpoint, options)
+		return
+	default:
+		log.Fatalf("Unknown backend %q (want s3 or local)", *backendType)
+	}
+
+	if *bucket == "" {
+		log.Fatal("bucket is required")
+	}
+
 	// Load credentials
 	creds := credentials.NewCredentials()
 	
 	if *passwdFile != "" {
-		if err := creds.LoadFromPasswdFile(*passwdFile); err != nil {
+		if err := creds.LoadFromPasswdFileForBucket(*passwdFile, *bucket); err != nil {
 			log.Fatalf("Failed to load credentials from file: %v", err)
 		}
+	} else if *profile != "" {
+		if err := creds.LoadFromSharedConfig(*profile); err != nil {
+			log.Fatalf("Failed to load credentials from profile: %v", err)
+		}
 	} else {
-		if err := creds.LoadFromEnvironment(); err != nil {
-			log.Fatalf("Failed to load credentials from environment: %v", err)
+		if err := creds.LoadDefault(context.Background()); err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
 		}
 	}
+	fmt.Printf("Using credentials from: %s\n", creds.Source)
 
-	if !creds.IsValid() {
-		log.Fatal("Invalid credentials")
+	if err := creds.Validate(); err != nil {
+		log.Fatalf("Invalid credentials: %v", err)
+	}
+
+	if *retries < 0 {
+		log.Fatal("retries must not be negative")
+	}
+
+	sseOptions, err := parseSSEOptions(*sseMode, *sseKMSKeyID, *sseCKey)
+	if err != nil {
+		log.Fatalf("Invalid SSE options: %v", err)
+	}
+	objectClass, err := s3client.ParseStorageClass(*storageClass)
+	if err != nil {
+		log.Fatalf("Invalid storage_class: %v", err)
+	}
+	checksumAlgorithm, err := s3client.ParseChecksumAlgorithm(*checksum)
+	if err != nil {
+		log.Fatalf("Invalid checksum: %v", err)
 	}
 
 	// Create S3 client
 	var client *s3client.Client
-	if *endpoint != "" {
+	if *iamRole != "" {
+		provider, err := credentials.NewAssumeRoleProvider(creds.Provider(), credentials.AssumeRoleOptions{
+			RoleARN:    *iamRole,
+			ExternalID: *iamExternalID,
+			Region:     *region,
+			Endpoint:   *stsEndpoint,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure IAM role: %v", err)
+		}
+		client = s3client.NewClientWithProvider(*bucket, *region, *endpoint, provider)
+		fmt.Printf("Assuming IAM role: %s\n", *iamRole)
+	} else if *endpoint != "" {
 		client = s3client.NewClientWithEndpoint(*bucket, *region, *endpoint, creds)
-		fmt.Printf("Using endpoint: %s\n", *endpoint)
 	} else {
 		client = s3client.NewClient(*bucket, *region, creds)
 	}
+	if *endpoint != "" {
+		fmt.Printf("Using endpoint: %s\n", *endpoint)
+	}
+	if pathStyle.set {
+		client.SetPathStyle(pathStyle.value)
+	}
 
-	// Mount filesystem with options
-	options := fuse.MountOptions{
-		EnableFileLock: *enableFileLock,
+	retryPolicy := s3client.DefaultRetryPolicy()
+	retryPolicy.MaxRetries = *retries
+	retryPolicy.MaxDelay = *retryMaxDelay
+	retryPolicy.MaxResumes = *resumes
+	retryPolicy.Timeout = *requestTimeout
+	retryPolicy.MultipartTimeout = *multipartTimeout
+	client.SetRetryPolicy(retryPolicy)
+	transportOptions := s3client.TransportOptions{
+		MaxIdleConns:        *maxIdleConns,
+		MaxIdleConnsPerHost: *maxIdleConnsHost,
+		IdleConnTimeout:     *idleConnTimeout,
 	}
-	fmt.Printf("Mounting bucket %s to %s\n", *bucket, *mountpoint)
-	if *enableFileLock {
-		fmt.Println("File-level advisory locking enabled")
+	if err := client.SetTransportOptions(transportOptions); err != nil {
+		log.Fatalf("Invalid connection pool options: %v", err)
+	}
+	if err := client.SetSSEOptions(sseOptions); err != nil {
+		log.Fatalf("Invalid SSE options: %v", err)
+	}
+	if sseOptions.Mode != s3client.SSENone {
+		fmt.Printf("Server-side encryption: %s\n", sseOptions.Mode)
+	}
+	client.SetStorageClass(objectClass)
+	if objectClass != "" {
+		fmt.Printf("Storage class for new objects: %s\n", objectClass)
+	}
+	client.SetChecksumAlgorithm(checksumAlgorithm)
+	if err := client.SetMultipartThreshold(*multipartThreshold * 1024 * 1024); err != nil {
+		log.Fatalf("Invalid multipart_threshold: %v", err)
+	}
+	if err := client.SetPartSize(*partSize * 1024 * 1024); err != nil {
+		log.Fatalf("Invalid part_size: %v", err)
+	}
+	if !snapshotAt.IsZero() {
+		versioned, err := client.Versioned(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to check bucket versioning for snapshot_time: %v", err)
+		}
+		if !versioned {
+			log.Fatalf("snapshot_time needs a versioned bucket, and %s has never had versioning enabled", *bucket)
+		}
+		client.SetSnapshotTime(snapshotAt)
+		fmt.Printf("Mounting the bucket as it was at %s\n", snapshotAt.Format(time.RFC3339))
+	}
+	if *mpuCleanupAge < 0 {
+		log.Fatalf("Invalid mpu_cleanup_age: %v is negative", *mpuCleanupAge)
+	}
+	if *mpuCleanupAge > 0 && !*readOnly {
+		fmt.Printf("Aborting multipart uploads older than %v\n", *mpuCleanupAge)
+		client.StartUploadReaper(context.Background(), mountPrefix, *mpuCleanupAge)
+	}
+
+	options.KeepOctetStream = !*contentType
+	if *metricsAddr != "" {
+		metrics.Handle("/uploads", client.ActiveUploadsHandler())
+	}
+
+	if mountPrefix != "" {
+		fmt.Printf("Mounting %s of bucket %s to %s\n", mountPrefix, *bucket, *mountpoint)
+	} else {
+		fmt.Printf("Mounting bucket %s to %s\n", *bucket, *mountpoint)
 	}
 	if err := fuse.MountWithOptions(*mountpoint, client, options); err != nil {
 		log.Fatalf("Failed to mount filesystem: %v", err)
 	}
 }
+
+// mountLocal mounts a directory-backed filesystem rooted at root
+func mountLocal(root, mountpoint string, options fuse.MountOptions) {
+	if root == "" {
+		log.Fatal("local_root is required with -backend local")
+	}
+	backend, err := localfs.NewLocalBackend(root)
+	if err != nil {
+		log.Fatalf("Failed to open local backend: %v", err)
+	}
+	// Content types only matter to objects served straight from S3
+	options.KeepOctetStream = true
+	fmt.Printf("Mounting local directory %s to %s\n", root, mountpoint)
+	if err := fuse.MountBackendWithOptions(mountpoint, backend, options); err != nil {
+		log.Fatalf("Failed to mount filesystem: %v", err)
+	}
+}
+
+// s3Flags are the flags that only configure how S3 is reached and what its
+// objects are stored with, which other backends would silently ignore
+var s3Flags = []string{
+	"bucket", "region", "endpoint", "passwd_file", "profile", "path_style",
+	"iam_role", "iam_role_external_id", "sts_endpoint",
+	"retries", "retry_max_delay", "download_resumes", "request_timeout", "multipart_timeout",
+	"max_idle_conns", "max_idle_conns_per_host", "idle_conn_timeout",
+	"sse", "sse_kms_key_id", "sse_c_key", "storage_class", "detect_content_type", "checksum",
+	"multipart_copy_size", "multipart_threshold", "part_size", "mpu_cleanup_age",
+	"list_noncurrent", "list_delete_markers",
+}
+
+// givenFlags returns those of names that were set on the command line
+func givenFlags(names []string) []string {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var given []string
+	flag.Visit(func(f *flag.Flag) {
+		if wanted[f.Name] {
+			given = append(given, f.Name)
+		}
+	})
+	return given
+}
+
+// optionalBool is a boolean flag that records whether it was given, for
+// options whose default depends on other settings
+type optionalBool struct {
+	set   bool
+	value bool
+}
+
+func (b *optionalBool) String() string {
+	if !b.set {
+		return "auto"
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func (b *optionalBool) Set(value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	b.set, b.value = true, v
+	return nil
+}
+
+// IsBoolFlag lets the flag be given without a value, like flag.Bool
+func (b *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+// parseMode parses an octal permission string such as "0644"
+func parseMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("mode %s has bits outside 0777", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// parseModeBits parses an octal mode string such as "6002" that may include
+// the setuid (04000), setgid (02000) and sticky (01000) bits
+func parseModeBits(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	bits, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if bits > 07777 {
+		return 0, fmt.Errorf("mode %s has bits outside 07777", value)
+	}
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// parseSSEOptions builds SSE options from the -sse flags
+// A KMS key ID or customer key given without -sse implies the matching mode
+func parseSSEOptions(mode, kmsKeyID, customerKey string) (s3client.SSEOptions, error) {
+	sseMode, err := s3client.ParseSSEMode(mode)
+	if err != nil {
+		return s3client.SSEOptions{}, err
+	}
+	if sseMode == s3client.SSENone {
+		switch {
+		case kmsKeyID != "" && customerKey != "":
+			return s3client.SSEOptions{}, fmt.Errorf("sse_kms_key_id and sse_c_key are mutually exclusive")
+		case kmsKeyID != "":
+			sseMode = s3client.SSEKMS
+		case customerKey != "":
+			sseMode = s3client.SSEC
+		}
+	}
+	if kmsKeyID != "" && sseMode != s3client.SSEKMS {
+		return s3client.SSEOptions{}, fmt.Errorf("sse_kms_key_id requires -sse kms")
+	}
+	if customerKey != "" && sseMode != s3client.SSEC {
+		return s3client.SSEOptions{}, fmt.Errorf("sse_c_key requires -sse c")
+	}
+	if sseMode == s3client.SSEC && customerKey == "" {
+		return s3client.SSEOptions{}, fmt.Errorf("-sse c requires sse_c_key")
+	}
+	return s3client.SSEOptions{
+		Mode:        sseMode,
+		KMSKeyID:    kmsKeyID,
+		CustomerKey: customerKey,
+	}, nil
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+)
+
+// DefaultDiskCacheSize is the disk cache size limit used when none is configured
+const DefaultDiskCacheSize int64 = 1024 * 1024 * 1024
+
+// DiskCache keeps whole copies of files in a local directory, so a file read
+// again after its pages were evicted, or after a remount, is not downloaded again
+//
+// Each file is stored under a name derived from its path, with a JSON sidecar
+// recording the path and the ETag of the content it holds. A copy only serves
+// reads while the file's ETag is unchanged, so files modified by other clients
+// are fetched again. When the copies exceed maxSize the least recently used
+// are deleted, whole
+type DiskCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	used    int64 // Bytes of file data held
+	entries map[string]*diskCacheEntry
+}
+
+// diskCacheEntry is a file held in the disk cache; the JSON form is its sidecar
+type diskCacheEntry struct {
+	Path     string `json:"path"`
+	ETag     string `json:"etag"`
+	Size     int64  `json:"size"`
+	lastUsed time.Time
+}
+
+// NewDiskCache opens the disk cache in dir, creating dir if needed, and picks up
+// the files a previous mount left there. A maxSize of 0 uses DefaultDiskCacheSize
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultDiskCacheSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory %s: %w", dir, err)
+	}
+	dc := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*diskCacheEntry),
+	}
+	if err := dc.load(); err != nil {
+		return nil, err
+	}
+	dc.mu.Lock()
+	dc.evict("")
+	dc.mu.Unlock()
+	return dc, nil
+}
+
+// load indexes the cached files in dir, deleting leftovers that are incomplete
+func (dc *DiskCache) load() error {
+	names, err := os.ReadDir(dc.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory %s: %w", dc.dir, err)
+	}
+	for _, dirEntry := range names {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			os.Remove(filepath.Join(dc.dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".json")
+		entry, err := dc.readSidecar(base)
+		if err != nil || dc.fileName(entry.Path) != base {
+			logging.Debug("disk cache dropped unreadable entry", "file", name, "err", err)
+			dc.removeFiles(base)
+			continue
+		}
+		dc.entries[entry.Path] = entry
+		dc.used += entry.Size
+	}
+	return nil
+}
+
+// readSidecar reads the sidecar of the cache file base and checks the data
+// file next to it is complete
+func (dc *DiskCache) readSidecar(base string) (*diskCacheEntry, error) {
+	raw, err := os.ReadFile(filepath.Join(dc.dir, base+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filepath.Join(dc.dir, base))
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != entry.Size {
+		return nil, fmt.Errorf("data file holds %d bytes, sidecar says %d", info.Size(), entry.Size)
+	}
+	entry.lastUsed = info.ModTime()
+	return &entry, nil
+}
+
+// fileName returns the name the copy of path is stored under
+func (dc *DiskCache) fileName(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+// removeFiles deletes the data file and sidecar named base
+func (dc *DiskCache) removeFiles(base string) {
+	os.Remove(filepath.Join(dc.dir, base))
+	os.Remove(filepath.Join(dc.dir, base+".json"))
+}
+
+// MaxSize returns the most bytes of file data the cache holds
+func (dc *DiskCache) MaxSize() int64 {
+	return dc.maxSize
+}
+
+// Size returns the bytes of file data held
+func (dc *DiskCache) Size() int64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.used
+}
+
+// ReadAt reads size bytes at offset from the cached copy of path, if it holds the
+// content with the given ETag; size 0 reads to the end of the file. A copy of
+// other content is deleted
+func (dc *DiskCache) ReadAt(path, etag string, offset, size int64) ([]byte, bool) {
+	dc.mu.Lock()
+	entry, found := dc.entries[path]
+	if found && entry.ETag != etag {
+		dc.remove(path)
+		found = false
+	}
+	if !found || etag == "" {
+		dc.mu.Unlock()
+		return nil, false
+	}
+	entry.lastUsed = time.Now()
+	fileSize := entry.Size
+	name := filepath.Join(dc.dir, dc.fileName(path))
+	dc.mu.Unlock()
+
+	if offset >= fileSize {
+		return []byte{}, true
+	}
+	if size <= 0 || offset+size > fileSize {
+		size = fileSize - offset
+	}
+	// The file may be replaced or evicted meanwhile; an open file stays readable,
+	// and a missing one is just a miss
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, false
+	}
+	return data, true
+}
+
+// Store saves data as the copy of path with the given ETag, replacing any older
+// copy. Content too large for the cache, or without an ETag, is not kept
+func (dc *DiskCache) Store(path, etag string, data []byte) error {
+	return dc.StoreFrom(path, etag, int64(len(data)), bytes.NewReader(data))
+}
+
+// StoreFrom is Store for content of the given size read from r, which is
+// copied to disk without being held in memory. Fails, keeping no copy, if r
+// doesn't yield exactly size bytes
+func (dc *DiskCache) StoreFrom(path, etag string, size int64, r io.Reader) error {
+	if etag == "" || size > dc.maxSize {
+		dc.Remove(path)
+		return nil
+	}
+
+	// Write both files aside and rename them into place, so a crash never
+	// leaves a sidecar describing a partial data file
+	sidecar, err := json.Marshal(&diskCacheEntry{Path: path, ETag: etag, Size: size})
+	if err != nil {
+		return err
+	}
+	dataTmp, err := dc.writeTemp(r, size)
+	if err != nil {
+		return err
+	}
+	sidecarTmp, err := dc.writeTemp(bytes.NewReader(sidecar), int64(len(sidecar)))
+	if err != nil {
+		os.Remove(dataTmp)
+		return err
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.remove(path)
+	base := filepath.Join(dc.dir, dc.fileName(path))
+	if err := os.Rename(dataTmp, base); err != nil {
+		os.Remove(dataTmp)
+		os.Remove(sidecarTmp)
+		return fmt.Errorf("failed to store cache file for %s: %w", path, err)
+	}
+	if err := os.Rename(sidecarTmp, base+".json"); err != nil {
+		os.Remove(base)
+		os.Remove(sidecarTmp)
+		return fmt.Errorf("failed to store cache file for %s: %w", path, err)
+	}
+	dc.entries[path] = &diskCacheEntry{Path: path, ETag: etag, Size: size, lastUsed: time.Now()}
+	dc.used += size
+	dc.evict(path)
+	return nil
+}
+
+// writeTemp copies size bytes from r to a new temporary file in the cache directory
+func (dc *DiskCache) writeTemp(r io.Reader, size int64) (string, error) {
+	file, err := os.CreateTemp(dc.dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache file: %w", err)
+	}
+	// One byte more than expected shows content longer than announced
+	written, err := io.Copy(file, io.LimitReader(r, size+1))
+	if err == nil && written != size {
+		err = fmt.Errorf("got %d bytes, expected %d", written, size)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// Remove deletes the cached copy of path, if any
+func (dc *DiskCache) Remove(path string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.remove(path)
+}
+
+// remove deletes the cached copy of path; the caller must hold dc.mu
+func (dc *DiskCache) remove(path string) {
+	entry, found := dc.entries[path]
+	if !found {
+		return
+	}
+	delete(dc.entries, path)
+	dc.used -= entry.Size
+	dc.removeFiles(dc.fileName(path))
+}
+
+// evict deletes the least recently used copies until the cache fits in maxSize,
+// sparing keep; the caller must hold dc.mu
+func (dc *DiskCache) evict(keep string) {
+	for dc.used > dc.maxSize {
+		var oldest *diskCacheEntry
+		for path, entry := range dc.entries {
+			if path != keep && (oldest == nil || entry.lastUsed.Before(oldest.lastUsed)) {
+				oldest = entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		logging.Debug("disk cache evicted file", "path", oldest.Path, "bytes", oldest.Size)
+		dc.remove(oldest.Path)
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskCache_StoreReadAt(t *testing.T) {
+	dc, err := NewDiskCache(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("NewDiskCache failed: %v", err)
+	}
+	if err := dc.Store("dir/file.txt", `"v1"`, []byte("0123456789")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	tests := []struct {
+		offset, size int64
+		want         string
+	}{
+		{0, 4, "0123"},
+		{6, 0, "6789"}, // Size 0 reads to the end
+		{8, 10, "89"},  // Clamped at the end
+		{12, 4, ""},    // Past the end
+	}
+	for _, tt := range tests {
+		data, found := dc.ReadAt("dir/file.txt", `"v1"`, tt.offset, tt.size)
+		if !found || string(data) != tt.want {
+			t.Errorf("ReadAt(%d, %d) = %q, %v; want %q", tt.offset, tt.size, data, found, tt.want)
+		}
+	}
+	if _, found := dc.ReadAt("other.txt", `"v1"`, 0, 4); found {
+		t.Error("ReadAt found a file that was never stored")
+	}
+}
+
+func TestDiskCache_ETagMismatch(t *testing.T) {
+	dc, err := NewDiskCache(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("NewDiskCache failed: %v", err)
+	}
+	dc.Store("file.txt", `"v1"`, []byte("old"))
+
+	if _, found := dc.ReadAt("file.txt", `"v2"`, 0, 0); found {
+		t.Fatal("ReadAt served a copy of different content")
+	}
+	// The stale copy is gone, even for the ETag it had
+	if _, found := dc.ReadAt("file.txt", `"v1"`, 0, 0); found {
+		t.Error("Stale copy survived a mismatch")
+	}
+	if dc.Size() != 0 {
+		t.Errorf("Size = %d after dropping the only copy, want 0", dc.Size())
+	}
+}
+
+func TestDiskCache_StoreFrom(t *testing.T) {
+	dir := t.TempDir()
+	dc, err := NewDiskCache(dir, 100)
+	if err != nil {
+		t.Fatalf("NewDiskCache failed: %v", err)
+	}
+	if err := dc.StoreFrom("file.txt", `"v1"`, 11, strings.NewReader("hello world")); err != nil {
+		t.Fatalf("StoreFrom failed: %v", err)
+	}
+	if data, found := dc.ReadAt("file.txt", `"v1"`, 6, 0); !found || string(data) != "world" {
+		t.Errorf("ReadAt = %q, %v; want %q", data, found, "world")
+	}
+
+	// Content shorter or longer than announced is not kept
+	for _, content := range []string{"short", "longer than eleven"} {
+		if err := dc.StoreFrom("other.txt", `"v1"`, 11, strings.NewReader(content)); err == nil {
+			t.Errorf("StoreFrom of %d bytes announced as 11 succeeded", len(content))
+		}
+		if _, found := dc.ReadAt("other.txt", `"v1"`, 0, 0); found {
+			t.Errorf("StoreFrom of %d bytes announced as 11 left a copy", len(content))
+		}
+	}
+	if dc.Size() != 11 {
+		t.Errorf("Size = %d, want 11", dc.Size())
+	}
+	// No temporary files are left behind
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".tmp-*")); len(matches) != 0 {
+		t.Errorf("Temporary files left: %v", matches)
+	}
+}
+
+func TestDiskCache_Eviction(t *testing.T) {
+	dc, err := NewDiskCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("NewDiskCache failed: %v", err)
+	}
+	dc.Store("a", `"a"`, []byte("aaaa"))
+	time.Sleep(time.Millisecond)
+	dc.Store("b", `"b"`, []byte("bbbb"))
+	time.Sleep(time.Millisecond)
+	dc.ReadAt("a", `"a"`, 0, 1) // a is now used more recently than b
+	time.Sleep(time.Millisecond)
+
+	dc.Store("c", `"c"`, []byte("cccc"))
+	if _, found := dc.ReadAt("b", `"b"`, 0, 0); found {
+		t.Error("Least recently used file survived eviction")
+	}
+	for _, path := range []string{"a", "c"} {
+		if _, found := dc.ReadAt(path, `"`+path+`"`, 0, 0); !found {
+			t.Errorf("File %s was evicted", path)
+		}
+	}
+	if dc.Size() != 8 {
+		t.Errorf("Size = %d, want 8", dc.Size())
+	}
+
+	// A file larger than the whole cache is not kept
+	dc.Store("big", `"big"`, make([]byte, 11))
+	if _, found := dc.ReadAt("big", `"big"`, 0, 0); found {
+		t.Error("File larger than the cache was stored")
+	}
+}
+
+func TestDiskCache_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	dc, err := NewDiskCache(dir, 100)
+	if err != nil {
+		t.Fatalf("NewDiskCache failed: %v", err)
+	}
+	dc.Store("kept.txt", `"v1"`, []byte("kept"))
+	dc.Store("cut.txt", `"v1"`, []byte("cut short"))
+
+	// A data file that doesn't match its sidecar is dropped on reopen
+	if err := os.Truncate(filepath.Join(dir, dc.fileName("cut.txt")), 3); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	dc, err = NewDiskCache(dir, 100)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	if data, found := dc.ReadAt("kept.txt", `"v1"`, 0, 0); !found || string(data) != "kept" {
+		t.Errorf("ReadAt after reopen = %q, %v; want %q", data, found, "kept")
+	}
+	if _, found := dc.ReadAt("cut.txt", `"v1"`, 0, 0); found {
+		t.Error("Incomplete file was picked up on reopen")
+	}
+	if dc.Size() != 4 {
+		t.Errorf("Size after reopen = %d, want 4", dc.Size())
+	}
+}
@@ -1,18 +1,31 @@
 package cache
 
 import (
+	"container/list"
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
+// DefaultPageSize is the page size used when a non-positive page size is configured
+const DefaultPageSize int64 = 4096
+
+// DefaultMaxPages is the default number of pages cached per entity
+// Dirty pages are never evicted, so an entity may exceed this until it is uploaded
+const DefaultMaxPages = 100
+
 // FdEntity represents a cached file descriptor entity
 type FdEntity struct {
 	mu            sync.RWMutex // Entity-level mutex (always used)
+	uploadMu      sync.Mutex   // Serializes uploads, so a newer snapshot is never overwritten by an older one
 	FileLock      sync.RWMutex // File-level advisory lock (optional, for stricter coordination)
 	path          string
 	file          *os.File
@@ -22,8 +35,22 @@ type FdEntity struct {
 	lastAccess    time.Time
 	pages         map[int64]*Page // Page cache: offset -> page data
 	pageSize      int64
+	maxPages      int            // Page cache limit (clean pages only are evicted)
 	bytesModified int64          // Total bytes modified but not yet uploaded
 	dirtyPages    map[int64]bool // Track which pages are dirty (not uploaded)
+	uploading     int            // Uploads in flight
+	uploads       uint64         // Uploads completed
+	sizeChanged   bool           // Size changed since the last upload (e.g. truncate with no data)
+	writeSeq      uint64         // Incremented by every page write and truncation
+	truncated     bool           // Cut since the last upload; stored bytes from truncatedTo on are stale
+	truncatedTo   int64          // Smallest size the file was cut to since the last upload
+	readAhead     readAheadState // Sequential read detection and prefetch (see readahead.go)
+	discarded     bool           // Dropped from the cache; buffered data is no longer uploaded
+	etag          string         // ETag of the stored version the entity is based on ("" = unknown)
+	stored        *types.Attr    // Attributes the entity's last upload stored (nil = unknown)
+	memory        int64          // Bytes of page data held (see fd_memory.go)
+	budget        *memoryBudget  // Manager's memory accounting; nil once dropped from the cache
+	primed        bool           // Cached by Prime and not opened since
 }
 
 // Page represents a cached page of file data
@@ -33,6 +60,40 @@ type Page struct {
 	Size       int64
 	Dirty      bool
 	LastAccess time.Time
+	seq        uint64        // writeSeq of the last write to the page
+	clean      *list.Element // Place in the manager's eviction order while clean
+	// written lists the parts of Data filled by writes to a page that was never
+	// loaded; nil means all of Data holds file content
+	written []pageRange
+}
+
+// pageRange is a half-open byte range within a page
+type pageRange struct {
+	start, end int64
+}
+
+// addPageRange merges [start, end) into the sorted, disjoint ranges
+func addPageRange(ranges []pageRange, start, end int64) []pageRange {
+	merged := make([]pageRange, 0, len(ranges)+1)
+	added := false
+	for _, r := range ranges {
+		switch {
+		case r.end < start:
+			merged = append(merged, r)
+		case r.start > end:
+			if !added {
+				merged = append(merged, pageRange{start, end})
+				added = true
+			}
+			merged = append(merged, r)
+		default:
+			start, end = min(start, r.start), max(end, r.end)
+		}
+	}
+	if !added {
+		merged = append(merged, pageRange{start, end})
+	}
+	return merged
 }
 
 // FdInfo contains metadata about a file descriptor
@@ -44,6 +105,9 @@ type FdInfo struct {
 	LastAccess time.Time
 }
 
+// FlushFunc uploads the buffered writes of the entity cached for path
+type FlushFunc func(path string, entity *FdEntity) error
+
 // FdCacheManager manages file descriptor cache
 type FdCacheManager struct {
 	mu            sync.RWMutex
@@ -51,19 +115,32 @@ type FdCacheManager struct {
 	maxSize       int
 	maxOpenFiles  int
 	pageSize      int64
+	maxPages      int
+	retainClosed  bool            // Keep clean entities after their last Close (see SetRetainClosed)
+	memory        *memoryBudget   // Page data held by all entities (see SetMaxMemory)
+	evictFlush    FlushFunc       // Uploads unused entities with buffered writes (see SetEvictFlush)
+	flushing      map[string]bool // Paths handed to evictFlush that it has not finished
 	cleanupTicker *time.Ticker
 	stopCleanup   chan struct{}
 }
 
 // NewFdCacheManager creates a new FD cache manager
 func NewFdCacheManager(maxSize int, maxOpenFiles int, pageSize int64) *FdCacheManager {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
 	fcm := &FdCacheManager{
 		entities:     make(map[string]*FdEntity),
 		maxSize:      maxSize,
 		maxOpenFiles: maxOpenFiles,
 		pageSize:     pageSize,
+		maxPages:     DefaultMaxPages,
 		stopCleanup:  make(chan struct{}),
+		memory:       &memoryBudget{},
+		flushing:     make(map[string]bool),
 	}
+	fcm.memory.evict = fcm.evictMemory
 
 	// Start cleanup goroutine
 	fcm.cleanupTicker = time.NewTicker(30 * time.Second)
@@ -80,10 +157,16 @@ func (fcm *FdCacheManager) Open(path string, size int64, mtime time.Time) (*FdEn
 	entity, exists := fcm.entities[path]
 	if exists {
 		entity.mu.Lock()
-		entity.refCount++
-		entity.lastAccess = time.Now()
+		if entity.refCount > 0 {
+			entity.refCount++
+			entity.lastAccess = time.Now()
+			entity.mu.Unlock()
+			return entity, nil
+		}
+		// Retained after close; its pages may be out of date, so start afresh
+		entity.discard()
 		entity.mu.Unlock()
-		return entity, nil
+		delete(fcm.entities, path)
 	}
 
 	// Check if we've reached max open files
@@ -91,21 +174,87 @@ func (fcm *FdCacheManager) Open(path string, size int64, mtime time.Time) (*FdEn
 		fcm.closeOldest()
 	}
 
-	// Create new entity
-	entity = &FdEntity{
+	entity = fcm.newEntity(path, size, mtime)
+	entity.refCount = 1
+	fcm.entities[path] = entity
+	return entity, nil
+}
+
+// newEntity creates an entity with no references and no pages
+// Callers hold fcm.mu
+func (fcm *FdCacheManager) newEntity(path string, size int64, mtime time.Time) *FdEntity {
+	return &FdEntity{
 		path:          path,
 		size:          size,
 		mtime:         mtime,
-		refCount:      1,
 		lastAccess:    time.Now(),
 		pages:         make(map[int64]*Page),
 		pageSize:      fcm.pageSize,
+		maxPages:      fcm.maxPages,
 		bytesModified: 0,
 		dirtyPages:    make(map[int64]bool),
+		budget:        fcm.memory,
 	}
+}
 
+// Prime caches data as the content of path, stored with the given ETag, for a
+// read expected soon. The entity holds no reference, so it is evicted like any
+// unused one; OpenPrimed takes it over. Open entities are left alone
+func (fcm *FdCacheManager) Prime(path string, size int64, mtime time.Time, etag string, data []byte) {
+	fcm.mu.Lock()
+	if existing, exists := fcm.entities[path]; exists {
+		existing.mu.Lock()
+		if existing.refCount > 0 {
+			existing.mu.Unlock()
+			fcm.mu.Unlock()
+			return
+		}
+		existing.discard()
+		existing.mu.Unlock()
+		delete(fcm.entities, path)
+	}
+	if len(fcm.entities) >= fcm.maxOpenFiles {
+		fcm.closeOldest()
+	}
+	entity := fcm.newEntity(path, size, mtime)
+	entity.etag = etag
+	entity.primed = true
 	fcm.entities[path] = entity
-	return entity, nil
+	fcm.mu.Unlock()
+
+	entity.LoadPages(data)
+}
+
+// OpenPrimed opens the entity Prime cached for path if no one has opened it
+// since and it holds the stored version with the given ETag
+func (fcm *FdCacheManager) OpenPrimed(path string, etag string) (*FdEntity, bool) {
+	fcm.mu.Lock()
+	defer fcm.mu.Unlock()
+
+	entity, exists := fcm.entities[path]
+	if !exists {
+		return nil, false
+	}
+	entity.mu.Lock()
+	defer entity.mu.Unlock()
+	if !entity.primed || entity.refCount > 0 || etag == "" || entity.etag != etag {
+		return nil, false
+	}
+	entity.primed = false
+	entity.refCount = 1
+	entity.lastAccess = time.Now()
+	return entity, true
+}
+
+// SetMaxPages sets how many pages each newly opened entity caches
+// Non-positive values restore DefaultMaxPages
+func (fcm *FdCacheManager) SetMaxPages(maxPages int) {
+	fcm.mu.Lock()
+	defer fcm.mu.Unlock()
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
+	fcm.maxPages = maxPages
 }
 
 // Get retrieves a cached entity without incrementing ref count
@@ -118,8 +267,8 @@ func (fcm *FdCacheManager) Get(path string) (*FdEntity, bool) {
 		return nil, false
 	}
 
-	entity.mu.RLock()
-	defer entity.mu.RUnlock()
+	entity.mu.Lock()
+	defer entity.mu.Unlock()
 	entity.lastAccess = time.Now()
 	return entity, true
 }
@@ -135,19 +284,30 @@ func (fcm *FdCacheManager) Close(path string) error {
 	}
 
 	entity.mu.Lock()
+	released := entity.refCount > 0
 	entity.refCount--
 	if entity.refCount <= 0 {
-		if entity.file != nil {
-			entity.file.Close()
-			entity.file = nil
+		entity.refCount = 0
+		if !released || !fcm.retainClosed || entity.bytesModified > 0 {
+			entity.discard()
+			delete(fcm.entities, path)
 		}
-		delete(fcm.entities, path)
 	}
 	entity.mu.Unlock()
 
 	return nil
 }
 
+// SetRetainClosed keeps clean entities in the cache after their last Close
+// Retained entities only serve reads that storage cannot answer: Open starts them
+// afresh, and they are evicted like any unreferenced entity. Closing a retained
+// entity again discards it
+func (fcm *FdCacheManager) SetRetainClosed(enable bool) {
+	fcm.mu.Lock()
+	defer fcm.mu.Unlock()
+	fcm.retainClosed = enable
+}
+
 // GetInfo returns information about a cached entity
 func (fcm *FdCacheManager) GetInfo(path string) (*FdInfo, bool) {
 	fcm.mu.RLock()
@@ -200,33 +360,92 @@ func (fcm *FdCacheManager) GetOpenFdCount(path string) int {
 	return entity.refCount
 }
 
-// closeOldest closes the oldest unused entity
+// SetEvictFlush sets how the open file limit deals with entities no handle
+// holds that still buffer writes. Eviction never drops them; with a flush set,
+// the oldest of them is handed to it in the background when no clean entity can
+// be evicted instead, and evicted once uploaded if the cache is still over its
+// limit. flush must upload the entity's buffered data (nil = keep such entities
+// until something else uploads them)
+func (fcm *FdCacheManager) SetEvictFlush(flush FlushFunc) {
+	fcm.mu.Lock()
+	defer fcm.mu.Unlock()
+	fcm.evictFlush = flush
+}
+
+// idle reports whether the entity can be evicted without losing writes
+// Callers hold the entity lock
+func (fe *FdEntity) idle() bool {
+	return fe.refCount == 0 && fe.bytesModified == 0 && fe.uploading == 0
+}
+
+// closeOldest closes the oldest unused entity, skipping those with buffered
+// writes (see SetEvictFlush)
 func (fcm *FdCacheManager) closeOldest() {
-	var oldestPath string
-	var oldestTime time.Time
-	var oldestEntity *FdEntity
+	var oldestPath, dirtyPath string
+	var oldestTime, dirtyTime time.Time
+	var oldestEntity, dirtyEntity *FdEntity
 
 	for path, entity := range fcm.entities {
 		entity.mu.RLock()
-		if entity.refCount == 0 {
+		if entity.idle() {
 			if oldestPath == "" || entity.lastAccess.Before(oldestTime) {
 				oldestPath = path
 				oldestTime = entity.lastAccess
 				oldestEntity = entity
 			}
+		} else if entity.refCount == 0 && !fcm.flushing[path] {
+			if dirtyPath == "" || entity.lastAccess.Before(dirtyTime) {
+				dirtyPath = path
+				dirtyTime = entity.lastAccess
+				dirtyEntity = entity
+			}
 		}
 		entity.mu.RUnlock()
 	}
 
 	if oldestEntity != nil {
+		logging.Debug("fd cache evicting entity", "path", oldestPath)
 		oldestEntity.mu.Lock()
 		if oldestEntity.file != nil {
 			oldestEntity.file.Close()
 			oldestEntity.file = nil
 		}
+		oldestEntity.detach()
 		oldestEntity.mu.Unlock()
 		delete(fcm.entities, oldestPath)
+	} else if dirtyEntity != nil && fcm.evictFlush != nil {
+		logging.Debug("fd cache flushing entity for eviction", "path", dirtyPath)
+		fcm.flushing[dirtyPath] = true
+		go fcm.flushForEviction(fcm.evictFlush, dirtyPath, dirtyEntity)
+	}
+}
+
+// flushForEviction uploads the buffered writes of an unused entity, then evicts
+// it if it is still unused, fully uploaded and over the open file limit
+func (fcm *FdCacheManager) flushForEviction(flush FlushFunc, path string, entity *FdEntity) {
+	err := flush(path, entity)
+
+	fcm.mu.Lock()
+	defer fcm.mu.Unlock()
+	delete(fcm.flushing, path)
+	if err != nil {
+		logging.Warn("flush before fd cache eviction failed, keeping data buffered", "path", path, "err", err)
+		return
+	}
+	if fcm.entities[path] != entity || len(fcm.entities) <= fcm.maxOpenFiles {
+		return
+	}
+	entity.mu.Lock()
+	if entity.idle() {
+		logging.Debug("fd cache evicting entity", "path", path)
+		if entity.file != nil {
+			entity.file.Close()
+			entity.file = nil
+		}
+		entity.detach()
+		delete(fcm.entities, path)
 	}
+	entity.mu.Unlock()
 }
 
 // cleanupUnused periodically cleans up unused entities
@@ -240,13 +459,14 @@ func (fcm *FdCacheManager) cleanupUnused() {
 
 			for path, entity := range fcm.entities {
 				entity.mu.RLock()
-				if entity.refCount == 0 && now.Sub(entity.lastAccess) > expired {
+				if entity.idle() && now.Sub(entity.lastAccess) > expired {
 					entity.mu.RUnlock()
 					entity.mu.Lock()
 					if entity.file != nil {
 						entity.file.Close()
 						entity.file = nil
 					}
+					entity.detach()
 					entity.mu.Unlock()
 					delete(fcm.entities, path)
 				} else {
@@ -272,15 +492,49 @@ func (fcm *FdCacheManager) CloseAll() {
 	defer fcm.mu.Unlock()
 	for _, entity := range fcm.entities {
 		entity.mu.Lock()
-		if entity.file != nil {
-			entity.file.Close()
-			entity.file = nil
-		}
+		entity.discard()
 		entity.mu.Unlock()
 	}
 	fcm.entities = make(map[string]*FdEntity)
 }
 
+// DropPrefix discards every entity under prefix, including unflushed data and
+// entities still referenced by open handles (used when the tree is deleted)
+func (fcm *FdCacheManager) DropPrefix(prefix string) {
+	fcm.mu.Lock()
+	defer fcm.mu.Unlock()
+
+	for path, entity := range fcm.entities {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		entity.mu.Lock()
+		entity.discard()
+		entity.mu.Unlock()
+		delete(fcm.entities, path)
+	}
+}
+
+// Discard drops the entity for path however many handles hold it, for a file
+// that no longer exists, and waits for an upload of it in flight to finish
+// Its buffered data is never uploaded; the next Open starts a new entity
+func (fcm *FdCacheManager) Discard(path string) {
+	fcm.mu.Lock()
+	entity, exists := fcm.entities[path]
+	if !exists {
+		fcm.mu.Unlock()
+		return
+	}
+	entity.mu.Lock()
+	entity.discard()
+	entity.mu.Unlock()
+	delete(fcm.entities, path)
+	fcm.mu.Unlock()
+
+	entity.uploadMu.Lock()
+	entity.uploadMu.Unlock()
+}
+
 // GetBufferedPaths returns all paths that have buffered data
 func (fcm *FdCacheManager) GetBufferedPaths(prefix string) []string {
 	fcm.mu.RLock()
@@ -295,12 +549,24 @@ func (fcm *FdCacheManager) GetBufferedPaths(prefix string) []string {
 	return paths
 }
 
+// discard marks the entity as dropped from the cache and closes its temp file;
+// the caller must hold fe.mu
+func (fe *FdEntity) discard() {
+	fe.discarded = true
+	fe.detach()
+	if fe.file != nil {
+		fe.file.Close()
+		fe.file = nil
+	}
+}
+
 // ReadPage reads a page from cache or returns nil if not cached
 func (fe *FdEntity) ReadPage(offset int64) ([]byte, bool) {
 	fe.mu.RLock()
 	defer fe.mu.RUnlock()
 
-	pageOffset := (offset / fe.pageSize) * fe.pageSize
+	pageSize := fe.effectivePageSize()
+	pageOffset := (offset / pageSize) * pageSize
 	page, exists := fe.pages[pageOffset]
 	if !exists {
 		return nil, false
@@ -313,34 +579,48 @@ func (fe *FdEntity) ReadPage(offset int64) ([]byte, bool) {
 
 	// Return data starting from offset
 	pageStart := offset - pageOffset
-	page.LastAccess = time.Now()
+	fe.touch(page)
 	return page.Data[pageStart:], true
 }
 
-// WritePage writes a page to cache
+// WritePage writes data to the page cache, splitting it across as many pages as it spans
 func (fe *FdEntity) WritePage(offset int64, data []byte) {
+	defer fe.enforceMemory()
 	fe.mu.Lock()
 	defer fe.mu.Unlock()
 
-	pageOffset := (offset / fe.pageSize) * fe.pageSize
+	for len(data) > 0 {
+		n := fe.writeSinglePage(offset, data)
+		offset += n
+		data = data[n:]
+	}
+}
+
+// writeSinglePage writes the part of data that falls in the page containing offset
+// and returns the number of bytes written; the caller must hold fe.mu
+func (fe *FdEntity) writeSinglePage(offset int64, data []byte) int64 {
+	pageSize := fe.effectivePageSize()
+	pageOffset := (offset / pageSize) * pageSize
 	offsetInPage := offset - pageOffset
 	endOffset := offset + int64(len(data))
-	pageEndOffset := pageOffset + fe.pageSize
+	pageEndOffset := pageOffset + pageSize
 	if endOffset > pageEndOffset {
 		endOffset = pageEndOffset
 	}
 	pageDataSize := endOffset - pageOffset
 
-	// Truncate cache if needed (before adding new page)
-	if len(fe.pages) >= 100 { // Max 100 pages per entity
-		fe.evictOldestPage()
-	}
-
 	// Check if page already exists
 	existingPage, exists := fe.pages[pageOffset]
 	var pageData []byte
+	var written []pageRange
 
 	if exists {
+		switch {
+		case existingPage.written != nil:
+			written = addPageRange(existingPage.written, offsetInPage, pageDataSize)
+		case offsetInPage > int64(len(existingPage.Data)):
+			written = []pageRange{{0, int64(len(existingPage.Data))}, {offsetInPage, pageDataSize}}
+		}
 		// Merge with existing page data
 		existingSize := int64(len(existingPage.Data))
 		if existingSize < pageDataSize {
@@ -360,27 +640,155 @@ func (fe *FdEntity) WritePage(offset int64, data []byte) {
 	} else {
 		// Create new page data
 		pageData = make([]byte, pageDataSize)
+		written = []pageRange{{offsetInPage, pageDataSize}}
 	}
 
 	// Write new data into page at correct offset
 	copy(pageData[offsetInPage:], data)
+	if len(written) == 1 && written[0].start == 0 && written[0].end >= int64(len(pageData)) {
+		written = nil
+	}
 
+	fe.writeSeq++
 	page := &Page{
 		Offset:     pageOffset,
 		Data:       pageData,
 		Size:       int64(len(pageData)),
 		Dirty:      true,
 		LastAccess: time.Now(),
+		seq:        fe.writeSeq,
+		written:    written,
 	}
 
-	fe.pages[pageOffset] = page
+	fe.setPage(page)
 	fe.dirtyPages[pageOffset] = true
 	fe.bytesModified += page.Size
 
-	// If we're still over limit after adding, evict more
-	for len(fe.pages) > 100 {
-		fe.evictOldestPage()
+	fe.evictPages()
+
+	return endOffset - offset
+}
+
+// WriteAt writes data to the page cache and updates the size in the same critical
+// section, returning the size before the write. The file grows to the end of the
+// write if it was smaller; with truncate it is cut to the end of the write instead
+// Concurrent writers thus never shrink each other's extensions
+func (fe *FdEntity) WriteAt(offset int64, data []byte, truncate bool) int64 {
+	defer fe.enforceMemory()
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	for written := int64(0); written < int64(len(data)); {
+		written += fe.writeSinglePage(offset+written, data[written:])
+	}
+
+	oldSize := fe.size
+	end := offset + int64(len(data))
+	if truncate && end < fe.size {
+		fe.markTruncated(end)
+	}
+	if end > fe.size || (truncate && end != fe.size) {
+		fe.size = end
+		fe.sizeChanged = true
+	}
+	return oldSize
+}
+
+// LoadPages fills the page cache with clean data read from storage, starting at offset 0
+// Unlike WritePage, loaded pages are not dirty and are never uploaded
+func (fe *FdEntity) LoadPages(data []byte) {
+	fe.LoadPagesAt(0, data)
+}
+
+// LoadPagesAt caches clean data read from storage at offset
+// A leading partial page is skipped since pages always start at a page boundary
+func (fe *FdEntity) LoadPagesAt(offset int64, data []byte) {
+	defer fe.enforceMemory()
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.loadPagesAt(offset, data)
+}
+
+// loadPagesAt is LoadPagesAt for callers that hold fe.mu
+func (fe *FdEntity) loadPagesAt(offset int64, data []byte) {
+	pageSize := fe.effectivePageSize()
+	dataEnd := offset + int64(len(data))
+	first := ((offset + pageSize - 1) / pageSize) * pageSize
+	for pageOffset := first; pageOffset < dataEnd; pageOffset += pageSize {
+		// Never replace buffered writes
+		if existing, exists := fe.pages[pageOffset]; exists && existing.Dirty {
+			continue
+		}
+		end := pageOffset + pageSize
+		if end > dataEnd {
+			end = dataEnd
+		}
+		pageData := make([]byte, end-pageOffset)
+		copy(pageData, data[pageOffset-offset:end-offset])
+
+		fe.setPage(&Page{
+			Offset:     pageOffset,
+			Data:       pageData,
+			Size:       int64(len(pageData)),
+			LastAccess: time.Now(),
+		})
+	}
+
+	fe.evictPages()
+}
+
+// ReadCachedRange reads a range spanning any number of cached pages
+// Returns false if any part of the range (clamped to the entity size) is not cached
+func (fe *FdEntity) ReadCachedRange(offset int64, size int64) ([]byte, bool) {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+
+	end := offset + size
+	if size <= 0 || end > fe.size {
+		end = fe.size
+	}
+	if offset < 0 || offset >= end {
+		return nil, false
+	}
+
+	pageSize := fe.effectivePageSize()
+	data := make([]byte, 0, end-offset)
+	for pos := offset; pos < end; {
+		pageOffset := (pos / pageSize) * pageSize
+		page, exists := fe.pages[pageOffset]
+		if !exists {
+			return nil, false
+		}
+		start := pos - pageOffset
+		if start >= int64(len(page.Data)) {
+			return nil, false
+		}
+		n := int64(len(page.Data)) - start
+		if n > end-pos {
+			n = end - pos
+		}
+		data = append(data, page.Data[start:start+n]...)
+		fe.touch(page)
+		pos += n
+	}
+	return data, true
+}
+
+// effectivePageSize returns the entity page size, guarding against a zero or
+// negative value which would otherwise cause a divide-by-zero panic
+func (fe *FdEntity) effectivePageSize() int64 {
+	if fe.pageSize <= 0 {
+		return DefaultPageSize
 	}
+	return fe.pageSize
+}
+
+// effectiveMaxPages returns the page cache limit, defaulting for entities built without one
+func (fe *FdEntity) effectiveMaxPages() int {
+	if fe.maxPages <= 0 {
+		return DefaultMaxPages
+	}
+	return fe.maxPages
 }
 
 // BytesModified returns the number of bytes modified but not uploaded
@@ -402,6 +810,7 @@ func (fe *FdEntity) MarkPageClean(pageOffset int64) {
 			if fe.bytesModified < 0 {
 				fe.bytesModified = 0
 			}
+			fe.track(page)
 		}
 	}
 	delete(fe.dirtyPages, pageOffset)
@@ -419,81 +828,152 @@ func (fe *FdEntity) GetDirtyPages() []int64 {
 	return dirty
 }
 
-// ReadBufferedData reads data from buffered pages and cached file
+// ReadBufferedData reads size bytes at offset (0 = to the end of the file) as the
+// entity sees them, from the cached file and pages. It returns false if part of
+// the range is held by neither; ReadBufferedDataFrom reads such ranges
 func (fe *FdEntity) ReadBufferedData(offset int64, size int64) ([]byte, bool) {
 	fe.mu.RLock()
 	defer fe.mu.RUnlock()
 
-	entitySize := fe.size
-	if size == 0 {
-		size = entitySize - offset
-		if size <= 0 {
-			return []byte{}, true
-		}
+	start, end := fe.bufferedRange(offset, size)
+	if !fe.holds(start, end) {
+		return nil, false
 	}
+	return fe.readBuffered(start, end, nil), true
+}
 
-	// Create buffer
-	maxOffset := offset + size
-	if maxOffset > entitySize {
-		maxOffset = entitySize
-	}
-	if maxOffset <= offset {
-		return []byte{}, true
-	}
+// ReadBufferedDataFrom is ReadBufferedData for ranges the entity may not hold in
+// full: stored holds the stored object's bytes from offset and fills the parts
+// neither the cached file nor a page holds
+func (fe *FdEntity) ReadBufferedDataFrom(offset, size int64, stored []byte) []byte {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
 
-	bufferedData := make([]byte, maxOffset)
+	start, end := fe.bufferedRange(offset, size)
+	return fe.readBuffered(start, end, stored)
+}
 
-	// Read existing file if available
+// bufferedRange clamps a read of size bytes at offset (0 = to the end of the
+// file) to the entity's size; the caller must hold fe.mu
+func (fe *FdEntity) bufferedRange(offset, size int64) (int64, int64) {
+	end := fe.size
+	if size > 0 && offset+size < end {
+		end = offset + size
+	}
+	return offset, max(end, offset)
+}
+
+// readBuffered returns bytes [start, end) of the file: the cached file if there is
+// one, else stored (the stored bytes from start), overlaid with the cached pages.
+// Stored bytes past a truncation read as zeros. The caller must hold fe.mu
+func (fe *FdEntity) readBuffered(start, end int64, stored []byte) []byte {
+	data := make([]byte, end-start)
 	if fe.file != nil {
-		fe.file.Seek(0, 0)
-		existingData, _ := io.ReadAll(fe.file)
-		if len(existingData) > 0 {
-			copy(bufferedData, existingData)
+		fe.file.ReadAt(data, start)
+	} else if stored != nil {
+		if fe.truncated {
+			stored = stored[:min(int64(len(stored)), max(fe.truncatedTo-start, 0))]
 		}
+		copy(data, stored)
 	}
+	fe.overlayPages(data, start)
+	return data
+}
 
-	// Overlay dirty pages
-	for pageOffset := range fe.dirtyPages {
-		if page, exists := fe.pages[pageOffset]; exists {
-			end := pageOffset + page.Size
-			if end > int64(len(bufferedData)) {
-				end = int64(len(bufferedData))
-			}
-			if pageOffset < int64(len(bufferedData)) {
-				copy(bufferedData[pageOffset:end], page.Data[:end-pageOffset])
+// holds reports whether the cached file or pages hold every byte of [start, end);
+// bytes past a truncation are zeros and need no page. The caller must hold fe.mu
+func (fe *FdEntity) holds(start, end int64) bool {
+	if fe.file != nil {
+		return true
+	}
+	if fe.truncated {
+		end = min(end, fe.truncatedTo)
+	}
+	pageSize := fe.effectivePageSize()
+	for pos := start; pos < end; {
+		pageOffset := (pos / pageSize) * pageSize
+		page, exists := fe.pages[pageOffset]
+		if !exists {
+			return false
+		}
+		held := pageOffset + int64(len(page.Data))
+		if page.written != nil {
+			held = pos
+			for _, r := range page.written {
+				if pos >= pageOffset+r.start && pos < pageOffset+r.end {
+					held = pageOffset + r.end
+				}
 			}
 		}
+		if held <= pos {
+			return false
+		}
+		pos = held
 	}
+	return true
+}
 
-	// Return requested range
-	if offset < int64(len(bufferedData)) {
-		end := offset + size
-		if end > int64(len(bufferedData)) {
-			end = int64(len(bufferedData))
+// overlayPages copies what the cached pages hold over data, which holds the
+// file's bytes from base. Bytes of a partly written page that no write covered
+// are left as they are. The caller must hold fe.mu
+func (fe *FdEntity) overlayPages(data []byte, base int64) {
+	end := base + int64(len(data))
+	for offset, page := range fe.pages {
+		pageEnd := min(offset+int64(len(page.Data)), end)
+		if offset >= end || pageEnd <= base {
+			continue
 		}
-		if offset < end {
-			return bufferedData[offset:end], true
+		if page.written == nil {
+			from := max(offset, base)
+			copy(data[from-base:pageEnd-base], page.Data[from-offset:pageEnd-offset])
+			continue
+		}
+		for _, r := range page.written {
+			if from, to := max(offset+r.start, base), min(offset+r.end, pageEnd); from < to {
+				copy(data[from-base:to-base], page.Data[from-offset:to-offset])
+			}
 		}
 	}
-
-	return nil, false
 }
 
-// evictOldestPage removes the oldest page from cache
-func (fe *FdEntity) evictOldestPage() {
-	var oldestOffset int64
-	var oldestTime time.Time
+// evictPages evicts the least recently used clean pages until the cache is within its limit
+// Dirty pages hold writes that have not been uploaded yet and are never evicted,
+// so the cache may stay over the limit until they are
+func (fe *FdEntity) evictPages() {
+	excess := len(fe.pages) - fe.effectiveMaxPages() - fe.readAheadPages()
+	if excess <= 0 || len(fe.dirtyPages) >= len(fe.pages) {
+		return
+	}
 
-	for offset, page := range fe.pages {
-		if oldestOffset == 0 || page.LastAccess.Before(oldestTime) {
-			oldestOffset = offset
-			oldestTime = page.LastAccess
+	clean := make([]*Page, 0, len(fe.pages)-len(fe.dirtyPages))
+	for _, page := range fe.pages {
+		if !page.Dirty {
+			clean = append(clean, page)
 		}
 	}
+	// Prefetched pages the reader has not reached yet go last, farthest ahead first
+	sort.Slice(clean, func(i, j int) bool {
+		ahead := fe.prefetched(clean[i])
+		if ahead != fe.prefetched(clean[j]) {
+			return !ahead
+		}
+		if ahead {
+			return clean[i].Offset > clean[j].Offset
+		}
+		return clean[i].LastAccess.Before(clean[j].LastAccess)
+	})
 
-	if oldestOffset != 0 {
-		delete(fe.pages, oldestOffset)
+	if excess > len(clean) {
+		excess = len(clean)
+	}
+	for _, page := range clean[:excess] {
+		// Fetch evicted prefetched data again when the reader gets close to it
+		if fe.prefetched(page) {
+			fe.readAhead.fetchedTo = min(fe.readAhead.fetchedTo, page.Offset)
+		}
+		fe.deletePage(page.Offset)
 	}
+	logging.Debug("fd cache evicted pages", "path", fe.path, "pages", excess)
 }
 
 // GetFile returns the underlying file handle (returns nil if not set)
@@ -598,9 +1078,78 @@ func (fe *FdEntity) Size() int64 {
 func (fe *FdEntity) SetSize(size int64) {
 	fe.mu.Lock()
 	defer fe.mu.Unlock()
+	if size != fe.size {
+		fe.sizeChanged = true
+	}
 	fe.size = size
 }
 
+// Truncate sets the file size and drops cached data past it, so growing the
+// file again reads back zeros rather than the old contents
+func (fe *FdEntity) Truncate(size int64) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	for offset, page := range fe.pages {
+		if offset+page.Size <= size {
+			continue
+		}
+		if page.Dirty {
+			fe.bytesModified -= page.Size
+		}
+		if offset >= size {
+			fe.deletePage(offset)
+			delete(fe.dirtyPages, offset)
+			continue
+		}
+		if int64(len(page.Data)) > size-offset {
+			fe.addMemory(size - offset - int64(len(page.Data)))
+			page.Data = page.Data[:size-offset]
+		}
+		page.Size = size - offset
+		if page.Dirty {
+			fe.bytesModified += page.Size
+		}
+	}
+	if fe.file != nil {
+		fe.file.Truncate(size)
+	}
+	fe.markTruncated(size)
+	fe.writeSeq++
+	if size != fe.size {
+		fe.sizeChanged = true
+	}
+	fe.size = size
+}
+
+// markTruncated records that stored bytes from size on no longer belong to the
+// file; the caller must hold fe.mu
+func (fe *FdEntity) markTruncated(size int64) {
+	if !fe.truncated || size < fe.truncatedTo {
+		fe.truncated = true
+		fe.truncatedTo = size
+	}
+}
+
+// Append writes data at the current end of the file and returns the offset it
+// was written at. Reading the size and writing happen under the entity lock, so
+// concurrent appenders never write at the same offset
+func (fe *FdEntity) Append(data []byte) int64 {
+	defer fe.enforceMemory()
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	offset := fe.size
+	for written := int64(0); written < int64(len(data)); {
+		written += fe.writeSinglePage(offset+written, data[written:])
+	}
+	if len(data) > 0 {
+		fe.size += int64(len(data))
+		fe.sizeChanged = true
+	}
+	return offset
+}
+
 func (fe *FdEntity) Mtime() time.Time {
 	fe.mu.RLock()
 	defer fe.mu.RUnlock()
@@ -613,84 +1162,300 @@ func (fe *FdEntity) SetMtime(mtime time.Time) {
 	fe.mtime = mtime
 }
 
+// ETag returns the ETag of the stored version the entity's pages and buffered
+// writes are based on, or "" if it is unknown
+func (fe *FdEntity) ETag() string {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+	return fe.etag
+}
+
+// SetETag records the stored version the entity is based on
+func (fe *FdEntity) SetETag(etag string) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.etag = etag
+}
+
+// Stored returns the attributes of the object the entity's last upload
+// stored, or nil if it has not uploaded since it was opened or reset, or since
+// the file's metadata was changed
+func (fe *FdEntity) Stored() *types.Attr {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+	return fe.stored
+}
+
+// SetStored records the attributes an upload stored; nil forgets them
+func (fe *FdEntity) SetStored(attr *types.Attr) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.stored = attr
+}
+
+// Reset makes the entity start afresh from a stored version changed by another
+// client, with the given size, mtime and ETag: cached pages and the temp file
+// are dropped, and a prefetch in flight is ignored. Pending writes are kept,
+// and nothing changes, unless dropWrites is set; it reports whether it reset
+func (fe *FdEntity) Reset(size int64, mtime time.Time, etag string, dropWrites bool) bool {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	pending := len(fe.dirtyPages) > 0 || fe.sizeChanged || fe.uploading > 0
+	if pending && !dropWrites {
+		return false
+	}
+	for _, page := range fe.pages {
+		fe.untrack(page)
+	}
+	fe.addMemory(-fe.memory)
+	fe.pages = make(map[int64]*Page)
+	fe.dirtyPages = make(map[int64]bool)
+	fe.bytesModified = 0
+	fe.sizeChanged = false
+	fe.truncated, fe.truncatedTo = false, 0
+	if fe.file != nil {
+		fe.file.Close()
+		fe.file = nil
+	}
+	fe.size, fe.mtime, fe.etag = size, mtime, etag
+	fe.stored = nil
+	fe.writeSeq++
+	fe.readAhead.fetchedTo = 0
+	return true
+}
+
+// Uploading reports whether an upload of this entity is in flight
+func (fe *FdEntity) Uploading() bool {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+	return fe.uploading > 0
+}
+
+// Uploads returns how many uploads of this entity have completed
+func (fe *FdEntity) Uploads() uint64 {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+	return fe.uploads
+}
+
+// UnlessUploaded runs fn unless an upload of the entity is in flight or has
+// completed since Uploads returned count; uploads wait for fn to return. It
+// reports whether fn ran
+func (fe *FdEntity) UnlessUploaded(count uint64, fn func()) bool {
+	if !fe.uploadMu.TryLock() {
+		return false
+	}
+	defer fe.uploadMu.Unlock()
+	if fe.Uploads() != count {
+		return false
+	}
+	fn()
+	return true
+}
+
 // UploadBufferedData uploads all dirty pages to S3 using the provided upload function
+// Parts of the file no page holds are uploaded as zeros; UploadBufferedDataFrom
+// fills them from storage instead
 func (fe *FdEntity) UploadBufferedData(ctx context.Context, uploadFunc func(ctx context.Context, data []byte) error) error {
+	return fe.UploadBufferedDataFrom(ctx, nil, uploadFunc)
+}
+
+// UploadBufferedDataFrom uploads the file as the entity sees it: the cached file,
+// or else the stored object from readStored, overlaid with every cached page
+// (dirty pages hold new writes, clean ones what was last stored). readStored is
+// only called when the pages do not cover the whole file; bytes past a truncation
+// are never taken from it. Uploads of one entity run one at a time
+func (fe *FdEntity) UploadBufferedDataFrom(ctx context.Context, readStored func(ctx context.Context) ([]byte, error), uploadFunc func(ctx context.Context, data []byte) error) error {
+	// Uploaded pages are clean, so eviction may now bring the cache within bounds
+	defer fe.enforceMemory()
+	fe.uploadMu.Lock()
+	defer fe.uploadMu.Unlock()
+
+	fe.mu.RLock()
+	pending := (len(fe.dirtyPages) > 0 || fe.sizeChanged) && !fe.discarded
+	needStored := pending && readStored != nil && fe.file == nil && !fe.pagesCover(fe.size)
+	fe.mu.RUnlock()
+	if !pending {
+		return nil
+	}
+
+	// Read outside the entity lock; uploadMu keeps other uploads from changing storage
+	var stored []byte
+	if needStored {
+		var err error
+		if stored, err = readStored(ctx); err != nil {
+			return fmt.Errorf("failed to read stored data: %w", err)
+		}
+	}
+
 	fe.mu.Lock()
 
-	// Get dirty data
+	// Get dirty data, remembering which write each page holds
 	dirtyPages := make([]int64, 0, len(fe.dirtyPages))
+	snapshot := make(map[int64]uint64, len(fe.dirtyPages))
 	for offset := range fe.dirtyPages {
 		dirtyPages = append(dirtyPages, offset)
+		if page, exists := fe.pages[offset]; exists {
+			snapshot[offset] = page.seq
+		}
 	}
 
-	if len(dirtyPages) == 0 {
+	if (len(dirtyPages) == 0 && !fe.sizeChanged) || fe.discarded {
 		fe.mu.Unlock()
 		return nil
 	}
 
 	// Use entity size, not max offset from dirty pages
 	entitySize := fe.size
+	truncated, truncatedTo := fe.truncated, fe.truncatedTo
 
-	// Sort offsets
-	sort.Slice(dirtyPages, func(i, j int) bool {
-		return dirtyPages[i] < dirtyPages[j]
-	})
-
-	// Read existing file if it exists, or create new buffer
-	var fullData []byte
+	// Start from the cached file if it exists, else from the stored object
+	fullData := make([]byte, entitySize)
 	if fe.file != nil {
-		// Read entire file
 		fe.file.Seek(0, 0)
-		fileData, err := io.ReadAll(fe.file)
-		if err == nil {
-			fullData = fileData
+		if fileData, err := io.ReadAll(fe.file); err == nil {
+			copy(fullData, fileData)
 		}
+	} else if stored != nil {
+		if truncated && truncatedTo < int64(len(stored)) {
+			stored = stored[:truncatedTo]
+		}
+		copy(fullData, stored)
 	}
 
-	// Ensure buffer matches entity size
-	if int64(len(fullData)) < entitySize {
-		// Extend with zeros
-		extended := make([]byte, entitySize)
-		copy(extended, fullData)
-		fullData = extended
-	} else if len(fullData) == 0 {
-		fullData = make([]byte, entitySize)
-	} else if int64(len(fullData)) > entitySize {
-		// Truncate to entity size
-		fullData = fullData[:entitySize]
+	// Write cached pages into buffer
+	fe.overlayPages(fullData, 0)
+
+	fe.uploading++
+	fe.mu.Unlock()
+
+	// Upload data
+	err := uploadFunc(ctx, fullData)
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.uploading--
+	if err != nil {
+		return err
+	}
+	fe.markUploaded(dirtyPages, snapshot, entitySize, truncated, truncatedTo)
+	return nil
+}
+
+// UploadDirtyRange uploads the entity's changes as a single range, for files
+// where only part of a large stored object changed. It applies when the bytes
+// written since the last upload are contiguous, the file was not truncated and
+// its size is storedSize or the end of the range. writeRange is passed the range's
+// offset and data and reports whether it stored them; false from either means
+// nothing was uploaded and the caller should upload the whole file instead
+func (fe *FdEntity) UploadDirtyRange(ctx context.Context, storedSize int64, writeRange func(ctx context.Context, offset int64, data []byte) (bool, error)) (bool, error) {
+	defer fe.enforceMemory()
+	fe.uploadMu.Lock()
+	defer fe.uploadMu.Unlock()
+
+	fe.mu.Lock()
+	if len(fe.dirtyPages) == 0 || fe.discarded || fe.file != nil || fe.truncated {
+		fe.mu.Unlock()
+		return false, nil
 	}
+	dirtyPages := make([]int64, 0, len(fe.dirtyPages))
+	for offset := range fe.dirtyPages {
+		dirtyPages = append(dirtyPages, offset)
+	}
+	sort.Slice(dirtyPages, func(i, j int) bool { return dirtyPages[i] < dirtyPages[j] })
 
-	// Write dirty pages into buffer
+	// Collect the written bytes back to back; a gap between them would need the
+	// stored bytes in it
+	start := int64(-1)
+	var data []byte
+	snapshot := make(map[int64]uint64, len(dirtyPages))
 	for _, offset := range dirtyPages {
-		if page, exists := fe.pages[offset]; exists {
-			// Ensure we don't go out of bounds
-			pageEnd := offset + page.Size
-			if pageEnd > entitySize {
-				pageEnd = entitySize
+		page, exists := fe.pages[offset]
+		if !exists {
+			fe.mu.Unlock()
+			return false, nil
+		}
+		ranges := page.written
+		if ranges == nil {
+			ranges = []pageRange{{0, int64(len(page.Data))}}
+		}
+		for _, r := range ranges {
+			if start < 0 {
+				start = offset + r.start
 			}
-			if offset < entitySize {
-				copy(fullData[offset:pageEnd], page.Data[:pageEnd-offset])
+			if offset+r.start != start+int64(len(data)) {
+				fe.mu.Unlock()
+				return false, nil
 			}
+			data = append(data, page.Data[r.start:r.end]...)
 		}
+		snapshot[offset] = page.seq
 	}
-
+	entitySize := fe.size
+	if end := start + int64(len(data)); end > entitySize {
+		data = data[:max(entitySize-start, 0)]
+	}
+	if entitySize != max(storedSize, start+int64(len(data))) {
+		fe.mu.Unlock()
+		return false, nil
+	}
+	fe.uploading++
 	fe.mu.Unlock()
 
-	// Upload data
-	if err := uploadFunc(ctx, fullData); err != nil {
-		return err
-	}
+	written, err := writeRange(ctx, start, data)
 
-	// Mark all pages as clean
 	fe.mu.Lock()
 	defer fe.mu.Unlock()
+	fe.uploading--
+	if err != nil || !written {
+		return false, err
+	}
+	fe.markUploaded(dirtyPages, snapshot, entitySize, false, 0)
+	return true, nil
+}
+
+// markUploaded marks the uploaded pages clean after an upload of entitySize
+// bytes; pages written again during the upload stay dirty for the next one.
+// The caller must hold fe.mu
+func (fe *FdEntity) markUploaded(dirtyPages []int64, snapshot map[int64]uint64, entitySize int64, truncated bool, truncatedTo int64) {
 	for _, offset := range dirtyPages {
-		if page, exists := fe.pages[offset]; exists {
+		page, exists := fe.pages[offset]
+		if exists && page.seq != snapshot[offset] {
+			continue
+		}
+		if exists && page.Dirty {
 			page.Dirty = false
+			fe.bytesModified -= page.Size
+			// A partly written page was never loaded, so it can't serve reads
+			if page.written != nil {
+				fe.deletePage(offset)
+			} else {
+				fe.track(page)
+			}
 		}
 		delete(fe.dirtyPages, offset)
 	}
-	fe.bytesModified = 0
+	if fe.bytesModified < 0 || len(fe.dirtyPages) == 0 {
+		fe.bytesModified = 0
+	}
+	if fe.size == entitySize {
+		fe.sizeChanged = false
+	}
+	if fe.truncated == truncated && fe.truncatedTo == truncatedTo {
+		fe.truncated = false // Storage now holds exactly what was uploaded
+	}
+	fe.uploads++
+}
 
-	return nil
+// pagesCover reports whether cached pages hold every byte of [0, size); the
+// caller must hold fe.mu
+func (fe *FdEntity) pagesCover(size int64) bool {
+	pageSize := fe.effectivePageSize()
+	for offset := int64(0); offset < size; offset += pageSize {
+		page, exists := fe.pages[offset]
+		if !exists || page.written != nil || offset+int64(len(page.Data)) < min(offset+pageSize, size) {
+			return false
+		}
+	}
+	return true
 }
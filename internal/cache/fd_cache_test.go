@@ -1,8 +1,12 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -108,6 +112,30 @@ func TestFdCacheManager_RefCount(t *testing.T) {
 	}
 }
 
+func TestFdCacheManager_DropPrefix(t *testing.T) {
+	fcm := NewFdCacheManager(100, 10, 4096)
+	defer fcm.CloseAll()
+
+	for _, path := range []string{"dir/a.txt", "dir/sub/b.txt", "dirt.txt"} {
+		if _, err := fcm.Open(path, 0, time.Now()); err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+	}
+	// A second reference must not keep the entity alive
+	fcm.Open("dir/a.txt", 0, time.Now())
+
+	fcm.DropPrefix("dir/")
+
+	for _, path := range []string{"dir/a.txt", "dir/sub/b.txt"} {
+		if fcm.HasOpenEntity(path) {
+			t.Errorf("Expected %s to be dropped", path)
+		}
+	}
+	if !fcm.HasOpenEntity("dirt.txt") {
+		t.Error("Entity outside the prefix should be kept")
+	}
+}
+
 func TestFdCacheManager_GetInfo(t *testing.T) {
 	fcm := NewFdCacheManager(100, 10, 4096)
 	defer fcm.CloseAll()
@@ -154,6 +182,39 @@ func TestFdCacheManager_HasOpenEntity(t *testing.T) {
 	}
 }
 
+func TestFdCacheManager_Prime(t *testing.T) {
+	fcm := NewFdCacheManager(100, 10, 4096)
+	defer fcm.CloseAll()
+
+	fcm.Prime("/test/file.txt", 5, time.Now(), "etag-1", []byte("hello"))
+	if fcm.HasOpenEntity("/test/file.txt") {
+		t.Error("Primed entity should not be open")
+	}
+	if _, ok := fcm.OpenPrimed("/test/file.txt", "etag-2"); ok {
+		t.Error("Primed entity should not be opened for another version")
+	}
+
+	entity, ok := fcm.OpenPrimed("/test/file.txt", "etag-1")
+	if !ok {
+		t.Fatal("Expected to open the primed entity")
+	}
+	if data, found := entity.ReadCachedRange(0, 5); !found || string(data) != "hello" {
+		t.Errorf("Expected the primed content, got %q", data)
+	}
+	if count := fcm.GetOpenFdCount("/test/file.txt"); count != 1 {
+		t.Errorf("Expected refCount 1, got %d", count)
+	}
+	if _, ok := fcm.OpenPrimed("/test/file.txt", "etag-1"); ok {
+		t.Error("Primed entity should only be taken over once")
+	}
+
+	// Open entities are never replaced
+	fcm.Prime("/test/file.txt", 3, time.Now(), "etag-3", []byte("new"))
+	if data, _ := entity.ReadCachedRange(0, 5); string(data) != "hello" {
+		t.Errorf("Priming replaced an open entity's content: %q", data)
+	}
+}
+
 func TestFdCacheManager_GetOpenFdCount(t *testing.T) {
 	fcm := NewFdCacheManager(100, 10, 4096)
 	defer fcm.CloseAll()
@@ -414,10 +475,10 @@ func TestFdEntity_PageEviction(t *testing.T) {
 		bytesModified: 0,
 	}
 
-	// Write more than 100 pages (max)
+	// Load more than 100 clean pages (max)
 	for i := 0; i < 150; i++ {
 		pageData := make([]byte, 4096)
-		entity.WritePage(int64(i*4096), pageData)
+		entity.LoadPagesAt(int64(i*4096), pageData)
 		time.Sleep(1 * time.Millisecond) // Ensure different access times
 	}
 
@@ -425,4 +486,546 @@ func TestFdEntity_PageEviction(t *testing.T) {
 	if len(entity.pages) > 100 {
 		t.Errorf("Expected <= 100 pages, got %d", len(entity.pages))
 	}
+	if _, exists := entity.pages[0]; exists {
+		t.Error("Expected the oldest page to be evicted")
+	}
+}
+
+func TestFdEntity_DirtyPagesNotEvicted(t *testing.T) {
+	manager := NewFdCacheManager(100, 10, 4096)
+	manager.SetMaxPages(10)
+	entity, err := manager.Open("/test/large.bin", 0, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to open entity: %v", err)
+	}
+
+	// One sequential pass well past the page cap
+	data := make([]byte, 25*4096+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for offset := 0; offset < len(data); offset += 1000 {
+		end := offset + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		entity.WritePage(int64(offset), data[offset:end])
+	}
+	entity.SetSize(int64(len(data)))
+
+	var uploaded []byte
+	err = entity.UploadBufferedData(context.Background(), func(ctx context.Context, d []byte) error {
+		uploaded = d
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if !bytes.Equal(uploaded, data) {
+		t.Fatal("Uploaded data does not match everything that was written")
+	}
+
+	// Once uploaded the pages are clean and the cap applies again
+	entity.LoadPagesAt(30*4096, make([]byte, 4096))
+	if len(entity.pages) > 10 {
+		t.Errorf("Expected clean pages to be evicted down to 10, got %d", len(entity.pages))
+	}
+}
+
+func TestFdEntity_ZeroPageSize(t *testing.T) {
+	// Entities constructed without a page size must not panic
+	entity := &FdEntity{
+		path:       "/test/file.txt",
+		size:       8192,
+		pages:      make(map[int64]*Page),
+		dirtyPages: make(map[int64]bool),
+	}
+
+	data := []byte("hello world")
+	entity.WritePage(5000, data)
+
+	readData, found := entity.ReadPage(5000)
+	if !found {
+		t.Fatal("Page not found after write")
+	}
+	if string(readData[:len(data)]) != string(data) {
+		t.Errorf("Expected %q, got %q", data, readData[:len(data)])
+	}
+
+	// The manager also normalizes a zero page size
+	fcm := NewFdCacheManager(100, 10, 0)
+	defer fcm.CloseAll()
+	if fcm.pageSize != DefaultPageSize {
+		t.Errorf("Expected page size %d, got %d", DefaultPageSize, fcm.pageSize)
+	}
+}
+
+func TestFdEntity_LoadPagesClean(t *testing.T) {
+	manager := NewFdCacheManager(100, 10, 4096)
+	entity, err := manager.Open("test.txt", 10000, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to open entity: %v", err)
+	}
+
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	entity.LoadPages(data)
+
+	if entity.BytesModified() != 0 {
+		t.Errorf("Loaded pages must not be dirty, got %d modified bytes", entity.BytesModified())
+	}
+
+	// Range crossing page boundaries
+	got, ok := entity.ReadCachedRange(4000, 5000)
+	if !ok {
+		t.Fatal("Expected cached range to be found")
+	}
+	if string(got) != string(data[4000:9000]) {
+		t.Error("Cached range does not match loaded data")
+	}
+
+	// Reads are clamped to the entity size
+	got, ok = entity.ReadCachedRange(8000, 4096)
+	if !ok || len(got) != 2000 {
+		t.Errorf("Expected 2000 bytes up to EOF, got %d (found=%v)", len(got), ok)
+	}
+
+	// Past EOF is not served from cache
+	if _, ok := entity.ReadCachedRange(10000, 10); ok {
+		t.Error("Expected no cached data past EOF")
+	}
+}
+
+func TestFdEntity_UploadState(t *testing.T) {
+	fcm := NewFdCacheManager(100, 10, 4096)
+	defer fcm.CloseAll()
+
+	entity, err := fcm.Open("/test/file.txt", 11, time.Now())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// Truncating without writing data still needs an upload
+	entity.SetSize(0)
+	uploads := 0
+	err = entity.UploadBufferedData(context.Background(), func(ctx context.Context, d []byte) error {
+		uploads++
+		if !entity.Uploading() {
+			t.Error("Expected Uploading() to be true during upload")
+		}
+		if len(d) != 0 {
+			t.Errorf("Expected empty upload, got %d bytes", len(d))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UploadBufferedData failed: %v", err)
+	}
+	if uploads != 1 {
+		t.Errorf("Expected 1 upload after truncate, got %d", uploads)
+	}
+	if entity.Uploading() {
+		t.Error("Expected Uploading() to be false after upload")
+	}
+
+	// Nothing changed since, so there is nothing to upload
+	entity.UploadBufferedData(context.Background(), func(ctx context.Context, d []byte) error {
+		uploads++
+		return nil
+	})
+	if uploads != 1 {
+		t.Errorf("Expected no upload for a clean entity, got %d", uploads)
+	}
+}
+
+func TestFdCacheManager_RetainClosed(t *testing.T) {
+	fcm := NewFdCacheManager(100, 10, 4096)
+	defer fcm.CloseAll()
+	fcm.SetRetainClosed(true)
+
+	entity, _ := fcm.Open("/kept.txt", 5, time.Now())
+	entity.LoadPages([]byte("hello"))
+	fcm.Close("/kept.txt")
+
+	retained, found := fcm.Get("/kept.txt")
+	if !found || fcm.HasOpenEntity("/kept.txt") {
+		t.Fatalf("Expected a retained entity with no open handles (found %v)", found)
+	}
+	if data, ok := retained.ReadCachedRange(0, 5); !ok || string(data) != "hello" {
+		t.Errorf("Retained pages = %q, %v", data, ok)
+	}
+
+	// Reopening starts from an empty entity
+	reopened, _ := fcm.Open("/kept.txt", 5, time.Now())
+	if _, ok := reopened.ReadCachedRange(0, 5); ok {
+		t.Error("Reopened entity still has the old pages")
+	}
+	fcm.Close("/kept.txt")
+
+	// Closing an entity with no handles discards it
+	fcm.Close("/kept.txt")
+	if _, found := fcm.Get("/kept.txt"); found {
+		t.Error("Entity still cached after closing a retained entity")
+	}
+
+	// Entities with unflushed writes are never retained
+	dirty, _ := fcm.Open("/dirty.txt", 0, time.Now())
+	dirty.WritePage(0, []byte("x"))
+	fcm.Close("/dirty.txt")
+	if _, found := fcm.Get("/dirty.txt"); found {
+		t.Error("Entity with buffered data retained after close")
+	}
+}
+
+func TestFdCacheManager_EvictionKeepsBufferedWrites(t *testing.T) {
+	fcm := NewFdCacheManager(100, 2, 4096)
+	defer fcm.CloseAll()
+	fcm.SetRetainClosed(true)
+
+	// Written to after its last handle was closed, so nothing else uploads it
+	fcm.Open("/dirty.txt", 0, time.Now())
+	fcm.Close("/dirty.txt")
+	dirty, _ := fcm.Get("/dirty.txt")
+	dirty.WriteAt(0, []byte("unsaved"), false)
+
+	// Without a flush the entity outlives the open file limit
+	fcm.Open("/a.txt", 0, time.Now())
+	fcm.Open("/b.txt", 0, time.Now())
+	if _, found := fcm.Get("/dirty.txt"); !found {
+		t.Fatal("Entity with buffered writes evicted")
+	}
+
+	uploaded := make(chan string, 1)
+	fcm.SetEvictFlush(func(path string, entity *FdEntity) error {
+		return entity.UploadBufferedData(context.Background(), func(ctx context.Context, data []byte) error {
+			uploaded <- path + ":" + string(data)
+			return nil
+		})
+	})
+	fcm.Open("/c.txt", 0, time.Now())
+	select {
+	case got := <-uploaded:
+		if got != "/dirty.txt:unsaved" {
+			t.Errorf("Flushed %q, want %q", got, "/dirty.txt:unsaved")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Entity with buffered writes was not flushed")
+	}
+
+	// Evicted once uploaded
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, found := fcm.Get("/dirty.txt"); !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Flushed entity was not evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFdCacheManager_MaxMemory(t *testing.T) {
+	const page = 4096
+	fcm := NewFdCacheManager(100, 10, page)
+	defer fcm.CloseAll()
+	fcm.SetRetainClosed(true)
+	fcm.SetMaxMemory(10 * page)
+
+	closed, _ := fcm.Open("/closed.txt", 4*page, time.Now())
+	closed.LoadPages(make([]byte, 4*page))
+	fcm.Close("/closed.txt")
+	open, _ := fcm.Open("/open.txt", 4*page, time.Now())
+	open.LoadPages(make([]byte, 4*page))
+	dirty, _ := fcm.Open("/dirty.bin", 0, time.Now())
+
+	data := make([]byte, 12*page)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	// The least recently used clean pages go first: the closed file's, then
+	// the open file's
+	dirty.WriteAt(0, data[:6*page], false)
+	if fcm.MemoryUsage() > 10*page || len(closed.pages) != 0 || len(open.pages) != 4 {
+		t.Errorf("Usage %d with %d closed and %d open pages, want the closed file evicted", fcm.MemoryUsage(), len(closed.pages), len(open.pages))
+	}
+	dirty.WriteAt(6*page, data[6*page:10*page], false)
+	if fcm.MemoryUsage() > 10*page || len(open.pages) != 0 {
+		t.Errorf("Usage %d with %d open pages, want the open file evicted", fcm.MemoryUsage(), len(open.pages))
+	}
+
+	// Dirty pages stay over the limit until they are uploaded
+	dirty.WriteAt(10*page, data[10*page:], false)
+	if !fcm.OverMemory() || fcm.MemoryUsage() != int64(len(data)) {
+		t.Fatalf("Usage %d, want all %d dirty bytes kept", fcm.MemoryUsage(), len(data))
+	}
+	var uploaded []byte
+	dirty.UploadBufferedData(context.Background(), func(ctx context.Context, d []byte) error {
+		uploaded = d
+		return nil
+	})
+	if !bytes.Equal(uploaded, data) {
+		t.Fatal("Uploaded data does not match everything that was written")
+	}
+	if fcm.OverMemory() || fcm.MemoryUsage() != 10*page {
+		t.Errorf("Usage %d after upload, want %d", fcm.MemoryUsage(), 10*page)
+	}
+
+	// Entities dropped from the cache no longer count
+	fcm.Discard("/dirty.bin")
+	if fcm.MemoryUsage() != 0 {
+		t.Errorf("Usage %d with only empty entities cached", fcm.MemoryUsage())
+	}
+}
+
+func TestFdCacheManager_MaxMemoryEvictsLeastRecentlyRead(t *testing.T) {
+	const page = 4096
+	fcm := NewFdCacheManager(100, 10, page)
+	defer fcm.CloseAll()
+	fcm.SetMaxMemory(8 * page)
+
+	first, _ := fcm.Open("/first.txt", 4*page, time.Now())
+	first.LoadPages(make([]byte, 4*page))
+	second, _ := fcm.Open("/second.txt", 4*page, time.Now())
+	second.LoadPages(make([]byte, 4*page))
+
+	// Reading the older file makes the newer one the eviction candidate
+	if _, ok := first.ReadCachedRange(0, 4*page); !ok {
+		t.Fatal("Loaded pages were not cached")
+	}
+	third, _ := fcm.Open("/third.txt", 2*page, time.Now())
+	third.LoadPages(make([]byte, 2*page))
+	if len(first.pages) != 4 || len(second.pages) != 2 || len(third.pages) != 2 {
+		t.Errorf("Got %d, %d and %d pages, want the unread file's oldest pages evicted", len(first.pages), len(second.pages), len(third.pages))
+	}
+	if fcm.MemoryUsage() != 8*page {
+		t.Errorf("Usage %d, want %d", fcm.MemoryUsage(), 8*page)
+	}
+}
+
+func TestFdEntity_Truncate(t *testing.T) {
+	entity := &FdEntity{
+		path:       "/test/file.txt",
+		pageSize:   4,
+		pages:      make(map[int64]*Page),
+		dirtyPages: make(map[int64]bool),
+	}
+	entity.WritePage(0, []byte("0123456789"))
+	entity.SetSize(10)
+
+	entity.Truncate(6)
+	if entity.Size() != 6 {
+		t.Errorf("Expected size 6, got %d", entity.Size())
+	}
+	if entity.BytesModified() != 6 {
+		t.Errorf("Expected 6 bytes modified, got %d", entity.BytesModified())
+	}
+
+	// Growing again must expose zeros, not the dropped bytes
+	entity.Truncate(10)
+	var uploaded []byte
+	err := entity.UploadBufferedData(context.Background(), func(ctx context.Context, data []byte) error {
+		uploaded = data
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UploadBufferedData failed: %v", err)
+	}
+	if string(uploaded) != "012345\x00\x00\x00\x00" {
+		t.Errorf("Expected truncated then zero-extended data, got %q", uploaded)
+	}
+}
+
+func TestFdEntity_Append(t *testing.T) {
+	entity := &FdEntity{
+		path:       "/test/file.txt",
+		pageSize:   4,
+		pages:      make(map[int64]*Page),
+		dirtyPages: make(map[int64]bool),
+	}
+	entity.WritePage(0, []byte("abc"))
+	entity.SetSize(3)
+
+	if offset := entity.Append([]byte("defgh")); offset != 3 {
+		t.Errorf("Expected append at offset 3, got %d", offset)
+	}
+	if entity.Size() != 8 {
+		t.Errorf("Expected size 8, got %d", entity.Size())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entity.Append([]byte("xy"))
+		}()
+	}
+	wg.Wait()
+	if entity.Size() != 28 {
+		t.Errorf("Expected size 28 after concurrent appends, got %d", entity.Size())
+	}
+	data, found := entity.ReadBufferedData(0, 28)
+	if !found || string(data) != "abcdefgh"+strings.Repeat("xy", 10) {
+		t.Errorf("Expected every append to land after the previous one, got %q", data)
+	}
+}
+
+func TestFdEntity_UploadBufferedDataFrom(t *testing.T) {
+	entity := &FdEntity{
+		path:       "/test/file.txt",
+		pageSize:   4,
+		pages:      make(map[int64]*Page),
+		dirtyPages: make(map[int64]bool),
+	}
+	entity.size = 10 // Stored as "0123456789", nothing cached
+	stored := []byte("0123456789")
+	readStored := func(ctx context.Context) ([]byte, error) {
+		return stored, nil
+	}
+
+	// Pages held by nothing are filled from the stored object
+	entity.WriteAt(10, []byte("ab"), false)
+	var uploaded []byte
+	upload := func(ctx context.Context, data []byte) error {
+		uploaded, stored = data, data
+		return nil
+	}
+	if err := entity.UploadBufferedDataFrom(context.Background(), readStored, upload); err != nil {
+		t.Fatalf("UploadBufferedDataFrom failed: %v", err)
+	}
+	if string(uploaded) != "0123456789ab" {
+		t.Errorf("Expected stored data plus the write, got %q", uploaded)
+	}
+
+	// The gap between two writes to one page keeps the stored byte
+	entity.WriteAt(3, []byte("B"), false)
+	entity.WriteAt(1, []byte("A"), false)
+	if err := entity.UploadBufferedDataFrom(context.Background(), readStored, upload); err != nil {
+		t.Fatalf("UploadBufferedDataFrom failed: %v", err)
+	}
+	if string(uploaded) != "0A2B456789ab" {
+		t.Errorf("Expected only the written bytes replaced, got %q", uploaded)
+	}
+
+	// Stored bytes past a truncation are not brought back
+	entity.Truncate(4)
+	entity.WriteAt(6, []byte("x"), false)
+	if err := entity.UploadBufferedDataFrom(context.Background(), readStored, upload); err != nil {
+		t.Fatalf("UploadBufferedDataFrom failed: %v", err)
+	}
+	if string(uploaded) != "0A2B\x00\x00x" {
+		t.Errorf("Expected zeros after the truncation point, got %q", uploaded)
+	}
+
+	// A page written again while its upload runs stays dirty
+	err := entity.UploadBufferedDataFrom(context.Background(), readStored, func(ctx context.Context, data []byte) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UploadBufferedDataFrom failed: %v", err)
+	}
+	entity.WriteAt(0, []byte("Z"), false)
+	err = entity.UploadBufferedDataFrom(context.Background(), readStored, func(ctx context.Context, data []byte) error {
+		entity.WriteAt(1, []byte("Y"), false)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UploadBufferedDataFrom failed: %v", err)
+	}
+	if entity.BytesModified() == 0 {
+		t.Error("Page rewritten during the upload was marked clean")
+	}
+}
+
+func TestFdEntity_Reset(t *testing.T) {
+	entity := &FdEntity{
+		path:       "/test/file.txt",
+		pageSize:   4,
+		pages:      make(map[int64]*Page),
+		dirtyPages: make(map[int64]bool),
+		etag:       `"v1"`,
+	}
+	entity.size = 8
+	entity.LoadPages([]byte("abcdefgh"))
+	entity.WriteAt(0, []byte("X"), false)
+
+	// Pending writes are kept unless dropped
+	if entity.Reset(5, time.Now(), `"v2"`, false) {
+		t.Fatal("Reset discarded pending writes")
+	}
+	if entity.ETag() != `"v1"` || entity.BytesModified() == 0 {
+		t.Error("Refused Reset changed the entity")
+	}
+
+	if !entity.Reset(5, time.Now(), `"v2"`, true) {
+		t.Fatal("Reset with dropWrites refused")
+	}
+	if entity.ETag() != `"v2"` || entity.Size() != 5 || entity.BytesModified() != 0 {
+		t.Errorf("After Reset: ETag %s, size %d, %d bytes modified", entity.ETag(), entity.Size(), entity.BytesModified())
+	}
+	if _, found := entity.ReadCachedRange(0, 4); found {
+		t.Error("Pages of the old version still cached")
+	}
+
+	// A clean entity resets without dropWrites
+	entity.LoadPages([]byte("12345"))
+	if !entity.Reset(3, time.Now(), `"v3"`, false) {
+		t.Error("Reset of a clean entity refused")
+	}
+}
+
+func TestFdEntity_UploadDirtyRange(t *testing.T) {
+	entity := &FdEntity{
+		path:       "/test/file.txt",
+		pageSize:   4,
+		pages:      make(map[int64]*Page),
+		dirtyPages: make(map[int64]bool),
+	}
+	entity.size = 12 // Stored as 12 bytes, nothing cached
+	var gotOffset int64
+	var got []byte
+	writeRange := func(ctx context.Context, offset int64, data []byte) (bool, error) {
+		gotOffset, got = offset, data
+		return true, nil
+	}
+
+	// One write spanning a page boundary is a single range
+	entity.WriteAt(3, []byte("abc"), false)
+	written, err := entity.UploadDirtyRange(context.Background(), 12, writeRange)
+	if err != nil || !written {
+		t.Fatalf("UploadDirtyRange = %v, %v; want true", written, err)
+	}
+	if gotOffset != 3 || string(got) != "abc" {
+		t.Errorf("Uploaded %q at %d, want %q at 3", got, gotOffset, "abc")
+	}
+	if entity.BytesModified() != 0 {
+		t.Error("Uploaded pages still dirty")
+	}
+
+	// A write past the end grows the file by the range
+	entity.WriteAt(12, []byte("xy"), false)
+	if written, _ := entity.UploadDirtyRange(context.Background(), 12, writeRange); !written || gotOffset != 12 || string(got) != "xy" {
+		t.Errorf("Extending write uploaded %q at %d, %v", got, gotOffset, written)
+	}
+
+	// Writes with stored bytes between them are left to a full upload
+	entity.WriteAt(0, []byte("A"), false)
+	entity.WriteAt(2, []byte("B"), false)
+	if written, _ := entity.UploadDirtyRange(context.Background(), 14, writeRange); written {
+		t.Error("Uploaded a range with a gap in it")
+	}
+	if entity.BytesModified() == 0 {
+		t.Error("Pages marked clean without an upload")
+	}
+
+	// So is a truncated file
+	entity.UploadBufferedDataFrom(context.Background(), nil, func(ctx context.Context, data []byte) error { return nil })
+	entity.Truncate(10)
+	entity.WriteAt(0, []byte("C"), false)
+	if written, _ := entity.UploadDirtyRange(context.Background(), 14, writeRange); written {
+		t.Error("Uploaded a range of a truncated file")
+	}
 }
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+)
+
+// memoryBudget tracks the page data held by all entities of a manager
+type memoryBudget struct {
+	used     atomic.Int64 // Bytes of page data held
+	limit    atomic.Int64 // Bytes to hold before evicting clean pages (0 = unlimited)
+	evicting sync.Mutex   // Held by the eviction pass in progress
+	evict    func()       // The manager's evictMemory
+
+	mu    sync.Mutex // Guards clean; taken after any entity's mu
+	clean list.List  // Clean pages of all entities (*cleanPage), least recently used first
+}
+
+// cleanPage is a clean page memory eviction may drop
+type cleanPage struct {
+	entity *FdEntity
+	page   *Page
+}
+
+// SetMaxMemory bounds the page data cached by all entities together at maxBytes
+// (0 = unlimited). Past the bound, clean pages are evicted least recently used
+// first. Dirty pages are never evicted: callers flush buffered writes while
+// OverMemory reports true
+func (fcm *FdCacheManager) SetMaxMemory(maxBytes int64) {
+	fcm.memory.limit.Store(max(maxBytes, 0))
+	fcm.evictMemory()
+}
+
+// MemoryUsage returns the bytes of page data cached by all entities
+func (fcm *FdCacheManager) MemoryUsage() int64 {
+	return fcm.memory.used.Load()
+}
+
+// OverMemory reports whether the entities cache more page data than
+// SetMaxMemory allows
+func (fcm *FdCacheManager) OverMemory() bool {
+	limit := fcm.memory.limit.Load()
+	return limit > 0 && fcm.memory.used.Load() > limit
+}
+
+// evictMemory evicts clean pages until the cache is back within its memory
+// bound or only dirty pages are left. Only one pass runs at a time; others
+// return at once, as the running pass frees what they would have
+func (fcm *FdCacheManager) evictMemory() {
+	if !fcm.OverMemory() || !fcm.memory.evicting.TryLock() {
+		return
+	}
+	defer fcm.memory.evicting.Unlock()
+
+	budget := fcm.memory
+	evicted := 0
+	for fcm.OverMemory() {
+		budget.mu.Lock()
+		oldest := budget.clean.Front()
+		budget.mu.Unlock()
+		if oldest == nil {
+			break
+		}
+		candidate := oldest.Value.(*cleanPage)
+		entity := candidate.entity
+		entity.mu.Lock()
+		// Written or dropped since it was listed, the page is off the list
+		// already; deleting it takes it off otherwise
+		if entity.pages[candidate.page.Offset] == candidate.page && !candidate.page.Dirty {
+			if entity.prefetched(candidate.page) {
+				entity.readAhead.fetchedTo = min(entity.readAhead.fetchedTo, candidate.page.Offset)
+			}
+			entity.deletePage(candidate.page.Offset)
+			evicted++
+		}
+		budget.mu.Lock()
+		budget.clean.Remove(oldest)
+		budget.mu.Unlock()
+		entity.mu.Unlock()
+	}
+	logging.Debug("fd cache evicted pages over the memory limit", "pages", evicted, "bytes", fcm.MemoryUsage())
+}
+
+// setPage caches page at its offset, replacing any page there; the caller must
+// hold fe.mu
+func (fe *FdEntity) setPage(page *Page) {
+	if old, exists := fe.pages[page.Offset]; exists {
+		fe.addMemory(-int64(len(old.Data)))
+		fe.untrack(old)
+	}
+	fe.pages[page.Offset] = page
+	fe.addMemory(int64(len(page.Data)))
+	if !page.Dirty {
+		fe.track(page)
+	}
+}
+
+// deletePage drops the page at offset; the caller must hold fe.mu
+func (fe *FdEntity) deletePage(offset int64) {
+	if page, exists := fe.pages[offset]; exists {
+		fe.addMemory(-int64(len(page.Data)))
+		fe.untrack(page)
+		delete(fe.pages, offset)
+	}
+}
+
+// track puts a page that just became clean at the back of the manager's
+// eviction order; the caller must hold fe.mu
+func (fe *FdEntity) track(page *Page) {
+	if fe.budget == nil || page.clean != nil {
+		return
+	}
+	fe.budget.mu.Lock()
+	page.clean = fe.budget.clean.PushBack(&cleanPage{fe, page})
+	fe.budget.mu.Unlock()
+}
+
+// untrack takes a page that is dropped or about to be written out of the
+// manager's eviction order; the caller must hold fe.mu
+func (fe *FdEntity) untrack(page *Page) {
+	if page.clean == nil {
+		return
+	}
+	if fe.budget != nil {
+		fe.budget.mu.Lock()
+		fe.budget.clean.Remove(page.clean)
+		fe.budget.mu.Unlock()
+	}
+	page.clean = nil
+}
+
+// touch records a read of the page, moving it to the back of the eviction
+// order; the caller must hold fe.mu, for reading at least
+func (fe *FdEntity) touch(page *Page) {
+	page.LastAccess = time.Now()
+	if fe.budget != nil && page.clean != nil {
+		fe.budget.mu.Lock()
+		fe.budget.clean.MoveToBack(page.clean)
+		fe.budget.mu.Unlock()
+	}
+}
+
+// addMemory records n more bytes of page data held by the entity; the caller
+// must hold fe.mu
+func (fe *FdEntity) addMemory(n int64) {
+	fe.memory += n
+	if fe.budget != nil {
+		fe.budget.used.Add(n)
+	}
+}
+
+// detach stops counting the entity's pages against its manager's memory bound
+// once the manager drops it; the caller must hold fe.mu
+func (fe *FdEntity) detach() {
+	if fe.budget != nil {
+		for _, page := range fe.pages {
+			fe.untrack(page)
+		}
+		fe.budget.used.Add(-fe.memory)
+		fe.budget = nil
+	}
+}
+
+// enforceMemory has the manager evict pages if the entity's last change took
+// the cache over its memory bound. It must be called without fe.mu, since
+// eviction locks every entity in turn
+func (fe *FdEntity) enforceMemory() {
+	fe.mu.RLock()
+	budget := fe.budget
+	fe.mu.RUnlock()
+	if budget != nil && budget.evict != nil {
+		budget.evict()
+	}
+}
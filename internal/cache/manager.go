@@ -35,6 +35,14 @@ func (m *Manager) GetTree() *CacheTree {
 	return m.tree
 }
 
+// SetNegativeCacheTTL sets how long failed lookups are cached (0 disables negative caching)
+// Repeated stats of a missing path are then answered without asking the backend
+func (m *Manager) SetNegativeCacheTTL(ttl time.Duration) {
+	if m.statCache != nil {
+		m.statCache.SetNegativeTTL(ttl)
+	}
+}
+
 // Close closes all caches
 func (m *Manager) Close() {
 	if m.statCache != nil {
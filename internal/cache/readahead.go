@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+)
+
+// readAheadState tracks sequential reads of an entity and the data prefetched for them
+// All fields are guarded by the entity mutex
+type readAheadState struct {
+	next      int64         // Offset the next read starts at if access is sequential
+	fetchedTo int64         // End of the prefetched data; pages before it may still be evicted
+	window    int64         // Bytes to keep prefetched ahead of the reader (0 = readahead unused)
+	done      chan struct{} // Closed when the prefetch in flight completes; nil if none
+	start     int64         // Range of the prefetch in flight
+	end       int64
+	seq       uint64 // writeSeq when the prefetch in flight started
+}
+
+// StartReadAhead records a read of n bytes at offset and decides whether to prefetch
+// When reads are sequential and less than half the window is cached past this one,
+// it returns the range [start, end) to fetch, which the caller must pass to
+// FinishReadAhead once fetched. Only one prefetch per entity is in flight at a time
+func (fe *FdEntity) StartReadAhead(offset, n, window int64) (start, end int64, ok bool) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	ra := &fe.readAhead
+	sequential := offset == ra.next
+	readEnd := offset + n
+	ra.next = readEnd
+	if !sequential || window <= 0 || ra.done != nil {
+		return 0, 0, false
+	}
+	ra.window = window
+	if ra.fetchedTo-readEnd >= window/2 {
+		return 0, 0, false
+	}
+
+	// Pages start at page boundaries, so fetch from the start of the page
+	pageSize := fe.effectivePageSize()
+	start = (max(ra.fetchedTo, readEnd) / pageSize) * pageSize
+	end = min(readEnd+window, fe.size)
+	if start >= end {
+		return 0, 0, false
+	}
+	ra.done = make(chan struct{})
+	ra.start, ra.end, ra.seq = start, end, fe.writeSeq
+	return start, end, true
+}
+
+// FinishReadAhead caches the data fetched for the range StartReadAhead returned
+// The data is dropped if the fetch failed or the file was written or truncated
+// meanwhile, since it may no longer match the file
+func (fe *FdEntity) FinishReadAhead(start int64, data []byte, err error) {
+	defer fe.enforceMemory()
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	ra := &fe.readAhead
+	close(ra.done)
+	ra.done = nil
+	if err != nil || fe.writeSeq != ra.seq {
+		return
+	}
+	fe.loadPagesAt(start, data)
+	ra.fetchedTo = max(ra.fetchedTo, start+int64(len(data)))
+}
+
+// WaitReadAhead waits for the prefetch in flight if it covers offset, and reports
+// whether it did, so a read that just missed the cache can retry instead of
+// fetching the same data again
+func (fe *FdEntity) WaitReadAhead(ctx context.Context, offset int64) bool {
+	fe.mu.RLock()
+	ra := &fe.readAhead
+	done := ra.done
+	covers := done != nil && offset >= ra.start && offset < ra.end
+	fe.mu.RUnlock()
+	if !covers {
+		return false
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// readAheadPages returns the pages allowed on top of the page cache limit for
+// data prefetched ahead of the reader; the caller must hold fe.mu
+func (fe *FdEntity) readAheadPages() int {
+	return int(fe.readAhead.window / fe.effectivePageSize())
+}
+
+// prefetched reports whether page holds prefetched data the reader has not reached
+// yet; the caller must hold fe.mu
+func (fe *FdEntity) prefetched(page *Page) bool {
+	pageSize := fe.effectivePageSize()
+	return page.Offset >= (fe.readAhead.next/pageSize)*pageSize && page.Offset < fe.readAhead.fetchedTo
+}
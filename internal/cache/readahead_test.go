@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func newReadAheadEntity(size int64) *FdEntity {
+	return &FdEntity{
+		path:       "/test/stream.bin",
+		size:       size,
+		pageSize:   4,
+		maxPages:   2,
+		pages:      make(map[int64]*Page),
+		dirtyPages: make(map[int64]bool),
+	}
+}
+
+func TestFdEntity_StartReadAhead(t *testing.T) {
+	entity := newReadAheadEntity(100)
+
+	// A read from the start of the file is sequential
+	start, end, ok := entity.StartReadAhead(0, 6, 16)
+	if !ok || start != 4 || end != 22 {
+		t.Fatalf("StartReadAhead(0, 6) = %d, %d, %v; want 4, 22, true", start, end, ok)
+	}
+	// Only one prefetch in flight
+	if _, _, ok := entity.StartReadAhead(6, 6, 16); ok {
+		t.Error("Second prefetch started while the first is in flight")
+	}
+	entity.FinishReadAhead(start, bytes.Repeat([]byte("x"), int(end-start)), nil)
+
+	// Plenty prefetched past the read: nothing to do
+	if _, _, ok := entity.StartReadAhead(12, 2, 16); ok {
+		t.Error("Prefetch started with more than half the window cached ahead")
+	}
+	// Running low: continue where the last prefetch stopped
+	start, end, ok = entity.StartReadAhead(14, 4, 16)
+	if !ok || start != 20 || end != 34 {
+		t.Fatalf("StartReadAhead(14, 4) = %d, %d, %v; want 20, 34, true", start, end, ok)
+	}
+	entity.FinishReadAhead(start, nil, context.Canceled)
+
+	// A random read is not followed by a prefetch, and the window stops at EOF
+	if _, _, ok := entity.StartReadAhead(50, 4, 16); ok {
+		t.Error("Prefetch started after a non-sequential read")
+	}
+	start, end, ok = entity.StartReadAhead(54, 42, 16)
+	if !ok || start != 96 || end != 100 {
+		t.Errorf("StartReadAhead(54, 42) = %d, %d, %v; want 96, 100, true", start, end, ok)
+	}
+}
+
+func TestFdEntity_FinishReadAhead(t *testing.T) {
+	entity := newReadAheadEntity(100)
+
+	start, end, _ := entity.StartReadAhead(0, 4, 8)
+	entity.FinishReadAhead(start, []byte("abcdefgh")[:end-start], nil)
+	if data, found := entity.ReadCachedRange(4, 8); !found || string(data) != "abcdefgh" {
+		t.Errorf("ReadCachedRange after prefetch = %q, %v; want %q", data, found, "abcdefgh")
+	}
+
+	// Data fetched before a write may be older than the file; drop it
+	start, _, ok := entity.StartReadAhead(4, 4, 16)
+	if !ok {
+		t.Fatal("Expected a prefetch")
+	}
+	entity.WriteAt(20, []byte("new!"), false)
+	entity.FinishReadAhead(start, []byte("old data"), nil)
+	if data, found := entity.ReadCachedRange(12, 4); found {
+		t.Errorf("Prefetch overlapping a write was cached: %q", data)
+	}
+}
+
+func TestFdEntity_ReadAheadEviction(t *testing.T) {
+	entity := newReadAheadEntity(100)
+
+	// The window is cached on top of the page limit
+	start, end, _ := entity.StartReadAhead(0, 4, 8)
+	entity.FinishReadAhead(start, []byte("abcdefgh")[:end-start], nil)
+	entity.StartReadAhead(4, 4, 8)
+	if len(entity.pages) != 2 {
+		t.Fatalf("Cached %d pages after the prefetch, want 2", len(entity.pages))
+	}
+
+	// Pages behind the reader are evicted before the prefetched ones ahead of it
+	entity.LoadPagesAt(20, []byte("0123456789ab"))
+	if _, exists := entity.pages[4]; exists {
+		t.Error("Page already read survived eviction")
+	}
+	if _, exists := entity.pages[8]; !exists {
+		t.Error("Prefetched page was evicted before pages the reader is done with")
+	}
+
+	// Under pressure prefetched pages go too, and are fetched again
+	for offset := int64(40); offset < 56; offset += 4 {
+		entity.WriteAt(offset, []byte("wxyz"), false)
+	}
+	entity.LoadPagesAt(60, []byte("more"))
+	if _, exists := entity.pages[8]; exists {
+		t.Error("Prefetched page survived with the cache full of dirty pages")
+	}
+	if entity.readAhead.fetchedTo != 8 {
+		t.Errorf("fetchedTo = %d after eviction, want 8", entity.readAhead.fetchedTo)
+	}
+}
+
+func TestFdEntity_WaitReadAhead(t *testing.T) {
+	entity := newReadAheadEntity(100)
+	ctx := context.Background()
+
+	if entity.WaitReadAhead(ctx, 0) {
+		t.Error("WaitReadAhead waited with no prefetch in flight")
+	}
+	start, _, _ := entity.StartReadAhead(0, 4, 8)
+	if entity.WaitReadAhead(ctx, 40) {
+		t.Error("WaitReadAhead waited for a prefetch that does not cover the offset")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		entity.FinishReadAhead(start, []byte("abcdefgh"), nil)
+	}()
+	if !entity.WaitReadAhead(ctx, 4) {
+		t.Fatal("WaitReadAhead did not wait for the prefetch covering the offset")
+	}
+	if data, found := entity.ReadCachedRange(4, 4); !found || string(data) != "abcd" {
+		t.Errorf("ReadCachedRange after WaitReadAhead = %q, %v; want %q", data, found, "abcd")
+	}
+}
@@ -1,8 +1,13 @@
 package cache
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
 )
 
 // StatCacheEntry represents a cached stat entry
@@ -11,37 +16,73 @@ type StatCacheEntry struct {
 	Attr      *CachedAttr
 	Metadata  map[string]string
 	Symlink   string // For symlink cache
+	Negative  bool   // Path is known not to exist
 	ExpiresAt time.Time
 	LastAccess time.Time
 }
 
 // CachedAttr represents cached file attributes
 type CachedAttr struct {
-	Mode  uint32
-	Size  int64
-	Mtime time.Time
-	Uid   uint32
-	Gid   uint32
+	Mode        uint32
+	Size        int64
+	Mtime       time.Time
+	Ctime       time.Time
+	Atime       time.Time
+	Uid         uint32
+	Gid         uint32
+	ETag        string // Backend ETag of the content (empty = unknown)
+	ContentType string // MIME type the object is stored with (empty = unknown)
 }
 
+// DefaultStatCacheShards is the number of lock shards used by NewStatCache
+const DefaultStatCacheShards = 16
+
 // StatCache manages cached file attributes
+// Entries are spread over shards by path hash, each with its own lock and LRU,
+// so concurrent lookups of different paths rarely contend
 type StatCache struct {
-	mu            sync.RWMutex
-	entries       map[string]*StatCacheEntry
+	mu            sync.Mutex // Serializes SetMaxSize
+	shards        []*statCacheShard
 	maxSize       int
-	defaultTTL    time.Duration
+	defaultTTL    atomic.Int64 // time.Duration
+	negativeTTL   atomic.Int64 // time.Duration; TTL for negative entries (0 disables them)
+	keepExpired   atomic.Bool  // Expired entries stay until evicted, for GetStale
 	cleanupTicker *time.Ticker
 	stopCleanup   chan struct{}
 }
 
-// NewStatCache creates a new stat cache
+// statCacheShard holds the entries for one slice of the path space
+type statCacheShard struct {
+	mu      sync.Mutex
+	entries map[string]*StatCacheEntry
+	maxSize int
+}
+
+// NewStatCache creates a new stat cache with DefaultStatCacheShards shards
 func NewStatCache(maxSize int, defaultTTL time.Duration) *StatCache {
+	return NewStatCacheWithShards(maxSize, defaultTTL, DefaultStatCacheShards)
+}
+
+// NewStatCacheWithShards creates a new stat cache split into the given number of shards
+// maxSize is divided evenly between shards, so eviction is LRU within each shard;
+// the shard count is capped at maxSize so every shard can hold an entry
+func NewStatCacheWithShards(maxSize int, defaultTTL time.Duration, shards int) *StatCache {
+	if shards > maxSize {
+		shards = maxSize
+	}
+	if shards < 1 {
+		shards = 1
+	}
+
 	sc := &StatCache{
-		entries:    make(map[string]*StatCacheEntry),
-		maxSize:    maxSize,
-		defaultTTL: defaultTTL,
+		shards:      make([]*statCacheShard, shards),
 		stopCleanup: make(chan struct{}),
 	}
+	for i := range sc.shards {
+		sc.shards[i] = &statCacheShard{entries: make(map[string]*StatCacheEntry)}
+	}
+	sc.defaultTTL.Store(int64(defaultTTL))
+	sc.setMaxSize(maxSize)
 
 	// Start cleanup goroutine
 	sc.cleanupTicker = time.NewTicker(defaultTTL / 2)
@@ -50,69 +91,135 @@ func NewStatCache(maxSize int, defaultTTL time.Duration) *StatCache {
 	return sc
 }
 
+// shardFor returns the shard owning path (FNV-1a hash)
+func (sc *StatCache) shardFor(path string) *statCacheShard {
+	if len(sc.shards) == 1 {
+		return sc.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(path); i++ {
+		h ^= uint32(path[i])
+		h *= 16777619
+	}
+	return sc.shards[h%uint32(len(sc.shards))]
+}
+
 // Get retrieves a cached stat entry
 func (sc *StatCache) Get(path string) (*StatCacheEntry, bool) {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
+	shard := sc.shardFor(path)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	entry, exists := sc.entries[path]
+	entry, exists := shard.entries[path]
 	if !exists {
+		metrics.StatCacheMiss()
 		return nil, false
 	}
 
 	// Check if expired
 	if time.Now().After(entry.ExpiresAt) {
+		metrics.StatCacheMiss()
+		return nil, false
+	}
+	if entry.Negative {
+		metrics.StatCacheMiss()
 		return nil, false
 	}
 
 	// Update last access time
 	entry.LastAccess = time.Now()
+	metrics.StatCacheHit()
 	return entry, true
 }
 
-// Set stores a stat entry in cache
-func (sc *StatCache) Set(path string, attr *CachedAttr, metadata map[string]string) {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
+// GetStale retrieves a cached stat entry even if it has expired
+// Only finds expired entries while SetKeepExpired is on; used to answer lookups
+// when storage is unreachable
+func (sc *StatCache) GetStale(path string) (*StatCacheEntry, bool) {
+	shard := sc.shardFor(path)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[path]
+	if !exists || entry.Negative || entry.Attr == nil {
+		return nil, false
+	}
+	entry.LastAccess = time.Now()
+	return entry, true
+}
 
-	// Truncate cache if needed
-	sc.truncateIfNeeded()
+// SetNegative records that path does not exist
+// Does nothing unless a negative TTL is set; any Set/Delete of path replaces the entry
+func (sc *StatCache) SetNegative(path string) {
+	negativeTTL := time.Duration(sc.negativeTTL.Load())
+	if negativeTTL <= 0 {
+		return
+	}
 
-	entry := &StatCacheEntry{
-		Path:      path,
-		Attr:      attr,
-		Metadata:  metadata,
-		ExpiresAt: time.Now().Add(sc.defaultTTL),
+	sc.shardFor(path).store(&StatCacheEntry{
+		Path:       path,
+		Negative:   true,
+		ExpiresAt:  time.Now().Add(negativeTTL),
 		LastAccess: time.Now(),
+	})
+}
+
+// IsNegative reports whether path is cached as not existing
+func (sc *StatCache) IsNegative(path string) bool {
+	shard := sc.shardFor(path)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[path]
+	if !exists || !entry.Negative {
+		return false
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		return false
 	}
 
-	sc.entries[path] = entry
+	entry.LastAccess = time.Now()
+	return true
 }
 
-// SetSymlink stores a symlink target in cache
-func (sc *StatCache) SetSymlink(path string, target string) {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
+// Set stores a stat entry in cache
+func (sc *StatCache) Set(path string, attr *CachedAttr, metadata map[string]string) {
+	sc.SetWithTTL(path, attr, metadata, 0)
+}
 
-	// Truncate cache if needed
-	sc.truncateIfNeeded()
+// SetWithTTL stores a stat entry in cache with a per-entry TTL
+// A non-positive ttl falls back to the default TTL
+func (sc *StatCache) SetWithTTL(path string, attr *CachedAttr, metadata map[string]string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = time.Duration(sc.defaultTTL.Load())
+	}
 
-	entry := &StatCacheEntry{
-		Path:      path,
-		Symlink:   target,
-		ExpiresAt: time.Now().Add(sc.defaultTTL),
+	sc.shardFor(path).store(&StatCacheEntry{
+		Path:       path,
+		Attr:       attr,
+		Metadata:   metadata,
+		ExpiresAt:  time.Now().Add(ttl),
 		LastAccess: time.Now(),
-	}
+	})
+}
 
-	sc.entries[path] = entry
+// SetSymlink stores a symlink target in cache
+func (sc *StatCache) SetSymlink(path string, target string) {
+	sc.shardFor(path).store(&StatCacheEntry{
+		Path:       path,
+		Symlink:    target,
+		ExpiresAt:  time.Now().Add(time.Duration(sc.defaultTTL.Load())),
+		LastAccess: time.Now(),
+	})
 }
 
 // GetSymlink retrieves a cached symlink target
 func (sc *StatCache) GetSymlink(path string) (string, bool) {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
+	shard := sc.shardFor(path)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	entry, exists := sc.entries[path]
+	entry, exists := shard.entries[path]
 	if !exists {
 		return "", false
 	}
@@ -133,76 +240,116 @@ func (sc *StatCache) GetSymlink(path string) (string, bool) {
 
 // Delete removes an entry from cache
 func (sc *StatCache) Delete(path string) {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
-	delete(sc.entries, path)
+	shard := sc.shardFor(path)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	delete(shard.entries, path)
+}
+
+// DeletePrefix removes every entry whose path starts with prefix
+func (sc *StatCache) DeletePrefix(prefix string) {
+	for _, shard := range sc.shards {
+		shard.mu.Lock()
+		for path := range shard.entries {
+			if strings.HasPrefix(path, prefix) {
+				delete(shard.entries, path)
+			}
+		}
+		shard.mu.Unlock()
+	}
 }
 
 // Clear removes all entries from cache
 func (sc *StatCache) Clear() {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
-	sc.entries = make(map[string]*StatCacheEntry)
+	for _, shard := range sc.shards {
+		shard.mu.Lock()
+		shard.entries = make(map[string]*StatCacheEntry)
+		shard.mu.Unlock()
+	}
 }
 
 // Size returns the current number of cached entries
 func (sc *StatCache) Size() int {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
-	return len(sc.entries)
+	size := 0
+	for _, shard := range sc.shards {
+		shard.mu.Lock()
+		size += len(shard.entries)
+		shard.mu.Unlock()
+	}
+	return size
 }
 
 // SetMaxSize updates the maximum cache size
 func (sc *StatCache) SetMaxSize(maxSize int) {
+	sc.setMaxSize(maxSize)
+}
+
+// setMaxSize splits maxSize between the shards and truncates any shard over its share
+func (sc *StatCache) setMaxSize(maxSize int) {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
 	sc.maxSize = maxSize
-	sc.truncateIfNeeded()
+
+	n := len(sc.shards)
+	for i, shard := range sc.shards {
+		share := maxSize / n
+		if i < maxSize%n {
+			share++
+		}
+		shard.mu.Lock()
+		shard.maxSize = share
+		shard.truncate(share)
+		shard.mu.Unlock()
+	}
 }
 
 // SetTTL updates the default TTL
 func (sc *StatCache) SetTTL(ttl time.Duration) {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
-	sc.defaultTTL = ttl
+	sc.defaultTTL.Store(int64(ttl))
 }
 
-// truncateIfNeeded removes oldest entries if cache exceeds max size
-func (sc *StatCache) truncateIfNeeded() {
-	if len(sc.entries) < sc.maxSize {
-		return
-	}
+// SetNegativeTTL sets how long missing paths are remembered (0 disables negative caching)
+func (sc *StatCache) SetNegativeTTL(ttl time.Duration) {
+	sc.negativeTTL.Store(int64(ttl))
+}
 
-	// Find entries to remove (oldest last access time)
-	type entryWithTime struct {
-		path       string
-		lastAccess time.Time
-	}
+// SetKeepExpired stops the periodic cleanup from removing expired attribute
+// entries, so GetStale can still find them; the size limit still applies
+func (sc *StatCache) SetKeepExpired(keep bool) {
+	sc.keepExpired.Store(keep)
+}
 
-	entries := make([]entryWithTime, 0, len(sc.entries))
-	for path, entry := range sc.entries {
-		entries = append(entries, entryWithTime{
-			path:       path,
-			lastAccess: entry.LastAccess,
-		})
+// store inserts entry, evicting the least recently used entries if the shard is full
+func (s *statCacheShard) store(entry *StatCacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize <= 0 {
+		return
 	}
+	// Replacing an existing path doesn't grow the shard
+	if _, exists := s.entries[entry.Path]; !exists {
+		s.truncate(s.maxSize - 1)
+	}
+	s.entries[entry.Path] = entry
+}
 
-	// Sort by last access time (oldest first)
-	// Simple selection sort for small caches
-	for i := 0; i < len(entries)-1; i++ {
-		minIdx := i
-		for j := i + 1; j < len(entries); j++ {
-			if entries[j].lastAccess.Before(entries[minIdx].lastAccess) {
-				minIdx = j
+// truncate removes the least recently used entries until at most limit remain
+// Caller must hold s.mu
+func (s *statCacheShard) truncate(limit int) {
+	for len(s.entries) > limit && len(s.entries) > 0 {
+		var oldestPath string
+		var oldest time.Time
+		first := true
+		for path, entry := range s.entries {
+			if first || entry.LastAccess.Before(oldest) {
+				oldestPath = path
+				oldest = entry.LastAccess
+				first = false
 			}
 		}
-		entries[i], entries[minIdx] = entries[minIdx], entries[i]
-	}
-
-	// Remove oldest entries
-	toRemove := len(sc.entries) - sc.maxSize + 1
-	for i := 0; i < toRemove && i < len(entries); i++ {
-		delete(sc.entries, entries[i].path)
+		delete(s.entries, oldestPath)
+		logging.Debug("stat cache evicted entry", "path", oldestPath)
 	}
 }
 
@@ -211,14 +358,20 @@ func (sc *StatCache) cleanupExpired() {
 	for {
 		select {
 		case <-sc.cleanupTicker.C:
-			sc.mu.Lock()
 			now := time.Now()
-			for path, entry := range sc.entries {
-				if now.After(entry.ExpiresAt) {
-					delete(sc.entries, path)
+			keepExpired := sc.keepExpired.Load()
+			for _, shard := range sc.shards {
+				shard.mu.Lock()
+				for path, entry := range shard.entries {
+					if keepExpired && entry.Attr != nil && !entry.Negative {
+						continue
+					}
+					if now.After(entry.ExpiresAt) {
+						delete(shard.entries, path)
+					}
 				}
+				shard.mu.Unlock()
 			}
-			sc.mu.Unlock()
 		case <-sc.stopCleanup:
 			return
 		}
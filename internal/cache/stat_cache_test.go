@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -102,6 +104,28 @@ func TestStatCache_Delete(t *testing.T) {
 	}
 }
 
+func TestStatCache_DeletePrefix(t *testing.T) {
+	cache := NewStatCache(100, 5*time.Minute)
+	defer cache.Close()
+
+	for _, path := range []string{"/dir", "/dir/a.txt", "/dir/sub/b.txt", "/dirt.txt"} {
+		cache.Set(path, &CachedAttr{}, nil)
+	}
+
+	cache.DeletePrefix("/dir/")
+
+	for _, path := range []string{"/dir/a.txt", "/dir/sub/b.txt"} {
+		if _, ok := cache.Get(path); ok {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	for _, path := range []string{"/dir", "/dirt.txt"} {
+		if _, ok := cache.Get(path); !ok {
+			t.Errorf("Expected %s to be kept", path)
+		}
+	}
+}
+
 func TestStatCache_Clear(t *testing.T) {
 	cache := NewStatCache(100, 5*time.Minute)
 	defer cache.Close()
@@ -267,3 +291,162 @@ func TestStatCache_LastAccess(t *testing.T) {
 		t.Error("Last access time should be updated on Get")
 	}
 }
+
+func TestStatCache_Negative(t *testing.T) {
+	cache := NewStatCache(100, time.Minute)
+	defer cache.Close()
+
+	// Disabled by default
+	cache.SetNegative("/missing.txt")
+	if cache.IsNegative("/missing.txt") {
+		t.Fatal("Negative entries must not be stored without a negative TTL")
+	}
+
+	cache.SetNegativeTTL(100 * time.Millisecond)
+	cache.SetNegative("/missing.txt")
+	if !cache.IsNegative("/missing.txt") {
+		t.Fatal("Expected negative entry to be found")
+	}
+	if _, found := cache.Get("/missing.txt"); found {
+		t.Error("Get must not return negative entries")
+	}
+
+	// Storing real attributes replaces the negative entry
+	cache.Set("/missing.txt", &CachedAttr{Mode: 0644}, nil)
+	if cache.IsNegative("/missing.txt") {
+		t.Error("Set should replace the negative entry")
+	}
+
+	cache.SetNegative("/gone.txt")
+	time.Sleep(150 * time.Millisecond)
+	if cache.IsNegative("/gone.txt") {
+		t.Error("Negative entry should have expired")
+	}
+}
+
+func TestStatCache_ShardedTruncation(t *testing.T) {
+	cache := NewStatCacheWithShards(8, 5*time.Minute, 4)
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprintf("/file%d", i), &CachedAttr{Size: int64(i)}, nil)
+	}
+	if cache.Size() > 8 {
+		t.Errorf("Expected cache size <= 8, got %d", cache.Size())
+	}
+
+	// The most recent entry always survives eviction in its shard
+	if _, ok := cache.Get("/file99"); !ok {
+		t.Error("Most recently set entry was evicted")
+	}
+
+	// More shards than entries are clamped so every shard can hold one
+	small := NewStatCacheWithShards(2, 5*time.Minute, 16)
+	defer small.Close()
+	small.Set("/a", &CachedAttr{}, nil)
+	small.Set("/b", &CachedAttr{}, nil)
+	if _, ok := small.Get("/b"); !ok {
+		t.Error("Entry missing from clamped cache")
+	}
+}
+
+// Run with -race: Get/IsNegative/GetSymlink update LastAccess under the shard lock
+func TestStatCache_ConcurrentAccess(t *testing.T) {
+	cache := NewStatCache(64, 5*time.Minute)
+	defer cache.Close()
+	cache.SetNegativeTTL(time.Minute)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				path := fmt.Sprintf("/dir/file%d", (g*31+i)%100)
+				switch i % 6 {
+				case 0:
+					cache.Set(path, &CachedAttr{Size: int64(i)}, nil)
+				case 1:
+					if entry, ok := cache.Get(path); ok && entry.Path != path {
+						t.Errorf("Get(%s) returned entry for %s", path, entry.Path)
+					}
+				case 2:
+					cache.SetNegative(path)
+				case 3:
+					cache.IsNegative(path)
+				case 4:
+					cache.SetSymlink(path, "/target")
+					cache.GetSymlink(path)
+				case 5:
+					cache.Delete(path)
+				}
+				if i%100 == 0 {
+					cache.SetMaxSize(32 + g)
+					cache.Size()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if cache.Size() > 64 {
+		t.Errorf("Expected cache size <= 64, got %d", cache.Size())
+	}
+}
+
+func benchmarkStatCache(b *testing.B, shards int) {
+	cache := NewStatCacheWithShards(10000, 5*time.Minute, shards)
+	defer cache.Close()
+
+	paths := make([]string, 1000)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/bench/dir%d/file%d", i%10, i)
+		cache.Set(paths[i], &CachedAttr{Size: int64(i)}, nil)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			path := paths[i%len(paths)]
+			// Mostly lookups, with occasional updates as GetAttr traffic would produce
+			if i%10 == 0 {
+				cache.Set(path, &CachedAttr{Size: int64(i)}, nil)
+			} else {
+				cache.Get(path)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkStatCache_SingleLock(b *testing.B) {
+	benchmarkStatCache(b, 1)
+}
+
+func BenchmarkStatCache_Sharded(b *testing.B) {
+	benchmarkStatCache(b, DefaultStatCacheShards)
+}
+
+func TestStatCache_GetStale(t *testing.T) {
+	cache := NewStatCache(100, 20*time.Millisecond)
+	defer cache.Close()
+	cache.SetKeepExpired(true)
+
+	cache.Set("/kept.txt", &CachedAttr{Mode: 0644, Size: 42}, nil)
+	time.Sleep(60 * time.Millisecond) // Past expiry and several cleanup ticks
+
+	if _, found := cache.Get("/kept.txt"); found {
+		t.Error("Expired entry returned by Get")
+	}
+	entry, found := cache.GetStale("/kept.txt")
+	if !found || entry.Attr.Size != 42 {
+		t.Fatalf("GetStale = %+v, %v; want the expired entry", entry, found)
+	}
+
+	cache.SetKeepExpired(false)
+	time.Sleep(30 * time.Millisecond)
+	if _, found := cache.GetStale("/kept.txt"); found {
+		t.Error("Expired entry not cleaned up after SetKeepExpired(false)")
+	}
+}
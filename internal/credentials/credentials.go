@@ -1,9 +1,24 @@
 package credentials
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// Credential sources
+const (
+	SourcePasswdFile   = "passwd file"
+	SourceEnvironment  = "environment"
+	SourceSharedConfig = "shared credentials file"
+	SourceECSTaskRole  = "ECS task role"
+	SourceIMDS         = "EC2 instance profile (IMDS)"
 )
 
 // Credentials holds AWS credentials
@@ -12,6 +27,13 @@ type Credentials struct {
 	SecretAccessKey string
 	SessionToken    string
 	Region          string
+
+	// Source describes where the credentials were loaded from (see Source* constants)
+	Source string
+
+	// provider refreshes temporary credentials (instance profile, task role)
+	// nil for static credentials
+	provider aws.CredentialsProvider
 }
 
 // NewCredentials creates a new credentials instance
@@ -19,21 +41,173 @@ func NewCredentials() *Credentials {
 	return &Credentials{}
 }
 
+// systemPasswdFile is the system-wide passwd file, which may be group-readable
+const systemPasswdFile = "/etc/passwd-s3fs"
+
+// Keys of the AWS key=value passwd file form
+const (
+	awsAccessKeyField    = "AWSAccessKeyId"
+	awsSecretKeyField    = "AWSSecretKey"
+	awsSessionTokenField = "AWSSessionToken"
+)
+
 // LoadFromPasswdFile loads credentials from a passwd file in format ACCESS_KEY:SECRET_KEY
 func (c *Credentials) LoadFromPasswdFile(path string) error {
+	return c.LoadFromPasswdFileForBucket(path, "")
+}
+
+// LoadFromPasswdFileForBucket loads credentials for bucket from an s3fs-fuse style passwd file
+// Lines are either ACCESS_KEY:SECRET_KEY (default entry) or BUCKET:ACCESS_KEY:SECRET_KEY;
+// an entry for bucket wins over the default one. Blank lines and # comments are ignored.
+// The AWS key=value form (AWSAccessKeyId=, AWSSecretKey=, optionally AWSSessionToken=)
+// is also accepted and acts as the default entry.
+// Like s3fs-fuse, the file must not be accessible by others (nor by the group, except /etc/passwd-s3fs)
+func (c *Credentials) LoadFromPasswdFileForBucket(path, bucket string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read passwd file: %w", err)
+	}
+	if err := checkPasswdFilePermissions(path, info.Mode()); err != nil {
+		return err
+	}
+
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read passwd file: %w", err)
 	}
 
-	content := strings.TrimSpace(string(data))
-	parts := strings.Split(content, ":")
-	if len(parts) != 2 {
+	var defaultEntry, bucketEntry []string
+	awsEntry := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, "="); ok {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			switch key {
+			case awsAccessKeyField, awsSecretKeyField, awsSessionTokenField:
+				awsEntry[key] = value
+			default:
+				return fmt.Errorf("invalid passwd file format at line %d: unknown key %q, expected %s, %s or %s",
+					lineNum, key, awsAccessKeyField, awsSecretKeyField, awsSessionTokenField)
+			}
+			continue
+		}
+
+		parts := strings.Split(line, ":")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		switch len(parts) {
+		case 2:
+			if err := checkPasswdEntry(parts, lineNum); err != nil {
+				return err
+			}
+			if defaultEntry == nil {
+				defaultEntry = parts
+			}
+		case 3:
+			if parts[0] == "" {
+				return fmt.Errorf("invalid passwd file format at line %d: bucket name is empty", lineNum)
+			}
+			if err := checkPasswdEntry(parts[1:], lineNum); err != nil {
+				return err
+			}
+			if bucket != "" && parts[0] == bucket && bucketEntry == nil {
+				bucketEntry = parts[1:]
+			}
+		default:
+			return fmt.Errorf("invalid passwd file format at line %d, expected ACCESS_KEY:SECRET_KEY or BUCKET:ACCESS_KEY:SECRET_KEY", lineNum)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read passwd file: %w", err)
+	}
+
+	sessionToken := ""
+	if len(awsEntry) > 0 && bucketEntry == nil {
+		if defaultEntry != nil {
+			return fmt.Errorf("invalid passwd file format: both ACCESS_KEY:SECRET_KEY and %s= default entries", awsAccessKeyField)
+		}
+		if err := validateKeys(awsEntry[awsAccessKeyField], awsEntry[awsSecretKeyField]); err != nil {
+			return fmt.Errorf("invalid passwd file format: %w (%s=, %s=)", err, awsAccessKeyField, awsSecretKeyField)
+		}
+		defaultEntry = []string{awsEntry[awsAccessKeyField], awsEntry[awsSecretKeyField]}
+		sessionToken = awsEntry[awsSessionTokenField]
+	}
+
+	entry := bucketEntry
+	if entry == nil {
+		entry = defaultEntry
+	}
+	if entry == nil {
+		if bucket != "" {
+			return fmt.Errorf("no credentials for bucket %s in passwd file", bucket)
+		}
 		return fmt.Errorf("invalid passwd file format, expected ACCESS_KEY:SECRET_KEY")
 	}
 
-	c.AccessKeyID = strings.TrimSpace(parts[0])
-	c.SecretAccessKey = strings.TrimSpace(parts[1])
+	c.AccessKeyID = entry[0]
+	c.SecretAccessKey = entry[1]
+	c.SessionToken = sessionToken
+	c.Source = SourcePasswdFile
+
+	return nil
+}
+
+// checkPasswdEntry rejects an ACCESS_KEY:SECRET_KEY pair with an empty field
+func checkPasswdEntry(entry []string, lineNum int) error {
+	if err := validateKeys(entry[0], entry[1]); err != nil {
+		return fmt.Errorf("invalid passwd file format at line %d: %w", lineNum, err)
+	}
+	return nil
+}
+
+// checkPasswdFilePermissions rejects passwd files that other users can access
+func checkPasswdFilePermissions(path string, mode os.FileMode) error {
+	perm := mode.Perm()
+	if perm&0007 != 0 {
+		return fmt.Errorf("passwd file %s should not have others permissions (mode %04o)", path, perm)
+	}
+	if perm&0070 != 0 && path != systemPasswdFile {
+		return fmt.Errorf("passwd file %s should not have group permissions (mode %04o)", path, perm)
+	}
+	return nil
+}
+
+// LoadFromSharedConfig loads a profile from the shared credentials file (~/.aws/credentials)
+// An empty profile uses AWS_PROFILE, or "default"; AWS_SHARED_CREDENTIALS_FILE overrides the path
+func (c *Credentials) LoadFromSharedConfig(profile string) error {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = config.DefaultSharedCredentialsFilename()
+	}
+
+	shared, err := config.LoadSharedConfigProfile(context.Background(), profile, func(o *config.LoadSharedConfigOptions) {
+		o.CredentialsFiles = []string{path}
+		o.ConfigFiles = []string{}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load profile %q from %s: %w", profile, path, err)
+	}
+	if !shared.Credentials.HasKeys() {
+		return fmt.Errorf("profile %q in %s has no access keys", profile, path)
+	}
+
+	c.AccessKeyID = shared.Credentials.AccessKeyID
+	c.SecretAccessKey = shared.Credentials.SecretAccessKey
+	c.SessionToken = shared.Credentials.SessionToken
+	c.Source = SourceSharedConfig
 
 	return nil
 }
@@ -44,18 +218,48 @@ func (c *Credentials) LoadFromEnvironment() error {
 	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
 	sessionToken := os.Getenv("AWS_SESSION_TOKEN")
 
-	if accessKey == "" || secretKey == "" {
+	switch {
+	case accessKey == "" && secretKey == "":
 		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	case accessKey == "":
+		return fmt.Errorf("AWS_ACCESS_KEY_ID is not set (AWS_SECRET_ACCESS_KEY is)")
+	case secretKey == "":
+		return fmt.Errorf("AWS_SECRET_ACCESS_KEY is not set (AWS_ACCESS_KEY_ID is)")
 	}
 
 	c.AccessKeyID = accessKey
 	c.SecretAccessKey = secretKey
 	c.SessionToken = sessionToken
+	c.Source = SourceEnvironment
 
 	return nil
 }
 
 // IsValid checks if credentials are valid (both access key and secret are set)
 func (c *Credentials) IsValid() bool {
-	return c.AccessKeyID != "" && c.SecretAccessKey != ""
+	return c.Validate() == nil
+}
+
+// Validate returns an error naming the missing key, or nil if both keys are set
+func (c *Credentials) Validate() error {
+	if err := validateKeys(c.AccessKeyID, c.SecretAccessKey); err != nil {
+		if c.Source != "" {
+			return fmt.Errorf("%w in credentials from %s", err, c.Source)
+		}
+		return err
+	}
+	return nil
+}
+
+// validateKeys reports which of the two keys is empty
+func validateKeys(accessKey, secretKey string) error {
+	switch {
+	case accessKey == "" && secretKey == "":
+		return fmt.Errorf("access key ID and secret access key are missing")
+	case accessKey == "":
+		return fmt.Errorf("access key ID is missing")
+	case secretKey == "":
+		return fmt.Errorf("secret access key is missing")
+	}
+	return nil
 }
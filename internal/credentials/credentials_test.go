@@ -92,3 +92,228 @@ func TestIsValid(t *testing.T) {
 		t.Error("Expected valid credentials, got invalid")
 	}
 }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		creds   Credentials
+		wantErr string
+	}{
+		{"valid", Credentials{AccessKeyID: "KEY", SecretAccessKey: "SECRET"}, ""},
+		{"empty", Credentials{}, "access key ID and secret access key are missing"},
+		{"no access key", Credentials{SecretAccessKey: "SECRET"}, "access key ID is missing"},
+		{"no secret", Credentials{AccessKeyID: "KEY"}, "secret access key is missing"},
+		{"with source", Credentials{AccessKeyID: "KEY", Source: SourceSharedConfig}, "secret access key is missing in credentials from shared credentials file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.creds.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnvironmentMissingKey(t *testing.T) {
+	tests := []struct {
+		name, accessKey, secretKey, wantErr string
+	}{
+		{"none", "", "", "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set"},
+		{"no access key", "", "SECRET", "AWS_ACCESS_KEY_ID is not set (AWS_SECRET_ACCESS_KEY is)"},
+		{"no secret", "KEY", "", "AWS_SECRET_ACCESS_KEY is not set (AWS_ACCESS_KEY_ID is)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", tt.accessKey)
+			t.Setenv("AWS_SECRET_ACCESS_KEY", tt.secretKey)
+			err := NewCredentials().LoadFromEnvironment()
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("LoadFromEnvironment() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFromPasswdFileMalformedLine(t *testing.T) {
+	tests := []struct {
+		name, content, wantErr string
+	}{
+		{"too many fields", "# comment\nA:B:C:D\n", "invalid passwd file format at line 2, expected ACCESS_KEY:SECRET_KEY or BUCKET:ACCESS_KEY:SECRET_KEY"},
+		{"no separator", "\n\nKEYONLY\n", "invalid passwd file format at line 3, expected ACCESS_KEY:SECRET_KEY or BUCKET:ACCESS_KEY:SECRET_KEY"},
+		{"empty secret", "KEY:\n", "invalid passwd file format at line 1: secret access key is missing"},
+		{"empty access key", "KEY:SECRET\n:SECRET\n", "invalid passwd file format at line 2: access key ID is missing"},
+		{"empty bucket entry keys", "bucket: : \n", "invalid passwd file format at line 1: access key ID and secret access key are missing"},
+		{"empty bucket name", ":KEY:SECRET\n", "invalid passwd file format at line 1: bucket name is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passwdFile := writePasswdFile(t, tt.content, 0600)
+			err := NewCredentials().LoadFromPasswdFile(passwdFile)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("LoadFromPasswdFile() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func writePasswdFile(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	passwdFile := filepath.Join(t.TempDir(), ".passwd-s3fs")
+	if err := os.WriteFile(passwdFile, []byte(content), perm); err != nil {
+		t.Fatalf("Failed to create test passwd file: %v", err)
+	}
+	// WriteFile is subject to umask
+	if err := os.Chmod(passwdFile, perm); err != nil {
+		t.Fatalf("Failed to chmod test passwd file: %v", err)
+	}
+	return passwdFile
+}
+
+func TestLoadFromPasswdFileForBucket(t *testing.T) {
+	passwdFile := writePasswdFile(t, `# s3fs-fuse credentials
+DEFAULT_KEY:DEFAULT_SECRET
+
+other-bucket:OTHER_KEY:OTHER_SECRET
+my-bucket:BUCKET_KEY:BUCKET_SECRET
+`, 0600)
+
+	tests := []struct {
+		bucket         string
+		expectedKey    string
+		expectedSecret string
+	}{
+		{"my-bucket", "BUCKET_KEY", "BUCKET_SECRET"},
+		{"other-bucket", "OTHER_KEY", "OTHER_SECRET"},
+		{"unlisted-bucket", "DEFAULT_KEY", "DEFAULT_SECRET"},
+		{"", "DEFAULT_KEY", "DEFAULT_SECRET"},
+	}
+
+	for _, tt := range tests {
+		cred := NewCredentials()
+		if err := cred.LoadFromPasswdFileForBucket(passwdFile, tt.bucket); err != nil {
+			t.Fatalf("%q: failed to load credentials: %v", tt.bucket, err)
+		}
+		if cred.AccessKeyID != tt.expectedKey || cred.SecretAccessKey != tt.expectedSecret {
+			t.Errorf("%q: expected %s:%s, got %s:%s", tt.bucket, tt.expectedKey, tt.expectedSecret, cred.AccessKeyID, cred.SecretAccessKey)
+		}
+	}
+}
+
+func TestLoadFromPasswdFileAWSFormat(t *testing.T) {
+	passwdFile := writePasswdFile(t, `# AWS key=value form
+AWSAccessKeyId=AWS_KEY
+AWSSecretKey = AWS_SECRET
+AWSSessionToken=AWS_TOKEN
+my-bucket:BUCKET_KEY:BUCKET_SECRET
+`, 0600)
+
+	cred := NewCredentials()
+	if err := cred.LoadFromPasswdFileForBucket(passwdFile, "other-bucket"); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if cred.AccessKeyID != "AWS_KEY" || cred.SecretAccessKey != "AWS_SECRET" || cred.SessionToken != "AWS_TOKEN" {
+		t.Errorf("Unexpected default credentials: %+v", cred)
+	}
+
+	// A bucket entry still wins over the key=value default
+	cred = NewCredentials()
+	if err := cred.LoadFromPasswdFileForBucket(passwdFile, "my-bucket"); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if cred.AccessKeyID != "BUCKET_KEY" || cred.SecretAccessKey != "BUCKET_SECRET" || cred.SessionToken != "" {
+		t.Errorf("Unexpected bucket credentials: %+v", cred)
+	}
+}
+
+func TestLoadFromPasswdFileAWSFormatInvalid(t *testing.T) {
+	tests := []struct {
+		name, content, wantErr string
+	}{
+		{"unknown key", "AWSAccessKeyId=KEY\nRegion=us-east-1\n", `invalid passwd file format at line 2: unknown key "Region", expected AWSAccessKeyId, AWSSecretKey or AWSSessionToken`},
+		{"no secret", "AWSAccessKeyId=KEY\n", "invalid passwd file format: secret access key is missing (AWSAccessKeyId=, AWSSecretKey=)"},
+		{"mixed defaults", "KEY:SECRET\nAWSAccessKeyId=KEY\nAWSSecretKey=SECRET\n", "invalid passwd file format: both ACCESS_KEY:SECRET_KEY and AWSAccessKeyId= default entries"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passwdFile := writePasswdFile(t, tt.content, 0600)
+			err := NewCredentials().LoadFromPasswdFile(passwdFile)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("LoadFromPasswdFile() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFromPasswdFileNoMatchingBucket(t *testing.T) {
+	passwdFile := writePasswdFile(t, "other-bucket:OTHER_KEY:OTHER_SECRET\n", 0600)
+
+	cred := NewCredentials()
+	if err := cred.LoadFromPasswdFileForBucket(passwdFile, "my-bucket"); err == nil {
+		t.Error("Expected error when no entry matches the bucket")
+	}
+}
+
+func TestLoadFromPasswdFilePermissions(t *testing.T) {
+	for _, perm := range []os.FileMode{0644, 0604, 0640} {
+		passwdFile := writePasswdFile(t, "TEST_ACCESS_KEY:TEST_SECRET_KEY", perm)
+
+		cred := NewCredentials()
+		if err := cred.LoadFromPasswdFile(passwdFile); err == nil {
+			t.Errorf("Expected mode %04o to be rejected", perm)
+		}
+	}
+
+	passwdFile := writePasswdFile(t, "TEST_ACCESS_KEY:TEST_SECRET_KEY", 0400)
+	cred := NewCredentials()
+	if err := cred.LoadFromPasswdFile(passwdFile); err != nil {
+		t.Errorf("Expected mode 0400 to be accepted, got %v", err)
+	}
+}
+
+func TestLoadFromSharedConfig(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	content := `[default]
+aws_access_key_id = DEFAULT_KEY
+aws_secret_access_key = DEFAULT_SECRET
+
+[work]
+aws_access_key_id = WORK_KEY
+aws_secret_access_key = WORK_SECRET
+aws_session_token = WORK_TOKEN
+`
+	if err := os.WriteFile(credentialsFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to create credentials file: %v", err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_PROFILE", "")
+
+	cred := NewCredentials()
+	if err := cred.LoadFromSharedConfig("work"); err != nil {
+		t.Fatalf("Failed to load profile: %v", err)
+	}
+	if cred.AccessKeyID != "WORK_KEY" || cred.SecretAccessKey != "WORK_SECRET" || cred.SessionToken != "WORK_TOKEN" {
+		t.Errorf("Unexpected credentials for profile work: %+v", cred)
+	}
+	if cred.Source != SourceSharedConfig {
+		t.Errorf("Expected source %q, got %q", SourceSharedConfig, cred.Source)
+	}
+
+	cred = NewCredentials()
+	if err := cred.LoadFromSharedConfig(""); err != nil {
+		t.Fatalf("Failed to load default profile: %v", err)
+	}
+	if cred.AccessKeyID != "DEFAULT_KEY" {
+		t.Errorf("Expected default profile, got %s", cred.AccessKeyID)
+	}
+
+	if err := NewCredentials().LoadFromSharedConfig("missing"); err == nil {
+		t.Error("Expected error for missing profile")
+	}
+}
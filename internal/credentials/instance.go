@@ -0,0 +1,107 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+const (
+	// ecsCredentialsHost serves AWS_CONTAINER_CREDENTIALS_RELATIVE_URI on ECS
+	ecsCredentialsHost = "http://169.254.170.2"
+	// imdsProbeTimeout bounds the IMDS probe so non-EC2 hosts fail fast
+	imdsProbeTimeout = 2 * time.Second
+)
+
+// LoadFromIMDS loads EC2 instance-profile credentials using the IMDSv2 token flow
+// The credentials are refreshed automatically as the instance profile rotates them
+// AWS_EC2_METADATA_SERVICE_ENDPOINT overrides the metadata endpoint
+func (c *Credentials) LoadFromIMDS(ctx context.Context) error {
+	imdsOptions := imds.Options{
+		Endpoint: os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"),
+	}
+	provider := ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		o.Client = imds.New(imdsOptions)
+	})
+
+	if err := c.loadFromProvider(ctx, provider, SourceIMDS); err != nil {
+		return fmt.Errorf("failed to load credentials from instance metadata: %w", err)
+	}
+	return nil
+}
+
+// LoadFromECSTaskRole loads ECS task-role credentials from the container credentials endpoint
+// Uses AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI,
+// with AWS_CONTAINER_AUTHORIZATION_TOKEN when set
+func (c *Credentials) LoadFromECSTaskRole(ctx context.Context) error {
+	endpoint := ecsCredentialsEndpoint()
+	if endpoint == "" {
+		return fmt.Errorf("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI must be set")
+	}
+
+	provider := endpointcreds.New(endpoint, func(o *endpointcreds.Options) {
+		o.AuthorizationToken = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	})
+
+	if err := c.loadFromProvider(ctx, provider, SourceECSTaskRole); err != nil {
+		return fmt.Errorf("failed to load ECS task role credentials: %w", err)
+	}
+	return nil
+}
+
+// LoadDefault picks the first available source: environment variables,
+// the shared credentials file, then the ECS task role, then the EC2 instance profile
+func (c *Credentials) LoadDefault(ctx context.Context) error {
+	envErr := c.LoadFromEnvironment()
+	if envErr == nil {
+		return nil
+	}
+	if err := c.LoadFromSharedConfig(""); err == nil {
+		return nil
+	}
+
+	if ecsCredentialsEndpoint() != "" {
+		return c.LoadFromECSTaskRole(ctx)
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, imdsProbeTimeout)
+	defer cancel()
+	if err := c.LoadFromIMDS(probeCtx); err != nil {
+		return fmt.Errorf("no credentials found (%v; %v)", envErr, err)
+	}
+	return nil
+}
+
+// ecsCredentialsEndpoint returns the ECS container credentials URL, or "" outside ECS
+func ecsCredentialsEndpoint() string {
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return ecsCredentialsHost + uri
+	}
+	return os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+}
+
+// loadFromProvider wraps a refreshing provider in a cache, retrieves the current
+// credentials to confirm the source works, and keeps the provider for later refreshes
+func (c *Credentials) loadFromProvider(ctx context.Context, provider aws.CredentialsProvider, source string) error {
+	cached := aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = DefaultExpiryWindow
+	})
+
+	creds, err := cached.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.AccessKeyID = creds.AccessKeyID
+	c.SecretAccessKey = creds.SecretAccessKey
+	c.SessionToken = creds.SessionToken
+	c.Source = source
+	c.provider = cached
+	return nil
+}
@@ -0,0 +1,162 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetadataCredentials returns numbered credentials expiring after lifetime
+type fakeMetadataCredentials struct {
+	mu       sync.Mutex
+	calls    int
+	lifetime time.Duration
+}
+
+func (f *fakeMetadataCredentials) next() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return map[string]string{
+		"Code":            "Success",
+		"AccessKeyId":     fmt.Sprintf("TEMP_KEY_%d", f.calls),
+		"SecretAccessKey": "TEMP_SECRET",
+		"Token":           "TEMP_TOKEN",
+		"Expiration":      time.Now().Add(f.lifetime).UTC().Format(time.RFC3339),
+	}
+}
+
+// newFakeIMDS emulates the IMDSv2 token flow and instance-profile credential endpoints
+func newFakeIMDS(t *testing.T, creds *fakeMetadataCredentials) *httptest.Server {
+	t.Helper()
+	const token = "imds-session-token"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "token requires PUT", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+		w.Write([]byte(token))
+	})
+	mux.HandleFunc("/latest/meta-data/iam/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aws-ec2-metadata-token") != token {
+			http.Error(w, "missing IMDSv2 token", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/latest/meta-data/iam/security-credentials/" {
+			w.Write([]byte("test-role"))
+			return
+		}
+		json.NewEncoder(w).Encode(creds.next())
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoadFromIMDS(t *testing.T) {
+	creds := &fakeMetadataCredentials{lifetime: time.Hour}
+	server := newFakeIMDS(t, creds)
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+
+	cred := NewCredentials()
+	if err := cred.LoadFromIMDS(context.Background()); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+
+	if cred.AccessKeyID != "TEMP_KEY_1" || cred.SessionToken != "TEMP_TOKEN" {
+		t.Errorf("Unexpected credentials: %s / %s", cred.AccessKeyID, cred.SessionToken)
+	}
+	if cred.Source != SourceIMDS {
+		t.Errorf("Expected source %q, got %q", SourceIMDS, cred.Source)
+	}
+	if !cred.IsValid() {
+		t.Error("Expected credentials to be valid")
+	}
+}
+
+func TestLoadFromIMDSRefreshes(t *testing.T) {
+	// Credentials are served already expired, so each retrieval rotates them
+	creds := &fakeMetadataCredentials{lifetime: -time.Minute}
+	server := newFakeIMDS(t, creds)
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+
+	cred := NewCredentials()
+	ctx := context.Background()
+	if err := cred.LoadFromIMDS(ctx); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+
+	refreshed, err := cred.Provider().Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh credentials: %v", err)
+	}
+	if refreshed.AccessKeyID == cred.AccessKeyID {
+		t.Errorf("Expected rotated credentials, got %s again", refreshed.AccessKeyID)
+	}
+}
+
+func TestLoadFromECSTaskRole(t *testing.T) {
+	creds := &fakeMetadataCredentials{lifetime: time.Hour}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "ecs-auth-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(creds.next())
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/v2/credentials/task")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "ecs-auth-token")
+
+	cred := NewCredentials()
+	if err := cred.LoadFromECSTaskRole(context.Background()); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if cred.AccessKeyID != "TEMP_KEY_1" {
+		t.Errorf("Unexpected access key %s", cred.AccessKeyID)
+	}
+	if cred.Source != SourceECSTaskRole {
+		t.Errorf("Expected source %q, got %q", SourceECSTaskRole, cred.Source)
+	}
+}
+
+func TestLoadDefaultSelection(t *testing.T) {
+	creds := &fakeMetadataCredentials{lifetime: time.Hour}
+	server := newFakeIMDS(t, creds)
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	// Environment variables win
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENV_KEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "ENV_SECRET")
+	cred := NewCredentials()
+	if err := cred.LoadDefault(context.Background()); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if cred.Source != SourceEnvironment {
+		t.Errorf("Expected source %q, got %q", SourceEnvironment, cred.Source)
+	}
+
+	// Without them, fall back to the instance profile
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	cred = NewCredentials()
+	if err := cred.LoadDefault(context.Background()); err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if cred.Source != SourceIMDS {
+		t.Errorf("Expected source %q, got %q", SourceIMDS, cred.Source)
+	}
+}
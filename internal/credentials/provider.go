@@ -0,0 +1,86 @@
+package credentials
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awscreds "github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// DefaultRoleSessionName is the session name used when assuming a role
+	DefaultRoleSessionName = "s3fs-go"
+	// DefaultRoleSessionDuration is the lifetime requested for assumed-role sessions
+	DefaultRoleSessionDuration = time.Hour
+	// DefaultExpiryWindow is how long before expiry credentials are refreshed
+	DefaultExpiryWindow = 5 * time.Minute
+)
+
+// Provider returns a credentials provider for these credentials
+// Temporary credentials (instance profile, task role) are refreshed as they rotate;
+// otherwise the loaded keys are served as static credentials
+func (c *Credentials) Provider() aws.CredentialsProvider {
+	if c.provider != nil {
+		return c.provider
+	}
+	return awscreds.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)
+}
+
+// AssumeRoleOptions configures an AssumeRole credentials provider
+type AssumeRoleOptions struct {
+	RoleARN         string        // IAM role to assume (required)
+	ExternalID      string        // External ID required by the role's trust policy (optional)
+	SessionName     string        // Role session name (default: DefaultRoleSessionName)
+	SessionDuration time.Duration // Requested session lifetime (default: DefaultRoleSessionDuration)
+	ExpiryWindow    time.Duration // Refresh this long before expiry (default: DefaultExpiryWindow)
+	Region          string        // STS region (default: us-east-1)
+	Endpoint        string        // Custom STS endpoint, e.g. LocalStack (optional)
+}
+
+// NewAssumeRoleProvider returns a provider that assumes opts.RoleARN using base credentials
+// Temporary credentials are cached and refreshed automatically before they expire,
+// so long-running mounts keep working without a remount
+func NewAssumeRoleProvider(base aws.CredentialsProvider, opts AssumeRoleOptions) (aws.CredentialsProvider, error) {
+	if opts.RoleARN == "" {
+		return nil, fmt.Errorf("role ARN is required")
+	}
+	if base == nil {
+		return nil, fmt.Errorf("base credentials are required to assume role %s", opts.RoleARN)
+	}
+	if opts.SessionName == "" {
+		opts.SessionName = DefaultRoleSessionName
+	}
+	if opts.SessionDuration <= 0 {
+		opts.SessionDuration = DefaultRoleSessionDuration
+	}
+	if opts.ExpiryWindow <= 0 {
+		opts.ExpiryWindow = DefaultExpiryWindow
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+
+	stsOptions := sts.Options{
+		Region:      opts.Region,
+		Credentials: base,
+	}
+	if opts.Endpoint != "" {
+		stsOptions.BaseEndpoint = aws.String(opts.Endpoint)
+	}
+	stsClient := sts.New(stsOptions)
+
+	provider := stscreds.NewAssumeRoleProvider(stsClient, opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = opts.SessionName
+		o.Duration = opts.SessionDuration
+		if opts.ExternalID != "" {
+			o.ExternalID = aws.String(opts.ExternalID)
+		}
+	})
+
+	return aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = opts.ExpiryWindow
+	}), nil
+}
@@ -0,0 +1,147 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSTS serves AssumeRole responses with sequentially numbered access keys
+type fakeSTS struct {
+	mu       sync.Mutex
+	calls    int
+	lifetime time.Duration
+	forms    []map[string]string
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.calls++
+	call := f.calls
+	f.forms = append(f.forms, map[string]string{
+		"Action":     r.Form.Get("Action"),
+		"RoleArn":    r.Form.Get("RoleArn"),
+		"ExternalId": r.Form.Get("ExternalId"),
+	})
+	f.mu.Unlock()
+
+	expiration := time.Now().Add(f.lifetime).UTC().Format(time.RFC3339)
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMED_KEY_%d</AccessKeyId>
+      <SecretAccessKey>ASSUMED_SECRET</SecretAccessKey>
+      <SessionToken>ASSUMED_TOKEN</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::000000000000:assumed-role/test/s3fs-go</Arn>
+      <AssumedRoleId>AROATEST:s3fs-go</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>test</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, call, expiration)
+}
+
+func newFakeSTSServer(t *testing.T, sts *fakeSTS) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(sts)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAssumeRoleProvider(t *testing.T) {
+	sts := &fakeSTS{lifetime: time.Hour}
+	server := newFakeSTSServer(t, sts)
+
+	base := &Credentials{AccessKeyID: "BASE_KEY", SecretAccessKey: "BASE_SECRET"}
+	provider, err := NewAssumeRoleProvider(base.Provider(), AssumeRoleOptions{
+		RoleARN:    "arn:aws:iam::000000000000:role/test",
+		ExternalID: "external-123",
+		Endpoint:   server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	ctx := context.Background()
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Failed to retrieve credentials: %v", err)
+	}
+	if creds.AccessKeyID != "ASSUMED_KEY_1" || creds.SessionToken != "ASSUMED_TOKEN" {
+		t.Errorf("Unexpected credentials: %+v", creds)
+	}
+
+	// Still valid - served from cache
+	if _, err := provider.Retrieve(ctx); err != nil {
+		t.Fatalf("Failed to retrieve credentials: %v", err)
+	}
+	if sts.calls != 1 {
+		t.Errorf("Expected 1 AssumeRole call, got %d", sts.calls)
+	}
+
+	form := sts.forms[0]
+	if form["Action"] != "AssumeRole" {
+		t.Errorf("Expected AssumeRole action, got %q", form["Action"])
+	}
+	if form["RoleArn"] != "arn:aws:iam::000000000000:role/test" {
+		t.Errorf("Unexpected RoleArn %q", form["RoleArn"])
+	}
+	if form["ExternalId"] != "external-123" {
+		t.Errorf("Unexpected ExternalId %q", form["ExternalId"])
+	}
+}
+
+func TestAssumeRoleProviderRefreshesBeforeExpiry(t *testing.T) {
+	// Sessions expire inside the refresh window, so every retrieval refreshes
+	sts := &fakeSTS{lifetime: time.Minute}
+	server := newFakeSTSServer(t, sts)
+
+	base := &Credentials{AccessKeyID: "BASE_KEY", SecretAccessKey: "BASE_SECRET"}
+	provider, err := NewAssumeRoleProvider(base.Provider(), AssumeRoleOptions{
+		RoleARN:      "arn:aws:iam::000000000000:role/test",
+		ExpiryWindow: 5 * time.Minute,
+		Endpoint:     server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	ctx := context.Background()
+	first, err := provider.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Failed to retrieve credentials: %v", err)
+	}
+	second, err := provider.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Failed to retrieve credentials: %v", err)
+	}
+
+	if first.AccessKeyID == second.AccessKeyID {
+		t.Errorf("Expected refreshed credentials, got %s twice", first.AccessKeyID)
+	}
+	if sts.calls != 2 {
+		t.Errorf("Expected 2 AssumeRole calls, got %d", sts.calls)
+	}
+}
+
+func TestAssumeRoleProviderValidation(t *testing.T) {
+	base := &Credentials{AccessKeyID: "BASE_KEY", SecretAccessKey: "BASE_SECRET"}
+	if _, err := NewAssumeRoleProvider(base.Provider(), AssumeRoleOptions{}); err == nil {
+		t.Error("Expected error without role ARN")
+	}
+	if _, err := NewAssumeRoleProvider(nil, AssumeRoleOptions{RoleARN: "arn:aws:iam::000000000000:role/test"}); err == nil {
+		t.Error("Expected error without base credentials")
+	}
+}
@@ -0,0 +1,113 @@
+// Package errno maps the errors of filesystem operations and storage backends
+// to the errno values FUSE callers see
+//
+// Errors reach the FUSE layer wrapped in context ("failed to get object
+// metadata: ..."), which bazil would report as EIO. From looks through the
+// wrapping for an errno, a sentinel error or an S3 error code instead
+package errno
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// s3Codes maps S3 error codes to errnos
+var s3Codes = map[string]syscall.Errno{
+	"NoSuchKey":     syscall.ENOENT,
+	"NotFound":      syscall.ENOENT,
+	"NoSuchBucket":  syscall.ENOENT,
+	"NoSuchUpload":  syscall.ENOENT,
+	"NoSuchVersion": syscall.ENOENT,
+
+	"AccessDenied":          syscall.EACCES,
+	"Forbidden":             syscall.EACCES,
+	"AllAccessDisabled":     syscall.EACCES,
+	"InvalidAccessKeyId":    syscall.EACCES,
+	"SignatureDoesNotMatch": syscall.EACCES,
+	"ExpiredToken":          syscall.EACCES,
+
+	// Still throttled once retries ran out: worth trying again later
+	"SlowDown":                 syscall.EAGAIN,
+	"ServiceUnavailable":       syscall.EAGAIN,
+	"Throttling":               syscall.EAGAIN,
+	"ThrottlingException":      syscall.EAGAIN,
+	"TooManyRequestsException": syscall.EAGAIN,
+	"RequestLimitExceeded":     syscall.EAGAIN,
+
+	"QuotaExceeded":       syscall.ENOSPC,
+	"InsufficientStorage": syscall.ENOSPC,
+	"EntityTooLarge":      syscall.EFBIG,
+
+	"RequestTimeout":          syscall.ETIMEDOUT,
+	"RequestTimeoutException": syscall.ETIMEDOUT,
+
+	"NotImplemented": syscall.ENOTSUP,
+}
+
+// httpStatuses maps the status of S3 responses without a known error code
+var httpStatuses = map[int]syscall.Errno{
+	http.StatusNotFound:              syscall.ENOENT,
+	http.StatusForbidden:             syscall.EACCES,
+	http.StatusTooManyRequests:       syscall.EAGAIN,
+	http.StatusServiceUnavailable:    syscall.EAGAIN,
+	http.StatusRequestEntityTooLarge: syscall.EFBIG,
+	http.StatusInsufficientStorage:   syscall.ENOSPC,
+	http.StatusNotImplemented:        syscall.ENOTSUP,
+}
+
+// From returns the errno err stands for, or nil for a nil err. It is the
+// first that applies of:
+//   - EIO for an unreachable backend or a timed out request
+//   - a syscall.Errno in err's chain
+//   - the errno of a sentinel error in the chain (os.ErrNotExist, os.ErrExist,
+//     os.ErrPermission, errors.ErrUnsupported, context.Canceled)
+//   - the errno of an S3 error code or, failing that, HTTP status in the chain
+//   - EIO
+func From(err error) error {
+	if err == nil {
+		return nil
+	}
+	// Before the errno check: a refused connection is not the file's fault
+	if types.IsUnavailable(err) {
+		return syscall.EIO
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, os.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, errors.ErrUnsupported):
+		return syscall.ENOTSUP
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if errno, ok := s3Codes[apiErr.ErrorCode()]; ok {
+			return errno
+		}
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		if errno, ok := httpStatuses[respErr.HTTPStatusCode()]; ok {
+			return errno
+		}
+	}
+	return syscall.EIO
+}
@@ -0,0 +1,64 @@
+package errno
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// s3Error returns an S3 error the way the SDK wraps it
+func s3Error(code string) error {
+	return fmt.Errorf("operation error S3: GetObject: %w", &smithy.GenericAPIError{Code: code})
+}
+
+// httpError returns an S3 response error with status and no known code
+func httpError(status int) error {
+	return &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}}, Err: errors.New("unknown error")}
+}
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil", nil, nil},
+		{"errno", syscall.EROFS, syscall.EROFS},
+		{"wrapped errno", fmt.Errorf("file not found: %w", syscall.ENOENT), syscall.ENOENT},
+		{"not exist", fmt.Errorf("read: %w", os.ErrNotExist), syscall.ENOENT},
+		{"exists", fmt.Errorf("create: %w", os.ErrExist), syscall.EEXIST},
+		{"permission", os.ErrPermission, syscall.EACCES},
+		{"unsupported", fmt.Errorf("object versions: %w", errors.ErrUnsupported), syscall.ENOTSUP},
+		{"canceled", fmt.Errorf("list: %w", context.Canceled), syscall.EINTR},
+		{"deadline", fmt.Errorf("head: %w", context.DeadlineExceeded), syscall.EIO},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, syscall.EIO},
+		{"cut short", io.ErrUnexpectedEOF, syscall.EIO},
+		{"NoSuchKey", s3Error("NoSuchKey"), syscall.ENOENT},
+		{"NoSuchBucket", s3Error("NoSuchBucket"), syscall.ENOENT},
+		{"AccessDenied", s3Error("AccessDenied"), syscall.EACCES},
+		{"SlowDown", s3Error("SlowDown"), syscall.EAGAIN},
+		{"QuotaExceeded", s3Error("QuotaExceeded"), syscall.ENOSPC},
+		{"EntityTooLarge", s3Error("EntityTooLarge"), syscall.EFBIG},
+		{"RequestTimeout", s3Error("RequestTimeout"), syscall.ETIMEDOUT},
+		{"unknown code", s3Error("InternalError"), syscall.EIO},
+		{"404", httpError(http.StatusNotFound), syscall.ENOENT},
+		{"403", httpError(http.StatusForbidden), syscall.EACCES},
+		{"503", httpError(http.StatusServiceUnavailable), syscall.EAGAIN},
+		{"500", httpError(http.StatusInternalServerError), syscall.EIO},
+		{"plain", errors.New("something broke"), syscall.EIO},
+	}
+	for _, tt := range tests {
+		if got := From(tt.err); got != tt.want {
+			t.Errorf("From(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// AtimeMode controls when reads update a file's stored access time. Each
+// update rewrites the object's metadata, so it is a request of its own; on S3
+// it is a copy that changes the ETag and, in versioned buckets, adds a version
+type AtimeMode int
+
+const (
+	// AtimeOff never updates the access time on reads (the default)
+	AtimeOff AtimeMode = iota
+	// AtimeRelative updates the access time on a read when it is older than
+	// the last modification or change, or more than a day old, like relatime
+	AtimeRelative
+	// AtimeStrict updates the access time on every read, at most once a second
+	AtimeStrict
+)
+
+// relatimeInterval is how old an access time may get under AtimeRelative
+const relatimeInterval = 24 * time.Hour
+
+// atimeRetryInterval is how long reads skip access time updates after one
+// failed, e.g. because the credentials may not copy objects
+const atimeRetryInterval = 10 * time.Minute
+
+// SetAtimeMode sets when reads update the stored access time
+func (fs *Filesystem) SetAtimeMode(mode AtimeMode) {
+	fs.atimeMode = mode
+}
+
+// atimeDue reports whether a read at now should update the access time of a
+// file with attributes attr. Times are stored in whole seconds
+func (fs *Filesystem) atimeDue(attr *Attr, now time.Time) bool {
+	switch fs.atimeMode {
+	case AtimeStrict:
+		return attr.Atime.Unix() < now.Unix()
+	case AtimeRelative:
+		return attr.Atime.Unix() <= attr.Mtime.Unix() ||
+			attr.Atime.Unix() <= attr.Ctime.Unix() ||
+			now.Sub(attr.Atime) >= relatimeInterval
+	}
+	return false
+}
+
+// updateAtime records a read of path in its access time when the atime mode
+// asks for it. The read already succeeded, so failures are only logged
+func (fs *Filesystem) updateAtime(ctx context.Context, path string) {
+	if fs.atimeMode == AtimeOff || fs.readOnly {
+		return
+	}
+	if failed := fs.atimeFailedAt.Load(); failed != 0 && time.Since(time.Unix(0, failed)) < atimeRetryInterval {
+		return
+	}
+	normalizedPath := fs.normalizePath(path)
+	// Times are set when buffered changes are uploaded
+	if fs.cache != nil {
+		if entity, found := fs.cache.GetFdCache().Get(normalizedPath); found && (entity.BytesModified() > 0 || entity.Uploading()) {
+			return
+		}
+	}
+	attr, err := fs.GetAttr(ctx, path)
+	if err != nil || attr.Mode.IsDir() {
+		return
+	}
+	now := time.Now()
+	if !fs.atimeDue(attr, now) {
+		return
+	}
+	// Concurrent reads of the file need only one update
+	if _, busy := fs.atimeUpdating.LoadOrStore(normalizedPath, true); busy {
+		return
+	}
+	defer fs.atimeUpdating.Delete(normalizedPath)
+
+	if err := fs.setAtime(ctx, normalizedPath, attr, now); err != nil {
+		fs.atimeFailedAt.Store(now.UnixNano())
+		logging.Warn("failed to update access time, retrying after a pause", "path", path, "retry_after", atimeRetryInterval, "err", err)
+		return
+	}
+	fs.atimeFailedAt.Store(0)
+	// Cache the new attributes straight away; a dropped entry could not be
+	// served stale if the backend became unreachable
+	if fs.cache != nil {
+		fs.cache.GetStatCache().Delete(path)
+		fs.GetAttr(ctx, path)
+	}
+}
+
+// setAtime stores atime as the access time of the file at normalizedPath,
+// whose current attributes are attr. The modification and change times are
+// stored as they are, since objects without them report the time of the
+// metadata rewrite instead
+func (fs *Filesystem) setAtime(ctx context.Context, normalizedPath string, attr *Attr, atime time.Time) error {
+	backend := fs.getBackend()
+	if backend == nil {
+		return fmt.Errorf("no storage backend available")
+	}
+	current, metadata, err := types.StatMetadata(ctx, backend, normalizedPath)
+	if err != nil {
+		return err
+	}
+	updated := make(map[string]string, len(metadata)+6)
+	for k, v := range metadata {
+		updated[k] = v
+	}
+	times := map[string]time.Time{"atime": atime, "mtime": attr.Mtime, "ctime": attr.Ctime}
+	for key, t := range times {
+		updated[key] = fmt.Sprintf("%d", t.Unix())
+		updated["x-amz-meta-"+key] = fmt.Sprintf("%d", t.Unix())
+	}
+	return fs.updateMetadata(ctx, backend, normalizedPath, current, updated)
+}
@@ -0,0 +1,176 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+// putTimedObject stores key with the given modification and access times
+func putTimedObject(t *testing.T, client *s3client.MockClient, key string, mtime, atime time.Time) {
+	metadata := map[string]string{
+		"mode":  "644",
+		"mtime": fmt.Sprintf("%d", mtime.Unix()),
+		"atime": fmt.Sprintf("%d", atime.Unix()),
+	}
+	if err := client.PutObjectWithMetadata(context.Background(), key, []byte("content"), metadata); err != nil {
+		t.Fatalf("PutObjectWithMetadata(%s) failed: %v", key, err)
+	}
+}
+
+// storedAtime returns the access time a fresh mount reports for path
+func storedAtime(t *testing.T, client *s3client.MockClient, path string) time.Time {
+	attr, err := NewFilesystem(client).GetAttr(context.Background(), path)
+	if err != nil {
+		t.Fatalf("GetAttr(%s) failed: %v", path, err)
+	}
+	return attr.Atime
+}
+
+func TestAtimeModes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	mtime := now.Add(-72 * time.Hour)
+	// Read since the last change: relatime leaves a recent atime alone, but
+	// catches up on one more than a day old
+	recent := now.Add(-time.Hour)
+	old := now.Add(-48 * time.Hour)
+
+	tests := []struct {
+		mode          AtimeMode
+		recentUpdated bool
+		oldUpdated    bool
+	}{
+		{AtimeOff, false, false},
+		{AtimeRelative, false, true},
+		{AtimeStrict, true, true},
+	}
+	for _, tt := range tests {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		putTimedObject(t, client, "recent.txt", mtime, recent)
+		putTimedObject(t, client, "old.txt", mtime, old)
+		filesystem := NewFilesystem(client)
+		filesystem.SetAtimeMode(tt.mode)
+
+		for path, updated := range map[string]bool{"/recent.txt": tt.recentUpdated, "/old.txt": tt.oldUpdated} {
+			before, _ := filesystem.GetAttr(ctx, path)
+			if _, err := filesystem.ReadFile(ctx, path, 0, 0); err != nil {
+				t.Fatalf("ReadFile(%s) failed: %v", path, err)
+			}
+			after, _ := filesystem.GetAttr(ctx, path)
+			if advanced := after.Atime.After(before.Atime); advanced != updated {
+				t.Errorf("mode %d: %s atime %v -> %v, want advanced %v", tt.mode, path, before.Atime, after.Atime, updated)
+			}
+			if stored := storedAtime(t, client, path); !stored.Equal(after.Atime) {
+				t.Errorf("mode %d: %s stored atime %v, reported %v", tt.mode, path, stored, after.Atime)
+			}
+			// Only the access time moves
+			if !after.Mtime.Equal(before.Mtime) {
+				t.Errorf("mode %d: %s mtime %v -> %v", tt.mode, path, before.Mtime, after.Mtime)
+			}
+		}
+	}
+}
+
+func TestRelatimeRecordsFirstRead(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetAtimeMode(AtimeRelative)
+	ctx := context.Background()
+
+	// A file written and not read since has an atime no later than its mtime
+	writeAndFlush(t, filesystem, "/fresh.txt", []byte("content"))
+	attr, _ := filesystem.GetAttr(ctx, "/fresh.txt")
+	if attr.Atime.After(attr.Mtime) {
+		t.Fatalf("New file atime %v after mtime %v", attr.Atime, attr.Mtime)
+	}
+
+	// relatime records the first read after a change
+	time.Sleep(time.Until(attr.Mtime.Add(time.Second)))
+	if _, err := filesystem.ReadFile(ctx, "/fresh.txt", 0, 0); err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	read, _ := filesystem.GetAttr(ctx, "/fresh.txt")
+	if !read.Atime.After(attr.Mtime) {
+		t.Errorf("atime after first read %v, want after mtime %v", read.Atime, attr.Mtime)
+	}
+}
+
+func TestAtimeOffByDefault(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	old := time.Now().Add(-48 * time.Hour)
+	putTimedObject(t, client, "old.txt", old, old)
+	filesystem := NewFilesystem(client)
+
+	if _, err := filesystem.ReadFile(context.Background(), "/old.txt", 0, 0); err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if stored := storedAtime(t, client, "/old.txt"); stored.Unix() != old.Unix() {
+		t.Errorf("Default mount stored atime %v on a read, want %v untouched", stored, old)
+	}
+}
+
+// copyDenyingClient fails metadata rewrites, as credentials without
+// s3:PutObject on the copy destination would
+type copyDenyingClient struct {
+	S3ClientInterface
+	copies int
+}
+
+func (c *copyDenyingClient) CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	c.copies++
+	return fmt.Errorf("access denied")
+}
+
+func TestAtimeBacksOffAfterFailure(t *testing.T) {
+	mock := s3client.NewMockClient("test-bucket", "us-east-1")
+	old := time.Now().Add(-48 * time.Hour)
+	putTimedObject(t, mock, "a.txt", old, old)
+	putTimedObject(t, mock, "b.txt", old, old)
+	client := &copyDenyingClient{S3ClientInterface: mock}
+	filesystem := NewFilesystem(client)
+	filesystem.SetAtimeMode(AtimeStrict)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		for _, path := range []string{"/a.txt", "/b.txt"} {
+			if _, err := filesystem.ReadFile(ctx, path, 0, 0); err != nil {
+				t.Fatalf("ReadFile(%s) failed: %v", path, err)
+			}
+		}
+	}
+	if client.copies != 1 {
+		t.Errorf("Expected reads to stop trying after a failed atime update, got %d copies", client.copies)
+	}
+}
+
+func TestUtimensSetsOneTime(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+	mtime := time.Date(2021, 2, 2, 13, 0, 0, 0, time.UTC)
+	atime := time.Date(2022, 3, 3, 14, 0, 0, 0, time.UTC)
+	putTimedObject(t, client, "file.txt", mtime, mtime)
+
+	// touch -a
+	if err := filesystem.Utimens(ctx, "/file.txt", atime, time.Time{}); err != nil {
+		t.Fatalf("Utimens failed: %v", err)
+	}
+	attr, _ := filesystem.GetAttr(ctx, "/file.txt")
+	if !attr.Atime.Equal(atime) || !attr.Mtime.Equal(mtime) {
+		t.Errorf("After setting atime: atime %v, mtime %v; want %v, %v", attr.Atime, attr.Mtime, atime, mtime)
+	}
+
+	// touch -m
+	newMtime := mtime.Add(time.Hour)
+	if err := filesystem.Utimens(ctx, "/file.txt", time.Time{}, newMtime); err != nil {
+		t.Fatalf("Utimens failed: %v", err)
+	}
+	attr, _ = filesystem.GetAttr(ctx, "/file.txt")
+	if !attr.Atime.Equal(atime) || !attr.Mtime.Equal(newMtime) {
+		t.Errorf("After setting mtime: atime %v, mtime %v; want %v, %v", attr.Atime, attr.Mtime, atime, newMtime)
+	}
+}
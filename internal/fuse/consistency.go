@@ -0,0 +1,130 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/cache"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// trackVersion records the ETag of the stored version an entity about to cache
+// content read from storage is based on, as the stat cache knows it, so a later
+// upload can tell whether another client changed the file meanwhile. Entities
+// that only hold writes need no version: the bytes they don't cover are read
+// from storage at upload time. An entity that knows its version keeps it
+func (fs *Filesystem) trackVersion(path string, entity *cache.FdEntity) {
+	if entity.ETag() != "" {
+		return
+	}
+	if entry, found := fs.cache.GetStatCache().Get(path); found && entry.Attr != nil {
+		entity.SetETag(entry.Attr.ETag)
+	}
+}
+
+// revalidate compares the attributes of a file just fetched from storage with
+// the version its entity is based on, dropping cached data of an older version
+// Entities with pending writes are left for the upload to detect the conflict
+func (fs *Filesystem) revalidate(normalizedPath string, attr *types.Attr) {
+	if fs.cache == nil || attr.ETag == "" {
+		return
+	}
+	entity, found := fs.cache.GetFdCache().Get(normalizedPath)
+	if !found {
+		return
+	}
+	switch etag := entity.ETag(); {
+	case etag == "":
+		entity.SetETag(attr.ETag)
+	case etag != attr.ETag:
+		if entity.Reset(attr.Size, attr.Mtime, attr.ETag, false) {
+			logging.Info("file changed in storage, dropped cached data", "path", normalizedPath)
+		}
+	}
+}
+
+// followVersion moves the entity of normalizedPath on to the stored version
+// after a change of this mount's that kept its content, such as a metadata
+// update, which may still give the object a new ETag
+func (fs *Filesystem) followVersion(ctx context.Context, backend types.Backend, normalizedPath string) {
+	if fs.cache == nil {
+		return
+	}
+	entity, found := fs.cache.GetFdCache().Get(normalizedPath)
+	if !found || entity.ETag() == "" {
+		return
+	}
+	if attr, err := backend.GetAttr(ctx, normalizedPath); err == nil {
+		entity.SetETag(attr.ETag)
+	} else {
+		entity.SetETag("")
+	}
+}
+
+// unchanged fails with types.ErrConflict if the stored file is no longer the
+// version entity is based on; for backends that cannot write conditionally.
+// Called under the entity's upload lock, so the entity's own uploads are not
+// mistaken for another client's
+func (fs *Filesystem) unchanged(ctx context.Context, backend types.Backend, normalizedPath string, entity *cache.FdEntity) error {
+	expected := entity.ETag()
+	if expected == "" {
+		return nil
+	}
+	if attr, err := backend.GetAttr(ctx, normalizedPath); err == nil && attr.ETag != "" && attr.ETag != expected {
+		return types.ErrConflict
+	}
+	return nil
+}
+
+// conflict handles an upload of normalizedPath refused because another client
+// changed the file: the local changes are discarded and the caches refreshed
+// from storage, so reads see the other client's version. Returns the error for
+// the upload
+func (fs *Filesystem) conflict(ctx context.Context, backend types.Backend, normalizedPath string, entity *cache.FdEntity) error {
+	logging.Warn("file was modified by another client, discarding local changes", "path", normalizedPath, "bytes", entity.BytesModified())
+	if attr, err := backend.GetAttr(ctx, normalizedPath); err == nil {
+		entity.Reset(attr.Size, attr.Mtime, attr.ETag, true)
+	} else {
+		entity.Reset(0, time.Now(), "", true)
+	}
+	// Files are statted both with and without the leading slash
+	statCache := fs.cache.GetStatCache()
+	statCache.Delete(normalizedPath)
+	statCache.Delete("/" + normalizedPath)
+	fs.forgetDiskCache(normalizedPath)
+	fs.completeFailedReleases(normalizedPath)
+	return fmt.Errorf("%s was modified by another client: %w", normalizedPath, syscall.ESTALE)
+}
+
+// uploadCount returns how many uploads the entity of normalizedPath has
+// completed, 0 without an entity
+func (fs *Filesystem) uploadCount(normalizedPath string) uint64 {
+	if fs.cache == nil {
+		return 0
+	}
+	if entity, found := fs.cache.GetFdCache().Get(normalizedPath); found {
+		return entity.Uploads()
+	}
+	return 0
+}
+
+// unlessUploaded runs cacheAttr, which caches attributes of normalizedPath
+// fetched from storage when its upload count was count, unless the file has
+// been uploaded since or is being uploaded: the attributes may then predate
+// the upload, which cached the ones it stored
+func (fs *Filesystem) unlessUploaded(normalizedPath string, count uint64, cacheAttr func()) {
+	if fs.cache == nil {
+		return
+	}
+	entity, found := fs.cache.GetFdCache().Get(normalizedPath)
+	if !found {
+		if count == 0 {
+			cacheAttr()
+		}
+		return
+	}
+	entity.UnlessUploaded(count, cacheAttr)
+}
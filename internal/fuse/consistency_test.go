@@ -0,0 +1,264 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// readAndEdit reads path through filesystem, so its pages are cached, and buffers
+// an in-place write of data at offset 1
+func readAndEdit(t *testing.T, filesystem *Filesystem, path string, data string) {
+	ctx := context.Background()
+	if _, err := filesystem.GetAttr(ctx, path); err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if _, err := filesystem.ReadFile(ctx, path, 0, 0); err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if err := filesystem.WriteFile(ctx, path, []byte(data), 1); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestExternalModificationDetectedOnFlush(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "shared.txt", []byte("original content"))
+	filesystem := NewFilesystem(client)
+
+	readAndEdit(t, filesystem, "/shared.txt", "XY")
+	// Another client replaces the file mid-session
+	client.PutObject(ctx, "shared.txt", []byte("from another client"))
+
+	if err := filesystem.Flush(ctx, "/shared.txt"); !errors.Is(err, syscall.ESTALE) {
+		t.Fatalf("Flush = %v, want ESTALE", err)
+	}
+	if data, _ := client.GetObject(ctx, "shared.txt"); string(data) != "from another client" {
+		t.Errorf("Stored data = %q, the other client's write was overwritten", data)
+	}
+	// The stale pages and the refused write are gone
+	if data, err := filesystem.ReadFile(ctx, "/shared.txt", 0, 0); err != nil || string(data) != "from another client" {
+		t.Errorf("ReadFile after the conflict = %q, %v; want the other client's data", data, err)
+	}
+	if attr, err := filesystem.GetAttr(ctx, "/shared.txt"); err != nil || attr.Size != int64(len("from another client")) {
+		t.Errorf("GetAttr after the conflict = %+v, %v", attr, err)
+	}
+	if err := filesystem.Release(ctx, "/shared.txt"); err != nil {
+		t.Errorf("Release after the conflict failed: %v", err)
+	}
+}
+
+// racingBackend lets another client write the file just before each
+// conditional write, after every check the filesystem makes first
+type racingBackend struct {
+	*s3Adapter
+	race func()
+}
+
+func (b *racingBackend) WriteIfMatch(ctx context.Context, path string, data []byte, metadata map[string]string, etag string) error {
+	b.race()
+	return b.s3Adapter.WriteIfMatch(ctx, path, data, metadata, etag)
+}
+
+func TestExternalModificationDuringUpload(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "shared.txt", []byte("original content"))
+	backend := &racingBackend{s3Adapter: newS3Adapter(client).(*s3Adapter)}
+	backend.race = func() { client.PutObject(ctx, "shared.txt", []byte("raced in")) }
+	filesystem := NewFilesystemWithBackend(backend)
+
+	readAndEdit(t, filesystem, "/shared.txt", "XY")
+	if err := filesystem.Flush(ctx, "/shared.txt"); !errors.Is(err, syscall.ESTALE) {
+		t.Fatalf("Flush = %v, want ESTALE", err)
+	}
+	if data, _ := client.GetObject(ctx, "shared.txt"); string(data) != "raced in" {
+		t.Errorf("Stored data = %q, the conditional write overwrote the other client", data)
+	}
+}
+
+func TestExternalModificationDropsCleanPages(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "shared.txt", []byte("version one"))
+	filesystem := NewFilesystem(client)
+
+	filesystem.GetAttr(ctx, "/shared.txt")
+	if data, _ := filesystem.ReadFile(ctx, "/shared.txt", 0, 0); string(data) != "version one" {
+		t.Fatalf("ReadFile = %q", data)
+	}
+	client.PutObject(ctx, "shared.txt", []byte("version two"))
+
+	// Once the stat entry expires, the next stat sees the new ETag
+	filesystem.cache.GetStatCache().Delete("/shared.txt")
+	if _, err := filesystem.GetAttr(ctx, "/shared.txt"); err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if data, err := filesystem.ReadFile(ctx, "/shared.txt", 0, 0); err != nil || string(data) != "version two" {
+		t.Errorf("ReadFile after revalidation = %q, %v; want %q", data, err, "version two")
+	}
+}
+
+func TestOwnChangesAreNotConflicts(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "mine.txt", []byte("original content"))
+	filesystem := NewFilesystem(client)
+
+	readAndEdit(t, filesystem, "/mine.txt", "AB")
+	if err := filesystem.Flush(ctx, "/mine.txt"); err != nil {
+		t.Fatalf("First flush failed: %v", err)
+	}
+	// A metadata update and a second edit are this mount's own changes
+	if err := filesystem.Chmod(ctx, "/mine.txt", os.FileMode(0600)); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := filesystem.WriteFile(ctx, "/mine.txt", []byte("CD"), 3); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := filesystem.Flush(ctx, "/mine.txt"); err != nil {
+		t.Fatalf("Second flush failed: %v", err)
+	}
+	if data, _ := client.GetObject(ctx, "mine.txt"); string(data) != "oABCDnal content" {
+		t.Errorf("Stored data = %q, want %q", data, "oABCDnal content")
+	}
+}
+
+// laggingBackend answers attribute lookups with the previous version of a
+// file for a while after it is written, like a store whose reads lag its writes
+type laggingBackend struct {
+	types.Backend
+	mu       sync.Mutex
+	previous map[string]*types.Attr // Attributes before the last write
+	until    map[string]time.Time   // When the last write becomes visible
+}
+
+func (b *laggingBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	previous, _ := b.Backend.GetAttr(ctx, path)
+	if err := b.Backend.WriteWithMetadata(ctx, path, data, metadata); err != nil {
+		return err
+	}
+	b.lag(path, previous)
+	return nil
+}
+
+// WriteIfMatch checks the condition against the stored object, as S3 does,
+// however late lookups see the write
+func (b *laggingBackend) WriteIfMatch(ctx context.Context, path string, data []byte, metadata map[string]string, etag string) error {
+	previous, _ := b.Backend.GetAttr(ctx, path)
+	if err := b.Backend.(types.ConditionalWriter).WriteIfMatch(ctx, path, data, metadata, etag); err != nil {
+		return err
+	}
+	b.lag(path, previous)
+	return nil
+}
+
+// lag makes lookups of path report previous for a while
+func (b *laggingBackend) lag(path string, previous *types.Attr) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.previous[path] = previous
+	b.until[path] = time.Now().Add(20 * time.Millisecond)
+}
+
+func (b *laggingBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	b.mu.Lock()
+	previous, until := b.previous[path], b.until[path]
+	b.mu.Unlock()
+	if previous != nil && time.Now().Before(until) {
+		attr := *previous
+		return &attr, nil
+	}
+	return b.Backend.GetAttr(ctx, path)
+}
+
+func TestStatAfterUploadSeesWrittenSize(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	backend := &laggingBackend{
+		Backend:  newS3Adapter(client),
+		previous: make(map[string]*types.Attr),
+		until:    make(map[string]time.Time),
+	}
+	filesystem := NewFilesystemWithBackend(backend)
+	filesystem.SetAtimeMode(AtimeOff)
+	ctx := context.Background()
+
+	// Other stats of the file keep asking storage while it is written
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					filesystem.GetAttr(ctx, "/growing.txt")
+					time.Sleep(100 * time.Microsecond)
+				}
+			}
+		}()
+	}
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	for size := 1; size <= 50; size++ {
+		if err := filesystem.WriteFile(ctx, "/growing.txt", bytes.Repeat([]byte("x"), size), 0); err != nil {
+			t.Fatalf("WriteFile of %d bytes failed: %v", size, err)
+		}
+		if err := filesystem.Flush(ctx, "/growing.txt"); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if attr, err := filesystem.GetAttr(ctx, "/growing.txt"); err != nil || attr.Size != int64(size) {
+			t.Fatalf("GetAttr after writing %d bytes = %+v, %v", size, attr, err)
+		}
+	}
+}
+
+func TestUploadsTakeETagFromResponse(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetAtimeMode(AtimeOff)
+	ctx := context.Background()
+
+	writeAndFlush(t, filesystem, "/repeated.txt", []byte("first"))
+	entity, _ := filesystem.cache.GetFdCache().Get("repeated.txt")
+	stored, _ := client.HeadObjectETag(ctx, "repeated.txt")
+	if entity.ETag() == "" || entity.ETag() != stored {
+		t.Fatalf("Entity ETag %q after upload, stored %q", entity.ETag(), stored)
+	}
+
+	// Later uploads of the open file need no HEAD before or after
+	heads := client.HeadCount()
+	for _, data := range []string{"second", "third!", "fourth"} {
+		if err := filesystem.WriteFile(ctx, "/repeated.txt", []byte(data), 1); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := filesystem.Flush(ctx, "/repeated.txt"); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if extra := client.HeadCount() - heads; extra != 0 {
+		t.Errorf("Three uploads made %d HEAD requests, want 0", extra)
+	}
+	stored, _ = client.HeadObjectETag(ctx, "repeated.txt")
+	if entity.ETag() != stored {
+		t.Errorf("Entity ETag %q, stored %q", entity.ETag(), stored)
+	}
+	if data, _ := client.GetObject(ctx, "repeated.txt"); string(data) != "ffourth" {
+		t.Errorf("Stored data = %q, want %q", data, "ffourth")
+	}
+}
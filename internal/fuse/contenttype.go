@@ -0,0 +1,48 @@
+package fuse
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// genericContentTypes are the types objects uploaded without a Content-Type
+// are served with
+var genericContentTypes = map[string]bool{
+	"":                         true,
+	"binary/octet-stream":      true,
+	"application/octet-stream": true,
+}
+
+// SetDetectContentType sets whether uploads store files with a Content-Type
+// guessed from the extension, or from the first bytes when the extension is
+// unknown, so objects served straight from the bucket get the right MIME type.
+// It is on by default; turned off, uploads get storage's default type
+func (fs *Filesystem) SetDetectContentType(enable bool) {
+	fs.detectContentType = enable
+}
+
+// setContentType adds the Content-Type to upload path with to metadata, unless
+// metadata has one already. A specific type the stored object has (existing,
+// nil if unknown) is kept, since another tool may have set it deliberately
+// data is the content to sniff, or nil if it is not at hand
+func (fs *Filesystem) setContentType(metadata map[string]string, path string, data []byte, existing *types.Attr) {
+	if _, ok := metadata[s3client.ContentTypeKey]; ok {
+		return
+	}
+	if existing != nil && !genericContentTypes[existing.ContentType] {
+		metadata[s3client.ContentTypeKey] = existing.ContentType
+		return
+	}
+	if !fs.detectContentType {
+		return
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		metadata[s3client.ContentTypeKey] = contentType
+	} else if len(data) > 0 {
+		metadata[s3client.ContentTypeKey] = http.DetectContentType(data)
+	}
+}
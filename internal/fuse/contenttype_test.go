@@ -0,0 +1,88 @@
+package fuse
+
+import (
+	"context"
+	"mime"
+	"os"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+// writeAndFlush writes data to path through filesystem and uploads it
+func writeAndFlush(t *testing.T, filesystem *Filesystem, path string, data []byte) {
+	ctx := context.Background()
+	if err := filesystem.WriteFile(ctx, path, data, 0); err != nil {
+		t.Fatalf("WriteFile(%s) failed: %v", path, err)
+	}
+	if err := filesystem.Flush(ctx, path); err != nil {
+		t.Fatalf("Flush(%s) failed: %v", path, err)
+	}
+}
+
+func contentTypeOf(t *testing.T, client *s3client.MockClient, key string) string {
+	info, err := client.HeadObjectFull(context.Background(), key)
+	if err != nil {
+		t.Fatalf("HeadObjectFull(%s) failed: %v", key, err)
+	}
+	return info.ContentType
+}
+
+func TestDetectContentType(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	// The system MIME tables may refine the built-in type of .html
+	html := mime.TypeByExtension(".html")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	writeAndFlush(t, filesystem, "/index.html", []byte("<p>hello</p>"))
+	writeAndFlush(t, filesystem, "/logo", png)
+	writeAndFlush(t, filesystem, "/notes.unknown-ext", []byte("plain words"))
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"index.html", html},
+		{"logo", "image/png"},
+		{"notes.unknown-ext", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		if got := contentTypeOf(t, client, tt.key); got != tt.expected {
+			t.Errorf("%s: Content-Type %q, want %q", tt.key, got, tt.expected)
+		}
+	}
+
+	// Metadata updates and renames keep the type
+	if err := filesystem.Chmod(ctx, "/index.html", os.FileMode(0600)); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := filesystem.Rename(ctx, "/logo", "/logo-copy"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if got := contentTypeOf(t, client, "index.html"); got != html {
+		t.Errorf("Content-Type after chmod = %q", got)
+	}
+	if got := contentTypeOf(t, client, "logo-copy"); got != "image/png" {
+		t.Errorf("Content-Type after rename = %q", got)
+	}
+
+	// A type set by another tool survives a rewrite
+	client.PutObjectWithMetadata(ctx, "page.html", []byte("<p>old</p>"), map[string]string{s3client.ContentTypeKey: "text/html; charset=iso-8859-1"})
+	writeAndFlush(t, filesystem, "/page.html", []byte("<p>new</p>"))
+	if got := contentTypeOf(t, client, "page.html"); got != "text/html; charset=iso-8859-1" {
+		t.Errorf("Content-Type after rewrite = %q, want the one set before", got)
+	}
+}
+
+func TestDetectContentTypeDisabled(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetDetectContentType(false)
+
+	writeAndFlush(t, filesystem, "/index.html", []byte("<p>hello</p>"))
+	if got := contentTypeOf(t, client, "index.html"); got != "binary/octet-stream" {
+		t.Errorf("Content-Type = %q, want the storage default", got)
+	}
+}
@@ -0,0 +1,195 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// dirContentType is the Content-Type C++ s3fs gives its directory markers
+const dirContentType = "application/x-directory"
+
+// DirMarkerStyle is the kind of marker object Mkdir creates for a directory.
+// Lookups recognize every style whichever is set
+type DirMarkerStyle string
+
+const (
+	// DirMarkerSlash creates a zero-byte object at the prefix itself ("dir/"),
+	// as C++ s3fs and most other S3 tools do
+	DirMarkerSlash DirMarkerStyle = "slash"
+	// DirMarkerKeep creates "dir/.keep", as earlier versions of this filesystem
+	// did, for buckets shared with mounts that only know that style
+	DirMarkerKeep DirMarkerStyle = "keep"
+)
+
+// ParseDirMarkerStyle parses a marker style name; "" means DirMarkerSlash
+func ParseDirMarkerStyle(name string) (DirMarkerStyle, error) {
+	switch style := DirMarkerStyle(strings.ToLower(name)); style {
+	case "":
+		return DirMarkerSlash, nil
+	case DirMarkerSlash, DirMarkerKeep:
+		return style, nil
+	}
+	return "", fmt.Errorf("unknown directory marker style %q (want slash or keep)", name)
+}
+
+// SetDirMarkerStyle sets the kind of marker Mkdir creates ("" = DirMarkerSlash)
+func (fs *Filesystem) SetDirMarkerStyle(style DirMarkerStyle) {
+	fs.dirMarkerStyle = style
+}
+
+// SetShowDirMarkers makes ReadDir list the "dir/.keep" marker of a directory
+// as a file, to see which directories still have one. A "dir/" marker is the
+// directory itself and never appears. Markers stay out of emptiness checks
+func (fs *Filesystem) SetShowDirMarkers(enable bool) {
+	fs.showDirMarkers = enable
+}
+
+// newDirMarker returns the key of the marker Mkdir creates for the directory
+// prefix ("dir/") in the configured style. The root has no key of its own, so
+// it keeps its metadata in .keep
+func (fs *Filesystem) newDirMarker(prefix string) string {
+	if prefix == "" || fs.dirMarkerStyle == DirMarkerKeep {
+		return prefix + ".keep"
+	}
+	return prefix
+}
+
+// dirMarkers returns the keys of the markers stored for the directory prefix,
+// with their attributes: "dir/", then the "dir/.keep" earlier versions of this
+// filesystem wrote and, from older versions of C++ s3fs, "dir" with the
+// directory Content-Type. The root has .keep, preceded by the "prefix/" marker
+// of a mounted prefix. Usually there is at most one; the lookup stops after
+// limit markers (0 = no limit)
+func dirMarkers(ctx context.Context, backend types.Backend, prefix string, limit int) ([]string, []*types.Attr, error) {
+	candidates := []string{".keep"}
+	if prefix == "" && hasRootKey(backend) {
+		candidates = []string{"", ".keep"}
+	} else if prefix != "" {
+		candidates = []string{prefix, prefix + ".keep", strings.TrimSuffix(prefix, "/")}
+	}
+	var keys []string
+	var attrs []*types.Attr
+	for i, key := range candidates {
+		attr, err := backend.GetAttr(ctx, key)
+		if err != nil {
+			if types.IsUnavailable(err) {
+				return nil, nil, err
+			}
+			continue
+		}
+		if i == 2 && attr.ContentType != dirContentType {
+			continue // A file, not a marker
+		}
+		keys = append(keys, key)
+		attrs = append(attrs, attr)
+		if len(keys) == limit {
+			break
+		}
+	}
+	return keys, attrs, nil
+}
+
+// hasRootKey reports whether the root of backend is a key prefix, which can
+// have a "prefix/" marker of its own as any other directory does
+func hasRootKey(backend types.Backend) bool {
+	keyed, ok := backend.(types.RootKeyer)
+	return ok && keyed.HasRootKey()
+}
+
+// dirMarker returns the marker holding the metadata of the directory prefix
+// and its attributes. Without one it returns the key Mkdir would create and
+// an error wrapping os.ErrNotExist
+func (fs *Filesystem) dirMarker(ctx context.Context, backend types.Backend, prefix string) (string, *types.Attr, error) {
+	keys, attrs, err := dirMarkers(ctx, backend, prefix, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) == 0 {
+		return fs.newDirMarker(prefix), nil, fmt.Errorf("no marker for directory %q: %w", prefix, os.ErrNotExist)
+	}
+	return keys[0], attrs[0], nil
+}
+
+// markDirType adds the directory Content-Type to the metadata of a "dir/"
+// marker, so C++ s3fs also recognizes it, and so a legacy "dir" marker isn't
+// written back as a plain file
+func markDirType(key string, metadata map[string]string) {
+	if !strings.HasSuffix(key, "/.keep") && key != ".keep" {
+		metadata[s3client.ContentTypeKey] = dirContentType
+	}
+}
+
+// writeDirMarker stores the marker key of a directory with metadata
+func writeDirMarker(ctx context.Context, backend types.Backend, key string, metadata map[string]string) error {
+	markDirType(key, metadata)
+	return backend.WriteWithMetadata(ctx, key, []byte{}, metadata)
+}
+
+// MigrateDirMarkers replaces the "dir/.keep" markers of the directories under
+// path, path included, with "dir/" markers carrying the same metadata, and
+// returns how many directories it migrated. A .keep next to an existing "dir/"
+// marker is only deleted. The root keeps its .keep, having no other key.
+// Mounts creating DirMarkerKeep markers can't migrate away from them
+func (fs *Filesystem) MigrateDirMarkers(ctx context.Context, path string) (int, error) {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return 0, syscall.EROFS
+	}
+	if fs.dirMarkerStyle == DirMarkerKeep {
+		return 0, fmt.Errorf("cannot migrate directory markers to \"dir/\" while creating %q markers", DirMarkerKeep)
+	}
+	backend := fs.getBackend()
+	if backend == nil {
+		return 0, fmt.Errorf("no storage backend available")
+	}
+	prefix := fs.normalizePath(path)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var legacy []string
+	err := types.ListFunc(ctx, backend, prefix, listPageSize, func(keys []string) error {
+		for _, key := range keys {
+			if strings.HasSuffix(key, "/.keep") {
+				legacy = append(legacy, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list directory markers: %w", err)
+	}
+
+	migrated := 0
+	for _, key := range legacy {
+		dir := strings.TrimSuffix(key, ".keep")
+		exists, err := backend.Exists(ctx, dir)
+		if err != nil {
+			return migrated, err
+		}
+		if !exists {
+			metadata, err := backend.GetMetadata(ctx, key)
+			if err != nil {
+				return migrated, fmt.Errorf("failed to read metadata of %s: %w", key, err)
+			}
+			if err := writeDirMarker(ctx, backend, dir, metadata); err != nil {
+				return migrated, fmt.Errorf("failed to create marker for %s: %w", dir, err)
+			}
+			migrated++
+		}
+		if err := backend.Delete(ctx, key); err != nil {
+			return migrated, fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		if fs.cache != nil {
+			statCache := fs.cache.GetStatCache()
+			statCache.Delete(dir)
+			statCache.Delete("/" + strings.TrimSuffix(dir, "/"))
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,335 @@
+package fuse
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+// seedS3fsBucket stores objects as C++ s3fs leaves them: "dir/" markers of
+// type application/x-directory, decimal st_mode and fractional mtimes
+func seedS3fsBucket(t *testing.T, client *s3client.MockClient) {
+	t.Helper()
+	ctx := context.Background()
+	objects := []struct {
+		key      string
+		data     string
+		metadata map[string]string
+	}{
+		{"photos/", "", map[string]string{"content-type": dirContentType, "mode": "16872", "uid": "1000", "gid": "1000", "mtime": "1700000000.250000000"}},
+		{"photos/2023/", "", map[string]string{"content-type": dirContentType, "mode": "16877", "mtime": "1700000100"}},
+		{"photos/2023/cat.jpg", "meow", map[string]string{"mode": "33184", "uid": "1000", "gid": "1000", "mtime": "1700000200.5"}},
+		{"legacy", "", map[string]string{"content-type": dirContentType, "mode": "16832"}},
+		{"empty/", "", map[string]string{"content-type": dirContentType, "mode": "16877"}},
+	}
+	for _, object := range objects {
+		if err := client.PutObjectWithMetadata(ctx, object.key, []byte(object.data), object.metadata); err != nil {
+			t.Fatalf("Failed to seed %s: %v", object.key, err)
+		}
+	}
+}
+
+func TestS3fsCompatibleObjects(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	seedS3fsBucket(t, client)
+	fs := NewFilesystem(client)
+	fs.SetAtimeMode(AtimeOff)
+
+	tests := []struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}{
+		{"/photos", os.ModeDir | 0750, time.Unix(1700000000, 250000000)},
+		{"/photos/2023", os.ModeDir | 0755, time.Unix(1700000100, 0)},
+		{"/photos/2023/cat.jpg", 0640, time.Unix(1700000200, 500000000)},
+		{"/legacy", os.ModeDir | 0700, time.Time{}},
+		{"/empty", os.ModeDir | 0755, time.Time{}},
+	}
+	for _, tt := range tests {
+		attr, err := fs.GetAttr(ctx, tt.path)
+		if err != nil {
+			t.Errorf("GetAttr(%s) failed: %v", tt.path, err)
+			continue
+		}
+		if attr.Mode != tt.mode {
+			t.Errorf("GetAttr(%s) mode = %v, want %v", tt.path, attr.Mode, tt.mode)
+		}
+		if !tt.mtime.IsZero() && !attr.Mtime.Equal(tt.mtime) {
+			t.Errorf("GetAttr(%s) mtime = %v, want %v", tt.path, attr.Mtime, tt.mtime)
+		}
+	}
+	if attr, _ := fs.GetAttr(ctx, "/photos"); attr != nil && (attr.Uid != 1000 || attr.Gid != 1000) {
+		t.Errorf("GetAttr(/photos) owner = %d:%d, want 1000:1000", attr.Uid, attr.Gid)
+	}
+
+	entries, err := fs.ReadDir(ctx, "/photos")
+	if err != nil {
+		t.Fatalf("ReadDir(/photos) failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "2023" || !entries[0].IsDir {
+		t.Errorf("ReadDir(/photos) = %+v, want the 2023 directory alone", entries)
+	}
+	for _, dir := range []string{"/empty", "/legacy"} {
+		if entries, err := fs.ReadDir(ctx, dir); err != nil || len(entries) != 0 {
+			t.Errorf("ReadDir(%s) = %+v, %v; want an empty directory", dir, entries, err)
+		}
+	}
+
+	// Changes go to the existing marker, which stays a directory for C++ s3fs
+	if err := fs.Chmod(ctx, "/photos/2023", 0700); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if attr, err := fs.GetAttr(ctx, "/photos/2023"); err != nil || attr.Mode != os.ModeDir|0700 {
+		t.Errorf("GetAttr after Chmod = %v, %v; want mode %v", attr, err, os.ModeDir|0700)
+	}
+	if info, err := client.HeadObjectFull(ctx, "photos/2023/"); err != nil || info.ContentType != dirContentType {
+		t.Errorf("Marker after Chmod = %+v, %v; want Content-Type %s", info, err, dirContentType)
+	}
+	if _, err := client.HeadObject(ctx, "photos/2023/.keep"); err == nil {
+		t.Error("Chmod created a .keep marker next to the s3fs one")
+	}
+
+	// Removing an empty directory removes its marker, whichever kind
+	for _, dir := range []string{"/empty", "/legacy"} {
+		if err := fs.Rmdir(ctx, dir); err != nil {
+			t.Errorf("Rmdir(%s) failed: %v", dir, err)
+		}
+		if _, err := fs.GetAttr(ctx, dir); err == nil {
+			t.Errorf("%s still exists after Rmdir", dir)
+		}
+	}
+}
+
+func TestMkdirMarker(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+
+	if err := fs.Mkdir(ctx, "/newdir", 0750); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	info, err := client.HeadObjectFull(ctx, "newdir/")
+	if err != nil {
+		t.Fatalf("Mkdir did not create a newdir/ marker: %v", err)
+	}
+	if info.ContentType != dirContentType || info.Size != 0 {
+		t.Errorf("Marker has Content-Type %q and size %d, want %s and 0", info.ContentType, info.Size, dirContentType)
+	}
+	if info.Metadata["x-amz-meta-mode"] != "16872" {
+		t.Errorf("Marker mode = %q, want decimal st_mode 16872", info.Metadata["x-amz-meta-mode"])
+	}
+	if _, err := client.HeadObject(ctx, "newdir/.keep"); err == nil {
+		t.Error("Mkdir created a .keep marker")
+	}
+
+	if attr, err := fs.GetAttr(ctx, "/newdir"); err != nil || attr.Mode != os.ModeDir|0750 {
+		t.Errorf("GetAttr(/newdir) = %v, %v; want mode %v", attr, err, os.ModeDir|0750)
+	}
+	if err := fs.Mkdir(ctx, "/newdir", 0750); err == nil {
+		t.Error("Expected error when creating existing directory")
+	}
+	if err := fs.Rmdir(ctx, "/newdir"); err != nil {
+		t.Fatalf("Rmdir failed: %v", err)
+	}
+	if objects, _ := client.ListObjects(ctx, "newdir"); len(objects) != 0 {
+		t.Errorf("Objects remain after Rmdir: %v", objects)
+	}
+}
+
+func TestBareSlashMarkerDirs(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	// "reports/" as tools that record ownership leave it, without a Content-Type,
+	// and "console/" as the AWS console creates folders, with no metadata at all
+	client.PutObjectWithMetadata(ctx, "reports/", nil, map[string]string{"mode": "16872", "uid": "1001", "gid": "1002", "mtime": "1700000000"})
+	client.PutObject(ctx, "reports/q1.csv", []byte("a,b"))
+	client.PutObject(ctx, "console/", nil)
+	fs := NewFilesystem(client)
+
+	attr, err := fs.GetAttr(ctx, "/reports")
+	if err != nil {
+		t.Fatalf("GetAttr(/reports) failed: %v", err)
+	}
+	if attr.Mode != os.ModeDir|0750 || attr.Uid != 1001 || attr.Gid != 1002 || !attr.Mtime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("GetAttr(/reports) = mode %v, owner %d:%d, mtime %v; want the marker's metadata", attr.Mode, attr.Uid, attr.Gid, attr.Mtime)
+	}
+	if attr, err := fs.GetAttr(ctx, "/console"); err != nil || attr.Mode != os.ModeDir|DefaultDirMode {
+		t.Errorf("GetAttr(/console) = %v, %v; want a directory with the default mode", attr, err)
+	}
+
+	entries, err := fs.ReadDir(ctx, "/")
+	if err != nil {
+		t.Fatalf("ReadDir(/) failed: %v", err)
+	}
+	if len(entries) != 2 || !entries[0].IsDir || !entries[1].IsDir {
+		t.Errorf("ReadDir(/) = %+v, want the console and reports directories", entries)
+	}
+	if entries, err := fs.ReadDir(ctx, "/reports"); err != nil || len(entries) != 1 || entries[0].Name != "q1.csv" {
+		t.Errorf("ReadDir(/reports) = %+v, %v; want q1.csv alone", entries, err)
+	}
+	if err := fs.Rmdir(ctx, "/console"); err != nil {
+		t.Errorf("Rmdir(/console) failed: %v", err)
+	}
+	if _, err := client.HeadObject(ctx, "console/"); err == nil {
+		t.Error("Rmdir left the console/ marker")
+	}
+}
+
+func TestDirMarkerStyleKeep(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	client.PutObjectWithMetadata(ctx, "shared/", nil, map[string]string{"content-type": dirContentType, "mode": "16877"})
+	fs := NewFilesystem(client)
+	fs.SetDirMarkerStyle(DirMarkerKeep)
+
+	if err := fs.Mkdir(ctx, "/newdir", 0750); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, err := client.HeadObject(ctx, "newdir/.keep"); err != nil {
+		t.Errorf("Mkdir did not create a newdir/.keep marker: %v", err)
+	}
+	if _, err := client.HeadObject(ctx, "newdir/"); err == nil {
+		t.Error("Mkdir created a newdir/ marker")
+	}
+	if attr, err := fs.GetAttr(ctx, "/newdir"); err != nil || attr.Mode != os.ModeDir|0750 {
+		t.Errorf("GetAttr(/newdir) = %v, %v; want mode %v", attr, err, os.ModeDir|0750)
+	}
+
+	// Directories with the other style are still read and changed in place
+	if err := fs.Chmod(ctx, "/shared", 0700); err != nil {
+		t.Fatalf("Chmod(/shared) failed: %v", err)
+	}
+	if attr, err := fs.GetAttr(ctx, "/shared"); err != nil || attr.Mode != os.ModeDir|0700 {
+		t.Errorf("GetAttr(/shared) = %v, %v; want mode %v", attr, err, os.ModeDir|0700)
+	}
+	if _, err := client.HeadObject(ctx, "shared/.keep"); err == nil {
+		t.Error("Chmod created a .keep marker next to the shared/ one")
+	}
+
+	if _, err := fs.MigrateDirMarkers(ctx, "/"); err == nil {
+		t.Error("MigrateDirMarkers succeeded on a mount creating .keep markers")
+	}
+
+	for name, want := range map[string]DirMarkerStyle{"": DirMarkerSlash, "slash": DirMarkerSlash, "KEEP": DirMarkerKeep} {
+		if style, err := ParseDirMarkerStyle(name); err != nil || style != want {
+			t.Errorf("ParseDirMarkerStyle(%q) = %q, %v; want %q", name, style, err, want)
+		}
+	}
+	if _, err := ParseDirMarkerStyle("slashes"); err == nil {
+		t.Error("ParseDirMarkerStyle accepted an unknown style")
+	}
+}
+
+// seedKeepBucket stores directories as earlier versions of this filesystem
+// created them, with "dir/.keep" markers
+func seedKeepBucket(t *testing.T, client *s3client.MockClient) {
+	t.Helper()
+	ctx := context.Background()
+	objects := []struct {
+		key      string
+		data     string
+		metadata map[string]string
+	}{
+		{"docs/.keep", "", map[string]string{"mode": "16872", "uid": "1000", "gid": "1000", "mtime": "1700000000", "x-amz-meta-xattr-user.tag": "blue"}},
+		{"docs/readme.txt", "hello", map[string]string{"mode": "33188"}},
+		{"docs/old/.keep", "", map[string]string{"mode": "16877"}},
+		{"both/", "", map[string]string{"content-type": dirContentType, "mode": "16832"}},
+		{"both/.keep", "", map[string]string{"mode": "16877"}},
+		{"bare/.keep", "", map[string]string{"mode": "16877"}},
+	}
+	for _, object := range objects {
+		if err := client.PutObjectWithMetadata(ctx, object.key, []byte(object.data), object.metadata); err != nil {
+			t.Fatalf("Failed to seed %s: %v", object.key, err)
+		}
+	}
+}
+
+func TestLegacyKeepDirs(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	seedKeepBucket(t, client)
+	fs := NewFilesystem(client)
+	fs.SetAtimeMode(AtimeOff)
+
+	// Honored for attributes, hidden from listings
+	if attr, err := fs.GetAttr(ctx, "/docs"); err != nil || attr.Mode != os.ModeDir|0750 || attr.Uid != 1000 {
+		t.Errorf("GetAttr(/docs) = %+v, %v; want mode %v owned by 1000", attr, err, os.ModeDir|0750)
+	}
+	entries, err := fs.ReadDir(ctx, "/docs")
+	if err != nil {
+		t.Fatalf("ReadDir(/docs) failed: %v", err)
+	}
+	names := map[string]bool{}
+	for _, entry := range entries {
+		names[entry.Name] = entry.IsDir
+	}
+	if len(names) != 2 || names["readme.txt"] || !names["old"] {
+		t.Errorf("ReadDir(/docs) = %+v, want readme.txt and the old directory", entries)
+	}
+	if entries, err := fs.ReadDir(ctx, "/docs/old"); err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir(/docs/old) = %+v, %v; want an empty directory", entries, err)
+	}
+
+	// Shown for debugging, the marker still leaves the directory empty
+	fs.SetShowDirMarkers(true)
+	if entries, err := fs.ReadDir(ctx, "/docs/old"); err != nil || len(entries) != 1 || entries[0].Name != ".keep" || entries[0].IsDir {
+		t.Errorf("ReadDir(/docs/old) showing markers = %+v, %v; want the .keep file", entries, err)
+	}
+	if empty, err := fs.isEmptyDir(ctx, "/docs/old"); err != nil || !empty {
+		t.Errorf("isEmptyDir(/docs/old) showing markers = %v, %v; want empty", empty, err)
+	}
+	fs.SetShowDirMarkers(false)
+
+	// Changes stay on the .keep marker
+	if err := fs.Chmod(ctx, "/docs/old", 0700); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if metadata, err := client.HeadObjectFull(ctx, "docs/old/.keep"); err != nil || metadata.Metadata["mode"] != "16832" {
+		t.Errorf("Marker after Chmod = %+v, %v; want mode 16832", metadata, err)
+	}
+	if _, err := client.HeadObject(ctx, "docs/old/"); err == nil {
+		t.Error("Chmod created a docs/old/ marker next to the .keep one")
+	}
+
+	if err := fs.Rmdir(ctx, "/bare"); err != nil {
+		t.Fatalf("Rmdir(/bare) failed: %v", err)
+	}
+	if objects, _ := client.ListObjects(ctx, "bare"); len(objects) != 0 {
+		t.Errorf("Objects remain after Rmdir: %v", objects)
+	}
+
+	// Migration moves the metadata to "dir/" markers
+	migrated, err := fs.MigrateDirMarkers(ctx, "/")
+	if err != nil {
+		t.Fatalf("MigrateDirMarkers failed: %v", err)
+	}
+	if migrated != 2 {
+		t.Errorf("MigrateDirMarkers migrated %d directories, want 2", migrated)
+	}
+	for _, key := range []string{"docs/.keep", "docs/old/.keep", "both/.keep"} {
+		if _, err := client.HeadObject(ctx, key); err == nil {
+			t.Errorf("%s remains after migration", key)
+		}
+	}
+	info, err := client.HeadObjectFull(ctx, "docs/")
+	if err != nil {
+		t.Fatalf("Migration did not create docs/: %v", err)
+	}
+	if info.ContentType != dirContentType || info.Metadata["x-amz-meta-xattr-user.tag"] != "blue" {
+		t.Errorf("Migrated marker = %+v, want Content-Type %s and the xattr", info, dirContentType)
+	}
+	tests := map[string]os.FileMode{"/docs": os.ModeDir | 0750, "/docs/old": os.ModeDir | 0700, "/both": os.ModeDir | 0700}
+	for path, mode := range tests {
+		if attr, err := fs.GetAttr(ctx, path); err != nil || attr.Mode != mode {
+			t.Errorf("GetAttr(%s) after migration = %+v, %v; want mode %v", path, attr, err, mode)
+		}
+	}
+	if data, err := fs.ReadFile(ctx, "/docs/readme.txt", 0, 0); err != nil || string(data) != "hello" {
+		t.Errorf("ReadFile after migration = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,132 @@
+package fuse
+
+import (
+	"context"
+	"io"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/cache"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// SetDiskCache keeps a copy of every file read or uploaded in dir, using at most
+// maxSize bytes (0 = cache.DefaultDiskCacheSize). A read the page cache misses
+// is served from the copy while the file's ETag still matches; otherwise the
+// whole file is downloaded once and kept. Files larger than maxSize, and files
+// on backends that report no ETag, are read from the backend as before. An
+// empty dir disables the disk cache
+func (fs *Filesystem) SetDiskCache(dir string, maxSize int64) error {
+	if dir == "" {
+		fs.diskCache = nil
+		return nil
+	}
+	diskCache, err := cache.NewDiskCache(dir, maxSize)
+	if err != nil {
+		return err
+	}
+	fs.diskCache = diskCache
+	return nil
+}
+
+// objectVersion returns the ETag and size of the stored file, from the stat
+// cache when it knows them
+func (fs *Filesystem) objectVersion(ctx context.Context, backend types.Backend, path, normalizedPath string) (string, int64) {
+	if fs.cache != nil {
+		if entry, found := fs.cache.GetStatCache().Get(path); found && entry.Attr != nil && entry.Attr.ETag != "" {
+			return entry.Attr.ETag, entry.Attr.Size
+		}
+	}
+	attr, err := backend.GetAttr(ctx, normalizedPath)
+	if err != nil {
+		return "", 0
+	}
+	return attr.ETag, attr.Size
+}
+
+// readDiskCache serves a read from the disk cache, downloading the whole file
+// into it chunk by chunk first if its copy is missing or out of date. It reports false when
+// the file can't be cached, leaving the read to the caller
+func (fs *Filesystem) readDiskCache(ctx context.Context, backend types.Backend, path, normalizedPath string, offset, size int64) ([]byte, bool) {
+	etag, fileSize := fs.objectVersion(ctx, backend, path, normalizedPath)
+	if etag == "" || fileSize > fs.diskCache.MaxSize() {
+		return nil, false
+	}
+	if data, found := fs.diskCache.ReadAt(normalizedPath, etag, offset, size); found {
+		return data, true
+	}
+
+	reader := &rangeReader{ctx: ctx, backend: backend, path: normalizedPath, etag: etag, size: fileSize}
+	if err := fs.diskCache.StoreFrom(normalizedPath, etag, fileSize, reader); err != nil {
+		logging.Warn("failed to add file to disk cache", "path", normalizedPath, "err", err)
+		return nil, false
+	}
+	return fs.diskCache.ReadAt(normalizedPath, etag, offset, size)
+}
+
+// diskCacheFillChunk is how much of a file is downloaded at once while it is
+// copied into the disk cache
+const diskCacheFillChunk = 8 * 1024 * 1024
+
+// rangeReader reads the first size bytes of a stored file with range reads of
+// at most diskCacheFillChunk bytes, so no more than one chunk is in memory.
+// On backends that can read conditionally every chunk must come from the
+// version with the given ETag, so a file replaced during the download fails
+// the fill instead of being cached as a mix of both versions
+type rangeReader struct {
+	ctx     context.Context
+	backend types.Backend
+	path    string
+	etag    string
+	offset  int64
+	size    int64
+	buf     []byte
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		end := min(r.offset+diskCacheFillChunk, r.size)
+		var data []byte
+		var err error
+		if reader, ok := r.backend.(types.ConditionalReader); ok {
+			data, err = reader.ReadRangeIfMatch(r.ctx, r.path, r.offset, end-1, r.etag)
+		} else {
+			data, err = r.backend.ReadRange(r.ctx, r.path, r.offset, end-1)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(data) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.offset += int64(len(data))
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// storeDiskCache replaces the disk cache copy of a file with the data just
+// uploaded; attr is the file's attributes after the upload (nil if unknown)
+func (fs *Filesystem) storeDiskCache(normalizedPath string, attr *types.Attr, data []byte) {
+	if fs.diskCache == nil {
+		return
+	}
+	etag := ""
+	if attr != nil {
+		etag = attr.ETag
+	}
+	if err := fs.diskCache.Store(normalizedPath, etag, data); err != nil {
+		logging.Warn("failed to update disk cache", "path", normalizedPath, "err", err)
+	}
+}
+
+// forgetDiskCache drops the disk cache copy of a file that was removed or moved
+func (fs *Filesystem) forgetDiskCache(normalizedPath string) {
+	if fs.diskCache != nil {
+		fs.diskCache.Remove(normalizedPath)
+	}
+}
@@ -0,0 +1,203 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+// newDiskCacheFilesystem mounts client with a disk cache in dir, as a new mount
+// would, so the page and stat caches start empty
+func newDiskCacheFilesystem(t *testing.T, client *s3client.MockClient, dir string, maxSize int64) *Filesystem {
+	filesystem := NewFilesystem(client)
+	if err := filesystem.SetDiskCache(dir, maxSize); err != nil {
+		t.Fatalf("SetDiskCache failed: %v", err)
+	}
+	return filesystem
+}
+
+func TestDiskCacheHit(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := client.PutObject(ctx, "big.bin", []byte("0123456789")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	first := newDiskCacheFilesystem(t, client, dir, 1024)
+	if data, err := first.ReadFile(ctx, "big.bin", 2, 4); err != nil || string(data) != "2345" {
+		t.Fatalf("ReadFile = %q, %v; want %q", data, err, "2345")
+	}
+	if n := client.GetCount(); n != 1 {
+		t.Errorf("First read made %d GETs, want 1 for the whole file", n)
+	}
+
+	// Another mount reads from the disk without downloading again
+	second := newDiskCacheFilesystem(t, client, dir, 1024)
+	for _, offset := range []int64{0, 6} {
+		if data, err := second.ReadFile(ctx, "big.bin", offset, 4); err != nil || string(data) != string("0123456789"[offset:offset+4]) {
+			t.Errorf("ReadFile at %d = %q, %v", offset, data, err)
+		}
+	}
+	if n := client.GetCount(); n != 1 {
+		t.Errorf("Reads of the cached file made %d GETs in total, want 1", n)
+	}
+}
+
+func TestDiskCacheETagInvalidation(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	dir := t.TempDir()
+	client.PutObject(ctx, "file.txt", []byte("old content"))
+
+	first := newDiskCacheFilesystem(t, client, dir, 1024)
+	if data, _ := first.ReadFile(ctx, "file.txt", 0, 0); string(data) != "old content" {
+		t.Fatalf("ReadFile = %q", data)
+	}
+
+	// Modified by another client
+	client.PutObject(ctx, "file.txt", []byte("new content!"))
+	second := newDiskCacheFilesystem(t, client, dir, 1024)
+	if data, err := second.ReadFile(ctx, "file.txt", 0, 0); err != nil || string(data) != "new content!" {
+		t.Errorf("ReadFile after external change = %q, %v; want the new content", data, err)
+	}
+	if n := client.GetCount(); n != 2 {
+		t.Errorf("Made %d GETs, want 2: the changed file is downloaded again", n)
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, key := range []string{"a.bin", "b.bin", "c.bin"} {
+		client.PutObject(ctx, key, []byte("123456"))
+	}
+
+	filesystem := newDiskCacheFilesystem(t, client, dir, 12)
+	for _, key := range []string{"a.bin", "b.bin", "c.bin"} {
+		if _, err := filesystem.ReadFile(ctx, key, 0, 0); err != nil {
+			t.Fatalf("ReadFile %s failed: %v", key, err)
+		}
+	}
+	if size := filesystem.diskCache.Size(); size > 12 {
+		t.Errorf("Disk cache holds %d bytes, over its 12 byte limit", size)
+	}
+
+	// The first file was evicted to make room for the third
+	remount := newDiskCacheFilesystem(t, client, dir, 12)
+	before := client.GetCount()
+	for _, key := range []string{"b.bin", "c.bin"} {
+		remount.ReadFile(ctx, key, 0, 0)
+	}
+	if n := client.GetCount() - before; n != 0 {
+		t.Errorf("Reading the files still cached made %d GETs, want 0", n)
+	}
+	remount.ReadFile(ctx, "a.bin", 0, 0)
+	if n := client.GetCount() - before; n != 1 {
+		t.Errorf("Reading the evicted file made %d GETs, want 1", n)
+	}
+}
+
+func TestDiskCacheWrite(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	filesystem := newDiskCacheFilesystem(t, client, dir, 1024)
+	if err := filesystem.WriteFile(ctx, "written.txt", []byte("fresh data"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := filesystem.Release(ctx, "written.txt"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// The upload left its data in the disk cache
+	remount := newDiskCacheFilesystem(t, client, dir, 1024)
+	before := client.GetCount()
+	if data, err := remount.ReadFile(ctx, "written.txt", 0, 0); err != nil || string(data) != "fresh data" {
+		t.Errorf("ReadFile = %q, %v; want %q", data, err, "fresh data")
+	}
+	if n := client.GetCount() - before; n != 0 {
+		t.Errorf("Reading a file just written made %d GETs, want 0", n)
+	}
+
+	if err := remount.Remove(ctx, "written.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if size := remount.diskCache.Size(); size != 0 {
+		t.Errorf("Disk cache holds %d bytes after the file was removed, want 0", size)
+	}
+}
+
+func TestDiskCacheFillIsChunked(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	content := make([]byte, 2*diskCacheFillChunk+100)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+	client.PutObject(ctx, "large.bin", content)
+	backend := &largestReadBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), t: t}
+	filesystem := NewFilesystemWithBackend(backend)
+	if err := filesystem.SetDiskCache(t.TempDir(), 0); err != nil {
+		t.Fatalf("SetDiskCache failed: %v", err)
+	}
+
+	offset := int64(diskCacheFillChunk + 10)
+	data, err := filesystem.ReadFile(ctx, "/large.bin", offset, 4096)
+	if err != nil || !bytes.Equal(data, content[offset:offset+4096]) {
+		t.Fatalf("ReadFile = %d bytes, %v; want the stored content", len(data), err)
+	}
+	if backend.largest > diskCacheFillChunk {
+		t.Errorf("Largest backend read was %d bytes, want at most %d", backend.largest, diskCacheFillChunk)
+	}
+	if n := client.GetCount(); n != 3 {
+		t.Errorf("Filling the disk cache made %d GETs, want 3", n)
+	}
+	if size := filesystem.diskCache.Size(); size != int64(len(content)) {
+		t.Errorf("Disk cache holds %d bytes, want %d", size, len(content))
+	}
+}
+
+// replacingBackend overwrites the file with new content after the first
+// conditional range read, as another client might during a download
+type replacingBackend struct {
+	*s3Adapter
+	client   *s3client.MockClient
+	replaced bool
+}
+
+func (b *replacingBackend) ReadRangeIfMatch(ctx context.Context, path string, start, end int64, etag string) ([]byte, error) {
+	data, err := b.s3Adapter.ReadRangeIfMatch(ctx, path, start, end, etag)
+	if !b.replaced {
+		b.replaced = true
+		b.client.PutObject(ctx, strings.TrimPrefix(path, "/"), bytes.Repeat([]byte("n"), 2*diskCacheFillChunk))
+	}
+	return data, err
+}
+
+func TestDiskCacheFillIsPinnedToOneVersion(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "large.bin", bytes.Repeat([]byte("o"), 2*diskCacheFillChunk))
+	backend := &replacingBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), client: client}
+	filesystem := NewFilesystemWithBackend(backend)
+	if err := filesystem.SetDiskCache(t.TempDir(), 0); err != nil {
+		t.Fatalf("SetDiskCache failed: %v", err)
+	}
+
+	// The second chunk no longer matches, so nothing is cached and the read
+	// goes to the backend
+	offset := int64(diskCacheFillChunk + 10)
+	data, err := filesystem.ReadFile(ctx, "/large.bin", offset, 4096)
+	if err != nil || !bytes.Equal(data, bytes.Repeat([]byte("n"), 4096)) {
+		t.Fatalf("ReadFile = %d bytes, %v; want the new content", len(data), err)
+	}
+	if size := filesystem.diskCache.Size(); size != 0 {
+		t.Errorf("Disk cache holds %d bytes of a file replaced during its download, want 0", size)
+	}
+}
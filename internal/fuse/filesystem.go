@@ -2,24 +2,62 @@ package fuse
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/s3fs-fuse/s3fs-go/internal/cache"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/encrypt"
 	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
+// blockUnit is the unit of st_blocks as reported to the kernel
+const blockUnit = 512
+
+const (
+	// DefaultFileMode is the mode reported for files without mode metadata
+	DefaultFileMode os.FileMode = 0644
+	// DefaultDirMode is the mode reported for directories without mode metadata
+	DefaultDirMode os.FileMode = 0755
+)
+
+const (
+	// DefaultAttrCacheTimeout is how long the kernel may cache attributes by default
+	DefaultAttrCacheTimeout = time.Minute
+	// DefaultEntryCacheTimeout is how long the kernel may cache name lookups by default
+	DefaultEntryCacheTimeout = time.Minute
+	// DefaultMaxReadSize is the most a read to the end of a file may return by default
+	DefaultMaxReadSize = 64 * 1024 * 1024
+)
+
 // Attr represents file attributes
 type Attr struct {
-	Mode  os.FileMode
-	Size  int64
-	Mtime time.Time
-	Uid   uint32
-	Gid   uint32
+	Mode   os.FileMode
+	Size   int64
+	Blocks uint64 // Number of 512-byte blocks (st_blocks), derived from the logical size
+	Mtime  time.Time
+	Ctime  time.Time // Last content or metadata change (chmod, chown, xattrs)
+	Atime  time.Time // Last read, as often as the atime mode records it
+	Uid    uint32
+	Gid    uint32
+	Inode  uint64 // Stable number derived from the path (see inodeFor)
+}
+
+// blocksForSize returns the number of 512-byte blocks needed to hold size bytes
+func blocksForSize(size int64) uint64 {
+	if size <= 0 {
+		return 0
+	}
+	return uint64((size + blockUnit - 1) / blockUnit)
 }
 
 // DirEntry represents a directory entry
@@ -38,6 +76,7 @@ type S3ClientInterface interface {
 	DeleteObject(ctx context.Context, key string) error
 	HeadObject(ctx context.Context, key string) (map[string]string, error)
 	HeadObjectSize(ctx context.Context, key string) (int64, error)
+	HeadObjectFull(ctx context.Context, key string) (*s3client.ObjectInfo, error)
 	CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error
 	CopyObjectMultipart(ctx context.Context, sourceKey, destKey string) error
 	CreateBucket(ctx context.Context) error
@@ -51,6 +90,45 @@ type Filesystem struct {
 	cache           *cache.Manager
 	maxDirtyData    int64 // Maximum bytes to buffer before auto-upload (default: 10MB)
 	enableFileLock  bool  // Enable file-level advisory locking (default: false, uses entity-level locking)
+	readOnly        bool  // Reject all modifications with EROFS (default: false)
+	forceUID        *uint32 // Report and store every object with this uid (nil = use stored owner)
+	forceGID        *uint32 // Report and store every object with this gid (nil = use stored owner)
+	defaultFileMode os.FileMode // Mode reported for files without mode metadata (default: 0644)
+	defaultDirMode  os.FileMode // Mode reported for directories without mode metadata (default: 0755)
+	umask           os.FileMode // Permission bits cleared from every reported mode (default: none)
+	forbiddenMode   os.FileMode // Mode bits chmod and creates may not set (default: none)
+	rejectForbidden bool  // Refuse forbidden mode bits with EPERM instead of clearing them (default: false)
+	detectContentType bool // Uploads set a Content-Type guessed from the file name or content (default: true)
+	atimeMode       AtimeMode // When reads update the stored access time (default: AtimeOff)
+	dirMarkerStyle  DirMarkerStyle // Kind of marker Mkdir creates (default: DirMarkerSlash)
+	showDirMarkers  bool  // ReadDir lists .keep markers as files (default: false)
+	atimeUpdating   sync.Map  // Paths whose access time is being stored
+	atimeFailedAt   atomic.Int64 // Unix nanoseconds of the last failed access time update (0 = none since)
+	statPrimeSize   int64 // GetAttr also caches content of files up to this size (0 = disabled)
+	readAheadSize   int64 // Bytes prefetched ahead of sequential reads (0 = disabled)
+	recursiveRmdir  bool  // Rmdir of a non-empty directory removes the whole tree (default: false)
+	rmdirFlush      bool  // Rmdir flushes buffered children and re-checks instead of refusing (default: false)
+	serveStale      bool  // Answer GetAttr/ReadFile from expired cache entries while the backend is unreachable (default: false)
+	strictDirs      bool  // Creating a file or directory requires an existing parent directory (default: false)
+	showVersions    bool  // Every directory has a read-only .versions pseudo-directory with its files' versions (default: false)
+	caseInsensitive bool  // Storage treats names differing only in case as one object (default: false)
+	attrTimeout     time.Duration // How long the kernel caches attributes (default: DefaultAttrCacheTimeout)
+	entryTimeout    time.Duration // How long the kernel caches lookups (default: DefaultEntryCacheTimeout)
+	kernel          entryInvalidator // Server the filesystem is mounted through (nil = not mounted)
+	maxReadSize     int64         // Most bytes a read to the end of a file may return (0 = unlimited)
+	removalMu       sync.Mutex
+	removing        map[string]int // Directory prefixes being deleted by RemoveAll (refcounted)
+	creating        pathLocks      // Serializes Create and Mkdir of the same path
+	renameMu        sync.Mutex
+	partialRenames  map[string]string // Destination prefix -> source prefix of directory renames that stopped part-way
+	releaseMu       sync.Mutex
+	failedReleases  map[string]int // Path -> releases whose upload failed; each still holds its FD cache reference
+	appendMu        sync.Mutex // Serializes appends on filesystems without a cache
+	writeBack       bool       // Writes only buffer; the flusher uploads in the background (default: false)
+	flusherMu       sync.Mutex
+	flusher         *writeBackFlusher // Running background flusher in write-back mode, else nil
+	diskCache       *cache.DiskCache  // Local copies of whole files (nil = disabled)
+	usage           *usageTracker     // Bytes stored under the mount (nil = not tracked)
 }
 
 // NewFilesystem creates a new filesystem instance with S3 client (backward compatibility)
@@ -61,20 +139,42 @@ func NewFilesystem(client S3ClientInterface) *Filesystem {
 // NewFilesystemWithBackend creates a new filesystem instance with a storage backend
 func NewFilesystemWithBackend(backend types.Backend) *Filesystem {
 	return &Filesystem{
-		backend:        backend,
-		cache:          cache.DefaultManager(),
-		maxDirtyData:   10 * 1024 * 1024, // Default: 10MB buffer
-		enableFileLock: false,            // Default: entity-level locking (Option 1)
+		backend:           backend,
+		cache:             cache.DefaultManager(),
+		maxDirtyData:      10 * 1024 * 1024, // Default: 10MB buffer
+		enableFileLock:    false,            // Default: entity-level locking (Option 1)
+		defaultFileMode:   DefaultFileMode,
+		defaultDirMode:    DefaultDirMode,
+		attrTimeout:       DefaultAttrCacheTimeout,
+		entryTimeout:      DefaultEntryCacheTimeout,
+		maxReadSize:       DefaultMaxReadSize,
+		detectContentType: true,
 	}
 }
 
+// NewFilesystemWithEncryption creates a filesystem over backend that encrypts
+// file contents before storing them, under masterKey (see storage/encrypt)
+func NewFilesystemWithEncryption(backend types.Backend, masterKey []byte) (*Filesystem, error) {
+	encrypted, err := encrypt.NewEncryptBackend(backend, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewFilesystemWithBackend(encrypted), nil
+}
+
 // NewFilesystemWithCache creates a new filesystem instance with custom cache settings
 func NewFilesystemWithCache(client *s3client.Client, cacheManager *cache.Manager) *Filesystem {
 	return &Filesystem{
-		client:         client,
-		cache:          cacheManager,
-		maxDirtyData:   10 * 1024 * 1024, // Default: 10MB buffer
-		enableFileLock: false,            // Default: entity-level locking (Option 1)
+		client:            client,
+		cache:             cacheManager,
+		maxDirtyData:      10 * 1024 * 1024, // Default: 10MB buffer
+		enableFileLock:    false,            // Default: entity-level locking (Option 1)
+		defaultFileMode:   DefaultFileMode,
+		defaultDirMode:    DefaultDirMode,
+		attrTimeout:       DefaultAttrCacheTimeout,
+		entryTimeout:      DefaultEntryCacheTimeout,
+		maxReadSize:       DefaultMaxReadSize,
+		detectContentType: true,
 	}
 }
 
@@ -90,6 +190,253 @@ func (fs *Filesystem) SetEnableFileLock(enable bool) {
 	fs.enableFileLock = enable
 }
 
+// SetDefaultFileMode sets the mode reported for files that carry no mode metadata
+func (fs *Filesystem) SetDefaultFileMode(mode os.FileMode) {
+	fs.defaultFileMode = mode & os.ModePerm
+}
+
+// SetDefaultDirMode sets the mode reported for directories that carry no mode metadata
+func (fs *Filesystem) SetDefaultDirMode(mode os.FileMode) {
+	fs.defaultDirMode = mode & os.ModePerm
+}
+
+// modeOf returns the permission bits for backend attributes, applying the default
+// mode when the object has no stored mode
+func (fs *Filesystem) modeOf(attr *types.Attr, isDir bool) os.FileMode {
+	if attr.DefaultMode {
+		if isDir {
+			return fs.defaultDirMode
+		}
+		return fs.defaultFileMode
+	}
+	return os.FileMode(attr.Mode)
+}
+
+// ctimeOf returns the change time for backend attributes, falling back to mtime
+// for objects (or backends) that carry no separate ctime
+func ctimeOf(attr *types.Attr) time.Time {
+	if attr.Ctime.IsZero() {
+		return attr.Mtime
+	}
+	return attr.Ctime
+}
+
+// atimeOf returns the access time for backend attributes, falling back to mtime
+// for objects (or backends) that carry no atime
+func atimeOf(attr *types.Attr) time.Time {
+	if attr.Atime.IsZero() {
+		return attr.Mtime
+	}
+	return attr.Atime
+}
+
+// nextTimestamp returns the mtime or ctime to store for a change made after prev
+// Timestamps are stored with second precision, so a change in the same second
+// as prev is pushed one second past it to keep the change observable. A prev
+// in the future (e.g. set with utimens) gives way to the current time, so
+// repeated changes never walk the timestamp further ahead of the clock
+func nextTimestamp(prev time.Time) time.Time {
+	now := time.Now()
+	if now.Unix() == prev.Unix() {
+		return prev.Add(time.Second)
+	}
+	return now
+}
+
+// SetStatPrimesRead makes GetAttr on files up to maxSize bytes also fetch and cache
+// their content, so the open+read that usually follows a stat is served locally
+// The content holds no file reference: it is evicted like any unused page, and
+// reads only use it while the stat cache still has the version it came from
+// A maxSize of 0 disables the optimization (default)
+func (fs *Filesystem) SetStatPrimesRead(maxSize int64) {
+	fs.statPrimeSize = maxSize
+}
+
+// primeRead caches the content of a small file in the FD cache after a stat
+// Best effort: failures simply leave the read to fetch from storage
+func (fs *Filesystem) primeRead(ctx context.Context, backend types.Backend, normalizedPath string, attr *types.Attr) {
+	if fs.cache == nil || attr.Size <= 0 || attr.Size > fs.statPrimeSize {
+		return
+	}
+
+	fdCache := fs.cache.GetFdCache()
+	if fdCache.HasOpenEntity(normalizedPath) {
+		return // Already cached (possibly with buffered writes)
+	}
+
+	data, err := backend.Read(ctx, normalizedPath)
+	if err != nil || int64(len(data)) != attr.Size {
+		return // Changed underneath us; don't cache a mismatched copy
+	}
+
+	// Cached without a reference: the read takes it over (see openPrimed), and
+	// a file that is only statted leaves it to be evicted
+	fdCache.Prime(normalizedPath, attr.Size, attr.Mtime, attr.ETag, data)
+}
+
+// openPrimed opens the entity primeRead cached for a file, if the stat cache
+// still has the version it holds
+func (fs *Filesystem) openPrimed(path, normalizedPath string) (*cache.FdEntity, bool) {
+	if fs.statPrimeSize <= 0 {
+		return nil, false
+	}
+	entry, found := fs.cache.GetStatCache().Get(path)
+	if !found || entry == nil || entry.Attr == nil {
+		return nil, false
+	}
+	return fs.cache.GetFdCache().OpenPrimed(normalizedPath, entry.Attr.ETag)
+}
+
+// SetReadAheadSize makes sequential reads of a file prefetch the next size bytes
+// in the background, so most reads are served from the page cache instead of
+// costing a ranged GET each. Prefetched pages are evicted like any other clean
+// page when the cache is full. A size of 0 disables readahead (default)
+func (fs *Filesystem) SetReadAheadSize(size int64) {
+	fs.readAheadSize = size
+}
+
+// readAhead records a read of n bytes at offset and, once reads are sequential,
+// fetches the data after it into the FD cache in the background
+func (fs *Filesystem) readAhead(normalizedPath string, entity *cache.FdEntity, offset, n int64) {
+	if fs.readAheadSize <= 0 {
+		return
+	}
+	backend := fs.getBackend()
+	if backend == nil {
+		return
+	}
+	start, end, ok := entity.StartReadAhead(offset, n, fs.readAheadSize)
+	if !ok {
+		return
+	}
+	go func() {
+		data, err := backend.ReadRange(context.Background(), normalizedPath, start, end-1)
+		if err != nil {
+			logging.Debug("readahead failed", "path", normalizedPath, "offset", start, "err", err)
+		}
+		entity.FinishReadAhead(start, data, err)
+	}()
+}
+
+// SetNegativeCacheTTL caches failed lookups for ttl so repeated stats of a
+// missing path don't reach the backend (0 disables negative caching)
+func (fs *Filesystem) SetNegativeCacheTTL(ttl time.Duration) {
+	if fs.cache != nil {
+		fs.cache.SetNegativeCacheTTL(ttl)
+	}
+}
+
+// SetPageCacheMemory bounds the memory the page cache of open files takes, in
+// bytes (0 = unlimited). Clean pages are evicted least recently used first;
+// writes that leave the cache over the bound upload the files buffering the
+// most data first, so their pages can be evicted too
+func (fs *Filesystem) SetPageCacheMemory(maxBytes int64) {
+	if fs.cache != nil {
+		fs.cache.GetFdCache().SetMaxMemory(maxBytes)
+	}
+}
+
+// SetMaxCachedPages caps how many clean pages the page cache keeps for each
+// file opened from now on; pages holding writes not yet uploaded are kept
+// even past it. 0 restores cache.DefaultMaxPages
+func (fs *Filesystem) SetMaxCachedPages(pages int) {
+	if fs.cache != nil {
+		fs.cache.GetFdCache().SetMaxPages(pages)
+	}
+}
+
+// SetMultipartCopyThreshold makes S3 copies of objects larger than size use
+// multipart copy instead of a single CopyObject. This applies to file renames and
+// to every object moved by a directory rename. 0 restores the default, which is
+// MaxCopyObjectSize. Other backends ignore the setting
+func (fs *Filesystem) SetMultipartCopyThreshold(size int64) {
+	if adapter, ok := fs.backend.(*s3Adapter); ok {
+		adapter.copyThreshold = size
+	}
+}
+
+// SetServeStaleOnError makes GetAttr and ReadFile fall back to the last cached
+// attributes and data, however old, when the backend cannot be reached, instead
+// of failing with EIO. Each fallback is logged as a warning. Writes are unaffected
+func (fs *Filesystem) SetServeStaleOnError(enable bool) {
+	fs.serveStale = enable
+	if fs.cache != nil {
+		fs.cache.GetStatCache().SetKeepExpired(enable)
+		fs.cache.GetFdCache().SetRetainClosed(enable)
+	}
+}
+
+// staleAttr returns the last cached attributes of path after the backend failed with err
+func (fs *Filesystem) staleAttr(path string, err error) (*Attr, bool) {
+	if !fs.serveStale || fs.cache == nil {
+		return nil, false
+	}
+	entry, found := fs.cache.GetStatCache().GetStale(path)
+	if !found {
+		return nil, false
+	}
+	logging.Warn("backend unreachable, serving stale attributes", "path", path, "cached_until", entry.ExpiresAt, "err", err)
+	cachedAttr := entry.Attr
+	return &Attr{
+		Mode:   os.FileMode(cachedAttr.Mode),
+		Size:   cachedAttr.Size,
+		Blocks: blocksForSize(cachedAttr.Size),
+		Mtime:  cachedAttr.Mtime,
+		Ctime:  cachedAttr.Ctime,
+		Atime:  cachedAttr.Atime,
+		Uid:    cachedAttr.Uid,
+		Gid:    cachedAttr.Gid,
+	}, true
+}
+
+// staleRead serves a read from whatever the FD cache still holds for path,
+// including entities kept after close, after the backend failed with err
+func (fs *Filesystem) staleRead(normalizedPath string, offset, size int64, err error) ([]byte, bool) {
+	if !fs.serveStale || fs.cache == nil {
+		return nil, false
+	}
+	entity, found := fs.cache.GetFdCache().Get(normalizedPath)
+	if !found {
+		return nil, false
+	}
+	data, found := readCachedEntity(entity, offset, size)
+	if !found {
+		return nil, false
+	}
+	logging.Warn("backend unreachable, serving stale cached data", "path", normalizedPath, "offset", offset, "size", len(data), "err", err)
+	return data, true
+}
+
+// SetReadOnly makes all write paths fail early with EROFS
+func (fs *Filesystem) SetReadOnly(readOnly bool) {
+	fs.readOnly = readOnly
+}
+
+// SetForceUID makes every object appear owned by uid, regardless of stored metadata
+// New objects are also stored with this uid
+func (fs *Filesystem) SetForceUID(uid uint32) {
+	fs.forceUID = &uid
+}
+
+// SetForceGID makes every object appear owned by gid, regardless of stored metadata
+// New objects are also stored with this gid
+func (fs *Filesystem) SetForceGID(gid uint32) {
+	fs.forceGID = &gid
+}
+
+// ownerForWrite returns the uid/gid to store on newly written objects
+func (fs *Filesystem) ownerForWrite() (uint32, uint32) {
+	uid := uint32(os.Getuid())
+	gid := uint32(os.Getgid())
+	if fs.forceUID != nil {
+		uid = *fs.forceUID
+	}
+	if fs.forceGID != nil {
+		gid = *fs.forceGID
+	}
+	return uid, gid
+}
+
 // normalizePath normalizes path (removes leading slash, ensures trailing slash for directories)
 func (fs *Filesystem) normalizePath(path string) string {
 	path = strings.TrimPrefix(path, "/")
@@ -113,56 +460,359 @@ func newS3Adapter(client S3ClientInterface) types.Backend {
 	return &s3Adapter{client: client}
 }
 
+// newPrefixedS3Adapter creates an S3 adapter whose paths are relative to
+// prefix, as given to ParseBucketPrefix
+func newPrefixedS3Adapter(client S3ClientInterface, prefix string) (*s3Adapter, error) {
+	prefix, err := ParseBucketPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Adapter{client: client, prefix: prefix}, nil
+}
+
+// ParseBucketPrefix checks the key prefix a mount exposes as its root and
+// returns it in the "team-a/data/" form keys are built from ("" for the whole
+// bucket). Leading and trailing slashes are optional; empty, "." and ".."
+// segments are rejected
+func ParseBucketPrefix(prefix string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "", nil
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid bucket prefix %q: empty, \".\" or \"..\" path segment", prefix)
+		}
+	}
+	return prefix + "/", nil
+}
+
+// NewFilesystemWithPrefix creates a filesystem whose root is the key prefix
+// of the client's bucket, e.g. "team-a/data", like mounting "bucket:/team-a/data"
+func NewFilesystemWithPrefix(client S3ClientInterface, prefix string) (*Filesystem, error) {
+	adapter, err := newPrefixedS3Adapter(client, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return NewFilesystemWithBackend(adapter), nil
+}
+
 // s3Adapter adapts S3ClientInterface to storage.Backend
+// Paths are relative to prefix: every key sent is prefixed and every key
+// listed has it removed, so nothing outside the prefix can be reached
 type s3Adapter struct {
-	client S3ClientInterface
+	client        S3ClientInterface
+	prefix        string // Key prefix of the mount's root, ending in "/" ("" = whole bucket)
+	copyThreshold int64  // Objects larger than this are copied with multipart copy (0 = MaxCopyObjectSize)
+}
+
+// key returns the object key of path
+func (s *s3Adapter) key(path string) string {
+	return s.prefix + path
+}
+
+// HasRootKey reports whether the root is a prefix, with a marker key of its own
+func (s *s3Adapter) HasRootKey() bool {
+	return s.prefix != ""
+}
+
+// paths returns the paths of listed keys, which all start with the prefix
+func (s *s3Adapter) paths(keys []string) []string {
+	if s.prefix == "" {
+		return keys
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, s.prefix)
+	}
+	return keys
 }
 
 func (s *s3Adapter) Read(ctx context.Context, path string) ([]byte, error) {
-	return s.client.GetObject(ctx, path)
+	return s.client.GetObject(ctx, s.key(path))
 }
 
 func (s *s3Adapter) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
-	return s.client.GetObjectRange(ctx, path, start, end)
+	return s.client.GetObjectRange(ctx, s.key(path), start, end)
 }
 
 func (s *s3Adapter) Write(ctx context.Context, path string, data []byte) error {
-	return s.client.PutObject(ctx, path, data)
+	return s.client.PutObject(ctx, s.key(path), data)
 }
 
 func (s *s3Adapter) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
-	return s.client.PutObjectWithMetadata(ctx, path, data, metadata)
+	return s.client.PutObjectWithMetadata(ctx, s.key(path), data, metadata)
+}
+
+// ReadRangeIfMatch uses the client's conditional GET when it has one, otherwise
+// reads unconditionally
+func (s *s3Adapter) ReadRangeIfMatch(ctx context.Context, path string, start, end int64, etag string) ([]byte, error) {
+	reader, ok := s.client.(interface {
+		GetObjectRangeIfMatch(ctx context.Context, key string, start, end int64, etag string) ([]byte, error)
+	})
+	if !ok {
+		return s.ReadRange(ctx, path, start, end)
+	}
+	data, err := reader.GetObjectRangeIfMatch(ctx, s.key(path), start, end, etag)
+	if errors.Is(err, s3client.ErrPreconditionFailed) {
+		return nil, fmt.Errorf("%w: %v", types.ErrConflict, err)
+	}
+	return data, err
+}
+
+// WriteIfMatch uses the client's conditional PUT when it has one, otherwise
+// writes unconditionally
+func (s *s3Adapter) WriteIfMatch(ctx context.Context, path string, data []byte, metadata map[string]string, etag string) error {
+	path = s.key(path)
+	writer, ok := s.client.(interface {
+		PutObjectIfMatch(ctx context.Context, key string, data []byte, metadata map[string]string, etag string) error
+	})
+	if !ok {
+		return s.client.PutObjectWithMetadata(ctx, path, data, metadata)
+	}
+	err := writer.PutObjectIfMatch(ctx, path, data, metadata, etag)
+	if errors.Is(err, s3client.ErrPreconditionFailed) {
+		return fmt.Errorf("%w: %v", types.ErrConflict, err)
+	}
+	return err
+}
+
+// WriteIfAbsent uses the client's If-None-Match PUT when it has one, otherwise
+// checks for the object first, which leaves a window for a concurrent create
+func (s *s3Adapter) WriteIfAbsent(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	path = s.key(path)
+	creator, ok := s.client.(interface {
+		PutObjectIfNoneMatch(ctx context.Context, key string, data []byte, metadata map[string]string) error
+	})
+	if !ok {
+		if _, err := s.client.HeadObject(ctx, path); err == nil {
+			return fmt.Errorf("%s: %w", path, os.ErrExist)
+		}
+		return s.client.PutObjectWithMetadata(ctx, path, data, metadata)
+	}
+	err := creator.PutObjectIfNoneMatch(ctx, path, data, metadata)
+	if errors.Is(err, s3client.ErrObjectExists) {
+		return fmt.Errorf("%w: %v", os.ErrExist, err)
+	}
+	return err
+}
+
+// WriteRange uses the client's part-copying patch when it has one
+func (s *s3Adapter) WriteRange(ctx context.Context, path string, offset int64, data []byte, metadata map[string]string) (bool, error) {
+	patcher, ok := s.client.(interface {
+		PatchObject(ctx context.Context, key string, offset int64, data []byte, metadata map[string]string) (bool, error)
+	})
+	if !ok {
+		return false, nil
+	}
+	return patcher.PatchObject(ctx, s.key(path), offset, data, metadata)
 }
 
 func (s *s3Adapter) Delete(ctx context.Context, path string) error {
-	return s.client.DeleteObject(ctx, path)
+	return s.client.DeleteObject(ctx, s.key(path))
+}
+
+// DeleteMany uses the client's batch delete when it has one, otherwise deletes
+// one object at a time; failures are collected rather than stopping early
+func (s *s3Adapter) DeleteMany(ctx context.Context, paths []string) error {
+	if batcher, ok := s.client.(interface {
+		DeleteObjects(ctx context.Context, keys []string) error
+	}); ok {
+		keys := make([]string, len(paths))
+		for i, path := range paths {
+			keys[i] = s.key(path)
+		}
+		err := batcher.DeleteObjects(ctx, keys)
+		var batchErr *s3client.DeleteObjectsError
+		if errors.As(err, &batchErr) {
+			failed := make(map[string]error, len(batchErr.Failed))
+			for key, keyErr := range batchErr.Failed {
+				failed[strings.TrimPrefix(key, s.prefix)] = keyErr
+			}
+			return &types.DeleteManyError{Failed: failed}
+		}
+		return err
+	}
+
+	failed := make(map[string]error)
+	for _, path := range paths {
+		if err := s.client.DeleteObject(ctx, s.key(path)); err != nil {
+			failed[path] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &types.DeleteManyError{Failed: failed}
+	}
+	return nil
 }
 
 func (s *s3Adapter) List(ctx context.Context, prefix string) ([]string, error) {
-	return s.client.ListObjects(ctx, prefix)
+	keys, err := s.client.ListObjects(ctx, s.key(prefix))
+	return s.paths(keys), err
 }
 
-func (s *s3Adapter) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
-	metadata, err := s.client.HeadObject(ctx, path)
+// ListDelimited uses the client's delimited listing when it has one, otherwise
+// groups a full prefix listing
+func (s *s3Adapter) ListDelimited(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	var keys, prefixes []string
+	if lister, ok := s.client.(types.DelimitedLister); ok {
+		var err error
+		if keys, prefixes, err = lister.ListDelimited(ctx, s.key(prefix), delimiter); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		objects, err := s.client.ListObjects(ctx, s.key(prefix))
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, prefixes = s3client.SplitDelimited(objects, s.key(prefix), delimiter)
+	}
+	return s.paths(keys), s.paths(prefixes), nil
+}
+
+// ListDelimitedPage uses the client's paged delimited listing when it has one,
+// otherwise lists the whole level at once
+func (s *s3Adapter) ListDelimitedPage(ctx context.Context, prefix, delimiter, token string, limit int) ([]string, []string, string, error) {
+	if lister, ok := s.client.(types.DelimitedPageLister); ok {
+		keys, prefixes, next, err := lister.ListDelimitedPage(ctx, s.key(prefix), delimiter, token, limit)
+		return s.paths(keys), s.paths(prefixes), next, err
+	}
+	keys, prefixes, err := s.ListDelimited(ctx, prefix, delimiter)
+	return keys, prefixes, "", err
+}
+
+func (s *s3Adapter) ListPage(ctx context.Context, prefix, token string, limit int) ([]string, string, error) {
+	if lister, ok := s.client.(types.PageLister); ok {
+		keys, next, err := lister.ListPage(ctx, s.key(prefix), token, limit)
+		return s.paths(keys), next, err
+	}
+	objects, err := s.client.ListObjects(ctx, s.key(prefix))
+	return s.paths(objects), "", err
+}
+
+func (s *s3Adapter) ListLimited(ctx context.Context, prefix string, maxKeys int) ([]string, error) {
+	if lister, ok := s.client.(types.LimitedLister); ok {
+		keys, err := lister.ListLimited(ctx, s.key(prefix), maxKeys)
+		return s.paths(keys), err
+	}
+	objects, err := s.client.ListObjects(ctx, s.key(prefix))
 	if err != nil {
-		return nil, fmt.Errorf("file not found: %w", os.ErrNotExist)
+		return nil, err
+	}
+	return s.paths(objects[:min(len(objects), maxKeys)]), nil
+}
+
+// ListSizes uses the client's sized listing when it has one, otherwise sizes
+// each listed object with a HEAD request
+func (s *s3Adapter) ListSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	var sizes map[string]int64
+	if lister, ok := s.client.(types.SizeLister); ok {
+		var err error
+		if sizes, err = lister.ListSizes(ctx, s.key(prefix)); err != nil {
+			return nil, err
+		}
+	} else {
+		objects, err := s.client.ListObjects(ctx, s.key(prefix))
+		if err != nil {
+			return nil, err
+		}
+		sizes = make(map[string]int64, len(objects))
+		for _, object := range objects {
+			size, err := s.client.HeadObjectSize(ctx, object)
+			if err != nil {
+				return nil, err
+			}
+			sizes[object] = size
+		}
+	}
+	if s.prefix == "" {
+		return sizes, nil
 	}
+	paths := make(map[string]int64, len(sizes))
+	for key, size := range sizes {
+		paths[strings.TrimPrefix(key, s.prefix)] = size
+	}
+	return paths, nil
+}
 
-	size, err := s.client.HeadObjectSize(ctx, path)
+// ListVersions uses the client's ListObjectVersions; clients without one have
+// no versions to offer
+func (s *s3Adapter) ListVersions(ctx context.Context, prefix string) ([]types.Version, error) {
+	lister, ok := s.client.(interface {
+		ListObjectVersions(ctx context.Context, prefix string) ([]s3client.ObjectVersion, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("object versions: %w", errors.ErrUnsupported)
+	}
+	objectVersions, err := lister.ListObjectVersions(ctx, s.key(prefix))
 	if err != nil {
 		return nil, err
 	}
+	versions := make([]types.Version, 0, len(objectVersions))
+	for _, v := range objectVersions {
+		versions = append(versions, types.Version{
+			Path:     strings.TrimPrefix(v.Key, s.prefix),
+			ID:       v.VersionID,
+			Modified: v.LastModified,
+			Size:     v.Size,
+			Latest:   v.IsLatest,
+			Deleted:  v.DeleteMarker,
+		})
+	}
+	// S3 lists the delete markers of a page apart from the versions
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Path != versions[j].Path {
+			return versions[i].Path < versions[j].Path
+		}
+		return versions[i].Modified.After(versions[j].Modified)
+	})
+	return versions, nil
+}
+
+func (s *s3Adapter) ReadVersion(ctx context.Context, path, id string) ([]byte, error) {
+	reader, ok := s.client.(interface {
+		GetObjectVersion(ctx context.Context, key, versionID string) ([]byte, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("object versions: %w", errors.ErrUnsupported)
+	}
+	return reader.GetObjectVersion(ctx, s.key(path), id)
+}
+
+func (s *s3Adapter) RestoreVersion(ctx context.Context, path, id string) error {
+	restorer, ok := s.client.(interface {
+		RestoreObjectVersion(ctx context.Context, key, versionID string) error
+	})
+	if !ok {
+		return fmt.Errorf("object versions: %w", errors.ErrUnsupported)
+	}
+	return restorer.RestoreObjectVersion(ctx, s.key(path), id)
+}
+
+func (s *s3Adapter) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	// Single HEAD for size, Last-Modified and metadata
+	info, err := s.client.HeadObjectFull(ctx, s.key(path))
+	if err != nil {
+		if types.IsUnavailable(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("file not found: %w", os.ErrNotExist)
+	}
+	metadata := info.Metadata
+	size := info.Size
 
 	mode := uint32(0644)
+	defaultMode := true
 	uid := uint32(os.Getuid())
 	gid := uint32(os.Getgid())
-	mtime := time.Now()
+	mtime := info.LastModified
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
 
 	// Parse metadata
-	if modeStr, ok := metadata["mode"]; ok {
-		var modeVal uint32
-		fmt.Sscanf(modeStr, "%o", &modeVal)
-		mode = modeVal
+	if stored, ok := types.DecodeMode(metadata["mode"]); ok {
+		mode = uint32(stored)
+		defaultMode = false
 	}
 	if uidStr, ok := metadata["uid"]; ok {
 		fmt.Sscanf(uidStr, "%d", &uid)
@@ -170,42 +820,124 @@ func (s *s3Adapter) GetAttr(ctx context.Context, path string) (*types.Attr, erro
 	if gidStr, ok := metadata["gid"]; ok {
 		fmt.Sscanf(gidStr, "%d", &gid)
 	}
-	if mtimeStr, ok := metadata["mtime"]; ok {
-		var unixTime int64
-		if _, err := fmt.Sscanf(mtimeStr, "%d", &unixTime); err == nil {
-			mtime = time.Unix(unixTime, 0)
-		}
+	if stored, ok := types.DecodeTime(metadata["mtime"]); ok {
+		mtime = stored
+	}
+	ctime := mtime
+	if stored, ok := types.DecodeTime(metadata["ctime"]); ok {
+		ctime = stored
+	}
+	atime := mtime
+	if stored, ok := types.DecodeTime(metadata["atime"]); ok {
+		atime = stored
 	}
 
 	return &types.Attr{
-		Size:  size,
-		Mode:  mode,
-		Uid:   uid,
-		Gid:   gid,
-		Mtime: mtime,
+		Size:     size,
+		Mode:     mode,
+		Uid:      uid,
+		Gid:      gid,
+		Mtime:    mtime,
+		Ctime:    ctime,
+		Atime:    atime,
+		CacheTTL:    types.CacheTTLFromMetadata(metadata),
+		DefaultMode: defaultMode,
+		ETag:        info.ETag,
+		ContentType: info.ContentType,
+		StorageClass: storageClassOf(info),
+		Metadata:    metadata,
 	}, nil
 }
 
+// storageClassOf returns the storage class of a HEAD result, which S3 leaves
+// out for STANDARD
+func storageClassOf(info *s3client.ObjectInfo) string {
+	if info.StorageClass == "" {
+		return "STANDARD"
+	}
+	return string(info.StorageClass)
+}
+
+// Rename moves an object with a server-side copy, so its content never passes
+// through the client, whatever its size. The copy's metadata is rewritten in
+// the same request to record the change time, as rename(2) does
 func (s *s3Adapter) Rename(ctx context.Context, oldPath, newPath string) error {
-	metadata, err := s.client.HeadObject(ctx, oldPath)
+	oldPath, newPath = s.key(oldPath), s.key(newPath)
+	info, err := s.client.HeadObjectFull(ctx, oldPath)
 	if err != nil {
 		return fmt.Errorf("source file not found: %w", err)
 	}
-	
-	if err := s.client.CopyObjectWithMetadata(ctx, oldPath, newPath, metadata); err != nil {
+	// Keys are sent in the form S3 returns them, so ctime below replaces the
+	// stored one rather than going out twice under both forms
+	metadata := make(map[string]string, len(info.Metadata)+1)
+	for k, v := range info.Metadata {
+		metadata[strings.TrimPrefix(k, "x-amz-meta-")] = v
+	}
+	metadata["ctime"] = fmt.Sprintf("%d", time.Now().Unix())
+	if err := s.copyObject(ctx, oldPath, newPath, info, metadata); err != nil {
 		return err
 	}
 	
 	return s.client.DeleteObject(ctx, oldPath)
 }
 
+// Copy copies an object server-side, keeping its metadata
+func (s *s3Adapter) Copy(ctx context.Context, srcPath, dstPath string) error {
+	srcPath, dstPath = s.key(srcPath), s.key(dstPath)
+	info, err := s.client.HeadObjectFull(ctx, srcPath)
+	if err != nil {
+		return fmt.Errorf("source file not found: %w", err)
+	}
+	return s.copyObject(ctx, srcPath, dstPath, info, info.Metadata)
+}
+
+// copyObject copies the object info describes to dstPath with metadata, in one
+// CopyObject or, above the multipart copy threshold, in copied parts. Clients
+// that can't replace metadata in a multipart copy keep the source's
+func (s *s3Adapter) copyObject(ctx context.Context, srcPath, dstPath string, info *s3client.ObjectInfo, metadata map[string]string) error {
+	if info.Size <= s.multipartCopyThreshold() {
+		return s.client.CopyObjectWithMetadata(ctx, srcPath, dstPath, metadata)
+	}
+	if copier, ok := s.client.(interface {
+		CopyObjectMultipartWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error
+	}); ok {
+		return copier.CopyObjectMultipartWithMetadata(ctx, srcPath, dstPath, metadata)
+	}
+	return s.client.CopyObjectMultipart(ctx, srcPath, dstPath)
+}
+
+// multipartCopyThreshold returns the size above which Copy uses multipart copy
+// A single CopyObject tops out at 5GB, so larger thresholds are capped there
+func (s *s3Adapter) multipartCopyThreshold() int64 {
+	if s.copyThreshold <= 0 || s.copyThreshold > s3client.MaxCopyObjectSize {
+		return s3client.MaxCopyObjectSize
+	}
+	return s.copyThreshold
+}
+
+// UpdateMetadata replaces an object's metadata by copying it onto itself,
+// so the body never leaves S3. The content type and storage class the caller
+// passes along (s3client.ContentTypeKey, s3client.StorageClassKey) save the
+// copy looking them up. Objects S3 finds too large for a single CopyObject
+// are copied onto themselves in parts, when the client can
+func (s *s3Adapter) UpdateMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	path = s.key(path)
+	err := s.client.CopyObjectWithMetadata(ctx, path, path, metadata)
+	if copier, ok := s.client.(interface {
+		CopyObjectMultipartWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error
+	}); ok && errors.Is(err, s3client.ErrCopyTooLarge) {
+		return copier.CopyObjectMultipartWithMetadata(ctx, path, path, metadata)
+	}
+	return err
+}
+
 func (s *s3Adapter) Exists(ctx context.Context, path string) (bool, error) {
-	_, err := s.client.HeadObject(ctx, path)
+	_, err := s.client.HeadObject(ctx, s.key(path))
 	return err == nil, nil
 }
 
 func (s *s3Adapter) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
-	metadata, err := s.client.HeadObject(ctx, path)
+	metadata, err := s.client.HeadObject(ctx, s.key(path))
 	if err != nil {
 		return nil, fmt.Errorf("file not found: %w", os.ErrNotExist)
 	}
@@ -214,20 +946,43 @@ func (s *s3Adapter) GetMetadata(ctx context.Context, path string) (map[string]st
 
 // GetAttr retrieves file attributes
 func (fs *Filesystem) GetAttr(ctx context.Context, path string) (*Attr, error) {
+	attr, err := fs.getAttr(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	attr.Inode = inodeFor(path)
+
+	// Apply owner override (-uid/-gid)
+	if fs.forceUID != nil {
+		attr.Uid = *fs.forceUID
+	}
+	if fs.forceGID != nil {
+		attr.Gid = *fs.forceGID
+	}
+	attr.Mode &^= fs.umask
+	return attr, nil
+}
+
+// getAttr retrieves file attributes as stored, without owner overrides
+func (fs *Filesystem) getAttr(ctx context.Context, path string) (*Attr, error) {
+	if vp, ok := fs.parseVersionPath(path); ok {
+		return fs.versionAttr(ctx, vp)
+	}
 	normalizedPath := fs.normalizePath(path)
 	
 	// Check FD cache for buffered files first
 	if fs.cache != nil {
 		fdCache := fs.cache.GetFdCache()
 		if entity, found := fdCache.Get(normalizedPath); found {
-			// If there's buffered data, return attributes from cache (including updated size and mtime)
-			if entity.BytesModified() > 0 {
+			// While data is buffered or being uploaded the entity is authoritative;
+			// storage is only consulted once the upload has finished and refreshed the stat cache
+			if entity.BytesModified() > 0 || entity.Uploading() {
 				// Return from cache - entity has the most up-to-date size and mtime
 				size := entity.Size()
 				mtime := entity.Mtime()
 				
 				// Try to get mode/uid/gid from stat cache or use defaults
-				mode := os.FileMode(0644)
+				mode := fs.defaultFileMode
 				uid := uint32(os.Getuid())
 				gid := uint32(os.Getgid())
 				
@@ -244,55 +999,16 @@ func (fs *Filesystem) GetAttr(ctx context.Context, path string) (*Attr, error) {
 				}
 				
 				return &Attr{
-					Mode:  mode,
-					Size:  size,
-					Mtime: mtime,
-					Uid:   uid,
-					Gid:   gid,
+					Mode:   mode,
+					Size:   size,
+					Blocks: blocksForSize(size),
+					Mtime:  mtime,
+					Ctime:  mtime,
+					Atime:  mtime,
+					Uid:    uid,
+					Gid:    gid,
 				}, nil
 			}
-			// Even if no buffered data, check if entity was very recently modified (within last 50ms)
-			// and entity mtime is newer than storage mtime (indicating a recent append/update)
-			// This handles the case where data was just uploaded but GetAttr is called immediately after
-			// Only do this if stat cache was invalidated (to avoid interfering with metadata operations)
-			statCache := fs.cache.GetStatCache()
-			hasStatCache := false
-			if statCache != nil {
-				if cachedEntry, found := statCache.Get(path); found && cachedEntry != nil {
-					hasStatCache = true
-				}
-			}
-			
-			if !hasStatCache {
-				backend := fs.getBackend()
-				if backend != nil {
-					storageAttr, err := backend.GetAttr(ctx, normalizedPath)
-					if err == nil {
-						entityMtime := entity.Mtime()
-						// If entity mtime is newer than storage mtime and was recently modified, use entity mtime
-						// This is specifically for append operations where mtime was just updated
-						if entityMtime.After(storageAttr.Mtime) && time.Since(entityMtime) < 50*time.Millisecond {
-							entitySize := entity.Size()
-							mode := os.FileMode(0644)
-							uid := uint32(os.Getuid())
-							gid := uint32(os.Getgid())
-							
-							// Use storage attributes for mode/uid/gid (they're more accurate)
-							mode = os.FileMode(storageAttr.Mode)
-							uid = storageAttr.Uid
-							gid = storageAttr.Gid
-							
-							return &Attr{
-								Mode:  mode,
-								Size:  entitySize,
-								Mtime: entityMtime,
-								Uid:   uid,
-								Gid:   gid,
-							}, nil
-						}
-					}
-				}
-			}
 		}
 	}
 	
@@ -300,15 +1016,21 @@ func (fs *Filesystem) GetAttr(ctx context.Context, path string) (*Attr, error) {
 	if fs.cache != nil {
 		statCache := fs.cache.GetStatCache()
 		if statCache != nil {
+			if statCache.IsNegative(path) {
+				return nil, fmt.Errorf("file not found: %w", syscall.ENOENT)
+			}
 			if cachedEntry, found := statCache.Get(path); found && cachedEntry != nil {
 				cachedAttr := cachedEntry.Attr
 				if cachedAttr != nil {
 					return &Attr{
-						Mode:  os.FileMode(cachedAttr.Mode),
-						Size:  cachedAttr.Size,
-						Mtime: cachedAttr.Mtime,
-						Uid:   cachedAttr.Uid,
-						Gid:   cachedAttr.Gid,
+						Mode:   os.FileMode(cachedAttr.Mode),
+						Size:   cachedAttr.Size,
+						Blocks: blocksForSize(cachedAttr.Size),
+						Mtime:  cachedAttr.Mtime,
+						Ctime:  cachedAttr.Ctime,
+						Atime:  cachedAttr.Atime,
+						Uid:    cachedAttr.Uid,
+						Gid:    cachedAttr.Gid,
 					}, nil
 				}
 			}
@@ -322,99 +1044,150 @@ func (fs *Filesystem) GetAttr(ctx context.Context, path string) (*Attr, error) {
 	
 	// Check if it's a directory by listing
 	if normalizedPath == "" || strings.HasSuffix(normalizedPath, "/") {
-		// Try to get directory metadata from .keep marker
-		keepPath := normalizedPath + ".keep"
-		keepAttr, err := backend.GetAttr(ctx, keepPath)
+		// Try to get directory metadata from its marker
+		_, keepAttr, err := fs.dirMarker(ctx, backend, normalizedPath)
 		
-		mode := os.FileMode(0755)
+		mode := fs.defaultDirMode
 		uid := uint32(os.Getuid())
 		gid := uint32(os.Getgid())
 		mtime := time.Now()
+		ctime := mtime
+		atime := mtime
 		
 		if err == nil {
 			// Use attributes from backend
-			mode = os.FileMode(keepAttr.Mode)
+			mode = fs.modeOf(keepAttr, true)
 			uid = keepAttr.Uid
 			gid = keepAttr.Gid
 			mtime = keepAttr.Mtime
+			ctime = ctimeOf(keepAttr)
+			atime = atimeOf(keepAttr)
 		}
 		
 		attr := &Attr{
-			Mode:  os.ModeDir | mode,
-			Size:  4096,
-			Mtime: mtime,
-			Uid:   uid,
-			Gid:   gid,
+			Mode:   os.ModeDir | mode,
+			Size:   4096,
+			Blocks: blocksForSize(4096),
+			Mtime:  mtime,
+			Ctime:  ctime,
+			Atime:  atime,
+			Uid:    uid,
+			Gid:    gid,
 		}
 		return attr, nil
 	}
 
-	// Try to get file attributes
+	// Try to get file attributes; an upload finishing meanwhile caches what it
+	// stored, which the answer may predate
+	uploads := fs.uploadCount(normalizedPath)
 	attr, err := backend.GetAttr(ctx, normalizedPath)
+	if err != nil && types.IsUnavailable(err) {
+		// Not evidence that the file is gone, so no negative entry either
+		if stale, ok := fs.staleAttr(path, err); ok {
+			return stale, nil
+		}
+		return nil, syscall.EIO
+	}
 	if err != nil {
-		// Check if it's a directory by listing objects with this prefix
-		objects, listErr := backend.List(ctx, normalizedPath+"/")
-		if listErr == nil && len(objects) > 0 {
-			// Try to get directory metadata from .keep marker
-			keepPath := normalizedPath + "/.keep"
-			keepAttr, err := backend.GetAttr(ctx, keepPath)
+		// Check if it's a directory: one key under the prefix is enough
+		if isDir, listErr := hasChildren(ctx, backend, normalizedPath+"/"); listErr == nil && isDir {
+			// Try to get directory metadata from its marker
+			_, keepAttr, err := fs.dirMarker(ctx, backend, normalizedPath+"/")
 			
-			mode := os.FileMode(0755)
+			mode := fs.defaultDirMode
 			uid := uint32(os.Getuid())
 			gid := uint32(os.Getgid())
 			mtime := time.Now()
+			ctime := mtime
+			atime := mtime
 			
 			if err == nil {
-				mode = os.FileMode(keepAttr.Mode)
+				mode = fs.modeOf(keepAttr, true)
 				uid = keepAttr.Uid
 				gid = keepAttr.Gid
 				mtime = keepAttr.Mtime
+				ctime = ctimeOf(keepAttr)
+				atime = atimeOf(keepAttr)
 			}
 			
 			return &Attr{
-				Mode:  os.ModeDir | mode,
-				Size:  4096,
-				Mtime: mtime,
-				Uid:   uid,
-				Gid:   gid,
+				Mode:   os.ModeDir | mode,
+				Size:   4096,
+				Blocks: blocksForSize(4096),
+				Mtime:  mtime,
+				Ctime:  ctime,
+				Atime:  atime,
+				Uid:    uid,
+				Gid:    gid,
 			}, nil
 		}
+		fs.unlessUploaded(normalizedPath, uploads, func() {
+			fs.cache.GetStatCache().SetNegative(path)
+		})
 		return nil, fmt.Errorf("file not found: %w", syscall.ENOENT)
 	}
 
-	// Use attributes from backend
-	mode := os.FileMode(attr.Mode)
+	// Use attributes from backend; an object typed as a directory is an old
+	// C++ s3fs marker, standing for the directory of the same name
+	isDir := attr.ContentType == dirContentType
+	mode := fs.modeOf(attr, isDir)
+	if isDir {
+		mode |= os.ModeDir
+	}
 	uid := attr.Uid
 	gid := attr.Gid
 	mtime := attr.Mtime
+	ctime := ctimeOf(attr)
+	atime := atimeOf(attr)
 	size := attr.Size
 
 	resultAttr := &Attr{
-		Mode:  mode,
-		Size:  size,
-		Mtime: mtime,
-		Uid:   uid,
-		Gid:   gid,
+		Mode:   mode,
+		Size:   size,
+		Blocks: blocksForSize(size),
+		Mtime:  mtime,
+		Ctime:  ctime,
+		Atime:  atime,
+		Uid:    uid,
+		Gid:    gid,
 	}
 
 	// Cache the result
-	if fs.cache != nil {
+	fs.unlessUploaded(normalizedPath, uploads, func() {
+		fs.revalidate(normalizedPath, attr)
 		statCache := fs.cache.GetStatCache()
 		cachedAttr := &cache.CachedAttr{
-			Mode:  uint32(mode),
-			Size:  size,
-			Mtime: mtime,
-			Uid:   uid,
-			Gid:   gid,
+			Mode:        uint32(mode),
+			Size:        size,
+			Mtime:       mtime,
+			Ctime:       ctime,
+			Atime:       atime,
+			Uid:         uid,
+			Gid:         gid,
+			ETag:        attr.ETag,
+			ContentType: attr.ContentType,
 		}
-		statCache.Set(path, cachedAttr, nil)
+		// Honor per-path TTL override (user.s3fs.cache_ttl xattr); the
+		// metadata read along with the attributes answers xattr lookups
+		statCache.SetWithTTL(path, cachedAttr, attr.Metadata, attr.CacheTTL)
+	})
+
+	if fs.statPrimeSize > 0 && !mode.IsDir() {
+		fs.primeRead(ctx, backend, normalizedPath, attr)
 	}
 
 	return resultAttr, nil
 }
 
+// listPageSize is how many keys ReadDir and directory Rename take from the
+// backend at a time (the most S3 returns per request)
+const listPageSize = 1000
+
 // ReadDir lists directory entries
 func (fs *Filesystem) ReadDir(ctx context.Context, path string) ([]DirEntry, error) {
+	if vp, ok := fs.parseVersionPath(path); ok {
+		return fs.versionReadDir(ctx, vp)
+	}
 	normalizedPath := fs.normalizePath(path)
 	if normalizedPath != "" && !strings.HasSuffix(normalizedPath, "/") {
 		normalizedPath += "/"
@@ -425,39 +1198,64 @@ func (fs *Filesystem) ReadDir(ctx context.Context, path string) ([]DirEntry, err
 		return nil, fmt.Errorf("no storage backend available")
 	}
 
-	objects, err := backend.List(ctx, normalizedPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list objects: %w", err)
-	}
-
 	// Track seen directory names to avoid duplicates
 	seen := make(map[string]bool)
 	entries := make([]DirEntry, 0)
+	listed := 0
+	addEntries := func(objects []string) error {
+		listed += len(objects)
+		for _, objKey := range objects {
+			// Remove the prefix to get relative path
+			relativePath := strings.TrimPrefix(objKey, normalizedPath)
+			if relativePath == "" {
+				continue
+			}
 
-	for _, objKey := range objects {
-		// Remove the prefix to get relative path
-		relativePath := strings.TrimPrefix(objKey, normalizedPath)
-		if relativePath == "" {
-			continue
-		}
+			// A legacy marker counts as directory contents, but isn't a file
+			if relativePath == ".keep" && !fs.showDirMarkers {
+				continue
+			}
 
-		// Don't filter out .keep files - they should appear in directory listings
-		// (Other versions don't filter them)
+			// Extract first component (file or directory name)
+			parts := strings.Split(relativePath, "/")
+			name := parts[0]
 
-		// Extract first component (file or directory name)
-		parts := strings.Split(relativePath, "/")
-		name := parts[0]
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
 
-		if seen[name] {
-			continue
+			isDir := len(parts) > 1
+			entries = append(entries, DirEntry{
+				Name:  name,
+				IsDir: isDir,
+			})
 		}
-		seen[name] = true
+		return nil
+	}
 
-		isDir := len(parts) > 1
-		entries = append(entries, DirEntry{
-			Name:  name,
-			IsDir: isDir,
+	// Prefer a one-level listing; subdirectories come back as common prefixes
+	// ("name/"), which addEntries handles like any deeper key. Otherwise the
+	// subtree is consumed a page at a time
+	_, delimited := backend.(types.DelimitedLister)
+	_, pagedDelimited := backend.(types.DelimitedPageLister)
+	if delimited || pagedDelimited {
+		err := types.ListDelimitedFunc(ctx, backend, normalizedPath, "/", listPageSize, func(keys, prefixes []string) error {
+			addEntries(keys)
+			return addEntries(prefixes)
 		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+	} else if err := types.ListFunc(ctx, backend, normalizedPath, listPageSize, addEntries); err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	// An empty listing may mean the path is a file rather than an empty directory
+	if listed == 0 && normalizedPath != "" {
+		if attr, err := backend.GetAttr(ctx, strings.TrimSuffix(normalizedPath, "/")); err == nil && attr.ContentType != dirContentType {
+			return nil, syscall.ENOTDIR
+		}
 	}
 
 	// Also include buffered files from FD cache
@@ -487,52 +1285,73 @@ func (fs *Filesystem) ReadDir(ctx context.Context, path string) ([]DirEntry, err
 	return entries, nil
 }
 
-// ReadFile reads file data
+// ReadFile reads file data; size 0 reads to the end of the file, which fails
+// with EFBIG when more remains than the limit set with SetMaxReadSize. The
+// read is recorded in the file's access time as the atime mode asks (see
+// SetAtimeMode)
 func (fs *Filesystem) ReadFile(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
+	if vp, ok := fs.parseVersionPath(path); ok {
+		return fs.versionReadFile(ctx, vp, offset, size)
+	}
+	data, err := fs.readFile(ctx, path, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 && fs.maxReadSize > 0 && int64(len(data)) > fs.maxReadSize {
+		return nil, fmt.Errorf("reading %s from %d to the end would return more than %d bytes; read it in ranges: %w", path, offset, fs.maxReadSize, syscall.EFBIG)
+	}
+	fs.updateAtime(ctx, path)
+	return data, nil
+}
+
+// readFile reads file data from the caches or the backend
+func (fs *Filesystem) readFile(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
 	normalizedPath := fs.normalizePath(path)
+	if normalizedPath == "" || strings.HasSuffix(normalizedPath, "/") {
+		return nil, syscall.EISDIR
+	}
+	// A read to the end asks for one byte past the limit, so ReadFile can
+	// tell a file that ends within it from one it would cut short
+	limited := size == 0 && fs.maxReadSize > 0
+	if limited {
+		size = fs.maxReadSize + 1
+	}
 	
 	// Try FD cache first (check for buffered data)
+	// Entities kept after their last close are only used when storage is unreachable
 	if fs.cache != nil {
 		fdCache := fs.cache.GetFdCache()
-		if entity, found := fdCache.Get(normalizedPath); found {
+		entity, found := fdCache.Get(normalizedPath)
+		if found && !fdCache.HasOpenEntity(normalizedPath) {
+			entity, found = fs.openPrimed(path, normalizedPath)
+		}
+		if found {
 			// Acquire file-level advisory read lock if enabled (Option 2)
 			if fs.enableFileLock {
 				entity.FileLock.RLock()
 				defer entity.FileLock.RUnlock()
 			}
 			
-			entitySize := entity.Size()
-			
-			// If size is 0, read entire file
-			if size == 0 {
-				size = entitySize - offset
-				if size <= 0 {
-					return []byte{}, nil
-				}
-			}
-			
-			// Try to read from page cache (buffered data)
-			if pageData, found := entity.ReadPage(offset); found {
-				if int64(len(pageData)) >= size {
-					return pageData[:size], nil
-				}
+			if data, found := readCachedEntity(entity, offset, size); found {
+				metrics.PageCacheHit()
+				fs.readAhead(normalizedPath, entity, offset, int64(len(data)))
+				return data, nil
 			}
-			
-			// Try to read from cached file
-			if entity.GetFile() != nil {
-				data, err := entity.Read(offset, size)
-				if err == nil && len(data) > 0 {
+			// The range may be on its way from a prefetch
+			if entity.WaitReadAhead(ctx, offset) {
+				if data, found := readCachedEntity(entity, offset, size); found {
+					metrics.PageCacheHit()
+					fs.readAhead(normalizedPath, entity, offset, int64(len(data)))
 					return data, nil
 				}
 			}
-			
-			// If we have buffered data, read from buffered pages
+			// Buffered writes over parts of the file no longer cached
 			if len(entity.GetDirtyPages()) > 0 {
-				if bufferedData, found := entity.ReadBufferedData(offset, size); found {
-					return bufferedData, nil
-				}
+				metrics.PageCacheMiss()
+				return fs.readBufferedFrom(ctx, normalizedPath, entity, offset, size)
 			}
 		}
+		metrics.PageCacheMiss()
 	}
 	
 	// Use range read if offset or size is specified
@@ -549,33 +1368,151 @@ func (fs *Filesystem) ReadFile(ctx context.Context, path string, offset int64, s
 	if backend == nil {
 		return nil, fmt.Errorf("no storage backend available")
 	}
+	if fs.diskCache != nil {
+		if data, found := fs.readDiskCache(ctx, backend, path, normalizedPath, offset, size); found {
+			return data, nil
+		}
+	}
 	data, err := backend.ReadRange(ctx, normalizedPath, offset, end)
 	if err != nil {
+		if types.IsUnavailable(err) {
+			if stale, ok := fs.staleRead(normalizedPath, offset, size, err); ok {
+				return stale, nil
+			}
+			return nil, syscall.EIO
+		}
+		// Archived objects (GLACIER/DEEP_ARCHIVE) are unreadable until restored
+		if errors.Is(err, syscall.EIO) {
+			return nil, syscall.EIO
+		}
+		// Directories have no object of their own, so the read fails; report why
+		attr, attrErr := fs.GetAttr(ctx, path)
+		if attrErr == nil && attr.Mode.IsDir() {
+			return nil, syscall.EISDIR
+		}
+		if errors.Is(attrErr, syscall.ENOENT) {
+			return nil, attrErr // Deleted, possibly by another client
+		}
 		return nil, fmt.Errorf("failed to get object: %w", err)
 	}
 
 	// Cache the data in FD cache
 	if fs.cache != nil && len(data) > 0 {
 		fdCache := fs.cache.GetFdCache()
-		entity, err := fdCache.Open(normalizedPath, int64(len(data)), time.Now())
+		entitySize := int64(len(data))
+		if limited || fs.readAheadSize > 0 && size > 0 {
+			// Readahead only fetches up to the size of the entity, and a capped
+			// read may have stopped short of the end, so it must be the file's
+			if attr, err := fs.GetAttr(ctx, path); err == nil {
+				entitySize = attr.Size
+			}
+		}
+		entity, err := fdCache.Open(normalizedPath, entitySize, time.Now())
 		if err == nil {
-			entity.WritePage(offset, data)
+			fs.trackVersion(path, entity)
+			entity.LoadPagesAt(offset, data)
+			if size > 0 {
+				fs.readAhead(normalizedPath, entity, offset, int64(len(data)))
+			}
 		}
 	}
 
 	return data, nil
 }
 
+// readBufferedFrom reads a range of a file with buffered writes that its entity
+// does not fully hold, taking the rest from the stored object
+func (fs *Filesystem) readBufferedFrom(ctx context.Context, normalizedPath string, entity *cache.FdEntity, offset, size int64) ([]byte, error) {
+	backend := fs.getBackend()
+	if backend == nil {
+		return nil, fmt.Errorf("no storage backend available")
+	}
+	var end int64
+	if size > 0 {
+		end = offset + size - 1
+	}
+	stored, err := backend.ReadRange(ctx, normalizedPath, offset, end)
+	if err != nil {
+		if types.IsUnavailable(err) {
+			return nil, syscall.EIO
+		}
+		// Nothing is stored there yet if the file is new or the range is past
+		// the end of the stored object
+		if attr, attrErr := backend.GetAttr(ctx, normalizedPath); attrErr == nil && offset < attr.Size {
+			return nil, fmt.Errorf("failed to get object: %w", err)
+		}
+		stored = nil
+	}
+	return entity.ReadBufferedDataFrom(offset, size, stored), nil
+}
+
+// readCachedEntity reads size bytes at offset from entity's cached pages, temp file
+// or buffered writes; size 0 reads to the end of the file
+func readCachedEntity(entity *cache.FdEntity, offset, size int64) ([]byte, bool) {
+	if size == 0 {
+		size = entity.Size() - offset
+		if size <= 0 {
+			return []byte{}, true
+		}
+	}
+	
+	// Try to read from page cache (buffered data)
+	if pageData, found := entity.ReadPage(offset); found {
+		if int64(len(pageData)) >= size {
+			return pageData[:size], true
+		}
+	}
+	
+	// Range may span several cached pages (e.g. content primed by GetAttr)
+	if cachedData, found := entity.ReadCachedRange(offset, size); found {
+		return cachedData, true
+	}
+	
+	// Try to read from cached file
+	if entity.GetFile() != nil {
+		data, err := entity.Read(offset, size)
+		if err == nil && len(data) > 0 {
+			return data, true
+		}
+	}
+	
+	// If we have buffered data, read from buffered pages
+	if len(entity.GetDirtyPages()) > 0 {
+		if bufferedData, found := entity.ReadBufferedData(offset, size); found {
+			return bufferedData, true
+		}
+	}
+	return nil, false
+}
+
 // WriteFile writes file data (buffered)
 func (fs *Filesystem) WriteFile(ctx context.Context, path string, data []byte, offset int64) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
 	normalizedPath := fs.normalizePath(path)
+	if normalizedPath == "" || strings.HasSuffix(normalizedPath, "/") {
+		return syscall.EISDIR
+	}
+	if fs.beingRemoved(normalizedPath) {
+		return syscall.ENOENT
+	}
+
+	attr, _ := fs.GetAttr(ctx, path)
+	if attr != nil && attr.Mode.IsDir() {
+		return syscall.EISDIR
+	}
+	if attr == nil {
+		if err := fs.checkParent(ctx, normalizedPath); err != nil {
+			return err
+		}
+	}
 	
 	// Use write buffering if cache is available
 	if fs.cache != nil {
 		fdCache := fs.cache.GetFdCache()
 		
 		// Get or create FD entity
-		attr, _ := fs.GetAttr(ctx, path)
 		var size int64
 		var mtime time.Time
 		if attr != nil {
@@ -597,18 +1534,33 @@ func (fs *Filesystem) WriteFile(ctx context.Context, path string, data []byte, o
 			defer entity.FileLock.Unlock()
 		}
 		
-		// Write to cache (buffered)
-		entity.WritePage(offset, data)
+		// Refuse a write that would take the bucket past its quota before
+		// buffering it, rather than failing the upload later
+		projected := max(entity.Size(), offset+int64(len(data)))
+		if offset == 0 {
+			projected = int64(len(data))
+		}
+		if err := fs.reserveSpace(ctx, normalizedPath, projected); err != nil {
+			return err
+		}
 		
-		// Update size - if offset is 0, always update size (may truncate or extend)
+		// Write to cache (buffered) and update the size under the entity lock:
+		// a write at offset 0 replaces the file (may truncate), others only extend.
+		// size above may be stale if another writer extended the file meanwhile
 		newSize := offset + int64(len(data))
+		size = entity.WriteAt(offset, data, offset == 0)
 		// Update mtime when writing (especially important for appends)
 		now := time.Now()
 		entity.SetMtime(now)
+		// Invalidate stat cache; an upload below caches what it stored
+		fs.cache.GetStatCache().Delete(path)
 		
-		if offset == 0 {
-			// Full file replacement - always update size (may truncate)
-			entity.SetSize(newSize)
+		// In write-back mode the flusher uploads, unless only the size changed
+		if fs.writeBack && entity.BytesModified() > 0 {
+			if entity.BytesModified() >= fs.maxDirtyData || fdCache.OverMemory() {
+				fs.flushSoon()
+			}
+		} else if offset == 0 {
 			// For full file replacement at offset 0, upload immediately to ensure size is correct
 			// This is especially important for empty files that are being written to
 			if err := fs.uploadBufferedData(ctx, normalizedPath, entity); err != nil {
@@ -619,10 +1571,6 @@ func (fs *Filesystem) WriteFile(ctx context.Context, path string, data []byte, o
 				}
 			}
 		} else {
-			// Partial write - extend if needed
-			if newSize > size {
-				entity.SetSize(newSize)
-			}
 			// For appends (writing beyond current size), upload immediately to ensure mtime is updated
 			if newSize > size {
 				if err := fs.uploadBufferedData(ctx, normalizedPath, entity); err != nil {
@@ -633,15 +1581,16 @@ func (fs *Filesystem) WriteFile(ctx context.Context, path string, data []byte, o
 					}
 				}
 			} else {
-				// Check if we should auto-upload (threshold reached)
+				// Check if we should auto-upload (threshold reached), or
+				// whether dirty pages keep the page cache over its memory limit
 				if entity.BytesModified() >= fs.maxDirtyData {
 					return fs.uploadBufferedData(ctx, normalizedPath, entity)
 				}
+				if fdCache.OverMemory() {
+					return fs.relieveMemory(ctx, normalizedPath, entity)
+				}
 			}
 		}
-		
-		// Invalidate stat cache
-		fs.cache.GetStatCache().Delete(path)
 		return nil
 	}
 	
@@ -649,6 +1598,212 @@ func (fs *Filesystem) WriteFile(ctx context.Context, path string, data []byte, o
 	return fs.writeFileImmediate(ctx, normalizedPath, data, offset)
 }
 
+// AppendFile writes data at the end of the file, for handles opened with O_APPEND,
+// and returns the offset it was written at
+// The offset is the live size of the FD cache entity, taken under its lock, so
+// concurrent appenders each get their own range instead of racing on GetAttr.
+// Appends stay buffered until flush (or maxDirtyData), like other partial writes
+func (fs *Filesystem) AppendFile(ctx context.Context, path string, data []byte) (int64, error) {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return 0, syscall.EROFS
+	}
+	normalizedPath := fs.normalizePath(path)
+	if normalizedPath == "" || strings.HasSuffix(normalizedPath, "/") {
+		return 0, syscall.EISDIR
+	}
+	if fs.beingRemoved(normalizedPath) {
+		return 0, syscall.ENOENT
+	}
+
+	attr, _ := fs.GetAttr(ctx, path)
+	if attr != nil && attr.Mode.IsDir() {
+		return 0, syscall.EISDIR
+	}
+	var size int64
+	mtime := time.Now()
+	if attr != nil {
+		size, mtime = attr.Size, attr.Mtime
+	} else if err := fs.checkParent(ctx, normalizedPath); err != nil {
+		return 0, err
+	}
+
+	if fs.cache == nil {
+		fs.appendMu.Lock()
+		defer fs.appendMu.Unlock()
+		if attr, err := fs.GetAttr(ctx, path); err == nil {
+			size = attr.Size
+		}
+		return size, fs.writeFileImmediate(ctx, normalizedPath, data, size)
+	}
+
+	fdCache := fs.cache.GetFdCache()
+	entity, err := fdCache.Open(normalizedPath, size, mtime)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open cache entity: %w", err)
+	}
+	if fs.enableFileLock {
+		entity.FileLock.Lock()
+		defer entity.FileLock.Unlock()
+	}
+
+	// Uploads are built from dirty pages only, so the existing content must be
+	// buffered too or the upload would replace it with zeros
+	if err := fs.bufferExistingContent(ctx, normalizedPath, entity); err != nil {
+		return 0, err
+	}
+	if err := fs.reserveSpace(ctx, normalizedPath, entity.Size()+int64(len(data))); err != nil {
+		return 0, err
+	}
+
+	offset := entity.Append(data)
+	entity.SetMtime(time.Now())
+	fs.cache.GetStatCache().Delete(path)
+
+	if entity.BytesModified() >= fs.maxDirtyData || fdCache.OverMemory() {
+		if fs.writeBack {
+			fs.flushSoon()
+			return offset, nil
+		}
+		if entity.BytesModified() < fs.maxDirtyData {
+			return offset, fs.relieveMemory(ctx, normalizedPath, entity)
+		}
+		return offset, fs.uploadBufferedData(ctx, normalizedPath, entity)
+	}
+	return offset, nil
+}
+
+// bufferExistingContent loads the stored content of a file into entity as dirty
+// pages, unless the entity already holds all of it
+func (fs *Filesystem) bufferExistingContent(ctx context.Context, normalizedPath string, entity *cache.FdEntity) error {
+	size := entity.Size()
+	if size == 0 || entity.BytesModified() > 0 || entity.Uploading() {
+		return nil
+	}
+	if data, found := entity.ReadCachedRange(0, size); found {
+		// Clean pages are not uploaded; write them back as dirty
+		entity.WritePage(0, data)
+		return nil
+	}
+
+	backend := fs.getBackend()
+	if backend == nil {
+		return nil
+	}
+	data, err := backend.ReadRange(ctx, normalizedPath, 0, size-1)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file for append: %w", err)
+	}
+	if int64(len(data)) > size {
+		data = data[:size]
+	}
+	entity.WritePage(0, data)
+	return nil
+}
+
+// Truncate sets the size of a file (truncate(2), ftruncate(2), O_TRUNC), dropping
+// data past size or extending the file with zeros
+// The change goes through the FD cache like a write and is uploaded right away,
+// as size-changing writes are; if that upload fails the truncated data stays
+// buffered for the next flush
+func (fs *Filesystem) Truncate(ctx context.Context, path string, size int64) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
+	if size < 0 {
+		return syscall.EINVAL
+	}
+	normalizedPath := fs.normalizePath(path)
+	if normalizedPath == "" || strings.HasSuffix(normalizedPath, "/") {
+		return syscall.EISDIR
+	}
+
+	attr, err := fs.GetAttr(ctx, path)
+	if err != nil {
+		return err
+	}
+	if attr.Mode.IsDir() {
+		return syscall.EISDIR
+	}
+
+	backend := fs.getBackend()
+	if backend == nil {
+		return fmt.Errorf("no storage backend available")
+	}
+	if err := fs.reserveSpace(ctx, normalizedPath, size); err != nil {
+		return err
+	}
+	if fs.cache == nil {
+		return fs.truncateImmediate(ctx, backend, normalizedPath, attr, size)
+	}
+
+	fdCache := fs.cache.GetFdCache()
+	buffered := false
+	if entity, found := fdCache.Get(normalizedPath); found {
+		buffered = entity.BytesModified() > 0 || entity.Uploading()
+	}
+
+	// Unless the entity already holds the file's pending contents, the bytes
+	// that survive must be buffered too: uploads are built from dirty pages only
+	var kept []byte
+	if keep := min(size, attr.Size); !buffered && keep > 0 {
+		kept, err = backend.ReadRange(ctx, normalizedPath, 0, keep-1)
+		if err != nil {
+			return fmt.Errorf("failed to read file for truncate: %w", err)
+		}
+		if int64(len(kept)) > keep {
+			kept = kept[:keep]
+		}
+	}
+
+	entity, err := fdCache.Open(normalizedPath, attr.Size, attr.Mtime)
+	if err != nil {
+		return fmt.Errorf("failed to open cache entity: %w", err)
+	}
+	if fs.enableFileLock {
+		entity.FileLock.Lock()
+		defer entity.FileLock.Unlock()
+	}
+	if len(kept) > 0 {
+		entity.WritePage(0, kept)
+	}
+	entity.Truncate(size)
+	entity.SetMtime(time.Now())
+	fs.cache.GetStatCache().Delete(path)
+
+	if err := fs.uploadBufferedData(ctx, normalizedPath, entity); err != nil {
+		if !strings.Contains(err.Error(), "storage backend not initialized") {
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateImmediate resizes a file in storage directly, for filesystems without a cache
+func (fs *Filesystem) truncateImmediate(ctx context.Context, backend types.Backend, normalizedPath string, attr *Attr, size int64) error {
+	data, err := backend.Read(ctx, normalizedPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file for truncate: %w", err)
+	}
+	if int64(len(data)) > size {
+		data = data[:size]
+	} else {
+		data = append(data, make([]byte, size-int64(len(data)))...)
+	}
+
+	now := time.Now()
+	metadata := map[string]string{
+		"mode":  types.EncodeMode(attr.Mode),
+		"uid":   fmt.Sprintf("%d", attr.Uid),
+		"gid":   fmt.Sprintf("%d", attr.Gid),
+		"mtime": fmt.Sprintf("%d", now.Unix()),
+		"ctime": fmt.Sprintf("%d", now.Unix()),
+	}
+	return fs.writeTracked(ctx, backend, normalizedPath, data, metadata)
+}
+
 // writeFileImmediate writes file data immediately to storage backend (no buffering)
 func (fs *Filesystem) writeFileImmediate(ctx context.Context, normalizedPath string, data []byte, offset int64) error {
 	backend := fs.getBackend()
@@ -669,8 +1824,9 @@ func (fs *Filesystem) writeFileImmediate(ctx context.Context, normalizedPath str
 			"mtime": fmt.Sprintf("%d", now.Unix()),
 			"ctime": fmt.Sprintf("%d", now.Unix()),
 		}
+		fs.setContentType(metadata, normalizedPath, data, nil)
 		
-		return backend.WriteWithMetadata(ctx, normalizedPath, data, metadata)
+		return fs.writeTracked(ctx, backend, normalizedPath, data, metadata)
 	}
 
 	// For non-zero offset, we need to read existing file, modify, and write back
@@ -688,7 +1844,8 @@ func (fs *Filesystem) writeFileImmediate(ctx context.Context, normalizedPath str
 			"mtime": fmt.Sprintf("%d", now.Unix()),
 			"ctime": fmt.Sprintf("%d", now.Unix()),
 		}
-		return backend.WriteWithMetadata(ctx, normalizedPath, data, metadata)
+		fs.setContentType(metadata, normalizedPath, data, nil)
+		return fs.writeTracked(ctx, backend, normalizedPath, data, metadata)
 	}
 
 	// Modify existing file
@@ -720,8 +1877,23 @@ func (fs *Filesystem) writeFileImmediate(ctx context.Context, normalizedPath str
 		"mtime": fmt.Sprintf("%d", now.Unix()),
 		"ctime": fmt.Sprintf("%d", now.Unix()),
 	}
+	fs.setContentType(metadata, normalizedPath, existing, nil)
 
-	return backend.WriteWithMetadata(ctx, normalizedPath, existing, metadata)
+	return fs.writeTracked(ctx, backend, normalizedPath, existing, metadata)
+}
+
+// writeTracked writes a whole file for the unbuffered paths, within the quota
+// when usage is tracked
+func (fs *Filesystem) writeTracked(ctx context.Context, backend types.Backend, normalizedPath string, data []byte, metadata map[string]string) error {
+	if err := fs.reserveSpace(ctx, normalizedPath, int64(len(data))); err != nil {
+		return err
+	}
+	before := fs.storedSize(ctx, normalizedPath)
+	if err := backend.WriteWithMetadata(ctx, normalizedPath, data, metadata); err != nil {
+		return err
+	}
+	fs.recordChange(normalizedPath, before, int64(len(data)))
+	return nil
 }
 
 // flushBufferedData flushes buffered data for a given path if it exists
@@ -758,12 +1930,29 @@ func (fs *Filesystem) uploadBufferedData(ctx context.Context, normalizedPath str
 	if backend == nil {
 		return fmt.Errorf("storage backend not initialized")
 	}
+	// Writes were checked against the quota as they were buffered, but other
+	// files may have used up the space since
+	if err := fs.reserveSpace(ctx, normalizedPath, entity.Size()); err != nil {
+		return err
+	}
 	
-	// Get existing metadata to preserve it
-	existingAttr, _ := backend.GetAttr(ctx, normalizedPath)
+	// Get existing metadata to preserve it. The entity remembers what its last
+	// upload stored, so only an entity's first upload has to ask storage
+	existingAttr, attrErr := entity.Stored(), error(nil)
+	if existingAttr == nil {
+		existingAttr, attrErr = backend.GetAttr(ctx, normalizedPath)
+	}
+	// Storage reports the new object's ETag in its response to the upload
+	ctx, written := types.WithWritten(ctx)
 	
-	// Update mtime/ctime
+	// Update mtime/ctime, at the second precision storage keeps, so GetAttr
+	// reports the same mtime during the upload as from storage afterwards
 	now := time.Now()
+	if existingAttr != nil {
+		now = nextTimestamp(existingAttr.Mtime)
+	}
+	now = now.Truncate(time.Second)
+	entity.SetMtime(now)
 	metadata := map[string]string{
 		"mtime": fmt.Sprintf("%d", now.Unix()),
 		"ctime": fmt.Sprintf("%d", now.Unix()),
@@ -771,11 +1960,98 @@ func (fs *Filesystem) uploadBufferedData(ctx context.Context, normalizedPath str
 	
 	// Preserve existing metadata (including mode, uid, gid)
 	if existingAttr != nil {
-		metadata["mode"] = fmt.Sprintf("%o", existingAttr.Mode)
+		metadata["mode"] = types.EncodeMode(os.FileMode(existingAttr.Mode))
 		metadata["uid"] = fmt.Sprintf("%d", existingAttr.Uid)
 		metadata["gid"] = fmt.Sprintf("%d", existingAttr.Gid)
 	}
+	if fs.forceUID != nil {
+		metadata["uid"] = fmt.Sprintf("%d", *fs.forceUID)
+	}
+	if fs.forceGID != nil {
+		metadata["gid"] = fmt.Sprintf("%d", *fs.forceGID)
+	}
+	fs.setContentType(metadata, normalizedPath, nil, existingAttr)
 	
+	// uploaded caches the attributes of the object just stored, which the upload
+	// itself determines, and returns them. It runs while the entity is still
+	// uploading, so GetAttr answers from the entity until they are cached
+	var storedBefore int64
+	if existingAttr != nil {
+		storedBefore = existingAttr.Size
+	}
+	uploaded := func(ctx context.Context, size int64) *types.Attr {
+		fs.recordChange(normalizedPath, storedBefore, size)
+		attr := &types.Attr{
+			Mode:        uint32(fs.defaultFileMode),
+			Size:        size,
+			Mtime:       now,
+			Ctime:       now,
+			Atime:       now,
+			Uid:         uint32(os.Getuid()),
+			Gid:         uint32(os.Getgid()),
+			DefaultMode: true,
+		}
+		if existingAttr != nil {
+			attr.Mode, attr.DefaultMode = existingAttr.Mode, false
+		}
+		fmt.Sscanf(metadata["uid"], "%d", &attr.Uid)
+		fmt.Sscanf(metadata["gid"], "%d", &attr.Gid)
+		attr.ContentType = metadata[s3client.ContentTypeKey]
+		attr.ETag = written.ETag
+		entity.SetETag(attr.ETag)
+		entity.SetStored(attr)
+		if fs.cache != nil {
+			cachedAttr := &cache.CachedAttr{
+				Mode:        uint32(fs.modeOf(attr, false)),
+				Size:        attr.Size,
+				Mtime:       attr.Mtime,
+				Ctime:       attr.Ctime,
+				Atime:       attr.Atime,
+				Uid:         attr.Uid,
+				Gid:         attr.Gid,
+				ETag:        attr.ETag,
+				ContentType: attr.ContentType,
+			}
+			// Files are statted both with and without the leading slash
+			statCache := fs.cache.GetStatCache()
+			statCache.SetWithTTL(normalizedPath, cachedAttr, nil, attr.CacheTTL)
+			statCache.SetWithTTL("/"+normalizedPath, cachedAttr, nil, attr.CacheTTL)
+		}
+		return attr
+	}
+
+	// A contiguous change to a large file is rewritten in place when the backend
+	// can, rather than uploading the whole file again
+	if rangeWriter, ok := backend.(types.RangeWriter); ok && existingAttr != nil {
+		written, err := entity.UploadDirtyRange(ctx, existingAttr.Size, func(ctx context.Context, offset int64, data []byte) (bool, error) {
+			// No part of a file smaller than a multipart part can be copied,
+			// so it is uploaded whole without asking storage about it first
+			if max(existingAttr.Size, offset+int64(len(data))) < s3client.MinMultipartSize {
+				return false, nil
+			}
+			if err := fs.unchanged(ctx, backend, normalizedPath, entity); err != nil {
+				return false, err
+			}
+			written, err := rangeWriter.WriteRange(ctx, normalizedPath, offset, data, metadata)
+			if written && err == nil {
+				uploaded(ctx, max(existingAttr.Size, offset+int64(len(data))))
+			}
+			return written, err
+		})
+		if errors.Is(err, types.ErrConflict) {
+			return fs.conflict(ctx, backend, normalizedPath, entity)
+		}
+		if err != nil {
+			return err
+		}
+		if written {
+			// The whole content isn't at hand to replace the disk cache copy
+			fs.forgetDiskCache(normalizedPath)
+			fs.completeFailedReleases(normalizedPath)
+			return nil
+		}
+	}
+
 	// Upload function - use entity size for truncation
 	uploadFunc := func(ctx context.Context, data []byte) error {
 		// Use entity size, not data length (for truncation)
@@ -789,77 +2065,142 @@ func (fs *Filesystem) uploadBufferedData(ctx context.Context, normalizedPath str
 			copy(extended, data)
 			data = extended
 		}
+		// Sniffed from the content if the name gave no type
+		fs.setContentType(metadata, normalizedPath, data, nil)
 		
-		// Use backend WriteWithMetadata (multipart handling is backend-specific)
-		err := backend.WriteWithMetadata(ctx, normalizedPath, data, metadata)
+		// Use backend WriteWithMetadata (multipart handling is backend-specific),
+		// unless the file was changed by another client
+		var err error
+		if writer, ok := backend.(types.ConditionalWriter); ok {
+			err = writer.WriteIfMatch(ctx, normalizedPath, data, metadata, entity.ETag())
+		} else if err = fs.unchanged(ctx, backend, normalizedPath, entity); err == nil {
+			err = backend.WriteWithMetadata(ctx, normalizedPath, data, metadata)
+		}
 		if err == nil {
-			// Update entity mtime after successful upload to match what was written
-			entity.SetMtime(now)
-			// Update stat cache with new attributes after upload
-			if fs.cache != nil {
-				statCache := fs.cache.GetStatCache()
-				if statCache != nil {
-					// Get updated attributes from storage to cache
-					if updatedAttr, err := backend.GetAttr(ctx, normalizedPath); err == nil {
-						cachedAttr := &cache.CachedAttr{
-							Mode:  uint32(updatedAttr.Mode),
-							Size:  updatedAttr.Size,
-							Mtime: updatedAttr.Mtime,
-							Uid:   updatedAttr.Uid,
-							Gid:   updatedAttr.Gid,
-						}
-						statCache.Set(fs.normalizePath(normalizedPath), cachedAttr, nil)
-					}
-				}
-			}
+			fs.storeDiskCache(normalizedPath, uploaded(ctx, int64(len(data))), data)
 		}
 		return err
 	}
 	
-	return entity.UploadBufferedData(ctx, uploadFunc)
+	// Content the entity holds no page for comes from the stored object
+	readStored := func(ctx context.Context) ([]byte, error) {
+		if attrErr != nil {
+			if types.IsUnavailable(attrErr) {
+				return nil, attrErr
+			}
+			return nil, nil // Not stored yet
+		}
+		return backend.Read(ctx, normalizedPath)
+	}
+	err := entity.UploadBufferedDataFrom(ctx, readStored, uploadFunc)
+	if errors.Is(err, types.ErrConflict) {
+		return fs.conflict(ctx, backend, normalizedPath, entity)
+	}
+	if err != nil {
+		return err
+	}
+	fs.completeFailedReleases(normalizedPath)
+	return nil
 }
 
 // Create creates a new file
 func (fs *Filesystem) Create(ctx context.Context, path string, mode os.FileMode) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
 	normalizedPath := fs.normalizePath(path)
-	
-	// Check if file already exists
-	_, err := fs.GetAttr(ctx, path)
-	if err == nil {
-		return syscall.EEXIST
+	if fs.beingRemoved(normalizedPath) {
+		return syscall.ENOENT
+	}
+	// Creates of one path in this mount take turns; other clients are kept out
+	// by the conditional write below where the backend has one
+	defer fs.creating.lock(normalizedPath)()
+
+	backend := fs.getBackend()
+	if backend == nil {
+		return fmt.Errorf("no storage backend available")
+	}
+	exclusive, canExclude := backend.(types.ExclusiveWriter)
+	if !canExclude {
+		// Check if file already exists
+		if _, err := fs.GetAttr(ctx, path); err == nil {
+			return syscall.EEXIST
+		}
+	} else {
+		// The conditional write only tests the file's own key; a directory
+		// is its marker or children under the prefix, which the new file
+		// would hide
+		isDir, err := hasChildren(ctx, backend, normalizedPath+"/")
+		if err != nil {
+			return fmt.Errorf("failed to list directory: %w", err)
+		}
+		if isDir {
+			return syscall.EEXIST
+		}
+	}
+	if err := fs.checkParent(ctx, normalizedPath); err != nil {
+		return err
 	}
 	
+	mode, err := fs.allowedMode(mode)
+	if err != nil {
+		return err
+	}
+
 	// Create empty file with mode metadata
-	modeStr := fmt.Sprintf("%04o", mode&0777)
+	modeStr := types.EncodeMode(mode & types.PermissionBits)
 	now := time.Now()
+	uid, gid := fs.ownerForWrite()
 	metadata := map[string]string{
 		"x-amz-meta-mode": modeStr,
 		"mode": modeStr,
+		"x-amz-meta-uid": fmt.Sprintf("%d", uid),
+		"uid": fmt.Sprintf("%d", uid),
+		"x-amz-meta-gid": fmt.Sprintf("%d", gid),
+		"gid": fmt.Sprintf("%d", gid),
 		"x-amz-meta-ctime": fmt.Sprintf("%d", now.Unix()),
 		"ctime": fmt.Sprintf("%d", now.Unix()),
 	}
 	
-	backend := fs.getBackend()
-	if backend == nil {
-		return fmt.Errorf("no storage backend available")
+	if canExclude {
+		err = exclusive.WriteIfAbsent(ctx, normalizedPath, []byte{}, metadata)
+	} else {
+		err = backend.WriteWithMetadata(ctx, normalizedPath, []byte{}, metadata)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return syscall.EEXIST
+	}
+	if err != nil {
+		return err
+	}
+	// Drop any cached "does not exist" entry
+	if fs.cache != nil {
+		fs.cache.GetStatCache().Delete(path)
 	}
-	return backend.WriteWithMetadata(ctx, normalizedPath, []byte{}, metadata)
+	return nil
 }
 
 // Remove removes a file
 func (fs *Filesystem) Remove(ctx context.Context, path string) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
 	normalizedPath := fs.normalizePath(path)
 	
 	// Check if file exists first
 	_, err := fs.GetAttr(ctx, path)
 	if err != nil {
-		return fmt.Errorf("file not found: %w", err)
+		return err
 	}
 	
-	// Invalidate cache
+	stored := fs.storedSize(ctx, normalizedPath)
+	
+	// Invalidate cache, including data kept after failed releases. Buffered data
+	// is dropped rather than uploaded later, which would bring the file back
 	if fs.cache != nil {
 		fs.cache.GetStatCache().Delete(path)
-		fs.cache.GetFdCache().Close(normalizedPath)
+		fs.cache.GetFdCache().Discard(normalizedPath)
+		fs.forgetFailedReleases(normalizedPath, false)
 	}
 	
 	backend := fs.getBackend()
@@ -870,12 +2211,81 @@ func (fs *Filesystem) Remove(ctx context.Context, path string) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete object: %w", err)
 	}
+	fs.forgetDiskCache(normalizedPath)
+	fs.recordChange(normalizedPath, stored, 0)
 	
 	return nil
 }
 
+// deleteMany deletes paths with backend.DeleteMany, retrying once any that fail
+// The returned *types.DeleteManyError lists the paths still present
+func deleteMany(ctx context.Context, backend types.Backend, paths []string) error {
+	err := backend.DeleteMany(ctx, paths)
+	var partial *types.DeleteManyError
+	if errors.As(err, &partial) {
+		err = backend.DeleteMany(ctx, partial.Paths())
+	}
+	return err
+}
+
+// hasChildren reports whether any key exists under prefix
+func hasChildren(ctx context.Context, backend types.Backend, prefix string) (bool, error) {
+	objects, err := listLimited(ctx, backend, prefix, 1)
+	return len(objects) > 0, err
+}
+
+// listLimited returns the first maxKeys keys under prefix
+// Backends that can limit a listing fetch just those instead of the whole subtree
+func listLimited(ctx context.Context, backend types.Backend, prefix string, maxKeys int) ([]string, error) {
+	if lister, ok := backend.(types.LimitedLister); ok {
+		return lister.ListLimited(ctx, prefix, maxKeys)
+	}
+	objects, err := backend.List(ctx, prefix)
+	return objects[:min(len(objects), maxKeys)], err
+}
+
+// updateMetadata replaces the metadata of an existing file, whose attributes
+// the caller read as current (nil if it has none at hand). Uses the backend's
+// server-side update when available, otherwise reads the content and writes
+// it back with the new metadata
+func (fs *Filesystem) updateMetadata(ctx context.Context, backend types.Backend, normalizedPath string, current *types.Attr, metadata map[string]string) error {
+	// The content type and storage class go along, so the copy replacing the
+	// metadata of an S3 object needn't look them up again
+	if current != nil {
+		if _, given := metadata[s3client.ContentTypeKey]; !given && current.ContentType != "" {
+			metadata[s3client.ContentTypeKey] = current.ContentType
+		}
+		if current.StorageClass != "" {
+			metadata[s3client.StorageClassKey] = current.StorageClass
+		}
+	}
+	var err error
+	if updater, ok := backend.(types.MetadataUpdater); ok {
+		err = updater.UpdateMetadata(ctx, normalizedPath, metadata)
+	} else {
+		var data []byte
+		if data, err = backend.Read(ctx, normalizedPath); err != nil {
+			return fmt.Errorf("failed to read file for metadata update: %w", err)
+		}
+		err = backend.WriteWithMetadata(ctx, normalizedPath, data, metadata)
+	}
+	if err == nil {
+		fs.followVersion(ctx, backend, normalizedPath)
+	}
+	// The next upload of an open file takes the new metadata from storage
+	if fs.cache != nil {
+		if entity, found := fs.cache.GetFdCache().Get(normalizedPath); found {
+			entity.SetStored(nil)
+		}
+	}
+	return err
+}
+
 // Rename renames a file or directory
 func (fs *Filesystem) Rename(ctx context.Context, oldPath, newPath string) error {
+	if fs.readOnly || fs.isVersionPath(oldPath) || fs.isVersionPath(newPath) {
+		return syscall.EROFS
+	}
 	// Flush buffered data for source path before renaming
 	if err := fs.flushBufferedData(ctx, oldPath); err != nil {
 		// If client not initialized, return error that can be caught by tests
@@ -899,6 +2309,20 @@ func (fs *Filesystem) Rename(ctx context.Context, oldPath, newPath string) error
 	}
 	
 	isDir := attr.Mode.IsDir()
+	oldKey := strings.TrimSuffix(oldNormalized, "/")
+	newKey := strings.TrimSuffix(newNormalized, "/")
+	if oldKey == newKey {
+		return nil // Renaming a path onto itself does nothing
+	}
+	if isDir && strings.HasPrefix(newKey+"/", oldKey+"/") {
+		return syscall.EINVAL // Can't move a directory into itself
+	}
+	if fs.caseInsensitive && strings.EqualFold(oldKey, newKey) {
+		return fs.renameCase(ctx, oldPath, newPath)
+	}
+	if err := fs.checkRenameTarget(ctx, oldPath, newPath, isDir); err != nil {
+		return err
+	}
 	if isDir {
 		// Normalize directory paths
 		if !strings.HasSuffix(oldNormalized, "/") {
@@ -925,27 +2349,24 @@ func (fs *Filesystem) Rename(ctx context.Context, oldPath, newPath string) error
 			return fmt.Errorf("no storage backend available")
 		}
 		
-		objects, err := backend.List(ctx, oldNormalized)
+		// Moving a tree onto an empty directory leaves the usage as it was
+		err = renameTreeInPages(ctx, backend, oldNormalized, newNormalized)
+		fs.recordRenameResult(oldNormalized, newNormalized, err)
 		if err != nil {
-			return fmt.Errorf("failed to list directory objects: %w", err)
-		}
-		
-		// Copy each object to new location
-		for _, objKey := range objects {
-			newKey := strings.Replace(objKey, oldNormalized, newNormalized, 1)
-			// Use backend Rename for each file
-			if err := backend.Rename(ctx, objKey, newKey); err != nil {
-				return fmt.Errorf("failed to rename object %s: %w", objKey, err)
-			}
+			fs.rescanUsage(ctx, backend)
+		} else {
+			fs.recordTreeChange(0, 0)
 		}
 		
-		// Invalidate cache
+		// Invalidate cache for both trees, even after a partial move
 		if fs.cache != nil {
 			fs.cache.GetStatCache().Delete(oldPath)
 			fs.cache.GetStatCache().Delete(newPath)
+			fs.invalidatePrefix(strings.TrimSuffix(oldNormalized, "/"))
+			fs.invalidatePrefix(strings.TrimSuffix(newNormalized, "/"))
 		}
 		
-		return nil
+		return err
 	}
 
 	// Use backend Rename method
@@ -953,9 +2374,15 @@ func (fs *Filesystem) Rename(ctx context.Context, oldPath, newPath string) error
 	if backend == nil {
 		return fmt.Errorf("no storage backend available")
 	}
+	replaced := fs.storedSize(ctx, newNormalized)
 	if err := backend.Rename(ctx, oldNormalized, newNormalized); err != nil {
+		// The copy may have been made without the source being deleted
+		fs.rescanUsage(ctx, backend)
 		return err
 	}
+	fs.forgetDiskCache(oldNormalized)
+	fs.recordChange(oldNormalized, attr.Size, 0)
+	fs.recordChange(newNormalized, replaced, attr.Size)
 
 	// Invalidate cache
 	if fs.cache != nil {
@@ -968,14 +2395,97 @@ func (fs *Filesystem) Rename(ctx context.Context, oldPath, newPath string) error
 	return nil
 }
 
+// checkRenameTarget applies rename(2)'s rules for an existing destination
+// A file may replace a file and a directory may replace an empty directory; a
+// non-empty directory gives ENOTEMPTY (Linux's choice where POSIX also allows
+// EEXIST) unless it holds the remains of an interrupted rename of the same source
+func (fs *Filesystem) checkRenameTarget(ctx context.Context, oldPath, newPath string, isDir bool) error {
+	dstAttr, err := fs.GetAttr(ctx, newPath)
+	if err != nil {
+		return nil // Nothing in the way
+	}
+	if !dstAttr.Mode.IsDir() {
+		if isDir {
+			return syscall.ENOTDIR
+		}
+		return nil
+	}
+	if !isDir {
+		return syscall.EISDIR
+	}
+
+	oldPrefix := strings.TrimSuffix(fs.normalizePath(oldPath), "/") + "/"
+	newPrefix := strings.TrimSuffix(fs.normalizePath(newPath), "/") + "/"
+	if fs.resumingRename(oldPrefix, newPrefix) {
+		return nil
+	}
+	empty, err := fs.isEmptyDir(ctx, newPath)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return syscall.ENOTEMPTY
+	}
+	return nil
+}
+
+// recordRenameResult remembers a directory rename that stopped part-way so that
+// repeating it isn't refused because the destination is no longer empty
+func (fs *Filesystem) recordRenameResult(oldPrefix, newPrefix string, err error) {
+	fs.renameMu.Lock()
+	defer fs.renameMu.Unlock()
+	var renameErr *RenameError
+	if errors.As(err, &renameErr) {
+		if fs.partialRenames == nil {
+			fs.partialRenames = make(map[string]string)
+		}
+		fs.partialRenames[newPrefix] = oldPrefix
+		return
+	}
+	if err == nil {
+		delete(fs.partialRenames, newPrefix)
+	}
+}
+
+// resumingRename reports whether renaming oldPrefix to newPrefix continues an
+// earlier rename that stopped part-way
+func (fs *Filesystem) resumingRename(oldPrefix, newPrefix string) bool {
+	fs.renameMu.Lock()
+	defer fs.renameMu.Unlock()
+	return fs.partialRenames[newPrefix] == oldPrefix
+}
+
+// isEmptyDir reports whether a directory holds nothing besides its marker
+func (fs *Filesystem) isEmptyDir(ctx context.Context, path string) (bool, error) {
+	entries, err := fs.ReadDir(ctx, path)
+	if err != nil {
+		return false, fmt.Errorf("failed to list directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name != ".keep" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // Mkdir creates a directory
 func (fs *Filesystem) Mkdir(ctx context.Context, path string, mode os.FileMode) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
 	normalizedPath := fs.normalizePath(path)
 	
 	// Ensure path ends with / for directories
 	if !strings.HasSuffix(normalizedPath, "/") {
 		normalizedPath += "/"
 	}
+	if fs.beingRemoved(normalizedPath) {
+		return syscall.ENOENT
+	}
+	// A second mkdir of the path in this mount waits for the first, then finds
+	// its directory; the marker is written conditionally for other clients
+	defer fs.creating.lock(normalizedPath)()
 	
 	// Check if directory already exists
 	entries, err := fs.ReadDir(ctx, path)
@@ -986,14 +2496,24 @@ func (fs *Filesystem) Mkdir(ctx context.Context, path string, mode os.FileMode)
 			return syscall.EEXIST // Directory already exists
 		}
 	}
+	if err := fs.checkParent(ctx, normalizedPath); err != nil {
+		return err
+	}
+	mode, err = fs.allowedMode(mode)
+	if err != nil {
+		return err
+	}
 	
 	// Create directory marker object (empty object with trailing slash)
 	// Store metadata for mode, uid, gid
 	now := time.Now()
+	uid, gid := fs.ownerForWrite()
+	modeStr := types.EncodeMode(os.ModeDir | mode&types.PermissionBits)
 	metadata := map[string]string{
-		"x-amz-meta-mode":  fmt.Sprintf("%o", mode),
-		"x-amz-meta-uid":   fmt.Sprintf("%d", os.Getuid()),
-		"x-amz-meta-gid":   fmt.Sprintf("%d", os.Getgid()),
+		"x-amz-meta-mode":  modeStr,
+		"mode":             modeStr,
+		"x-amz-meta-uid":   fmt.Sprintf("%d", uid),
+		"x-amz-meta-gid":   fmt.Sprintf("%d", gid),
 		"x-amz-meta-mtime": fmt.Sprintf("%d", now.Unix()),
 		"x-amz-meta-ctime": fmt.Sprintf("%d", now.Unix()),
 	}
@@ -1003,11 +2523,32 @@ func (fs *Filesystem) Mkdir(ctx context.Context, path string, mode os.FileMode)
 	if backend == nil {
 		return fmt.Errorf("no storage backend available")
 	}
-	return backend.WriteWithMetadata(ctx, normalizedPath+".keep", []byte{}, metadata)
+	markerPath := fs.newDirMarker(normalizedPath)
+	markDirType(markerPath, metadata)
+	if writer, ok := backend.(types.ExclusiveWriter); ok {
+		err = writer.WriteIfAbsent(ctx, markerPath, []byte{}, metadata)
+	} else {
+		err = backend.WriteWithMetadata(ctx, markerPath, []byte{}, metadata)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return syscall.EEXIST // Created by another client since the check above
+	}
+	if err != nil {
+		return err
+	}
+	
+	// Drop any cached "does not exist" entry
+	if fs.cache != nil {
+		fs.cache.GetStatCache().Delete(path)
+	}
+	return nil
 }
 
 // Rmdir removes an empty directory
 func (fs *Filesystem) Rmdir(ctx context.Context, path string) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
 	normalizedPath := fs.normalizePath(path)
 	
 	// Ensure path ends with / for directories
@@ -1024,47 +2565,259 @@ func (fs *Filesystem) Rmdir(ctx context.Context, path string) error {
 		return syscall.ENOTDIR
 	}
 	
-	// Check if directory is empty
-	entries, err := fs.ReadDir(ctx, path)
+	// A child still buffered in memory may not be in the listing yet; deleting the
+	// marker now would orphan it when it is flushed
+	if fs.cache != nil {
+		buffered := fs.cache.GetFdCache().GetBufferedPaths(normalizedPath)
+		if len(buffered) > 0 && !fs.rmdirFlush {
+			return syscall.ENOTEMPTY
+		}
+		for _, bufferedPath := range buffered {
+			if err := fs.flushBufferedData(ctx, bufferedPath); err != nil {
+				return fmt.Errorf("failed to flush buffered data for %s before rmdir: %w", bufferedPath, err)
+			}
+		}
+	}
+	
+	backend := fs.getBackend()
+	if backend == nil {
+		return fmt.Errorf("no storage backend available")
+	}
+
+	// Three keys are enough to tell: at most two of them can be markers ("dir/"
+	// and "dir/.keep"), so a third is always a child
+	keys, err := listLimited(ctx, backend, normalizedPath, 3)
 	if err != nil {
 		return fmt.Errorf("failed to list directory: %w", err)
 	}
-	
-	// Filter out directory markers
-	realEntries := 0
-	for _, entry := range entries {
-		if entry.Name != ".keep" {
-			realEntries++
+	for _, key := range keys {
+		if key != normalizedPath && key != normalizedPath+".keep" {
+			return syscall.ENOTEMPTY
 		}
 	}
+
+	// Delete the markers, whichever forms exist. Without any, the directory
+	// existed only in the caches
+	markers, _, err := dirMarkers(ctx, backend, normalizedPath, 0)
+	if err != nil {
+		return fmt.Errorf("failed to look up directory marker: %w", err)
+	}
+	for _, marker := range markers {
+		if err := backend.Delete(ctx, marker); err != nil {
+			return fmt.Errorf("failed to delete directory marker: %w", err)
+		}
+	}
+
+	// A C++ s3fs "dir" marker is cached like a file; the parent's listing changed
+	if fs.cache != nil {
+		dir := strings.TrimSuffix(normalizedPath, "/")
+		fs.invalidatePrefix(dir)
+		parent := ""
+		if i := strings.LastIndex(dir, "/"); i >= 0 {
+			parent = dir[:i]
+		}
+		statCache := fs.cache.GetStatCache()
+		statCache.Delete(parent)
+		statCache.Delete("/" + parent)
+	}
+	return nil
+}
+
+// SetRecursiveRmdir makes the FUSE layer remove non-empty directories with RemoveAll
+// instead of failing with ENOTEMPTY; this is not POSIX rmdir behaviour, so it is opt-in
+func (fs *Filesystem) SetRecursiveRmdir(enable bool) {
+	fs.recursiveRmdir = enable
+}
+
+// SetStrictDirs makes Create, WriteFile and AppendFile (of a new file) and Mkdir fail with ENOENT
+// when the parent directory does not exist, as open(2) and mkdir(2) do. By
+// default any key can be written and its parent directories exist implicitly
+func (fs *Filesystem) SetStrictDirs(enable bool) {
+	fs.strictDirs = enable
+}
+
+// SetAttrCacheTimeout sets how long the kernel may cache the attributes it is
+// given before asking again. Longer timeouts save round trips for hot paths but
+// delay changes made by other clients; 0 makes every stat reach the filesystem
+func (fs *Filesystem) SetAttrCacheTimeout(timeout time.Duration) {
+	fs.attrTimeout = timeout
+}
+
+// SetEntryCacheTimeout sets how long the kernel may cache the result of looking
+// up a name in a directory, so path walks skip the lookup
+func (fs *Filesystem) SetEntryCacheTimeout(timeout time.Duration) {
+	fs.entryTimeout = timeout
+}
+
+// SetMaxReadSize caps how much ReadFile returns when asked for the rest of a
+// file (size 0), so reading a huge object doesn't hold it all in memory; a
+// read to the end with more left fails with EFBIG instead of returning a
+// silently short file, and the caller reads in ranges. 0 removes the cap
+func (fs *Filesystem) SetMaxReadSize(size int64) {
+	fs.maxReadSize = size
+}
+
+// attrValidity returns how long the kernel may cache the attributes of path
+// Files with data buffered or being uploaded are not cached: the upload sets a
+// new mtime, and in write-back mode happens without the kernel knowing
+func (fs *Filesystem) attrValidity(path string) time.Duration {
+	if fs.cache != nil {
+		if entity, found := fs.cache.GetFdCache().Get(fs.normalizePath(path)); found {
+			if entity.BytesModified() > 0 || entity.Uploading() {
+				return 0
+			}
+		}
+	}
+	return fs.attrTimeout
+}
+
+// checkParent returns ENOENT if strict directories are enabled and the parent of
+// normalizedPath does not exist (neither a marker nor other keys under it), and
+// ENOTDIR if the parent is a file
+func (fs *Filesystem) checkParent(ctx context.Context, normalizedPath string) error {
+	if !fs.strictDirs {
+		return nil
+	}
+	parent := strings.TrimSuffix(normalizedPath, "/")
+	i := strings.LastIndex(parent, "/")
+	if i < 0 {
+		return nil // The root always exists
+	}
+	attr, err := fs.GetAttr(ctx, parent[:i])
+	if err != nil {
+		if errors.Is(err, syscall.EIO) {
+			return err // Unreachable backend, not a missing parent
+		}
+		return syscall.ENOENT
+	}
+	if !attr.Mode.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return nil
+}
+
+// SetRmdirFlush makes Rmdir flush buffered children and decide from the listing
+// afterwards, instead of refusing with ENOTEMPTY while any child is buffered
+func (fs *Filesystem) SetRmdirFlush(enable bool) {
+	fs.rmdirFlush = enable
+}
+
+// maxRemovePasses bounds how often RemoveAll re-lists a prefix to catch objects
+// written by requests that were already in flight when the removal started
+const maxRemovePasses = 3
+
+// beginRemoval marks prefix as being deleted; creates and writes under it fail with ENOENT
+func (fs *Filesystem) beginRemoval(prefix string) {
+	fs.removalMu.Lock()
+	defer fs.removalMu.Unlock()
+	if fs.removing == nil {
+		fs.removing = make(map[string]int)
+	}
+	fs.removing[prefix]++
+}
+
+// endRemoval clears a mark set by beginRemoval
+func (fs *Filesystem) endRemoval(prefix string) {
+	fs.removalMu.Lock()
+	defer fs.removalMu.Unlock()
+	if fs.removing[prefix]--; fs.removing[prefix] <= 0 {
+		delete(fs.removing, prefix)
+	}
+}
+
+// beingRemoved reports whether normalizedPath lies in a tree RemoveAll is deleting
+func (fs *Filesystem) beingRemoved(normalizedPath string) bool {
+	fs.removalMu.Lock()
+	defer fs.removalMu.Unlock()
+	for prefix := range fs.removing {
+		if strings.HasPrefix(normalizedPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveAll removes a directory and everything under it, like rm -rf
+// Objects are deleted in batches; if any remain, the error wraps a
+// *types.DeleteManyError listing them
+func (fs *Filesystem) RemoveAll(ctx context.Context, path string) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
+	normalizedPath := strings.TrimSuffix(fs.normalizePath(path), "/")
+	if normalizedPath == "" {
+		return syscall.EBUSY // Refuse to empty the whole mount
+	}
 	
-	if realEntries > 0 {
-		return syscall.ENOTEMPTY // Directory is not empty
+	attr, err := fs.GetAttr(ctx, path)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	if !attr.Mode.IsDir() {
+		return syscall.ENOTDIR
 	}
 	
-	// Remove directory marker if it exists
 	backend := fs.getBackend()
 	if backend == nil {
 		return fmt.Errorf("no storage backend available")
 	}
 	
-	err = backend.Delete(ctx, normalizedPath+".keep")
-	if err != nil {
-		// Directory marker might not exist, which is okay
-		// Check if there are any objects with this prefix
-		objects, listErr := backend.List(ctx, normalizedPath)
-		if listErr != nil || len(objects) > 0 {
-			return syscall.ENOTEMPTY
+	prefix := normalizedPath + "/"
+	fs.beginRemoval(prefix)
+	defer fs.endRemoval(prefix)
+	
+	if fs.cache != nil {
+		// Upload buffered files so they are listed and deleted below,
+		// then drop their entities so a later release can't recreate them
+		fdCache := fs.cache.GetFdCache()
+		for _, bufferedPath := range fdCache.GetBufferedPaths(prefix) {
+			if err := fs.flushBufferedData(ctx, bufferedPath); err != nil {
+				return fmt.Errorf("failed to flush buffered data for %s before removal: %w", bufferedPath, err)
+			}
 		}
-		// Directory is effectively empty, allow removal
-		return nil
+		defer fdCache.DropPrefix(prefix)
+		defer fs.forgetFailedReleases(prefix, true)
+		defer fs.invalidatePrefix(normalizedPath)
+	}
+	removed, err := fs.usedUnder(ctx, backend, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list directory objects: %w", err)
 	}
 	
-	return nil
+	for pass := 0; ; pass++ {
+		objects, err := backend.List(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("failed to list directory objects: %w", err)
+		}
+		if len(objects) == 0 {
+			fs.recordTreeChange(removed, 0)
+			return nil
+		}
+		if pass == maxRemovePasses {
+			return fmt.Errorf("failed to remove %s: %d objects reappeared during removal: %w", path, len(objects), syscall.ENOTEMPTY)
+		}
+		if err := deleteMany(ctx, backend, objects); err != nil {
+			fs.rescanUsage(ctx, backend)
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+}
+
+// invalidatePrefix drops stat cache entries for a directory and everything under it
+// The stat cache is keyed by the path as given, so both slash forms are cleared
+func (fs *Filesystem) invalidatePrefix(normalizedPath string) {
+	statCache := fs.cache.GetStatCache()
+	for _, dir := range []string{normalizedPath, "/" + normalizedPath} {
+		statCache.Delete(dir)
+		statCache.DeletePrefix(dir + "/")
+	}
 }
 
 // Symlink creates a symbolic link
 func (fs *Filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	if fs.readOnly || fs.isVersionPath(newname) {
+		return syscall.EROFS
+	}
 	normalizedPath := fs.normalizePath(newname)
 	
 	// Check if target already exists
@@ -1073,15 +2826,24 @@ func (fs *Filesystem) Symlink(ctx context.Context, oldname, newname string) erro
 		return syscall.EEXIST
 	}
 	
-	// Create symlink file with target path as content
+	// Create symlink file with target path as content. The file type in the
+	// stored mode (S_IFLNK) is all that marks the object as a symlink
+	// once the stat cache has forgotten it; like Create, set both key forms
 	now := time.Now()
+	uid, gid := fs.ownerForWrite()
+	modeStr := types.EncodeMode(os.ModeSymlink | 0777)
 	metadata := map[string]string{
-		"x-amz-meta-mode":  fmt.Sprintf("%o", os.ModeSymlink|0777),
-		"x-amz-meta-uid":   fmt.Sprintf("%d", os.Getuid()),
-		"x-amz-meta-gid":   fmt.Sprintf("%d", os.Getgid()),
+		"x-amz-meta-mode":  modeStr,
+		"mode":             modeStr,
+		"x-amz-meta-uid":   fmt.Sprintf("%d", uid),
+		"uid":              fmt.Sprintf("%d", uid),
+		"x-amz-meta-gid":   fmt.Sprintf("%d", gid),
+		"gid":              fmt.Sprintf("%d", gid),
 		"x-amz-meta-mtime": fmt.Sprintf("%d", now.Unix()),
+		"mtime":            fmt.Sprintf("%d", now.Unix()),
 		"x-amz-meta-atime": fmt.Sprintf("%d", now.Unix()),
 		"x-amz-meta-ctime": fmt.Sprintf("%d", now.Unix()),
+		"ctime":            fmt.Sprintf("%d", now.Unix()),
 	}
 	
 	// Store symlink target in file content
@@ -1094,6 +2856,7 @@ func (fs *Filesystem) Symlink(ctx context.Context, oldname, newname string) erro
 	if err != nil {
 		return fmt.Errorf("failed to create symlink: %w", err)
 	}
+	fs.recordChange(normalizedPath, 0, int64(len(targetData)))
 	
 	// Cache symlink target
 	if fs.cache != nil {
@@ -1146,9 +2909,10 @@ func (fs *Filesystem) Mknod(ctx context.Context, path string, mode os.FileMode,
 }
 
 // Access checks file access permissions
+// The caller's uid/gid are taken from the context (see WithCaller)
 func (fs *Filesystem) Access(ctx context.Context, path string, mask uint32) error {
 	// Check if file exists
-	_, err := fs.GetAttr(ctx, path)
+	attr, err := fs.GetAttr(ctx, path)
 	if err != nil {
 		return err
 	}
@@ -1159,10 +2923,8 @@ func (fs *Filesystem) Access(ctx context.Context, path string, mask uint32) erro
 		return nil
 	}
 	
-	// For now, allow all if file exists
-	// In a full implementation, we'd check actual permissions
-	// against the current user's uid/gid
-	return nil
+	uid, gid := callerFromContext(ctx)
+	return checkAccess(attr, uid, gid, mask)
 }
 
 // Statfs represents filesystem statistics
@@ -1180,7 +2942,7 @@ type Statfs struct {
 func (fs *Filesystem) Statfs(ctx context.Context) (*Statfs, error) {
 	// Return default filesystem statistics
 	// S3 doesn't have real filesystem limits, so we return large values
-	return &Statfs{
+	statfs := &Statfs{
 		Bsize:  4096,              // Block size
 		Blocks: 1000000000,        // Total blocks (fake large number)
 		Bfree:  1000000000,        // Free blocks
@@ -1188,7 +2950,22 @@ func (fs *Filesystem) Statfs(ctx context.Context) (*Statfs, error) {
 		Files:  1000000000,        // Total inodes
 		Ffree:  1000000000,        // Free inodes
 		Namelen: 255,              // Max filename length
-	}, nil
+	}
+
+	// With usage tracked, the quota (if any) is the size and the bytes stored
+	// are what's used
+	if fs.usage != nil {
+		used, quota := fs.UsedBytes()
+		usedBlocks := uint64((used + int64(statfs.Bsize) - 1) / int64(statfs.Bsize))
+		if quota > 0 {
+			statfs.Blocks = uint64(quota / int64(statfs.Bsize))
+		} else {
+			statfs.Blocks = max(statfs.Blocks, usedBlocks)
+		}
+		statfs.Bfree = statfs.Blocks - min(usedBlocks, statfs.Blocks)
+		statfs.Bavail = statfs.Bfree
+	}
+	return statfs, nil
 }
 
 // Flush flushes file buffers
@@ -1277,9 +3054,21 @@ func (fs *Filesystem) Release(ctx context.Context, path string) error {
 		if entity, found := fdCache.Get(normalizedPath); found {
 			// Upload any buffered data before closing
 			if entity.BytesModified() > 0 {
-				if err := fs.uploadBufferedData(ctx, normalizedPath, entity); err != nil {
-					// Log error but still close
-					// In production, you might want to handle this differently
+				// Changes refused because another client changed the file are
+				// already discarded, leaving nothing to retry
+				err := fs.uploadBufferedData(ctx, normalizedPath, entity)
+				if err != nil && !errors.Is(err, syscall.ESTALE) {
+					// close(2) has already returned, so nobody else will see this
+					// Keep the handle's reference so the data stays cached until a
+					// later Flush, Fsync, Release or FlushAll uploads it
+					logging.Error("failed to upload buffered data on release, keeping it for retry", "path", normalizedPath, "bytes", entity.BytesModified(), "err", err)
+					fs.releaseMu.Lock()
+					if fs.failedReleases == nil {
+						fs.failedReleases = make(map[string]int)
+					}
+					fs.failedReleases[normalizedPath]++
+					fs.releaseMu.Unlock()
+					return nil
 				}
 			}
 		}
@@ -1291,6 +3080,74 @@ func (fs *Filesystem) Release(ctx context.Context, path string) error {
 	return nil
 }
 
+// completeFailedReleases drops the FD cache references kept by releases of
+// normalizedPath whose upload failed, now that the data has been uploaded
+func (fs *Filesystem) completeFailedReleases(normalizedPath string) {
+	fs.releaseMu.Lock()
+	count := fs.failedReleases[normalizedPath]
+	delete(fs.failedReleases, normalizedPath)
+	fs.releaseMu.Unlock()
+	
+	if count > 0 {
+		logging.Info("uploaded buffered data kept after a failed release", "path", normalizedPath)
+	}
+	for i := 0; i < count; i++ {
+		fs.cache.GetFdCache().Close(normalizedPath)
+	}
+}
+
+// forgetFailedReleases discards failed-release bookkeeping for normalizedPath, or
+// for every path under it when tree is set, once the data is no longer wanted
+// Returns how many references were held for normalizedPath itself
+func (fs *Filesystem) forgetFailedReleases(normalizedPath string, tree bool) int {
+	fs.releaseMu.Lock()
+	defer fs.releaseMu.Unlock()
+	count := fs.failedReleases[normalizedPath]
+	for path := range fs.failedReleases {
+		if path == normalizedPath || (tree && strings.HasPrefix(path, normalizedPath)) {
+			delete(fs.failedReleases, path)
+		}
+	}
+	return count
+}
+
+// FlushAll uploads the buffered data of every file, including data kept after a
+// failed release. Called on unmount; returns the first failure after trying all
+func (fs *Filesystem) FlushAll(ctx context.Context) error {
+	if fs.cache == nil {
+		return nil
+	}
+	var firstErr error
+	for _, path := range fs.cache.GetFdCache().GetBufferedPaths("") {
+		if err := fs.flushBufferedData(ctx, path); err != nil {
+			logging.Error("failed to upload buffered data", "path", path, "err", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to upload %s: %w", path, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// ReleaseReadOnly releases a handle opened read-only
+// Only drops the local FD cache reference; never uploads or touches the backend
+func (fs *Filesystem) ReleaseReadOnly(ctx context.Context, path string) error {
+	normalizedPath := fs.normalizePath(path)
+	
+	if fs.cache != nil {
+		fdCache := fs.cache.GetFdCache()
+		if entity, found := fdCache.Get(normalizedPath); found {
+			// Buffered data belongs to a writable handle, which will upload it on its own release
+			if entity.BytesModified() > 0 {
+				return nil
+			}
+		}
+		return fdCache.Close(normalizedPath)
+	}
+	
+	return nil
+}
+
 // Opendir opens a directory handle
 func (fs *Filesystem) Opendir(ctx context.Context, path string) error {
 	// Check if directory exists and is accessible
@@ -1,10 +1,28 @@
 package fuse
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/aws/smithy-go"
+	"github.com/s3fs-fuse/s3fs-go/internal/cache"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/backendtest"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 func TestNewFilesystem(t *testing.T) {
@@ -67,3 +85,1657 @@ func TestWriteFile(t *testing.T) {
 	// Test will fail until implemented
 	_ = err
 }
+
+func TestGetAttrBlocks(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		size           int
+		expectedBlocks uint64
+	}{
+		{"empty.txt", 0, 0},
+		{"one-block.txt", 512, 1},
+		{"partial-block.txt", 1000, 2},
+		{"large.txt", 1024*1024 + 1, 2049},
+	}
+
+	for _, tt := range tests {
+		if err := client.PutObject(ctx, tt.name, make([]byte, tt.size)); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+
+		attr, err := filesystem.GetAttr(ctx, tt.name)
+		if err != nil {
+			t.Fatalf("Failed to get attributes for %s: %v", tt.name, err)
+		}
+		if attr.Blocks != tt.expectedBlocks {
+			t.Errorf("%s: expected %d blocks, got %d", tt.name, tt.expectedBlocks, attr.Blocks)
+		}
+
+		// Blocks must also be reported through the FUSE node
+		var fuseAttr fuse.Attr
+		file := &File{filesystem: filesystem, path: tt.name}
+		if err := file.Attr(ctx, &fuseAttr); err != nil {
+			t.Fatalf("Failed to get FUSE attributes for %s: %v", tt.name, err)
+		}
+		if fuseAttr.Blocks != tt.expectedBlocks {
+			t.Errorf("%s: expected FUSE blocks %d, got %d", tt.name, tt.expectedBlocks, fuseAttr.Blocks)
+		}
+	}
+}
+
+func TestKernelCacheValidity(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+	client.PutObject(ctx, "dir/file.txt", []byte("hello"))
+
+	root := &Dir{filesystem: filesystem, path: "/"}
+	var fuseAttr fuse.Attr
+	if err := root.Attr(ctx, &fuseAttr); err != nil || fuseAttr.Valid != DefaultAttrCacheTimeout {
+		t.Errorf("Default directory attribute validity = %v (err %v), want %v", fuseAttr.Valid, err, DefaultAttrCacheTimeout)
+	}
+
+	filesystem.SetAttrCacheTimeout(10 * time.Second)
+	filesystem.SetEntryCacheTimeout(30 * time.Second)
+	dir := &Dir{filesystem: filesystem, path: "/dir"}
+	resp := &fuse.LookupResponse{}
+	node, err := dir.Lookup(ctx, &fuse.LookupRequest{Name: "file.txt"}, resp)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if resp.EntryValid != 30*time.Second {
+		t.Errorf("Lookup entry validity = %v, want 30s", resp.EntryValid)
+	}
+	file := node.(*File)
+	if err := file.Attr(ctx, &fuseAttr); err != nil || fuseAttr.Valid != 10*time.Second {
+		t.Errorf("File attribute validity = %v (err %v), want 10s", fuseAttr.Valid, err)
+	}
+
+	// Attributes of a file with data not yet uploaded must not be cached
+	if err := filesystem.WriteFile(ctx, "/dir/file.txt", []byte("EL"), 1); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := file.Attr(ctx, &fuseAttr); err != nil || fuseAttr.Valid != 0 {
+		t.Errorf("Attribute validity with buffered data = %v (err %v), want 0", fuseAttr.Valid, err)
+	}
+	if err := filesystem.Flush(ctx, "/dir/file.txt"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := file.Attr(ctx, &fuseAttr); err != nil || fuseAttr.Valid != 10*time.Second {
+		t.Errorf("Attribute validity after upload = %v (err %v), want 10s", fuseAttr.Valid, err)
+	}
+}
+
+// recordingInvalidator records the kernel entries it is asked to invalidate
+type recordingInvalidator struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (r *recordingInvalidator) InvalidateEntry(parent fusefs.Node, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, parent.(*Dir).path+" "+name)
+	return nil
+}
+
+func TestMkdirEntryExpires(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	kernel := &recordingInvalidator{}
+	filesystem.kernel = kernel
+	ctx := context.Background()
+
+	// The default timeout is the validity the kernel is given anyway
+	root := &Dir{filesystem: filesystem, path: "/"}
+	if _, err := root.Mkdir(ctx, &fuse.MkdirRequest{Name: "kept", Mode: os.ModeDir | 0755}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	filesystem.SetEntryCacheTimeout(0)
+	if _, err := root.Mkdir(ctx, &fuse.MkdirRequest{Name: "expired", Mode: os.ModeDir | 0755}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	time.Sleep(minEntryExpiry + 200*time.Millisecond)
+	kernel.mu.Lock()
+	defer kernel.mu.Unlock()
+	if len(kernel.entries) != 1 || kernel.entries[0] != "/ expired" {
+		t.Errorf("Expected only the entry of the directory made with a short timeout invalidated, got %v", kernel.entries)
+	}
+}
+
+func TestGetAttrSingleHead(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := client.PutObject(ctx, "single-head.txt", []byte("hello")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	before := client.HeadCount()
+	attr, err := filesystem.GetAttr(ctx, "single-head.txt")
+	if err != nil {
+		t.Fatalf("Failed to get attributes: %v", err)
+	}
+	if attr.Size != 5 {
+		t.Errorf("Expected size 5, got %d", attr.Size)
+	}
+
+	if heads := client.HeadCount() - before; heads != 1 {
+		t.Errorf("Expected exactly 1 HEAD request per stat, got %d", heads)
+	}
+}
+
+func TestGetAttrDirectoryLimitedList(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		if err := client.PutObject(ctx, fmt.Sprintf("big/file-%02d.txt", i), []byte("x")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	// The root needs no listing at all
+	if _, err := filesystem.GetAttr(ctx, ""); err != nil {
+		t.Fatalf("GetAttr(root) failed: %v", err)
+	}
+	if lists := client.ListCount() + client.LimitedListCount(); lists != 0 {
+		t.Errorf("Expected no list requests for the root, got %d", lists)
+	}
+
+	// A directory without a marker is confirmed by a single-key list
+	attr, err := filesystem.GetAttr(ctx, "big")
+	if err != nil {
+		t.Fatalf("GetAttr(big) failed: %v", err)
+	}
+	if !attr.Mode.IsDir() {
+		t.Errorf("Expected big to be a directory, got mode %v", attr.Mode)
+	}
+	if lists, limited := client.ListCount(), client.LimitedListCount(); lists != 0 || limited != 1 {
+		t.Errorf("Expected a single limited list, got %d full and %d limited", lists, limited)
+	}
+}
+
+func TestGetAttrNegativeCache(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetNegativeCacheTTL(time.Minute)
+	ctx := context.Background()
+
+	if _, err := filesystem.GetAttr(ctx, "missing.txt"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("Expected ENOENT, got %v", err)
+	}
+
+	// Second stat is answered from the negative cache
+	before := client.HeadCount()
+	if _, err := filesystem.GetAttr(ctx, "missing.txt"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("Expected ENOENT, got %v", err)
+	}
+	if heads := client.HeadCount() - before; heads != 0 {
+		t.Errorf("Expected no HeadObject calls for a cached miss, got %d", heads)
+	}
+
+	// Creating the path invalidates the negative entry
+	if err := filesystem.Create(ctx, "missing.txt", 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if _, err := filesystem.GetAttr(ctx, "missing.txt"); err != nil {
+		t.Errorf("Expected created file to be found, got %v", err)
+	}
+
+	if _, err := filesystem.GetAttr(ctx, "newdir"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("Expected ENOENT, got %v", err)
+	}
+	if err := filesystem.Mkdir(ctx, "newdir", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	attr, err := filesystem.GetAttr(ctx, "newdir")
+	if err != nil || !attr.Mode.IsDir() {
+		t.Errorf("Expected created directory to be found, got %v", err)
+	}
+
+	if _, err := filesystem.GetAttr(ctx, "written.txt"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("Expected ENOENT, got %v", err)
+	}
+	if err := filesystem.WriteFile(ctx, "written.txt", []byte("data"), 0); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := filesystem.GetAttr(ctx, "written.txt"); err != nil {
+		t.Errorf("Expected written file to be found, got %v", err)
+	}
+}
+
+// readOnlyBackend simulates read-only credentials: every mutation fails and is counted
+type readOnlyBackend struct {
+	types.Backend
+	writes int
+}
+
+func (b *readOnlyBackend) Write(ctx context.Context, path string, data []byte) error {
+	b.writes++
+	return syscall.EACCES
+}
+
+func (b *readOnlyBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	b.writes++
+	return syscall.EACCES
+}
+
+func (b *readOnlyBackend) Delete(ctx context.Context, path string) error {
+	b.writes++
+	return syscall.EACCES
+}
+
+func (b *readOnlyBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	b.writes++
+	return syscall.EACCES
+}
+
+func TestReadOnlyHandleFlushRelease(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+
+	if err := client.PutObject(ctx, "readonly.txt", []byte("read only data")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	backend := &readOnlyBackend{Backend: newS3Adapter(client)}
+	filesystem := NewFilesystemWithBackend(backend)
+	// Access times are the one thing reads store
+	filesystem.SetAtimeMode(AtimeOff)
+
+	node := &File{filesystem: filesystem, path: "readonly.txt"}
+	handle, err := node.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	file := handle.(*File)
+
+	resp := &fuse.ReadResponse{}
+	if err := file.Read(ctx, &fuse.ReadRequest{Offset: 0, Size: 4}, resp); err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(resp.Data) != "read" {
+		t.Errorf("Expected 'read', got %q", resp.Data)
+	}
+
+	if err := file.Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Errorf("Flush on read-only handle failed: %v", err)
+	}
+	if err := file.Release(ctx, &fuse.ReleaseRequest{}); err != nil {
+		t.Errorf("Release on read-only handle failed: %v", err)
+	}
+
+	if backend.writes != 0 {
+		t.Errorf("Expected no backend writes from read-only handle, got %d", backend.writes)
+	}
+}
+
+func TestReadOnlyFilesystem(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := client.PutObject(ctx, "existing.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	filesystem.SetReadOnly(true)
+
+	writeOps := map[string]func() error{
+		"WriteFile":   func() error { return filesystem.WriteFile(ctx, "existing.txt", []byte("x"), 0) },
+		"Create":      func() error { return filesystem.Create(ctx, "new.txt", 0644) },
+		"Remove":      func() error { return filesystem.Remove(ctx, "existing.txt") },
+		"Rename":      func() error { return filesystem.Rename(ctx, "existing.txt", "moved.txt") },
+		"Mkdir":       func() error { return filesystem.Mkdir(ctx, "dir", 0755) },
+		"Rmdir":       func() error { return filesystem.Rmdir(ctx, "dir") },
+		"Symlink":     func() error { return filesystem.Symlink(ctx, "existing.txt", "link") },
+		"Chmod":       func() error { return filesystem.Chmod(ctx, "existing.txt", 0600) },
+		"Chown":       func() error { return filesystem.Chown(ctx, "existing.txt", 1000, 1000) },
+		"SetXattr":    func() error { return filesystem.SetXattr(ctx, "existing.txt", "user.a", []byte("b")) },
+		"RemoveXattr": func() error { return filesystem.RemoveXattr(ctx, "existing.txt", "user.a") },
+	}
+	for name, op := range writeOps {
+		if err := op(); err != syscall.EROFS {
+			t.Errorf("%s: expected EROFS, got %v", name, err)
+		}
+	}
+
+	// Reads keep working and nothing was modified
+	data, err := filesystem.ReadFile(ctx, "existing.txt", 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(data) != "data" {
+		t.Errorf("Expected 'data', got %q", data)
+	}
+	if objects, _ := client.ListObjects(ctx, ""); len(objects) != 1 {
+		t.Errorf("Expected 1 object, got %v", objects)
+	}
+}
+
+func TestFileDirTypeMismatch(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := client.PutObject(ctx, "dir/child.txt", []byte("child")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if err := client.PutObject(ctx, "file.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	// File operations on a directory
+	if _, err := filesystem.ReadFile(ctx, "/dir", 0, 0); err != syscall.EISDIR {
+		t.Errorf("ReadFile on directory: expected EISDIR, got %v", err)
+	}
+	if _, err := filesystem.ReadFile(ctx, "/dir/", 0, 0); err != syscall.EISDIR {
+		t.Errorf("ReadFile on directory path: expected EISDIR, got %v", err)
+	}
+	if err := filesystem.WriteFile(ctx, "/dir", []byte("x"), 0); err != syscall.EISDIR {
+		t.Errorf("WriteFile on directory: expected EISDIR, got %v", err)
+	}
+	if _, err := client.GetObject(ctx, "dir"); err == nil {
+		t.Error("WriteFile on directory should not create an object")
+	}
+
+	// Directory operations on a file
+	if _, err := filesystem.ReadDir(ctx, "/file.txt"); err != syscall.ENOTDIR {
+		t.Errorf("ReadDir on file: expected ENOTDIR, got %v", err)
+	}
+	if err := filesystem.Opendir(ctx, "/file.txt"); err != syscall.ENOTDIR {
+		t.Errorf("Opendir on file: expected ENOTDIR, got %v", err)
+	}
+
+	// Matching types keep working
+	if entries, err := filesystem.ReadDir(ctx, "/dir"); err != nil || len(entries) != 1 {
+		t.Errorf("ReadDir on directory: expected 1 entry, got %v (err %v)", entries, err)
+	}
+	if data, err := filesystem.ReadFile(ctx, "/file.txt", 0, 0); err != nil || string(data) != "data" {
+		t.Errorf("ReadFile on file: expected 'data', got %q (err %v)", data, err)
+	}
+}
+
+// listCountingBackend counts full-subtree List calls and hides ListDelimited
+type listCountingBackend struct {
+	types.Backend
+	lists int
+}
+
+func (b *listCountingBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	b.lists++
+	return b.Backend.List(ctx, prefix)
+}
+
+// delimitedCountingBackend additionally exposes the wrapped ListDelimited
+type delimitedCountingBackend struct {
+	*listCountingBackend
+}
+
+func (b delimitedCountingBackend) ListDelimited(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	return b.Backend.(types.DelimitedLister).ListDelimited(ctx, prefix, delimiter)
+}
+
+func TestReadDirDelimited(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	for _, key := range []string{"dir/a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/sub/deep/d.txt", "dir/other/.keep", "dir/.keep"} {
+		if err := client.PutObject(ctx, key, []byte("x")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+	expected := map[string]bool{"a.txt": false, "b.txt": false, "sub": true, "other": true}
+
+	plain := &listCountingBackend{Backend: newS3Adapter(client)}
+	delimited := delimitedCountingBackend{&listCountingBackend{Backend: newS3Adapter(client)}}
+
+	for name, backend := range map[string]types.Backend{"fallback": plain, "delimited": delimited} {
+		entries, err := NewFilesystemWithBackend(backend).ReadDir(ctx, "/dir")
+		if err != nil {
+			t.Fatalf("%s: ReadDir failed: %v", name, err)
+		}
+		if len(entries) != len(expected) {
+			t.Errorf("%s: expected %d entries, got %v", name, len(expected), entries)
+		}
+		for _, entry := range entries {
+			isDir, ok := expected[entry.Name]
+			if !ok || isDir != entry.IsDir {
+				t.Errorf("%s: unexpected entry %+v", name, entry)
+			}
+		}
+	}
+
+	if plain.lists == 0 {
+		t.Error("Backend without ListDelimited should fall back to List")
+	}
+	if delimited.lists != 0 {
+		t.Errorf("Delimited backend should not scan the subtree, got %d List calls", delimited.lists)
+	}
+}
+
+// batchDeleteBackend records DeleteMany batches and fails one path the first time
+type batchDeleteBackend struct {
+	*s3Adapter
+	batches  [][]string
+	renames  int
+	failOnce string
+}
+
+func (b *batchDeleteBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	b.renames++
+	return b.s3Adapter.Rename(ctx, oldPath, newPath)
+}
+
+func (b *batchDeleteBackend) DeleteMany(ctx context.Context, paths []string) error {
+	b.batches = append(b.batches, append([]string(nil), paths...))
+	failed := make(map[string]error)
+	var deletable []string
+	for _, path := range paths {
+		if path == b.failOnce {
+			failed[path] = syscall.EAGAIN
+			continue
+		}
+		deletable = append(deletable, path)
+	}
+	b.failOnce = ""
+	if err := b.s3Adapter.DeleteMany(ctx, deletable); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &types.DeleteManyError{Failed: failed}
+	}
+	return nil
+}
+
+func TestRenameDirectoryBatchDelete(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	keys := []string{"dir/.keep", "dir/a.txt", "dir/sub/b.txt"}
+	for _, key := range keys {
+		if err := client.PutObject(ctx, key, []byte("x")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	backend := &batchDeleteBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), failOnce: "dir/a.txt"}
+	filesystem := NewFilesystemWithBackend(backend)
+	if err := filesystem.Rename(ctx, "/dir", "/moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	if backend.renames != 0 {
+		t.Errorf("Expected copies plus batch delete, got %d per-object renames", backend.renames)
+	}
+	if len(backend.batches) != 2 || len(backend.batches[0]) != len(keys) {
+		t.Fatalf("Expected one full batch and one retry, got %v", backend.batches)
+	}
+	if len(backend.batches[1]) != 1 || backend.batches[1][0] != "dir/a.txt" {
+		t.Errorf("Expected retry of only the failed path, got %v", backend.batches[1])
+	}
+
+	if remaining, _ := client.ListObjects(ctx, "dir/"); len(remaining) != 0 {
+		t.Errorf("Old directory objects remain: %v", remaining)
+	}
+	if moved, _ := client.ListObjects(ctx, "moved/"); len(moved) != len(keys) {
+		t.Errorf("Expected %d moved objects, got %v", len(keys), moved)
+	}
+}
+
+// slowUploadBackend blocks uploads until released, signalling when one starts
+type slowUploadBackend struct {
+	types.Backend
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *slowUploadBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.Backend.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+func TestGetAttrDuringUpload(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	if err := client.PutObject(ctx, "slow.txt", []byte("hello")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	backend := &slowUploadBackend{Backend: newS3Adapter(client), started: make(chan struct{}), release: make(chan struct{})}
+	filesystem := NewFilesystemWithBackend(backend)
+
+	// Appending uploads immediately; the upload blocks in the backend
+	done := make(chan error)
+	go func() {
+		done <- filesystem.WriteFile(ctx, "slow.txt", []byte(" world"), 5)
+	}()
+	<-backend.started
+
+	during, err := filesystem.GetAttr(ctx, "slow.txt")
+	if err != nil {
+		t.Fatalf("GetAttr during upload failed: %v", err)
+	}
+	if during.Size != 11 {
+		t.Errorf("Expected size 11 during upload, got %d", during.Size)
+	}
+
+	close(backend.release)
+	if err := <-done; err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// Storage now reports the same attributes, however long after the upload we look
+	for _, wait := range []time.Duration{0, 100 * time.Millisecond} {
+		time.Sleep(wait)
+		after, err := filesystem.GetAttr(ctx, "slow.txt")
+		if err != nil {
+			t.Fatalf("GetAttr after upload failed: %v", err)
+		}
+		if after.Size != during.Size || !after.Mtime.Equal(during.Mtime) {
+			t.Errorf("After %v: got size %d mtime %v, during upload size %d mtime %v",
+				wait, after.Size, after.Mtime, during.Size, during.Mtime)
+		}
+	}
+}
+
+func TestMountOptionsTranslation(t *testing.T) {
+	base := len(MountOptions{}.fuseMountOptions())
+	all := MountOptions{AllowOther: true, DefaultPermissions: true, ReadOnly: true}
+	if got := len(all.fuseMountOptions()); got != base+3 {
+		t.Errorf("Expected %d mount options, got %d", base+3, got)
+	}
+	if got := len((MountOptions{AllowOther: true}).fuseMountOptions()); got != base+1 {
+		t.Errorf("Expected %d mount options, got %d", base+1, got)
+	}
+}
+
+// listingClient records the list options it is given
+type listingClient struct {
+	*s3client.MockClient
+	options *s3client.ListOptions
+}
+
+func (c *listingClient) SetListOptions(options s3client.ListOptions) {
+	c.options = &options
+}
+
+func TestConfigureClientListOptions(t *testing.T) {
+	client := &listingClient{MockClient: s3client.NewMockClient("test-bucket", "us-east-1")}
+	if err := configureClient(client, MountOptions{}); err != nil || client.options != nil {
+		t.Errorf("Default options set list options %v (err %v), want none", client.options, err)
+	}
+
+	options := MountOptions{ListNonCurrent: true, ListDeleteMarkers: true}
+	if err := configureClient(client, options); err != nil {
+		t.Fatalf("configureClient failed: %v", err)
+	}
+	want := s3client.ListOptions{IncludeNonCurrent: true, ShowDeleteMarkers: true}
+	if client.options == nil || *client.options != want {
+		t.Errorf("List options = %v, want %v", client.options, want)
+	}
+
+	// A client that can't list versions can't honor them
+	if err := configureClient(s3client.NewMockClient("test-bucket", "us-east-1"), options); err == nil {
+		t.Error("Expected an error for a client without version listing")
+	}
+}
+
+func TestMountMaxCachedPages(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem, err := newMountFilesystem(newS3Adapter(client), MountOptions{MaxCachedPages: 3})
+	if err != nil {
+		t.Fatalf("newMountFilesystem failed: %v", err)
+	}
+
+	fdCache := filesystem.cache.GetFdCache()
+	entity, err := fdCache.Open("file.bin", 8*cache.DefaultPageSize, time.Now())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	entity.LoadPages(make([]byte, 8*cache.DefaultPageSize))
+	cached := 0
+	for i := int64(0); i < 8; i++ {
+		if _, found := entity.ReadPage(i * cache.DefaultPageSize); found {
+			cached++
+		}
+	}
+	if cached != 3 {
+		t.Errorf("Entity caches %d pages, want the configured 3", cached)
+	}
+}
+
+func TestStatPrimesRead(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetStatPrimesRead(64 * 1024)
+	ctx := context.Background()
+
+	// Spans several pages to exercise multi-page cached reads
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	if err := client.PutObject(ctx, "small.txt", content); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if err := client.PutObject(ctx, "large.bin", make([]byte, 128*1024)); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	before := client.GetCount()
+	if _, err := filesystem.GetAttr(ctx, "small.txt"); err != nil {
+		t.Fatalf("Failed to get attributes: %v", err)
+	}
+	// Priming holds no reference, so a file that is only statted isn't pinned
+	if count := filesystem.cache.GetFdCache().GetOpenFdCount("small.txt"); count != 0 {
+		t.Errorf("Expected the primed file to have no open references, got %d", count)
+	}
+	data, err := filesystem.ReadFile(ctx, "small.txt", 0, 16384)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(data) != string(content) {
+		t.Errorf("Read returned %d bytes that don't match the object", len(data))
+	}
+	if gets := client.GetCount() - before; gets != 1 {
+		t.Errorf("Expected a single backend fetch for stat+read, got %d", gets)
+	}
+
+	// The primed copy is only served while the stat cache vouches for it
+	if err := client.PutObject(ctx, "changed.txt", []byte("old")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if _, err := filesystem.GetAttr(ctx, "changed.txt"); err != nil {
+		t.Fatalf("Failed to get attributes: %v", err)
+	}
+	if err := client.PutObject(ctx, "changed.txt", []byte("new")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	filesystem.cache.GetStatCache().Delete("changed.txt")
+	if data, err := filesystem.ReadFile(ctx, "changed.txt", 0, 0); err != nil || string(data) != "new" {
+		t.Errorf("Expected the new content, got %q (err %v)", data, err)
+	}
+
+	// Files above the threshold are not fetched by stat
+	before = client.GetCount()
+	if _, err := filesystem.GetAttr(ctx, "large.bin"); err != nil {
+		t.Fatalf("Failed to get attributes: %v", err)
+	}
+	if gets := client.GetCount() - before; gets != 0 {
+		t.Errorf("Expected no fetch when statting a large file, got %d", gets)
+	}
+}
+
+func TestReadFileReadAhead(t *testing.T) {
+	ctx := context.Background()
+	content := make([]byte, 8*1024*1024)
+	for i := range content {
+		content[i] = byte(i * 7 / 4096)
+	}
+
+	// Reads the file the way the kernel does for cat and returns the GETs it cost
+	readSequentially := func(t *testing.T, readAhead int64) int64 {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		if err := client.PutObject(ctx, "stream.bin", content); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+		filesystem := NewFilesystem(client)
+		filesystem.SetReadAheadSize(readAhead)
+
+		before := client.GetCount()
+		const chunk = 128 * 1024
+		for offset := 0; offset < len(content); offset += chunk {
+			data, err := filesystem.ReadFile(ctx, "stream.bin", int64(offset), chunk)
+			if err != nil {
+				t.Fatalf("ReadFile at %d failed: %v", offset, err)
+			}
+			if !bytes.Equal(data, content[offset:offset+chunk]) {
+				t.Fatalf("ReadFile at %d returned the wrong data", offset)
+			}
+		}
+		return client.GetCount() - before
+	}
+
+	without := readSequentially(t, 0)
+	if without != 64 {
+		t.Errorf("Expected one GET per read without readahead, got %d", without)
+	}
+	with := readSequentially(t, 2*1024*1024)
+	if with > 10 {
+		t.Errorf("Expected about one GET per MB with a 2MB readahead, got %d", with)
+	}
+	t.Logf("GETs for an 8MB sequential read: %d without readahead, %d with", without, with)
+
+	// Random reads are served as requested, without prefetching
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	if err := client.PutObject(ctx, "random.bin", content); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	filesystem := NewFilesystem(client)
+	filesystem.SetReadAheadSize(2 * 1024 * 1024)
+	before := client.GetCount()
+	for _, offset := range []int64{5 << 20, 1 << 20, 3 << 20} {
+		if _, err := filesystem.ReadFile(ctx, "random.bin", offset, 4096); err != nil {
+			t.Fatalf("ReadFile at %d failed: %v", offset, err)
+		}
+	}
+	if gets := client.GetCount() - before; gets != 3 {
+		t.Errorf("Expected 3 GETs for 3 random reads, got %d", gets)
+	}
+}
+
+func TestPageCacheMemoryLimit(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetAtimeMode(AtimeOff)
+	const limit = 256 * 1024
+	filesystem.SetPageCacheMemory(limit)
+	fdCache := filesystem.cache.GetFdCache()
+
+	// Four open files of twice the limit each, rewritten in place in 64KB
+	// chunks: well below maxDirtyData, so only the limit makes them upload
+	files := map[string][]byte{}
+	for i := 0; i < 4; i++ {
+		path := fmt.Sprintf("/file%d.bin", i)
+		files[path] = bytes.Repeat([]byte{byte(i)}, 2*limit)
+		writeAndFlush(t, filesystem, path, files[path])
+	}
+	const chunk = 64 * 1024
+	for offset := chunk; offset < 2*limit; offset += chunk {
+		for path, content := range files {
+			data := bytes.Repeat([]byte{byte(offset / chunk)}, chunk)
+			if err := filesystem.WriteFile(ctx, path, data, int64(offset)); err != nil {
+				t.Fatalf("WriteFile(%s, %d) failed: %v", path, offset, err)
+			}
+			copy(content[offset:], data)
+			if usage := fdCache.MemoryUsage(); usage > limit {
+				t.Fatalf("Page cache holds %d bytes after a write, limit %d", usage, limit)
+			}
+		}
+	}
+
+	// Nothing written was dropped with the evicted pages
+	for path, content := range files {
+		data, err := filesystem.ReadFile(ctx, path, 0, 0)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("ReadFile(%s) = %d bytes, %v; want the written content", path, len(data), err)
+		}
+		if err := filesystem.Flush(ctx, path); err != nil {
+			t.Fatalf("Flush(%s) failed: %v", path, err)
+		}
+		if stored, _ := client.GetObject(ctx, strings.TrimPrefix(path, "/")); !bytes.Equal(stored, content) {
+			t.Errorf("Object %s does not hold the written content", path)
+		}
+	}
+	if usage := fdCache.MemoryUsage(); usage > limit {
+		t.Errorf("Page cache holds %d bytes after reading back, limit %d", usage, limit)
+	}
+}
+
+func TestPageCacheMemoryLimitFlushesDirtiestFile(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetAtimeMode(AtimeOff)
+	const limit = 256 * 1024
+	filesystem.SetPageCacheMemory(limit)
+	fdCache := filesystem.cache.GetFdCache()
+
+	for _, path := range []string{"/big.bin", "/small.bin"} {
+		writeAndFlush(t, filesystem, path, make([]byte, 2*limit))
+	}
+	const chunk = 64 * 1024
+	for offset := chunk; offset <= 3*chunk; offset += chunk {
+		if err := filesystem.WriteFile(ctx, "/big.bin", bytes.Repeat([]byte{1}, chunk), int64(offset)); err != nil {
+			t.Fatalf("WriteFile(/big.bin, %d) failed: %v", offset, err)
+		}
+	}
+	big, _ := fdCache.Get("big.bin")
+	if big.BytesModified() != 3*chunk {
+		t.Fatalf("big.bin buffers %d bytes, want %d", big.BytesModified(), 3*chunk)
+	}
+
+	// The write that takes the cache over its limit uploads the file holding
+	// most of the dirty pages, not itself
+	if err := filesystem.WriteFile(ctx, "/small.bin", bytes.Repeat([]byte{2}, 2*chunk), chunk); err != nil {
+		t.Fatalf("WriteFile(/small.bin) failed: %v", err)
+	}
+	small, _ := fdCache.Get("small.bin")
+	if big.BytesModified() != 0 || small.BytesModified() != 2*chunk {
+		t.Errorf("big.bin buffers %d bytes and small.bin %d, want big.bin uploaded", big.BytesModified(), small.BytesModified())
+	}
+	if usage := fdCache.MemoryUsage(); usage > limit {
+		t.Errorf("Page cache holds %d bytes, limit %d", usage, limit)
+	}
+}
+
+// failingUploadBackend rejects every upload
+type failingUploadBackend struct {
+	types.Backend
+}
+
+func (b *failingUploadBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	return syscall.EIO
+}
+
+func TestReleaseLogsUploadFailure(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetOutput(&logs, slog.LevelWarn)
+	defer logging.SetOutput(io.Discard, logging.LevelOff)
+
+	filesystem := NewFilesystemWithBackend(&failingUploadBackend{Backend: newS3Adapter(s3client.NewMockClient("test-bucket", "us-east-1"))})
+	entity, err := filesystem.cache.GetFdCache().Open("lost.txt", 0, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to open cache entity: %v", err)
+	}
+	entity.WritePage(0, []byte("unsaved"))
+	entity.SetSize(7)
+
+	if err := filesystem.Release(context.Background(), "lost.txt"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if out := logs.String(); !strings.Contains(out, "level=ERROR") || !strings.Contains(out, "path=lost.txt") {
+		t.Errorf("Expected the lost upload to be logged, got %q", out)
+	}
+}
+
+// unreliableUploadBackend rejects uploads while failing is set
+type unreliableUploadBackend struct {
+	types.Backend
+	failing bool
+}
+
+func (b *unreliableUploadBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	if b.failing {
+		return syscall.EIO
+	}
+	return b.Backend.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// TestReleaseUploadRetried tests that data whose upload failed on release stays
+// cached and is uploaded by a later flush
+func TestReleaseUploadRetried(t *testing.T) {
+	ctx := context.Background()
+	retries := map[string]func(fs *Filesystem) error{
+		"Flush":    func(fs *Filesystem) error { return fs.Flush(ctx, "kept.txt") },
+		"Fsync":    func(fs *Filesystem) error { return fs.Fsync(ctx, "kept.txt", false) },
+		"FlushAll": func(fs *Filesystem) error { return fs.FlushAll(ctx) },
+	}
+	for name, retry := range retries {
+		t.Run(name, func(t *testing.T) {
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			backend := &unreliableUploadBackend{Backend: newS3Adapter(client), failing: true}
+			filesystem := NewFilesystemWithBackend(backend)
+			fdCache := filesystem.cache.GetFdCache()
+
+			entity, err := fdCache.Open("kept.txt", 0, time.Now())
+			if err != nil {
+				t.Fatalf("Failed to open cache entity: %v", err)
+			}
+			entity.WritePage(0, []byte("unsaved"))
+			entity.SetSize(7)
+
+			if err := filesystem.Release(ctx, "kept.txt"); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			if kept, found := fdCache.Get("kept.txt"); !found || kept.BytesModified() == 0 {
+				t.Fatal("Buffered data dropped after the failed upload")
+			}
+			if data, err := filesystem.ReadFile(ctx, "kept.txt", 0, 7); err != nil || string(data) != "unsaved" {
+				t.Errorf("ReadFile after failed release = %q, %v", data, err)
+			}
+
+			// Still failing: the retry reports the error and keeps the data
+			if err := retry(filesystem); err == nil {
+				t.Error("Retry succeeded while uploads still fail")
+			}
+			if _, found := fdCache.Get("kept.txt"); !found {
+				t.Fatal("Buffered data dropped after a failed retry")
+			}
+
+			backend.failing = false
+			if err := retry(filesystem); err != nil {
+				t.Fatalf("Retry failed: %v", err)
+			}
+			if data, err := client.GetObject(ctx, "kept.txt"); err != nil || string(data) != "unsaved" {
+				t.Errorf("Uploaded object = %q, %v", data, err)
+			}
+			if _, found := fdCache.Get("kept.txt"); found {
+				t.Error("Entity still cached after the retried upload")
+			}
+		})
+	}
+}
+
+// outageBackend fails every call with a refused connection while down is set
+type outageBackend struct {
+	types.Backend
+	down bool
+}
+
+func (b *outageBackend) err() error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+}
+
+func (b *outageBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	if b.down {
+		return nil, b.err()
+	}
+	return b.Backend.GetAttr(ctx, path)
+}
+
+func (b *outageBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	if b.down {
+		return nil, b.err()
+	}
+	return b.Backend.ReadRange(ctx, path, start, end)
+}
+
+func (b *outageBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	if b.down {
+		return nil, b.err()
+	}
+	return b.Backend.List(ctx, prefix)
+}
+
+func (b *outageBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	if b.down {
+		return b.err()
+	}
+	return b.Backend.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// uncheckedBackend hides the optional interfaces of the backend it wraps,
+// such as conditional writes
+type uncheckedBackend struct {
+	types.Backend
+}
+
+func TestCreateConcurrent(t *testing.T) {
+	const creators = 16
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name    string
+		backend func(client *s3client.MockClient) types.Backend
+	}{
+		{"conditional write", func(client *s3client.MockClient) types.Backend { return newS3Adapter(client) }},
+		{"check under lock", func(client *s3client.MockClient) types.Backend {
+			return uncheckedBackend{newS3Adapter(client)}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			filesystem := NewFilesystemWithBackend(tc.backend(client))
+
+			start := make(chan struct{})
+			errs := make(chan error, creators)
+			for i := 0; i < creators; i++ {
+				go func() {
+					<-start
+					errs <- filesystem.Create(ctx, "/race.txt", 0644)
+				}()
+			}
+			close(start)
+
+			created := 0
+			for i := 0; i < creators; i++ {
+				switch err := <-errs; err {
+				case nil:
+					created++
+				case syscall.EEXIST:
+				default:
+					t.Errorf("Create: expected nil or EEXIST, got %v", err)
+				}
+			}
+			if created != 1 {
+				t.Errorf("Expected exactly one Create to succeed, got %d", created)
+			}
+		})
+	}
+
+	// A new file costs no HEAD when the write itself is conditional
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.GetAttr(ctx, "/")
+	heads := client.HeadCount()
+	if err := filesystem.Create(ctx, "/new.txt", 0644); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if n := client.HeadCount() - heads; n != 0 {
+		t.Errorf("Create of a new file made %d HEAD requests, want 0", n)
+	}
+}
+
+func TestCreateExistingFile(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "file.txt", []byte("written elsewhere"))
+	root := &Dir{filesystem: NewFilesystem(client), path: "/"}
+	create := func(flags fuse.OpenFlags) error {
+		_, _, err := root.Create(ctx, &fuse.CreateRequest{Name: "file.txt", Flags: flags, Mode: 0644}, &fuse.CreateResponse{})
+		return err
+	}
+
+	if err := create(fuse.OpenWriteOnly | fuse.OpenExclusive); err != syscall.EEXIST {
+		t.Errorf("Create with O_EXCL = %v, want EEXIST", err)
+	}
+
+	// Without O_EXCL the file created by another client is opened
+	if err := create(fuse.OpenWriteOnly); err != nil {
+		t.Errorf("Create without O_EXCL failed: %v", err)
+	}
+	if data, _ := client.GetObject(ctx, "file.txt"); string(data) != "written elsewhere" {
+		t.Errorf("Create without O_TRUNC changed the file to %q", data)
+	}
+	if err := create(fuse.OpenWriteOnly | fuse.OpenTruncate); err != nil {
+		t.Errorf("Create with O_TRUNC failed: %v", err)
+	}
+	if data, _ := client.GetObject(ctx, "file.txt"); len(data) != 0 {
+		t.Errorf("Create with O_TRUNC left %q", data)
+	}
+}
+
+func TestCreateOverDirectory(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := filesystem.Mkdir(ctx, "/d", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	client.PutObject(ctx, "implicit/child.txt", []byte("data"))
+
+	for _, path := range []string{"/d", "/implicit"} {
+		if err := filesystem.Create(ctx, path, 0644); err != syscall.EEXIST {
+			t.Errorf("Create(%s) over a directory = %v, want EEXIST", path, err)
+		}
+		attr, err := filesystem.GetAttr(ctx, path)
+		if err != nil || !attr.Mode.IsDir() {
+			t.Errorf("%s after Create: attr %+v (err %v), want a directory", path, attr, err)
+		}
+	}
+	if _, err := client.HeadObject(ctx, "d"); err == nil {
+		t.Error("Create over a directory stored a file object")
+	}
+}
+
+func TestLookupReportsUnansweredRequests(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	client.PutObject(context.Background(), "file.txt", []byte("data"))
+	backend := &outageBackend{Backend: newS3Adapter(client)}
+	root := &Dir{filesystem: NewFilesystemWithBackend(backend), path: "/"}
+	lookup := func(ctx context.Context) error {
+		_, err := root.Lookup(ctx, &fuse.LookupRequest{Name: "file.txt"}, &fuse.LookupResponse{})
+		return err
+	}
+
+	// An unreachable or timed out backend is an I/O error, not a missing file
+	backend.down = true
+	if err := lookup(context.Background()); err != syscall.EIO {
+		t.Errorf("Lookup during outage = %v, want EIO", err)
+	}
+
+	// An interrupted lookup reports EINTR so the caller can retry it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lookup(ctx); err != syscall.EINTR {
+		t.Errorf("Interrupted lookup = %v, want EINTR", err)
+	}
+
+	backend.down = false
+	if err := lookup(context.Background()); err != nil {
+		t.Errorf("Lookup after outage failed: %v", err)
+	}
+}
+
+// erroringBackend fails reads and writes with err while it is set
+type erroringBackend struct {
+	types.Backend
+	err error
+}
+
+func (b *erroringBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.Backend.ReadRange(ctx, path, start, end)
+}
+
+func (b *erroringBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.Backend.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+func TestOperationErrnos(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	client.PutObject(ctx, "file.txt", []byte("data"))
+	client.PutObject(ctx, "unmarked/child.txt", []byte("data"))
+	backend := &erroringBackend{Backend: newS3Adapter(client)}
+	filesystem := NewFilesystemWithBackend(backend)
+	root := &Dir{filesystem: filesystem, path: "/"}
+	file := &File{filesystem: filesystem, path: "/file.txt"}
+	missing := &File{filesystem: filesystem, path: "/missing.txt"}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := root.Lookup(ctx, &fuse.LookupRequest{Name: "missing.txt"}, &fuse.LookupResponse{}); err != syscall.ENOENT {
+			t.Errorf("Lookup = %v, want ENOENT", err)
+		}
+		if err := root.Remove(ctx, &fuse.RemoveRequest{Name: "missing.txt"}); err != syscall.ENOENT {
+			t.Errorf("Remove = %v, want ENOENT", err)
+		}
+		if err := missing.Read(ctx, &fuse.ReadRequest{Size: 4}, &fuse.ReadResponse{}); err != syscall.ENOENT {
+			t.Errorf("Read = %v, want ENOENT", err)
+		}
+		if err := missing.Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.tag"}, &fuse.GetxattrResponse{}); err != syscall.ENOENT {
+			t.Errorf("Getxattr = %v, want ENOENT", err)
+		}
+		if err := missing.Listxattr(ctx, &fuse.ListxattrRequest{}, &fuse.ListxattrResponse{}); err != syscall.ENOENT {
+			t.Errorf("Listxattr = %v, want ENOENT", err)
+		}
+	})
+
+	t.Run("missing attribute", func(t *testing.T) {
+		if err := file.Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.tag"}, &fuse.GetxattrResponse{}); err != syscall.ENODATA {
+			t.Errorf("Getxattr = %v, want ENODATA", err)
+		}
+		if err := file.Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.tag"}); err != syscall.ENODATA {
+			t.Errorf("Removexattr = %v, want ENODATA", err)
+		}
+		unmarked := &Dir{filesystem: filesystem, path: "/unmarked"}
+		if err := unmarked.Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.tag"}, &fuse.GetxattrResponse{}); err != syscall.ENODATA {
+			t.Errorf("Getxattr of a directory without marker = %v, want ENODATA", err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want syscall.Errno
+	}{
+		{"access denied", &smithy.GenericAPIError{Code: "AccessDenied"}, syscall.EACCES},
+		{"throttled", &smithy.GenericAPIError{Code: "SlowDown"}, syscall.EAGAIN},
+		{"quota exceeded", &smithy.GenericAPIError{Code: "QuotaExceeded"}, syscall.ENOSPC},
+		{"request timeout", &smithy.GenericAPIError{Code: "RequestTimeout"}, syscall.ETIMEDOUT},
+		{"unreachable", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, syscall.EIO},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend.err = fmt.Errorf("operation error S3: %w", tc.err)
+			defer func() { backend.err = nil }()
+			filesystem.cache.GetFdCache().Close("file.txt")
+
+			if err := file.Read(ctx, &fuse.ReadRequest{Size: 4}, &fuse.ReadResponse{}); err != tc.want {
+				t.Errorf("Read = %v, want %v", err, tc.want)
+			}
+			// A write replacing the file is uploaded right away
+			writer := &File{filesystem: filesystem, path: "/" + strings.ReplaceAll(tc.name, " ", "-")}
+			if err := writer.Write(ctx, &fuse.WriteRequest{Data: []byte("new")}, &fuse.WriteResponse{}); err != tc.want {
+				t.Errorf("Write = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestServeStaleOnError(t *testing.T) {
+	ctx := context.Background()
+	for _, serveStale := range []bool{true, false} {
+		t.Run(fmt.Sprintf("serveStale=%v", serveStale), func(t *testing.T) {
+			var logs bytes.Buffer
+			logging.SetOutput(&logs, slog.LevelWarn)
+			defer logging.SetOutput(io.Discard, logging.LevelOff)
+
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			client.PutObject(ctx, "cached.txt", []byte("cached content"))
+			client.PutObject(ctx, "uncached.txt", []byte("never read"))
+			backend := &outageBackend{Backend: newS3Adapter(client)}
+			filesystem := NewFilesystemWithBackend(backend)
+			filesystem.cache = cache.NewManager(100, 20*time.Millisecond, 100, 10, 4096)
+			filesystem.SetServeStaleOnError(serveStale)
+
+			if _, err := filesystem.GetAttr(ctx, "cached.txt"); err != nil {
+				t.Fatalf("GetAttr failed: %v", err)
+			}
+			if _, err := filesystem.ReadFile(ctx, "cached.txt", 0, 0); err != nil {
+				t.Fatalf("ReadFile failed: %v", err)
+			}
+			filesystem.ReleaseReadOnly(ctx, "cached.txt")
+			time.Sleep(60 * time.Millisecond) // Let the stat cache entry expire
+
+			backend.down = true
+			attr, err := filesystem.GetAttr(ctx, "cached.txt")
+			data, readErr := filesystem.ReadFile(ctx, "cached.txt", 0, 0)
+			if serveStale {
+				if err != nil || attr.Size != int64(len("cached content")) {
+					t.Errorf("GetAttr during outage = %+v, %v; want the cached attributes", attr, err)
+				}
+				if readErr != nil || string(data) != "cached content" {
+					t.Errorf("ReadFile during outage = %q, %v; want the cached content", data, readErr)
+				}
+				if out := logs.String(); !strings.Contains(out, "level=WARN") || !strings.Contains(out, "stale") {
+					t.Errorf("Expected stale reads to be logged, got %q", out)
+				}
+			} else {
+				if !errors.Is(err, syscall.EIO) || !errors.Is(readErr, syscall.EIO) {
+					t.Errorf("Expected EIO without -serve_stale_on_error, got %v and %v", err, readErr)
+				}
+			}
+
+			// Nothing cached: the outage is reported as EIO, not as a missing file
+			if _, err := filesystem.GetAttr(ctx, "uncached.txt"); !errors.Is(err, syscall.EIO) {
+				t.Errorf("GetAttr of uncached file = %v, want EIO", err)
+			}
+			if _, err := filesystem.ReadFile(ctx, "uncached.txt", 0, 0); !errors.Is(err, syscall.EIO) {
+				t.Errorf("ReadFile of uncached file = %v, want EIO", err)
+			}
+
+			// Writes still fail
+			if err := filesystem.Create(ctx, "new.txt", 0644); err == nil {
+				t.Error("Create succeeded during the outage")
+			}
+
+			backend.down = false
+			if _, err := filesystem.GetAttr(ctx, "uncached.txt"); err != nil {
+				t.Errorf("GetAttr after the outage failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3AdapterBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) types.Backend {
+		return newS3Adapter(s3client.NewMockClient("test-bucket", "us-east-1"))
+	})
+}
+
+// TestTruncate tests shrinking and growing files through Truncate and Setattr
+func TestTruncate(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.cache = cache.NewManager(100, time.Minute, 100, 10, 4096)
+
+	if err := filesystem.WriteFile(ctx, "trunc.txt", []byte("hello world"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	// Drop the cached copy so the kept bytes have to come from storage
+	filesystem.cache = cache.NewManager(100, time.Minute, 100, 10, 4096)
+
+	if err := filesystem.Truncate(ctx, "trunc.txt", 5); err != nil {
+		t.Fatalf("Truncate to 5 failed: %v", err)
+	}
+	if data, _ := client.GetObject(ctx, "trunc.txt"); string(data) != "hello" {
+		t.Errorf("Stored data after shrinking = %q, want %q", data, "hello")
+	}
+
+	file := &File{filesystem: filesystem, path: "trunc.txt"}
+	resp := &fuse.SetattrResponse{}
+	if err := file.Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 8}, resp); err != nil {
+		t.Fatalf("Setattr size 8 failed: %v", err)
+	}
+	if resp.Attr.Size != 8 {
+		t.Errorf("Setattr response size = %d, want 8", resp.Attr.Size)
+	}
+	if data, _ := client.GetObject(ctx, "trunc.txt"); string(data) != "hello\x00\x00\x00" {
+		t.Errorf("Stored data after growing = %q, want %q", data, "hello\x00\x00\x00")
+	}
+	if data, err := filesystem.ReadFile(ctx, "trunc.txt", 0, 8); err != nil || string(data) != "hello\x00\x00\x00" {
+		t.Errorf("ReadFile after growing = %q, %v", data, err)
+	}
+
+	if err := filesystem.Truncate(ctx, "trunc.txt", 0); err != nil {
+		t.Fatalf("Truncate to 0 failed: %v", err)
+	}
+	if attr, err := filesystem.GetAttr(ctx, "trunc.txt"); err != nil || attr.Size != 0 {
+		t.Errorf("GetAttr after truncating to 0 = %+v, %v", attr, err)
+	}
+	if err := filesystem.Truncate(ctx, "missing.txt", 0); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Truncate of missing file: got %v, want ENOENT", err)
+	}
+}
+
+// TestWriteFileConcurrentRanges tests that writers extending the same file at
+// disjoint ranges never shrink each other's extensions or lose data
+func TestWriteFileConcurrentRanges(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+
+	const writers, chunk, piece = 8, 5000, 1000
+	expected := make([]byte, (writers+1)*chunk)
+	for i := range expected {
+		expected[i] = byte('a' + i/chunk)
+	}
+	if err := filesystem.WriteFile(ctx, "ranges.bin", expected[:chunk], 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 1; w <= writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Back to front, so every piece but the first extends the file
+			for off := (w+1)*chunk - piece; off >= w*chunk; off -= piece {
+				if err := filesystem.WriteFile(ctx, "ranges.bin", expected[off:off+piece], int64(off)); err != nil {
+					t.Errorf("WriteFile at %d failed: %v", off, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	attr, err := filesystem.GetAttr(ctx, "ranges.bin")
+	if err != nil || attr.Size != int64(len(expected)) {
+		t.Fatalf("GetAttr = %+v (err %v), want size %d", attr, err, len(expected))
+	}
+	if err := filesystem.Flush(ctx, "ranges.bin"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	data, err := client.GetObject(ctx, "ranges.bin")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if !bytes.Equal(data, expected) {
+		for i := range expected {
+			if i >= len(data) || data[i] != expected[i] {
+				t.Fatalf("Stored %d bytes, first difference at offset %d (want %d)", len(data), i, len(expected))
+			}
+		}
+		t.Fatalf("Stored %d bytes, want %d", len(data), len(expected))
+	}
+}
+
+// TestReadDirPaged tests that a huge directory is listed a page at a time
+func TestReadDirPaged(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	const files = 10000
+	for i := 0; i < files; i++ {
+		if err := client.PutObject(ctx, fmt.Sprintf("huge/file-%05d", i), nil); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+	client.PutObject(ctx, "huge/sub/deep.txt", nil)
+	filesystem := NewFilesystem(client)
+
+	lists, pages := client.ListCount(), client.PageCount()
+	entries, err := filesystem.ReadDir(ctx, "huge")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != files+1 {
+		t.Errorf("ReadDir returned %d entries, want %d", len(entries), files+1)
+	}
+	if n := client.ListCount() - lists; n != 0 {
+		t.Errorf("ReadDir listed the whole prefix %d times", n)
+	}
+	if n := client.PageCount() - pages; n < files/listPageSize {
+		t.Errorf("ReadDir listed %d pages, want pages of at most %d entries", n, listPageSize)
+	}
+}
+
+// TestAppendFileConcurrent tests that concurrent appenders never overwrite each other
+func TestAppendFileConcurrent(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	if err := NewFilesystem(client).WriteFile(ctx, "app.log", []byte("header\n"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// A fresh filesystem, so the existing content is not in its cache
+	filesystem := NewFilesystem(client)
+	const writers, lines = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				if _, err := filesystem.AppendFile(ctx, "app.log", []byte(fmt.Sprintf("writer %d line %03d\n", w, i))); err != nil {
+					t.Errorf("AppendFile failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// A handle opened with O_APPEND ignores the (stale) offset the kernel sends
+	handle, err := (&File{filesystem: filesystem, path: "app.log"}).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	req := &fuse.WriteRequest{Data: []byte("footer\n"), Offset: 0}
+	if err := handle.(*File).Write(ctx, req, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if err := filesystem.Flush(ctx, "app.log"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	data, err := client.GetObject(ctx, "app.log")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(got) != writers*lines+2 || got[0] != "header" || got[len(got)-1] != "footer" {
+		t.Fatalf("Stored %d lines (first %q, last %q), want %d between header and footer", len(got), got[0], got[len(got)-1], writers*lines)
+	}
+	seen := make(map[string]bool, len(got))
+	for _, line := range got {
+		seen[line] = true
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < lines; i++ {
+			if line := fmt.Sprintf("writer %d line %03d", w, i); !seen[line] {
+				t.Errorf("Line %q was lost", line)
+			}
+		}
+	}
+}
+
+// TestTruncateBufferedOnly tests truncating a file whose data was never uploaded
+func TestTruncateBufferedOnly(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	backend := &unreliableUploadBackend{Backend: newS3Adapter(client), failing: true}
+	filesystem := NewFilesystemWithBackend(backend)
+	filesystem.cache = cache.NewManager(100, time.Minute, 100, 10, 4096)
+
+	entity, err := filesystem.cache.GetFdCache().Open("pending.txt", 0, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to open cache entity: %v", err)
+	}
+	entity.WritePage(0, []byte("buffered data"))
+	entity.SetSize(13)
+
+	// The upload still fails, but the truncation must stick in the buffer
+	if err := filesystem.Truncate(ctx, "pending.txt", 8); err == nil {
+		t.Error("Truncate reported success while uploads fail")
+	}
+	if data, err := filesystem.ReadFile(ctx, "pending.txt", 0, 13); err != nil || string(data) != "buffered" {
+		t.Errorf("ReadFile after truncate = %q, %v; want %q", data, err, "buffered")
+	}
+
+	backend.failing = false
+	if err := filesystem.Truncate(ctx, "pending.txt", 10); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if data, _ := client.GetObject(ctx, "pending.txt"); string(data) != "buffered\x00\x00" {
+		t.Errorf("Stored data = %q, want %q", data, "buffered\x00\x00")
+	}
+}
+
+// editLargeFile writes a small change into the middle of a 20MB file and
+// returns the bytes uploaded to store it
+func editLargeFile(tb testing.TB, client *s3client.MockClient, filesystem *Filesystem, original []byte) int64 {
+	ctx := context.Background()
+	client.PutObject(ctx, "large.bin", original)
+	before := client.UploadedBytes()
+	if err := filesystem.WriteFile(ctx, "large.bin", []byte("edited"), 12*1024*1024+3); err != nil {
+		tb.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := filesystem.Release(ctx, "large.bin"); err != nil {
+		tb.Fatalf("Release failed: %v", err)
+	}
+	return client.UploadedBytes() - before
+}
+
+func TestWriteFileSmallEditOfLargeFile(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	original := bytes.Repeat([]byte("0123456789abcdef"), 20*1024*1024/16)
+
+	uploaded := editLargeFile(t, client, filesystem, original)
+	if uploaded != s3client.DefaultPartSize {
+		t.Errorf("Uploaded %d bytes for a 6 byte edit, want one %d byte part", uploaded, s3client.DefaultPartSize)
+	}
+	want := append([]byte{}, original...)
+	copy(want[12*1024*1024+3:], "edited")
+	if data, _ := client.GetObject(context.Background(), "large.bin"); !bytes.Equal(data, want) {
+		t.Error("Stored content differs from the edited file")
+	}
+	if data, err := filesystem.ReadFile(context.Background(), "large.bin", 12*1024*1024, 16); err != nil || string(data) != "012edited9abcdef" {
+		t.Errorf("ReadFile after the edit = %q, %v", data, err)
+	}
+}
+
+// BenchmarkSmallEditUpload reports the bytes uploaded per 6 byte edit of a 20MB
+// file, with and without rewriting only the changed part
+func BenchmarkSmallEditUpload(b *testing.B) {
+	original := bytes.Repeat([]byte("0123456789abcdef"), 20*1024*1024/16)
+	for _, bm := range []struct {
+		name    string
+		backend func(types.Backend) types.Backend
+	}{
+		{"whole", func(backend types.Backend) types.Backend { return struct{ types.Backend }{backend} }},
+		{"patch", func(backend types.Backend) types.Backend { return backend }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			filesystem := NewFilesystemWithBackend(bm.backend(newS3Adapter(client)))
+			var uploaded int64
+			for i := 0; i < b.N; i++ {
+				uploaded += editLargeFile(b, client, filesystem, original)
+			}
+			b.ReportMetric(float64(uploaded)/float64(b.N), "uploaded-B/op")
+		})
+	}
+}
+
+// largestReadBackend records the most bytes one backend read returned, and
+// fails the test on whole-file reads
+type largestReadBackend struct {
+	*s3Adapter
+	t       *testing.T
+	mu      sync.Mutex
+	largest int
+}
+
+func (b *largestReadBackend) Read(ctx context.Context, path string) ([]byte, error) {
+	b.t.Errorf("Read(%q) downloads the whole file at once", path)
+	return b.s3Adapter.Read(ctx, path)
+}
+
+func (b *largestReadBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	data, err := b.s3Adapter.ReadRange(ctx, path, start, end)
+	b.record(data)
+	return data, err
+}
+
+func (b *largestReadBackend) ReadRangeIfMatch(ctx context.Context, path string, start, end int64, etag string) ([]byte, error) {
+	data, err := b.s3Adapter.ReadRangeIfMatch(ctx, path, start, end, etag)
+	b.record(data)
+	return data, err
+}
+
+func (b *largestReadBackend) record(data []byte) {
+	b.mu.Lock()
+	b.largest = max(b.largest, len(data))
+	b.mu.Unlock()
+}
+
+func TestReadFileToEndIsBounded(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	content := make([]byte, 5*1024*1024+1234)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	client.PutObject(ctx, "large.bin", content)
+	backend := &largestReadBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), t: t}
+	filesystem := NewFilesystemWithBackend(backend)
+	const limit = 1024 * 1024
+	filesystem.SetMaxReadSize(limit)
+
+	// Reading to the end fails while more than the limit remains, rather than
+	// returning a file cut short; the caller reads in ranges instead
+	if _, err := filesystem.ReadFile(ctx, "/large.bin", 0, 0); !errors.Is(err, syscall.EFBIG) {
+		t.Fatalf("ReadFile to the end of %d bytes = %v, want EFBIG", len(content), err)
+	}
+	var read []byte
+	for len(read) < len(content) {
+		chunk, err := filesystem.ReadFile(ctx, "/large.bin", int64(len(read)), limit)
+		if err != nil || len(chunk) == 0 {
+			t.Fatalf("ReadFile at %d = %d bytes, %v", len(read), len(chunk), err)
+		}
+		read = append(read, chunk...)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("Read %d bytes that differ from the %d stored", len(read), len(content))
+	}
+	// Within the limit of the end, reading to it works
+	tail, err := filesystem.ReadFile(ctx, "/large.bin", int64(len(content)-limit), 0)
+	if err != nil || !bytes.Equal(tail, content[len(content)-limit:]) {
+		t.Errorf("ReadFile of the last %d bytes = %d bytes, %v", limit, len(tail), err)
+	}
+	if backend.largest > limit+1 {
+		t.Errorf("Largest backend read was %d bytes, want at most %d", backend.largest, limit+1)
+	}
+	// The entity was sized from the file, not from the first capped read
+	if attr, err := filesystem.GetAttr(ctx, "/large.bin"); err != nil || attr.Size != int64(len(content)) {
+		t.Errorf("GetAttr = %+v, %v; want size %d", attr, err, len(content))
+	}
+}
+
+func TestFilesystemWithEncryption(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{7}, 32)
+	filesystem, err := NewFilesystemWithEncryption(newS3Adapter(client), key)
+	if err != nil {
+		t.Fatalf("NewFilesystemWithEncryption failed: %v", err)
+	}
+
+	content := []byte(strings.Repeat("confidential ", 10000))
+	writeAndFlush(t, filesystem, "/secret.txt", content)
+	stored, err := client.GetObject(ctx, "secret.txt")
+	if err != nil || bytes.Contains(stored, []byte("confidential")) {
+		t.Fatalf("Stored object holds the plaintext (%v)", err)
+	}
+
+	// Read back through a fresh mount with the key
+	reader, _ := NewFilesystemWithEncryption(newS3Adapter(client), key)
+	if attr, err := reader.GetAttr(ctx, "/secret.txt"); err != nil || attr.Size != int64(len(content)) {
+		t.Errorf("GetAttr = %+v, %v; want size %d", attr, err, len(content))
+	}
+	data, err := reader.ReadFile(ctx, "/secret.txt", 13, 12)
+	if err != nil || string(data) != "confidential" {
+		t.Errorf("ReadFile = %q, %v; want %q", data, err, "confidential")
+	}
+
+	if _, err := NewFilesystemWithEncryption(newS3Adapter(client), key[:16]); err == nil {
+		t.Error("NewFilesystemWithEncryption accepted a 16-byte key")
+	}
+}
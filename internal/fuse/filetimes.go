@@ -3,12 +3,20 @@ package fuse
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 // Utimens sets file access and modification times
+// A zero atime or mtime keeps the one stored, so either can be set alone
 func (fs *Filesystem) Utimens(ctx context.Context, path string, atime, mtime time.Time) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
 	// Flush buffered data before updating metadata
 	if err := fs.flushBufferedData(ctx, path); err != nil {
 		return fmt.Errorf("failed to flush buffered data before utimens: %w", err)
@@ -33,33 +41,37 @@ func (fs *Filesystem) Utimens(ctx context.Context, path string, atime, mtime tim
 	}
 
 	var metadata map[string]string
+	var keepPath string
+	var fileAttr *types.Attr
 	if isDir {
-		// For directories, check for .keep marker
-		keepPath := normalizedPath + ".keep"
-		keepAttr, err := backend.GetAttr(ctx, keepPath)
+		// For directories, check for a directory marker
+		var keepAttr *types.Attr
+		keepPath, keepAttr, err = fs.dirMarker(ctx, backend, normalizedPath)
 		if err != nil {
 			// No marker, create new metadata
 			metadata = make(map[string]string)
 		} else {
 			// Convert attributes to metadata map
 			metadata = make(map[string]string)
-			metadata["mode"] = fmt.Sprintf("%o", keepAttr.Mode)
+			metadata["mode"] = types.EncodeMode(os.ModeDir | os.FileMode(keepAttr.Mode))
 			metadata["uid"] = fmt.Sprintf("%d", keepAttr.Uid)
 			metadata["gid"] = fmt.Sprintf("%d", keepAttr.Gid)
 			metadata["mtime"] = fmt.Sprintf("%d", keepAttr.Mtime.Unix())
+			metadata["atime"] = fmt.Sprintf("%d", atimeOf(keepAttr).Unix())
 		}
 	} else {
 		// For files, get current attributes
-		fileAttr, err := backend.GetAttr(ctx, normalizedPath)
+		fileAttr, err = backend.GetAttr(ctx, normalizedPath)
 		if err != nil {
 			return fmt.Errorf("failed to get object metadata: %w", err)
 		}
 		// Convert attributes to metadata map
 		metadata = make(map[string]string)
-		metadata["mode"] = fmt.Sprintf("%o", fileAttr.Mode)
+		metadata["mode"] = types.EncodeMode(os.FileMode(fileAttr.Mode))
 		metadata["uid"] = fmt.Sprintf("%d", fileAttr.Uid)
 		metadata["gid"] = fmt.Sprintf("%d", fileAttr.Gid)
 		metadata["mtime"] = fmt.Sprintf("%d", fileAttr.Mtime.Unix())
+		metadata["atime"] = fmt.Sprintf("%d", atimeOf(fileAttr).Unix())
 	}
 
 	// HeadObject returns metadata keys WITHOUT "x-amz-meta-" prefix (AWS SDK strips it)
@@ -68,6 +80,10 @@ func (fs *Filesystem) Utimens(ctx context.Context, path string, atime, mtime tim
 	// Ensure mtime is actually updated (not before or equal to current mtime)
 	// Always ensure mtime is at least 1 second after current time to guarantee update
 	now := time.Now()
+	keepMtime := mtime.IsZero()
+	if keepMtime {
+		mtime = now
+	}
 	currentMtime := mtime
 	// Check mtime in metadata (HeadObject returns keys without prefix)
 	currentMtimeStr := metadata["mtime"]
@@ -95,30 +111,33 @@ func (fs *Filesystem) Utimens(ctx context.Context, path string, atime, mtime tim
 		// If no mtime in metadata, use the passed mtime
 		currentMtime = mtime
 	}
-	metadata["x-amz-meta-atime"] = fmt.Sprintf("%d", atime.Unix())
+	// Setting only the access time keeps the stored mtime as it is
+	var storedMtime int64
+	if _, err := fmt.Sscanf(currentMtimeStr, "%d", &storedMtime); err == nil && keepMtime {
+		currentMtime = time.Unix(storedMtime, 0)
+	}
+	if !atime.IsZero() {
+		metadata["atime"] = fmt.Sprintf("%d", atime.Unix())
+	}
+	if storedAtime, ok := metadata["atime"]; ok {
+		metadata["x-amz-meta-atime"] = storedAtime
+	}
 	metadata["x-amz-meta-mtime"] = fmt.Sprintf("%d", currentMtime.Unix())
 	metadata["x-amz-meta-ctime"] = fmt.Sprintf("%d", now.Unix())
 	// Also set without prefix for consistency
-	metadata["atime"] = fmt.Sprintf("%d", atime.Unix())
 	metadata["mtime"] = fmt.Sprintf("%d", currentMtime.Unix())
 	metadata["ctime"] = fmt.Sprintf("%d", now.Unix())
 
-	// Update metadata using WriteWithMetadata
+	// Update metadata
 	if isDir {
-		// Directory - update .keep marker with metadata
-		keepPath := normalizedPath + ".keep"
-		err = backend.WriteWithMetadata(ctx, keepPath, []byte{}, metadata)
+		// Directory - update its marker with metadata
+		err = writeDirMarker(ctx, backend, keepPath, metadata)
 		if err != nil {
 			return fmt.Errorf("failed to set times on directory: %w", err)
 		}
 	} else {
-		// File - read existing data (or use empty if file doesn't exist), then write back with new metadata
-		existingData, err := backend.Read(ctx, normalizedPath)
-		if err != nil {
-			// File doesn't exist - create empty file with metadata
-			existingData = []byte{}
-		}
-		err = backend.WriteWithMetadata(ctx, normalizedPath, existingData, metadata)
+		// File - replace metadata in place; touching a large file must not re-upload it
+		err = fs.updateMetadata(ctx, backend, normalizedPath, fileAttr, metadata)
 		if err != nil {
 			return fmt.Errorf("failed to set times: %w", err)
 		}
@@ -129,7 +148,7 @@ func (fs *Filesystem) Utimens(ctx context.Context, path string, atime, mtime tim
 		fs.cache.GetStatCache().Delete(path)
 		// Update entity mtime if entity exists in FD cache
 		fdCache := fs.cache.GetFdCache()
-		if entity, found := fdCache.Get(normalizedPath); found {
+		if entity, found := fdCache.Get(normalizedPath); found && !keepMtime {
 			entity.SetMtime(mtime)
 		}
 	}
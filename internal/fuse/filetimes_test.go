@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
 )
 
@@ -82,7 +84,7 @@ func TestUpdateTimeChmod(t *testing.T) {
 		t.Fatalf("Failed to get initial attributes: %v", err)
 	}
 
-	initialCtime := attr1.Mtime
+	initialCtime := attr1.Ctime
 
 	// Chmod should update ctime
 	err = fs.Chmod(ctx, testFile, os.FileMode(0777))
@@ -97,13 +99,49 @@ func TestUpdateTimeChmod(t *testing.T) {
 	}
 
 	// Ctime should be updated
-	if attr2.Mtime.Before(initialCtime) {
+	if attr2.Ctime.Before(initialCtime) {
 		t.Error("Ctime should be updated after chmod")
 	}
 
 	fs.Remove(ctx, testFile)
 }
 
+// TestChmodPreservesMtime tests that chmod advances ctime without touching mtime
+func TestChmodPreservesMtime(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	testFile := "test-chmod-mtime.txt"
+	if err := fs.WriteFile(ctx, testFile, []byte("HELLO WORLD"), 0); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	attr1, err := fs.GetAttr(ctx, testFile)
+	if err != nil {
+		t.Fatalf("Failed to get initial attributes: %v", err)
+	}
+
+	if err := fs.Chmod(ctx, testFile, os.FileMode(0600)); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+
+	attr2, err := fs.GetAttr(ctx, testFile)
+	if err != nil {
+		t.Fatalf("Failed to get attributes after chmod: %v", err)
+	}
+
+	if attr2.Mode.Perm() != 0600 {
+		t.Errorf("Expected mode 0600 after chmod, got %o", attr2.Mode.Perm())
+	}
+	if attr2.Mtime.Unix() != attr1.Mtime.Unix() {
+		t.Errorf("Mtime should be unchanged by chmod: before %v, after %v", attr1.Mtime, attr2.Mtime)
+	}
+	if !attr2.Ctime.After(attr1.Ctime) {
+		t.Errorf("Ctime should advance after chmod: before %v, after %v", attr1.Ctime, attr2.Ctime)
+	}
+}
+
 // TestUpdateTimeChown tests that chown updates ctime
 func TestUpdateTimeChown(t *testing.T) {
 	client := s3client.NewMockClient("test-bucket", "us-east-1")
@@ -125,7 +163,7 @@ func TestUpdateTimeChown(t *testing.T) {
 		t.Fatalf("Failed to get initial attributes: %v", err)
 	}
 
-	initialCtime := attr1.Mtime
+	initialCtime := attr1.Ctime
 
 	// Chown should update ctime
 	err = fs.Chown(ctx, testFile, 1000, 1000)
@@ -140,7 +178,7 @@ func TestUpdateTimeChown(t *testing.T) {
 	}
 
 	// Ctime should be updated
-	if attr2.Mtime.Before(initialCtime) {
+	if attr2.Ctime.Before(initialCtime) {
 		t.Error("Ctime should be updated after chown")
 	}
 
@@ -191,6 +229,118 @@ func TestUpdateTimeTouch(t *testing.T) {
 	fs.Remove(ctx, testFile)
 }
 
+// TestRepeatedFlushesKeepMtimeNearNow tests that flushes within one second,
+// or after a future mtime was set, leave mtime at most a second ahead of now
+func TestRepeatedFlushesKeepMtimeNearNow(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	testFile := "test-time-flushes.txt"
+	for i := 0; i < 20; i++ {
+		if err := fs.WriteFile(ctx, testFile, []byte("HELLO WORLD"), int64(i)); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := fs.Flush(ctx, testFile); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	attr, err := fs.GetAttr(ctx, testFile)
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if ahead := attr.Mtime.Sub(time.Now()); ahead > time.Second {
+		t.Errorf("Expected mtime at most 1s ahead after 20 flushes, got %v ahead", ahead)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := fs.Utimens(ctx, testFile, future, future); err != nil {
+		t.Fatalf("Utimens failed: %v", err)
+	}
+	if err := fs.WriteFile(ctx, testFile, []byte("!"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.Flush(ctx, testFile); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	attr, err = fs.GetAttr(ctx, testFile)
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if ahead := attr.Mtime.Sub(time.Now()); ahead > time.Second {
+		t.Errorf("Expected a write after a future mtime to store the current time, got %v ahead", ahead)
+	}
+
+	fs.Remove(ctx, testFile)
+}
+
+// transferCountingClient counts requests that move object content in either direction
+type transferCountingClient struct {
+	S3ClientInterface
+	downloads int
+	uploads   int
+}
+
+func (c *transferCountingClient) GetObject(ctx context.Context, key string) ([]byte, error) {
+	c.downloads++
+	return c.S3ClientInterface.GetObject(ctx, key)
+}
+
+func (c *transferCountingClient) GetObjectRange(ctx context.Context, key string, start, end int64) ([]byte, error) {
+	c.downloads++
+	return c.S3ClientInterface.GetObjectRange(ctx, key, start, end)
+}
+
+func (c *transferCountingClient) PutObject(ctx context.Context, key string, data []byte) error {
+	c.uploads++
+	return c.S3ClientInterface.PutObject(ctx, key, data)
+}
+
+func (c *transferCountingClient) PutObjectWithMetadata(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	c.uploads++
+	return c.S3ClientInterface.PutObjectWithMetadata(ctx, key, data, metadata)
+}
+
+func (c *transferCountingClient) PutObjectMultipart(ctx context.Context, key string, data []byte) error {
+	c.uploads++
+	return c.S3ClientInterface.PutObjectMultipart(ctx, key, data)
+}
+
+// TestUtimensNoTransfer tests that touch updates times with a server-side copy
+func TestUtimensNoTransfer(t *testing.T) {
+	mock := s3client.NewMockClient("test-bucket", "us-east-1")
+	client := &transferCountingClient{S3ClientInterface: mock}
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	content := strings.Repeat("x", 1024*1024)
+	if err := mock.PutObjectWithMetadata(ctx, "big.bin", []byte(content), map[string]string{"x-amz-meta-mode": "0600", "mode": "0600"}); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	mtime := time.Date(2021, 2, 2, 13, 0, 0, 0, time.UTC)
+	if err := fs.Utimens(ctx, "big.bin", mtime, mtime); err != nil {
+		t.Fatalf("Utimens failed: %v", err)
+	}
+	if client.downloads != 0 || client.uploads != 0 {
+		t.Errorf("Expected no content transfer, got %d downloads and %d uploads", client.downloads, client.uploads)
+	}
+
+	attr, err := fs.GetAttr(ctx, "big.bin")
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if !attr.Mtime.Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, attr.Mtime)
+	}
+	if attr.Size != int64(len(content)) {
+		t.Errorf("Expected size %d, got %d", len(content), attr.Size)
+	}
+	if attr.Mode.Perm() != 0600 {
+		t.Errorf("Expected mode 0600 to be kept, got %o", attr.Mode.Perm())
+	}
+}
+
 // TestUpdateTimeAppend tests that append updates ctime/mtime
 func TestUpdateTimeAppend(t *testing.T) {
 	client := s3client.NewMockClient("test-bucket", "us-east-1")
@@ -332,3 +482,74 @@ func TestUpdateTimeMv(t *testing.T) {
 	// Cleanup
 	fs.Remove(ctx, destFile)
 }
+
+// TestSetattrTimes sets times through the FUSE Setattr path (touch -d, cp -p, touch)
+func TestSetattrTimes(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := filesystem.WriteFile(ctx, "setattr-times.txt", []byte("data"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := filesystem.Mkdir(ctx, "setattr-dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	explicit := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	nodes := []struct {
+		path string
+		node fs.NodeSetattrer
+	}{
+		{"setattr-times.txt", &File{filesystem: filesystem, path: "setattr-times.txt"}},
+		{"setattr-dir", &Dir{filesystem: filesystem, path: "setattr-dir"}},
+	}
+	for _, tt := range nodes {
+		path, node := tt.path, tt.node
+
+		// touch -d / cp -p: explicit atime and mtime
+		req := &fuse.SetattrRequest{
+			Valid: fuse.SetattrAtime | fuse.SetattrMtime,
+			Atime: explicit,
+			Mtime: explicit,
+		}
+		resp := &fuse.SetattrResponse{}
+		if err := node.Setattr(ctx, req, resp); err != nil {
+			t.Fatalf("Setattr(%s, explicit) failed: %v", path, err)
+		}
+		if !resp.Attr.Mtime.Equal(explicit) {
+			t.Errorf("Setattr(%s) response mtime = %v, want %v", path, resp.Attr.Mtime, explicit)
+		}
+		attr, err := filesystem.GetAttr(ctx, path)
+		if err != nil {
+			t.Fatalf("GetAttr(%s) failed: %v", path, err)
+		}
+		if !attr.Mtime.Equal(explicit) {
+			t.Errorf("GetAttr(%s) mtime = %v, want %v", path, attr.Mtime, explicit)
+		}
+
+		// touch: both times set to now
+		before := time.Now().Add(-time.Second)
+		req = &fuse.SetattrRequest{
+			Valid: fuse.SetattrAtime | fuse.SetattrMtime | fuse.SetattrAtimeNow | fuse.SetattrMtimeNow,
+		}
+		if err := node.Setattr(ctx, req, &fuse.SetattrResponse{}); err != nil {
+			t.Fatalf("Setattr(%s, now) failed: %v", path, err)
+		}
+		attr, _ = filesystem.GetAttr(ctx, path)
+		if attr.Mtime.Before(before) {
+			t.Errorf("GetAttr(%s) mtime after touch = %v, want about now", path, attr.Mtime)
+		}
+
+		// touch -a: only atime, which must leave mtime alone
+		mtime := attr.Mtime
+		req = &fuse.SetattrRequest{Valid: fuse.SetattrAtime, Atime: explicit}
+		if err := node.Setattr(ctx, req, &fuse.SetattrResponse{}); err != nil {
+			t.Fatalf("Setattr(%s, atime) failed: %v", path, err)
+		}
+		attr, _ = filesystem.GetAttr(ctx, path)
+		if !attr.Mtime.Equal(mtime) {
+			t.Errorf("GetAttr(%s) mtime after atime-only setattr = %v, want %v", path, attr.Mtime, mtime)
+		}
+	}
+}
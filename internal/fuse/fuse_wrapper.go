@@ -2,12 +2,20 @@ package fuse
 
 import (
 	"context"
-	"log"
+	"errors"
 	"os"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/s3fs-fuse/s3fs-go/internal/errno"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/compress"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/dedup"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/encrypt"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 // FuseFS implements the fuse.FS interface
@@ -30,7 +38,7 @@ func (f *FuseFS) Root() (fs.Node, error) {
 func (f *FuseFS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
 	statfs, err := f.filesystem.Statfs(ctx)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	resp.Blocks = statfs.Blocks
 	resp.Bfree = statfs.Bfree
@@ -50,7 +58,7 @@ type Dir struct {
 }
 
 var _ fs.Node = (*Dir)(nil)
-var _ fs.NodeStringLookuper = (*Dir)(nil)
+var _ fs.NodeRequestLookuper = (*Dir)(nil)
 var _ fs.HandleReadDirAller = (*Dir)(nil)
 var _ fs.NodeSetattrer = (*Dir)(nil)
 var _ fs.NodeGetxattrer = (*Dir)(nil)
@@ -68,27 +76,33 @@ var _ fs.NodeAccesser = (*Dir)(nil)
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	attr, err := d.filesystem.GetAttr(ctx, d.path)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
+	a.Valid = d.filesystem.attrValidity(d.path)
+	a.Inode = attr.Inode
 	a.Mode = os.ModeDir | attr.Mode
 	a.Size = uint64(attr.Size)
+	a.Blocks = attr.Blocks
 	a.Mtime = attr.Mtime
+	a.Ctime = attr.Ctime
+	a.Atime = attr.Atime
 	a.Uid = attr.Uid
 	a.Gid = attr.Gid
 	return nil
 }
 
 // Lookup looks up a child node
-func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
 	childPath := d.path
 	if childPath != "/" {
 		childPath += "/"
 	}
-	childPath += name
+	childPath += req.Name
+	resp.EntryValid = d.filesystem.entryTimeout
 
 	attr, err := d.filesystem.GetAttr(ctx, childPath)
 	if err != nil {
-		return nil, syscall.ENOENT
+		return nil, opError(ctx, err)
 	}
 
 	if attr.Mode.IsDir() {
@@ -108,13 +122,14 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	entries, err := d.filesystem.ReadDir(ctx, d.path)
 	if err != nil {
-		return nil, err
+		return nil, opError(ctx, err)
 	}
 
 	dirents := make([]fuse.Dirent, 0, len(entries))
 	for _, entry := range entries {
 		dirent := fuse.Dirent{
-			Name: entry.Name,
+			Inode: inodeFor(d.path + "/" + entry.Name),
+			Name:  entry.Name,
 		}
 		if entry.IsDir {
 			dirent.Type = fuse.DT_Dir
@@ -132,7 +147,7 @@ func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.
 	if req.Valid.Mode() {
 		err := d.filesystem.Chmod(ctx, d.path, req.Mode)
 		if err != nil {
-			return err
+			return opError(ctx, err)
 		}
 	}
 	if req.Valid.Uid() || req.Valid.Gid() {
@@ -151,26 +166,57 @@ func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.
 		}
 		err := d.filesystem.Chown(ctx, d.path, uid, gid)
 		if err != nil {
-			return err
+			return opError(ctx, err)
 		}
 	}
+	if err := setattrTimes(ctx, d.filesystem, d.path, req); err != nil {
+		return opError(ctx, err)
+	}
 	attr, err := d.filesystem.GetAttr(ctx, d.path)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	resp.Attr.Mode = os.ModeDir | attr.Mode
 	resp.Attr.Size = uint64(attr.Size)
+	resp.Attr.Blocks = attr.Blocks
 	resp.Attr.Mtime = attr.Mtime
+	resp.Attr.Ctime = attr.Ctime
+	resp.Attr.Atime = attr.Atime
 	resp.Attr.Uid = attr.Uid
 	resp.Attr.Gid = attr.Gid
 	return nil
 }
 
+// setattrTimes applies the mtime/atime part of a setattr request (touch, cp -p)
+// The *Now flags mean "use the current time" (utimensat with UTIME_NOW). A
+// request changing only one of them (touch -a, touch -m) leaves the other alone
+func setattrTimes(ctx context.Context, filesystem *Filesystem, path string, req *fuse.SetattrRequest) error {
+	setMtime := req.Valid.Mtime() || req.Valid.MtimeNow()
+	setAtime := req.Valid.Atime() || req.Valid.AtimeNow()
+	if !setMtime && !setAtime {
+		return nil
+	}
+	now := time.Now()
+	var mtime time.Time
+	if req.Valid.MtimeNow() {
+		mtime = now
+	} else if setMtime {
+		mtime = req.Mtime
+	}
+	var atime time.Time
+	if req.Valid.AtimeNow() {
+		atime = now
+	} else if setAtime {
+		atime = req.Atime
+	}
+	return opError(ctx, filesystem.Utimens(ctx, path, atime, mtime))
+}
+
 // Getxattr gets an extended attribute
 func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
 	value, err := d.filesystem.GetXattr(ctx, d.path, req.Name)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	resp.Xattr = value
 	return nil
@@ -178,19 +224,19 @@ func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fus
 
 // Setxattr sets an extended attribute
 func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
-	return d.filesystem.SetXattr(ctx, d.path, req.Name, req.Xattr)
+	return opError(ctx, d.filesystem.SetXattr(ctx, d.path, req.Name, req.Xattr))
 }
 
 // Removexattr removes an extended attribute
 func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
-	return d.filesystem.RemoveXattr(ctx, d.path, req.Name)
+	return opError(ctx, d.filesystem.RemoveXattr(ctx, d.path, req.Name))
 }
 
 // Listxattr lists extended attributes
 func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
 	names, err := d.filesystem.ListXattr(ctx, d.path)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	var buf []byte
 	for _, name := range names {
@@ -211,8 +257,9 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error
 	
 	err := d.filesystem.Mkdir(ctx, childPath, req.Mode)
 	if err != nil {
-		return nil, err
+		return nil, opError(ctx, err)
 	}
+	d.expireEntry(req.Name)
 	
 	return &Dir{
 		filesystem: d.filesystem,
@@ -220,6 +267,33 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error
 	}, nil
 }
 
+// minEntryExpiry is the soonest expireEntry invalidates an entry: the kernel
+// only has it once the response is delivered, and invalidating it before then
+// does nothing
+const minEntryExpiry = time.Second
+
+// entryInvalidator drops entries the kernel has cached (implemented by the
+// bazil fs.Server the filesystem is mounted through)
+type entryInvalidator interface {
+	InvalidateEntry(parent fs.Node, name string) error
+}
+
+// expireEntry makes the kernel forget the entry for name once the entry cache
+// timeout has passed. bazil gives the responses to Mkdir and Symlink its own
+// fixed entry validity (DefaultEntryCacheTimeout) with no way to set another,
+// so shorter timeouts are enforced by invalidating the entry instead
+func (d *Dir) expireEntry(name string) {
+	kernel, timeout := d.filesystem.kernel, d.filesystem.entryTimeout
+	if kernel == nil || timeout >= DefaultEntryCacheTimeout {
+		return
+	}
+	time.AfterFunc(max(timeout, minEntryExpiry), func() {
+		if err := kernel.InvalidateEntry(d, name); err != nil && err != fuse.ErrNotCached {
+			logging.Debug("failed to invalidate kernel entry", "path", d.path, "name", name, "err", err)
+		}
+	})
+}
+
 // Create creates a new file in the directory
 func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
 	childPath := d.path
@@ -229,8 +303,13 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	childPath += req.Name
 	
 	err := d.filesystem.Create(ctx, childPath, req.Mode)
+	if err == syscall.EEXIST && req.Flags&fuse.OpenExclusive == 0 {
+		// Without O_EXCL, a file another client created since the kernel's
+		// lookup is opened like any existing one
+		err = d.openExisting(WithCaller(ctx, req.Uid, req.Gid), childPath, req.Flags)
+	}
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, opError(ctx, err)
 	}
 	
 	file := &File{
@@ -238,10 +317,31 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 		path:       childPath,
 	}
 	
+	resp.EntryValid = d.filesystem.entryTimeout
 	resp.Handle = fuse.HandleID(0) // Not used, but required
 	return file, file, nil
 }
 
+// openExisting checks that path, found to exist by Create, can be opened as a
+// file by the caller, truncating it for O_TRUNC
+func (d *Dir) openExisting(ctx context.Context, path string, flags fuse.OpenFlags) error {
+	attr, err := d.filesystem.GetAttr(ctx, path)
+	if err != nil {
+		return err
+	}
+	if attr.Mode.IsDir() {
+		return syscall.EISDIR
+	}
+	uid, gid := callerFromContext(ctx)
+	if err := checkAccess(attr, uid, gid, openMask(flags)); err != nil {
+		return err
+	}
+	if flags&fuse.OpenTruncate != 0 {
+		return d.filesystem.Truncate(ctx, path, 0)
+	}
+	return nil
+}
+
 // Remove removes a file or empty directory
 func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	childPath := d.path
@@ -253,16 +353,19 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	// Check if it's a directory
 	attr, err := d.filesystem.GetAttr(ctx, childPath)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	
 	if attr.Mode.IsDir() {
-		// Remove directory
-		return d.filesystem.Rmdir(ctx, childPath)
+		// Remove directory (and its contents, if recursive rmdir is enabled)
+		if d.filesystem.recursiveRmdir {
+			return opError(ctx, d.filesystem.RemoveAll(ctx, childPath))
+		}
+		return opError(ctx, d.filesystem.Rmdir(ctx, childPath))
 	}
 	
 	// Remove file
-	return d.filesystem.Remove(ctx, childPath)
+	return opError(ctx, d.filesystem.Remove(ctx, childPath))
 }
 
 // Symlink creates a symbolic link
@@ -275,8 +378,9 @@ func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, e
 	
 	err := d.filesystem.Symlink(ctx, req.Target, childPath)
 	if err != nil {
-		return nil, err
+		return nil, opError(ctx, err)
 	}
+	d.expireEntry(req.NewName)
 	
 	// Return a file node for the symlink
 	return &File{
@@ -295,7 +399,7 @@ func (d *Dir) Mknod(ctx context.Context, req *fuse.MknodRequest) (fs.Node, error
 	
 	err := d.filesystem.Mknod(ctx, childPath, req.Mode, req.Rdev)
 	if err != nil {
-		return nil, err
+		return nil, opError(ctx, err)
 	}
 	
 	return &File{
@@ -306,7 +410,7 @@ func (d *Dir) Mknod(ctx context.Context, req *fuse.MknodRequest) (fs.Node, error
 
 // Access checks file access permissions
 func (d *Dir) Access(ctx context.Context, req *fuse.AccessRequest) error {
-	return d.filesystem.Access(ctx, d.path, req.Mask)
+	return opError(ctx, d.filesystem.Access(WithCaller(ctx, req.Uid, req.Gid), d.path, req.Mask))
 }
 
 // Opendir opens a directory handle - implemented as part of HandleReadDirAller
@@ -316,6 +420,8 @@ func (d *Dir) Access(ctx context.Context, req *fuse.AccessRequest) error {
 type File struct {
 	filesystem *Filesystem
 	path       string
+	readOnly   bool // Handle was opened O_RDONLY; Flush/Release have nothing to upload
+	appendMode bool // Handle was opened O_APPEND; writes go to the end of the file
 }
 
 var _ fs.Node = (*File)(nil)
@@ -338,26 +444,59 @@ var _ fs.HandleReleaser = (*File)(nil)
 func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 	attr, err := f.filesystem.GetAttr(ctx, f.path)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
+	a.Valid = f.filesystem.attrValidity(f.path)
+	a.Inode = attr.Inode
 	a.Mode = attr.Mode
 	a.Size = uint64(attr.Size)
+	a.Blocks = attr.Blocks
 	a.Mtime = attr.Mtime
+	a.Ctime = attr.Ctime
+	a.Atime = attr.Atime
 	a.Uid = attr.Uid
 	a.Gid = attr.Gid
 	return nil
 }
 
+// openMask returns the access(2) mask an open with flags needs
+func openMask(flags fuse.OpenFlags) uint32 {
+	var mask uint32
+	switch {
+	case flags.IsReadOnly():
+		mask = accessRead
+	case flags.IsWriteOnly():
+		mask = accessWrite
+	case flags.IsReadWrite():
+		mask = accessRead | accessWrite
+	}
+	if flags&fuse.OpenTruncate != 0 {
+		mask |= accessWrite
+	}
+	return mask
+}
+
 // Open opens a file
+// The caller needs the permissions its open mode asks for; reads and writes
+// through the handle are not checked again, as with a local file
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
-	return f, nil
+	if err := f.filesystem.Access(WithCaller(ctx, req.Uid, req.Gid), f.path, openMask(req.Flags)); err != nil {
+		return nil, opError(ctx, err)
+	}
+	// Each open gets its own handle so the open mode can be tracked per handle
+	return &File{
+		filesystem: f.filesystem,
+		path:       f.path,
+		readOnly:   req.Flags.IsReadOnly(),
+		appendMode: req.Flags&fuse.OpenAppend != 0,
+	}, nil
 }
 
 // Read reads file data
 func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
 	data, err := f.filesystem.ReadFile(ctx, f.path, req.Offset, int64(req.Size))
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	resp.Data = data
 	return nil
@@ -365,9 +504,16 @@ func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 
 // Write writes file data
 func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
-	err := f.filesystem.WriteFile(ctx, f.path, req.Data, req.Offset)
+	// The kernel's offset for an O_APPEND write is the size it last saw, which
+	// another appender may already have written past
+	var err error
+	if f.appendMode {
+		_, err = f.filesystem.AppendFile(ctx, f.path, req.Data)
+	} else {
+		err = f.filesystem.WriteFile(ctx, f.path, req.Data, req.Offset)
+	}
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	resp.Size = len(req.Data)
 	return nil
@@ -378,7 +524,7 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse
 	if req.Valid.Mode() {
 		err := f.filesystem.Chmod(ctx, f.path, req.Mode)
 		if err != nil {
-			return err
+			return opError(ctx, err)
 		}
 	}
 	if req.Valid.Uid() || req.Valid.Gid() {
@@ -398,17 +544,34 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse
 		}
 		err := f.filesystem.Chown(ctx, f.path, uid, gid)
 		if err != nil {
-			return err
+			return opError(ctx, err)
+		}
+	}
+	if req.Valid.Size() {
+		// truncate(2) by path needs write permission; ftruncate(2) was checked at open
+		if !req.Valid.Handle() {
+			if err := f.filesystem.Access(WithCaller(ctx, req.Header.Uid, req.Header.Gid), f.path, accessWrite); err != nil {
+				return opError(ctx, err)
+			}
 		}
+		if err := f.filesystem.Truncate(ctx, f.path, int64(req.Size)); err != nil {
+			return opError(ctx, err)
+		}
+	}
+	if err := setattrTimes(ctx, f.filesystem, f.path, req); err != nil {
+		return opError(ctx, err)
 	}
 	// Update response with new attributes
 	attr, err := f.filesystem.GetAttr(ctx, f.path)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	resp.Attr.Mode = attr.Mode
 	resp.Attr.Size = uint64(attr.Size)
+	resp.Attr.Blocks = attr.Blocks
 	resp.Attr.Mtime = attr.Mtime
+	resp.Attr.Ctime = attr.Ctime
+	resp.Attr.Atime = attr.Atime
 	resp.Attr.Uid = attr.Uid
 	resp.Attr.Gid = attr.Gid
 	return nil
@@ -418,7 +581,7 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse
 func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
 	value, err := f.filesystem.GetXattr(ctx, f.path, req.Name)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	resp.Xattr = value
 	return nil
@@ -426,19 +589,19 @@ func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fu
 
 // Setxattr sets an extended attribute
 func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
-	return f.filesystem.SetXattr(ctx, f.path, req.Name, req.Xattr)
+	return opError(ctx, f.filesystem.SetXattr(ctx, f.path, req.Name, req.Xattr))
 }
 
 // Removexattr removes an extended attribute
 func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
-	return f.filesystem.RemoveXattr(ctx, f.path, req.Name)
+	return opError(ctx, f.filesystem.RemoveXattr(ctx, f.path, req.Name))
 }
 
 // Listxattr lists extended attributes
 func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
 	names, err := f.filesystem.ListXattr(ctx, f.path)
 	if err != nil {
-		return err
+		return opError(ctx, err)
 	}
 	// Convert names to null-terminated strings
 	var buf []byte
@@ -452,7 +615,8 @@ func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *
 
 // Readlink reads the target of a symbolic link
 func (f *File) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
-	return f.filesystem.Readlink(ctx, f.path)
+	target, err := f.filesystem.Readlink(ctx, f.path)
+	return target, opError(ctx, err)
 }
 
 // Link creates a hard link (not supported)
@@ -464,7 +628,7 @@ func (f *File) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs
 	
 	err := f.filesystem.Link(ctx, oldFile.path, f.path)
 	if err != nil {
-		return nil, err
+		return nil, opError(ctx, err)
 	}
 	
 	return &File{
@@ -475,29 +639,107 @@ func (f *File) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs
 
 // Access checks file access permissions
 func (f *File) Access(ctx context.Context, req *fuse.AccessRequest) error {
-	return f.filesystem.Access(ctx, f.path, req.Mask)
+	return opError(ctx, f.filesystem.Access(WithCaller(ctx, req.Uid, req.Gid), f.path, req.Mask))
 }
 
 // Flush flushes file buffers
 func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
-	return f.filesystem.Flush(ctx, f.path)
+	// Read-only handles never buffer data, so never touch the backend
+	if f.readOnly {
+		return nil
+	}
+	return opError(ctx, f.filesystem.Flush(ctx, f.path))
 }
 
 // Fsync syncs file data to storage
 func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 	// req.Flags: bit 1 is datasync (sync data only), 0 = fsync (sync data and metadata)
 	datasync := req.Flags&1 != 0
-	return f.filesystem.Fsync(ctx, f.path, datasync)
+	return opError(ctx, f.filesystem.Fsync(ctx, f.path, datasync))
 }
 
 // Release releases a file handle
 func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	return f.filesystem.Release(ctx, f.path)
+	if f.readOnly {
+		return opError(ctx, f.filesystem.ReleaseReadOnly(ctx, f.path))
+	}
+	return opError(ctx, f.filesystem.Release(ctx, f.path))
+}
+
+// opError turns the error of an operation into the errno the kernel gets:
+// EINTR when the kernel interrupted the operation, otherwise the errno the
+// error stands for (see errno.From). bazil only passes on errnos returned
+// unwrapped, and reports anything else as EIO
+func opError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return syscall.EINTR
+	}
+	return errno.From(err)
 }
 
 // MountOptions contains options for mounting the filesystem
 type MountOptions struct {
-	EnableFileLock bool // Enable file-level advisory locking (default: false)
+	EnableFileLock     bool               // Enable file-level advisory locking (default: false)
+	AllowOther         bool               // Allow users other than the mounting user to access the mount
+	DefaultPermissions bool               // Let the kernel enforce permissions from file mode/uid/gid
+	ReadOnly           bool               // Mount read-only; write paths return EROFS
+	Prefix             string             // Key prefix mounted as the root, e.g. "team-a/data" ("" = whole bucket; S3 mounts only)
+	ForceUID           *uint32            // Report every object as owned by this uid (nil = stored owner)
+	ForceGID           *uint32            // Report every object as owned by this gid (nil = stored owner)
+	DefaultFileMode    os.FileMode        // Mode for files without mode metadata (0 = DefaultFileMode)
+	DefaultDirMode     os.FileMode        // Mode for directories without mode metadata (0 = DefaultDirMode)
+	Umask              os.FileMode        // Permission bits cleared from every reported mode (0 = none)
+	ForbiddenMode      os.FileMode        // Mode bits chmod and creates may not set (0 = none)
+	RejectForbidden    bool               // Fail requests for ForbiddenMode bits with EPERM instead of clearing them
+	KeepOctetStream    bool               // Upload files with storage's default Content-Type instead of one guessed from their name or content
+	Atime              AtimeMode          // When reads update access times (zero = AtimeOff)
+	NegativeCacheTTL   time.Duration      // How long missing paths are remembered (0 = disabled)
+	RecursiveRmdir     bool               // rmdir removes non-empty directories with all their contents
+	RmdirFlush         bool               // rmdir uploads children still being written instead of failing with ENOTEMPTY
+	MultipartCopySize  int64              // Copies of larger objects use multipart copy (0 = s3client.MaxCopyObjectSize)
+	ServeStaleOnError  bool               // Serve expired cached attributes and data while the backend is unreachable
+	StrictDirs         bool               // Creating a file or directory in a missing directory fails with ENOENT
+	DirMarkerStyle     DirMarkerStyle     // Kind of marker new directories get (zero = DirMarkerSlash)
+	ShowDirMarkers     bool               // List "dir/.keep" markers in directories, for debugging
+	MigrateDirMarkers  bool               // Replace the "dir/.keep" markers of earlier versions with "dir/" at mount
+	ShowVersions       bool               // Earlier versions of files are readable under each directory's .versions
+	ListNonCurrent     bool               // Listings of a versioned bucket include keys with only non-current versions left
+	ListDeleteMarkers  bool               // Listings of a versioned bucket include keys whose current version is a delete marker
+	CaseInsensitive    bool               // Storage treats names differing only in case as one object
+	ReadAheadSize      int64              // Bytes prefetched ahead of sequential reads (0 = disabled)
+	StatPrimeSize      int64              // Stat also caches the content of files up to this many bytes (0 = disabled)
+	PageCacheMemory    int64              // Most bytes of file data the page cache holds across files (0 = unlimited)
+	MaxCachedPages     int                // Most clean pages the page cache holds per file (0 = cache.DefaultMaxPages)
+	WriteBack          bool               // Buffer writes and upload them in the background
+	FlushInterval      time.Duration      // How often write-back mode uploads (0 = DefaultFlushInterval)
+	FlushOnEvict       bool               // Upload unused files' buffered writes so the FD cache can evict them
+	Dedup              bool               // Store identical file contents once (see storage/dedup)
+	Compression        compress.Algorithm // Compress file contents with this algorithm ("" = disabled)
+	EncryptionKey      []byte             // Encrypt file contents client-side under this master key (nil = disabled)
+	CacheDir           string             // Keep copies of whole files in this directory ("" = disabled)
+	CacheMaxSize       int64              // Disk cache size limit (0 = cache.DefaultDiskCacheSize)
+	TrackUsage         bool               // Count the bytes stored, from a bucket scan at mount, for Statfs and metrics
+	Quota              int64              // Fail writes past this many bytes stored with ENOSPC (0 = unlimited; implies TrackUsage)
+	AttrCacheTimeout   *time.Duration     // How long the kernel caches attributes (nil = DefaultAttrCacheTimeout)
+	EntryCacheTimeout  *time.Duration     // How long the kernel caches lookups (nil = DefaultEntryCacheTimeout)
+}
+
+// fuseMountOptions translates MountOptions into bazil/fuse mount options
+func (options MountOptions) fuseMountOptions() []fuse.MountOption {
+	mountOptions := []fuse.MountOption{
+		fuse.FSName("s3fs"),
+		fuse.Subtype("s3fs-go"),
+	}
+	if options.AllowOther {
+		mountOptions = append(mountOptions, fuse.AllowOther())
+	}
+	if options.DefaultPermissions {
+		mountOptions = append(mountOptions, fuse.DefaultPermissions())
+	}
+	if options.ReadOnly {
+		mountOptions = append(mountOptions, fuse.ReadOnly())
+	}
+	return mountOptions
 }
 
 // Mount mounts the filesystem at the given mountpoint
@@ -507,30 +749,185 @@ func Mount(mountpoint string, client S3ClientInterface) error {
 
 // MountWithOptions mounts the filesystem at the given mountpoint with options
 func MountWithOptions(mountpoint string, client S3ClientInterface, options MountOptions) error {
-	filesystem := NewFilesystem(client)
-	if options.EnableFileLock {
-		filesystem.SetEnableFileLock(true)
+	if err := configureClient(client, options); err != nil {
+		return err
+	}
+	adapter, err := newPrefixedS3Adapter(client, options.Prefix)
+	if err != nil {
+		return err
+	}
+	return MountBackendWithOptions(mountpoint, adapter, options)
+}
+
+// listOptionsSetter is implemented by S3 clients that can list object versions
+type listOptionsSetter interface {
+	SetListOptions(options s3client.ListOptions)
+}
+
+// configureClient applies the mount options that the S3 client itself carries out
+func configureClient(client S3ClientInterface, options MountOptions) error {
+	listOptions := s3client.ListOptions{
+		IncludeNonCurrent: options.ListNonCurrent,
+		ShowDeleteMarkers: options.ListDeleteMarkers,
+	}
+	if listOptions == (s3client.ListOptions{}) {
+		return nil
+	}
+	setter, ok := client.(listOptionsSetter)
+	if !ok {
+		return errors.New("listing non-current versions or delete markers needs an S3 client that lists object versions")
+	}
+	setter.SetListOptions(listOptions)
+	return nil
+}
+
+// MountBackendWithOptions mounts a filesystem over any storage backend
+func MountBackendWithOptions(mountpoint string, backend types.Backend, options MountOptions) error {
+	filesystem, err := newMountFilesystem(backend, options)
+	if err != nil {
+		return err
 	}
 	fuseFS := &FuseFS{
 		filesystem: filesystem,
 	}
 
-	c, err := fuse.Mount(
-		mountpoint,
-		fuse.FSName("s3fs"),
-		fuse.Subtype("s3fs-go"),
-	)
+	c, err := fuse.Mount(mountpoint, options.fuseMountOptions()...)
 	if err != nil {
 		return err
 	}
 	defer c.Close()
 
-	log.Printf("Mounted filesystem at %s", mountpoint)
+	logging.Info("mounted filesystem", "mountpoint", mountpoint)
+
+	server := fs.New(c, nil)
+	filesystem.kernel = server
+	err = server.Serve(fuseFS)
 
-	err = fs.Serve(c, fuseFS)
+	// Last chance for data whose upload failed when its file was closed, or
+	// that the write-back flusher had not uploaded yet
+	filesystem.SetWriteBack(false, 0)
+	if flushErr := filesystem.FlushAll(context.Background()); flushErr != nil {
+		logging.Error("unmounted with buffered data that could not be uploaded", "err", flushErr)
+	}
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// newMountFilesystem creates the filesystem a mount serves: backend wrapped
+// as the options ask, with every option applied
+func newMountFilesystem(backend types.Backend, options MountOptions) (*Filesystem, error) {
+	// Encryption goes innermost, since ciphertext does not compress
+	if options.EncryptionKey != nil {
+		encrypted, err := encrypt.NewEncryptBackend(backend, options.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		backend = encrypted
+	}
+	if options.Compression != "" {
+		backend = compress.NewCompressBackend(backend, options.Compression)
+	}
+	if options.Dedup {
+		backend = dedup.NewDedupBackend(backend)
+	}
+	filesystem := NewFilesystemWithBackend(backend)
+	if options.EnableFileLock {
+		filesystem.SetEnableFileLock(true)
+	}
+	if options.ReadOnly {
+		filesystem.SetReadOnly(true)
+	}
+	if options.ForceUID != nil {
+		filesystem.SetForceUID(*options.ForceUID)
+	}
+	if options.ForceGID != nil {
+		filesystem.SetForceGID(*options.ForceGID)
+	}
+	if options.DefaultFileMode != 0 {
+		filesystem.SetDefaultFileMode(options.DefaultFileMode)
+	}
+	if options.DefaultDirMode != 0 {
+		filesystem.SetDefaultDirMode(options.DefaultDirMode)
+	}
+	if options.Umask != 0 {
+		filesystem.SetUmask(options.Umask)
+	}
+	if options.ForbiddenMode != 0 {
+		filesystem.SetForbiddenMode(options.ForbiddenMode, options.RejectForbidden)
+	}
+	if options.KeepOctetStream {
+		filesystem.SetDetectContentType(false)
+	}
+	filesystem.SetAtimeMode(options.Atime)
+	filesystem.SetDirMarkerStyle(options.DirMarkerStyle)
+	filesystem.SetShowDirMarkers(options.ShowDirMarkers)
+	if options.NegativeCacheTTL > 0 {
+		filesystem.SetNegativeCacheTTL(options.NegativeCacheTTL)
+	}
+	if options.RecursiveRmdir {
+		filesystem.SetRecursiveRmdir(true)
+	}
+	if options.RmdirFlush {
+		filesystem.SetRmdirFlush(true)
+	}
+	if options.MultipartCopySize > 0 {
+		filesystem.SetMultipartCopyThreshold(options.MultipartCopySize)
+	}
+	if options.ServeStaleOnError {
+		filesystem.SetServeStaleOnError(true)
+	}
+	if options.StrictDirs {
+		filesystem.SetStrictDirs(true)
+	}
+	if options.ShowVersions {
+		filesystem.SetShowVersions(true)
+	}
+	if options.CaseInsensitive {
+		filesystem.SetCaseInsensitive(true)
+	}
+	if options.ReadAheadSize > 0 {
+		filesystem.SetReadAheadSize(options.ReadAheadSize)
+	}
+	if options.StatPrimeSize > 0 {
+		filesystem.SetStatPrimesRead(options.StatPrimeSize)
+	}
+	if options.PageCacheMemory > 0 {
+		filesystem.SetPageCacheMemory(options.PageCacheMemory)
+	}
+	if options.MaxCachedPages > 0 {
+		filesystem.SetMaxCachedPages(options.MaxCachedPages)
+	}
+	if options.AttrCacheTimeout != nil {
+		filesystem.SetAttrCacheTimeout(*options.AttrCacheTimeout)
+	}
+	if options.EntryCacheTimeout != nil {
+		filesystem.SetEntryCacheTimeout(*options.EntryCacheTimeout)
+	}
+	if options.CacheDir != "" {
+		if err := filesystem.SetDiskCache(options.CacheDir, options.CacheMaxSize); err != nil {
+			return nil, err
+		}
+	}
+	if options.TrackUsage || options.Quota > 0 {
+		if err := filesystem.SetUsageTracking(context.Background(), options.Quota); err != nil {
+			return nil, err
+		}
+	}
+	if options.MigrateDirMarkers {
+		migrated, err := filesystem.MigrateDirMarkers(context.Background(), "/")
+		if err != nil {
+			return nil, err
+		}
+		logging.Info("migrated directory markers", "directories", migrated)
+	}
+	if options.WriteBack {
+		filesystem.SetWriteBack(true, options.FlushInterval)
+	}
+	if options.FlushOnEvict {
+		filesystem.SetFlushOnEvict(true)
+	}
+	return filesystem, nil
+}
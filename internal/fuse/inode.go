@@ -0,0 +1,27 @@
+package fuse
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// rootInode is the inode number of the mount's root directory
+const rootInode = 1
+
+// inodeFor returns the inode number reported for path: a hash of the path, so
+// a file keeps its number across lookups and remounts, as tools and NFS
+// re-exports that remember inode numbers expect. Renaming a file changes it
+func inodeFor(path string) uint64 {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return rootInode
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(path))
+	inode := hash.Sum64()
+	// 0 means "unset" to FUSE and 1 is the root
+	if inode <= rootInode {
+		inode += rootInode + 1
+	}
+	return inode
+}
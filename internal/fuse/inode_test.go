@@ -0,0 +1,58 @@
+package fuse
+
+import (
+	"context"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+func TestStableInodes(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "dir/a.txt", []byte("a"))
+	client.PutObject(ctx, "dir/b.txt", []byte("b"))
+
+	inodeOf := func(filesystem *Filesystem, path string) uint64 {
+		attr, err := filesystem.GetAttr(ctx, path)
+		if err != nil {
+			t.Fatalf("GetAttr(%q) failed: %v", path, err)
+		}
+		return attr.Inode
+	}
+	filesystem := NewFilesystem(client)
+	first := inodeOf(filesystem, "/dir/a.txt")
+	if first == 0 || first == rootInode {
+		t.Fatalf("Inode of a file = %d", first)
+	}
+	if again := inodeOf(filesystem, "dir/a.txt"); again != first {
+		t.Errorf("Second GetAttr returned inode %d, first %d", again, first)
+	}
+	// A new mount reports the same number
+	if remounted := inodeOf(NewFilesystem(client), "/dir/a.txt"); remounted != first {
+		t.Errorf("Inode after remount = %d, want %d", remounted, first)
+	}
+	other, dir := inodeOf(filesystem, "/dir/b.txt"), inodeOf(filesystem, "/dir")
+	if other == first || dir == first || dir == other {
+		t.Errorf("Different paths share inodes: a=%d b=%d dir=%d", first, other, dir)
+	}
+	if root := inodeOf(filesystem, "/"); root != rootInode {
+		t.Errorf("Root inode = %d, want %d", root, rootInode)
+	}
+
+	// Nodes and directory entries report the same numbers
+	var fuseAttr fuse.Attr
+	if err := (&File{filesystem: filesystem, path: "/dir/a.txt"}).Attr(ctx, &fuseAttr); err != nil || fuseAttr.Inode != first {
+		t.Errorf("File.Attr inode = %d, %v; want %d", fuseAttr.Inode, err, first)
+	}
+	dirents, err := (&Dir{filesystem: filesystem, path: "/dir"}).ReadDirAll(ctx)
+	if err != nil {
+		t.Fatalf("ReadDirAll failed: %v", err)
+	}
+	for _, dirent := range dirents {
+		if want := inodeOf(filesystem, "/dir/"+dirent.Name); dirent.Inode != want {
+			t.Errorf("Dirent %s inode = %d, want %d", dirent.Name, dirent.Inode, want)
+		}
+	}
+}
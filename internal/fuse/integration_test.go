@@ -871,16 +871,12 @@ func TestMvEmptyDirectory(t *testing.T) {
 		t.Fatalf("Failed to list new directory: %v", err)
 	}
 
-	found := false
-	for _, entry := range entries {
-		if entry.Name == ".keep" {
-			found = true
-			break
-		}
+	// The placeholder is hidden as a directory marker, but keeps the directory
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty renamed directory, got %v", entries)
 	}
-
-	if !found {
-		t.Error("Directory entry '.keep' not found in renamed directory")
+	if attr, err := fs.GetAttr(ctx, newDir); err != nil || !attr.Mode.IsDir() {
+		t.Errorf("Renamed directory = %v, %v; want a directory", attr, err)
 	}
 
 	// Cleanup
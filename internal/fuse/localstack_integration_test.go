@@ -3,8 +3,14 @@ package fuse
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -204,6 +210,42 @@ func TestLocalStackUtimens(t *testing.T) {
 	fs.Remove(ctx, testFile)
 }
 
+// TestLocalStackUtimensLargeFile tests that touching a 30MB file only rewrites metadata
+func TestLocalStackUtimensLargeFile(t *testing.T) {
+	base := setupLocalStackFilesystemTest(t)
+	ctx := context.Background()
+
+	client := &transferCountingClient{S3ClientInterface: base.backend.(*s3Adapter).client}
+	fs := NewFilesystem(client)
+
+	testFile := fmt.Sprintf("test-utimens-large-%d.bin", time.Now().UnixNano())
+	size := 30 * 1024 * 1024
+	if err := client.PutObjectMultipart(ctx, testFile, make([]byte, size)); err != nil {
+		t.Fatalf("Failed to upload large file: %v", err)
+	}
+	defer fs.Remove(ctx, testFile)
+	client.uploads = 0
+
+	mtime := time.Date(2021, 2, 2, 13, 0, 0, 0, time.UTC)
+	if err := fs.Utimens(ctx, testFile, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	if client.downloads != 0 || client.uploads != 0 {
+		t.Errorf("Expected metadata-only update, got %d downloads and %d uploads", client.downloads, client.uploads)
+	}
+
+	attr, err := fs.GetAttr(ctx, testFile)
+	if err != nil {
+		t.Fatalf("Failed to get file attributes: %v", err)
+	}
+	if attr.Mtime.Unix() != mtime.Unix() {
+		t.Errorf("Expected mtime %v, got %v", mtime, attr.Mtime)
+	}
+	if attr.Size != int64(size) {
+		t.Errorf("Expected size %d after utimens, got %d", size, attr.Size)
+	}
+}
+
 // TestLocalStackUtimensDirectory tests setting times on a directory
 func TestLocalStackUtimensDirectory(t *testing.T) {
 	fs := setupLocalStackFilesystemTest(t)
@@ -414,6 +456,53 @@ func TestLocalStackSetXattr(t *testing.T) {
 	fs.Remove(ctx, testFile)
 }
 
+// TestLocalStackRenameKeepsXattrs tests that a renamed file keeps its mode,
+// owner and extended attributes through S3's metadata handling
+func TestLocalStackRenameKeepsXattrs(t *testing.T) {
+	fs := setupLocalStackFilesystemTest(t)
+	ctx := context.Background()
+
+	src := fmt.Sprintf("test-rename-xattr-%d.txt", time.Now().UnixNano())
+	dst := src + ".moved"
+	defer fs.Remove(ctx, src)
+	defer fs.Remove(ctx, dst)
+
+	if err := fs.WriteFile(ctx, src, []byte("Test data"), 0); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := fs.Flush(ctx, src); err != nil {
+		t.Fatalf("Failed to flush file: %v", err)
+	}
+	if err := fs.Chmod(ctx, src, 0640); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.Chown(ctx, src, 1234, 5678); err != nil {
+		t.Fatalf("Chown failed: %v", err)
+	}
+	xattrs := map[string]string{"user.comment": "hello world", "user.tag": "blue"}
+	for name, value := range xattrs {
+		if err := fs.SetXattr(ctx, src, name, []byte(value)); err != nil {
+			t.Fatalf("SetXattr(%s) failed: %v", name, err)
+		}
+	}
+
+	if err := fs.Rename(ctx, src, dst); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	attr, err := fs.GetAttr(ctx, dst)
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if attr.Mode != 0640 || attr.Uid != 1234 || attr.Gid != 5678 {
+		t.Errorf("Renamed file has mode %v, owner %d:%d; want -rw-r----- and 1234:5678", attr.Mode, attr.Uid, attr.Gid)
+	}
+	for name, value := range xattrs {
+		if got, err := fs.GetXattr(ctx, dst, name); err != nil || string(got) != value {
+			t.Errorf("GetXattr(%s) = %q, %v; want %q", name, got, err, value)
+		}
+	}
+}
+
 // TestLocalStackGetXattr tests getting extended attributes
 func TestLocalStackGetXattr(t *testing.T) {
 	fs := setupLocalStackFilesystemTest(t)
@@ -728,14 +817,14 @@ func TestLocalStackMkdirNested(t *testing.T) {
 	fs.Rmdir(ctx, parentDir)
 }
 
-// TestLocalStackRmdirWithMarker tests removing directory with .keep marker
+// TestLocalStackRmdirWithMarker tests removing directory with its "dir/" marker
 func TestLocalStackRmdirWithMarker(t *testing.T) {
 	fs := setupLocalStackFilesystemTest(t)
 	ctx := context.Background()
 
 	testDir := fmt.Sprintf("test-rmdir-marker-%d", time.Now().UnixNano())
 
-	// Create directory (creates .keep marker)
+	// Create directory (creates the "dir/" marker)
 	err := fs.Mkdir(ctx, testDir, 0755)
 	if err != nil {
 		t.Fatalf("Failed to create directory: %v", err)
@@ -753,3 +842,294 @@ func TestLocalStackRmdirWithMarker(t *testing.T) {
 		t.Error("Directory should not exist after removal")
 	}
 }
+
+// TestLocalStackRemoveAll tests removing a 2000-object tree with batch deletes
+func TestLocalStackRemoveAll(t *testing.T) {
+	fs := setupLocalStackFilesystemTest(t)
+	ctx := context.Background()
+
+	testDir := fmt.Sprintf("test-removeall-%d", time.Now().UnixNano())
+	if err := fs.Mkdir(ctx, testDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	backend := fs.getBackend()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2000)
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 2000; i += 16 {
+				key := fmt.Sprintf("%s/sub%d/file%d.txt", testDir, i%20, i)
+				if err := backend.Write(ctx, key, []byte("x")); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatalf("Failed to write object: %v", err)
+	}
+
+	if err := fs.RemoveAll(ctx, testDir); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+
+	remaining, err := backend.List(ctx, testDir+"/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected empty prefix after RemoveAll, got %d objects", len(remaining))
+	}
+	if _, err := fs.GetAttr(ctx, testDir); err == nil {
+		t.Error("Directory should not exist after RemoveAll")
+	}
+}
+
+// TestLocalStackRenameDirectory tests renaming a directory of 200 files
+func TestLocalStackRenameDirectory(t *testing.T) {
+	fs := setupLocalStackFilesystemTest(t)
+	ctx := context.Background()
+
+	oldDir := fmt.Sprintf("test-rename-dir-%d", time.Now().UnixNano())
+	newDir := oldDir + "-moved"
+	if err := fs.Mkdir(ctx, oldDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer fs.RemoveAll(ctx, newDir)
+
+	backend := fs.getBackend()
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("%s/file%03d.txt", oldDir, i)
+		if err := backend.Write(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Failed to write object: %v", err)
+		}
+	}
+
+	if err := fs.Rename(ctx, oldDir, newDir); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	remaining, err := backend.List(ctx, oldDir+"/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected old prefix to be empty, got %d objects", len(remaining))
+	}
+	moved, err := backend.List(ctx, newDir+"/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(moved) != 201 { // 200 files plus the directory marker
+		t.Errorf("Expected 201 objects under new prefix, got %d", len(moved))
+	}
+	data, err := fs.ReadFile(ctx, newDir+"/file123.txt", 0, 100)
+	if err != nil || string(data) != oldDir+"/file123.txt" {
+		t.Errorf("Moved file content = %q (err %v)", data, err)
+	}
+}
+
+// TestLocalStackRenameDirectoryCopyThreshold tests that a directory rename copies
+// small files with CopyObject and files above the threshold with multipart copy
+func TestLocalStackRenameDirectoryCopyThreshold(t *testing.T) {
+	base := setupLocalStackFilesystemTest(t)
+	ctx := context.Background()
+
+	client := &copyCountingClient{S3ClientInterface: base.backend.(*s3Adapter).client}
+	fs := NewFilesystem(client)
+	fs.SetMultipartCopyThreshold(5 * 1024 * 1024)
+
+	oldDir := fmt.Sprintf("test-rename-threshold-%d", time.Now().UnixNano())
+	newDir := oldDir + "-moved"
+	if err := fs.Mkdir(ctx, oldDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer fs.RemoveAll(ctx, newDir)
+
+	small := oldDir + "/small.bin"
+	large := oldDir + "/large.bin"
+	if err := client.PutObject(ctx, small, make([]byte, 1024)); err != nil {
+		t.Fatalf("Failed to upload small file: %v", err)
+	}
+	if err := client.PutObjectMultipart(ctx, large, make([]byte, 6*1024*1024)); err != nil {
+		t.Fatalf("Failed to upload large file: %v", err)
+	}
+
+	if err := fs.Rename(ctx, oldDir, newDir); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	if got := strings.Join(client.multipart, ","); got != large {
+		t.Errorf("Expected only %s to use multipart copy, got %q", large, got)
+	}
+	simple := make(map[string]bool)
+	for _, key := range client.simple {
+		simple[key] = true
+	}
+	if !simple[small] || simple[large] {
+		t.Errorf("Expected %s but not %s to use CopyObject, got %v", small, large, client.simple)
+	}
+
+	for name, size := range map[string]int64{"small.bin": 1024, "large.bin": 6 * 1024 * 1024} {
+		attr, err := fs.GetAttr(ctx, newDir+"/"+name)
+		if err != nil {
+			t.Fatalf("Moved file %s missing: %v", name, err)
+		}
+		if attr.Size != size {
+			t.Errorf("Expected %s to be %d bytes, got %d", name, size, attr.Size)
+		}
+	}
+}
+
+// TestLocalStackVersions tests reading and restoring earlier versions in a
+// versioned bucket
+func TestLocalStackVersions(t *testing.T) {
+	if !isLocalStackAvailable() {
+		t.Skip("LocalStack is not available. Start it with: docker-compose -f docker-compose.localstack.yml up -d")
+	}
+	creds := credentials.NewCredentials()
+	creds.AccessKeyID = "test"
+	creds.SecretAccessKey = "test"
+	client := s3client.NewClientWithEndpoint(localstackBucket+"-versions", localstackRegion, localstackEndpoint, creds)
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx); err != nil &&
+		!strings.Contains(err.Error(), "BucketAlreadyOwnedByYou") && !strings.Contains(err.Error(), "BucketAlreadyExists") {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if err := client.EnableVersioning(ctx); err != nil {
+		t.Fatalf("Failed to enable versioning: %v", err)
+	}
+	fs := NewFilesystem(client)
+	fs.SetShowVersions(true)
+
+	dir := fmt.Sprintf("test-versions-%d", time.Now().UnixNano())
+	path := dir + "/notes.txt"
+	for _, content := range []string{"first", "second"} {
+		if err := client.PutObject(ctx, path, []byte(content)); err != nil {
+			t.Fatalf("Failed to upload %q: %v", content, err)
+		}
+	}
+	if err := fs.Remove(ctx, path); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	versions, err := fs.ListVersions(ctx, path)
+	if err != nil {
+		t.Fatalf("ListVersions failed: %v", err)
+	}
+	if len(versions) != 3 || !versions[0].Deleted || !versions[0].Latest {
+		t.Fatalf("Expected a delete marker on top of 2 versions, got %+v", versions)
+	}
+	oldest := versions[2]
+	if data, err := fs.ReadVersion(ctx, path, oldest.ID); err != nil || string(data) != "first" {
+		t.Errorf("ReadVersion = %q, %v; want %q", data, err, "first")
+	}
+
+	// The deleted file's versions stay readable through .versions
+	entries, err := fs.ReadDir(ctx, dir+"/.versions/notes.txt")
+	if err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir of the version directory = %+v, %v; want 2 versions", entries, err)
+	}
+	if data, err := fs.ReadFile(ctx, dir+"/.versions/notes.txt/"+oldest.ID, 0, 0); err != nil || string(data) != "first" {
+		t.Errorf("ReadFile of a version = %q, %v; want %q", data, err, "first")
+	}
+
+	// Restoring undeletes the file
+	if err := fs.Restore(ctx, path, oldest.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if data, err := fs.ReadFile(ctx, path, 0, 0); err != nil || string(data) != "first" {
+		t.Errorf("ReadFile after Restore = %q, %v; want %q", data, err, "first")
+	}
+	fs.Remove(ctx, path)
+}
+
+// countingProxy forwards requests to LocalStack, counting the body bytes that
+// pass through it either way
+type countingProxy struct {
+	proxy *httputil.ReverseProxy
+	bytes atomic.Int64
+}
+
+func (p *countingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		r.Body = &countingBody{ReadCloser: r.Body, count: &p.bytes}
+	}
+	p.proxy.ServeHTTP(&countingWriter{ResponseWriter: w, count: &p.bytes}, r)
+}
+
+type countingBody struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	count *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.count.Add(int64(n))
+	return n, err
+}
+
+// TestLocalStackRenameServerSide tests that renaming large files and directories
+// copies them within S3 instead of through the client
+func TestLocalStackRenameServerSide(t *testing.T) {
+	setupLocalStackFilesystemTest(t)
+	target, _ := url.Parse(localstackEndpoint)
+	counter := &countingProxy{proxy: httputil.NewSingleHostReverseProxy(target)}
+	proxy := httptest.NewServer(counter)
+	defer proxy.Close()
+
+	creds := credentials.NewCredentials()
+	creds.AccessKeyID = "test"
+	creds.SecretAccessKey = "test"
+	client := s3client.NewClientWithEndpoint(localstackBucket, localstackRegion, proxy.URL, creds)
+	fs := NewFilesystem(client)
+	fs.SetMultipartCopyThreshold(8 * 1024 * 1024)
+	ctx := context.Background()
+
+	const size = 16 * 1024 * 1024
+	prefix := fmt.Sprintf("test-rename-server-side-%d", time.Now().UnixNano())
+	for _, key := range []string{prefix + "/big.bin", prefix + "/dir/big.bin"} {
+		if err := client.PutObjectMultipart(ctx, key, make([]byte, size)); err != nil {
+			t.Fatalf("Failed to upload %s: %v", key, err)
+		}
+	}
+	defer fs.RemoveAll(ctx, prefix)
+
+	before := counter.bytes.Load()
+	if err := fs.Rename(ctx, prefix+"/big.bin", prefix+"/moved.bin"); err != nil {
+		t.Fatalf("Rename of file failed: %v", err)
+	}
+	if err := fs.Rename(ctx, prefix+"/dir", prefix+"/moved-dir"); err != nil {
+		t.Fatalf("Rename of directory failed: %v", err)
+	}
+	// Requests and responses of the copies are XML, far smaller than one object
+	if transferred := counter.bytes.Load() - before; transferred > size/16 {
+		t.Errorf("Renames moved %d bytes through the client, want object data to stay in S3", transferred)
+	}
+
+	for _, path := range []string{prefix + "/moved.bin", prefix + "/moved-dir/big.bin"} {
+		attr, err := fs.GetAttr(ctx, path)
+		if err != nil {
+			t.Fatalf("Renamed file %s missing: %v", path, err)
+		}
+		if attr.Size != size {
+			t.Errorf("Expected %s to be %d bytes, got %d", path, size, attr.Size)
+		}
+	}
+}
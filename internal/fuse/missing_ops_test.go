@@ -5,6 +5,8 @@ import (
 	"os"
 	"syscall"
 	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
 )
 
 func TestSymlink(t *testing.T) {
@@ -134,10 +136,10 @@ func TestAccess(t *testing.T) {
 		t.Errorf("Expected write permission, got error: %v", err)
 	}
 
-	// Test X_OK (execute permission)
+	// Test X_OK (execute permission) - 0644 grants no execute bit
 	err = fs.Access(ctx, filePath, 1)
-	if err != nil {
-		t.Errorf("Expected execute permission, got error: %v", err)
+	if err != syscall.EACCES {
+		t.Errorf("Expected EACCES for execute permission, got: %v", err)
 	}
 
 	// Test nonexistent file
@@ -285,3 +287,28 @@ func setupTestFilesystem(t *testing.T) *Filesystem {
 	t.Skip("Skipping test - requires S3 client setup")
 	return nil
 }
+
+func TestSymlinkSurvivesCacheExpiry(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := fs.Symlink(ctx, "target/file.txt", "/link"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	fs.cache.GetStatCache().Clear()
+
+	// A fresh mount sees only what storage holds
+	for _, filesystem := range []*Filesystem{fs, NewFilesystem(client)} {
+		attr, err := filesystem.GetAttr(ctx, "/link")
+		if err != nil {
+			t.Fatalf("Failed to get symlink attributes: %v", err)
+		}
+		if attr.Mode&os.ModeSymlink == 0 {
+			t.Errorf("Expected symlink mode from storage, got %v", attr.Mode)
+		}
+		if target, err := filesystem.Readlink(ctx, "/link"); err != nil || target != "target/file.txt" {
+			t.Errorf("Readlink = %q, %v; want target/file.txt", target, err)
+		}
+	}
+}
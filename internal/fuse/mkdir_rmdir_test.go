@@ -2,10 +2,15 @@ package fuse
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"syscall"
 	"testing"
+	"time"
 
+	"bazil.org/fuse"
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 // TestMkdir tests creating a directory
@@ -68,6 +73,44 @@ func TestMkdirExisting(t *testing.T) {
 	fs.Rmdir(ctx, testDir)
 }
 
+// TestMkdirConcurrent tests that racing Mkdir calls create the directory once
+func TestMkdirConcurrent(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+
+	// Two calls on one mount, and one on a second mount of the same bucket
+	fs := NewFilesystem(client)
+	other := NewFilesystem(client)
+	mounts := []*Filesystem{fs, fs, other}
+
+	start := make(chan struct{})
+	errs := make(chan error, len(mounts))
+	for _, mount := range mounts {
+		go func(mount *Filesystem) {
+			<-start
+			errs <- mount.Mkdir(ctx, "racedir", 0755)
+		}(mount)
+	}
+	close(start)
+
+	created := 0
+	for range mounts {
+		switch err := <-errs; err {
+		case nil:
+			created++
+		case syscall.EEXIST:
+		default:
+			t.Errorf("Mkdir: expected nil or EEXIST, got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one Mkdir to succeed, got %d", created)
+	}
+	if _, err := fs.GetAttr(ctx, "racedir"); err != nil {
+		t.Errorf("GetAttr after concurrent Mkdir failed: %v", err)
+	}
+}
+
 // TestRmdir tests removing an empty directory
 func TestRmdir(t *testing.T) {
 	client := s3client.NewClient("test-bucket", "us-east-1", nil)
@@ -168,11 +211,14 @@ func TestMkdirRmdirIntegration(t *testing.T) {
 		t.Error("Created path is not a directory")
 	}
 
-	// List directory (should be empty or only contain .keep)
+	// List directory; its marker isn't an entry
 	entries, err := fs.ReadDir(ctx, testDir)
 	if err != nil {
 		t.Fatalf("Failed to list directory: %v", err)
 	}
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty directory, got %+v", entries)
+	}
 
 	// Remove directory
 	err = fs.Rmdir(ctx, testDir)
@@ -185,7 +231,315 @@ func TestMkdirRmdirIntegration(t *testing.T) {
 	if err == nil {
 		t.Error("Directory should not exist after removal")
 	}
+}
+
+// TestRemoveAll tests removing a non-empty directory in one call
+func TestRemoveAll(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	for _, key := range []string{"tree/.keep", "tree/a.txt", "tree/b.txt", "treehouse.txt"} {
+		if err := client.PutObject(ctx, key, []byte("x")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	if err := fs.RemoveAll(ctx, "tree"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if remaining, _ := client.ListObjects(ctx, "tree/"); len(remaining) != 0 {
+		t.Errorf("Objects remain after RemoveAll: %v", remaining)
+	}
+	if _, err := fs.GetAttr(ctx, "treehouse.txt"); err != nil {
+		t.Errorf("Sibling sharing the name prefix should survive: %v", err)
+	}
 
-	// Verify entries list is empty (or only had .keep)
-	_ = entries
+	if err := fs.RemoveAll(ctx, "treehouse.txt"); err != syscall.ENOTDIR {
+		t.Errorf("Expected ENOTDIR for a file, got %v", err)
+	}
+	if err := fs.RemoveAll(ctx, "missing"); err != syscall.ENOENT {
+		t.Errorf("Expected ENOENT for a missing directory, got %v", err)
+	}
+}
+
+// TestRemoveAllNestedTree tests removing nested directories, buffered files and cached stats
+func TestRemoveAllNestedTree(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	keys := []string{"top/.keep", "top/a.txt", "top/sub/.keep", "top/sub/b.txt", "top/sub/deep/c.txt", "top/sub/deep/deeper/d.txt"}
+	for _, key := range keys {
+		if err := client.PutObject(ctx, key, []byte("hello world")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	// Partial write inside the existing size stays buffered in the fd cache
+	if err := fs.WriteFile(ctx, "top/sub/b.txt", []byte("HE"), 2); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if len(fs.cache.GetFdCache().GetBufferedPaths("top/")) != 1 {
+		t.Fatal("Expected a buffered file under top/")
+	}
+	// Populate the stat cache for nested paths
+	for _, path := range []string{"/top", "/top/sub", "/top/sub/deep/c.txt"} {
+		if _, err := fs.GetAttr(ctx, path); err != nil {
+			t.Fatalf("GetAttr(%s) failed: %v", path, err)
+		}
+	}
+
+	if err := fs.RemoveAll(ctx, "/top"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+
+	if remaining, _ := client.ListObjects(ctx, "top/"); len(remaining) != 0 {
+		t.Errorf("Objects remain after RemoveAll: %v", remaining)
+	}
+	for _, path := range []string{"/top", "/top/sub", "/top/sub/deep/c.txt"} {
+		if _, err := fs.GetAttr(ctx, path); err == nil {
+			t.Errorf("%s still visible after RemoveAll", path)
+		}
+	}
+
+	// Releasing the buffered file afterwards must not bring it back
+	if err := fs.Flush(ctx, "top/sub/b.txt"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if exists, _ := fs.getBackend().Exists(ctx, "top/sub/b.txt"); exists {
+		t.Error("Buffered file was re-uploaded after RemoveAll")
+	}
+}
+
+// racingDeleteBackend runs hook during the first DeleteMany, while RemoveAll is in progress
+type racingDeleteBackend struct {
+	types.Backend
+	hook func()
+}
+
+func (b *racingDeleteBackend) DeleteMany(ctx context.Context, paths []string) error {
+	if hook := b.hook; hook != nil {
+		b.hook = nil
+		hook()
+	}
+	return b.Backend.DeleteMany(ctx, paths)
+}
+
+// TestRemoveAllConcurrentWrites tests that writes during removal are refused or removed
+func TestRemoveAllConcurrentWrites(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	for _, key := range []string{"busy/.keep", "busy/a.txt", "busy/sub/b.txt"} {
+		if err := client.PutObject(ctx, key, []byte("x")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	backend := &racingDeleteBackend{Backend: newS3Adapter(client)}
+	fs := NewFilesystemWithBackend(backend)
+
+	var createErr, writeErr, mkdirErr error
+	backend.hook = func() {
+		createErr = fs.Create(ctx, "busy/new.txt", 0644)
+		writeErr = fs.WriteFile(ctx, "busy/sub/new.txt", []byte("data"), 0)
+		mkdirErr = fs.Mkdir(ctx, "busy/newdir", 0755)
+		// A write that was already past the checks lands straight in the backend
+		client.PutObject(ctx, "busy/sub/inflight.txt", []byte("late"))
+	}
+
+	if err := fs.RemoveAll(ctx, "busy"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	for name, err := range map[string]error{"Create": createErr, "WriteFile": writeErr, "Mkdir": mkdirErr} {
+		if err != syscall.ENOENT {
+			t.Errorf("%s during removal: expected ENOENT, got %v", name, err)
+		}
+	}
+	if remaining, _ := client.ListObjects(ctx, "busy/"); len(remaining) != 0 {
+		t.Errorf("Objects remain after RemoveAll: %v", remaining)
+	}
+
+	// Once the removal finishes the directory name is usable again
+	if err := fs.Mkdir(ctx, "busy", 0755); err != nil {
+		t.Errorf("Mkdir after RemoveAll failed: %v", err)
+	}
+}
+
+// TestRecursiveRmdir tests that the FUSE remove path uses RemoveAll when enabled
+func TestRecursiveRmdir(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+	for _, key := range []string{"full/.keep", "full/file.txt"} {
+		if err := client.PutObject(ctx, key, []byte("x")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	root := &Dir{filesystem: fs, path: "/"}
+	req := &fuse.RemoveRequest{Name: "full", Dir: true}
+	if err := root.Remove(ctx, req); err != syscall.ENOTEMPTY {
+		t.Errorf("Expected ENOTEMPTY without recursive rmdir, got %v", err)
+	}
+
+	fs.SetRecursiveRmdir(true)
+	if err := root.Remove(ctx, req); err != nil {
+		t.Fatalf("Recursive rmdir failed: %v", err)
+	}
+	if remaining, _ := client.ListObjects(ctx, "full/"); len(remaining) != 0 {
+		t.Errorf("Objects remain after recursive rmdir: %v", remaining)
+	}
+}
+
+func TestRmdirBufferedChild(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		t.Run(fmt.Sprintf("flush=%v", flush), func(t *testing.T) {
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			fs := NewFilesystem(client)
+			fs.SetRmdirFlush(flush)
+			ctx := context.Background()
+
+			if err := fs.Mkdir(ctx, "/dir", 0755); err != nil {
+				t.Fatalf("Mkdir failed: %v", err)
+			}
+			// Buffer a child without uploading it, as a write below maxDirtyData does
+			entity, err := fs.cache.GetFdCache().Open("dir/pending.txt", 0, time.Now())
+			if err != nil {
+				t.Fatalf("Failed to open cache entity: %v", err)
+			}
+			entity.WritePage(0, []byte("not flushed yet"))
+			entity.SetSize(int64(len("not flushed yet")))
+
+			if err := fs.Rmdir(ctx, "/dir"); err != syscall.ENOTEMPTY {
+				t.Fatalf("Expected ENOTEMPTY, got %v", err)
+			}
+			if _, err := client.HeadObject(ctx, "dir/"); err != nil {
+				t.Errorf("Directory marker removed: %v", err)
+			}
+
+			// The child survives, whether flushed by Rmdir or on release
+			if err := fs.Release(ctx, "/dir/pending.txt"); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			data, err := client.GetObject(ctx, "dir/pending.txt")
+			if err != nil || string(data) != "not flushed yet" {
+				t.Errorf("Child = %q (err %v)", data, err)
+			}
+		})
+	}
+}
+
+func TestStrictDirs(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("strict", func(t *testing.T) {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		fs := NewFilesystem(client)
+		fs.SetStrictDirs(true)
+
+		if err := fs.Create(ctx, "a/b/file.txt", 0644); err != syscall.ENOENT {
+			t.Errorf("Create in a missing directory: expected ENOENT, got %v", err)
+		}
+		if err := fs.WriteFile(ctx, "a/b/file.txt", []byte("data"), 0); err != syscall.ENOENT {
+			t.Errorf("WriteFile in a missing directory: expected ENOENT, got %v", err)
+		}
+		if err := fs.Mkdir(ctx, "a/b", 0755); err != syscall.ENOENT {
+			t.Errorf("Mkdir in a missing directory: expected ENOENT, got %v", err)
+		}
+		if keys, _ := client.ListObjects(ctx, "a/"); len(keys) != 0 {
+			t.Errorf("Objects written despite ENOENT: %v", keys)
+		}
+
+		if err := fs.Mkdir(ctx, "a", 0755); err != nil {
+			t.Fatalf("Mkdir(a) failed: %v", err)
+		}
+		if err := fs.Mkdir(ctx, "a/b", 0755); err != nil {
+			t.Fatalf("Mkdir(a/b) failed: %v", err)
+		}
+		if err := fs.Create(ctx, "a/b/file.txt", 0644); err != nil {
+			t.Errorf("Create after Mkdir failed: %v", err)
+		}
+
+		// A directory implied by other keys exists too
+		if err := client.PutObject(ctx, "implied/x.txt", []byte("x")); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+		if err := fs.WriteFile(ctx, "implied/y.txt", []byte("y"), 0); err != nil {
+			t.Errorf("WriteFile in an implied directory failed: %v", err)
+		}
+		if err := fs.Create(ctx, "implied/x.txt/z.txt", 0644); err != syscall.ENOTDIR {
+			t.Errorf("Create under a file: expected ENOTDIR, got %v", err)
+		}
+	})
+
+	t.Run("lenient", func(t *testing.T) {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		fs := NewFilesystem(client)
+
+		if err := fs.WriteFile(ctx, "a/b/file.txt", []byte("data"), 0); err != nil {
+			t.Fatalf("WriteFile in a missing directory failed: %v", err)
+		}
+		attr, err := fs.GetAttr(ctx, "a/b")
+		if err != nil || !attr.Mode.IsDir() {
+			t.Errorf("Parent of the written file = %+v (err %v), want a directory", attr, err)
+		}
+		if err := fs.Create(ctx, "c/d/new.txt", 0644); err != nil {
+			t.Errorf("Create in a missing directory failed: %v", err)
+		}
+	})
+}
+
+func TestRmdirMarkers(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want error
+	}{
+		{"empty with marker", []string{"dir/"}, nil},
+		{"empty with legacy marker", []string{"dir/.keep"}, nil},
+		{"empty with both markers", []string{"dir/", "dir/.keep"}, nil},
+		{"empty without marker", nil, syscall.ENOENT}, // Nothing left to be a directory
+		{"one child", []string{"dir/", "dir/file.txt"}, syscall.ENOTEMPTY},
+		{"one child without marker", []string{"dir/file.txt"}, syscall.ENOTEMPTY},
+		{"child after both markers", []string{"dir/", "dir/.keep", "dir/z.txt"}, syscall.ENOTEMPTY},
+		{"subdirectory", []string{"dir/", "dir/sub/"}, syscall.ENOTEMPTY},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			for _, key := range append(tt.keys, "other.txt") {
+				if err := client.PutObject(ctx, key, nil); err != nil {
+					t.Fatalf("Failed to put %s: %v", key, err)
+				}
+			}
+			fs := NewFilesystem(client)
+			fs.SetAtimeMode(AtimeOff)
+			// Fill the caches Rmdir has to invalidate
+			fs.GetAttr(ctx, "/dir")
+			fs.ReadDir(ctx, "/")
+
+			if err := fs.Rmdir(ctx, "/dir"); err != tt.want {
+				t.Fatalf("Rmdir = %v, want %v", err, tt.want)
+			}
+			remaining, _ := client.ListObjects(ctx, "dir")
+			if tt.want != nil {
+				if len(remaining) != len(tt.keys) {
+					t.Errorf("Objects after a failed Rmdir = %v, want %v", remaining, tt.keys)
+				}
+				return
+			}
+			if len(remaining) != 0 {
+				t.Errorf("Objects remain after Rmdir: %v", remaining)
+			}
+			if _, err := fs.GetAttr(ctx, "/dir"); err == nil {
+				t.Error("/dir still exists after Rmdir")
+			}
+			entries, err := fs.ReadDir(ctx, "/")
+			if err != nil || len(entries) != 1 || entries[0].Name != "other.txt" {
+				t.Errorf("ReadDir(/) after Rmdir = %+v, %v; want other.txt alone", entries, err)
+			}
+		})
+	}
 }
@@ -0,0 +1,42 @@
+package fuse
+
+import "sync"
+
+// pathLocks hands out a mutex per path, so operations on one path take turns
+// while those on other paths go ahead. A path's entry is dropped once nobody
+// holds or waits for it. The zero value is ready to use
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+// pathLock is the mutex of one path
+type pathLock struct {
+	mu      sync.Mutex
+	holders int // Callers holding or waiting for mu
+}
+
+// lock locks path and returns the function that unlocks it
+func (p *pathLocks) lock(path string) func() {
+	p.mu.Lock()
+	if p.locks == nil {
+		p.locks = make(map[string]*pathLock)
+	}
+	l, ok := p.locks[path]
+	if !ok {
+		l = &pathLock{}
+		p.locks[path] = l
+	}
+	l.holders++
+	p.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if l.holders--; l.holders == 0 {
+			delete(p.locks, path)
+		}
+	}
+}
@@ -5,11 +5,113 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// Access mask bits (see access(2))
+const (
+	accessExecute uint32 = 1 // X_OK
+	accessWrite   uint32 = 2 // W_OK
+	accessRead    uint32 = 4 // R_OK
 )
 
+// callerKey is the context key for the identity of the process making a request
+type callerKey struct{}
+
+// caller identifies the process issuing a filesystem request
+type caller struct {
+	uid uint32
+	gid uint32
+}
+
+// WithCaller returns a context carrying the caller's uid/gid
+// The FUSE wrapper sets this from the request header
+func WithCaller(ctx context.Context, uid, gid uint32) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller{uid: uid, gid: gid})
+}
+
+// callerFromContext returns the caller's uid/gid
+// Falls back to the mounting process identity when no caller is recorded
+func callerFromContext(ctx context.Context) (uint32, uint32) {
+	if c, ok := ctx.Value(callerKey{}).(caller); ok {
+		return c.uid, c.gid
+	}
+	return uint32(os.Getuid()), uint32(os.Getgid())
+}
+
+// checkAccess reports whether uid/gid may access a file with the given attributes
+// mask is a combination of R_OK/W_OK/X_OK; returns EACCES when any bit is denied
+func checkAccess(attr *Attr, uid, gid uint32, mask uint32) error {
+	mask &= accessRead | accessWrite | accessExecute
+	if mask == 0 {
+		return nil
+	}
+
+	perm := uint32(attr.Mode.Perm())
+
+	// Root bypasses read/write checks; execute still needs some x bit (or a directory)
+	if uid == 0 {
+		if mask&accessExecute != 0 && !attr.Mode.IsDir() && perm&0111 == 0 {
+			return syscall.EACCES
+		}
+		return nil
+	}
+
+	// Pick the permission class: owner, group, then other
+	var granted uint32
+	switch {
+	case uid == attr.Uid:
+		granted = (perm >> 6) & 07
+	case gid == attr.Gid:
+		granted = (perm >> 3) & 07
+	default:
+		granted = perm & 07
+	}
+
+	if mask&granted != mask {
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// SetUmask clears the permission bits in mask from the mode of every file and
+// directory, like s3fs-fuse's -o umask. Only reported modes change; stored
+// modes are left as they are
+func (fs *Filesystem) SetUmask(mask os.FileMode) {
+	fs.umask = mask & os.ModePerm
+}
+
+// SetForbiddenMode sets mode bits that Chmod, Create and Mkdir may not set,
+// such as os.ModeSetuid or world write (0002). Requests for them fail with
+// EPERM if reject is set, and otherwise have the bits cleared
+func (fs *Filesystem) SetForbiddenMode(bits os.FileMode, reject bool) {
+	fs.forbiddenMode = bits
+	fs.rejectForbidden = reject
+}
+
+// allowedMode applies the forbidden mode bits to a requested mode
+func (fs *Filesystem) allowedMode(mode os.FileMode) (os.FileMode, error) {
+	if mode&fs.forbiddenMode == 0 {
+		return mode, nil
+	}
+	if fs.rejectForbidden {
+		return 0, syscall.EPERM
+	}
+	return mode &^ fs.forbiddenMode, nil
+}
+
 // Chmod changes file permissions
 func (fs *Filesystem) Chmod(ctx context.Context, path string, mode os.FileMode) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
+	mode, err := fs.allowedMode(mode)
+	if err != nil {
+		return err
+	}
 	normalizedPath := fs.normalizePath(path)
 	
 	backend := fs.getBackend()
@@ -36,32 +138,31 @@ func (fs *Filesystem) Chmod(ctx context.Context, path string, mode os.FileMode)
 		}
 	}
 
-	// For directories, update .keep marker
+	// For directories, update the directory marker
 	if attr.Mode.IsDir() {
 		if !strings.HasSuffix(normalizedPath, "/") {
 			normalizedPath += "/"
 		}
-		keepPath := normalizedPath + ".keep"
 		
 		// Get current metadata or create new
-		keepAttr, err := backend.GetAttr(ctx, keepPath)
+		keepPath, keepAttr, err := fs.dirMarker(ctx, backend, normalizedPath)
 		metadata := make(map[string]string)
 		if err == nil {
 			// Convert attributes to metadata map
-			metadata["mode"] = fmt.Sprintf("%o", keepAttr.Mode)
+			metadata["mode"] = types.EncodeMode(os.ModeDir | os.FileMode(keepAttr.Mode))
 			metadata["uid"] = fmt.Sprintf("%d", keepAttr.Uid)
 			metadata["gid"] = fmt.Sprintf("%d", keepAttr.Gid)
 			metadata["mtime"] = fmt.Sprintf("%d", keepAttr.Mtime.Unix())
 		}
 		
-		modeStr := fmt.Sprintf("%04o", mode&0777)
+		modeStr := types.EncodeMode(os.ModeDir | mode&types.PermissionBits)
 		now := time.Now()
 		metadata["x-amz-meta-mode"] = modeStr
 		metadata["mode"] = modeStr
 		metadata["x-amz-meta-ctime"] = fmt.Sprintf("%d", now.Unix())
 		metadata["ctime"] = fmt.Sprintf("%d", now.Unix())
 		
-		err = backend.WriteWithMetadata(ctx, keepPath, []byte{}, metadata)
+		err = writeDirMarker(ctx, backend, keepPath, metadata)
 		if err != nil {
 			return fmt.Errorf("failed to update directory mode: %w", err)
 		}
@@ -81,40 +182,21 @@ func (fs *Filesystem) Chmod(ctx context.Context, path string, mode os.FileMode)
 	}
 	// Convert attributes to metadata map
 	currentMetadata := make(map[string]string)
-	currentMetadata["mode"] = fmt.Sprintf("%o", fileAttr.Mode)
+	currentMetadata["mode"] = types.EncodeMode(os.FileMode(fileAttr.Mode))
 	currentMetadata["uid"] = fmt.Sprintf("%d", fileAttr.Uid)
 	currentMetadata["gid"] = fmt.Sprintf("%d", fileAttr.Gid)
 	currentMetadata["mtime"] = fmt.Sprintf("%d", fileAttr.Mtime.Unix())
 
-	// Update mode in metadata
-	modeStr := fmt.Sprintf("%04o", mode&0777)
-	now := time.Now()
-	// Ensure time is at least 1 second after the current mtime to guarantee update
-	if currentMtimeStr, ok := currentMetadata["mtime"]; ok {
-		if currentMtimeStr != "" {
-			var currentMtimeUnix int64
-			if _, err := fmt.Sscanf(currentMtimeStr, "%d", &currentMtimeUnix); err == nil {
-				currentMtime := time.Unix(currentMtimeUnix, 0)
-				if !now.After(currentMtime) {
-					now = currentMtime.Add(time.Second)
-				}
-			}
-		}
-	}
+	// Update mode in metadata; a mode change bumps ctime but leaves mtime alone
+	modeStr := types.EncodeMode(os.FileMode(fileAttr.Mode)&os.ModeType | mode&types.PermissionBits)
+	now := nextTimestamp(ctimeOf(fileAttr))
 	currentMetadata["x-amz-meta-mode"] = modeStr
 	currentMetadata["mode"] = modeStr // Also set without prefix
 	currentMetadata["x-amz-meta-ctime"] = fmt.Sprintf("%d", now.Unix())
 	currentMetadata["ctime"] = fmt.Sprintf("%d", now.Unix())
-	// Also update mtime so GetAttr reflects the change (tests use mtime as proxy for ctime)
-	currentMetadata["x-amz-meta-mtime"] = fmt.Sprintf("%d", now.Unix())
-	currentMetadata["mtime"] = fmt.Sprintf("%d", now.Unix())
 
-	// Read existing data, then write back with new metadata
-	existingData, err := backend.Read(ctx, normalizedPath)
-	if err != nil {
-		return fmt.Errorf("failed to read file for metadata update: %w", err)
-	}
-	err = backend.WriteWithMetadata(ctx, normalizedPath, existingData, currentMetadata)
+	// Replace metadata without re-uploading the content
+	err = fs.updateMetadata(ctx, backend, normalizedPath, fileAttr, currentMetadata)
 	if err != nil {
 		return fmt.Errorf("failed to update file mode: %w", err)
 	}
@@ -129,6 +211,13 @@ func (fs *Filesystem) Chmod(ctx context.Context, path string, mode os.FileMode)
 
 // Chown changes file ownership
 func (fs *Filesystem) Chown(ctx context.Context, path string, uid, gid uint32) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
+	// With an owner override active, the forced owner cannot be changed
+	if (fs.forceUID != nil && uid != *fs.forceUID) || (fs.forceGID != nil && gid != *fs.forceGID) {
+		return syscall.EPERM
+	}
 	normalizedPath := fs.normalizePath(path)
 	
 	backend := fs.getBackend()
@@ -155,19 +244,18 @@ func (fs *Filesystem) Chown(ctx context.Context, path string, uid, gid uint32) e
 		}
 	}
 
-	// For directories, update .keep marker
+	// For directories, update the directory marker
 	if attr.Mode.IsDir() {
 		if !strings.HasSuffix(normalizedPath, "/") {
 			normalizedPath += "/"
 		}
-		keepPath := normalizedPath + ".keep"
 		
 		// Get current metadata or create new
-		keepAttr, err := backend.GetAttr(ctx, keepPath)
+		keepPath, keepAttr, err := fs.dirMarker(ctx, backend, normalizedPath)
 		metadata := make(map[string]string)
 		if err == nil {
 			// Convert attributes to metadata map
-			metadata["mode"] = fmt.Sprintf("%o", keepAttr.Mode)
+			metadata["mode"] = types.EncodeMode(os.ModeDir | os.FileMode(keepAttr.Mode))
 			metadata["uid"] = fmt.Sprintf("%d", keepAttr.Uid)
 			metadata["gid"] = fmt.Sprintf("%d", keepAttr.Gid)
 			metadata["mtime"] = fmt.Sprintf("%d", keepAttr.Mtime.Unix())
@@ -181,7 +269,7 @@ func (fs *Filesystem) Chown(ctx context.Context, path string, uid, gid uint32) e
 		metadata["x-amz-meta-ctime"] = fmt.Sprintf("%d", now.Unix())
 		metadata["ctime"] = fmt.Sprintf("%d", now.Unix())
 		
-		err = backend.WriteWithMetadata(ctx, keepPath, []byte{}, metadata)
+		err = writeDirMarker(ctx, backend, keepPath, metadata)
 		if err != nil {
 			return fmt.Errorf("failed to update directory ownership: %w", err)
 		}
@@ -201,41 +289,22 @@ func (fs *Filesystem) Chown(ctx context.Context, path string, uid, gid uint32) e
 	}
 	// Convert attributes to metadata map
 	currentMetadata := make(map[string]string)
-	currentMetadata["mode"] = fmt.Sprintf("%o", fileAttr.Mode)
+	currentMetadata["mode"] = types.EncodeMode(os.FileMode(fileAttr.Mode))
 	currentMetadata["uid"] = fmt.Sprintf("%d", fileAttr.Uid)
 	currentMetadata["gid"] = fmt.Sprintf("%d", fileAttr.Gid)
 	currentMetadata["mtime"] = fmt.Sprintf("%d", fileAttr.Mtime.Unix())
 
-	// Update ownership in metadata
-	now := time.Now()
-	// Ensure time is at least 1 second after the current mtime to guarantee update
-	if currentMtimeStr, ok := currentMetadata["mtime"]; ok {
-		if currentMtimeStr != "" {
-			var currentMtimeUnix int64
-			if _, err := fmt.Sscanf(currentMtimeStr, "%d", &currentMtimeUnix); err == nil {
-				currentMtime := time.Unix(currentMtimeUnix, 0)
-				if !now.After(currentMtime) {
-					now = currentMtime.Add(time.Second)
-				}
-			}
-		}
-	}
+	// Update ownership in metadata; an owner change bumps ctime but leaves mtime alone
+	now := nextTimestamp(ctimeOf(fileAttr))
 	currentMetadata["x-amz-meta-uid"] = fmt.Sprintf("%d", uid)
 	currentMetadata["uid"] = fmt.Sprintf("%d", uid)
 	currentMetadata["x-amz-meta-gid"] = fmt.Sprintf("%d", gid)
 	currentMetadata["gid"] = fmt.Sprintf("%d", gid)
 	currentMetadata["x-amz-meta-ctime"] = fmt.Sprintf("%d", now.Unix())
 	currentMetadata["ctime"] = fmt.Sprintf("%d", now.Unix())
-	// Also update mtime so GetAttr reflects the change (tests use mtime as proxy for ctime)
-	currentMetadata["x-amz-meta-mtime"] = fmt.Sprintf("%d", now.Unix())
-	currentMetadata["mtime"] = fmt.Sprintf("%d", now.Unix())
 
-	// Read existing data, then write back with new metadata
-	existingData, err := backend.Read(ctx, normalizedPath)
-	if err != nil {
-		return fmt.Errorf("failed to read file for metadata update: %w", err)
-	}
-	err = backend.WriteWithMetadata(ctx, normalizedPath, existingData, currentMetadata)
+	// Replace metadata without re-uploading the content
+	err = fs.updateMetadata(ctx, backend, normalizedPath, fileAttr, currentMetadata)
 	if err != nil {
 		return fmt.Errorf("failed to update file ownership: %w", err)
 	}
@@ -2,10 +2,16 @@ package fuse
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"syscall"
 	"testing"
+	"time"
 
+	"bazil.org/fuse"
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 // TestChmod tests changing file permissions
@@ -189,3 +195,453 @@ func TestChownMountpoint(t *testing.T) {
 		t.Logf("GID unchanged (may not be supported for directories): expected %d, got %d", newGid, attr2.Gid)
 	}
 }
+
+// TestAccessPermissions tests that Access honors mode, uid and gid
+func TestAccessPermissions(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	testFile := "test-access.txt"
+	if err := fs.WriteFile(ctx, testFile, []byte("secret"), 0); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Chown(ctx, testFile, 1000, 1000); err != nil {
+		t.Fatalf("Failed to chown: %v", err)
+	}
+	if err := fs.Chmod(ctx, testFile, 0400); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		uid, gid uint32
+		mask     uint32
+		expected error
+	}{
+		{"owner read", 1000, 1000, 4, nil},
+		{"owner write", 1000, 1000, 2, syscall.EACCES},
+		{"owner read-write", 1000, 1000, 6, syscall.EACCES},
+		{"owner exists", 1000, 1000, 0, nil},
+		{"group read", 2000, 1000, 4, syscall.EACCES},
+		{"other read", 2000, 2000, 4, syscall.EACCES},
+		{"other exists", 2000, 2000, 0, nil},
+		{"root read-write", 0, 0, 6, nil},
+		{"root execute", 0, 0, 1, syscall.EACCES},
+	}
+
+	for _, tt := range tests {
+		err := fs.Access(WithCaller(ctx, tt.uid, tt.gid), testFile, tt.mask)
+		if err != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+
+	// Group and other classes are checked independently of the owner bits
+	if err := fs.Chmod(ctx, testFile, 0604); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	if err := fs.Access(WithCaller(ctx, 2000, 2000), testFile, 4); err != nil {
+		t.Errorf("other read on 0604: expected nil, got %v", err)
+	}
+	if err := fs.Access(WithCaller(ctx, 2000, 1000), testFile, 4); err != syscall.EACCES {
+		t.Errorf("group read on 0604: expected EACCES, got %v", err)
+	}
+}
+
+// TestOpenPermissions tests that opening a file checks the caller against its
+// mode and owner
+func TestOpenPermissions(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := filesystem.WriteFile(ctx, "/private.txt", []byte("secret"), 0); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := filesystem.Chown(ctx, "/private.txt", 1000, 1000); err != nil {
+		t.Fatalf("Failed to chown: %v", err)
+	}
+	if err := filesystem.Chmod(ctx, "/private.txt", 0600); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	file := &File{filesystem: filesystem, path: "/private.txt"}
+	open := func(uid, gid uint32, flags fuse.OpenFlags) error {
+		req := &fuse.OpenRequest{Header: fuse.Header{Uid: uid, Gid: gid}, Flags: flags}
+		_, err := file.Open(ctx, req, &fuse.OpenResponse{})
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		uid, gid uint32
+		flags    fuse.OpenFlags
+		expected error
+	}{
+		{"owner read", 1000, 1000, fuse.OpenReadOnly, nil},
+		{"owner read-write", 1000, 1000, fuse.OpenReadWrite, nil},
+		{"group read", 2000, 1000, fuse.OpenReadOnly, syscall.EACCES},
+		{"other read", 2000, 2000, fuse.OpenReadOnly, syscall.EACCES},
+		{"other write", 2000, 2000, fuse.OpenWriteOnly, syscall.EACCES},
+		{"root read-write", 0, 0, fuse.OpenReadWrite, nil},
+	}
+	for _, tt := range tests {
+		if err := open(tt.uid, tt.gid, tt.flags); err != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+
+	// O_TRUNC needs write permission even on a read-only open
+	if err := filesystem.Chmod(ctx, "/private.txt", 0400); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	if err := open(1000, 1000, fuse.OpenReadOnly|fuse.OpenTruncate); err != syscall.EACCES {
+		t.Errorf("owner read with O_TRUNC on 0400: expected EACCES, got %v", err)
+	}
+
+	// So does truncating by path
+	req := &fuse.SetattrRequest{Header: fuse.Header{Uid: 2000, Gid: 2000}, Valid: fuse.SetattrSize}
+	if err := file.Setattr(ctx, req, &fuse.SetattrResponse{}); err != syscall.EACCES {
+		t.Errorf("truncate by other: expected EACCES, got %v", err)
+	}
+	if data, _ := client.GetObject(ctx, "private.txt"); string(data) != "secret" {
+		t.Errorf("Denied truncate changed the file to %q", data)
+	}
+}
+
+// TestUmask tests that -umask clears bits from reported modes only
+func TestUmask(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := fs.Create(ctx, "shared.txt", 0666); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "shared-dir", 0777); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := client.PutObject(ctx, "external.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	fs.SetUmask(0077)
+
+	tests := []struct {
+		path     string
+		expected os.FileMode
+	}{
+		{"shared.txt", 0600},
+		{"shared-dir", os.ModeDir | 0700},
+		{"external.txt", 0600},
+	}
+	for _, tt := range tests {
+		attr, err := fs.GetAttr(ctx, tt.path)
+		if err != nil {
+			t.Fatalf("Failed to get attributes for %s: %v", tt.path, err)
+		}
+		if attr.Mode != tt.expected {
+			t.Errorf("%s: expected mode %v, got %v", tt.path, tt.expected, attr.Mode)
+		}
+	}
+
+	metadata, err := client.HeadObject(ctx, "shared.txt")
+	if err != nil {
+		t.Fatalf("Failed to head object: %v", err)
+	}
+	if mode := metadata["x-amz-meta-mode"]; mode != types.EncodeMode(0666) {
+		t.Errorf("Expected stored mode to stay %s, got %s", types.EncodeMode(0666), mode)
+	}
+}
+
+// TestForceOwner tests the uid/gid override
+func TestForceOwner(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	// Object stored by someone else
+	err := client.PutObjectWithMetadata(ctx, "foreign.txt", []byte("data"), map[string]string{
+		"uid": "4242",
+		"gid": "4343",
+	})
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	fs.SetForceUID(1500)
+	fs.SetForceGID(1600)
+
+	attr, err := fs.GetAttr(ctx, "foreign.txt")
+	if err != nil {
+		t.Fatalf("Failed to get attributes: %v", err)
+	}
+	if attr.Uid != 1500 || attr.Gid != 1600 {
+		t.Errorf("Expected forced owner 1500:1600, got %d:%d", attr.Uid, attr.Gid)
+	}
+
+	// New objects are stored with the forced owner
+	if err := fs.Create(ctx, "created.txt", 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "created-dir", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, key := range []string{"created.txt", "created-dir/"} {
+		metadata, err := client.HeadObject(ctx, key)
+		if err != nil {
+			t.Fatalf("Failed to head %s: %v", key, err)
+		}
+		if metadata["x-amz-meta-uid"] != "1500" || metadata["x-amz-meta-gid"] != "1600" {
+			t.Errorf("%s: expected stored owner 1500:1600, got %s:%s", key, metadata["x-amz-meta-uid"], metadata["x-amz-meta-gid"])
+		}
+	}
+
+	// Chown to a different owner is rejected; chown to the forced owner is a valid no-op
+	if err := fs.Chown(ctx, "foreign.txt", 1000, 1000); err != syscall.EPERM {
+		t.Errorf("Expected EPERM for chown with override active, got %v", err)
+	}
+	if err := fs.Chown(ctx, "foreign.txt", 1500, 1600); err != nil {
+		t.Errorf("Expected chown to the forced owner to succeed, got %v", err)
+	}
+}
+
+// TestDefaultModes tests the modes reported for objects created outside the filesystem
+func TestDefaultModes(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	// Objects without any mode metadata, as written by other S3 tools
+	for _, key := range []string{"external.txt", "external-dir/child.txt", "marked-dir/.keep"} {
+		if err := client.PutObject(ctx, key, []byte("data")); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	// Object with stored mode keeps it
+	err := client.PutObjectWithMetadata(ctx, "stored.txt", []byte("data"), map[string]string{"mode": "0640"})
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	fs.SetDefaultFileMode(0600)
+	fs.SetDefaultDirMode(0700)
+
+	tests := []struct {
+		path     string
+		expected os.FileMode
+	}{
+		{"external.txt", 0600},
+		{"external-dir", os.ModeDir | 0700},
+		{"marked-dir/", os.ModeDir | 0700},
+		{"stored.txt", 0640},
+	}
+	for _, tt := range tests {
+		attr, err := fs.GetAttr(ctx, tt.path)
+		if err != nil {
+			t.Fatalf("Failed to get attributes for %s: %v", tt.path, err)
+		}
+		if attr.Mode != tt.expected {
+			t.Errorf("%s: expected mode %v, got %v", tt.path, tt.expected, attr.Mode)
+		}
+	}
+}
+
+func TestForbiddenMode(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+	forbidden := os.ModeSetuid | os.ModeSetgid | 0002
+
+	// Cleared by default
+	fs.SetForbiddenMode(forbidden, false)
+	if err := fs.Create(ctx, "/shared.txt", 0666); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/shared-dir", os.ModeSetgid|0777); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.Create(ctx, "/tool", 0755); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Chmod(ctx, "/tool", os.ModeSetuid|0755); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	tests := []struct {
+		path     string
+		expected os.FileMode
+	}{
+		{"/shared.txt", 0664},
+		{"/tool", 0755},
+	}
+	for _, tt := range tests {
+		attr, err := fs.GetAttr(ctx, tt.path)
+		if err != nil {
+			t.Fatalf("Failed to get attributes for %s: %v", tt.path, err)
+		}
+		if attr.Mode != tt.expected {
+			t.Errorf("%s: expected mode %v, got %v", tt.path, tt.expected, attr.Mode)
+		}
+	}
+	// The marker holds the directory's mode, file type included
+	info, err := client.HeadObjectFull(ctx, "shared-dir/")
+	if err != nil {
+		t.Fatalf("Failed to head directory marker: %v", err)
+	}
+	if mode, _ := types.DecodeMode(info.Metadata["x-amz-meta-mode"]); mode != os.ModeDir|0775 {
+		t.Errorf("Directory stored with mode %q, want %v", info.Metadata["x-amz-meta-mode"], os.ModeDir|0775)
+	}
+
+	// Refused when rejecting
+	fs.SetForbiddenMode(forbidden, true)
+	if err := fs.Chmod(ctx, "/tool", os.ModeSetuid|0755); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("Chmod to setuid = %v, want EPERM", err)
+	}
+	if err := fs.Create(ctx, "/open.txt", 0666); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("Create world-writable = %v, want EPERM", err)
+	}
+	if _, err := fs.GetAttr(ctx, "/open.txt"); err == nil {
+		t.Error("Refused Create left a file behind")
+	}
+	if err := fs.Mkdir(ctx, "/open-dir", 0777); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("Mkdir world-writable = %v, want EPERM", err)
+	}
+	if err := fs.Chmod(ctx, "/tool", 0750); err != nil {
+		t.Errorf("Chmod to an allowed mode failed: %v", err)
+	}
+}
+
+// largeObjectClient fails every single CopyObject the way S3 does for sources
+// over 5GB and records the objects copied in parts
+type largeObjectClient struct {
+	*s3client.MockClient
+	multipartCopies []string
+}
+
+func (c *largeObjectClient) CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	return fmt.Errorf("failed to copy object with metadata: %w", s3client.ErrCopyTooLarge)
+}
+
+func (c *largeObjectClient) CopyObjectMultipartWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	c.multipartCopies = append(c.multipartCopies, sourceKey)
+	return c.MockClient.CopyObjectMultipartWithMetadata(ctx, sourceKey, destKey, metadata)
+}
+
+// TestMetadataChangesLeaveContentInStorage tests that changing a file's
+// metadata never downloads or uploads its content
+func TestMetadataChangesLeaveContentInStorage(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	fs.SetAtimeMode(AtimeOff)
+	ctx := context.Background()
+	if err := client.PutObject(ctx, "big.bin", make([]byte, 100*1024*1024)); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	// The kernel looks a file up before changing it
+	if _, err := fs.GetAttr(ctx, "/big.bin"); err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	gets, uploaded, heads := client.GetCount(), client.UploadedBytes(), client.HeadCount()
+	if err := fs.Chmod(ctx, "/big.bin", 0600); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	// The stat Chmod makes already has the content type and storage class
+	// the copy must keep, so the copy itself doesn't look at the object
+	if n := client.HeadCount() - heads; n != 1 {
+		t.Errorf("Chmod made %d HEAD requests, want 1", n)
+	}
+	if err := fs.Chown(ctx, "/big.bin", 1000, 1000); err != nil {
+		t.Fatalf("Chown failed: %v", err)
+	}
+	if err := fs.Utimens(ctx, "/big.bin", time.Now(), time.Now()); err != nil {
+		t.Fatalf("Utimens failed: %v", err)
+	}
+	if err := fs.SetXattr(ctx, "/big.bin", "user.tag", []byte("value")); err != nil {
+		t.Fatalf("SetXattr failed: %v", err)
+	}
+	if err := fs.RemoveXattr(ctx, "/big.bin", "user.tag"); err != nil {
+		t.Fatalf("RemoveXattr failed: %v", err)
+	}
+	if n := client.GetCount() - gets; n != 0 {
+		t.Errorf("Metadata changes made %d GetObject calls, want 0", n)
+	}
+	if n := client.UploadedBytes() - uploaded; n != 0 {
+		t.Errorf("Metadata changes uploaded %d bytes, want 0", n)
+	}
+	if attr, err := fs.GetAttr(ctx, "/big.bin"); err != nil || attr.Mode.Perm() != 0600 || attr.Uid != 1000 || attr.Size != 100*1024*1024 {
+		t.Errorf("GetAttr after metadata changes = %+v, %v", attr, err)
+	}
+
+	// Above 5GB a single CopyObject can't copy the object onto itself
+	large := &largeObjectClient{MockClient: s3client.NewMockClient("test-bucket", "us-east-1")}
+	large.PutObject(ctx, "huge.bin", []byte("content"))
+	if err := NewFilesystem(large).Chmod(ctx, "/huge.bin", 0600); err != nil {
+		t.Fatalf("Chmod of a large object failed: %v", err)
+	}
+	if len(large.multipartCopies) != 1 {
+		t.Errorf("Chmod of a large object made multipart copies %v, want one", large.multipartCopies)
+	}
+}
+
+// TestStoredModeRoundTrip tests that modes, with their file type and setuid,
+// setgid and sticky bits, are stored as the decimal st_mode C++ s3fs uses and
+// read back unchanged
+func TestStoredModeRoundTrip(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := fs.Create(ctx, "/file.txt", 0640); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Create(ctx, "/tool", 0755); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Chmod(ctx, "/tool", os.ModeSetuid|os.ModeSetgid|0755); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/dir", os.ModeDir|0750); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/tmp", os.ModeDir|0777); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.Chmod(ctx, "/tmp", os.ModeSticky|0777); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.Symlink(ctx, "file.txt", "/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		key    string
+		mode   os.FileMode
+		stored string // Decimal st_mode, as C++ s3fs stores it
+	}{
+		{"/file.txt", "file.txt", 0640, "33184"},
+		{"/tool", "tool", os.ModeSetuid | os.ModeSetgid | 0755, "36333"},
+		{"/dir", "dir/", os.ModeDir | 0750, "16872"},
+		{"/tmp", "tmp/", os.ModeDir | os.ModeSticky | 0777, "17407"},
+		{"/link", "link", os.ModeSymlink | 0777, "41471"},
+	}
+	// A second filesystem has nothing cached, so it reads the stored modes
+	fresh := NewFilesystem(client)
+	for _, tt := range tests {
+		info, err := client.HeadObjectFull(ctx, tt.key)
+		if err != nil {
+			t.Fatalf("HeadObjectFull(%s) failed: %v", tt.key, err)
+		}
+		if stored := info.Metadata["mode"]; stored != tt.stored {
+			t.Errorf("%s stored with mode %q, want %q", tt.path, stored, tt.stored)
+		}
+		attr, err := fresh.GetAttr(ctx, tt.path)
+		if err != nil {
+			t.Fatalf("GetAttr(%s) failed: %v", tt.path, err)
+		}
+		if attr.Mode != tt.mode {
+			t.Errorf("%s: mode %v, want %v", tt.path, attr.Mode, tt.mode)
+		}
+	}
+}
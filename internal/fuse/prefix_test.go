@@ -0,0 +1,211 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/compress"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/encrypt"
+)
+
+// newPrefixFilesystem mounts "team-a/data" of a bucket that also holds the
+// sibling prefix "team-a/data2" and another team's files
+func newPrefixFilesystem(t *testing.T) (*Filesystem, *s3client.MockClient) {
+	t.Helper()
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	for _, obj := range []struct {
+		key, data string
+		metadata  map[string]string
+	}{
+		{"team-a/data/", "", map[string]string{"content-type": dirContentType, "mode": "16872", "uid": "1000"}},
+		{"team-a/data/a.txt", "aaaa", nil},
+		{"team-a/data/sub/b.txt", "bb", nil},
+		{"team-a/data2/secret.txt", "sibling", nil},
+		{"team-b/c.txt", "other team", nil},
+	} {
+		if err := client.PutObjectWithMetadata(ctx, obj.key, []byte(obj.data), obj.metadata); err != nil {
+			t.Fatalf("PutObjectWithMetadata failed: %v", err)
+		}
+	}
+	fs, err := NewFilesystemWithPrefix(client, "/team-a/data")
+	if err != nil {
+		t.Fatalf("NewFilesystemWithPrefix failed: %v", err)
+	}
+	return fs, client
+}
+
+// keysUnder lists the bucket's keys under prefix
+func keysUnder(t *testing.T, client *s3client.MockClient, prefix string) []string {
+	t.Helper()
+	keys, err := client.ListObjects(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestParseBucketPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, want string
+		wantErr      bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"team-a", "team-a/", false},
+		{"/team-a/data/", "team-a/data/", false},
+		{"team-a//data", "", true},
+		{"team-a/../team-b", "", true},
+		{"./team-a", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBucketPrefix(tt.prefix)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBucketPrefix(%q) = %q, %v; want %q (error %v)", tt.prefix, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPrefixMountListsOnlyPrefix(t *testing.T) {
+	fs, _ := newPrefixFilesystem(t)
+	ctx := context.Background()
+
+	entries, err := fs.ReadDir(ctx, "/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	want := []DirEntry{{Name: "a.txt"}, {Name: "sub", IsDir: true}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Expected the root to list %v, got %v", want, entries)
+	}
+
+	for _, path := range []string{"/team-a/data2/secret.txt", "/data2/secret.txt", "/team-b/c.txt"} {
+		if _, err := fs.GetAttr(ctx, path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected %s to be invisible, got %v", path, err)
+		}
+	}
+	data, err := fs.ReadFile(ctx, "/sub/b.txt", 0, 10)
+	if err != nil || string(data) != "bb" {
+		t.Errorf("Expected to read bb from /sub/b.txt, got %q, %v", data, err)
+	}
+}
+
+func TestPrefixMountRoot(t *testing.T) {
+	fs, client := newPrefixFilesystem(t)
+	ctx := context.Background()
+
+	// The "team-a/data/" marker holds the root's attributes
+	attr, err := fs.GetAttr(ctx, "/")
+	if err != nil {
+		t.Fatalf("GetAttr of the root failed: %v", err)
+	}
+	if !attr.Mode.IsDir() || attr.Mode.Perm() != 0750 || attr.Uid != 1000 {
+		t.Errorf("Expected the root as a 0750 directory owned by 1000, got %v owned by %d", attr.Mode, attr.Uid)
+	}
+
+	if err := fs.Chmod(ctx, "/", 0700); err != nil {
+		t.Fatalf("Chmod of the root failed: %v", err)
+	}
+	metadata, err := client.HeadObject(ctx, "team-a/data/")
+	if err != nil {
+		t.Fatalf("HeadObject of the root marker failed: %v", err)
+	}
+	if metadata["mode"] != "16832" {
+		t.Errorf("Expected chmod to update the root marker, got metadata %v", metadata)
+	}
+	if keys := keysUnder(t, client, "team-a/data/.keep"); len(keys) != 0 {
+		t.Errorf("Expected no .keep next to the root marker, got %v", keys)
+	}
+
+	if err := fs.SetUsageTracking(ctx, 0); err != nil {
+		t.Fatalf("SetUsageTracking failed: %v", err)
+	}
+	fs.waitUsageScan(ctx)
+	if used, _ := fs.UsedBytes(); used != 6 {
+		t.Errorf("Expected the 6 bytes under the prefix to be counted, got %d", used)
+	}
+}
+
+func TestPrefixMountWritesStayUnderPrefix(t *testing.T) {
+	fs, client := newPrefixFilesystem(t)
+	ctx := context.Background()
+
+	if err := fs.Create(ctx, "/new.txt", 0644); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.WriteFile(ctx, "/new.txt", []byte("new"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.Flush(ctx, "/new.txt"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.Rename(ctx, "/a.txt", "/dir/a.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := fs.Rename(ctx, "/sub", "/moved"); err != nil {
+		t.Fatalf("Rename of a directory failed: %v", err)
+	}
+	if err := fs.Remove(ctx, "/new.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	want := []string{"team-a/data/", "team-a/data/dir/", "team-a/data/dir/a.txt", "team-a/data/moved/b.txt"}
+	if got := keysUnder(t, client, "team-a/data/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected keys %v under the prefix, got %v", want, got)
+	}
+	want = []string{"team-a/data/", "team-a/data/dir/", "team-a/data/dir/a.txt", "team-a/data/moved/b.txt", "team-a/data2/secret.txt", "team-b/c.txt"}
+	if got := keysUnder(t, client, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected only the prefix to change, got keys %v", got)
+	}
+}
+
+func TestPrefixMountThroughWrappers(t *testing.T) {
+	_, client := newPrefixFilesystem(t)
+	ctx := context.Background()
+	adapter, err := newPrefixedS3Adapter(client, "team-a/data")
+	if err != nil {
+		t.Fatalf("newPrefixedS3Adapter failed: %v", err)
+	}
+	encrypted, err := encrypt.NewEncryptBackend(adapter, bytes.Repeat([]byte{1}, encrypt.KeySize))
+	if err != nil {
+		t.Fatalf("NewEncryptBackend failed: %v", err)
+	}
+	fs := NewFilesystemWithBackend(compress.NewCompressBackend(encrypted, compress.Zstd))
+
+	// The root marker and delimited listing reach through both wrappers
+	attr, err := fs.GetAttr(ctx, "/")
+	if err != nil || attr.Mode.Perm() != 0750 || attr.Uid != 1000 {
+		t.Errorf("Expected the root marker's 0750 owned by 1000, got %+v, %v", attr, err)
+	}
+	if err := fs.Create(ctx, "/sub/c.txt", 0644); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.WriteFile(ctx, "/sub/c.txt", []byte("secret"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.Flush(ctx, "/sub/c.txt"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// Directory renames copy server-side and still decrypt at the new path
+	if err := fs.Rename(ctx, "/sub", "/moved"); err != nil {
+		t.Fatalf("Rename of a directory failed: %v", err)
+	}
+	if data, err := fs.ReadFile(ctx, "/moved/c.txt", 0, 0); err != nil || string(data) != "secret" {
+		t.Errorf("Expected to read secret after the rename, got %q, %v", data, err)
+	}
+	want := []string{"team-a/data/moved/b.txt", "team-a/data/moved/c.txt"}
+	if got := keysUnder(t, client, "team-a/data/moved/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected keys %v, got %v", want, got)
+	}
+}
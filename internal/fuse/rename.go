@@ -0,0 +1,282 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// renameWorkers bounds how many object copies a directory rename runs at once
+const renameWorkers = 16
+
+// RenameError reports a directory rename that did not complete
+// Moved keys now exist only under the new name; NotMoved keys are still under the
+// old name (possibly with a copy already at the destination). Running the same
+// rename again on the same mount moves whatever is left; the partly filled
+// destination doesn't make the retry fail with ENOTEMPTY
+type RenameError struct {
+	Moved    []string // Source keys removed after being copied
+	NotMoved []string // Source keys still present
+	Err      error
+}
+
+func (e *RenameError) Error() string {
+	return fmt.Sprintf("rename moved %d of %d objects: %v", len(e.Moved), len(e.Moved)+len(e.NotMoved), e.Err)
+}
+
+func (e *RenameError) Unwrap() error {
+	return e.Err
+}
+
+// SetCaseInsensitive tells the filesystem that storage treats names differing
+// only in case as the same object, so renames between such names go through a
+// temporary name instead of copying the object onto itself
+func (fs *Filesystem) SetCaseInsensitive(enable bool) {
+	fs.caseInsensitive = enable
+}
+
+// renameCase renames a path to a name differing from it only in case, on
+// storage that ignores case. Both names are the same object there: copying it
+// onto itself and deleting the source would lose it, and the source would pass
+// for a destination in the way. So the source moves to a temporary name first,
+// and only what still answers to the new name then is treated as a destination
+func (fs *Filesystem) renameCase(ctx context.Context, oldPath, newPath string) error {
+	tmpPath := fmt.Sprintf("%s.s3fs-rename-%d", strings.TrimSuffix(oldPath, "/"), time.Now().UnixNano())
+	if err := fs.Rename(ctx, oldPath, tmpPath); err != nil {
+		return err
+	}
+	if fs.cache != nil {
+		// May have been cached from the source under the other case
+		newKey := strings.TrimSuffix(fs.normalizePath(newPath), "/")
+		fs.cache.GetStatCache().Delete(newKey)
+		fs.cache.GetStatCache().Delete("/" + newKey)
+	}
+	err := fs.Rename(ctx, tmpPath, newPath)
+	if err != nil {
+		if restoreErr := fs.Rename(ctx, tmpPath, oldPath); restoreErr != nil {
+			logging.Error("case-only rename left the source under a temporary name", "path", oldPath, "temp", tmpPath, "err", restoreErr)
+		}
+	}
+	return err
+}
+
+// renameTreeInPages moves everything under oldPrefix to newPrefix, listing and
+// moving listPageSize keys at a time so a huge tree is never listed up front
+// Each page is moved with renameTree. If one fails, the pages before it stay
+// moved and are added to the RenameError's Moved keys; keys not listed yet are
+// not reported. Backends that move a prefix themselves get the whole tree at once
+func renameTreeInPages(ctx context.Context, backend types.Backend, oldPrefix, newPrefix string) error {
+	if _, ok := backend.(types.PrefixRenamer); ok {
+		objects, err := backend.List(ctx, oldPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to list directory objects: %w", err)
+		}
+		return renameTree(ctx, backend, objects, oldPrefix, newPrefix)
+	}
+
+	var moved []string
+	err := types.ListFunc(ctx, backend, oldPrefix, listPageSize, func(objects []string) error {
+		if err := renameTree(ctx, backend, objects, oldPrefix, newPrefix); err != nil {
+			return err
+		}
+		moved = append(moved, objects...)
+		return nil
+	})
+	var renameErr *RenameError
+	if errors.As(err, &renameErr) {
+		renameErr.Moved = append(moved, renameErr.Moved...)
+		return renameErr
+	}
+	if err != nil {
+		if len(moved) > 0 {
+			return &RenameError{Moved: moved, Err: fmt.Errorf("failed to list directory objects: %w", err)}
+		}
+		return fmt.Errorf("failed to list directory objects: %w", err)
+	}
+	return nil
+}
+
+// renameTree moves every object in objects from under oldPrefix to newPrefix
+// Everything is copied and verified before any source is deleted; if a copy or the
+// verification fails, the copies made so far are removed and the source tree is
+// left untouched. Failures are returned as a *RenameError
+func renameTree(ctx context.Context, backend types.Backend, objects []string, oldPrefix, newPrefix string) error {
+	if renamer, ok := backend.(types.PrefixRenamer); ok {
+		return renameTreeByPrefix(ctx, backend, renamer, objects, oldPrefix, newPrefix)
+	}
+	copier, ok := backend.(types.Copier)
+	if !ok {
+		return renameTreeOneByOne(ctx, backend, objects, oldPrefix, newPrefix)
+	}
+
+	copied, err := copyTree(ctx, backend, copier, objects, oldPrefix, newPrefix)
+	if err != nil {
+		if rollbackErr := deleteMany(ctx, backend, copied); rollbackErr != nil {
+			err = fmt.Errorf("%w (rollback of copied objects failed: %v)", err, rollbackErr)
+		}
+		return &RenameError{NotMoved: objects, Err: err}
+	}
+
+	if err := deleteMany(ctx, backend, objects); err != nil {
+		remaining := make(map[string]bool)
+		var partial *types.DeleteManyError
+		if errors.As(err, &partial) {
+			for path := range partial.Failed {
+				remaining[path] = true
+			}
+		} else {
+			for _, src := range objects {
+				remaining[src] = true
+			}
+		}
+
+		renameErr := &RenameError{Err: fmt.Errorf("directory copied but old objects remain: %w", err)}
+		for _, src := range objects {
+			if remaining[src] {
+				renameErr.NotMoved = append(renameErr.NotMoved, src)
+			} else {
+				renameErr.Moved = append(renameErr.Moved, src)
+			}
+		}
+		return renameErr
+	}
+	return nil
+}
+
+// renameTreeByPrefix lets the backend move the tree itself
+// On failure the old prefix is listed again to report which objects moved
+func renameTreeByPrefix(ctx context.Context, backend types.Backend, renamer types.PrefixRenamer, objects []string, oldPrefix, newPrefix string) error {
+	err := renamer.RenamePrefix(ctx, oldPrefix, newPrefix)
+	if err == nil {
+		return nil
+	}
+
+	renameErr := &RenameError{NotMoved: objects, Err: err}
+	remaining, listErr := backend.List(ctx, oldPrefix)
+	if listErr != nil {
+		return renameErr // Assume nothing moved
+	}
+	left := make(map[string]bool, len(remaining))
+	for _, path := range remaining {
+		left[path] = true
+	}
+	renameErr.NotMoved = nil
+	for _, src := range objects {
+		if left[src] {
+			renameErr.NotMoved = append(renameErr.NotMoved, src)
+		} else {
+			renameErr.Moved = append(renameErr.Moved, src)
+		}
+	}
+	return renameErr
+}
+
+// copyTree copies and verifies objects with up to renameWorkers copies in flight
+// No new copies start after the first failure. Returns the destinations written
+// so far, which the caller removes if an error is returned
+func copyTree(ctx context.Context, backend types.Backend, copier types.Copier, objects []string, oldPrefix, newPrefix string) ([]string, error) {
+	copyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		copied   = make([]string, 0, len(objects))
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	workers := renameWorkers
+	if len(objects) < workers {
+		workers = len(objects)
+	}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range jobs {
+				dst := newPrefix + strings.TrimPrefix(src, oldPrefix)
+				if err := copier.Copy(copyCtx, src, dst); err != nil {
+					fail(fmt.Errorf("failed to copy object %s: %w", src, err))
+					continue
+				}
+				mu.Lock()
+				copied = append(copied, dst)
+				mu.Unlock()
+				if err := verifyCopy(copyCtx, backend, src, dst); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, src := range objects {
+		select {
+		case jobs <- src:
+		case <-copyCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil && len(copied) < len(objects) {
+		// Cancelled by the caller between copies
+		firstErr = ctx.Err()
+	}
+	return copied, firstErr
+}
+
+// verifyCopy checks that dst exists with the same size as src
+func verifyCopy(ctx context.Context, backend types.Backend, src, dst string) error {
+	srcAttr, err := backend.GetAttr(ctx, src)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy of %s: %w", src, err)
+	}
+	dstAttr, err := backend.GetAttr(ctx, dst)
+	if err != nil {
+		return fmt.Errorf("copy of %s missing at %s: %w", src, dst, err)
+	}
+	if dstAttr.Size != srcAttr.Size {
+		return fmt.Errorf("copy of %s has size %d, expected %d", src, dstAttr.Size, srcAttr.Size)
+	}
+	return nil
+}
+
+// renameTreeOneByOne renames objects individually for backends without Copy
+// On failure the objects already moved are renamed back
+func renameTreeOneByOne(ctx context.Context, backend types.Backend, objects []string, oldPrefix, newPrefix string) error {
+	for i, src := range objects {
+		dst := newPrefix + strings.TrimPrefix(src, oldPrefix)
+		if err := backend.Rename(ctx, src, dst); err != nil {
+			cause := fmt.Errorf("failed to rename object %s: %w", src, err)
+			for j := i - 1; j >= 0; j-- {
+				moved := newPrefix + strings.TrimPrefix(objects[j], oldPrefix)
+				if err := backend.Rename(ctx, moved, objects[j]); err != nil {
+					return &RenameError{
+						Moved:    objects[:j+1],
+						NotMoved: objects[j+1:],
+						Err:      fmt.Errorf("%w (failed to move %s back: %v)", cause, moved, err),
+					}
+				}
+			}
+			return &RenameError{NotMoved: objects, Err: cause}
+		}
+	}
+	return nil
+}
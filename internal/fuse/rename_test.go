@@ -0,0 +1,760 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// faultyCopyBackend fails or corrupts the copy of one source key
+type faultyCopyBackend struct {
+	*s3Adapter
+	failKey    string
+	corruptKey string
+}
+
+func (b *faultyCopyBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	switch srcPath {
+	case b.failKey:
+		return syscall.EIO
+	case b.corruptKey:
+		return b.s3Adapter.Write(ctx, dstPath, []byte("short"))
+	}
+	return b.s3Adapter.Copy(ctx, srcPath, dstPath)
+}
+
+// noCopyBackend hides Copy and fails the per-object Rename of one key
+type noCopyBackend struct {
+	types.Backend
+	failKey string
+}
+
+func (b *noCopyBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	if oldPath == b.failKey {
+		return syscall.EIO
+	}
+	return b.Backend.Rename(ctx, oldPath, newPath)
+}
+
+// slowCopyBackend delays each copy and records the most copies in flight at once
+type slowCopyBackend struct {
+	*s3Adapter
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (b *slowCopyBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	b.mu.Lock()
+	b.inFlight++
+	if b.inFlight > b.maxInFlight {
+		b.maxInFlight = b.inFlight
+	}
+	b.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+	err := b.s3Adapter.Copy(ctx, srcPath, dstPath)
+
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+	return err
+}
+
+// stuckDeleteBackend cannot delete the paths in stuck
+type stuckDeleteBackend struct {
+	*s3Adapter
+	stuck map[string]bool
+}
+
+func (b *stuckDeleteBackend) DeleteMany(ctx context.Context, paths []string) error {
+	failed := make(map[string]error)
+	var deletable []string
+	for _, path := range paths {
+		if b.stuck[path] {
+			failed[path] = syscall.EIO
+		} else {
+			deletable = append(deletable, path)
+		}
+	}
+	if err := b.s3Adapter.DeleteMany(ctx, deletable); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &types.DeleteManyError{Failed: failed}
+	}
+	return nil
+}
+
+func putTree(t *testing.T, client *s3client.MockClient, keys []string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := client.PutObject(context.Background(), key, []byte("content of "+key)); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+}
+
+func listSorted(client *s3client.MockClient, prefix string) []string {
+	keys, _ := client.ListObjects(context.Background(), prefix)
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRenameDirectoryRollback(t *testing.T) {
+	keys := []string{"src/.keep", "src/a.txt", "src/b.txt", "src/sub/c.txt"}
+	tests := []struct {
+		name    string
+		backend func(client *s3client.MockClient) types.Backend
+	}{
+		{"copy fails", func(client *s3client.MockClient) types.Backend {
+			return &faultyCopyBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), failKey: "src/b.txt"}
+		}},
+		{"verification fails", func(client *s3client.MockClient) types.Backend {
+			return &faultyCopyBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), corruptKey: "src/sub/c.txt"}
+		}},
+		{"rename without copy fails", func(client *s3client.MockClient) types.Backend {
+			return &noCopyBackend{Backend: newS3Adapter(client), failKey: "src/b.txt"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			putTree(t, client, keys)
+			fs := NewFilesystemWithBackend(tt.backend(client))
+			ctx := context.Background()
+
+			if err := fs.Rename(ctx, "/src", "/dst"); err == nil {
+				t.Fatal("Expected rename to fail")
+			}
+
+			if got := listSorted(client, "src/"); strings.Join(got, ",") != strings.Join(keys, ",") {
+				t.Errorf("Source tree changed: %v", got)
+			}
+			for _, key := range keys {
+				data, err := client.GetObject(ctx, key)
+				if err != nil || string(data) != "content of "+key {
+					t.Errorf("Source %s damaged: %q (err %v)", key, data, err)
+				}
+			}
+			if got := listSorted(client, "dst/"); len(got) != 0 {
+				t.Errorf("Destination should be rolled back, got %v", got)
+			}
+		})
+	}
+}
+
+func TestRenameEmptyDirectory(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := fs.Mkdir(ctx, "/empty", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.Rename(ctx, "/empty", "/moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	if got := listSorted(client, "empty/"); len(got) != 0 {
+		t.Errorf("Old marker remains: %v", got)
+	}
+	if got := listSorted(client, "moved/"); len(got) != 1 || got[0] != "moved/" {
+		t.Errorf("Expected moved/, got %v", got)
+	}
+	attr, err := fs.GetAttr(ctx, "/moved")
+	if err != nil || !attr.Mode.IsDir() {
+		t.Fatalf("Expected /moved to be a directory, got %v (err %v)", attr, err)
+	}
+	if _, err := fs.GetAttr(ctx, "/empty"); err == nil {
+		t.Error("Old directory still visible")
+	}
+}
+
+func TestRenameDirectoryConcurrent(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("big/file%03d.txt", i))
+	}
+	putTree(t, client, keys)
+	backend := &slowCopyBackend{s3Adapter: newS3Adapter(client).(*s3Adapter)}
+	fs := NewFilesystemWithBackend(backend)
+
+	if err := fs.Rename(context.Background(), "/big", "/moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	if got := listSorted(client, "moved/"); len(got) != len(keys) {
+		t.Errorf("Expected %d moved objects, got %d", len(keys), len(got))
+	}
+	if got := listSorted(client, "big/"); len(got) != 0 {
+		t.Errorf("Source objects remain: %v", got)
+	}
+	if backend.maxInFlight < 2 || backend.maxInFlight > renameWorkers {
+		t.Errorf("Expected between 2 and %d concurrent copies, got %d", renameWorkers, backend.maxInFlight)
+	}
+}
+
+func TestRenameDirectoryPartialDelete(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	keys := []string{"src/a.txt", "src/b.txt", "src/c.txt"}
+	putTree(t, client, keys)
+	backend := &stuckDeleteBackend{
+		s3Adapter: newS3Adapter(client).(*s3Adapter),
+		stuck:     map[string]bool{"src/b.txt": true},
+	}
+	fs := NewFilesystemWithBackend(backend)
+	ctx := context.Background()
+
+	err := fs.Rename(ctx, "/src", "/dst")
+	var renameErr *RenameError
+	if !errors.As(err, &renameErr) {
+		t.Fatalf("Expected *RenameError, got %v", err)
+	}
+	sort.Strings(renameErr.Moved)
+	if strings.Join(renameErr.Moved, ",") != "src/a.txt,src/c.txt" {
+		t.Errorf("Moved = %v", renameErr.Moved)
+	}
+	if strings.Join(renameErr.NotMoved, ",") != "src/b.txt" {
+		t.Errorf("NotMoved = %v", renameErr.NotMoved)
+	}
+
+	// Once the fault clears, repeating the rename finishes the move
+	backend.stuck = nil
+	if err := fs.Rename(ctx, "/src", "/dst"); err != nil {
+		t.Fatalf("Retried rename failed: %v", err)
+	}
+	if got := listSorted(client, "src/"); len(got) != 0 {
+		t.Errorf("Source objects remain: %v", got)
+	}
+	want := "dst/a.txt,dst/b.txt,dst/c.txt"
+	if got := listSorted(client, "dst/"); strings.Join(got, ",") != want {
+		t.Errorf("Destination = %v, want %s", got, want)
+	}
+}
+
+func TestRenameOntoExisting(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		src     string
+		dst     string
+		wantErr error
+	}{
+		{"file over file", []string{"a.txt", "b.txt"}, "/a.txt", "/b.txt", nil},
+		{"file over directory", []string{"a.txt", "dir/.keep"}, "/a.txt", "/dir", syscall.EISDIR},
+		{"directory over file", []string{"dir/x.txt", "b.txt"}, "/dir", "/b.txt", syscall.ENOTDIR},
+		{"directory over empty directory", []string{"dir/x.txt", "empty/.keep"}, "/dir", "/empty", nil},
+		{"directory over non-empty directory", []string{"dir/x.txt", "full/y.txt"}, "/dir", "/full", syscall.ENOTEMPTY},
+		{"directory into itself", []string{"dir/x.txt"}, "/dir", "/dir/sub", syscall.EINVAL},
+		{"path onto itself", []string{"a.txt"}, "/a.txt", "/a.txt", nil},
+		{"directory onto itself", []string{"dir/x.txt"}, "/dir", "/dir/", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := s3client.NewMockClient("test-bucket", "us-east-1")
+			putTree(t, client, tt.keys)
+			fs := NewFilesystem(client)
+			before := listSorted(client, "")
+
+			err := fs.Rename(context.Background(), tt.src, tt.dst)
+			if err != tt.wantErr {
+				t.Fatalf("Rename(%s, %s) = %v, want %v", tt.src, tt.dst, err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if got := listSorted(client, ""); strings.Join(got, ",") != strings.Join(before, ",") {
+					t.Errorf("Failed rename changed the bucket: %v", got)
+				}
+			}
+		})
+	}
+
+	t.Run("replaced file has source content", func(t *testing.T) {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		putTree(t, client, []string{"a.txt", "b.txt"})
+		fs := NewFilesystem(client)
+		ctx := context.Background()
+
+		if err := fs.Rename(ctx, "/a.txt", "/b.txt"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		data, err := fs.ReadFile(ctx, "/b.txt", 0, 100)
+		if err != nil || string(data) != "content of a.txt" {
+			t.Errorf("b.txt = %q (err %v), want content of a.txt", data, err)
+		}
+		if got := listSorted(client, ""); strings.Join(got, ",") != "b.txt" {
+			t.Errorf("Expected only b.txt, got %v", got)
+		}
+	})
+}
+
+// caseFoldingBackend stores every path in lower case, like storage that
+// ignores case
+type caseFoldingBackend struct {
+	types.Backend
+}
+
+func (b caseFoldingBackend) Read(ctx context.Context, path string) ([]byte, error) {
+	return b.Backend.Read(ctx, strings.ToLower(path))
+}
+
+func (b caseFoldingBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	return b.Backend.ReadRange(ctx, strings.ToLower(path), start, end)
+}
+
+func (b caseFoldingBackend) Write(ctx context.Context, path string, data []byte) error {
+	return b.Backend.Write(ctx, strings.ToLower(path), data)
+}
+
+func (b caseFoldingBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	return b.Backend.WriteWithMetadata(ctx, strings.ToLower(path), data, metadata)
+}
+
+func (b caseFoldingBackend) Delete(ctx context.Context, path string) error {
+	return b.Backend.Delete(ctx, strings.ToLower(path))
+}
+
+func (b caseFoldingBackend) DeleteMany(ctx context.Context, paths []string) error {
+	folded := make([]string, len(paths))
+	for i, path := range paths {
+		folded[i] = strings.ToLower(path)
+	}
+	return b.Backend.DeleteMany(ctx, folded)
+}
+
+func (b caseFoldingBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return b.Backend.List(ctx, strings.ToLower(prefix))
+}
+
+func (b caseFoldingBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	return b.Backend.GetAttr(ctx, strings.ToLower(path))
+}
+
+func (b caseFoldingBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	return b.Backend.Rename(ctx, strings.ToLower(oldPath), strings.ToLower(newPath))
+}
+
+func (b caseFoldingBackend) Exists(ctx context.Context, path string) (bool, error) {
+	return b.Backend.Exists(ctx, strings.ToLower(path))
+}
+
+func (b caseFoldingBackend) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	return b.Backend.GetMetadata(ctx, strings.ToLower(path))
+}
+
+func TestRenameCaseOnly(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("case-insensitive storage", func(t *testing.T) {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		putTree(t, client, []string{"readme.txt"})
+		fs := NewFilesystemWithBackend(caseFoldingBackend{newS3Adapter(client)})
+		fs.SetCaseInsensitive(true)
+
+		// The same object under both names: renaming keeps it
+		if err := fs.Rename(ctx, "/README.txt", "/readme.txt"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		if got := listSorted(client, ""); strings.Join(got, ",") != "readme.txt" {
+			t.Errorf("Bucket after renames = %v", got)
+		}
+		if data, err := fs.ReadFile(ctx, "/readme.txt", 0, 0); err != nil || string(data) != "content of readme.txt" {
+			t.Errorf("readme.txt = %q, %v", data, err)
+		}
+	})
+
+	t.Run("case-sensitive storage", func(t *testing.T) {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		putTree(t, client, []string{"Foo.txt", "Dir/a.txt", "Empty/.keep"})
+		copies := &copyCountingClient{S3ClientInterface: client}
+		fs := NewFilesystem(copies)
+
+		// Distinct objects: a real move, straight to the new name
+		if err := fs.Rename(ctx, "/Foo.txt", "/foo.txt"); err != nil {
+			t.Fatalf("Rename of a file failed: %v", err)
+		}
+		if len(copies.simple) != 1 {
+			t.Errorf("Case-only rename of a file copied %v, want one copy", copies.simple)
+		}
+		if err := fs.Rename(ctx, "/Dir", "/dir"); err != nil {
+			t.Fatalf("Rename of a directory failed: %v", err)
+		}
+		if got := listSorted(client, ""); strings.Join(got, ",") != "Empty/.keep,dir/a.txt,foo.txt" {
+			t.Errorf("Bucket after renames = %v", got)
+		}
+		if data, err := fs.ReadFile(ctx, "/foo.txt", 0, 0); err != nil || string(data) != "content of Foo.txt" {
+			t.Errorf("foo.txt = %q, %v", data, err)
+		}
+
+		// A non-empty directory under the other case is still in the way, and
+		// the source stays where it was
+		if err := fs.Rename(ctx, "/Empty", "/dir"); err != syscall.ENOTEMPTY {
+			t.Fatalf("Rename onto a non-empty directory = %v, want ENOTEMPTY", err)
+		}
+		putTree(t, client, []string{"DIR/b.txt"})
+		if err := fs.Rename(ctx, "/DIR", "/dir"); err != syscall.ENOTEMPTY {
+			t.Errorf("Case-only rename onto a non-empty directory = %v, want ENOTEMPTY", err)
+		}
+		if got := listSorted(client, ""); strings.Join(got, ",") != "DIR/b.txt,Empty/.keep,dir/a.txt,foo.txt" {
+			t.Errorf("Bucket after refused rename = %v", got)
+		}
+	})
+}
+
+// copyCountingClient records which source keys were copied with a single
+// CopyObject and which with multipart copy
+type copyCountingClient struct {
+	S3ClientInterface
+	mu        sync.Mutex
+	simple    []string
+	multipart []string
+}
+
+func (c *copyCountingClient) CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	c.mu.Lock()
+	c.simple = append(c.simple, sourceKey)
+	c.mu.Unlock()
+	return c.S3ClientInterface.CopyObjectWithMetadata(ctx, sourceKey, destKey, metadata)
+}
+
+func (c *copyCountingClient) CopyObjectMultipart(ctx context.Context, sourceKey, destKey string) error {
+	c.mu.Lock()
+	c.multipart = append(c.multipart, sourceKey)
+	c.mu.Unlock()
+	return c.S3ClientInterface.CopyObjectMultipart(ctx, sourceKey, destKey)
+}
+
+// TestRenameCopyThreshold tests that each object moved by a rename is copied
+// with multipart copy only when it is larger than the configured threshold
+func TestRenameCopyThreshold(t *testing.T) {
+	mock := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	for key, size := range map[string]int{"dir/small.bin": 1024, "dir/edge.bin": 4096, "dir/large.bin": 8192, "single.bin": 8192} {
+		if err := mock.PutObject(ctx, key, make([]byte, size)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	client := &copyCountingClient{S3ClientInterface: mock}
+	fs := NewFilesystem(client)
+	fs.SetMultipartCopyThreshold(4096)
+
+	if err := fs.Rename(ctx, "/dir", "/moved"); err != nil {
+		t.Fatalf("Rename of directory failed: %v", err)
+	}
+	if err := fs.Rename(ctx, "/single.bin", "/renamed.bin"); err != nil {
+		t.Fatalf("Rename of file failed: %v", err)
+	}
+
+	sort.Strings(client.simple)
+	sort.Strings(client.multipart)
+	if got := strings.Join(client.simple, ","); got != "dir/edge.bin,dir/small.bin" {
+		t.Errorf("Expected simple copies of the small files, got %s", got)
+	}
+	if got := strings.Join(client.multipart, ","); got != "dir/large.bin,single.bin" {
+		t.Errorf("Expected multipart copies of the large files, got %s", got)
+	}
+	if got := listSorted(mock, "moved/"); strings.Join(got, ",") != "moved/edge.bin,moved/large.bin,moved/small.bin" {
+		t.Errorf("Unexpected objects after rename: %v", got)
+	}
+
+	// Without a threshold everything below 5GB takes a single CopyObject
+	client.simple, client.multipart = nil, nil
+	fs.SetMultipartCopyThreshold(0)
+	if err := fs.Rename(ctx, "/moved", "/back"); err != nil {
+		t.Fatalf("Rename back failed: %v", err)
+	}
+	if len(client.multipart) != 0 || len(client.simple) != 3 {
+		t.Errorf("Expected 3 simple copies with the default threshold, got %v simple and %v multipart", client.simple, client.multipart)
+	}
+}
+
+func TestRenameServerSide(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	stored := time.Now().Add(-time.Hour).Unix()
+	metadata := map[string]string{"mode": types.EncodeMode(0600), "ctime": fmt.Sprint(stored)}
+	for key, size := range map[string]int{"small.txt": 100, "big.bin": 8192, "dir/big.bin": 8192} {
+		if err := client.PutObjectWithMetadata(ctx, key, make([]byte, size), metadata); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	fs := NewFilesystem(client)
+	fs.SetMultipartCopyThreshold(4096)
+
+	gets, uploaded := client.GetCount(), client.UploadedBytes()
+	for oldPath, newPath := range map[string]string{"/small.txt": "/small2.txt", "/big.bin": "/big2.bin", "/dir": "/dir2"} {
+		if err := fs.Rename(ctx, oldPath, newPath); err != nil {
+			t.Fatalf("Rename(%s) failed: %v", oldPath, err)
+		}
+	}
+	if n := client.GetCount() - gets; n != 0 {
+		t.Errorf("Renames downloaded %d objects, want none", n)
+	}
+	if n := client.UploadedBytes() - uploaded; n != 0 {
+		t.Errorf("Renames uploaded %d bytes, want none", n)
+	}
+
+	// Renamed files keep their metadata and record the change time, whichever
+	// way they were copied
+	for _, path := range []string{"/small2.txt", "/big2.bin"} {
+		attr, err := fs.GetAttr(ctx, path)
+		if err != nil {
+			t.Fatalf("GetAttr(%s) failed: %v", path, err)
+		}
+		if attr.Mode != 0600 {
+			t.Errorf("%s mode = %v after rename, want %v", path, attr.Mode, os.FileMode(0600))
+		}
+		if attr.Ctime.Unix() <= stored {
+			t.Errorf("%s ctime = %v after rename, want later than %v", path, attr.Ctime, time.Unix(stored, 0))
+		}
+	}
+	if data, err := client.GetObject(ctx, "dir2/big.bin"); err != nil || len(data) != 8192 {
+		t.Errorf("dir2/big.bin holds %d bytes, %v; want 8192", len(data), err)
+	}
+}
+
+// prefixRenameBackend moves trees itself, failing after moving failAfter objects
+type prefixRenameBackend struct {
+	types.Backend
+	failAfter int
+	calls     int
+}
+
+func (b *prefixRenameBackend) RenamePrefix(ctx context.Context, oldPrefix, newPrefix string) error {
+	b.calls++
+	objects, err := b.Backend.List(ctx, oldPrefix)
+	if err != nil {
+		return err
+	}
+	sort.Strings(objects)
+	for i, src := range objects {
+		if b.failAfter > 0 && i == b.failAfter {
+			return syscall.EIO
+		}
+		if err := b.Backend.Rename(ctx, src, newPrefix+strings.TrimPrefix(src, oldPrefix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRenameDirectoryByPrefix(t *testing.T) {
+	keys := []string{"src/a.txt", "src/b.txt", "src/sub/c.txt"}
+
+	t.Run("success", func(t *testing.T) {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		putTree(t, client, keys)
+		backend := &prefixRenameBackend{Backend: newS3Adapter(client)}
+		fs := NewFilesystemWithBackend(backend)
+
+		if err := fs.Rename(context.Background(), "/src", "/dst"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		if backend.calls != 1 {
+			t.Errorf("Expected one RenamePrefix call, got %d", backend.calls)
+		}
+		want := "dst/a.txt,dst/b.txt,dst/sub/c.txt"
+		if got := listSorted(client, "dst/"); strings.Join(got, ",") != want {
+			t.Errorf("Destination = %v, want %s", got, want)
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		client := s3client.NewMockClient("test-bucket", "us-east-1")
+		putTree(t, client, keys)
+		fs := NewFilesystemWithBackend(&prefixRenameBackend{Backend: newS3Adapter(client), failAfter: 2})
+
+		err := fs.Rename(context.Background(), "/src", "/dst")
+		var renameErr *RenameError
+		if !errors.As(err, &renameErr) {
+			t.Fatalf("Expected *RenameError, got %v", err)
+		}
+		sort.Strings(renameErr.Moved)
+		if strings.Join(renameErr.Moved, ",") != "src/a.txt,src/b.txt" {
+			t.Errorf("Moved = %v", renameErr.Moved)
+		}
+		if strings.Join(renameErr.NotMoved, ",") != "src/sub/c.txt" {
+			t.Errorf("NotMoved = %v", renameErr.NotMoved)
+		}
+	})
+}
+
+// TestRenameDirectoryPaged tests that a directory larger than a listing page is
+// moved page by page, and that a failure keeps the earlier pages moved
+func TestRenameDirectoryPaged(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	const files = 2500
+	keys := make([]string, files)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("src/file-%04d", i)
+	}
+	putTree(t, client, keys)
+
+	// The first key of the second page cannot be copied
+	backend := &faultyCopyBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), failKey: keys[listPageSize]}
+	filesystem := NewFilesystemWithBackend(backend)
+
+	err := filesystem.Rename(ctx, "src", "dst")
+	var renameErr *RenameError
+	if !errors.As(err, &renameErr) {
+		t.Fatalf("Expected a RenameError, got %v", err)
+	}
+	if len(renameErr.Moved) != listPageSize || len(renameErr.NotMoved) != listPageSize {
+		t.Errorf("Moved %d and not moved %d keys, want one page each", len(renameErr.Moved), len(renameErr.NotMoved))
+	}
+	if got := len(listSorted(client, "dst/")); got != listPageSize {
+		t.Errorf("%d keys under dst/, want the first page (%d)", got, listPageSize)
+	}
+
+	// Retrying moves the rest
+	backend.failKey = ""
+	if err := filesystem.Rename(ctx, "src", "dst"); err != nil {
+		t.Fatalf("Retried rename failed: %v", err)
+	}
+	if got := len(listSorted(client, "dst/")); got != files {
+		t.Errorf("%d keys under dst/ after retry, want %d", got, files)
+	}
+	if left := listSorted(client, "src/"); len(left) != 0 {
+		t.Errorf("%d keys left under src/", len(left))
+	}
+}
+
+// s3MetadataClient stores user metadata as S3 does, keyed without the
+// "x-amz-meta-" prefix, and rejects uploads that give one key twice with
+// different values, which S3 would keep only one of
+type s3MetadataClient struct {
+	*s3client.MockClient
+}
+
+func s3Metadata(metadata map[string]string) (map[string]string, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+	stored := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		key := strings.TrimPrefix(k, "x-amz-meta-")
+		if prev, ok := stored[key]; ok && prev != v {
+			return nil, fmt.Errorf("metadata key %q given as %q and %q", key, prev, v)
+		}
+		stored[key] = v
+	}
+	return stored, nil
+}
+
+func (c s3MetadataClient) PutObjectWithMetadata(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	stored, err := s3Metadata(metadata)
+	if err != nil {
+		return err
+	}
+	return c.MockClient.PutObjectWithMetadata(ctx, key, data, stored)
+}
+
+func (c s3MetadataClient) CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	stored, err := s3Metadata(metadata)
+	if err != nil {
+		return err
+	}
+	return c.MockClient.CopyObjectWithMetadata(ctx, sourceKey, destKey, stored)
+}
+
+func (c s3MetadataClient) CopyObjectMultipartWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	stored, err := s3Metadata(metadata)
+	if err != nil {
+		return err
+	}
+	return c.MockClient.CopyObjectMultipartWithMetadata(ctx, sourceKey, destKey, stored)
+}
+
+func TestRenamePreservesMetadata(t *testing.T) {
+	ctx := context.Background()
+	xattrs := map[string]string{"user.comment": "hello world", "user.tag": "blue", "user.origin": "x-amz-meta-trap"}
+	for name, client := range map[string]S3ClientInterface{
+		"mock": s3client.NewMockClient("test-bucket", "us-east-1"),
+		"s3":   s3MetadataClient{s3client.NewMockClient("test-bucket", "us-east-1")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs := NewFilesystem(client)
+			fs.SetAtimeMode(AtimeOff)
+			fs.SetMultipartCopyThreshold(4096)
+			for _, path := range []string{"/small.txt", "/big.bin", "/dir/nested.txt"} {
+				size := 100
+				if path == "/big.bin" {
+					size = 8192
+				}
+				writeAndFlush(t, fs, path, make([]byte, size))
+				if err := fs.Chmod(ctx, path, 0640); err != nil {
+					t.Fatalf("Chmod(%s) failed: %v", path, err)
+				}
+				if err := fs.Chown(ctx, path, 1234, 5678); err != nil {
+					t.Fatalf("Chown(%s) failed: %v", path, err)
+				}
+				for xattr, value := range xattrs {
+					if err := fs.SetXattr(ctx, path, xattr, []byte(value)); err != nil {
+						t.Fatalf("SetXattr(%s, %s) failed: %v", path, xattr, err)
+					}
+				}
+			}
+			before := make(map[string]*Attr)
+			listed := make(map[string]string)
+			for _, path := range []string{"/small.txt", "/big.bin", "/dir/nested.txt"} {
+				attr, err := fs.GetAttr(ctx, path)
+				if err != nil {
+					t.Fatalf("GetAttr(%s) failed: %v", path, err)
+				}
+				before[path] = attr
+				names, err := fs.ListXattr(ctx, path)
+				if err != nil {
+					t.Fatalf("ListXattr(%s) failed: %v", path, err)
+				}
+				sort.Strings(names)
+				listed[path] = strings.Join(names, ",")
+			}
+
+			renames := map[string]string{"/small.txt": "/small2.txt", "/big.bin": "/big2.bin", "/dir": "/dir2"}
+			for oldPath, newPath := range renames {
+				if err := fs.Rename(ctx, oldPath, newPath); err != nil {
+					t.Fatalf("Rename(%s) failed: %v", oldPath, err)
+				}
+			}
+
+			for oldPath, newPath := range map[string]string{"/small.txt": "/small2.txt", "/big.bin": "/big2.bin", "/dir/nested.txt": "/dir2/nested.txt"} {
+				attr, err := fs.GetAttr(ctx, newPath)
+				if err != nil {
+					t.Fatalf("GetAttr(%s) failed: %v", newPath, err)
+				}
+				want := before[oldPath]
+				if attr.Mode != want.Mode || attr.Uid != want.Uid || attr.Gid != want.Gid || !attr.Mtime.Equal(want.Mtime) || attr.Size != want.Size {
+					t.Errorf("%s after rename = mode %v, owner %d:%d, mtime %v, size %d; want mode %v, owner %d:%d, mtime %v, size %d",
+						newPath, attr.Mode, attr.Uid, attr.Gid, attr.Mtime, attr.Size, want.Mode, want.Uid, want.Gid, want.Mtime, want.Size)
+				}
+				names, err := fs.ListXattr(ctx, newPath)
+				if err != nil {
+					t.Fatalf("ListXattr(%s) failed: %v", newPath, err)
+				}
+				sort.Strings(names)
+				if got := strings.Join(names, ","); got != listed[oldPath] {
+					t.Errorf("ListXattr(%s) = %s, want %s", newPath, got, listed[oldPath])
+				}
+				for xattr, value := range xattrs {
+					if got, err := fs.GetXattr(ctx, newPath, xattr); err != nil || string(got) != value {
+						t.Errorf("GetXattr(%s, %s) = %q, %v; want %q", newPath, xattr, got, err, value)
+					}
+				}
+			}
+		})
+	}
+}
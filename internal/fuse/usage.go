@@ -0,0 +1,243 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// usageTracker keeps a running total of the bytes stored under the mount, so
+// it is known without listing the bucket again. The total is set by a scan in
+// the background and follows this mount's writes, deletes and renames by the
+// size each one changes; changes made by other clients show up only after a
+// remount. Only while a scan runs are sizes kept per object, for the objects
+// changed meanwhile, whose listed sizes may predate the change
+type usageTracker struct {
+	mu      sync.Mutex
+	used    int64            // Bytes stored: the last scan's total plus the changes since
+	quota   int64            // Most bytes that may be stored (0 = unlimited)
+	changed map[string]int64 // Size now of each path changed during the running scan (nil = no scan)
+	rescan  bool             // Another scan is needed once the running one finishes
+	scanned chan struct{}    // Closed once the first scan has finished
+}
+
+// SetUsageTracking starts tracking the bytes stored under the mount. The total
+// is found by a scan of the whole bucket, which runs in the background so the
+// mount doesn't wait for it; until it finishes, Statfs and the metrics report
+// only the changes since. With a quota above 0, writes that would take the
+// total past quota bytes fail with ENOSPC (writes wait for the scan, since
+// until then it isn't known whether they fit); shrinking and removing files
+// always succeed. The backend must report sizes in its listings (see
+// types.SizeLister), as asking for each object's size would take a request
+// per object
+func (fs *Filesystem) SetUsageTracking(ctx context.Context, quota int64) error {
+	backend := fs.getBackend()
+	if backend == nil {
+		return fmt.Errorf("no storage backend available")
+	}
+	if _, ok := backend.(types.SizeLister); !ok {
+		return fmt.Errorf("usage tracking needs a storage backend that reports sizes in listings")
+	}
+
+	fs.usage = &usageTracker{quota: max(quota, 0), scanned: make(chan struct{})}
+	metrics.SetUsage(0, fs.usage.quota)
+	fs.scanUsage(context.WithoutCancel(ctx), backend)
+	return nil
+}
+
+// UsedBytes returns the bytes stored under the mount and the quota on them,
+// both 0 unless usage is tracked
+func (fs *Filesystem) UsedBytes() (used, quota int64) {
+	if fs.usage == nil {
+		return 0, 0
+	}
+	fs.usage.mu.Lock()
+	defer fs.usage.mu.Unlock()
+	return fs.usage.used, fs.usage.quota
+}
+
+// waitUsageScan blocks until the first usage scan has finished or ctx is done
+func (fs *Filesystem) waitUsageScan(ctx context.Context) error {
+	if fs.usage == nil {
+		return nil
+	}
+	select {
+	case <-fs.usage.scanned:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scanUsage totals the bucket in the background and makes the result the
+// usage, with the objects changed while it ran counted at their size now. A
+// scan asked for while one runs is made once that one finishes
+func (fs *Filesystem) scanUsage(ctx context.Context, backend types.Backend) {
+	usage := fs.usage
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	if usage.changed != nil {
+		usage.rescan = true
+		return
+	}
+	usage.changed = make(map[string]int64)
+	go func() {
+		for {
+			sizes, err := listSizes(ctx, backend, "")
+			usage.mu.Lock()
+			if err != nil {
+				logging.Warn("failed to scan bucket usage, the total counts only changes made since mounting", "error", err)
+			} else {
+				var total int64
+				for _, size := range sizes {
+					total += size
+				}
+				for path, size := range usage.changed {
+					total += size - sizes[path]
+				}
+				usage.used = total
+				logging.Info("tracking bucket usage", "objects", len(sizes), "bytes", usage.used, "quota", usage.quota)
+			}
+			metrics.SetUsage(usage.used, usage.quota)
+			select {
+			case <-usage.scanned:
+			default:
+				close(usage.scanned)
+			}
+			if !usage.rescan {
+				usage.changed = nil
+				usage.mu.Unlock()
+				return
+			}
+			usage.rescan = false
+			usage.changed = make(map[string]int64)
+			usage.mu.Unlock()
+		}
+	}()
+}
+
+// listSizes returns the size of every object under prefix, by path, from the
+// backend's sized listing
+func listSizes(ctx context.Context, backend types.Backend, prefix string) (map[string]int64, error) {
+	lister, ok := backend.(types.SizeLister)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't report sizes in listings")
+	}
+	return lister.ListSizes(ctx, prefix)
+}
+
+// storedSize returns the size of the object stored at normalizedPath, from the
+// stat cache when it has the path and otherwise from the backend; 0 when
+// nothing is stored there or usage isn't tracked
+func (fs *Filesystem) storedSize(ctx context.Context, normalizedPath string) int64 {
+	if fs.usage == nil {
+		return 0
+	}
+	if fs.cache != nil {
+		statCache := fs.cache.GetStatCache()
+		for _, key := range []string{"/" + normalizedPath, normalizedPath} {
+			if statCache.IsNegative(key) {
+				return 0
+			}
+			if entry, found := statCache.Get(key); found && entry != nil && entry.Attr != nil {
+				return entry.Attr.Size
+			}
+		}
+	}
+	backend := fs.getBackend()
+	if backend == nil {
+		return 0
+	}
+	attr, err := backend.GetAttr(ctx, normalizedPath)
+	if err != nil {
+		return 0
+	}
+	return attr.Size
+}
+
+// reserveSpace fails with ENOSPC if storing size bytes at normalizedPath would
+// take the usage past the quota. Nothing is reserved: the change is recorded
+// once the write succeeds. The size already stored there is only looked up
+// when the write wouldn't fit on top of it
+func (fs *Filesystem) reserveSpace(ctx context.Context, normalizedPath string, size int64) error {
+	if fs.usage == nil || fs.usage.quota == 0 {
+		return nil
+	}
+	if err := fs.waitUsageScan(ctx); err != nil {
+		return err
+	}
+	used, quota := fs.UsedBytes()
+	if used+size <= quota {
+		return nil
+	}
+	current := fs.storedSize(ctx, normalizedPath)
+	if size > current && used-current+size > quota {
+		return syscall.ENOSPC
+	}
+	return nil
+}
+
+// recordChange records that the object at normalizedPath, which held before
+// bytes in storage, now holds after bytes (0 for one that didn't exist or no
+// longer does)
+func (fs *Filesystem) recordChange(normalizedPath string, before, after int64) {
+	if fs.usage == nil {
+		return
+	}
+	fs.usage.update(after-before, func(u *usageTracker) {
+		u.changed[normalizedPath] = after
+	})
+}
+
+// recordTreeChange records that the objects under a prefix, which held before
+// bytes in storage, now hold after bytes
+func (fs *Filesystem) recordTreeChange(before, after int64) {
+	if fs.usage == nil {
+		return
+	}
+	// A running scan may have listed the tree before or after the change
+	fs.usage.update(after-before, func(u *usageTracker) {
+		u.rescan = true
+	})
+}
+
+// usedUnder returns the bytes stored under prefix, for operations that are
+// about to remove them all; 0 unless usage is tracked
+func (fs *Filesystem) usedUnder(ctx context.Context, backend types.Backend, prefix string) (int64, error) {
+	if fs.usage == nil {
+		return 0, nil
+	}
+	sizes, err := listSizes(ctx, backend, prefix)
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	return total, err
+}
+
+// update adds delta to the total, calls duringScan as well while a scan runs,
+// and publishes the new total
+func (u *usageTracker) update(delta int64, duringScan func(u *usageTracker)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.used += delta
+	if u.changed != nil {
+		duringScan(u)
+	}
+	metrics.SetUsage(u.used, u.quota)
+}
+
+// rescanUsage scans the bucket again in the background, for operations that
+// failed part-way and left unknown how much they changed
+func (fs *Filesystem) rescanUsage(ctx context.Context, backend types.Backend) {
+	if fs.usage == nil {
+		return
+	}
+	logging.Warn("an operation failed part-way, rescanning bucket usage")
+	fs.scanUsage(context.WithoutCancel(ctx), backend)
+}
@@ -0,0 +1,181 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+func expectUsed(t *testing.T, filesystem *Filesystem, want int64) {
+	t.Helper()
+	if used, _ := filesystem.UsedBytes(); used != want {
+		t.Errorf("UsedBytes() = %d, want %d", used, want)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetAtimeMode(AtimeOff)
+
+	// Objects stored before the mount count from the initial scan
+	if err := client.PutObject(ctx, "existing.bin", make([]byte, 4096)); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	const quota = 16 * 1024
+	if err := filesystem.SetUsageTracking(ctx, quota); err != nil {
+		t.Fatalf("SetUsageTracking failed: %v", err)
+	}
+	filesystem.waitUsageScan(ctx)
+	expectUsed(t, filesystem, 4096)
+
+	// Writes up to the quota succeed
+	writeAndFlush(t, filesystem, "/a.bin", bytes.Repeat([]byte("a"), 8192))
+	if err := filesystem.WriteFile(ctx, "/a.bin", bytes.Repeat([]byte("b"), 4096), 8192); err != nil {
+		t.Fatalf("WriteFile up to the quota failed: %v", err)
+	}
+	if err := filesystem.Flush(ctx, "/a.bin"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	expectUsed(t, filesystem, quota)
+
+	// The next byte, in the same file or another, doesn't fit
+	if err := filesystem.WriteFile(ctx, "/a.bin", []byte("c"), 12288); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("WriteFile past the quota = %v, want ENOSPC", err)
+	}
+	if _, err := filesystem.AppendFile(ctx, "/a.bin", []byte("c")); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("AppendFile past the quota = %v, want ENOSPC", err)
+	}
+	if err := filesystem.Truncate(ctx, "/existing.bin", 4097); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("Truncate past the quota = %v, want ENOSPC", err)
+	}
+	if err := filesystem.Create(ctx, "/b.bin", 0644); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := filesystem.WriteFile(ctx, "/b.bin", []byte("c"), 0); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("WriteFile of a new file past the quota = %v, want ENOSPC", err)
+	}
+	if stored, _ := client.GetObject(ctx, "a.bin"); len(stored) != 12288 {
+		t.Errorf("a.bin holds %d bytes after refused writes, want 12288", len(stored))
+	}
+
+	statfs, err := filesystem.Statfs(ctx)
+	if err != nil {
+		t.Fatalf("Statfs failed: %v", err)
+	}
+	if statfs.Blocks != quota/4096 || statfs.Bfree != 0 || statfs.Bavail != 0 {
+		t.Errorf("Statfs = %d blocks, %d free, %d available; want %d, 0, 0", statfs.Blocks, statfs.Bfree, statfs.Bavail, quota/4096)
+	}
+
+	// Rewriting a file in place takes no more space
+	if err := filesystem.WriteFile(ctx, "/a.bin", bytes.Repeat([]byte("d"), 4096), 4096); err != nil {
+		t.Errorf("WriteFile within the file failed: %v", err)
+	}
+	if err := filesystem.Flush(ctx, "/a.bin"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	expectUsed(t, filesystem, quota)
+
+	// Removing a file frees its space, and renaming one keeps it in use
+	if err := filesystem.Remove(ctx, "/existing.bin"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	expectUsed(t, filesystem, 12288)
+	if err := filesystem.Rename(ctx, "/a.bin", "/dir/a.bin"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	expectUsed(t, filesystem, 12288)
+	writeAndFlush(t, filesystem, "/b.bin", make([]byte, 4096))
+	expectUsed(t, filesystem, quota)
+
+	statfs, _ = filesystem.Statfs(ctx)
+	if statfs.Bfree != 0 {
+		t.Errorf("Statfs reports %d free blocks at the quota, want 0", statfs.Bfree)
+	}
+	if err := filesystem.RemoveAll(ctx, "/dir"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	expectUsed(t, filesystem, 4096)
+	statfs, _ = filesystem.Statfs(ctx)
+	if statfs.Bfree != 3 {
+		t.Errorf("Statfs reports %d free blocks after removing a directory, want 3", statfs.Bfree)
+	}
+}
+
+func TestUsageWithoutQuota(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	filesystem := NewFilesystem(client)
+	filesystem.SetAtimeMode(AtimeOff)
+	if err := filesystem.SetUsageTracking(ctx, 0); err != nil {
+		t.Fatalf("SetUsageTracking failed: %v", err)
+	}
+	filesystem.waitUsageScan(ctx)
+
+	writeAndFlush(t, filesystem, "/big.bin", make([]byte, 1<<20))
+	expectUsed(t, filesystem, 1<<20)
+	statfs, err := filesystem.Statfs(ctx)
+	if err != nil {
+		t.Fatalf("Statfs failed: %v", err)
+	}
+	if used := statfs.Blocks - statfs.Bfree; used != (1<<20)/4096 {
+		t.Errorf("Statfs reports %d blocks used, want %d", used, (1<<20)/4096)
+	}
+}
+
+// slowScanBackend holds its sized listings until release is closed
+type slowScanBackend struct {
+	*s3Adapter
+	release chan struct{}
+}
+
+func (b *slowScanBackend) ListSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	<-b.release
+	return b.s3Adapter.ListSizes(ctx, prefix)
+}
+
+func TestUsageScanRunsInBackground(t *testing.T) {
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	if err := client.PutObject(ctx, "existing.bin", make([]byte, 4096)); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	backend := &slowScanBackend{s3Adapter: newS3Adapter(client).(*s3Adapter), release: make(chan struct{})}
+	filesystem := NewFilesystemWithBackend(backend)
+	filesystem.SetAtimeMode(AtimeOff)
+
+	// The mount doesn't wait for the scan, and changes made meanwhile are
+	// added to its result
+	if err := filesystem.SetUsageTracking(ctx, 0); err != nil {
+		t.Fatalf("SetUsageTracking failed: %v", err)
+	}
+	writeAndFlush(t, filesystem, "/new.bin", make([]byte, 1024))
+	expectUsed(t, filesystem, 1024)
+	heads := client.HeadCount()
+	close(backend.release)
+	filesystem.waitUsageScan(ctx)
+	expectUsed(t, filesystem, 4096+1024)
+	// The sizes came from the listing alone
+	if n := client.HeadCount() - heads; n != 0 {
+		t.Errorf("Scanning usage made %d HEAD requests, want 0", n)
+	}
+
+	// Overwriting and removing change the total by the size they replace
+	writeAndFlush(t, filesystem, "/existing.bin", make([]byte, 100))
+	expectUsed(t, filesystem, 100+1024)
+	if err := filesystem.Remove(ctx, "/new.bin"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	expectUsed(t, filesystem, 100)
+
+	// A backend that can't list sizes would need a request per object
+	plain := NewFilesystemWithBackend(&failingUploadBackend{Backend: newS3Adapter(client)})
+	if err := plain.SetUsageTracking(ctx, 0); err == nil {
+		t.Error("SetUsageTracking without sized listings succeeded")
+	}
+}
@@ -0,0 +1,291 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// versionsDir is the name of the pseudo-directory holding the earlier versions
+// of the files in a directory
+const versionsDir = ".versions"
+
+// SetShowVersions makes the stored versions of files readable through a
+// read-only pseudo-directory in every directory: dir/.versions/name/ holds a
+// file per version of dir/name, named by its version ID, also for files deleted
+// since. The pseudo-directory is reached by name only and never listed. Needs a
+// backend that keeps versions, such as a bucket with versioning enabled
+func (fs *Filesystem) SetShowVersions(enable bool) {
+	fs.showVersions = enable
+}
+
+// versioner returns the backend as a Versioner, failing with ENOTSUP for
+// backends that keep no versions
+func (fs *Filesystem) versioner() (types.Versioner, error) {
+	backend := fs.getBackend()
+	if backend == nil {
+		return nil, fmt.Errorf("no storage backend available")
+	}
+	versioner, ok := backend.(types.Versioner)
+	if !ok {
+		return nil, syscall.ENOTSUP
+	}
+	return versioner, nil
+}
+
+// versionsError maps a backend's missing version support to ENOTSUP
+func versionsError(err error) error {
+	if errors.Is(err, errors.ErrUnsupported) {
+		return syscall.ENOTSUP
+	}
+	return err
+}
+
+// ListVersions returns the stored versions of the file at path, newest first,
+// delete markers included. A path that never held a file fails with ENOENT
+func (fs *Filesystem) ListVersions(ctx context.Context, path string) ([]types.Version, error) {
+	versioner, err := fs.versioner()
+	if err != nil {
+		return nil, err
+	}
+	normalizedPath := fs.normalizePath(path)
+	listed, err := versioner.ListVersions(ctx, normalizedPath)
+	if err != nil {
+		return nil, versionsError(err)
+	}
+	// The listing is by prefix, so it also has the files path is a prefix of
+	var versions []types.Version
+	for _, v := range listed {
+		if v.Path == normalizedPath {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, syscall.ENOENT
+	}
+	return versions, nil
+}
+
+// ReadVersion returns the content of the given version of the file at path
+func (fs *Filesystem) ReadVersion(ctx context.Context, path, versionID string) ([]byte, error) {
+	versioner, err := fs.versioner()
+	if err != nil {
+		return nil, err
+	}
+	data, err := versioner.ReadVersion(ctx, fs.normalizePath(path), versionID)
+	return data, versionsError(err)
+}
+
+// Restore makes the given version of the file at path current again, content
+// and metadata, which also brings back a deleted file. The version it replaces
+// is kept. Writes still buffered for the file are uploaded first, so they end
+// up as a version of their own rather than on top of the restored one
+func (fs *Filesystem) Restore(ctx context.Context, path, versionID string) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
+	versioner, err := fs.versioner()
+	if err != nil {
+		return err
+	}
+	normalizedPath := fs.normalizePath(path)
+	if fs.cache != nil {
+		if entity, found := fs.cache.GetFdCache().Get(normalizedPath); found && entity.BytesModified() > 0 {
+			if err := fs.uploadBufferedData(ctx, normalizedPath, entity); err != nil {
+				return fmt.Errorf("failed to upload buffered data before restore: %w", err)
+			}
+		}
+	}
+	before := fs.storedSize(ctx, normalizedPath)
+	if err := versioner.RestoreVersion(ctx, normalizedPath, versionID); err != nil {
+		return versionsError(err)
+	}
+
+	// Whatever is cached is of the version just replaced
+	attr, err := fs.getBackend().GetAttr(ctx, normalizedPath)
+	if fs.cache != nil {
+		statCache := fs.cache.GetStatCache()
+		statCache.Delete(normalizedPath)
+		statCache.Delete("/" + normalizedPath)
+		if entity, found := fs.cache.GetFdCache().Get(normalizedPath); found {
+			if err == nil {
+				entity.Reset(attr.Size, attr.Mtime, attr.ETag, false)
+			} else {
+				entity.Reset(0, time.Now(), "", false)
+			}
+		}
+	}
+	fs.forgetDiskCache(normalizedPath)
+	if err == nil {
+		fs.recordChange(normalizedPath, before, attr.Size)
+	}
+	return nil
+}
+
+// versionPath is a path inside a .versions pseudo-directory
+type versionPath struct {
+	dir  string   // Directory whose files' versions are shown, normalized with a trailing slash ("" = root)
+	rest []string // Components after .versions: none, the file name, then the version ID
+}
+
+// parseVersionPath splits up path if it lies in a .versions pseudo-directory
+// and those are shown
+func (fs *Filesystem) parseVersionPath(path string) (versionPath, bool) {
+	if !fs.showVersions {
+		return versionPath{}, false
+	}
+	parts := strings.Split(strings.Trim(fs.normalizePath(path), "/"), "/")
+	for i, part := range parts {
+		if part != versionsDir {
+			continue
+		}
+		dir := strings.Join(parts[:i], "/")
+		if dir != "" {
+			dir += "/"
+		}
+		return versionPath{dir: dir, rest: parts[i+1:]}, true
+	}
+	return versionPath{}, false
+}
+
+// isVersionPath reports whether path lies in a .versions pseudo-directory,
+// which can't be written to
+func (fs *Filesystem) isVersionPath(path string) bool {
+	_, ok := fs.parseVersionPath(path)
+	return ok
+}
+
+// storedVersions returns the versions of path that hold content, newest first
+func (fs *Filesystem) storedVersions(ctx context.Context, path string) ([]types.Version, error) {
+	versions, err := fs.ListVersions(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	stored := versions[:0]
+	for _, v := range versions {
+		if !v.Deleted {
+			stored = append(stored, v)
+		}
+	}
+	if len(stored) == 0 {
+		return nil, syscall.ENOENT
+	}
+	return stored, nil
+}
+
+// versionAttr returns the attributes of a path in a .versions pseudo-directory:
+// read-only directories for the pseudo-directory and each file in it, and
+// read-only files for the versions
+func (fs *Filesystem) versionAttr(ctx context.Context, vp versionPath) (*Attr, error) {
+	uid, gid := fs.ownerForWrite()
+	dirAttr := func(mtime time.Time) *Attr {
+		return &Attr{
+			Mode:   os.ModeDir | 0555,
+			Size:   4096,
+			Blocks: blocksForSize(4096),
+			Mtime:  mtime,
+			Ctime:  mtime,
+			Atime:  mtime,
+			Uid:    uid,
+			Gid:    gid,
+		}
+	}
+	if len(vp.rest) == 0 {
+		return dirAttr(time.Now()), nil
+	}
+	if len(vp.rest) > 2 {
+		return nil, syscall.ENOENT
+	}
+	versions, err := fs.storedVersions(ctx, vp.dir+vp.rest[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(vp.rest) == 1 {
+		return dirAttr(versions[0].Modified), nil
+	}
+	for _, v := range versions {
+		if v.ID == vp.rest[1] {
+			return &Attr{
+				Mode:   0444,
+				Size:   v.Size,
+				Blocks: blocksForSize(v.Size),
+				Mtime:  v.Modified,
+				Ctime:  v.Modified,
+				Atime:  v.Modified,
+				Uid:    uid,
+				Gid:    gid,
+			}, nil
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+// versionReadDir lists a .versions pseudo-directory, with a directory for each
+// file of the directory that has stored versions, or the directory of a file,
+// with its versions
+func (fs *Filesystem) versionReadDir(ctx context.Context, vp versionPath) ([]DirEntry, error) {
+	switch len(vp.rest) {
+	case 0:
+		versioner, err := fs.versioner()
+		if err != nil {
+			return nil, err
+		}
+		versions, err := versioner.ListVersions(ctx, vp.dir)
+		if err != nil {
+			return nil, versionsError(err)
+		}
+		seen := make(map[string]bool)
+		entries := make([]DirEntry, 0)
+		for _, v := range versions {
+			name := strings.TrimPrefix(v.Path, vp.dir)
+			// Files in subdirectories are shown in the subdirectory's .versions
+			if v.Deleted || name == "" || name == ".keep" || strings.Contains(name, "/") || seen[name] {
+				continue
+			}
+			seen[name] = true
+			entries = append(entries, DirEntry{Name: name, IsDir: true})
+		}
+		return entries, nil
+	case 1:
+		versions, err := fs.storedVersions(ctx, vp.dir+vp.rest[0])
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]DirEntry, 0, len(versions))
+		for _, v := range versions {
+			entries = append(entries, DirEntry{Name: v.ID})
+		}
+		return entries, nil
+	case 2:
+		return nil, syscall.ENOTDIR
+	}
+	return nil, syscall.ENOENT
+}
+
+// versionReadFile reads from a version in a .versions pseudo-directory
+func (fs *Filesystem) versionReadFile(ctx context.Context, vp versionPath, offset, size int64) ([]byte, error) {
+	if len(vp.rest) < 2 {
+		return nil, syscall.EISDIR
+	}
+	if len(vp.rest) > 2 {
+		return nil, syscall.ENOENT
+	}
+	data, err := fs.ReadVersion(ctx, vp.dir+vp.rest[0], vp.rest[1])
+	if err != nil {
+		return nil, err
+	}
+	if offset >= int64(len(data)) {
+		return []byte{}, nil
+	}
+	data = data[offset:]
+	if size > 0 && size < int64(len(data)) {
+		data = data[:size]
+	}
+	return data, nil
+}
@@ -0,0 +1,208 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// versionedBackend adds a fixed set of earlier versions to a backend; restoring
+// one writes its content back to the backend
+type versionedBackend struct {
+	types.Backend
+	versions []types.Version
+	data     map[string]string // Version ID -> content
+}
+
+func (b *versionedBackend) ListVersions(ctx context.Context, prefix string) ([]types.Version, error) {
+	var versions []types.Version
+	for _, v := range b.versions {
+		if strings.HasPrefix(v.Path, prefix) {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (b *versionedBackend) ReadVersion(ctx context.Context, path, id string) ([]byte, error) {
+	for _, v := range b.versions {
+		if v.Path == path && v.ID == id && !v.Deleted {
+			return []byte(b.data[id]), nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (b *versionedBackend) RestoreVersion(ctx context.Context, path, id string) error {
+	data, err := b.ReadVersion(ctx, path, id)
+	if err != nil {
+		return err
+	}
+	return b.Backend.Write(ctx, path, data)
+}
+
+func newVersionedFilesystem(t *testing.T) (*Filesystem, *versionedBackend) {
+	t.Helper()
+	ctx := context.Background()
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	for key, data := range map[string]string{"notes.txt": "third", "notes.txt.bak": "backup", "sub/deep.txt": "deep"} {
+		if err := client.PutObject(ctx, key, []byte(data)); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	backend := &versionedBackend{
+		Backend: newS3Adapter(client),
+		versions: []types.Version{
+			{Path: "gone.txt", ID: "g2", Modified: base.Add(time.Hour), Latest: true, Deleted: true},
+			{Path: "gone.txt", ID: "g1", Modified: base, Size: 4},
+			{Path: "notes.txt", ID: "n3", Modified: base.Add(2 * time.Hour), Size: 5, Latest: true},
+			{Path: "notes.txt", ID: "n2", Modified: base.Add(time.Hour), Size: 6},
+			{Path: "notes.txt", ID: "n1", Modified: base, Size: 5},
+			{Path: "notes.txt.bak", ID: "b1", Modified: base, Size: 6, Latest: true},
+			{Path: "sub/deep.txt", ID: "d1", Modified: base, Size: 4, Latest: true},
+		},
+		data: map[string]string{"g1": "lost", "n1": "first", "n2": "second", "n3": "third", "b1": "backup", "d1": "deep"},
+	}
+	fs := NewFilesystemWithBackend(backend)
+	fs.SetAtimeMode(AtimeOff)
+	return fs, backend
+}
+
+func TestListAndReadVersions(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := newVersionedFilesystem(t)
+
+	versions, err := fs.ListVersions(ctx, "/notes.txt")
+	if err != nil {
+		t.Fatalf("ListVersions failed: %v", err)
+	}
+	var ids []string
+	for _, v := range versions {
+		ids = append(ids, v.ID)
+	}
+	if want := []string{"n3", "n2", "n1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ListVersions = %v, want %v", ids, want)
+	}
+	if _, err := fs.ListVersions(ctx, "/never.txt"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("ListVersions of a path without versions = %v, want ENOENT", err)
+	}
+	if data, err := fs.ReadVersion(ctx, "/notes.txt", "n1"); err != nil || string(data) != "first" {
+		t.Errorf("ReadVersion = %q, %v; want %q", data, err, "first")
+	}
+
+	// Clients without versions can't offer any
+	plain := NewFilesystem(s3client.NewMockClient("test-bucket", "us-east-1"))
+	if _, err := plain.ListVersions(ctx, "/notes.txt"); !errors.Is(err, syscall.ENOTSUP) {
+		t.Errorf("ListVersions without version support = %v, want ENOTSUP", err)
+	}
+}
+
+func TestVersionsDirectory(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := newVersionedFilesystem(t)
+
+	// Hidden until enabled
+	if _, err := fs.GetAttr(ctx, "/.versions"); err == nil {
+		t.Error("GetAttr(/.versions) succeeded with versions not shown")
+	}
+	fs.SetShowVersions(true)
+
+	if attr, err := fs.GetAttr(ctx, "/.versions"); err != nil || attr.Mode != os.ModeDir|0555 {
+		t.Errorf("GetAttr(/.versions) = %v, %v; want a read-only directory", attr, err)
+	}
+	entries, err := fs.ReadDir(ctx, "/.versions")
+	if err != nil {
+		t.Fatalf("ReadDir(/.versions) failed: %v", err)
+	}
+	names := map[string]bool{}
+	for _, entry := range entries {
+		names[entry.Name] = entry.IsDir
+	}
+	if want := map[string]bool{"gone.txt": true, "notes.txt": true, "notes.txt.bak": true}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir(/.versions) = %v, want %v", names, want)
+	}
+	if entries, err := fs.ReadDir(ctx, "/sub/.versions"); err != nil || len(entries) != 1 || entries[0].Name != "deep.txt" {
+		t.Errorf("ReadDir(/sub/.versions) = %+v, %v; want deep.txt", entries, err)
+	}
+
+	entries, err = fs.ReadDir(ctx, "/.versions/notes.txt")
+	if err != nil {
+		t.Fatalf("ReadDir(/.versions/notes.txt) failed: %v", err)
+	}
+	if len(entries) != 3 || entries[0].Name != "n3" || entries[0].IsDir {
+		t.Errorf("ReadDir(/.versions/notes.txt) = %+v, want files n3, n2, n1", entries)
+	}
+	attr, err := fs.GetAttr(ctx, "/.versions/notes.txt/n2")
+	if err != nil {
+		t.Fatalf("GetAttr of a version failed: %v", err)
+	}
+	if attr.Mode != 0444 || attr.Size != 6 || !attr.Mtime.Equal(time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("GetAttr of a version = %+v", attr)
+	}
+	if data, err := fs.ReadFile(ctx, "/.versions/notes.txt/n2", 1, 3); err != nil || string(data) != "eco" {
+		t.Errorf("ReadFile of a version = %q, %v; want %q", data, err, "eco")
+	}
+	for _, path := range []string{"/.versions/gone.txt/g2", "/.versions/notes.txt/n9", "/.versions/never.txt"} {
+		if _, err := fs.GetAttr(ctx, path); !errors.Is(err, syscall.ENOENT) {
+			t.Errorf("GetAttr(%s) = %v, want ENOENT", path, err)
+		}
+	}
+
+	// Read-only, and not in listings of the directory itself
+	if err := fs.WriteFile(ctx, "/.versions/notes.txt/n2", []byte("x"), 0); err != syscall.EROFS {
+		t.Errorf("WriteFile of a version = %v, want EROFS", err)
+	}
+	if err := fs.Remove(ctx, "/.versions/notes.txt/n2"); err != syscall.EROFS {
+		t.Errorf("Remove of a version = %v, want EROFS", err)
+	}
+	if err := fs.Rename(ctx, "/notes.txt", "/.versions/notes.txt/n4"); err != syscall.EROFS {
+		t.Errorf("Rename into .versions = %v, want EROFS", err)
+	}
+	entries, _ = fs.ReadDir(ctx, "/")
+	for _, entry := range entries {
+		if entry.Name == versionsDir {
+			t.Error("ReadDir(/) lists .versions")
+		}
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := newVersionedFilesystem(t)
+
+	// An older version replaces the current one
+	if data, err := fs.ReadFile(ctx, "/notes.txt", 0, 0); err != nil || string(data) != "third" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	if err := fs.Restore(ctx, "/notes.txt", "n1"); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if data, err := fs.ReadFile(ctx, "/notes.txt", 0, 0); err != nil || string(data) != "first" {
+		t.Errorf("ReadFile after Restore = %q, %v; want %q", data, err, "first")
+	}
+
+	// A deleted file comes back, even after a lookup cached its absence
+	if _, err := fs.GetAttr(ctx, "/gone.txt"); err == nil {
+		t.Fatal("Deleted file exists")
+	}
+	if err := fs.Restore(ctx, "/gone.txt", "g1"); err != nil {
+		t.Fatalf("Restore of a deleted file failed: %v", err)
+	}
+	if attr, err := fs.GetAttr(ctx, "/gone.txt"); err != nil || attr.Size != 4 {
+		t.Errorf("GetAttr after undelete = %v, %v; want 4 bytes", attr, err)
+	}
+
+	fs.SetReadOnly(true)
+	if err := fs.Restore(ctx, "/notes.txt", "n2"); err != syscall.EROFS {
+		t.Errorf("Restore on a read-only mount = %v, want EROFS", err)
+	}
+}
@@ -0,0 +1,174 @@
+package fuse
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/cache"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+)
+
+// DefaultFlushInterval is how often write-back mode uploads buffered data when
+// SetWriteBack is given no interval
+const DefaultFlushInterval = 5 * time.Second
+
+// writeBackFlusher uploads buffered data in the background for write-back mode
+type writeBackFlusher struct {
+	wake chan struct{} // Requests a flush before the next tick
+	stop chan struct{}
+	done chan struct{} // Closed when the flusher goroutine has exited
+}
+
+// SetWriteBack switches between uploading writes as they happen (the default)
+// and write-back mode. In write-back mode WriteFile and AppendFile only buffer
+// data; a background goroutine uploads the buffered data of every file each
+// flushInterval, and as soon as a file buffers maxDirtyData bytes. Flush, Fsync
+// and Release still upload synchronously. A flushInterval of 0 uses
+// DefaultFlushInterval. Disabling write-back stops the goroutine once its current
+// flush is done, leaving data that is still buffered to Flush, Release or FlushAll
+func (fs *Filesystem) SetWriteBack(enabled bool, flushInterval time.Duration) {
+	fs.flusherMu.Lock()
+	defer fs.flusherMu.Unlock()
+
+	if fs.flusher != nil {
+		close(fs.flusher.stop)
+		<-fs.flusher.done
+		fs.flusher = nil
+	}
+	fs.writeBack = enabled && fs.cache != nil
+	if !fs.writeBack {
+		return
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	fs.flusher = &writeBackFlusher{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go fs.runFlusher(fs.flusher, flushInterval)
+}
+
+// runFlusher flushes buffered data every interval and when woken, until stopped
+func (fs *Filesystem) runFlusher(flusher *writeBackFlusher, interval time.Duration) {
+	defer close(flusher.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-flusher.stop:
+			return
+		case <-ticker.C:
+		case <-flusher.wake:
+		}
+		fs.flushWriteBack(context.Background())
+	}
+}
+
+// flushWriteBack uploads the buffered data of every file once
+// Each file is uploaded under its own entity locks, so writes to other files
+// carry on meanwhile. A file removed while it is being flushed is skipped, and
+// failures are left buffered for the next flush
+func (fs *Filesystem) flushWriteBack(ctx context.Context) {
+	fdCache := fs.cache.GetFdCache()
+	for _, path := range fdCache.GetBufferedPaths("") {
+		entity, found := fdCache.Get(path)
+		if !found {
+			continue // Closed since it was listed
+		}
+		if fs.enableFileLock {
+			entity.FileLock.Lock()
+		}
+		err := fs.uploadBufferedData(ctx, path, entity)
+		if fs.enableFileLock {
+			entity.FileLock.Unlock()
+		}
+		if err != nil {
+			logging.Warn("background flush failed, keeping data buffered for retry", "path", path, "bytes", entity.BytesModified(), "err", err)
+		}
+	}
+}
+
+// relieveMemory uploads the writes buffered by the files holding the most dirty
+// pages until the page cache is back within its memory bound, so the pages can
+// be evicted. The file being written, whose lock the caller holds, is uploaded
+// like any other; files whose lock is held elsewhere are left to their writer.
+// Only a failure to upload that file is returned
+func (fs *Filesystem) relieveMemory(ctx context.Context, path string, current *cache.FdEntity) error {
+	fdCache := fs.cache.GetFdCache()
+	type buffered struct {
+		path   string
+		entity *cache.FdEntity
+		bytes  int64
+	}
+	var files []buffered
+	for _, bufferedPath := range fdCache.GetBufferedPaths("") {
+		if entity, found := fdCache.Get(bufferedPath); found {
+			files = append(files, buffered{bufferedPath, entity, entity.BytesModified()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].bytes > files[j].bytes })
+
+	for _, file := range files {
+		if !fdCache.OverMemory() {
+			break
+		}
+		if file.entity == current {
+			if err := fs.uploadBufferedData(ctx, path, current); err != nil {
+				return err
+			}
+			continue
+		}
+		if fs.enableFileLock {
+			if !file.entity.FileLock.TryLock() {
+				continue
+			}
+		}
+		err := fs.uploadBufferedData(ctx, file.path, file.entity)
+		if fs.enableFileLock {
+			file.entity.FileLock.Unlock()
+		}
+		if err != nil {
+			logging.Warn("flush over the page cache memory limit failed, keeping data buffered", "path", file.path, "bytes", file.bytes, "err", err)
+		}
+	}
+	return nil
+}
+
+// flushSoon asks the write-back flusher to run without waiting for its next tick
+func (fs *Filesystem) flushSoon() {
+	fs.flusherMu.Lock()
+	defer fs.flusherMu.Unlock()
+	if fs.flusher == nil {
+		return
+	}
+	select {
+	case fs.flusher.wake <- struct{}{}:
+	default: // A flush is already pending
+	}
+}
+
+// SetFlushOnEvict lets the FD cache make room under its open file limit with
+// files no handle holds that still buffer writes, by uploading those writes in
+// the background before evicting them. Without it such files are never evicted
+// and wait for the write-back flusher or FlushAll to upload them
+func (fs *Filesystem) SetFlushOnEvict(enable bool) {
+	if fs.cache == nil {
+		return
+	}
+	var flush cache.FlushFunc
+	if enable {
+		flush = func(path string, entity *cache.FdEntity) error {
+			if fs.enableFileLock {
+				entity.FileLock.Lock()
+				defer entity.FileLock.Unlock()
+			}
+			return fs.uploadBufferedData(context.Background(), path, entity)
+		}
+	}
+	fs.cache.GetFdCache().SetEvictFlush(flush)
+}
@@ -0,0 +1,179 @@
+package fuse
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// uploadTrackingBackend counts uploads and can hold them until released
+type uploadTrackingBackend struct {
+	types.Backend
+	uploads int64
+	started chan string   // Receives the path of each upload, if set
+	release chan struct{} // Uploads wait for it, if set
+}
+
+func (b *uploadTrackingBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	atomic.AddInt64(&b.uploads, 1)
+	select {
+	case b.started <- path:
+	default: // Nobody waiting, or not tracked
+	}
+	if b.release != nil {
+		<-b.release
+	}
+	return b.Backend.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+func (b *uploadTrackingBackend) uploadCount() int64 {
+	return atomic.LoadInt64(&b.uploads)
+}
+
+func newWriteBackFilesystem(t *testing.T, flushInterval time.Duration) (*Filesystem, *uploadTrackingBackend, *s3client.MockClient) {
+	return newWriteBackFilesystemWith(t, flushInterval, &uploadTrackingBackend{})
+}
+
+// newWriteBackFilesystemWith is newWriteBackFilesystem over backend, whose
+// channels must be set up before the flusher starts
+func newWriteBackFilesystemWith(t *testing.T, flushInterval time.Duration, backend *uploadTrackingBackend) (*Filesystem, *uploadTrackingBackend, *s3client.MockClient) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	backend.Backend = newS3Adapter(client)
+	filesystem := NewFilesystemWithBackend(backend)
+	filesystem.SetWriteBack(true, flushInterval)
+	t.Cleanup(func() { filesystem.SetWriteBack(false, 0) })
+	return filesystem, backend, client
+}
+
+// waitForObject polls until key holds want or the deadline passes
+func waitForObject(t *testing.T, client *s3client.MockClient, key, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, err := client.GetObject(context.Background(), key)
+		if err == nil && string(data) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Object %s = %q (err %v), want %q", key, data, err, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriteBackDefersUploads(t *testing.T) {
+	filesystem, backend, client := newWriteBackFilesystem(t, time.Hour)
+	ctx := context.Background()
+
+	if err := filesystem.WriteFile(ctx, "log.txt", []byte("one\n"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	for _, line := range []string{"two\n", "three\n"} {
+		if _, err := filesystem.AppendFile(ctx, "log.txt", []byte(line)); err != nil {
+			t.Fatalf("AppendFile failed: %v", err)
+		}
+	}
+	if n := backend.uploadCount(); n != 0 {
+		t.Errorf("Writes uploaded %d times in write-back mode, want 0", n)
+	}
+
+	// Buffered data is visible before it is uploaded
+	data, err := filesystem.ReadFile(ctx, "log.txt", 0, 0)
+	if err != nil || string(data) != "one\ntwo\nthree\n" {
+		t.Errorf("ReadFile = %q, %v; want the buffered lines", data, err)
+	}
+
+	// Flush still uploads before returning
+	if err := filesystem.Flush(ctx, "log.txt"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if data, err := client.GetObject(ctx, "log.txt"); err != nil || string(data) != "one\ntwo\nthree\n" {
+		t.Errorf("Object after Flush = %q, %v", data, err)
+	}
+	if n := backend.uploadCount(); n != 1 {
+		t.Errorf("Flush uploaded %d times, want 1", n)
+	}
+}
+
+func TestWriteBackBackgroundFlush(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Interval", func(t *testing.T) {
+		filesystem, _, client := newWriteBackFilesystem(t, 10*time.Millisecond)
+		if err := filesystem.WriteFile(ctx, "a.txt", []byte("hello"), 0); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := filesystem.WriteFile(ctx, "a.txt", []byte(" world"), 5); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		waitForObject(t, client, "a.txt", "hello world")
+	})
+
+	t.Run("MaxDirtyData", func(t *testing.T) {
+		filesystem, backend, client := newWriteBackFilesystem(t, time.Hour)
+		filesystem.SetMaxDirtyData(8)
+		if err := filesystem.WriteFile(ctx, "b.txt", []byte("1234"), 0); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if n := backend.uploadCount(); n != 0 {
+			t.Fatalf("Uploaded %d times below maxDirtyData, want 0", n)
+		}
+		if _, err := filesystem.AppendFile(ctx, "b.txt", []byte("56789")); err != nil {
+			t.Fatalf("AppendFile failed: %v", err)
+		}
+		waitForObject(t, client, "b.txt", "123456789")
+	})
+}
+
+func TestWriteBackReleaseUploads(t *testing.T) {
+	filesystem, _, client := newWriteBackFilesystem(t, time.Hour)
+	ctx := context.Background()
+
+	if err := filesystem.WriteFile(ctx, "c.txt", []byte("data"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := filesystem.Release(ctx, "c.txt"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if data, err := client.GetObject(ctx, "c.txt"); err != nil || string(data) != "data" {
+		t.Errorf("Object after Release = %q, %v; want %q", data, err, "data")
+	}
+}
+
+func TestWriteBackRemoveDuringFlush(t *testing.T) {
+	// Hold the background upload while the file is removed
+	backend := &uploadTrackingBackend{started: make(chan string, 1), release: make(chan struct{})}
+	filesystem, backend, client := newWriteBackFilesystemWith(t, 10*time.Millisecond, backend)
+	ctx := context.Background()
+
+	if err := filesystem.WriteFile(ctx, "gone.txt", []byte("data"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	<-backend.started
+	removed := make(chan error, 1)
+	go func() { removed <- filesystem.Remove(ctx, "gone.txt") }()
+
+	select {
+	case err := <-removed:
+		t.Fatalf("Remove returned (%v) while an upload of the file was in flight", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(backend.release)
+	if err := <-removed; err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	// Later flushes must not bring the file back
+	time.Sleep(50 * time.Millisecond)
+	if _, err := client.GetObject(ctx, "gone.txt"); err == nil {
+		t.Error("Removed file was uploaded again by the flusher")
+	}
+	if n := backend.uploadCount(); n != 1 {
+		t.Errorf("Uploaded %d times, want only the flush Remove waited for", n)
+	}
+}
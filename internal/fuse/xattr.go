@@ -4,22 +4,125 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/cache"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// Read-only extended attributes of files, answered from what the backend
+// reports about the stored object rather than from its metadata, so they are
+// never written back with it
+const (
+	// ETagXattr holds the object's ETag, e.g. for sync tools comparing versions
+	ETagXattr = "user.s3.etag"
+	// ContentTypeXattr holds the MIME type the object is served with
+	ContentTypeXattr = "user.s3.content-type"
 )
 
+// isObjectXattr reports whether name is one of the read-only object attributes
+func isObjectXattr(name string) bool {
+	return name == ETagXattr || name == ContentTypeXattr
+}
+
+// cachedObject returns the stat cache entry GetAttr left for the stored file
+// at path, if it holds the object's raw metadata, so reading attributes right
+// after a lookup doesn't ask storage again
+func (fs *Filesystem) cachedObject(path string) (*cache.StatCacheEntry, bool) {
+	if fs.cache == nil {
+		return nil, false
+	}
+	entry, found := fs.cache.GetStatCache().Get(path)
+	if !found || entry == nil || entry.Attr == nil || entry.Metadata == nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// objectXattrs returns the read-only attributes of the stored file at
+// normalizedPath that the backend reports a value for
+func (fs *Filesystem) objectXattrs(ctx context.Context, backend types.Backend, path, normalizedPath string) map[string]string {
+	xattrs := make(map[string]string)
+	var etag, contentType string
+	if entry, ok := fs.cachedObject(path); ok {
+		etag, contentType = entry.Attr.ETag, entry.Attr.ContentType
+	} else {
+		attr, err := backend.GetAttr(ctx, normalizedPath)
+		if err != nil {
+			return xattrs
+		}
+		etag, contentType = attr.ETag, attr.ContentType
+	}
+	if etag != "" {
+		xattrs[ETagXattr] = etag
+	}
+	if contentType != "" {
+		xattrs[ContentTypeXattr] = contentType
+	}
+	return xattrs
+}
+
+// fileMetadata returns the raw metadata of the stored file at normalizedPath,
+// from the stat cache when GetAttr put it there
+func (fs *Filesystem) fileMetadata(ctx context.Context, backend types.Backend, path, normalizedPath string) (map[string]string, error) {
+	if entry, ok := fs.cachedObject(path); ok {
+		return entry.Metadata, nil
+	}
+	return backend.GetMetadata(ctx, normalizedPath)
+}
+
+// setObjectXattr answers a set of a read-only object attribute: setting the
+// value it already has succeeds, so cp -a and rsync -X can copy a file's
+// attributes back onto it, and any other value is unsupported, which they
+// skip over rather than fail on
+func (fs *Filesystem) setObjectXattr(ctx context.Context, path, name string, value []byte) error {
+	backend := fs.getBackend()
+	if backend == nil {
+		return fmt.Errorf("no storage backend available")
+	}
+	attr, err := fs.GetAttr(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !attr.Mode.IsDir() {
+		current, ok := fs.objectXattrs(ctx, backend, path, fs.normalizePath(path))[name]
+		if ok && current == string(value) {
+			return nil
+		}
+	}
+	return syscall.ENOTSUP
+}
+
 // SetXattr sets an extended attribute
 func (fs *Filesystem) SetXattr(ctx context.Context, path string, name string, value []byte) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
+	if isObjectXattr(name) {
+		return fs.setObjectXattr(ctx, path, name, value)
+	}
 	// Flush buffered data before updating metadata
 	if err := fs.flushBufferedData(ctx, path); err != nil {
 		return fmt.Errorf("failed to flush buffered data before setxattr: %w", err)
 	}
-	
+
+	// Reject cache TTL overrides that could never be honored
+	if name == types.CacheTTLXattr {
+		if _, err := types.ParseCacheTTL(string(value)); err != nil {
+			return syscall.EINVAL
+		}
+	}
+
 	normalizedPath := fs.normalizePath(path)
 
 	// Check if it's a directory by checking attributes
 	attr, err := fs.GetAttr(ctx, path)
+	if err != nil {
+		return err
+	}
 	isDir := false
-	if err == nil && attr.Mode.IsDir() {
+	if attr.Mode.IsDir() {
 		isDir = true
 		// Normalize directory path
 		if !strings.HasSuffix(normalizedPath, "/") {
@@ -33,9 +136,11 @@ func (fs *Filesystem) SetXattr(ctx context.Context, path string, name string, va
 	}
 
 	var metadata map[string]string
+	var current *types.Attr
+	keepPath := normalizedPath
 	if isDir {
-		// For directories, check for .keep marker
-		keepPath := normalizedPath + ".keep"
+		// For directories, check for a directory marker
+		keepPath, _, _ = fs.dirMarker(ctx, backend, normalizedPath)
 		metadata, err = backend.GetMetadata(ctx, keepPath)
 		if err != nil {
 			// No marker, create new metadata
@@ -43,9 +148,9 @@ func (fs *Filesystem) SetXattr(ctx context.Context, path string, name string, va
 		}
 	} else {
 		// For files, get current metadata
-		metadata, err = backend.GetMetadata(ctx, normalizedPath)
+		current, metadata, err = types.StatMetadata(ctx, backend, normalizedPath)
 		if err != nil {
-			// File doesn't exist yet - create empty metadata for new file
+			// No metadata stored yet
 			metadata = make(map[string]string)
 		}
 	}
@@ -54,55 +159,33 @@ func (fs *Filesystem) SetXattr(ctx context.Context, path string, name string, va
 	// Use base64 encoding for binary values
 	xattrKey := fmt.Sprintf("x-amz-meta-xattr-%s", name)
 	metadata[xattrKey] = string(value)
-	// Update ctime when setting xattr
-	// Always ensure time is at least 1 second after current time to guarantee update
-	now := time.Now()
-	// HeadObject returns keys without prefix, so check "mtime" first
-	currentMtimeStr := metadata["mtime"]
-	if currentMtimeStr == "" {
-		currentMtimeStr = metadata["x-amz-meta-mtime"]
-	}
-	if currentMtimeStr != "" {
-		var currentMtimeUnix int64
-		if _, err := fmt.Sscanf(currentMtimeStr, "%d", &currentMtimeUnix); err == nil {
-			currentMtime := time.Unix(currentMtimeUnix, 0)
-			// Always ensure time is at least 1 second after current to guarantee update
-			if !now.After(currentMtime) {
-				now = currentMtime.Add(time.Second)
-			} else {
-				// Even if now is after, add 1 second to guarantee update
-				now = now.Add(time.Second)
-			}
-		} else {
-			// If parsing failed, use current time + 1 second
-			now = now.Add(time.Second)
-		}
-	} else {
-		// If no mtime in metadata, use current time + 1 second
-		now = now.Add(time.Second)
+	// Update ctime when setting xattr; mtime tracks content and is left alone
+	// HeadObject returns keys without prefix, so check "ctime" first
+	prevCtimeStr := metadata["ctime"]
+	if prevCtimeStr == "" {
+		prevCtimeStr = metadata["x-amz-meta-ctime"]
 	}
+	if prevCtimeStr == "" {
+		prevCtimeStr = metadata["mtime"]
+	}
+	var prevCtime time.Time
+	var prevCtimeUnix int64
+	if _, err := fmt.Sscanf(prevCtimeStr, "%d", &prevCtimeUnix); err == nil {
+		prevCtime = time.Unix(prevCtimeUnix, 0)
+	}
+	now := nextTimestamp(prevCtime)
 	metadata["x-amz-meta-ctime"] = fmt.Sprintf("%d", now.Unix())
 	metadata["ctime"] = fmt.Sprintf("%d", now.Unix())
-	// Also update mtime so GetAttr reflects the change (tests use mtime as proxy for ctime)
-	metadata["x-amz-meta-mtime"] = fmt.Sprintf("%d", now.Unix())
-	metadata["mtime"] = fmt.Sprintf("%d", now.Unix())
 
 	// Update metadata using WriteWithMetadata
 	if isDir {
-		// Directory - update .keep marker with metadata
-		keepPath := normalizedPath + ".keep"
-		err = backend.WriteWithMetadata(ctx, keepPath, []byte{}, metadata)
+		// Directory - update its marker with metadata
+		err = writeDirMarker(ctx, backend, keepPath, metadata)
 		if err != nil {
 			return fmt.Errorf("failed to set xattr on directory: %w", err)
 		}
 	} else {
-		// File - read existing data, then write back with new metadata
-		existingData, err := backend.Read(ctx, normalizedPath)
-		if err != nil {
-			return fmt.Errorf("failed to read file for xattr update: %w", err)
-		}
-		err = backend.WriteWithMetadata(ctx, normalizedPath, existingData, metadata)
-		if err != nil {
+		if err := fs.updateMetadata(ctx, backend, normalizedPath, current, metadata); err != nil {
 			return fmt.Errorf("failed to set xattr: %w", err)
 		}
 	}
@@ -121,8 +204,11 @@ func (fs *Filesystem) GetXattr(ctx context.Context, path string, name string) ([
 
 	// Check if it's a directory by checking attributes
 	attr, err := fs.GetAttr(ctx, path)
+	if err != nil {
+		return nil, err
+	}
 	isDir := false
-	if err == nil && attr.Mode.IsDir() {
+	if attr.Mode.IsDir() {
 		isDir = true
 		// Normalize directory path
 		if !strings.HasSuffix(normalizedPath, "/") {
@@ -135,17 +221,29 @@ func (fs *Filesystem) GetXattr(ctx context.Context, path string, name string) ([
 		return nil, fmt.Errorf("no storage backend available")
 	}
 
+	if isObjectXattr(name) {
+		if isDir {
+			return nil, syscall.ENODATA
+		}
+		value, ok := fs.objectXattrs(ctx, backend, path, normalizedPath)[name]
+		if !ok {
+			return nil, syscall.ENODATA
+		}
+		return []byte(value), nil
+	}
+
 	var metadata map[string]string
 	if isDir {
-		// For directories, check for .keep marker
-		keepPath := normalizedPath + ".keep"
+		// For directories, check for a directory marker
+		keepPath, _, _ := fs.dirMarker(ctx, backend, normalizedPath)
 		metadata, err = backend.GetMetadata(ctx, keepPath)
 		if err != nil {
-			return nil, fmt.Errorf("extended attribute not found: %w", err)
+			// A directory without a marker has no attributes
+			return nil, fmt.Errorf("extended attribute %q not found: %w", name, syscall.ENODATA)
 		}
 	} else {
 		// For files, get metadata
-		metadata, err = backend.GetMetadata(ctx, normalizedPath)
+		metadata, err = fs.fileMetadata(ctx, backend, path, normalizedPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get object metadata: %w", err)
 		}
@@ -159,7 +257,7 @@ func (fs *Filesystem) GetXattr(ctx context.Context, path string, name string) ([
 		// Also check without prefix (HeadObject returns keys without prefix)
 		valueStr, ok = metadata[xattrKeyNoPrefix]
 		if !ok {
-			return nil, fmt.Errorf("extended attribute '%s' not found", name)
+			return nil, fmt.Errorf("extended attribute %q not found: %w", name, syscall.ENODATA)
 		}
 	}
 
@@ -172,8 +270,11 @@ func (fs *Filesystem) ListXattr(ctx context.Context, path string) ([]string, err
 
 	// Check if it's a directory by checking attributes
 	attr, err := fs.GetAttr(ctx, path)
+	if err != nil {
+		return nil, err
+	}
 	isDir := false
-	if err == nil && attr.Mode.IsDir() {
+	if attr.Mode.IsDir() {
 		isDir = true
 		// Normalize directory path
 		if !strings.HasSuffix(normalizedPath, "/") {
@@ -189,44 +290,24 @@ func (fs *Filesystem) ListXattr(ctx context.Context, path string) ([]string, err
 	// For xattrs, we need raw metadata. Try to get it from backend.
 	// For S3 adapter, we can access HeadObject directly
 	var metadata map[string]string
-	if s3Adapter, ok := backend.(*s3Adapter); ok {
-		// Use S3 adapter's client directly to get metadata
-		if isDir {
-			keepPath := normalizedPath + ".keep"
-			metadata, err = s3Adapter.client.HeadObject(ctx, keepPath)
-			if err != nil {
-				return []string{}, nil // No xattrs
-			}
+	var keepPath string
+	if isDir {
+		keepPath, _, _ = fs.dirMarker(ctx, backend, normalizedPath)
+	}
+	if isDir {
+		if s3Adapter, ok := backend.(*s3Adapter); ok {
+			// Use S3 adapter's client directly to get metadata
+			metadata, err = s3Adapter.client.HeadObject(ctx, s3Adapter.key(keepPath))
 		} else {
-			metadata, err = s3Adapter.client.HeadObject(ctx, normalizedPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get object metadata: %w", err)
-			}
+			metadata, err = backend.GetMetadata(ctx, keepPath)
+		}
+		if err != nil {
+			return []string{}, nil // No xattrs
 		}
 	} else {
-		// For other backends, try to get attributes and reconstruct metadata
-		// This won't include xattrs, but at least won't crash
-		if isDir {
-			keepPath := normalizedPath + ".keep"
-			keepAttr, err := backend.GetAttr(ctx, keepPath)
-			if err != nil {
-				return []string{}, nil // No xattrs
-			}
-			metadata = make(map[string]string)
-			metadata["mode"] = fmt.Sprintf("%o", keepAttr.Mode)
-			metadata["uid"] = fmt.Sprintf("%d", keepAttr.Uid)
-			metadata["gid"] = fmt.Sprintf("%d", keepAttr.Gid)
-			metadata["mtime"] = fmt.Sprintf("%d", keepAttr.Mtime.Unix())
-		} else {
-			fileAttr, err := backend.GetAttr(ctx, normalizedPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get object metadata: %w", err)
-			}
-			metadata = make(map[string]string)
-			metadata["mode"] = fmt.Sprintf("%o", fileAttr.Mode)
-			metadata["uid"] = fmt.Sprintf("%d", fileAttr.Uid)
-			metadata["gid"] = fmt.Sprintf("%d", fileAttr.Gid)
-			metadata["mtime"] = fmt.Sprintf("%d", fileAttr.Mtime.Unix())
+		metadata, err = fs.fileMetadata(ctx, backend, path, normalizedPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get object metadata: %w", err)
 		}
 	}
 
@@ -236,20 +317,37 @@ func (fs *Filesystem) ListXattr(ctx context.Context, path string) ([]string, err
 	prefixWithMeta := "x-amz-meta-xattr-"
 	prefixNoMeta := "xattr-"
 	for key := range metadata {
+		var name string
 		if strings.HasPrefix(key, prefixWithMeta) {
-			name := strings.TrimPrefix(key, prefixWithMeta)
-			names = append(names, name)
+			name = strings.TrimPrefix(key, prefixWithMeta)
 		} else if strings.HasPrefix(key, prefixNoMeta) {
-			name := strings.TrimPrefix(key, prefixNoMeta)
+			name = strings.TrimPrefix(key, prefixNoMeta)
+		}
+		// Stored copies of the read-only attributes are shadowed by them
+		if name != "" && !isObjectXattr(name) {
 			names = append(names, name)
 		}
 	}
+	if !isDir {
+		objectAttrs := fs.objectXattrs(ctx, backend, path, normalizedPath)
+		for _, name := range []string{ETagXattr, ContentTypeXattr} {
+			if _, ok := objectAttrs[name]; ok {
+				names = append(names, name)
+			}
+		}
+	}
 
 	return names, nil
 }
 
 // RemoveXattr removes an extended attribute
 func (fs *Filesystem) RemoveXattr(ctx context.Context, path string, name string) error {
+	if fs.readOnly || fs.isVersionPath(path) {
+		return syscall.EROFS
+	}
+	if isObjectXattr(name) {
+		return syscall.EPERM
+	}
 	// Flush buffered data before updating metadata
 	if err := fs.flushBufferedData(ctx, path); err != nil {
 		return fmt.Errorf("failed to flush buffered data before removexattr: %w", err)
@@ -259,8 +357,11 @@ func (fs *Filesystem) RemoveXattr(ctx context.Context, path string, name string)
 
 	// Check if it's a directory by checking attributes
 	attr, err := fs.GetAttr(ctx, path)
+	if err != nil {
+		return err
+	}
 	isDir := false
-	if err == nil && attr.Mode.IsDir() {
+	if attr.Mode.IsDir() {
 		isDir = true
 		// Normalize directory path
 		if !strings.HasSuffix(normalizedPath, "/") {
@@ -274,16 +375,18 @@ func (fs *Filesystem) RemoveXattr(ctx context.Context, path string, name string)
 	}
 
 	var metadata map[string]string
+	var current *types.Attr
+	keepPath := normalizedPath
 	if isDir {
-		// For directories, check for .keep marker
-		keepPath := normalizedPath + ".keep"
+		// For directories, check for a directory marker
+		keepPath, current, _ = fs.dirMarker(ctx, backend, normalizedPath)
 		metadata, err = backend.GetMetadata(ctx, keepPath)
 		if err != nil {
-			return fmt.Errorf("extended attribute not found: %w", err)
+			return fmt.Errorf("extended attribute %q not found: %w", name, syscall.ENODATA)
 		}
 	} else {
 		// For files, get current metadata
-		metadata, err = backend.GetMetadata(ctx, normalizedPath)
+		current, metadata, err = types.StatMetadata(ctx, backend, normalizedPath)
 		if err != nil {
 			return fmt.Errorf("failed to get object metadata: %w", err)
 		}
@@ -302,25 +405,18 @@ func (fs *Filesystem) RemoveXattr(ctx context.Context, path string, name string)
 		found = true
 	}
 	if !found {
-		return fmt.Errorf("extended attribute '%s' not found", name)
+		return fmt.Errorf("extended attribute %q not found: %w", name, syscall.ENODATA)
 	}
 
 	// Update metadata
 	if isDir {
-		// Directory - update .keep marker
-		keepPath := normalizedPath + ".keep"
-		err = backend.WriteWithMetadata(ctx, keepPath, []byte{}, metadata)
-		if err != nil {
+		// Directory - update its marker
+		// Replace rather than write, since some backends merge written metadata
+		if err := fs.updateMetadata(ctx, backend, keepPath, current, metadata); err != nil {
 			return fmt.Errorf("failed to remove xattr from directory: %w", err)
 		}
 	} else {
-		// File - read existing data, then write back with new metadata
-		existingData, err := backend.Read(ctx, normalizedPath)
-		if err != nil {
-			return fmt.Errorf("failed to read file for xattr removal: %w", err)
-		}
-		err = backend.WriteWithMetadata(ctx, normalizedPath, existingData, metadata)
-		if err != nil {
+		if err := fs.updateMetadata(ctx, backend, normalizedPath, current, metadata); err != nil {
 			return fmt.Errorf("failed to remove xattr: %w", err)
 		}
 	}
@@ -2,9 +2,13 @@ package fuse
 
 import (
 	"context"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 // TestExtendedAttributes tests basic extended attributes operations
@@ -97,7 +101,7 @@ func TestUpdateTimeXattr(t *testing.T) {
 		t.Fatalf("Failed to get initial attributes: %v", err)
 	}
 
-	initialCtime := attr1.Mtime // Using Mtime as proxy for ctime
+	initialCtime := attr1.Ctime
 
 	// Set extended attribute (should update ctime)
 	xattrName := "user.test"
@@ -114,7 +118,7 @@ func TestUpdateTimeXattr(t *testing.T) {
 	}
 
 	// Ctime should be updated (or at least not before initial)
-	if attr2.Mtime.Before(initialCtime) {
+	if attr2.Ctime.Before(initialCtime) {
 		t.Error("Ctime should be updated after setting xattr")
 	}
 
@@ -145,7 +149,7 @@ func TestUpdateDirectoryTimeSetXattr(t *testing.T) {
 		t.Fatalf("Failed to get initial directory attributes: %v", err)
 	}
 
-	initialCtime := attr1.Mtime
+	initialCtime := attr1.Ctime
 
 	// Set extended attribute on directory
 	xattrName := "user.test"
@@ -162,10 +166,163 @@ func TestUpdateDirectoryTimeSetXattr(t *testing.T) {
 	}
 
 	// Ctime should be updated
-	if attr2.Mtime.Before(initialCtime) {
+	if attr2.Ctime.Before(initialCtime) {
 		t.Error("Directory ctime should be updated after setting xattr")
 	}
 
 	// Cleanup
 	fs.Remove(ctx, testDir+".keep")
 }
+
+// TestCacheTTLXattr tests that user.s3fs.cache_ttl overrides the stat cache TTL per path
+func TestCacheTTLXattr(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	shortFile := "test-ttl-short.txt"
+	longFile := "test-ttl-long.txt"
+
+	if err := fs.Create(ctx, shortFile, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := fs.Create(ctx, longFile, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if err := fs.SetXattr(ctx, shortFile, types.CacheTTLXattr, []byte("2s")); err != nil {
+		t.Fatalf("Failed to set short TTL: %v", err)
+	}
+	if err := fs.SetXattr(ctx, longFile, types.CacheTTLXattr, []byte("3600")); err != nil {
+		t.Fatalf("Failed to set long TTL: %v", err)
+	}
+
+	// Populate the stat cache
+	if _, err := fs.GetAttr(ctx, shortFile); err != nil {
+		t.Fatalf("Failed to get attributes: %v", err)
+	}
+	if _, err := fs.GetAttr(ctx, longFile); err != nil {
+		t.Fatalf("Failed to get attributes: %v", err)
+	}
+
+	statCache := fs.cache.GetStatCache()
+	shortEntry, found := statCache.Get(shortFile)
+	if !found {
+		t.Fatal("Expected stat cache entry for short TTL file")
+	}
+	longEntry, found := statCache.Get(longFile)
+	if !found {
+		t.Fatal("Expected stat cache entry for long TTL file")
+	}
+
+	shortTTL := time.Until(shortEntry.ExpiresAt)
+	longTTL := time.Until(longEntry.ExpiresAt)
+	if shortTTL > 2*time.Second {
+		t.Errorf("Expected short TTL <= 2s, got %v", shortTTL)
+	}
+	if longTTL < 59*time.Minute {
+		t.Errorf("Expected long TTL ~1h, got %v", longTTL)
+	}
+
+	// Invalid TTL values are rejected
+	if err := fs.SetXattr(ctx, shortFile, types.CacheTTLXattr, []byte("soon")); err != syscall.EINVAL {
+		t.Errorf("Expected EINVAL for invalid TTL, got %v", err)
+	}
+
+	// A zero TTL would silently mean the default, so it is rejected too
+	for _, value := range []string{"0", "0s"} {
+		if err := fs.SetXattr(ctx, shortFile, types.CacheTTLXattr, []byte(value)); err != syscall.EINVAL {
+			t.Errorf("Expected EINVAL for TTL %q, got %v", value, err)
+		}
+	}
+}
+
+func TestObjectXattrs(t *testing.T) {
+	client := s3client.NewMockClient("test-bucket", "us-east-1")
+	fs := NewFilesystem(client)
+	ctx := context.Background()
+
+	if err := fs.WriteFile(ctx, "synced.txt", []byte("first version"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.SetXattr(ctx, "synced.txt", "user.note", []byte("kept")); err != nil {
+		t.Fatalf("SetXattr failed: %v", err)
+	}
+	etag, _ := client.HeadObjectETag(ctx, "synced.txt")
+	if value, err := fs.GetXattr(ctx, "synced.txt", ETagXattr); err != nil || string(value) != etag {
+		t.Errorf("%s = %q, %v; want %q", ETagXattr, value, err, etag)
+	}
+	if value, err := fs.GetXattr(ctx, "synced.txt", ContentTypeXattr); err != nil || string(value) != "text/plain; charset=utf-8" {
+		t.Errorf("%s = %q, %v", ContentTypeXattr, value, err)
+	}
+	names, err := fs.ListXattr(ctx, "synced.txt")
+	if err != nil {
+		t.Fatalf("ListXattr failed: %v", err)
+	}
+	listed := make(map[string]bool)
+	for _, name := range names {
+		listed[name] = true
+	}
+	if len(names) != 3 || !listed["user.note"] || !listed[ETagXattr] || !listed[ContentTypeXattr] {
+		t.Errorf("ListXattr = %v, want user.note and the object attributes", names)
+	}
+
+	// After a lookup they are answered from the stat cache, as getfattr -d
+	// on many files would otherwise cost a HEAD per attribute
+	if _, err := fs.GetAttr(ctx, "synced.txt"); err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	heads := client.HeadCount()
+	if value, err := fs.GetXattr(ctx, "synced.txt", ETagXattr); err != nil || string(value) != etag {
+		t.Errorf("Cached %s = %q, %v; want %q", ETagXattr, value, err, etag)
+	}
+	if value, err := fs.GetXattr(ctx, "synced.txt", "user.note"); err != nil || string(value) != "kept" {
+		t.Errorf("Cached user.note = %q, %v", value, err)
+	}
+	if names, err := fs.ListXattr(ctx, "synced.txt"); err != nil || len(names) != 3 {
+		t.Errorf("Cached ListXattr = %v, %v", names, err)
+	}
+	if n := client.HeadCount() - heads; n != 0 {
+		t.Errorf("Reading attributes after a lookup made %d HEAD requests, want 0", n)
+	}
+
+	// They are read-only, but copying a file's attributes back onto it works
+	if err := fs.SetXattr(ctx, "synced.txt", ETagXattr, []byte(`"forged"`)); err != syscall.ENOTSUP {
+		t.Errorf("SetXattr of %s = %v, want ENOTSUP", ETagXattr, err)
+	}
+	if err := fs.SetXattr(ctx, "synced.txt", ETagXattr, []byte(etag)); err != nil {
+		t.Errorf("SetXattr of %s to its own value = %v, want nil", ETagXattr, err)
+	}
+	if err := fs.SetXattr(ctx, "synced.txt", ContentTypeXattr, []byte("text/plain; charset=utf-8")); err != nil {
+		t.Errorf("SetXattr of %s to its own value = %v, want nil", ContentTypeXattr, err)
+	}
+	if err := fs.RemoveXattr(ctx, "synced.txt", ContentTypeXattr); err != syscall.EPERM {
+		t.Errorf("RemoveXattr of %s = %v, want EPERM", ContentTypeXattr, err)
+	}
+
+	// A later write stores none of them and the ETag follows the content
+	if err := fs.WriteFile(ctx, "synced.txt", []byte("second version"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.Flush(ctx, "synced.txt"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	metadata, _ := client.HeadObject(ctx, "synced.txt")
+	for key := range metadata {
+		if strings.Contains(key, "s3.etag") || strings.Contains(key, "s3.content-type") {
+			t.Errorf("Object attribute stored in metadata as %q", key)
+		}
+	}
+	newETag, _ := client.HeadObjectETag(ctx, "synced.txt")
+	if value, err := fs.GetXattr(ctx, "synced.txt", ETagXattr); err != nil || string(value) != newETag || newETag == etag {
+		t.Errorf("%s after rewrite = %q, %v; want %q", ETagXattr, value, err, newETag)
+	}
+
+	// Directories have no object to describe
+	if err := fs.Mkdir(ctx, "folder", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, err := fs.GetXattr(ctx, "folder", ETagXattr); err != syscall.ENODATA {
+		t.Errorf("GetXattr of %s on a directory = %v, want ENODATA", ETagXattr, err)
+	}
+}
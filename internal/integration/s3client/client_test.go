@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/s3fs-fuse/s3fs-go/internal/integration"
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
 )
 
 // TestPutGet tests putting and getting objects
@@ -76,6 +77,46 @@ func TestListObjects(t *testing.T) {
 	}
 }
 
+// TestListObjectsPagination tests listing more keys than fit in one ListObjectsV2 page
+func TestListObjectsPagination(t *testing.T) {
+	client := integration.SetupTestClient(t, integration.LocalStackBucket, integration.LocalStackRegion)
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("test-list-paged-%d", time.Now().UnixNano())
+	const count = 1500
+
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s/file-%04d.txt", prefix, i)
+		if err := client.PutObject(ctx, keys[i], []byte("x")); err != nil {
+			t.Fatalf("Failed to put object %s: %v", keys[i], err)
+		}
+	}
+	defer func() {
+		for _, key := range keys {
+			client.DeleteObject(ctx, key)
+		}
+	}()
+
+	objects, err := client.ListObjects(ctx, prefix+"/")
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+
+	if len(objects) != count {
+		t.Fatalf("Expected %d objects, got %d", count, len(objects))
+	}
+	listed := make(map[string]bool, len(objects))
+	for _, key := range objects {
+		listed[key] = true
+	}
+	for _, key := range keys {
+		if !listed[key] {
+			t.Errorf("Key %s missing from listing", key)
+		}
+	}
+}
+
 // TestDeleteObject tests deleting objects
 func TestDeleteObject(t *testing.T) {
 	client := integration.SetupTestClient(t, integration.LocalStackBucket, integration.LocalStackRegion)
@@ -197,3 +238,58 @@ func TestPutObjectWithMetadata(t *testing.T) {
 	// Cleanup
 	client.DeleteObject(ctx, testKey)
 }
+
+// TestListHidesDeleteMarkers tests that deleted keys in a versioned bucket stay hidden by default
+func TestListHidesDeleteMarkers(t *testing.T) {
+	client := integration.SetupTestClient(t, "test-bucket-versioned", integration.LocalStackRegion)
+	ctx := context.Background()
+
+	if err := client.EnableVersioning(ctx); err != nil {
+		t.Fatalf("EnableVersioning failed: %v", err)
+	}
+
+	prefix := fmt.Sprintf("test-versions-%d/", time.Now().UnixNano())
+	keptKey := prefix + "kept.txt"
+	deletedKey := prefix + "deleted.txt"
+
+	for _, key := range []string{keptKey, deletedKey} {
+		if err := client.PutObject(ctx, key, []byte("v1")); err != nil {
+			t.Fatalf("Failed to put object %s: %v", key, err)
+		}
+	}
+	// Second version so the deleted key has non-current history
+	if err := client.PutObject(ctx, deletedKey, []byte("v2")); err != nil {
+		t.Fatalf("Failed to put second version: %v", err)
+	}
+	if err := client.DeleteObject(ctx, deletedKey); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+
+	// Both the default listing and the version-aware listing hide delete-markered keys
+	for _, options := range []s3client.ListOptions{{}, {IncludeNonCurrent: true}} {
+		client.SetListOptions(options)
+		keys, err := client.ListObjects(ctx, prefix)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		if len(keys) != 1 || keys[0] != keptKey {
+			t.Errorf("Expected only %s with %+v, got %v", keptKey, options, keys)
+		}
+	}
+
+	// Opting in shows the delete-markered key
+	client.SetListOptions(s3client.ListOptions{ShowDeleteMarkers: true})
+	keys, err := client.ListObjects(ctx, prefix)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	found := false
+	for _, key := range keys {
+		if key == deletedKey {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s to be listed with ShowDeleteMarkers, got %v", deletedKey, keys)
+	}
+}
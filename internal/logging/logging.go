@@ -0,0 +1,71 @@
+// Package logging is the leveled logger shared by all s3fs packages
+//
+// Output is discarded until SetOutput configures a level, so library code and
+// tests stay quiet; cmd/s3fs sets it from the -log_level flag
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// LevelOff disables all output
+const LevelOff = slog.Level(100)
+
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: LevelOff})))
+}
+
+// ParseLevel parses a -log_level value: debug, info, warn, error or off
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	case "off", "none", "silent":
+		return LevelOff, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn, error or off)", name)
+}
+
+// SetOutput sends messages at level and above to w as key=value lines
+func SetOutput(w io.Writer, level slog.Level) {
+	logger.Store(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})))
+}
+
+// Enabled reports whether messages at level are written
+// Lets callers skip building expensive arguments
+func Enabled(level slog.Level) bool {
+	return logger.Load().Enabled(context.Background(), level)
+}
+
+// Debug logs msg with key/value pairs at debug level
+func Debug(msg string, args ...any) {
+	logger.Load().Debug(msg, args...)
+}
+
+// Info logs msg with key/value pairs at info level
+func Info(msg string, args ...any) {
+	logger.Load().Info(msg, args...)
+}
+
+// Warn logs msg with key/value pairs at warn level
+func Warn(msg string, args ...any) {
+	logger.Load().Warn(msg, args...)
+}
+
+// Error logs msg with key/value pairs at error level
+func Error(msg string, args ...any) {
+	logger.Load().Error(msg, args...)
+}
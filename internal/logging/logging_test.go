@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level slog.Level
+	}{
+		{"debug", slog.LevelDebug},
+		{"INFO", slog.LevelInfo},
+		{"", slog.LevelInfo},
+		{"warn", slog.LevelWarn},
+		{"error", slog.LevelError},
+		{"off", LevelOff},
+	}
+	for _, tt := range tests {
+		level, err := ParseLevel(tt.name)
+		if err != nil || level != tt.level {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", tt.name, level, err, tt.level)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	defer SetOutput(io.Discard, LevelOff)
+
+	var buf bytes.Buffer
+	SetOutput(&buf, slog.LevelWarn)
+	Debug("hidden debug")
+	Info("hidden info")
+	Warn("shown warning", "path", "a.txt")
+	Error("shown error")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("Messages below the level were written: %q", out)
+	}
+	if !strings.Contains(out, `msg="shown warning" path=a.txt`) || !strings.Contains(out, "shown error") {
+		t.Errorf("Expected warning and error, got %q", out)
+	}
+	if Enabled(slog.LevelInfo) || !Enabled(slog.LevelError) {
+		t.Error("Enabled does not match the configured level")
+	}
+}
+
+func TestDefaultIsSilent(t *testing.T) {
+	if Enabled(slog.LevelError) {
+		t.Error("Logging should be off until SetOutput is called")
+	}
+}
@@ -0,0 +1,259 @@
+// Package metrics counts backend operations and cache lookups and serves them
+// in the Prometheus text exposition format
+//
+// Collection is off until Enable (or Serve) is called; until then every
+// recording function returns after a single atomic load
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend operations tracked by StartOp
+const (
+	OpGet    = "get"
+	OpPut    = "put"
+	OpHead   = "head"
+	OpList   = "list"
+	OpDelete = "delete"
+	OpCopy   = "copy"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var enabled atomic.Bool
+
+// opMetrics holds the call count and latency histogram of one operation
+type opMetrics struct {
+	buckets []atomic.Uint64 // Cumulative counts are computed when written out
+	count   atomic.Uint64
+	sumBits atomic.Uint64 // float64 seconds
+}
+
+func newOpMetrics() *opMetrics {
+	return &opMetrics{buckets: make([]atomic.Uint64, len(latencyBuckets))}
+}
+
+// observe records one call that took d
+func (m *opMetrics) observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.buckets[i].Add(1)
+			break
+		}
+	}
+	m.count.Add(1)
+	for {
+		old := m.sumBits.Load()
+		sum := math.Float64frombits(old) + seconds
+		if m.sumBits.CompareAndSwap(old, math.Float64bits(sum)) {
+			return
+		}
+	}
+}
+
+// cacheMetrics counts lookups in one cache
+type cacheMetrics struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+var (
+	ops = map[string]*opMetrics{
+		OpGet:    newOpMetrics(),
+		OpPut:    newOpMetrics(),
+		OpHead:   newOpMetrics(),
+		OpList:   newOpMetrics(),
+		OpDelete: newOpMetrics(),
+		OpCopy:   newOpMetrics(),
+	}
+	statCache cacheMetrics
+	pageCache cacheMetrics
+
+	// Bytes stored under the mount and its quota, once usage is tracked
+	usageTracked atomic.Bool
+	usedBytes    atomic.Int64
+	quotaBytes   atomic.Int64
+
+	// Endpoints served next to /metrics, added by Handle
+	handlersMu sync.Mutex
+	handlers   = map[string]http.Handler{}
+)
+
+// Enable turns collection on
+func Enable() {
+	enabled.Store(true)
+}
+
+// Enabled reports whether collection is on
+func Enabled() bool {
+	return enabled.Load()
+}
+
+func noop() {}
+
+// StartOp starts timing a backend operation; call the returned function when it
+// finishes. Use as: defer metrics.StartOp(metrics.OpGet)()
+func StartOp(op string) func() {
+	if !enabled.Load() {
+		return noop
+	}
+	m, ok := ops[op]
+	if !ok {
+		return noop
+	}
+	start := time.Now()
+	return func() {
+		m.observe(time.Since(start))
+	}
+}
+
+// StatCacheHit records a stat cache lookup that found a live entry
+func StatCacheHit() {
+	if enabled.Load() {
+		statCache.hits.Add(1)
+	}
+}
+
+// StatCacheMiss records a stat cache lookup that found nothing usable
+func StatCacheMiss() {
+	if enabled.Load() {
+		statCache.misses.Add(1)
+	}
+}
+
+// PageCacheHit records a read served from the FD page cache
+func PageCacheHit() {
+	if enabled.Load() {
+		pageCache.hits.Add(1)
+	}
+}
+
+// PageCacheMiss records a read that had to go to the backend
+func PageCacheMiss() {
+	if enabled.Load() {
+		pageCache.misses.Add(1)
+	}
+}
+
+// SetUsage records the bytes stored under the mount and the quota on them
+// (0 = unlimited), for filesystems that track usage
+func SetUsage(used, quota int64) {
+	if enabled.Load() {
+		usedBytes.Store(used)
+		quotaBytes.Store(quota)
+		usageTracked.Store(true)
+	}
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func WriteText(w io.Writer) error {
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("# HELP s3fs_backend_operations_total Backend operations performed, by type.\n")
+	printf("# TYPE s3fs_backend_operations_total counter\n")
+	for _, name := range names {
+		printf("s3fs_backend_operations_total{op=%q} %d\n", name, ops[name].count.Load())
+	}
+
+	printf("# HELP s3fs_backend_operation_duration_seconds Backend operation latency, by type.\n")
+	printf("# TYPE s3fs_backend_operation_duration_seconds histogram\n")
+	for _, name := range names {
+		m := ops[name]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += m.buckets[i].Load()
+			printf("s3fs_backend_operation_duration_seconds_bucket{op=%q,le=\"%g\"} %d\n", name, bound, cumulative)
+		}
+		count := m.count.Load()
+		printf("s3fs_backend_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", name, count)
+		printf("s3fs_backend_operation_duration_seconds_sum{op=%q} %g\n", name, math.Float64frombits(m.sumBits.Load()))
+		printf("s3fs_backend_operation_duration_seconds_count{op=%q} %d\n", name, count)
+	}
+
+	printf("# HELP s3fs_cache_hits_total Cache lookups answered from the cache.\n")
+	printf("# TYPE s3fs_cache_hits_total counter\n")
+	printf("s3fs_cache_hits_total{cache=\"page\"} %d\n", pageCache.hits.Load())
+	printf("s3fs_cache_hits_total{cache=\"stat\"} %d\n", statCache.hits.Load())
+	printf("# HELP s3fs_cache_misses_total Cache lookups that fell through to the backend.\n")
+	printf("# TYPE s3fs_cache_misses_total counter\n")
+	printf("s3fs_cache_misses_total{cache=\"page\"} %d\n", pageCache.misses.Load())
+	printf("s3fs_cache_misses_total{cache=\"stat\"} %d\n", statCache.misses.Load())
+
+	if usageTracked.Load() {
+		printf("# HELP s3fs_used_bytes Bytes stored under the mount.\n")
+		printf("# TYPE s3fs_used_bytes gauge\n")
+		printf("s3fs_used_bytes %d\n", usedBytes.Load())
+		printf("# HELP s3fs_quota_bytes Most bytes the mount may store (0 = unlimited).\n")
+		printf("# TYPE s3fs_quota_bytes gauge\n")
+		printf("s3fs_quota_bytes %d\n", quotaBytes.Load())
+	}
+	return err
+}
+
+// Handler returns an http.Handler serving WriteText
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
+
+// Handle adds an endpoint at path to the server Serve starts, e.g. for status
+// that doesn't fit the metrics format. It may be called before or after Serve
+func Handle(path string, handler http.Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[path] = handler
+}
+
+// serveHandled serves the endpoints added by Handle
+func serveHandled(w http.ResponseWriter, r *http.Request) {
+	handlersMu.Lock()
+	handler, ok := handlers[r.URL.Path]
+	handlersMu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// Serve enables collection and serves /metrics on addr in the background
+// Returns once the address is bound, so a bad address is reported immediately
+func Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	mux.HandleFunc("/", serveHandled)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	Enable()
+	go server.Serve(listener)
+	return server, nil
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reset zeroes every metric and disables collection
+func reset() {
+	enabled.Store(false)
+	for name := range ops {
+		ops[name] = newOpMetrics()
+	}
+	for _, c := range []*cacheMetrics{&statCache, &pageCache} {
+		c.hits.Store(0)
+		c.misses.Store(0)
+	}
+	usageTracked.Store(false)
+	usedBytes.Store(0)
+	quotaBytes.Store(0)
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read scrape: %v", err)
+	}
+	return string(body)
+}
+
+func expectLines(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("Missing %q in output:\n%s", line, output)
+		}
+	}
+}
+
+func TestDisabledRecordsNothing(t *testing.T) {
+	reset()
+	defer reset()
+
+	StartOp(OpGet)()
+	StatCacheHit()
+	PageCacheMiss()
+
+	expectLines(t, scrape(t),
+		`s3fs_backend_operations_total{op="get"} 0`,
+		`s3fs_cache_hits_total{cache="stat"} 0`,
+		`s3fs_cache_misses_total{cache="page"} 0`,
+	)
+}
+
+func TestStartOp(t *testing.T) {
+	reset()
+	defer reset()
+	Enable()
+
+	done := StartOp(OpPut)
+	time.Sleep(20 * time.Millisecond)
+	done()
+	StartOp(OpPut)()
+	StartOp("unknown")()
+
+	output := scrape(t)
+	expectLines(t, output,
+		`s3fs_backend_operations_total{op="put"} 2`,
+		`s3fs_backend_operations_total{op="get"} 0`,
+		`s3fs_backend_operation_duration_seconds_bucket{op="put",le="0.005"} 1`,
+		`s3fs_backend_operation_duration_seconds_bucket{op="put",le="10"} 2`,
+		`s3fs_backend_operation_duration_seconds_bucket{op="put",le="+Inf"} 2`,
+		`s3fs_backend_operation_duration_seconds_count{op="put"} 2`,
+		"# TYPE s3fs_backend_operation_duration_seconds histogram",
+	)
+	if strings.Contains(output, "unknown") {
+		t.Error("Unknown operation should not be reported")
+	}
+}
+
+func TestCacheCounters(t *testing.T) {
+	reset()
+	defer reset()
+	Enable()
+
+	StatCacheHit()
+	StatCacheHit()
+	StatCacheMiss()
+	PageCacheHit()
+	PageCacheMiss()
+	PageCacheMiss()
+
+	expectLines(t, scrape(t),
+		`s3fs_cache_hits_total{cache="stat"} 2`,
+		`s3fs_cache_misses_total{cache="stat"} 1`,
+		`s3fs_cache_hits_total{cache="page"} 1`,
+		`s3fs_cache_misses_total{cache="page"} 2`,
+	)
+}
+
+func TestUsage(t *testing.T) {
+	reset()
+	defer reset()
+	Enable()
+
+	if output := scrape(t); strings.Contains(output, "s3fs_used_bytes") {
+		t.Errorf("Usage reported before it was tracked:\n%s", output)
+	}
+	SetUsage(1500, 4096)
+	expectLines(t, scrape(t),
+		`s3fs_used_bytes 1500`,
+		`s3fs_quota_bytes 4096`,
+	)
+}
+
+func TestServeBadAddress(t *testing.T) {
+	reset()
+	defer reset()
+
+	if _, err := Serve("256.0.0.1:bad"); err == nil {
+		t.Fatal("Expected error for an invalid address")
+	}
+	if Enabled() {
+		t.Error("Collection should stay off when the server fails to start")
+	}
+}
+
+func BenchmarkStartOpDisabled(b *testing.B) {
+	reset()
+	for i := 0; i < b.N; i++ {
+		StartOp(OpGet)()
+	}
+}
+
+func TestHandle(t *testing.T) {
+	Handle("/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer delete(handlers, "/status")
+	server := httptest.NewServer(http.HandlerFunc(serveHandled))
+	defer server.Close()
+
+	for path, want := range map[string]int{"/status": http.StatusOK, "/other": http.StatusNotFound} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package s3client
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestParseChecksumAlgorithm(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected types.ChecksumAlgorithm
+	}{
+		{"", ""},
+		{"crc32c", types.ChecksumAlgorithmCrc32c},
+		{"SHA256", types.ChecksumAlgorithmSha256},
+	}
+	for _, tt := range tests {
+		got, err := ParseChecksumAlgorithm(tt.value)
+		if err != nil || got != tt.expected {
+			t.Errorf("%q: got %q, %v; want %q", tt.value, got, err, tt.expected)
+		}
+	}
+	if _, err := ParseChecksumAlgorithm("md4"); err == nil {
+		t.Error("Expected error for unknown checksum algorithm")
+	}
+}
+
+func crc32cBase64(data []byte) string {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// checksumServer serves content with its CRC32C checksum, flipping a byte of
+// the body of the first corrupt responses, and records request headers
+type checksumServer struct {
+	mu       sync.Mutex
+	content  []byte
+	checksum string
+	corrupt  int
+	gets     int
+	headers  []http.Header
+}
+
+func (s *checksumServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers = append(s.headers, r.Header.Clone())
+	switch r.Method {
+	case http.MethodGet:
+		s.gets++
+		body := append([]byte(nil), s.content...)
+		if s.corrupt > 0 {
+			s.corrupt--
+			body[len(body)/2] ^= 0x01
+		}
+		if r.Header.Get("x-amz-checksum-mode") == "ENABLED" {
+			w.Header().Set("x-amz-checksum-crc32c", s.checksum)
+		}
+		w.Write(body)
+	case http.MethodPut:
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"etag"`)
+	}
+}
+
+func newChecksumClient(t *testing.T, server *checksumServer) *Client {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", httpServer.URL, provider)
+	client.SetRetryPolicy(RetryPolicy{})
+	client.SetChecksumAlgorithm(types.ChecksumAlgorithmCrc32c)
+	return client
+}
+
+func TestGetObjectChecksumRetriesCorruptDownload(t *testing.T) {
+	content := []byte("content that must arrive intact")
+	server := &checksumServer{content: content, checksum: crc32cBase64(content), corrupt: 1}
+	client := newChecksumClient(t, server)
+
+	data, err := client.GetObject(context.Background(), "file.txt")
+	if err != nil || string(data) != string(content) {
+		t.Fatalf("GetObject = %q, %v; want %q", data, err, content)
+	}
+	if server.gets != 2 {
+		t.Errorf("GetObject made %d requests, want 2", server.gets)
+	}
+}
+
+func TestGetObjectChecksumMismatchIsEIO(t *testing.T) {
+	content := []byte("content that is corrupted every time")
+	server := &checksumServer{content: content, checksum: crc32cBase64(content), corrupt: 10}
+	client := newChecksumClient(t, server)
+
+	if _, err := client.GetObject(context.Background(), "file.txt"); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("GetObject = %v, want EIO", err)
+	}
+	if server.gets != 2 {
+		t.Errorf("GetObject made %d requests, want 2", server.gets)
+	}
+}
+
+func TestGetObjectChecksumSkipped(t *testing.T) {
+	content := []byte("content of a multipart object")
+	server := &checksumServer{content: content, checksum: crc32cBase64(content) + "-3", corrupt: 1}
+	client := newChecksumClient(t, server)
+	ctx := context.Background()
+
+	// Multipart checksums don't cover the content, and ranges have none
+	if _, err := client.GetObject(ctx, "file.txt"); err != nil {
+		t.Errorf("GetObject of a multipart object failed: %v", err)
+	}
+	if _, err := client.GetObjectRange(ctx, "file.txt", 0, 3); err != nil {
+		t.Errorf("GetObjectRange failed: %v", err)
+	}
+	if mode := server.headers[1].Get("x-amz-checksum-mode"); mode != "" {
+		t.Errorf("Ranged read asked for checksum mode %q", mode)
+	}
+}
+
+func TestUploadChecksums(t *testing.T) {
+	server := &checksumServer{}
+	client := newChecksumClient(t, server)
+	ctx := context.Background()
+	data := []byte("uploaded content")
+
+	if err := client.PutObject(ctx, "file.txt", data); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	put := server.headers[0]
+	if got := put.Get("x-amz-checksum-crc32c"); got != crc32cBase64(data) {
+		t.Errorf("PutObject sent CRC32C %q, want %q (headers %v)", got, crc32cBase64(data), put)
+	}
+
+	if _, err := client.UploadPart(ctx, "file.txt", "upload-id", 1, data); err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	sum := md5.Sum(data)
+	if got, want := server.headers[1].Get("Content-MD5"), base64.StdEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("UploadPart sent Content-MD5 %q, want %q", got, want)
+	}
+}
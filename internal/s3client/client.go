@@ -5,14 +5,21 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	awscreds "github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/s3fs-fuse/s3fs-go/internal/credentials"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
 )
 
 // Client represents an S3 client
@@ -22,6 +29,24 @@ type Client struct {
 	endpoint string
 	creds    *credentials.Credentials
 	s3Client *s3.Client
+	retry    RetryPolicy
+	// Version filtering for listings on versioned buckets
+	listOptions ListOptions
+	// Point in time reads and listings are pinned to (see SetSnapshotTime)
+	snapshot snapshot
+	// Server-side encryption applied to uploads and copies
+	sse sseConfig
+	// Storage class for new objects (empty = bucket default)
+	storageClass types.StorageClass
+	// Set once the provider rejects If-Match on PUT; later writes go without it
+	noConditionalWrites atomic.Bool
+	// Checksum sent with uploads and verified on downloads (empty = none)
+	checksum types.ChecksumAlgorithm
+	// Objects of at least this size are uploaded and copied in parts of partSize
+	multipartThreshold int64
+	partSize           int64
+	// Multipart uploads started and not yet completed or aborted
+	active activeUploads
 }
 
 // NewClient creates a new S3 client
@@ -31,31 +56,51 @@ func NewClient(bucket, region string, creds *credentials.Credentials) *Client {
 
 // NewClientWithEndpoint creates a new S3 client with custom endpoint
 func NewClientWithEndpoint(bucket, region, endpoint string, creds *credentials.Credentials) *Client {
+	var provider aws.CredentialsProvider
+	if creds != nil && creds.IsValid() {
+		provider = creds.Provider()
+	}
+
+	client := NewClientWithProvider(bucket, region, endpoint, provider)
+	client.creds = creds
+	return client
+}
+
+// NewClientWithProvider creates a new S3 client using a credentials provider
+// The provider is consulted on every request, so refreshed credentials
+// (e.g. from an assumed role) are picked up without recreating the client
+func NewClientWithProvider(bucket, region, endpoint string, provider aws.CredentialsProvider) *Client {
 	client := &Client{
 		bucket:   bucket,
 		region:   region,
 		endpoint: endpoint,
-		creds:    creds,
+		retry:    DefaultRetryPolicy(),
+
+		multipartThreshold: MinMultipartSize,
+		partSize:           DefaultPartSize,
 	}
 
 	// Initialize AWS SDK client
-	if creds != nil && creds.IsValid() {
+	if provider != nil {
 		cfgOptions := []func(*config.LoadOptions) error{
 			config.WithRegion(region),
-			config.WithCredentialsProvider(awscreds.NewStaticCredentialsProvider(
-				creds.AccessKeyID,
-				creds.SecretAccessKey,
-				creds.SessionToken,
-			)),
+			config.WithCredentialsProvider(provider),
+			config.WithHTTPClient(newHTTPClient(DefaultTransportOptions())),
 		}
 
 		cfg, err := config.LoadDefaultConfig(context.Background(), cfgOptions...)
 		if err == nil {
-			s3Options := []func(*s3.Options){}
+			s3Options := []func(*s3.Options){
+				// Retries are handled by the client's RetryPolicy
+				func(o *s3.Options) {
+					o.Retryer = aws.NopRetryer{}
+				},
+			}
 			if endpoint != "" {
+				pathStyle := defaultPathStyle(endpoint)
 				s3Options = append(s3Options, func(o *s3.Options) {
 					o.BaseEndpoint = aws.String(endpoint)
-					o.UsePathStyle = true // Required for LocalStack
+					o.UsePathStyle = pathStyle
 				})
 			}
 			client.s3Client = s3.NewFromConfig(cfg, s3Options...)
@@ -65,29 +110,86 @@ func NewClientWithEndpoint(bucket, region, endpoint string, creds *credentials.C
 	return client
 }
 
+// defaultPathStyle reports whether requests to endpoint address the bucket in
+// the path rather than the host name unless SetPathStyle says otherwise
+// Local endpoints such as LocalStack or MinIO on localhost, and endpoints given
+// as an IP address, have no DNS name to put the bucket in
+func defaultPathStyle(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	return net.ParseIP(host) != nil
+}
+
+// SetPathStyle sets whether requests address the bucket in the path
+// (https://endpoint/bucket/key) instead of the host name
+// (https://bucket.endpoint/key). AWS and most providers take virtual-host
+// requests; some S3-compatible servers only understand path-style ones
+func (c *Client) SetPathStyle(pathStyle bool) {
+	if c.s3Client == nil {
+		return
+	}
+	c.s3Client = s3.New(c.s3Client.Options(), func(o *s3.Options) {
+		o.UsePathStyle = pathStyle
+	})
+}
+
+// SetRetryPolicy sets the retry policy for transient S3 errors
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
 // ListObjects lists objects with the given prefix
 func (c *Client) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list", "prefix", prefix)
 	if c.s3Client == nil {
 		return nil, fmt.Errorf("S3 client not initialized")
 	}
 
+	if c.listsVersions() {
+		return c.listObjectVersions(ctx, prefix)
+	}
+
+	// ListObjectsV2 only returns current versions and skips delete-markered keys
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(c.bucket),
 		Prefix: aws.String(prefix),
 	}
 
-	result, err := c.s3Client.ListObjectsV2(ctx, input)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list objects: %w", err)
-	}
+	// S3 returns at most 1000 keys per page; follow continuation tokens until done
+	var keys []string
+	for {
+		var result *s3.ListObjectsV2Output
+		err := c.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			result, err = c.s3Client.ListObjectsV2(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
 
-	keys := make([]string, 0, len(result.Contents))
-	for _, obj := range result.Contents {
-		if obj.Key != nil {
-			keys = append(keys, *obj.Key)
+		for _, obj := range result.Contents {
+			if obj.Key != nil {
+				keys = append(keys, *obj.Key)
+			}
+		}
+
+		if !aws.ToBool(result.IsTruncated) || aws.ToString(result.NextContinuationToken) == "" {
+			break
 		}
+		input.ContinuationToken = result.NextContinuationToken
 	}
 
+	if keys == nil {
+		keys = []string{}
+	}
 	return keys, nil
 }
 
@@ -100,6 +202,25 @@ func (c *Client) GetObject(ctx context.Context, key string) ([]byte, error) {
 // If start and end are both 0, retrieves the entire object
 // If end is 0, retrieves from start to end of object
 func (c *Client) GetObjectRange(ctx context.Context, key string, start, end int64) ([]byte, error) {
+	return c.getObjectRange(ctx, key, start, end, "")
+}
+
+// GetObjectRangeIfMatch retrieves a range of an object like GetObjectRange if
+// the stored object still has the given ETag, and fails with
+// ErrPreconditionFailed otherwise
+func (c *Client) GetObjectRangeIfMatch(ctx context.Context, key string, start, end int64, etag string) ([]byte, error) {
+	data, err := c.getObjectRange(ctx, key, start, end, etag)
+	if err != nil && etag != "" && (isPreconditionFailed(err) || isNotFound(err)) {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, ErrPreconditionFailed)
+	}
+	return data, err
+}
+
+// getObjectRange retrieves a range of an object, if it has the given ETag
+// when etag is not ""
+func (c *Client) getObjectRange(ctx context.Context, key string, start, end int64, etag string) ([]byte, error) {
+	defer metrics.StartOp(metrics.OpGet)()
+	logging.Debug("s3 get", "key", key, "start", start, "end", end)
 	if c.s3Client == nil {
 		return nil, fmt.Errorf("S3 client not initialized")
 	}
@@ -108,6 +229,13 @@ func (c *Client) GetObjectRange(ctx context.Context, key string, start, end int6
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 	}
+	versionID, err := c.pinnedVersion(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
 
 	// Add range header if specified
 	if start > 0 || end > 0 {
@@ -119,19 +247,181 @@ func (c *Client) GetObjectRange(ctx context.Context, key string, start, end int6
 		}
 		input.Range = aws.String(rangeHeader)
 	}
+	if etag != "" {
+		input.IfMatch = aws.String(quoteETag(etag))
+	}
+	c.sse.applyGet(input)
+	// Only whole objects can be checked against their stored checksum
+	verify := c.checksum != "" && start == 0 && end == 0
+	var optFns []func(*s3.Options)
+	if verify {
+		input.ChecksumMode = types.ChecksumModeEnabled
+		optFns = append(optFns, withoutResponseValidation)
+	}
 
-	result, err := c.s3Client.GetObject(ctx, input)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get object: %w", err)
+	// A corrupted download is retried once, in case the damage happened in transit
+	for attempt := 0; ; attempt++ {
+		data, output, err := c.getObject(ctx, key, input, optFns...)
+		if err != nil || !verify {
+			return data, err
+		}
+		mismatch := verifyChecksum(output, data)
+		if mismatch == nil {
+			return data, nil
+		}
+		if attempt > 0 {
+			logging.Error("object failed checksum verification", "key", key, "err", mismatch)
+			return nil, fmt.Errorf("object %s is corrupt: %v: %w", key, mismatch, syscall.EIO)
+		}
+		logging.Warn("downloaded object failed checksum verification, retrying", "key", key, "err", mismatch)
 	}
-	defer result.Body.Close()
+}
 
-	data, err := io.ReadAll(result.Body)
+// getObject runs a GetObject request and reads the body
+func (c *Client) getObject(ctx context.Context, key string, input *s3.GetObjectInput, optFns ...func(*s3.Options)) ([]byte, *s3.GetObjectOutput, error) {
+	// The body read is retried together with the request, since a connection
+	// reset usually surfaces while streaming the body
+	var data []byte
+	var output *s3.GetObjectOutput
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		result, err := c.s3Client.GetObject(ctx, input, optFns...)
+		if err != nil {
+			return fmt.Errorf("failed to get object: %w", err)
+		}
+		defer result.Body.Close()
+
+		data, err = c.readBody(ctx, key, input, result)
+		if err != nil {
+			return fmt.Errorf("failed to read object body: %w", err)
+		}
+		output = result
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object body: %w", err)
+		if archived := archivedError(key, err); archived != nil {
+			return nil, nil, archived
+		}
+		return nil, nil, err
 	}
 
-	return data, nil
+	return data, output, nil
+}
+
+// readBody reads the body of output, the response to input. A body that breaks
+// off, with an error or short of its Content-Length, is continued by requesting
+// the rest of the same object version, so a flaky connection doesn't cost what
+// was already downloaded. Past retry.MaxResumes, or if the rest can't be
+// fetched, the truncation is returned for the request to be retried whole
+func (c *Client) readBody(ctx context.Context, key string, input *s3.GetObjectInput, output *s3.GetObjectOutput) ([]byte, error) {
+	data, err := io.ReadAll(output.Body)
+	size := aws.ToInt64(output.ContentLength)
+	for resumes := 0; ; resumes++ {
+		if err == nil && (output.ContentLength == nil || int64(len(data)) >= size) {
+			return data, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("body ended after %d of %d bytes: %w", len(data), size, io.ErrUnexpectedEOF)
+		}
+		if resumes >= c.retry.MaxResumes || output.ETag == nil || ctx.Err() != nil {
+			return nil, err
+		}
+
+		resume, ok := resumeInput(input, int64(len(data)))
+		if !ok {
+			return nil, err
+		}
+		resume.IfMatch = aws.String(quoteETag(*output.ETag))
+		logging.Warn("object download cut short, resuming", "key", key, "received", len(data), "err", err)
+		rest, resumeErr := c.s3Client.GetObject(ctx, resume)
+		if resumeErr != nil {
+			logging.Debug("s3 get resume failed", "key", key, "err", resumeErr)
+			return nil, err
+		}
+		var more []byte
+		more, err = io.ReadAll(rest.Body)
+		rest.Body.Close()
+		data = append(data, more...)
+	}
+}
+
+// resumeInput returns a copy of input requesting what follows the first
+// received bytes of its response, or false if its range can't be continued
+func resumeInput(input *s3.GetObjectInput, received int64) (*s3.GetObjectInput, bool) {
+	var start int64
+	end := ""
+	if input.Range != nil {
+		first, last, ok := strings.Cut(strings.TrimPrefix(*input.Range, "bytes="), "-")
+		n, err := strconv.ParseInt(first, 10, 64)
+		if !ok || err != nil {
+			return nil, false
+		}
+		start, end = n, last
+	}
+	resume := *input
+	resume.Range = aws.String(fmt.Sprintf("bytes=%d-%s", start+received, end))
+	// Only a whole object has a checksum to return
+	resume.ChecksumMode = ""
+	return &resume, true
+}
+
+// ContentTypeKey is the metadata key for the MIME type of an upload. It is
+// sent as the object's Content-Type rather than stored as user metadata
+const ContentTypeKey = "content-type"
+
+// StorageClassKey is the metadata key for the storage class an object is in
+// ("STANDARD" included). A copy replacing the object's metadata keeps it in
+// that class without looking it up; uploads ignore it
+const StorageClassKey = "storage-class"
+
+// userMetadata splits upload metadata into the content type (nil if not given)
+// and the user metadata, with keys in metadataKey form
+func userMetadata(metadata map[string]string) (map[string]string, *string) {
+	var contentType *string
+	cleanMetadata := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if strings.EqualFold(k, ContentTypeKey) {
+			contentType = aws.String(v)
+			continue
+		}
+		if strings.EqualFold(k, StorageClassKey) {
+			continue
+		}
+		cleanMetadata[metadataKey(k)] = v
+	}
+	return cleanMetadata, contentType
+}
+
+// metadataStorageClass returns the storage class given under StorageClassKey,
+// with STANDARD as "" since S3 omits it; ok is false if none is given
+func metadataStorageClass(metadata map[string]string) (class types.StorageClass, ok bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, StorageClassKey) {
+			if types.StorageClass(v) == types.StorageClassStandard {
+				return "", true
+			}
+			return types.StorageClass(v), true
+		}
+	}
+	return "", false
+}
+
+// metadataKey returns a user metadata key in lower case and without the
+// "x-amz-meta-" prefix the SDK adds itself. Header names are case-insensitive,
+// and S3-compatible servers return stored keys in whatever case they keep them
+// (MinIO as "X-Amz-Meta-Mode"), so keys are compared in one form
+func metadataKey(key string) string {
+	return strings.TrimPrefix(strings.ToLower(key), "x-amz-meta-")
+}
+
+// quoteETag returns etag in the quoted form S3 sends in ETag headers
+// Some S3-compatible servers leave the quotes off in part of their responses,
+// which would make the same ETag compare unequal or fail as an If-Match value
+func quoteETag(etag string) string {
+	etag = strings.TrimSpace(etag)
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
 }
 
 // PutObject uploads an object to S3
@@ -141,31 +431,42 @@ func (c *Client) PutObject(ctx context.Context, key string, data []byte) error {
 
 // PutObjectWithMetadata uploads an object to S3 with metadata
 func (c *Client) PutObjectWithMetadata(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	return c.putObject(ctx, key, data, metadata, c.storageClass)
+}
+
+// putObject uploads an object in the given storage class, in parts if it
+// reaches the multipart threshold; optFns adjust the request that stores the
+// object (e.g. to add a condition)
+func (c *Client) putObject(ctx context.Context, key string, data []byte, metadata map[string]string, storageClass types.StorageClass, optFns ...func(*s3.Options)) error {
+	if int64(len(data)) >= c.multipartThreshold {
+		return c.putObjectMultipart(ctx, key, data, metadata, storageClass, optFns...)
+	}
+	defer metrics.StartOp(metrics.OpPut)()
+	logging.Debug("s3 put", "key", key, "size", len(data))
 	if c.s3Client == nil {
 		return fmt.Errorf("S3 client not initialized")
 	}
 
-	// AWS SDK expects metadata keys WITHOUT "x-amz-meta-" prefix
-	// It adds the prefix automatically
-	cleanMetadata := make(map[string]string)
-	const metaPrefix = "x-amz-meta-"
-	for k, v := range metadata {
-		// Remove "x-amz-meta-" prefix if present
-		key := k
-		if strings.HasPrefix(k, metaPrefix) {
-			key = k[len(metaPrefix):] // Remove prefix
-		}
-		cleanMetadata[key] = v
-	}
-
-	input := &s3.PutObjectInput{
-		Bucket:   aws.String(c.bucket),
-		Key:      aws.String(key),
-		Body:     bytes.NewReader(data),
-		Metadata: cleanMetadata,
-	}
+	cleanMetadata, contentType := userMetadata(metadata)
 
-	_, err := c.s3Client.PutObject(ctx, input)
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		// Fresh body reader per attempt
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(c.bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(data),
+			Metadata:    cleanMetadata,
+			ContentType: contentType,
+		}
+		input.StorageClass = storageClass
+		input.ChecksumAlgorithm = c.checksum
+		c.sse.applyPut(input)
+		output, err := c.s3Client.PutObject(ctx, input, optFns...)
+		if err == nil {
+			reportWritten(ctx, output.ETag)
+		}
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to put object: %w", err)
 	}
@@ -175,21 +476,29 @@ func (c *Client) PutObjectWithMetadata(ctx context.Context, key string, data []b
 
 // CopyObjectWithMetadata copies an object with updated metadata
 func (c *Client) CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	defer metrics.StartOp(metrics.OpCopy)()
+	logging.Debug("s3 copy", "source", sourceKey, "dest", destKey)
 	if c.s3Client == nil {
 		return fmt.Errorf("S3 client not initialized")
 	}
 
-	// AWS SDK expects metadata keys WITHOUT "x-amz-meta-" prefix
-	// It adds the prefix automatically
-	cleanMetadata := make(map[string]string)
-	const metaPrefix = "x-amz-meta-"
-	for k, v := range metadata {
-		// Remove "x-amz-meta-" prefix if present
-		key := k
-		if strings.HasPrefix(k, metaPrefix) {
-			key = k[len(metaPrefix):] // Remove prefix
+	cleanMetadata, contentType := userMetadata(metadata)
+
+	// A copy lands in STANDARD unless a class is given, and replacing the
+	// metadata replaces the Content-Type too, so keep the source's unless set
+	storageClass, classKnown := c.storageClass, c.storageClass != ""
+	if !classKnown {
+		storageClass, classKnown = metadataStorageClass(metadata)
+	}
+	if !classKnown || contentType == nil {
+		if info, err := c.HeadObjectFull(ctx, sourceKey); err == nil {
+			if !classKnown {
+				storageClass = info.StorageClass
+			}
+			if contentType == nil && info.ContentType != "" {
+				contentType = aws.String(info.ContentType)
+			}
 		}
-		cleanMetadata[key] = v
 	}
 
 	copySource := fmt.Sprintf("%s/%s", c.bucket, sourceKey)
@@ -199,9 +508,19 @@ func (c *Client) CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey
 		CopySource:        aws.String(copySource),
 		Metadata:          cleanMetadata,
 		MetadataDirective: types.MetadataDirectiveReplace,
+		StorageClass:      storageClass,
+		ContentType:       contentType,
 	}
+	c.sse.applyCopy(input)
 
-	_, err := c.s3Client.CopyObject(ctx, input)
+	// A single copy can move up to 5GB server-side, so it gets the longer timeout
+	err := c.retry.multipart().do(ctx, func(ctx context.Context) error {
+		_, err := c.s3Client.CopyObject(ctx, input)
+		return err
+	})
+	if isCopyTooLarge(err) {
+		return fmt.Errorf("failed to copy object with metadata: %w: %v", ErrCopyTooLarge, err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to copy object with metadata: %w", err)
 	}
@@ -211,6 +530,8 @@ func (c *Client) CopyObjectWithMetadata(ctx context.Context, sourceKey, destKey
 
 // DeleteObject deletes an object from S3
 func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	defer metrics.StartOp(metrics.OpDelete)()
+	logging.Debug("s3 delete", "key", key)
 	if c.s3Client == nil {
 		return fmt.Errorf("S3 client not initialized")
 	}
@@ -220,7 +541,10 @@ func (c *Client) DeleteObject(ctx context.Context, key string) error {
 		Key:    aws.String(key),
 	}
 
-	_, err := c.s3Client.DeleteObject(ctx, input)
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		_, err := c.s3Client.DeleteObject(ctx, input)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete object: %w", err)
 	}
@@ -228,8 +552,25 @@ func (c *Client) DeleteObject(ctx context.Context, key string) error {
 	return nil
 }
 
-// HeadObject retrieves object metadata
-func (c *Client) HeadObject(ctx context.Context, key string) (map[string]string, error) {
+// ObjectInfo holds the result of a single HEAD request
+type ObjectInfo struct {
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string // User metadata, keys without "x-amz-meta-" prefix
+	ETag         string            // Entity tag, changed by every write of the object
+	ContentType  string            // MIME type S3 serves the object with
+	// Encryption reported by S3 ("AES256", "aws:kms"; empty when unencrypted or SSE-C)
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+	SSECustomerAlgorithm string // Set for objects encrypted with a customer-provided key
+	// Storage class reported by S3 (empty for STANDARD, which S3 omits)
+	StorageClass types.StorageClass
+}
+
+// HeadObjectFull retrieves object size, Last-Modified and metadata in one round trip
+func (c *Client) HeadObjectFull(ctx context.Context, key string) (*ObjectInfo, error) {
+	defer metrics.StartOp(metrics.OpHead)()
+	logging.Debug("s3 head", "key", key)
 	if c.s3Client == nil {
 		return nil, fmt.Errorf("S3 client not initialized")
 	}
@@ -238,43 +579,65 @@ func (c *Client) HeadObject(ctx context.Context, key string) (map[string]string,
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 	}
-
-	result, err := c.s3Client.HeadObject(ctx, input)
+	c.sse.applyHead(input)
+	versionID, err := c.pinnedVersion(ctx, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to head object: %w", err)
 	}
-
-	metadata := make(map[string]string)
-	if result.Metadata != nil {
-		for k, v := range result.Metadata {
-			metadata[k] = v
-		}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
 	}
 
-	return metadata, nil
-}
-
-// HeadObjectSize retrieves object size from metadata without downloading
-func (c *Client) HeadObjectSize(ctx context.Context, key string) (int64, error) {
-	if c.s3Client == nil {
-		return 0, fmt.Errorf("S3 client not initialized")
+	var result *s3.HeadObjectOutput
+	err = c.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.s3Client.HeadObject(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to head object: %w", err)
 	}
 
-	input := &s3.HeadObjectInput{
-		Bucket: aws.String(c.bucket),
-		Key:    aws.String(key),
+	info := &ObjectInfo{
+		Metadata: make(map[string]string),
+	}
+	if result.ContentLength != nil {
+		info.Size = *result.ContentLength
 	}
+	if result.LastModified != nil {
+		info.LastModified = *result.LastModified
+	}
+	info.ETag = quoteETag(aws.ToString(result.ETag))
+	info.ContentType = aws.ToString(result.ContentType)
+	info.ServerSideEncryption = string(result.ServerSideEncryption)
+	info.SSEKMSKeyID = aws.ToString(result.SSEKMSKeyId)
+	info.SSECustomerAlgorithm = aws.ToString(result.SSECustomerAlgorithm)
+	info.StorageClass = result.StorageClass
+	for k, v := range result.Metadata {
+		info.Metadata[metadataKey(k)] = v
+	}
+
+	return info, nil
+}
 
-	result, err := c.s3Client.HeadObject(ctx, input)
+// HeadObject retrieves object metadata
+// Wrapper around HeadObjectFull kept for compatibility
+func (c *Client) HeadObject(ctx context.Context, key string) (map[string]string, error) {
+	info, err := c.HeadObjectFull(ctx, key)
 	if err != nil {
-		return 0, fmt.Errorf("failed to head object: %w", err)
+		return nil, err
 	}
+	return info.Metadata, nil
+}
 
-	if result.ContentLength != nil {
-		return *result.ContentLength, nil
+// HeadObjectSize retrieves object size from metadata without downloading
+// Wrapper around HeadObjectFull kept for compatibility
+func (c *Client) HeadObjectSize(ctx context.Context, key string) (int64, error) {
+	info, err := c.HeadObjectFull(ctx, key)
+	if err != nil {
+		return 0, err
 	}
-
-	return 0, nil
+	return info.Size, nil
 }
 
 // CreateBucket creates an S3 bucket
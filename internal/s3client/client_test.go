@@ -1,8 +1,22 @@
 package s3client
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 func TestNewClient(t *testing.T) {
@@ -64,3 +78,540 @@ func TestDeleteObject(t *testing.T) {
 	// Test will fail until implemented
 	_ = err
 }
+
+// rotatingProvider hands out a new, already-expiring access key on every retrieval
+type rotatingProvider struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *rotatingProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return aws.Credentials{
+		AccessKeyID:     fmt.Sprintf("ROTATED_KEY_%d", p.calls),
+		SecretAccessKey: "ROTATED_SECRET",
+		Source:          "test",
+		CanExpire:       true,
+		Expires:         time.Now(),
+	}, nil
+}
+
+func TestClientPicksUpRefreshedCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Authorization: AWS4-HMAC-SHA256 Credential=<key>/<scope>, ...
+		auth := r.Header.Get("Authorization")
+		if idx := strings.Index(auth, "Credential="); idx >= 0 {
+			credential := auth[idx+len("Credential="):]
+			mu.Lock()
+			keys = append(keys, credential[:strings.Index(credential, "/")])
+			mu.Unlock()
+		}
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, &rotatingProvider{})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetObject(ctx, "key"); err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 signed requests, got %d", len(keys))
+	}
+	if keys[0] == keys[1] {
+		t.Errorf("Expected requests to be signed with refreshed credentials, got %s twice", keys[0])
+	}
+}
+
+// recordingTransport answers every request with "data", recording its URL
+// without the query
+type recordingTransport struct {
+	urls []string
+}
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	u := *r.URL
+	u.RawQuery = ""
+	rt.urls = append(rt.urls, u.String())
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("data")),
+		Request:    r,
+	}, nil
+}
+
+func TestDefaultPathStyle(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected bool
+	}{
+		{"http://localhost:4566", true},
+		{"http://minio.localhost:9000", true},
+		{"http://127.0.0.1:9000", true},
+		{"http://[::1]:9000", true},
+		{"https://10.0.0.5", true},
+		{"https://minio.example.com", false},
+		{"https://your-account-id.r2.cloudflarestorage.com", false},
+	}
+	for _, tt := range tests {
+		if got := defaultPathStyle(tt.endpoint); got != tt.expected {
+			t.Errorf("defaultPathStyle(%q) = %v, want %v", tt.endpoint, got, tt.expected)
+		}
+	}
+}
+
+func TestSetPathStyle(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoint  string
+		pathStyle *bool
+		expected  string
+	}{
+		{"custom endpoint", "https://s3.example.com", nil, "https://test-bucket.s3.example.com/key"},
+		{"local endpoint", "http://localhost:4566", nil, "http://localhost:4566/test-bucket/key"},
+		{"path style forced", "https://s3.example.com", aws.Bool(true), "https://s3.example.com/test-bucket/key"},
+		{"virtual host forced", "http://localhost:4566", aws.Bool(false), "http://test-bucket.localhost:4566/key"},
+	}
+	for _, tt := range tests {
+		client := NewClientWithProvider("test-bucket", "us-east-1", tt.endpoint, &rotatingProvider{})
+		if tt.pathStyle != nil {
+			client.SetPathStyle(*tt.pathStyle)
+		}
+		transport := &recordingTransport{}
+		client.s3Client = s3.New(client.s3Client.Options(), func(o *s3.Options) {
+			o.HTTPClient = &http.Client{Transport: transport}
+		})
+
+		if _, err := client.GetObject(context.Background(), "key"); err != nil {
+			t.Fatalf("%s: GetObject failed: %v", tt.name, err)
+		}
+		if len(transport.urls) != 1 || transport.urls[0] != tt.expected {
+			t.Errorf("%s: expected a request to %s, got %v", tt.name, tt.expected, transport.urls)
+		}
+	}
+}
+
+func TestClientTimesOutHungRequests(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		hang := requests == 1
+		mu.Unlock()
+		if hang {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, &rotatingProvider{})
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.Timeout = 100 * time.Millisecond
+	client.SetRetryPolicy(policy)
+
+	data, err := client.GetObject(context.Background(), "key")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if string(data) != "data" || requests != 2 {
+		t.Errorf("Expected the hung request to be retried, got %q after %d requests", data, requests)
+	}
+
+	// Without retries the timeout is reported
+	requests = 0
+	policy.MaxRetries = 0
+	client.SetRetryPolicy(policy)
+	if _, err := client.GetObject(context.Background(), "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestListObjectsFollowsContinuationTokens(t *testing.T) {
+	const total, pageSize = 2500, 1000
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		start := 0
+		if token := r.URL.Query().Get("continuation-token"); token != "" {
+			fmt.Sscanf(token, "page-%d", &start)
+		}
+		end := start + pageSize
+		if end > total {
+			end = total
+		}
+
+		var body strings.Builder
+		body.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+		body.WriteString(`<Name>test-bucket</Name><Prefix>dir/</Prefix>`)
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&body, "<Contents><Key>dir/file-%04d</Key><Size>1</Size></Contents>", i)
+		}
+		if end < total {
+			fmt.Fprintf(&body, "<IsTruncated>true</IsTruncated><NextContinuationToken>page-%d</NextContinuationToken>", end)
+		} else {
+			body.WriteString("<IsTruncated>false</IsTruncated>")
+		}
+		body.WriteString("</ListBucketResult>")
+		w.Write([]byte(body.String()))
+	}))
+	defer server.Close()
+
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, provider)
+
+	keys, err := client.ListObjects(context.Background(), "dir/")
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(keys) != total {
+		t.Fatalf("Expected %d keys, got %d", total, len(keys))
+	}
+	if keys[0] != "dir/file-0000" || keys[total-1] != fmt.Sprintf("dir/file-%04d", total-1) {
+		t.Errorf("Unexpected first/last keys: %s, %s", keys[0], keys[total-1])
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 list requests, got %d", requests)
+	}
+}
+
+func TestListObjectsStopsOnEmptyContinuationToken(t *testing.T) {
+	// Some S3-compatible servers mark the last page truncated with no token
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+			`<Name>test-bucket</Name><Contents><Key>file</Key><Size>1</Size></Contents>` +
+			`<IsTruncated>true</IsTruncated><NextContinuationToken></NextContinuationToken></ListBucketResult>`))
+	}))
+	defer server.Close()
+
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, &rotatingProvider{})
+	keys, err := client.ListObjects(context.Background(), "")
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(keys) != 1 || requests != 1 {
+		t.Errorf("Expected 1 key from 1 request, got %v from %d", keys, requests)
+	}
+}
+
+func TestHeadObjectNormalizesProviderResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", "0123456789abcdef0123456789abcdef")
+		w.Header().Set("X-Amz-Meta-Mode", "0100600")
+		w.Header().Set("x-amz-meta-UID", "1000")
+	}))
+	defer server.Close()
+
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, &rotatingProvider{})
+	info, err := client.HeadObjectFull(context.Background(), "key")
+	if err != nil {
+		t.Fatalf("HeadObjectFull failed: %v", err)
+	}
+	if info.ETag != `"0123456789abcdef0123456789abcdef"` {
+		t.Errorf("Expected a quoted ETag, got %s", info.ETag)
+	}
+	if info.Metadata["mode"] != "0100600" || info.Metadata["uid"] != "1000" {
+		t.Errorf("Expected lower-case metadata keys, got %v", info.Metadata)
+	}
+}
+
+func TestQuoteETag(t *testing.T) {
+	tests := map[string]string{
+		"":        "",
+		"abc":     `"abc"`,
+		`"abc"`:   `"abc"`,
+		`"abc-3"`: `"abc-3"`,
+		" abc-3 ": `"abc-3"`,
+		`W/"abc"`: `W/"abc"`,
+	}
+	for etag, expected := range tests {
+		if got := quoteETag(etag); got != expected {
+			t.Errorf("quoteETag(%q) = %q, want %q", etag, got, expected)
+		}
+	}
+}
+
+func TestUserMetadataKeys(t *testing.T) {
+	metadata, contentType := userMetadata(map[string]string{
+		"x-amz-meta-mode": "0100644",
+		"X-Amz-Meta-Uid":  "1000",
+		"Gid":             "1000",
+		"Content-Type":    "text/plain",
+	})
+	expected := map[string]string{"mode": "0100644", "uid": "1000", "gid": "1000"}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("Expected %v, got %v", expected, metadata)
+	}
+	if aws.ToString(contentType) != "text/plain" {
+		t.Errorf("Expected content type text/plain, got %v", aws.ToString(contentType))
+	}
+}
+
+func TestListDelimitedUsesDelimiter(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if got := r.URL.Query().Get("delimiter"); got != "/" {
+			t.Errorf("Expected delimiter '/', got %q", got)
+		}
+
+		var body strings.Builder
+		body.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+		body.WriteString(`<Name>test-bucket</Name><Prefix>dir/</Prefix><Delimiter>/</Delimiter>`)
+		if r.URL.Query().Get("continuation-token") == "" {
+			body.WriteString("<Contents><Key>dir/a.txt</Key><Size>1</Size></Contents>")
+			body.WriteString("<CommonPrefixes><Prefix>dir/sub1/</Prefix></CommonPrefixes>")
+			body.WriteString("<IsTruncated>true</IsTruncated><NextContinuationToken>page-2</NextContinuationToken>")
+		} else {
+			body.WriteString("<Contents><Key>dir/b.txt</Key><Size>1</Size></Contents>")
+			body.WriteString("<CommonPrefixes><Prefix>dir/sub2/</Prefix></CommonPrefixes>")
+			body.WriteString("<IsTruncated>false</IsTruncated>")
+		}
+		body.WriteString("</ListBucketResult>")
+		w.Write([]byte(body.String()))
+	}))
+	defer server.Close()
+
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, provider)
+
+	keys, prefixes, err := client.ListDelimited(context.Background(), "dir/", "/")
+	if err != nil {
+		t.Fatalf("ListDelimited failed: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"dir/a.txt", "dir/b.txt"}) {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+	if !reflect.DeepEqual(prefixes, []string{"dir/sub1/", "dir/sub2/"}) {
+		t.Errorf("Unexpected prefixes: %v", prefixes)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 list requests, got %d", requests)
+	}
+}
+
+func TestListDelimitedPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("delimiter") != "/" || query.Get("max-keys") != "2" {
+			t.Errorf("Expected delimiter '/' and max-keys 2, got %q and %q", query.Get("delimiter"), query.Get("max-keys"))
+		}
+		var body strings.Builder
+		body.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+		if query.Get("continuation-token") == "" {
+			body.WriteString("<Contents><Key>dir/a.txt</Key><Size>1</Size></Contents>")
+			body.WriteString("<CommonPrefixes><Prefix>dir/sub1/</Prefix></CommonPrefixes>")
+			body.WriteString("<IsTruncated>true</IsTruncated><NextContinuationToken>page-2</NextContinuationToken>")
+		} else {
+			body.WriteString("<Contents><Key>dir/b.txt</Key><Size>1</Size></Contents>")
+			body.WriteString("<IsTruncated>false</IsTruncated>")
+		}
+		body.WriteString("</ListBucketResult>")
+		w.Write([]byte(body.String()))
+	}))
+	defer server.Close()
+
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, provider)
+
+	keys, prefixes, next, err := client.ListDelimitedPage(context.Background(), "dir/", "/", "", 2)
+	if err != nil || next != "page-2" || !reflect.DeepEqual(keys, []string{"dir/a.txt"}) || !reflect.DeepEqual(prefixes, []string{"dir/sub1/"}) {
+		t.Errorf("First page = %v, %v, %q, %v", keys, prefixes, next, err)
+	}
+	keys, prefixes, next, err = client.ListDelimitedPage(context.Background(), "dir/", "/", next, 2)
+	if err != nil || next != "" || !reflect.DeepEqual(keys, []string{"dir/b.txt"}) || len(prefixes) != 0 {
+		t.Errorf("Last page = %v, %v, %q, %v", keys, prefixes, next, err)
+	}
+}
+
+func TestSplitDelimited(t *testing.T) {
+	all := []string{"dir/b.txt", "dir/sub/x", "dir/sub/deep/y", "dir/a.txt", "dir/other/.keep", "elsewhere/z"}
+	keys, prefixes := SplitDelimited(all, "dir/", "/")
+	if !reflect.DeepEqual(keys, []string{"dir/a.txt", "dir/b.txt"}) {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+	if !reflect.DeepEqual(prefixes, []string{"dir/other/", "dir/sub/"}) {
+		t.Errorf("Unexpected prefixes: %v", prefixes)
+	}
+}
+
+func TestContentTypeIsNotUserMetadata(t *testing.T) {
+	var mu sync.Mutex
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Type", "text/html")
+		case r.Header.Get("x-amz-copy-source") != "":
+			w.Write([]byte(`<CopyObjectResult><ETag>"copied"</ETag></CopyObjectResult>`))
+		}
+	}))
+	defer server.Close()
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, provider)
+	ctx := context.Background()
+
+	metadata := map[string]string{ContentTypeKey: "text/html", "mode": "0644"}
+	if err := client.PutObjectWithMetadata(ctx, "index.html", []byte("<html></html>"), metadata); err != nil {
+		t.Fatalf("PutObjectWithMetadata failed: %v", err)
+	}
+	if got := headers[0].Get("Content-Type"); got != "text/html" {
+		t.Errorf("PUT Content-Type = %q, want text/html", got)
+	}
+	if got := headers[0].Get("X-Amz-Meta-Content-Type"); got != "" {
+		t.Errorf("Content type also sent as user metadata %q", got)
+	}
+
+	// Replacing the metadata keeps the stored type
+	if err := client.CopyObjectWithMetadata(ctx, "index.html", "index.html", map[string]string{"mode": "0600"}); err != nil {
+		t.Fatalf("CopyObjectWithMetadata failed: %v", err)
+	}
+	if copied := headers[len(headers)-1]; copied.Get("Content-Type") != "text/html" {
+		t.Errorf("Copy Content-Type = %q, want the source's text/html", copied.Get("Content-Type"))
+	}
+
+	// Given the stored type and class, the copy goes without asking for them
+	sent := len(headers)
+	metadata = map[string]string{ContentTypeKey: "text/css", StorageClassKey: "STANDARD_IA", "mode": "0600"}
+	if err := client.CopyObjectWithMetadata(ctx, "index.html", "index.html", metadata); err != nil {
+		t.Fatalf("CopyObjectWithMetadata failed: %v", err)
+	}
+	if n := len(headers) - sent; n != 1 {
+		t.Fatalf("Copy with known type and class made %d requests, want 1", n)
+	}
+	copied := headers[len(headers)-1]
+	if copied.Get("Content-Type") != "text/css" || copied.Get("X-Amz-Storage-Class") != "STANDARD_IA" {
+		t.Errorf("Copy sent Content-Type %q and storage class %q", copied.Get("Content-Type"), copied.Get("X-Amz-Storage-Class"))
+	}
+	if copied.Get("X-Amz-Meta-Storage-Class") != "" {
+		t.Error("Storage class also sent as user metadata")
+	}
+}
+
+// truncatingServer serves content, cutting the first response to each request
+// off halfway through its body
+type truncatingServer struct {
+	mu       sync.Mutex
+	content  []byte
+	ranges   []string
+	ifMatch  []string
+	truncate bool
+}
+
+func (s *truncatingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.ifMatch = append(s.ifMatch, r.Header.Get("If-Match"))
+	if match := r.Header.Get("If-Match"); match != "" && match != `"v1"` {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	start, end := 0, len(s.content)-1
+	if spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+		first, last, _ := strings.Cut(spec, "-")
+		start, _ = strconv.Atoi(first)
+		if last != "" {
+			end, _ = strconv.Atoi(last)
+		}
+	}
+	body := s.content[start : end+1]
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.content)))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if s.truncate {
+		s.truncate = false
+		body = body[:len(body)/2]
+	}
+	w.Write(body)
+}
+
+func TestGetObjectResumesTruncatedBody(t *testing.T) {
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	server := &truncatingServer{content: content}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", httpServer.URL, provider)
+	client.SetRetryPolicy(RetryPolicy{MaxResumes: 1})
+	ctx := context.Background()
+
+	server.truncate = true
+	data, err := client.GetObject(ctx, "big.bin")
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("GetObject = %d bytes, %v; want all %d", len(data), err, len(content))
+	}
+	// The rest of the same version was requested, not the whole object again
+	if want := []string{"", "bytes=32768-"}; !reflect.DeepEqual(server.ranges, want) {
+		t.Errorf("Requested ranges %q, want %q", server.ranges, want)
+	}
+	if server.ifMatch[1] != `"v1"` {
+		t.Errorf("Resume If-Match = %q, want the first response's ETag", server.ifMatch[1])
+	}
+
+	// A range keeps its end
+	server.ranges = nil
+	server.truncate = true
+	data, err = client.GetObjectRange(ctx, "big.bin", 1000, 2999)
+	if err != nil || !bytes.Equal(data, content[1000:3000]) {
+		t.Fatalf("GetObjectRange = %d bytes, %v; want 2000", len(data), err)
+	}
+	if want := []string{"bytes=1000-2999", "bytes=2000-2999"}; !reflect.DeepEqual(server.ranges, want) {
+		t.Errorf("Requested ranges %q, want %q", server.ranges, want)
+	}
+
+	// A pinned range is sent with the caller's ETag and fails once it changed
+	server.ranges = nil
+	server.ifMatch = nil
+	data, err = client.GetObjectRangeIfMatch(ctx, "big.bin", 0, 99, `"v1"`)
+	if err != nil || !bytes.Equal(data, content[:100]) {
+		t.Fatalf("GetObjectRangeIfMatch = %d bytes, %v; want 100", len(data), err)
+	}
+	if server.ifMatch[0] != `"v1"` {
+		t.Errorf("If-Match = %q, want the given ETag", server.ifMatch[0])
+	}
+	if _, err := client.GetObjectRangeIfMatch(ctx, "big.bin", 0, 99, `"v0"`); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("GetObjectRangeIfMatch of a replaced object = %v, want ErrPreconditionFailed", err)
+	}
+
+	// Without resumes or retries the truncation is an error
+	client.SetRetryPolicy(RetryPolicy{})
+	server.truncate = true
+	if _, err := client.GetObject(ctx, "big.bin"); err == nil {
+		t.Error("GetObject of a truncated body succeeded without resuming")
+	}
+}
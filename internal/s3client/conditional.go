@@ -0,0 +1,144 @@
+package s3client
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// ErrPreconditionFailed is returned by conditional writes when the object no
+// longer has the ETag the write was conditional on
+var ErrPreconditionFailed = errors.New("object was modified since it was read")
+
+// HeadObjectETag returns the ETag of the stored object, the version a later
+// PutObjectIfMatch can be made conditional on
+func (c *Client) HeadObjectETag(ctx context.Context, key string) (string, error) {
+	info, err := c.HeadObjectFull(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return info.ETag, nil
+}
+
+// PutObjectIfMatch uploads an object with metadata if the stored object still has
+// the given ETag, and fails with ErrPreconditionFailed otherwise. An empty etag
+// makes the write unconditional. Providers that don't implement conditional
+// writes get a plain PUT
+func (c *Client) PutObjectIfMatch(ctx context.Context, key string, data []byte, metadata map[string]string, etag string) error {
+	if etag == "" || c.noConditionalWrites.Load() {
+		return c.PutObjectWithMetadata(ctx, key, data, metadata)
+	}
+	started := time.Now()
+	err := c.putObject(ctx, key, data, metadata, c.storageClass, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-Match", quoteETag(etag)))
+	})
+	switch {
+	case err == nil:
+		return nil
+	case isNotImplemented(err):
+		logging.Warn("storage provider does not support conditional writes; overwrites by other clients will not be detected", "err", err)
+		c.noConditionalWrites.Store(true)
+		return c.PutObjectWithMetadata(ctx, key, data, metadata)
+	case isNotFound(err):
+		// If-Match on a key another client deleted
+		return fmt.Errorf("failed to put object %s: %w", key, ErrPreconditionFailed)
+	case !isPreconditionFailed(err):
+		return err
+	}
+
+	// A retried PUT fails the condition if an earlier attempt got through before
+	// its response was lost; then the object holds exactly this data
+	if info, headErr := c.HeadObjectFull(ctx, key); headErr == nil && holdsWrite(info, data, started) {
+		types.ReportWritten(ctx, info.ETag)
+		return nil
+	}
+	return fmt.Errorf("failed to put object %s: %w", key, ErrPreconditionFailed)
+}
+
+// holdsWrite reports whether the stored object described by info is the one a
+// write of data begun at started stored. The ETag of a single-part upload is
+// the MD5 of its content unless it is encrypted with SSE-KMS or SSE-C; other
+// ETags aren't, so then the object must have the size of data and have been
+// stored since the write began (Last-Modified has whole seconds)
+func holdsWrite(info *ObjectInfo, data []byte, started time.Time) bool {
+	md5ETag := !strings.Contains(info.ETag, "-") &&
+		!strings.HasPrefix(info.ServerSideEncryption, "aws:kms") &&
+		info.SSECustomerAlgorithm == ""
+	if md5ETag {
+		return info.ETag == fmt.Sprintf("\"%x\"", md5.Sum(data))
+	}
+	return info.Size == int64(len(data)) && !info.LastModified.Before(started.Truncate(time.Second))
+}
+
+// ErrObjectExists is returned by PutObjectIfNoneMatch when the key already
+// holds an object
+var ErrObjectExists = errors.New("object already exists")
+
+// PutObjectIfNoneMatch uploads an object with metadata only if nothing is
+// stored at the key, and fails with ErrObjectExists otherwise, so of two
+// clients creating the same key only one succeeds. Providers that don't
+// implement conditional writes get a plain PUT
+func (c *Client) PutObjectIfNoneMatch(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	if c.noConditionalWrites.Load() {
+		return c.PutObjectWithMetadata(ctx, key, data, metadata)
+	}
+	err := c.putObject(ctx, key, data, metadata, c.storageClass, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-None-Match", "*"))
+	})
+	switch {
+	case err == nil:
+		return nil
+	case isNotImplemented(err):
+		logging.Warn("storage provider does not support conditional writes; concurrent creates of a path will not be detected", "err", err)
+		c.noConditionalWrites.Store(true)
+		return c.PutObjectWithMetadata(ctx, key, data, metadata)
+	case isPreconditionFailed(err):
+		return fmt.Errorf("failed to put object %s: %w", key, ErrObjectExists)
+	}
+	return err
+}
+
+// isPreconditionFailed reports whether S3 refused a conditional request because
+// the condition did not hold, or because a concurrent write to the key won
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusPreconditionFailed
+}
+
+// isNotFound reports whether S3 found no object at the key
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey" {
+		return true
+	}
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
+
+// isNotImplemented reports whether the provider rejected a request header it
+// doesn't implement
+func isNotImplemented(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" {
+		return true
+	}
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotImplemented
+}
@@ -0,0 +1,194 @@
+package s3client
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+func TestConditionalErrors(t *testing.T) {
+	tests := []struct {
+		err                                    error
+		precondition, notFound, notImplemented bool
+	}{
+		{&smithy.GenericAPIError{Code: "PreconditionFailed"}, true, false, false},
+		{fmt.Errorf("put: %w", &smithy.GenericAPIError{Code: "ConditionalRequestConflict"}), true, false, false},
+		{&smithy.GenericAPIError{Code: "NoSuchKey"}, false, true, false},
+		{&smithy.GenericAPIError{Code: "NotImplemented"}, false, false, true},
+		{&smithy.GenericAPIError{Code: "AccessDenied"}, false, false, false},
+	}
+	for _, tt := range tests {
+		if got := isPreconditionFailed(tt.err); got != tt.precondition {
+			t.Errorf("isPreconditionFailed(%v) = %v", tt.err, got)
+		}
+		if got := isNotFound(tt.err); got != tt.notFound {
+			t.Errorf("isNotFound(%v) = %v", tt.err, got)
+		}
+		if got := isNotImplemented(tt.err); got != tt.notImplemented {
+			t.Errorf("isNotImplemented(%v) = %v", tt.err, got)
+		}
+	}
+}
+
+func TestHoldsWrite(t *testing.T) {
+	data := []byte("written")
+	started := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	md5ETag := fmt.Sprintf("\"%x\"", md5.Sum(data))
+	tests := []struct {
+		name string
+		info ObjectInfo
+		want bool
+	}{
+		{"plain upload of the data", ObjectInfo{ETag: md5ETag}, true},
+		{"plain upload of other data", ObjectInfo{ETag: `"0123"`, Size: 7, LastModified: started}, false},
+		{"multipart upload", ObjectInfo{ETag: `"abc-2"`, Size: 7, LastModified: started.Truncate(time.Second)}, true},
+		{"multipart upload of another size", ObjectInfo{ETag: `"abc-2"`, Size: 8, LastModified: started}, false},
+		{"multipart upload stored before the write", ObjectInfo{ETag: `"abc-2"`, Size: 7, LastModified: started.Add(-time.Second)}, false},
+		{"SSE-KMS upload", ObjectInfo{ETag: `"0123"`, Size: 7, LastModified: started, ServerSideEncryption: "aws:kms"}, true},
+		{"SSE-C upload", ObjectInfo{ETag: `"0123"`, Size: 7, LastModified: started, SSECustomerAlgorithm: "AES256"}, true},
+	}
+	for _, tt := range tests {
+		if got := holdsWrite(&tt.info, data, started); got != tt.want {
+			t.Errorf("%s: holdsWrite = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMockPutObjectIfMatch(t *testing.T) {
+	client := NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	client.PutObject(ctx, "file.txt", []byte("v1"))
+	info, _ := client.HeadObjectFull(ctx, "file.txt")
+
+	if err := client.PutObjectIfMatch(ctx, "file.txt", []byte("v2"), nil, info.ETag); err != nil {
+		t.Fatalf("PutObjectIfMatch with the current ETag failed: %v", err)
+	}
+	// The ETag read before is stale now
+	if err := client.PutObjectIfMatch(ctx, "file.txt", []byte("v3"), nil, info.ETag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("PutObjectIfMatch with a stale ETag = %v, want ErrPreconditionFailed", err)
+	}
+	if err := client.PutObjectIfMatch(ctx, "gone.txt", []byte("v1"), nil, info.ETag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("PutObjectIfMatch of a missing key = %v, want ErrPreconditionFailed", err)
+	}
+	if err := client.PutObjectIfMatch(ctx, "new.txt", []byte("v1"), nil, ""); err != nil {
+		t.Errorf("Unconditional PutObjectIfMatch failed: %v", err)
+	}
+	if data, _ := client.GetObject(ctx, "file.txt"); string(data) != "v2" {
+		t.Errorf("Stored data = %q, want %q", data, "v2")
+	}
+}
+
+func TestPutObjectIfMatchAfterInterveningWrite(t *testing.T) {
+	var mu sync.Mutex
+	current := `"v1"`
+	var conditions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("ETag", current)
+		case http.MethodPut:
+			conditions = append(conditions, r.Header.Get("If-Match"))
+			if condition := r.Header.Get("If-Match"); condition != "" && condition != current {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				w.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`))
+				return
+			}
+			current = `"v3"`
+			w.Header().Set("ETag", current)
+		}
+	}))
+	defer server.Close()
+
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, provider)
+	ctx := context.Background()
+
+	etag, err := client.HeadObjectETag(ctx, "file.txt")
+	if err != nil || etag != `"v1"` {
+		t.Fatalf("HeadObjectETag = %q, %v; want %q", etag, err, `"v1"`)
+	}
+	// Another client writes between our read and our write
+	mu.Lock()
+	current = `"v2"`
+	mu.Unlock()
+
+	err = client.PutObjectIfMatch(ctx, "file.txt", []byte("mine"), nil, etag)
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("PutObjectIfMatch after an intervening write = %v, want ErrPreconditionFailed", err)
+	}
+	if len(conditions) != 1 || conditions[0] != `"v1"` {
+		t.Errorf("PUT sent If-Match %q, want one PUT conditional on %q", conditions, `"v1"`)
+	}
+
+	// Conditional on the version now stored, the write goes through and
+	// reports the new ETag from the response
+	writeCtx, written := types.WithWritten(ctx)
+	if err := client.PutObjectIfMatch(writeCtx, "file.txt", []byte("mine"), nil, `"v2"`); err != nil {
+		t.Errorf("PutObjectIfMatch with the current ETag failed: %v", err)
+	}
+	if written.ETag != `"v3"` {
+		t.Errorf("Reported ETag %q, want %q", written.ETag, `"v3"`)
+	}
+}
+
+func TestPutObjectIfNoneMatch(t *testing.T) {
+	var mu sync.Mutex
+	stored := map[string]bool{}
+	var conditions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		conditions = append(conditions, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == "*" && stored[r.URL.Path] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`))
+			return
+		}
+		stored[r.URL.Path] = true
+		w.Header().Set("ETag", `"v1"`)
+	}))
+	defer server.Close()
+
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, provider)
+	client.SetRetryPolicy(RetryPolicy{})
+	ctx := context.Background()
+
+	if err := client.PutObjectIfNoneMatch(ctx, "dir/.keep", nil, nil); err != nil {
+		t.Fatalf("PutObjectIfNoneMatch of a new key failed: %v", err)
+	}
+	if err := client.PutObjectIfNoneMatch(ctx, "dir/.keep", nil, nil); !errors.Is(err, ErrObjectExists) {
+		t.Errorf("PutObjectIfNoneMatch of an existing key = %v, want ErrObjectExists", err)
+	}
+	if len(conditions) != 2 || conditions[0] != "*" || conditions[1] != "*" {
+		t.Errorf("PUTs sent If-None-Match %q, want * on both", conditions)
+	}
+
+	mock := NewMockClient("test-bucket", "us-east-1")
+	if err := mock.PutObjectIfNoneMatch(ctx, "dir/.keep", nil, nil); err != nil {
+		t.Fatalf("Mock PutObjectIfNoneMatch of a new key failed: %v", err)
+	}
+	if err := mock.PutObjectIfNoneMatch(ctx, "dir/.keep", nil, nil); !errors.Is(err, ErrObjectExists) {
+		t.Errorf("Mock PutObjectIfNoneMatch of an existing key = %v, want ErrObjectExists", err)
+	}
+}
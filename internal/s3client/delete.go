@@ -0,0 +1,95 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
+)
+
+// maxDeleteBatch is the most keys S3 accepts in a single DeleteObjects request
+const maxDeleteBatch = 1000
+
+// DeleteObjectsError reports the keys a batch delete could not remove
+// Keys not listed were deleted (or already absent), so callers retry only Failed
+type DeleteObjectsError struct {
+	Failed map[string]error // Key -> reason
+}
+
+func (e *DeleteObjectsError) Error() string {
+	keys := e.Keys()
+	if len(keys) == 1 {
+		return fmt.Sprintf("failed to delete %s: %v", keys[0], e.Failed[keys[0]])
+	}
+	return fmt.Sprintf("failed to delete %d objects (first %s: %v)", len(keys), keys[0], e.Failed[keys[0]])
+}
+
+// Keys returns the keys that could not be deleted, sorted
+func (e *DeleteObjectsError) Keys() []string {
+	keys := make([]string, 0, len(e.Failed))
+	for key := range e.Failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DeleteObjects deletes keys using the S3 batch delete API, up to 1000 keys per request
+// A batch that fails outright marks all of its keys failed and the remaining batches
+// still run; any failures are returned as a *DeleteObjectsError
+func (c *Client) DeleteObjects(ctx context.Context, keys []string) error {
+	defer metrics.StartOp(metrics.OpDelete)()
+	logging.Debug("s3 delete batch", "keys", len(keys))
+	if c.s3Client == nil {
+		return fmt.Errorf("S3 client not initialized")
+	}
+
+	failed := make(map[string]error)
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[start:end]
+
+		objects := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+		input := &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.bucket),
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true), // Only report failures
+			},
+		}
+
+		var result *s3.DeleteObjectsOutput
+		err := c.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			result, err = c.s3Client.DeleteObjects(ctx, input)
+			return err
+		})
+		if err != nil {
+			for _, key := range batch {
+				failed[key] = err
+			}
+			continue
+		}
+
+		for _, deleteErr := range result.Errors {
+			key := aws.ToString(deleteErr.Key)
+			failed[key] = fmt.Errorf("%s: %s", aws.ToString(deleteErr.Code), aws.ToString(deleteErr.Message))
+		}
+	}
+
+	if len(failed) > 0 {
+		return &DeleteObjectsError{Failed: failed}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package s3client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestDeleteObjectsBatches(t *testing.T) {
+	var batches []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["delete"]; !ok || r.Method != http.MethodPost {
+			t.Errorf("Expected POST ?delete, got %s %s", r.Method, r.URL)
+		}
+		var req struct {
+			Objects []struct {
+				Key string `xml:"Key"`
+			} `xml:"Object"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Failed to decode delete request: %v", err)
+		}
+		batches = append(batches, len(req.Objects))
+
+		var body strings.Builder
+		body.WriteString(`<DeleteResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+		for _, obj := range req.Objects {
+			if obj.Key == "dir/locked" {
+				body.WriteString("<Error><Key>dir/locked</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+			}
+		}
+		body.WriteString("</DeleteResult>")
+		w.Write([]byte(body.String()))
+	}))
+	defer server.Close()
+
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, provider)
+
+	keys := make([]string, 2500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("dir/file%d", i)
+	}
+	keys[1700] = "dir/locked"
+
+	err := client.DeleteObjects(context.Background(), keys)
+	if !reflect.DeepEqual(batches, []int{1000, 1000, 500}) {
+		t.Errorf("Expected batches of 1000, 1000, 500, got %v", batches)
+	}
+
+	var deleteErr *DeleteObjectsError
+	if !errors.As(err, &deleteErr) {
+		t.Fatalf("Expected *DeleteObjectsError, got %v", err)
+	}
+	if !reflect.DeepEqual(deleteErr.Keys(), []string{"dir/locked"}) {
+		t.Errorf("Expected only dir/locked to fail, got %v", deleteErr.Keys())
+	}
+	if !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Expected error to carry the S3 code, got %v", err)
+	}
+
+	if err := client.DeleteObjects(context.Background(), nil); err != nil {
+		t.Errorf("Deleting no keys should succeed, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// ListDelimited lists one level below prefix using the S3 Delimiter parameter
+// Returns the keys directly under prefix and the common prefixes (subdirectories)
+// without fetching anything deeper, so large subtrees cost one entry per child
+func (c *Client) ListDelimited(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list", "prefix", prefix, "delimiter", delimiter)
+	if c.s3Client == nil {
+		return nil, nil, fmt.Errorf("S3 client not initialized")
+	}
+
+	// Version filtering needs ListObjectVersions, which takes a delimiter too
+	// A subdirectory is listed if it has any version, so one whose objects were
+	// all deleted (or, under a snapshot, created later) shows up empty
+	if c.listsVersions() {
+		versions, prefixes, err := c.listVersions(ctx, prefix, delimiter)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.visibleKeys(versions), prefixes, nil
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(c.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String(delimiter),
+	}
+
+	keys := []string{}
+	prefixes := []string{}
+	for {
+		var result *s3.ListObjectsV2Output
+		err := c.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			result, err = c.s3Client.ListObjectsV2(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			if obj.Key != nil {
+				keys = append(keys, *obj.Key)
+			}
+		}
+		for _, common := range result.CommonPrefixes {
+			if common.Prefix != nil {
+				prefixes = append(prefixes, *common.Prefix)
+			}
+		}
+
+		if !aws.ToBool(result.IsTruncated) || aws.ToString(result.NextContinuationToken) == "" {
+			break
+		}
+		input.ContinuationToken = result.NextContinuationToken
+	}
+
+	return keys, prefixes, nil
+}
+
+// ListDelimitedPage returns one delimited ListObjectsV2 page of up to limit
+// entries one level below prefix, keys and common prefixes counted together
+// token is the continuation token from the previous page ("" to start); the
+// returned token is "" once the listing is complete
+func (c *Client) ListDelimitedPage(ctx context.Context, prefix, delimiter, token string, limit int) ([]string, []string, string, error) {
+	// ListObjectVersions pages differently; hand back the whole level at once
+	if c.listsVersions() {
+		keys, prefixes, err := c.ListDelimited(ctx, prefix, delimiter)
+		return keys, prefixes, "", err
+	}
+
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list", "prefix", prefix, "delimiter", delimiter, "max_keys", limit, "continued", token != "")
+	if c.s3Client == nil {
+		return nil, nil, "", fmt.Errorf("S3 client not initialized")
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(c.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String(delimiter),
+		MaxKeys:   aws.Int32(int32(limit)),
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	var result *s3.ListObjectsV2Output
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.s3Client.ListObjectsV2(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	keys := make([]string, 0, len(result.Contents))
+	for _, obj := range result.Contents {
+		if obj.Key != nil {
+			keys = append(keys, *obj.Key)
+		}
+	}
+	prefixes := make([]string, 0, len(result.CommonPrefixes))
+	for _, common := range result.CommonPrefixes {
+		if common.Prefix != nil {
+			prefixes = append(prefixes, *common.Prefix)
+		}
+	}
+	if !aws.ToBool(result.IsTruncated) {
+		return keys, prefixes, "", nil
+	}
+	return keys, prefixes, aws.ToString(result.NextContinuationToken), nil
+}
+
+// ListLimited returns at most maxKeys keys under prefix from a single request,
+// which is all it takes to tell whether a directory has any children
+func (c *Client) ListLimited(ctx context.Context, prefix string, maxKeys int) ([]string, error) {
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list", "prefix", prefix, "max_keys", maxKeys)
+	if c.s3Client == nil {
+		return nil, fmt.Errorf("S3 client not initialized")
+	}
+
+	if c.listsVersions() {
+		keys, err := c.listObjectVersions(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return keys[:min(len(keys), maxKeys)], nil
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(maxKeys)),
+	}
+	var result *s3.ListObjectsV2Output
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.s3Client.ListObjectsV2(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	keys := []string{}
+	for _, obj := range result.Contents {
+		if obj.Key != nil && len(keys) < maxKeys {
+			keys = append(keys, *obj.Key)
+		}
+	}
+	return keys, nil
+}
+
+// ListPage returns one ListObjectsV2 page of up to limit keys under prefix
+// token is the continuation token from the previous page ("" to start); the
+// returned token is "" once the listing is complete
+func (c *Client) ListPage(ctx context.Context, prefix, token string, limit int) ([]string, string, error) {
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list", "prefix", prefix, "max_keys", limit, "continued", token != "")
+	if c.s3Client == nil {
+		return nil, "", fmt.Errorf("S3 client not initialized")
+	}
+
+	// ListObjectVersions pages differently; hand back the whole listing at once
+	if c.listsVersions() {
+		keys, err := c.listObjectVersions(ctx, prefix)
+		return keys, "", err
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(limit)),
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	var result *s3.ListObjectsV2Output
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.s3Client.ListObjectsV2(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	keys := make([]string, 0, len(result.Contents))
+	for _, obj := range result.Contents {
+		if obj.Key != nil {
+			keys = append(keys, *obj.Key)
+		}
+	}
+	if !aws.ToBool(result.IsTruncated) {
+		return keys, "", nil
+	}
+	return keys, aws.ToString(result.NextContinuationToken), nil
+}
+
+// ListSizes returns the size of every object under prefix, by key, from the
+// same ListObjectsV2 pages ListObjects reads. Version-aware listings carry no
+// sizes for the versions shown, so those objects are sized with HEAD requests
+func (c *Client) ListSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	if c.listsVersions() {
+		keys, err := c.ListObjects(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		sizes := make(map[string]int64, len(keys))
+		for _, key := range keys {
+			size, err := c.HeadObjectSize(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			sizes[key] = size
+		}
+		return sizes, nil
+	}
+
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list", "prefix", prefix, "sizes", true)
+	if c.s3Client == nil {
+		return nil, fmt.Errorf("S3 client not initialized")
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	}
+	sizes := make(map[string]int64)
+	for {
+		var result *s3.ListObjectsV2Output
+		err := c.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			result, err = c.s3Client.ListObjectsV2(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			if obj.Key != nil {
+				sizes[*obj.Key] = aws.ToInt64(obj.Size)
+			}
+		}
+
+		if !aws.ToBool(result.IsTruncated) || aws.ToString(result.NextContinuationToken) == "" {
+			break
+		}
+		input.ContinuationToken = result.NextContinuationToken
+	}
+	return sizes, nil
+}
+
+// SplitDelimited groups a flat key listing the way a delimited list request would:
+// keys directly under prefix are returned as-is, deeper keys collapse into their
+// first-level common prefix (ending in delimiter)
+func SplitDelimited(allKeys []string, prefix, delimiter string) ([]string, []string) {
+	return types.SplitDelimited(allKeys, prefix, delimiter)
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/s3fs-fuse/s3fs-go/internal/credentials"
 )
 
@@ -29,7 +31,7 @@ func isLocalStackAvailable() bool {
 }
 
 // setupLocalStackTest sets up LocalStack test environment
-func setupLocalStackTest(t *testing.T) *Client {
+func setupLocalStackTest(t testing.TB) *Client {
 	if !isLocalStackAvailable() {
 		t.Skip("LocalStack is not available. Start it with: docker-compose -f docker-compose.localstack.yml up -d")
 	}
@@ -177,6 +179,96 @@ func TestLocalStackDeleteObject(t *testing.T) {
 	}
 }
 
+// TestLocalStackDeleteObjects tests batch deletion across several DeleteObjects requests
+func TestLocalStackDeleteObjects(t *testing.T) {
+	client := setupLocalStackTest(t)
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("test-batch-delete-%d/", time.Now().UnixNano())
+	keys := putLocalStackTree(t, client, prefix, 1500)
+
+	if err := client.DeleteObjects(ctx, keys); err != nil {
+		t.Fatalf("DeleteObjects failed: %v", err)
+	}
+
+	remaining, err := client.ListObjects(ctx, prefix)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected no objects after batch delete, got %d", len(remaining))
+	}
+}
+
+// BenchmarkLocalStackDeleteDirectory compares removing a 5000-object directory
+// with batch deletes against one DeleteObject request per key
+func BenchmarkLocalStackDeleteDirectory(b *testing.B) {
+	client := setupLocalStackTest(b)
+	ctx := context.Background()
+
+	remove := map[string]func(keys []string) error{
+		"Batch": func(keys []string) error {
+			return client.DeleteObjects(ctx, keys)
+		},
+		"Single": func(keys []string) error {
+			for _, key := range keys {
+				if err := client.DeleteObject(ctx, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	for _, name := range []string{"Batch", "Single"} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				prefix := fmt.Sprintf("bench-delete-%d/", time.Now().UnixNano())
+				keys := putLocalStackTree(b, client, prefix, 5000)
+				b.StartTimer()
+
+				if err := remove[name](keys); err != nil {
+					b.Fatalf("Delete failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// putLocalStackTree uploads count small objects under prefix in parallel
+func putLocalStackTree(t testing.TB, client *Client, prefix string, count int) []string {
+	ctx := context.Background()
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%ssub%d/file%d.txt", prefix, i%10, i)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	work := make(chan string)
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				if err := client.PutObject(ctx, key, []byte("x")); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	return keys
+}
+
 // TestLocalStackGetObjectRange tests getting object ranges with LocalStack
 func TestLocalStackGetObjectRange(t *testing.T) {
 	client := setupLocalStackTest(t)
@@ -254,6 +346,70 @@ func TestLocalStackHeadObject(t *testing.T) {
 	client.DeleteObject(ctx, testKey)
 }
 
+// TestLocalStackSSES3 tests that SSE-S3 uploads and copies are reported as encrypted
+func TestLocalStackSSES3(t *testing.T) {
+	client := setupLocalStackTest(t)
+	ctx := context.Background()
+
+	if err := client.SetSSEOptions(SSEOptions{Mode: SSES3}); err != nil {
+		t.Fatalf("SetSSEOptions failed: %v", err)
+	}
+
+	testKey := fmt.Sprintf("test-sse-%d", time.Now().UnixNano())
+	copyKey := testKey + "-copy"
+	if err := client.PutObject(ctx, testKey, []byte("Encrypted at rest")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	defer client.DeleteObject(ctx, testKey)
+
+	if err := client.CopyObjectWithMetadata(ctx, testKey, copyKey, map[string]string{"mode": "0644"}); err != nil {
+		t.Fatalf("CopyObjectWithMetadata failed: %v", err)
+	}
+	defer client.DeleteObject(ctx, copyKey)
+
+	for _, key := range []string{testKey, copyKey} {
+		info, err := client.HeadObjectFull(ctx, key)
+		if err != nil {
+			t.Fatalf("HeadObjectFull failed: %v", err)
+		}
+		if info.ServerSideEncryption != "AES256" {
+			t.Errorf("%s: expected ServerSideEncryption AES256, got %q", key, info.ServerSideEncryption)
+		}
+	}
+}
+
+// TestLocalStackStorageClass tests that new objects and their copies get the configured class
+func TestLocalStackStorageClass(t *testing.T) {
+	client := setupLocalStackTest(t)
+	ctx := context.Background()
+
+	client.SetStorageClass(types.StorageClassStandardIa)
+
+	testKey := fmt.Sprintf("test-storage-class-%d", time.Now().UnixNano())
+	copyKey := testKey + "-copy"
+	if err := client.PutObject(ctx, testKey, []byte("Infrequently accessed")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	defer client.DeleteObject(ctx, testKey)
+
+	// Copy with no class configured must keep the source's class
+	client.SetStorageClass("")
+	if err := client.CopyObjectWithMetadata(ctx, testKey, copyKey, map[string]string{"mode": "0644"}); err != nil {
+		t.Fatalf("CopyObjectWithMetadata failed: %v", err)
+	}
+	defer client.DeleteObject(ctx, copyKey)
+
+	for _, key := range []string{testKey, copyKey} {
+		info, err := client.HeadObjectFull(ctx, key)
+		if err != nil {
+			t.Fatalf("HeadObjectFull failed: %v", err)
+		}
+		if info.StorageClass != types.StorageClassStandardIa {
+			t.Errorf("%s: expected storage class STANDARD_IA, got %q", key, info.StorageClass)
+		}
+	}
+}
+
 // TestLocalStackHeadObjectSize tests getting object size with LocalStack
 func TestLocalStackHeadObjectSize(t *testing.T) {
 	client := setupLocalStackTest(t)
@@ -2,9 +2,14 @@ package s3client
 
 import (
 	"context"
+	"crypto/md5"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 // MockClient is an in-memory mock implementation of the S3 client for unit tests
@@ -13,6 +18,12 @@ type MockClient struct {
 	region   string
 	objects  map[string]*MockObject
 	mu       sync.RWMutex
+	heads    int64 // Number of HEAD requests served
+	gets     int64 // Number of GET requests served
+	lists    int64 // Number of unbounded list requests served
+	limited  int64 // Number of list requests served with a key limit
+	pages    int64 // Number of ListPage and ListDelimitedPage requests served
+	uploaded int64 // Bytes of object data uploaded
 }
 
 // MockObject represents a mock S3 object
@@ -22,6 +33,25 @@ type MockObject struct {
 	Metadata   map[string]string
 	Size       int64
 	LastModified time.Time
+	ContentType  string // Set from ContentTypeKey on upload ("" = S3 default)
+}
+
+// mockMetadata copies upload metadata, taking out the content type and storage
+// class like userMetadata does
+func mockMetadata(metadata map[string]string) (map[string]string, string) {
+	objMetadata := make(map[string]string, len(metadata))
+	contentType := ""
+	for k, v := range metadata {
+		if k == ContentTypeKey {
+			contentType = v
+			continue
+		}
+		if k == StorageClassKey {
+			continue
+		}
+		objMetadata[k] = v
+	}
+	return objMetadata, contentType
 }
 
 // NewMockClient creates a new mock S3 client
@@ -35,6 +65,53 @@ func NewMockClient(bucket, region string) *MockClient {
 
 // ListObjects lists objects with the given prefix
 func (m *MockClient) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	atomic.AddInt64(&m.lists, 1)
+	return m.listObjects(prefix), nil
+}
+
+// ListLimited returns at most maxKeys keys with the given prefix, in key order
+func (m *MockClient) ListLimited(ctx context.Context, prefix string, maxKeys int) ([]string, error) {
+	atomic.AddInt64(&m.limited, 1)
+	keys := m.listObjects(prefix)
+	sort.Strings(keys)
+	return keys[:min(len(keys), maxKeys)], nil
+}
+
+// ListPage returns up to limit keys with the given prefix, in key order, after
+// the key named by token; the next token is the last key returned
+func (m *MockClient) ListPage(ctx context.Context, prefix, token string, limit int) ([]string, string, error) {
+	atomic.AddInt64(&m.pages, 1)
+	all := m.listObjects(prefix)
+	sort.Strings(all)
+	start := sort.SearchStrings(all, token)
+	if start < len(all) && all[start] == token {
+		start++
+	}
+	end := min(start+limit, len(all))
+	keys := append([]string(nil), all[start:end]...)
+	if end == len(all) || len(keys) == 0 {
+		return keys, "", nil
+	}
+	return keys, keys[len(keys)-1], nil
+}
+
+// ListSizes returns the size of every object with the given prefix, by key
+func (m *MockClient) ListSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	atomic.AddInt64(&m.lists, 1)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sizes := make(map[string]int64)
+	for key, obj := range m.objects {
+		if prefix == "" || (len(key) >= len(prefix) && key[:len(prefix)] == prefix) {
+			sizes[key] = int64(len(obj.Data))
+		}
+	}
+	return sizes, nil
+}
+
+// listObjects returns the keys with the given prefix, in no particular order
+func (m *MockClient) listObjects(prefix string) []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
@@ -44,11 +121,54 @@ func (m *MockClient) ListObjects(ctx context.Context, prefix string) ([]string,
 			keys = append(keys, key)
 		}
 	}
-	return keys, nil
+	return keys
+}
+
+// ListDelimited lists one level below prefix, like a delimited S3 list request
+func (m *MockClient) ListDelimited(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	keys, err := m.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, prefixes := SplitDelimited(keys, prefix, delimiter)
+	return files, prefixes, nil
+}
+
+// ListDelimitedPage returns up to limit of the keys and common prefixes one
+// level below prefix, in key order, after the entry named by token; the next
+// token is the last entry returned
+func (m *MockClient) ListDelimitedPage(ctx context.Context, prefix, delimiter, token string, limit int) ([]string, []string, string, error) {
+	atomic.AddInt64(&m.pages, 1)
+	files, prefixes := SplitDelimited(m.listObjects(prefix), prefix, delimiter)
+	common := make(map[string]bool, len(prefixes))
+	for _, p := range prefixes {
+		common[p] = true
+	}
+	all := append(files, prefixes...)
+	sort.Strings(all)
+	start := sort.SearchStrings(all, token)
+	if start < len(all) && all[start] == token {
+		start++
+	}
+	end := min(start+limit, len(all))
+	keys, dirs := []string{}, []string{}
+	for _, entry := range all[start:end] {
+		if common[entry] {
+			dirs = append(dirs, entry)
+		} else {
+			keys = append(keys, entry)
+		}
+	}
+	if end == len(all) || start == end {
+		return keys, dirs, "", nil
+	}
+	return keys, dirs, all[end-1], nil
 }
 
 // GetObject retrieves an object
 func (m *MockClient) GetObject(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddInt64(&m.gets, 1)
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
@@ -72,18 +192,58 @@ func (m *MockClient) PutObject(ctx context.Context, key string, data []byte) err
 func (m *MockClient) PutObjectWithMetadata(ctx context.Context, key string, data []byte, metadata map[string]string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	
+	m.store(ctx, key, data, metadata)
+	return nil
+}
+
+// PutObjectIfMatch uploads an object with metadata if the stored object has the
+// given ETag; an empty etag makes the write unconditional
+func (m *MockClient) PutObjectIfMatch(ctx context.Context, key string, data []byte, metadata map[string]string, etag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if obj, exists := m.objects[key]; etag != "" && (!exists || fmt.Sprintf("\"%x\"", md5.Sum(obj.Data)) != etag) {
+		return fmt.Errorf("failed to put object %s: %w", key, ErrPreconditionFailed)
+	}
+	m.store(ctx, key, data, metadata)
+	return nil
+}
+
+// GetObjectRangeIfMatch retrieves a range of an object if it still has the
+// given ETag
+func (m *MockClient) GetObjectRangeIfMatch(ctx context.Context, key string, start, end int64, etag string) ([]byte, error) {
+	m.mu.RLock()
+	obj, exists := m.objects[key]
+	matches := exists && fmt.Sprintf("\"%x\"", md5.Sum(obj.Data)) == etag
+	m.mu.RUnlock()
+	if etag != "" && !matches {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, ErrPreconditionFailed)
+	}
+	return m.GetObjectRange(ctx, key, start, end)
+}
+
+// PutObjectIfNoneMatch uploads an object unless key already holds one
+func (m *MockClient) PutObjectIfNoneMatch(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.objects[key]; exists {
+		return fmt.Errorf("failed to put object %s: %w", key, ErrObjectExists)
+	}
+	m.store(ctx, key, data, metadata)
+	return nil
+}
+
+// store saves an upload of key, reporting its ETag like S3 does; the caller
+// must hold m.mu
+func (m *MockClient) store(ctx context.Context, key string, data []byte, metadata map[string]string) {
+	atomic.AddInt64(&m.uploaded, int64(len(data)))
+	types.ReportWritten(ctx, fmt.Sprintf("\"%x\"", md5.Sum(data)))
+
 	// Copy data
 	objData := make([]byte, len(data))
 	copy(objData, data)
 	
 	// Copy metadata
-	objMetadata := make(map[string]string)
-	if metadata != nil {
-		for k, v := range metadata {
-			objMetadata[k] = v
-		}
-	}
+	objMetadata, contentType := mockMetadata(metadata)
 	
 	m.objects[key] = &MockObject{
 		Key:          key,
@@ -91,8 +251,8 @@ func (m *MockClient) PutObjectWithMetadata(ctx context.Context, key string, data
 		Metadata:     objMetadata,
 		Size:         int64(len(data)),
 		LastModified: time.Now(),
+		ContentType:  contentType,
 	}
-	return nil
 }
 
 // DeleteObject deletes an object
@@ -104,22 +264,86 @@ func (m *MockClient) DeleteObject(ctx context.Context, key string) error {
 	return nil
 }
 
-// HeadObject retrieves object metadata
-func (m *MockClient) HeadObject(ctx context.Context, key string) (map[string]string, error) {
+// DeleteObjects deletes keys in one call, like the S3 batch delete API
+func (m *MockClient) DeleteObjects(ctx context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.objects, key)
+	}
+	return nil
+}
+
+// HeadObjectFull retrieves object size, last-modified time and metadata
+func (m *MockClient) HeadObjectFull(ctx context.Context, key string) (*ObjectInfo, error) {
+	atomic.AddInt64(&m.heads, 1)
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	
+
 	obj, exists := m.objects[key]
 	if !exists {
 		return nil, fmt.Errorf("object not found: %s", key)
 	}
-	
+
 	// Return a copy of metadata
 	metadata := make(map[string]string)
 	for k, v := range obj.Metadata {
 		metadata[k] = v
 	}
-	return metadata, nil
+	contentType := obj.ContentType
+	if contentType == "" {
+		// What S3 reports for objects uploaded without a Content-Type
+		contentType = "binary/octet-stream"
+	}
+	return &ObjectInfo{
+		Size:         obj.Size,
+		LastModified: obj.LastModified,
+		Metadata:     metadata,
+		ETag:         fmt.Sprintf("\"%x\"", md5.Sum(obj.Data)),
+		ContentType:  contentType,
+	}, nil
+}
+
+// HeadObject retrieves object metadata
+func (m *MockClient) HeadObject(ctx context.Context, key string) (map[string]string, error) {
+	info, err := m.HeadObjectFull(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata, nil
+}
+
+// HeadCount returns the number of HEAD requests served so far
+func (m *MockClient) HeadCount() int64 {
+	return atomic.LoadInt64(&m.heads)
+}
+
+// ListCount returns the number of unbounded list requests served so far
+func (m *MockClient) ListCount() int64 {
+	return atomic.LoadInt64(&m.lists)
+}
+
+// LimitedListCount returns the number of key-limited list requests served so far
+func (m *MockClient) LimitedListCount() int64 {
+	return atomic.LoadInt64(&m.limited)
+}
+
+// PageCount returns the number of paged list requests served so far
+func (m *MockClient) PageCount() int64 {
+	return atomic.LoadInt64(&m.pages)
+}
+
+// GetCount returns the number of GET requests served so far
+func (m *MockClient) GetCount() int64 {
+	return atomic.LoadInt64(&m.gets)
+}
+
+// UploadedBytes returns the bytes of object data uploaded so far; server-side
+// copies don't count
+func (m *MockClient) UploadedBytes() int64 {
+	return atomic.LoadInt64(&m.uploaded)
 }
 
 // CopyObject copies an object (not used by filesystem, but for completeness)
@@ -129,14 +353,20 @@ func (m *MockClient) CopyObject(ctx context.Context, sourceKey, destKey string)
 
 // HeadObjectSize retrieves object size from metadata
 func (m *MockClient) HeadObjectSize(ctx context.Context, key string) (int64, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	
-	obj, exists := m.objects[key]
-	if !exists {
-		return 0, fmt.Errorf("object not found: %s", key)
+	info, err := m.HeadObjectFull(ctx, key)
+	if err != nil {
+		return 0, err
 	}
-	return obj.Size, nil
+	return info.Size, nil
+}
+
+// HeadObjectETag retrieves the object's ETag
+func (m *MockClient) HeadObjectETag(ctx context.Context, key string) (string, error) {
+	info, err := m.HeadObjectFull(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return info.ETag, nil
 }
 
 // CopyObjectWithMetadata copies an object with metadata
@@ -154,11 +384,13 @@ func (m *MockClient) CopyObjectWithMetadata(ctx context.Context, sourceKey, dest
 	copy(destData, sourceObj.Data)
 	
 	// Replace metadata (not merge) - matching S3 behavior with MetadataDirectiveReplace
+	// The content type is kept unless given, as Client.CopyObjectWithMetadata does
 	destMetadata := make(map[string]string)
+	contentType := sourceObj.ContentType
 	if metadata != nil {
-		// Use provided metadata as-is (replaces all metadata)
-		for k, v := range metadata {
-			destMetadata[k] = v
+		var newType string
+		if destMetadata, newType = mockMetadata(metadata); newType != "" {
+			contentType = newType
 		}
 	} else {
 		// If no metadata provided, copy existing metadata
@@ -173,12 +405,15 @@ func (m *MockClient) CopyObjectWithMetadata(ctx context.Context, sourceKey, dest
 		Metadata:     destMetadata,
 		Size:         sourceObj.Size,
 		LastModified: time.Now(),
+		ContentType:  contentType,
 	}
 	return nil
 }
 
 // GetObjectRange retrieves a range of bytes from an object
 func (m *MockClient) GetObjectRange(ctx context.Context, key string, start, end int64) ([]byte, error) {
+	atomic.AddInt64(&m.gets, 1)
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
@@ -221,3 +456,54 @@ func (m *MockClient) PutObjectMultipart(ctx context.Context, key string, data []
 func (m *MockClient) CopyObjectMultipart(ctx context.Context, sourceKey, destKey string) error {
 	return m.CopyObjectWithMetadata(ctx, sourceKey, destKey, nil)
 }
+
+// CopyObjectMultipartWithMetadata copies a large object with new metadata
+// (simplified for mock)
+func (m *MockClient) CopyObjectMultipartWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
+	return m.CopyObjectWithMetadata(ctx, sourceKey, destKey, metadata)
+}
+
+// PatchObject replaces the bytes of key from offset with data, counting only the
+// parts a real multipart patch would upload
+func (m *MockClient) PatchObject(ctx context.Context, key string, offset int64, data []byte, metadata map[string]string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, exists := m.objects[key]
+	if !exists {
+		return false, fmt.Errorf("object not found: %s", key)
+	}
+	oldSize := int64(len(obj.Data))
+	newSize := max(oldSize, offset+int64(len(data)))
+	if newSize < MinMultipartSize {
+		return false, nil
+	}
+	var uploaded int64
+	copied := false
+	for _, part := range planPatch(oldSize, offset, int64(len(data)), scalePartSize(DefaultPartSize, newSize)) {
+		if part.copied {
+			copied = true
+		} else {
+			uploaded += part.end - part.start
+		}
+	}
+	if !copied {
+		return false, nil
+	}
+	atomic.AddInt64(&m.uploaded, uploaded)
+
+	objData := make([]byte, newSize)
+	copy(objData, obj.Data)
+	copy(objData[offset:], data)
+	objMetadata, contentType := mockMetadata(metadata)
+	m.objects[key] = &MockObject{
+		Key:          key,
+		Data:         objData,
+		Metadata:     objMetadata,
+		Size:         newSize,
+		LastModified: time.Now(),
+		ContentType:  contentType,
+	}
+	types.ReportWritten(ctx, fmt.Sprintf("\"%x\"", md5.Sum(objData)))
+	return true, nil
+}
@@ -3,11 +3,19 @@ package s3client
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
 )
 
 const (
@@ -15,10 +23,59 @@ const (
 	MinMultipartSize = 5 * 1024 * 1024
 	// DefaultPartSize is the default part size for multipart upload (5MB)
 	DefaultPartSize = 5 * 1024 * 1024
+	// MaxCopyObjectSize is the largest object a single CopyObject request can copy (5GB)
+	MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	// maxMultipartParts is the most parts S3 accepts in one multipart upload
+	maxMultipartParts = 10000
+	// maxPartSize is the largest part S3 accepts (5GB)
+	maxPartSize = 5 * 1024 * 1024 * 1024
+	// maxObjectSize is the largest object S3 stores (5TB)
+	maxObjectSize = 5 * 1024 * 1024 * 1024 * 1024
 )
 
+// ErrCopyTooLarge is returned by CopyObjectWithMetadata when the source is
+// larger than a single CopyObject can copy; CopyObjectMultipartWithMetadata
+// copies it in parts
+var ErrCopyTooLarge = errors.New("copy source is larger than a single copy allows")
+
+// isCopyTooLarge reports whether S3 refused a CopyObject because the source is
+// over MaxCopyObjectSize
+func isCopyTooLarge(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRequest" &&
+		strings.Contains(apiErr.ErrorMessage(), "copy source is larger than the maximum allowable size")
+}
+
+// SetMultipartThreshold makes uploads and copies of objects of at least size
+// bytes use multipart requests (default MinMultipartSize). It must lie between
+// MinMultipartSize and MaxCopyObjectSize, the most a single request can store
+func (c *Client) SetMultipartThreshold(size int64) error {
+	if size < MinMultipartSize || size > MaxCopyObjectSize {
+		return fmt.Errorf("multipart threshold must be between %d and %d bytes, got %d", int64(MinMultipartSize), int64(MaxCopyObjectSize), size)
+	}
+	c.multipartThreshold = size
+	return nil
+}
+
+// SetPartSize sets the size of the parts of multipart uploads and copies
+// (default DefaultPartSize), between the 5MB and 5GB S3 accepts. Objects that
+// would need more than 10,000 parts of this size get larger parts
+func (c *Client) SetPartSize(size int64) error {
+	if size < MinMultipartSize || size > maxPartSize {
+		return fmt.Errorf("part size must be between %d and %d bytes, got %d", int64(MinMultipartSize), int64(maxPartSize), size)
+	}
+	c.partSize = size
+	return nil
+}
+
 // CreateMultipartUpload initiates a multipart upload
 func (c *Client) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	return c.createMultipartUpload(ctx, key, c.storageClass, nil)
+}
+
+// createMultipartUpload initiates a multipart upload in the given storage class
+// metadata becomes the user metadata of the completed object
+func (c *Client) createMultipartUpload(ctx context.Context, key string, storageClass types.StorageClass, metadata map[string]string) (string, error) {
 	if c.s3Client == nil {
 		return "", fmt.Errorf("S3 client not initialized")
 	}
@@ -27,8 +84,19 @@ func (c *Client) CreateMultipartUpload(ctx context.Context, key string) (string,
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 	}
-
-	result, err := c.s3Client.CreateMultipartUpload(ctx, input)
+	input.StorageClass = storageClass
+	if len(metadata) > 0 {
+		input.Metadata, input.ContentType = userMetadata(metadata)
+	}
+	c.sse.applyCreateMultipart(input)
+
+	// Not idempotent: an ambiguous failure may have created an upload already
+	var result *s3.CreateMultipartUploadOutput
+	err := c.retry.doIfRejected(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.s3Client.CreateMultipartUpload(ctx, input)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create multipart upload: %w", err)
 	}
@@ -37,6 +105,7 @@ func (c *Client) CreateMultipartUpload(ctx context.Context, key string) (string,
 		return "", fmt.Errorf("upload ID is nil")
 	}
 
+	c.active.add(MultipartUpload{Key: key, UploadID: *result.UploadId, Initiated: time.Now()})
 	return *result.UploadId, nil
 }
 
@@ -46,15 +115,24 @@ func (c *Client) UploadPart(ctx context.Context, key, uploadID string, partNumbe
 		return "", fmt.Errorf("S3 client not initialized")
 	}
 
-	input := &s3.UploadPartInput{
-		Bucket:     aws.String(c.bucket),
-		Key:        aws.String(key),
-		PartNumber: aws.Int32(partNumber),
-		UploadId:   aws.String(uploadID),
-		Body:       bytes.NewReader(data),
-	}
-
-	result, err := c.s3Client.UploadPart(ctx, input)
+	var result *s3.UploadPartOutput
+	err := c.retry.multipart().do(ctx, func(ctx context.Context) error {
+		// Fresh body reader per attempt
+		input := &s3.UploadPartInput{
+			Bucket:     aws.String(c.bucket),
+			Key:        aws.String(key),
+			PartNumber: aws.Int32(partNumber),
+			UploadId:   aws.String(uploadID),
+			Body:       bytes.NewReader(data),
+		}
+		if c.checksum != "" {
+			input.ContentMD5 = contentMD5(data)
+		}
+		c.sse.applyUploadPart(input)
+		var err error
+		result, err = c.s3Client.UploadPart(ctx, input)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
 	}
@@ -63,11 +141,17 @@ func (c *Client) UploadPart(ctx context.Context, key, uploadID string, partNumbe
 		return "", fmt.Errorf("ETag is nil for part %d", partNumber)
 	}
 
-	return *result.ETag, nil
+	return quoteETag(*result.ETag), nil
 }
 
 // CompleteMultipartUpload completes a multipart upload
 func (c *Client) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []types.CompletedPart) error {
+	return c.completeMultipartUpload(ctx, key, uploadID, parts)
+}
+
+// completeMultipartUpload completes a multipart upload; optFns adjust the
+// request (e.g. to add a condition)
+func (c *Client) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []types.CompletedPart, optFns ...func(*s3.Options)) error {
 	if c.s3Client == nil {
 		return fmt.Errorf("S3 client not initialized")
 	}
@@ -81,11 +165,19 @@ func (c *Client) CompleteMultipartUpload(ctx context.Context, key, uploadID stri
 		},
 	}
 
-	_, err := c.s3Client.CompleteMultipartUpload(ctx, input)
+	// Never retry after an ambiguous failure: the upload may already be complete
+	err := c.retry.multipart().doIfRejected(ctx, func(ctx context.Context) error {
+		output, err := c.s3Client.CompleteMultipartUpload(ctx, input, optFns...)
+		if err == nil {
+			reportWritten(ctx, output.ETag)
+		}
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to complete multipart upload: %w", err)
 	}
 
+	c.active.remove(uploadID)
 	return nil
 }
 
@@ -95,13 +187,19 @@ func (c *Client) AbortMultipartUpload(ctx context.Context, key, uploadID string)
 		return fmt.Errorf("S3 client not initialized")
 	}
 
+	// Even if the abort fails, this process is done with the upload
+	defer c.active.remove(uploadID)
+
 	input := &s3.AbortMultipartUploadInput{
 		Bucket:   aws.String(c.bucket),
 		Key:      aws.String(key),
 		UploadId: aws.String(uploadID),
 	}
 
-	_, err := c.s3Client.AbortMultipartUpload(ctx, input)
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		_, err := c.s3Client.AbortMultipartUpload(ctx, input)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to abort multipart upload: %w", err)
 	}
@@ -110,25 +208,31 @@ func (c *Client) AbortMultipartUpload(ctx context.Context, key, uploadID string)
 }
 
 // PutObjectMultipart uploads an object using multipart upload for large files
+// Objects below the multipart threshold are uploaded with a single PUT
 func (c *Client) PutObjectMultipart(ctx context.Context, key string, data []byte) error {
-	if c.s3Client == nil {
-		return fmt.Errorf("S3 client not initialized")
-	}
+	return c.putObject(ctx, key, data, nil, c.storageClass)
+}
 
-	// Use simple PutObject for small files
-	if int64(len(data)) < MinMultipartSize {
-		return c.PutObject(ctx, key, data)
+// putObjectMultipart uploads an object in parts; optFns adjust the request
+// that completes the upload
+func (c *Client) putObjectMultipart(ctx context.Context, key string, data []byte, metadata map[string]string, storageClass types.StorageClass, optFns ...func(*s3.Options)) error {
+	if err := checkObjectSize(key, int64(len(data))); err != nil {
+		return err
 	}
+	defer metrics.StartOp(metrics.OpPut)()
+	logging.Debug("s3 multipart put", "key", key, "size", len(data))
 
 	// Initiate multipart upload
-	uploadID, err := c.CreateMultipartUpload(ctx, key)
+	uploadID, err := c.createMultipartUpload(ctx, key, storageClass, metadata)
 	if err != nil {
 		return fmt.Errorf("failed to create multipart upload: %w", err)
 	}
+	completed := false
+	defer c.abortUnlessCompleted(ctx, key, uploadID, &completed)
 
 	// Upload parts
 	var parts []types.CompletedPart
-	partSize := int64(DefaultPartSize)
+	partSize := c.partSizeFor(int64(len(data)))
 	totalParts := (int64(len(data)) + partSize - 1) / partSize
 
 	for i := int64(0); i < totalParts; i++ {
@@ -141,8 +245,6 @@ func (c *Client) PutObjectMultipart(ctx context.Context, key string, data []byte
 		partData := data[start:end]
 		etag, err := c.UploadPart(ctx, key, uploadID, int32(i+1), partData)
 		if err != nil {
-			// Try to abort on error
-			c.AbortMultipartUpload(ctx, key, uploadID)
 			return fmt.Errorf("failed to upload part %d: %w", i+1, err)
 		}
 
@@ -153,16 +255,51 @@ func (c *Client) PutObjectMultipart(ctx context.Context, key string, data []byte
 	}
 
 	// Complete multipart upload
-	err = c.CompleteMultipartUpload(ctx, key, uploadID, parts)
+	err = c.completeMultipartUpload(ctx, key, uploadID, parts, optFns...)
 	if err != nil {
-		// Try to abort on error
-		c.AbortMultipartUpload(ctx, key, uploadID)
 		return fmt.Errorf("failed to complete multipart upload: %w", err)
 	}
+	completed = true
+
+	return nil
+}
+
+// abortUnlessCompleted is deferred right after a multipart upload is created,
+// so the upload is aborted however the function returns without completing
+// it: by error, panic or cancellation. The abort outlives ctx, which may be
+// what stopped the upload
+func (c *Client) abortUnlessCompleted(ctx context.Context, key, uploadID string, completed *bool) {
+	if *completed {
+		return
+	}
+	if err := c.AbortMultipartUpload(context.WithoutCancel(ctx), key, uploadID); err != nil {
+		logging.Warn("failed to abort multipart upload", "key", key, "upload_id", uploadID, "err", err)
+	}
+}
 
+// partSizeFor returns the part size for a multipart upload of size bytes
+func (c *Client) partSizeFor(size int64) int64 {
+	return scalePartSize(c.partSize, size)
+}
+
+// checkObjectSize fails with EFBIG for objects larger than S3 stores, which
+// would need more parts than allowed even at the largest part size
+func checkObjectSize(key string, size int64) error {
+	if size > maxObjectSize {
+		return fmt.Errorf("%s would be %d bytes, more than the %d S3 stores: %w", key, size, int64(maxObjectSize), syscall.EFBIG)
+	}
 	return nil
 }
 
+// scalePartSize returns partSize, or larger parts if an object of size bytes
+// would need more parts than S3 allows
+func scalePartSize(partSize, size int64) int64 {
+	if minPart := (size + maxMultipartParts - 1) / maxMultipartParts; minPart > partSize {
+		partSize = minPart
+	}
+	return partSize
+}
+
 // CopyPart copies a part from source object for multipart copy
 func (c *Client) CopyPart(ctx context.Context, destKey, uploadID string, partNumber int32, sourceKey string, start, end int64) (string, error) {
 	if c.s3Client == nil {
@@ -178,8 +315,14 @@ func (c *Client) CopyPart(ctx context.Context, destKey, uploadID string, partNum
 		CopySource:      aws.String(copySource),
 		CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
 	}
-
-	result, err := c.s3Client.UploadPartCopy(ctx, input)
+	c.sse.applyUploadPartCopy(input)
+
+	var result *s3.UploadPartCopyOutput
+	err := c.retry.multipart().do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.s3Client.UploadPartCopy(ctx, input)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to copy part %d: %w", partNumber, err)
 	}
@@ -188,39 +331,71 @@ func (c *Client) CopyPart(ctx context.Context, destKey, uploadID string, partNum
 		return "", fmt.Errorf("ETag is nil for copied part %d", partNumber)
 	}
 
-	return *result.CopyPartResult.ETag, nil
+	return quoteETag(*result.CopyPartResult.ETag), nil
 }
 
 // CopyObjectMultipart copies an object using multipart copy for large files
+// The source's user metadata is carried over; this is the only way to copy
+// objects larger than MaxCopyObjectSize
 func (c *Client) CopyObjectMultipart(ctx context.Context, sourceKey, destKey string) error {
+	return c.CopyObjectMultipartWithMetadata(ctx, sourceKey, destKey, nil)
+}
+
+// CopyObjectMultipartWithMetadata is CopyObjectMultipart with the copy's user
+// metadata replaced by metadata, unless it is nil. Copying an object onto
+// itself this way changes the metadata of one larger than MaxCopyObjectSize.
+// The source's Content-Type is kept unless metadata sets one
+func (c *Client) CopyObjectMultipartWithMetadata(ctx context.Context, sourceKey, destKey string, metadata map[string]string) error {
 	if c.s3Client == nil {
 		return fmt.Errorf("S3 client not initialized")
 	}
 
 	// Get source object size
-	sourceSize, err := c.HeadObjectSize(ctx, sourceKey)
+	info, err := c.HeadObjectFull(ctx, sourceKey)
 	if err != nil {
 		return fmt.Errorf("failed to get source object size: %w", err)
 	}
+	sourceSize := info.Size
+	if err := checkObjectSize(destKey, sourceSize); err != nil {
+		return err
+	}
+
+	// Keep the source's storage class unless one is configured
+	storageClass := c.storageClass
+	if storageClass == "" {
+		storageClass = info.StorageClass
+	}
+	if metadata == nil {
+		metadata = info.Metadata
+	} else {
+		metadata = maps.Clone(metadata)
+	}
+	if _, ok := metadata[ContentTypeKey]; !ok && info.ContentType != "" {
+		metadata[ContentTypeKey] = info.ContentType
+	}
 
 	// Use simple copy for small files
-	if sourceSize < MinMultipartSize {
+	if sourceSize < c.multipartThreshold {
 		data, err := c.GetObject(ctx, sourceKey)
 		if err != nil {
 			return fmt.Errorf("failed to read source object: %w", err)
 		}
-		return c.PutObject(ctx, destKey, data)
+		return c.putObject(ctx, destKey, data, metadata, storageClass)
 	}
+	defer metrics.StartOp(metrics.OpCopy)()
+	logging.Debug("s3 multipart copy", "source", sourceKey, "dest", destKey, "size", sourceSize)
 
 	// Initiate multipart upload
-	uploadID, err := c.CreateMultipartUpload(ctx, destKey)
+	uploadID, err := c.createMultipartUpload(ctx, destKey, storageClass, metadata)
 	if err != nil {
 		return fmt.Errorf("failed to create multipart upload: %w", err)
 	}
+	completed := false
+	defer c.abortUnlessCompleted(ctx, destKey, uploadID, &completed)
 
 	// Copy parts
 	var parts []types.CompletedPart
-	partSize := int64(DefaultPartSize)
+	partSize := c.partSizeFor(sourceSize)
 	totalParts := (sourceSize + partSize - 1) / partSize
 
 	for i := int64(0); i < totalParts; i++ {
@@ -232,8 +407,6 @@ func (c *Client) CopyObjectMultipart(ctx context.Context, sourceKey, destKey str
 
 		etag, err := c.CopyPart(ctx, destKey, uploadID, int32(i+1), sourceKey, start, end)
 		if err != nil {
-			// Try to abort on error
-			c.AbortMultipartUpload(ctx, destKey, uploadID)
 			return fmt.Errorf("failed to copy part %d: %w", i+1, err)
 		}
 
@@ -246,10 +419,121 @@ func (c *Client) CopyObjectMultipart(ctx context.Context, sourceKey, destKey str
 	// Complete multipart upload
 	err = c.CompleteMultipartUpload(ctx, destKey, uploadID, parts)
 	if err != nil {
-		// Try to abort on error
-		c.AbortMultipartUpload(ctx, destKey, uploadID)
 		return fmt.Errorf("failed to complete multipart copy: %w", err)
 	}
+	completed = true
 
 	return nil
 }
+
+// patchPart is one part of an object rewritten by PatchObject
+type patchPart struct {
+	start, end int64 // Byte range in the new object
+	copied     bool  // Copied from the stored object rather than uploaded
+}
+
+// planPatch splits an object of oldSize bytes, with length bytes at offset
+// replaced, into parts of partSize. Parts that the new bytes don't touch and
+// that lie within the stored object are copied
+func planPatch(oldSize, offset, length, partSize int64) []patchPart {
+	newSize := max(oldSize, offset+length)
+	var parts []patchPart
+	for start := int64(0); start < newSize; start += partSize {
+		end := min(start+partSize, newSize)
+		touched := start < offset+length && end > offset
+		parts = append(parts, patchPart{start: start, end: end, copied: !touched && end <= oldSize})
+	}
+	return parts
+}
+
+// PatchObject replaces the bytes of key from offset with data, and its metadata,
+// without uploading the rest of the object again: parts the new bytes don't
+// touch are copied server-side, and only the parts they fall in are read and
+// uploaded. The object grows if data extends past its end. It reports false,
+// leaving the object alone, when no part could be copied and a plain PUT of
+// the whole object is no more expensive
+func (c *Client) PatchObject(ctx context.Context, key string, offset int64, data []byte, metadata map[string]string) (bool, error) {
+	if c.s3Client == nil {
+		return false, fmt.Errorf("S3 client not initialized")
+	}
+	info, err := c.HeadObjectFull(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to get object size: %w", err)
+	}
+	oldSize := info.Size
+	newSize := max(oldSize, offset+int64(len(data)))
+	if newSize < c.multipartThreshold {
+		return false, nil
+	}
+	if err := checkObjectSize(key, newSize); err != nil {
+		return false, err
+	}
+	plan := planPatch(oldSize, offset, int64(len(data)), c.partSizeFor(newSize))
+	copied := 0
+	for _, part := range plan {
+		if part.copied {
+			copied++
+		}
+	}
+	if copied == 0 {
+		return false, nil
+	}
+	defer metrics.StartOp(metrics.OpPut)()
+	logging.Debug("s3 patch", "key", key, "offset", offset, "size", len(data), "parts", len(plan), "copied", copied)
+
+	storageClass := c.storageClass
+	if storageClass == "" {
+		storageClass = info.StorageClass
+	}
+	uploadID, err := c.createMultipartUpload(ctx, key, storageClass, metadata)
+	if err != nil {
+		return false, err
+	}
+	completed := false
+	defer c.abortUnlessCompleted(ctx, key, uploadID, &completed)
+
+	parts := make([]types.CompletedPart, 0, len(plan))
+	for i, part := range plan {
+		partNumber := int32(i + 1)
+		var etag string
+		if part.copied {
+			etag, err = c.CopyPart(ctx, key, uploadID, partNumber, key, part.start, part.end)
+		} else {
+			var partData []byte
+			if partData, err = c.patchedPart(ctx, key, oldSize, part, offset, data); err == nil {
+				etag, err = c.UploadPart(ctx, key, uploadID, partNumber, partData)
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       aws.String(etag),
+			PartNumber: aws.Int32(partNumber),
+		})
+	}
+
+	if err := c.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		return false, err
+	}
+	completed = true
+	return true, nil
+}
+
+// patchedPart returns the content of part in the patched object: the stored
+// bytes it keeps, read from S3, with the new data laid over them
+func (c *Client) patchedPart(ctx context.Context, key string, oldSize int64, part patchPart, offset int64, data []byte) ([]byte, error) {
+	buf := make([]byte, part.end-part.start)
+	dataEnd := offset + int64(len(data))
+	if storedEnd := min(part.end, oldSize); part.start < storedEnd && (part.start < offset || storedEnd > dataEnd) {
+		stored, err := c.GetObjectRange(ctx, key, part.start, storedEnd-1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part at %d: %w", part.start, err)
+		}
+		copy(buf, stored)
+	}
+	if start, end := max(part.start, offset), min(part.end, dataEnd); start < end {
+		copy(buf[start-part.start:], data[start-offset:end-offset])
+	}
+	return buf, nil
+}
@@ -3,7 +3,17 @@ package s3client
 import (
 	"context"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
 	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
 const (
@@ -189,3 +199,215 @@ func TestMultipartAbort(t *testing.T) {
 		t.Error("Object should not exist after abort")
 	}
 }
+
+func TestPlanPatch(t *testing.T) {
+	tests := []struct {
+		name                    string
+		oldSize, offset, length int64
+		want                    []patchPart
+	}{
+		{"edit in middle part", 25, 12, 2, []patchPart{
+			{0, 10, true}, {10, 20, false}, {20, 25, true},
+		}},
+		{"edit across a part boundary", 30, 8, 4, []patchPart{
+			{0, 10, false}, {10, 20, false}, {20, 30, true},
+		}},
+		{"append", 20, 20, 5, []patchPart{
+			{0, 10, true}, {10, 20, true}, {20, 25, false},
+		}},
+		{"part ending past the stored size", 15, 18, 4, []patchPart{
+			{0, 10, true}, {10, 20, false}, {20, 22, false},
+		}},
+	}
+	for _, tt := range tests {
+		got := planPatch(tt.oldSize, tt.offset, tt.length, 10)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: planPatch = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: planPatch = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMockPatchObject(t *testing.T) {
+	client := NewMockClient("test-bucket", "us-east-1")
+	ctx := context.Background()
+	original := generateTestData(3 * DefaultPartSize)
+	client.PutObject(ctx, "large.bin", original)
+	before := client.UploadedBytes()
+
+	patched, err := client.PatchObject(ctx, "large.bin", DefaultPartSize+100, []byte("patch"), map[string]string{"mode": "644"})
+	if err != nil || !patched {
+		t.Fatalf("PatchObject = %v, %v; want true", patched, err)
+	}
+	if n := client.UploadedBytes() - before; n != DefaultPartSize {
+		t.Errorf("Uploaded %d bytes, want one part of %d", n, DefaultPartSize)
+	}
+	data, _ := client.GetObject(ctx, "large.bin")
+	want := append([]byte{}, original...)
+	copy(want[DefaultPartSize+100:], "patch")
+	if string(data) != string(want) {
+		t.Error("Patched object content differs")
+	}
+
+	// Small objects are left to a plain PUT
+	client.PutObject(ctx, "small.bin", []byte("small"))
+	if patched, _ := client.PatchObject(ctx, "small.bin", 0, []byte("S"), nil); patched {
+		t.Error("Patched an object smaller than a part")
+	}
+}
+
+func TestMultipartSettingsValidation(t *testing.T) {
+	client := NewClientWithProvider("test-bucket", "us-east-1", "", nil)
+	for _, size := range []int64{MinMultipartSize - 1, MaxCopyObjectSize + 1} {
+		if err := client.SetMultipartThreshold(size); err == nil {
+			t.Errorf("SetMultipartThreshold(%d) succeeded", size)
+		}
+	}
+	for _, size := range []int64{MinMultipartSize - 1, maxPartSize + 1} {
+		if err := client.SetPartSize(size); err == nil {
+			t.Errorf("SetPartSize(%d) succeeded", size)
+		}
+	}
+	if err := client.SetPartSize(64 * 1024 * 1024); err != nil {
+		t.Errorf("SetPartSize(64MB) failed: %v", err)
+	}
+	// Parts grow so a 1TB object fits in 10,000 of them
+	const size = 1 << 40
+	if got := client.partSizeFor(size); got*maxMultipartParts < size || got < 64*1024*1024 {
+		t.Errorf("partSizeFor(1TB) = %d", got)
+	}
+	if got := client.partSizeFor(100 * 1024 * 1024); got != 64*1024*1024 {
+		t.Errorf("partSizeFor(100MB) = %d, want the configured 64MB", got)
+	}
+}
+
+// multipartServer records the requests of multipart uploads and copies
+type multipartServer struct {
+	mu         sync.Mutex
+	sourceSize string // Content-Length of HEAD responses ("" = 12MB)
+	creates    int
+	createMode []string // x-amz-meta-mode of each upload started
+	puts       int
+	partSizes  []int
+	copyRanges []string
+	completes  int
+}
+
+func (s *multipartServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodHead:
+		w.Header().Set("Content-Length", "12582912")
+		if s.sourceSize != "" {
+			w.Header().Set("Content-Length", s.sourceSize)
+		}
+		w.Header().Set("ETag", `"source"`)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.creates++
+		s.createMode = append(s.createMode, r.Header.Get("x-amz-meta-mode"))
+		w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>test-bucket</Bucket><Key>k</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completes++
+		w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"done-2"</ETag></CompleteMultipartUploadResult>`))
+	case r.Method == http.MethodPut && query.Has("partNumber") && r.Header.Get("x-amz-copy-source") != "":
+		s.copyRanges = append(s.copyRanges, r.Header.Get("x-amz-copy-source-range"))
+		w.Write([]byte(`<CopyPartResult><ETag>"copied"</ETag></CopyPartResult>`))
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		s.partSizes = append(s.partSizes, len(body))
+		w.Header().Set("ETag", `"part"`)
+	case r.Method == http.MethodPut:
+		s.puts++
+		w.Header().Set("ETag", `"single"`)
+	}
+}
+
+func TestMultipartPartSizeAndThreshold(t *testing.T) {
+	server := &multipartServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", httpServer.URL, provider)
+	ctx := context.Background()
+	const mb = 1024 * 1024
+	if err := client.SetPartSize(8 * mb); err != nil {
+		t.Fatalf("SetPartSize failed: %v", err)
+	}
+
+	// A 12MB upload with 8MB parts takes exactly 2 parts, and reports the
+	// ETag the completion returned
+	writeCtx, written := types.WithWritten(ctx)
+	if err := client.PutObjectWithMetadata(writeCtx, "big.bin", make([]byte, 12*mb), nil); err != nil {
+		t.Fatalf("PutObjectWithMetadata failed: %v", err)
+	}
+	if len(server.partSizes) != 2 || server.partSizes[0] != 8*mb || server.partSizes[1] != 4*mb || server.completes != 1 {
+		t.Errorf("Uploaded parts %v with %d completions, want [8MB 4MB] and 1", server.partSizes, server.completes)
+	}
+	if written.ETag != `"done-2"` {
+		t.Errorf("Reported ETag %q, want %q", written.ETag, `"done-2"`)
+	}
+
+	// Copies use the same part size
+	if err := client.CopyObjectMultipart(ctx, "big.bin", "copy.bin"); err != nil {
+		t.Fatalf("CopyObjectMultipart failed: %v", err)
+	}
+	if want := []string{"bytes=0-8388607", "bytes=8388608-12582911"}; len(server.copyRanges) != 2 || server.copyRanges[0] != want[0] || server.copyRanges[1] != want[1] {
+		t.Errorf("Copied ranges %v, want %v", server.copyRanges, want)
+	}
+
+	// A copy onto itself with new metadata starts its upload with that metadata
+	if err := client.CopyObjectMultipartWithMetadata(ctx, "big.bin", "big.bin", map[string]string{"mode": "600"}); err != nil {
+		t.Fatalf("CopyObjectMultipartWithMetadata failed: %v", err)
+	}
+	if modes := server.createMode; len(modes) != 3 || modes[1] != "" || modes[2] != "600" {
+		t.Errorf("Uploads started with modes %q, want the source's (none) then 600", modes)
+	}
+
+	// Below a raised threshold the same upload is a single PUT
+	if err := client.SetMultipartThreshold(16 * mb); err != nil {
+		t.Fatalf("SetMultipartThreshold failed: %v", err)
+	}
+	if err := client.PutObjectWithMetadata(ctx, "big.bin", make([]byte, 12*mb), nil); err != nil {
+		t.Fatalf("PutObjectWithMetadata failed: %v", err)
+	}
+	if server.puts != 1 || len(server.partSizes) != 2 {
+		t.Errorf("Got %d single PUTs and %d parts, want 1 PUT and no new parts", server.puts, len(server.partSizes))
+	}
+}
+
+func TestMultipartObjectSizeLimit(t *testing.T) {
+	const tb = 1024 * 1024 * 1024 * 1024
+	// The largest object S3 stores fits in 10,000 parts of at most 5GB
+	for _, partSize := range []int64{DefaultPartSize, 64 * 1024 * 1024} {
+		size := int64(5 * tb)
+		scaled := scalePartSize(partSize, size)
+		if scaled > maxPartSize || (size+scaled-1)/scaled > maxMultipartParts {
+			t.Errorf("scalePartSize(%d, 5TB) = %d, over the part size or count limit", partSize, scaled)
+		}
+	}
+
+	server := &multipartServer{sourceSize: fmt.Sprint(6 * tb)}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", httpServer.URL, provider)
+
+	if err := client.CopyObjectMultipart(context.Background(), "huge.bin", "copy.bin"); !errors.Is(err, syscall.EFBIG) {
+		t.Errorf("CopyObjectMultipart of 6TB = %v, want EFBIG", err)
+	}
+	if server.creates != 0 {
+		t.Errorf("Started %d uploads for an object S3 can't store", server.creates)
+	}
+}
@@ -0,0 +1,256 @@
+package s3client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	// DefaultMaxRetries is the default number of retries after the first attempt
+	DefaultMaxRetries = 5
+	// DefaultRetryBaseDelay is the default delay before the first retry
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	// DefaultRetryMaxDelay is the default upper bound for a single retry delay
+	DefaultRetryMaxDelay = 20 * time.Second
+	// DefaultMaxResumes is the default number of times a download cut short is resumed
+	DefaultMaxResumes = 3
+	// DefaultRequestTimeout is the default limit on a single S3 request attempt
+	DefaultRequestTimeout = 2 * time.Minute
+	// DefaultMultipartTimeout is the default limit on uploading or copying one
+	// part of a multipart upload, and on completing the upload
+	DefaultMultipartTimeout = 10 * time.Minute
+)
+
+// RetryPolicy controls how transient S3 errors are retried
+// Delays grow exponentially from BaseDelay, capped at MaxDelay, with jitter
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt (0 disables retrying)
+	BaseDelay  time.Duration // Delay before the first retry
+	MaxDelay   time.Duration // Upper bound on any single delay
+	// Times a download cut short is continued from the last byte received
+	// before it is retried from the start (0 always retries from the start)
+	MaxResumes int
+	// Limit on each attempt of a request, so a hung connection fails and is
+	// retried instead of blocking the caller forever (0 = no limit)
+	Timeout time.Duration
+	// Limit used instead of Timeout for multipart parts, their completion and
+	// server-side copies, which move far more data than other requests (0 = no limit)
+	MultipartTimeout time.Duration
+
+	// Overridable for tests
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func(d time.Duration) time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used by new clients
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultRetryBaseDelay,
+		MaxDelay:   DefaultRetryMaxDelay,
+		MaxResumes: DefaultMaxResumes,
+
+		Timeout:          DefaultRequestTimeout,
+		MultipartTimeout: DefaultMultipartTimeout,
+	}
+}
+
+// multipart returns the policy for requests bounded by MultipartTimeout
+func (p RetryPolicy) multipart() RetryPolicy {
+	p.Timeout = p.MultipartTimeout
+	return p
+}
+
+// timeoutError is returned when a request attempt ran past the policy's timeout
+// while the caller was still waiting. Unlike a cancelled caller, it is retryable
+type timeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %s: %v", e.timeout, e.err)
+}
+
+func (e *timeoutError) Unwrap() error { return e.err }
+
+// Timeout reports true, so net.Error checks treat it like a network timeout
+func (e *timeoutError) Timeout() bool { return true }
+
+// Temporary implements net.Error
+func (e *timeoutError) Temporary() bool { return true }
+
+// backoff returns the delay before retry number attempt (0-based)
+// Uses "equal jitter": half of the exponential delay is kept, the other half is randomized
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+	jitter := p.jitter
+	if jitter == nil {
+		jitter = randomJitter
+	}
+	return half + jitter(d-half)
+}
+
+// randomJitter returns a random duration in [0, d]
+func randomJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do runs fn, retrying idempotent operations on transient errors
+// fn must use the context it is given, which carries the attempt's timeout
+func (p RetryPolicy) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return p.doWhen(ctx, isRetryable, fn)
+}
+
+// doIfRejected runs fn, retrying only when S3 explicitly rejected the request
+// Used for non-idempotent calls (e.g. CompleteMultipartUpload) where a timeout or
+// dropped connection may hide a request that already succeeded
+func (p RetryPolicy) doIfRejected(ctx context.Context, fn func(ctx context.Context) error) error {
+	return p.doWhen(ctx, isRejected, fn)
+}
+
+func (p RetryPolicy) doWhen(ctx context.Context, retryable func(error) bool, fn func(ctx context.Context) error) error {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := p.attempt(ctx, fn)
+		if err == nil || attempt >= p.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		if sleepErr := sleep(ctx, p.backoff(attempt)); sleepErr != nil {
+			// Context cancelled while waiting - report the last S3 error
+			return err
+		}
+	}
+}
+
+// attempt runs fn once, bounded by the policy's timeout
+func (p RetryPolicy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	err := fn(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return &timeoutError{timeout: p.Timeout, err: err}
+	}
+	return err
+}
+
+// isRejected reports whether S3 refused the request without processing it (throttling)
+func isRejected(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "ServiceUnavailable", "Throttling", "ThrottlingException",
+			"TooManyRequestsException", "RequestLimitExceeded":
+			return true
+		}
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRetryable reports whether err is a transient error worth retrying
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	// Checked first: an attempt that timed out wraps context.DeadlineExceeded
+	var timeoutErr *timeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isRejected(err) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "InternalError", "RequestTimeout", "RequestTimeoutException":
+			return true
+		}
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+
+	// Network-level failures
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "connection reset")
+}
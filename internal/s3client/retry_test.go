@@ -0,0 +1,274 @@
+package s3client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// fakeClock records requested sleeps instead of waiting
+type fakeClock struct {
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.sleeps = append(c.sleeps, d)
+	return nil
+}
+
+func newTestPolicy(clock *fakeClock, maxRetries int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  100 * time.Millisecond,
+		MaxDelay:   1 * time.Second,
+		sleep:      clock.sleep,
+		// Deterministic: always take the full jitter window
+		jitter: func(d time.Duration) time.Duration { return d },
+	}
+}
+
+func TestRetryBackoffSchedule(t *testing.T) {
+	clock := &fakeClock{}
+	policy := newTestPolicy(clock, 6)
+
+	slowDown := &smithy.GenericAPIError{Code: "SlowDown"}
+	calls := 0
+	err := policy.do(context.Background(), func(context.Context) error {
+		calls++
+		return slowDown
+	})
+	if !errors.Is(err, slowDown) {
+		t.Fatalf("Expected last error to be returned, got %v", err)
+	}
+	if calls != 7 {
+		t.Errorf("Expected 7 attempts, got %d", calls)
+	}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1 * time.Second, // capped at MaxDelay
+		1 * time.Second,
+	}
+	if len(clock.sleeps) != len(expected) {
+		t.Fatalf("Expected %d sleeps, got %d: %v", len(expected), len(clock.sleeps), clock.sleeps)
+	}
+	for i, d := range expected {
+		if clock.sleeps[i] != d {
+			t.Errorf("Sleep %d: expected %v, got %v", i, d, clock.sleeps[i])
+		}
+	}
+}
+
+func TestRetryBackoffJitterBounds(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		ceiling := policy.BaseDelay << attempt
+		if ceiling > policy.MaxDelay {
+			ceiling = policy.MaxDelay
+		}
+		for i := 0; i < 50; i++ {
+			d := policy.backoff(attempt)
+			if d < ceiling/2 || d > ceiling {
+				t.Fatalf("Attempt %d: delay %v outside [%v, %v]", attempt, d, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryStopsOnSuccess(t *testing.T) {
+	clock := &fakeClock{}
+	policy := newTestPolicy(clock, 5)
+
+	calls := 0
+	err := policy.do(context.Background(), func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("failed to get object: %w", syscall.ECONNRESET)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+	if len(clock.sleeps) != 2 {
+		t.Errorf("Expected 2 sleeps, got %d", len(clock.sleeps))
+	}
+}
+
+func TestRetryNonRetryableError(t *testing.T) {
+	clock := &fakeClock{}
+	policy := newTestPolicy(clock, 5)
+
+	calls := 0
+	err := policy.do(context.Background(), func(context.Context) error {
+		calls++
+		return &smithy.GenericAPIError{Code: "AccessDenied"}
+	})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 attempt for non-retryable error, got %d", calls)
+	}
+	if len(clock.sleeps) != 0 {
+		t.Errorf("Expected no sleeps, got %v", clock.sleeps)
+	}
+}
+
+func TestRetryRespectsContextCancellation(t *testing.T) {
+	clock := &fakeClock{}
+	policy := newTestPolicy(clock, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := policy.do(ctx, func(context.Context) error {
+		calls++
+		cancel()
+		return &smithy.GenericAPIError{Code: "ServiceUnavailable"}
+	})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if calls != 1 {
+		t.Errorf("Expected no retries after cancellation, got %d attempts", calls)
+	}
+
+	// A cancelled context never starts an attempt
+	calls = 0
+	err = policy.do(ctx, func(context.Context) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no attempts with cancelled context, got %d", calls)
+	}
+}
+
+func TestRetryNonIdempotentAmbiguousFailure(t *testing.T) {
+	clock := &fakeClock{}
+	policy := newTestPolicy(clock, 5)
+
+	// Ambiguous failures must not be retried
+	for _, ambiguous := range []error{
+		&smithy.GenericAPIError{Code: "InternalError"},
+		&smithy.GenericAPIError{Code: "RequestTimeout"},
+		fmt.Errorf("read: %w", syscall.ECONNRESET),
+	} {
+		calls := 0
+		policy.doIfRejected(context.Background(), func(context.Context) error {
+			calls++
+			return ambiguous
+		})
+		if calls != 1 {
+			t.Errorf("%v: expected 1 attempt, got %d", ambiguous, calls)
+		}
+	}
+
+	// Explicit throttling means the request was not processed, so retrying is safe
+	calls := 0
+	err := policy.doIfRejected(context.Background(), func(context.Context) error {
+		calls++
+		if calls == 1 {
+			return &smithy.GenericAPIError{Code: "SlowDown"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success after throttling, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{&smithy.GenericAPIError{Code: "SlowDown"}, true},
+		{&smithy.GenericAPIError{Code: "ServiceUnavailable"}, true},
+		{&smithy.GenericAPIError{Code: "InternalError"}, true},
+		{&smithy.GenericAPIError{Code: "RequestTimeout"}, true},
+		{&smithy.GenericAPIError{Code: "NoSuchKey"}, false},
+		{fmt.Errorf("wrapped: %w", syscall.ECONNRESET), true},
+		{errors.New("read tcp: connection reset by peer"), true},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.expected {
+			t.Errorf("isRetryable(%v) = %v, expected %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	clock := &fakeClock{}
+	policy := newTestPolicy(clock, 2)
+	policy.Timeout = 20 * time.Millisecond
+
+	// A hung attempt is cut off and retried
+	calls := 0
+	err := policy.do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success after a timed out attempt, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 attempts, got %d", calls)
+	}
+
+	// A timeout is ambiguous for non-idempotent requests, so it is not retried
+	calls = 0
+	err = policy.doIfRejected(context.Background(), func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", calls)
+	}
+
+	// Multipart requests get their own limit
+	policy.MultipartTimeout = time.Second
+	err = policy.multipart().do(context.Background(), func(ctx context.Context) error {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		t.Errorf("Expected multipart request to outlast Timeout, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotInSnapshot is returned for keys that had no object at the snapshot time
+// It wraps os.ErrNotExist, so the filesystem reports such keys as missing
+var ErrNotInSnapshot = fmt.Errorf("object did not exist at the snapshot time: %w", os.ErrNotExist)
+
+// maxSnapshotVersions bounds how many resolved keys a snapshot remembers
+const maxSnapshotVersions = 100000
+
+// snapshot pins listings and reads to the object versions current at a point
+// in time. Versions that old never change, so what a key resolves to is kept
+// until the cache is full; then arbitrary keys are forgotten and resolved again
+// if they are needed
+type snapshot struct {
+	at       time.Time
+	mu       sync.Mutex
+	versions map[string]string // Key -> ID of its version at the pin ("" = no object then)
+	limit    int               // Most keys versions holds
+}
+
+// SetSnapshotTime pins the client to the bucket as it was at t: listings show the
+// keys that had an object then, and reads and HEADs fetch the version that was
+// current then, found with ListObjectVersions. The bucket must be versioned.
+// Writes are not pinned, so a snapshot should be mounted read-only. The zero
+// time unpins the client
+func (c *Client) SetSnapshotTime(t time.Time) {
+	c.snapshot.mu.Lock()
+	defer c.snapshot.mu.Unlock()
+	c.snapshot.at = t
+	c.snapshot.versions = make(map[string]string)
+	c.snapshot.limit = maxSnapshotVersions
+}
+
+// pinned reports whether a snapshot time is set
+func (s *snapshot) pinned() bool {
+	return !s.at.IsZero()
+}
+
+// versionsAt returns, for each key in versions, the version that was current at
+// t: the newest one last modified at or before t, which may be a delete marker.
+// Keys created after t are left out
+func versionsAt(versions []ObjectVersion, t time.Time) map[string]ObjectVersion {
+	current := make(map[string]ObjectVersion)
+	for _, v := range versions {
+		if v.LastModified.After(t) {
+			continue
+		}
+		if seen, ok := current[v.Key]; !ok || v.LastModified.After(seen.LastModified) {
+			current[v.Key] = v
+		}
+	}
+	return current
+}
+
+// keys returns the keys of a version listing that had an object at the snapshot
+// time, remembering the version of every key listed
+func (s *snapshot) keys(versions []ObjectVersion) []string {
+	current := versionsAt(versions, s.at)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for _, v := range versions {
+		if _, ok := current[v.Key]; !ok {
+			s.remember(v.Key, "")
+		}
+	}
+	for key, v := range current {
+		if v.DeleteMarker {
+			s.remember(key, "")
+		} else {
+			s.remember(key, v.VersionID)
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// remember records the version of key at the snapshot time, forgetting an
+// arbitrary other key if the cache is full. Called with mu held
+func (s *snapshot) remember(key, id string) {
+	if _, known := s.versions[key]; !known && len(s.versions) >= s.limit {
+		for other := range s.versions {
+			delete(s.versions, other)
+			break
+		}
+	}
+	s.versions[key] = id
+}
+
+// pinnedVersion returns the ID of the version of key that was current at the
+// snapshot time, or "" if the client is not pinned. Keys that had no object then
+// fail with ErrNotInSnapshot
+func (c *Client) pinnedVersion(ctx context.Context, key string) (string, error) {
+	if !c.snapshot.pinned() {
+		return "", nil
+	}
+	c.snapshot.mu.Lock()
+	id, known := c.snapshot.versions[key]
+	c.snapshot.mu.Unlock()
+
+	if !known {
+		// Listing the key as a prefix, one level deep, also resolves the
+		// sibling keys it is a prefix of
+		versions, _, err := c.listVersions(ctx, key, "/")
+		if err != nil {
+			return "", err
+		}
+		c.snapshot.keys(versions)
+		c.snapshot.mu.Lock()
+		if id, known = c.snapshot.versions[key]; !known {
+			c.snapshot.remember(key, "")
+		}
+		c.snapshot.mu.Unlock()
+	}
+	if id == "" {
+		return "", fmt.Errorf("%s at %s: %w", key, c.snapshot.at.Format(time.RFC3339), ErrNotInSnapshot)
+	}
+	return id, nil
+}
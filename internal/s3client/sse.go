@@ -0,0 +1,165 @@
+package s3client
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// SSEMode selects the server-side encryption applied to new objects
+type SSEMode string
+
+const (
+	SSENone SSEMode = ""    // No encryption headers (bucket default applies)
+	SSES3   SSEMode = "s3"  // S3-managed keys (AES256)
+	SSEKMS  SSEMode = "kms" // AWS KMS keys
+	SSEC    SSEMode = "c"   // Customer-provided key sent with every request
+)
+
+// sseCustomerAlgorithm is the only algorithm S3 accepts for SSE-C
+const sseCustomerAlgorithm = "AES256"
+
+// SSEOptions configures server-side encryption for uploads and copies
+type SSEOptions struct {
+	Mode        SSEMode
+	KMSKeyID    string // Key ID or ARN for SSE-KMS (empty = AWS managed key)
+	CustomerKey string // 256-bit key for SSE-C, raw or base64-encoded
+}
+
+// ParseSSEMode parses an -sse flag value
+// Accepts the short names as well as the S3 header values (AES256, aws:kms)
+func ParseSSEMode(value string) (SSEMode, error) {
+	switch strings.ToLower(value) {
+	case "", "none":
+		return SSENone, nil
+	case "s3", "sse-s3", "aes256":
+		return SSES3, nil
+	case "kms", "sse-kms", "aws:kms":
+		return SSEKMS, nil
+	case "c", "sse-c", "custom":
+		return SSEC, nil
+	}
+	return SSENone, fmt.Errorf("unknown SSE mode %q (want s3, kms or c)", value)
+}
+
+// sseConfig is the validated form of SSEOptions, with SSE-C key material
+// already encoded the way S3 expects it in request headers
+type sseConfig struct {
+	mode     SSEMode
+	kmsKeyID string
+	keyB64   string // base64 of the 32-byte SSE-C key
+	keyMD5   string // base64 of the MD5 digest of the SSE-C key
+}
+
+// newSSEConfig validates options and prepares the SSE-C headers
+func newSSEConfig(options SSEOptions) (sseConfig, error) {
+	cfg := sseConfig{mode: options.Mode}
+	switch options.Mode {
+	case SSENone, SSES3:
+	case SSEKMS:
+		cfg.kmsKeyID = options.KMSKeyID
+	case SSEC:
+		key, err := decodeCustomerKey(options.CustomerKey)
+		if err != nil {
+			return sseConfig{}, err
+		}
+		sum := md5.Sum(key)
+		cfg.keyB64 = base64.StdEncoding.EncodeToString(key)
+		cfg.keyMD5 = base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		return sseConfig{}, fmt.Errorf("unknown SSE mode %q", options.Mode)
+	}
+	return cfg, nil
+}
+
+// decodeCustomerKey returns the 32-byte SSE-C key from its raw or base64 form
+func decodeCustomerKey(value string) ([]byte, error) {
+	if len(value) == 32 {
+		return []byte(value), nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("SSE-C key must be 32 bytes, raw or base64-encoded")
+}
+
+// SetSSEOptions sets the server-side encryption used for uploads and copies
+// With SSE-C the key is also sent on reads, since S3 cannot decrypt without it
+func (c *Client) SetSSEOptions(options SSEOptions) error {
+	cfg, err := newSSEConfig(options)
+	if err != nil {
+		return err
+	}
+	c.sse = cfg
+	return nil
+}
+
+// serverSideEncryption returns the x-amz-server-side-encryption value for SSE-S3/SSE-KMS
+func (s sseConfig) serverSideEncryption() types.ServerSideEncryption {
+	switch s.mode {
+	case SSES3:
+		return types.ServerSideEncryptionAes256
+	case SSEKMS:
+		return types.ServerSideEncryptionAwsKms
+	}
+	return ""
+}
+
+// kmsKey returns the KMS key ID header value, or nil for the AWS managed key
+func (s sseConfig) kmsKey() *string {
+	if s.mode != SSEKMS || s.kmsKeyID == "" {
+		return nil
+	}
+	return aws.String(s.kmsKeyID)
+}
+
+// customerKey returns the SSE-C algorithm, key and key MD5 headers (all nil unless SSE-C)
+func (s sseConfig) customerKey() (algorithm, key, keyMD5 *string) {
+	if s.mode != SSEC {
+		return nil, nil, nil
+	}
+	return aws.String(sseCustomerAlgorithm), aws.String(s.keyB64), aws.String(s.keyMD5)
+}
+
+func (s sseConfig) applyPut(input *s3.PutObjectInput) {
+	input.ServerSideEncryption = s.serverSideEncryption()
+	input.SSEKMSKeyId = s.kmsKey()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+}
+
+func (s sseConfig) applyCreateMultipart(input *s3.CreateMultipartUploadInput) {
+	input.ServerSideEncryption = s.serverSideEncryption()
+	input.SSEKMSKeyId = s.kmsKey()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+}
+
+// applyUploadPart sets the SSE-C headers; SSE-S3/KMS are fixed when the upload is created
+func (s sseConfig) applyUploadPart(input *s3.UploadPartInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+}
+
+// applyCopy encrypts the destination and, for SSE-C, supplies the key to decrypt the source
+func (s sseConfig) applyCopy(input *s3.CopyObjectInput) {
+	input.ServerSideEncryption = s.serverSideEncryption()
+	input.SSEKMSKeyId = s.kmsKey()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = s.customerKey()
+}
+
+func (s sseConfig) applyUploadPartCopy(input *s3.UploadPartCopyInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = s.customerKey()
+}
+
+func (s sseConfig) applyGet(input *s3.GetObjectInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+}
+
+func (s sseConfig) applyHead(input *s3.HeadObjectInput) {
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+}
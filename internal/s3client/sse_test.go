@@ -0,0 +1,101 @@
+package s3client
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestParseSSEMode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected SSEMode
+	}{
+		{"", SSENone},
+		{"s3", SSES3},
+		{"AES256", SSES3},
+		{"kms", SSEKMS},
+		{"aws:kms", SSEKMS},
+		{"c", SSEC},
+	}
+	for _, tt := range tests {
+		got, err := ParseSSEMode(tt.value)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.value, tt.expected, got)
+		}
+	}
+
+	if _, err := ParseSSEMode("rot13"); err == nil {
+		t.Error("Expected error for unknown SSE mode")
+	}
+}
+
+func TestSSEHeadersOnPut(t *testing.T) {
+	cfg, err := newSSEConfig(SSEOptions{Mode: SSES3})
+	if err != nil {
+		t.Fatalf("newSSEConfig failed: %v", err)
+	}
+	put := &s3.PutObjectInput{}
+	cfg.applyPut(put)
+	if put.ServerSideEncryption != types.ServerSideEncryptionAes256 {
+		t.Errorf("SSE-S3: expected AES256, got %q", put.ServerSideEncryption)
+	}
+	if put.SSECustomerKey != nil {
+		t.Error("SSE-S3 should not send a customer key")
+	}
+
+	cfg, err = newSSEConfig(SSEOptions{Mode: SSEKMS, KMSKeyID: "alias/s3fs"})
+	if err != nil {
+		t.Fatalf("newSSEConfig failed: %v", err)
+	}
+	put = &s3.PutObjectInput{}
+	cfg.applyPut(put)
+	if put.ServerSideEncryption != types.ServerSideEncryptionAwsKms {
+		t.Errorf("SSE-KMS: expected aws:kms, got %q", put.ServerSideEncryption)
+	}
+	if aws.ToString(put.SSEKMSKeyId) != "alias/s3fs" {
+		t.Errorf("SSE-KMS: expected key alias/s3fs, got %q", aws.ToString(put.SSEKMSKeyId))
+	}
+}
+
+func TestSSECustomerKeyOnCopy(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef"
+	sum := md5.Sum([]byte(key))
+	expectedKey := base64.StdEncoding.EncodeToString([]byte(key))
+	expectedMD5 := base64.StdEncoding.EncodeToString(sum[:])
+
+	// Raw and base64 forms of the same key are equivalent
+	for _, value := range []string{key, expectedKey} {
+		cfg, err := newSSEConfig(SSEOptions{Mode: SSEC, CustomerKey: value})
+		if err != nil {
+			t.Fatalf("newSSEConfig failed: %v", err)
+		}
+
+		copyInput := &s3.CopyObjectInput{}
+		cfg.applyCopy(copyInput)
+		if copyInput.ServerSideEncryption != "" {
+			t.Errorf("SSE-C should not set x-amz-server-side-encryption, got %q", copyInput.ServerSideEncryption)
+		}
+		if aws.ToString(copyInput.SSECustomerKey) != expectedKey || aws.ToString(copyInput.SSECustomerKeyMD5) != expectedMD5 {
+			t.Error("Copy destination is missing the SSE-C key headers")
+		}
+		if aws.ToString(copyInput.CopySourceSSECustomerKey) != expectedKey || aws.ToString(copyInput.CopySourceSSECustomerKeyMD5) != expectedMD5 {
+			t.Error("Copy source is missing the SSE-C key headers")
+		}
+		if aws.ToString(copyInput.CopySourceSSECustomerAlgorithm) != "AES256" {
+			t.Errorf("Expected source algorithm AES256, got %q", aws.ToString(copyInput.CopySourceSSECustomerAlgorithm))
+		}
+	}
+
+	if _, err := newSSEConfig(SSEOptions{Mode: SSEC, CustomerKey: "too-short"}); err == nil {
+		t.Error("Expected error for a customer key that is not 32 bytes")
+	}
+}
@@ -0,0 +1,44 @@
+package s3client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+)
+
+// ParseStorageClass parses a -storage_class flag value such as "STANDARD_IA"
+// An empty value means the bucket default (STANDARD)
+func ParseStorageClass(value string) (types.StorageClass, error) {
+	if value == "" {
+		return "", nil
+	}
+	class := types.StorageClass(strings.ToUpper(value))
+	for _, known := range class.Values() {
+		if class == known {
+			return class, nil
+		}
+	}
+	return "", fmt.Errorf("unknown storage class %q", value)
+}
+
+// SetStorageClass sets the storage class for objects created by uploads and copies
+// An empty class leaves new objects in the bucket default and copies in their source class
+func (c *Client) SetStorageClass(class types.StorageClass) {
+	c.storageClass = class
+}
+
+// archivedError translates a read of an archived object into EIO
+// GLACIER and DEEP_ARCHIVE objects must be restored before S3 will serve them,
+// which no retry can fix, so the reason is logged as a warning and the read fails cleanly
+func archivedError(key string, err error) error {
+	var invalidState *types.InvalidObjectState
+	if !errors.As(err, &invalidState) {
+		return nil
+	}
+	logging.Warn("object is archived and must be restored before it can be read", "key", key, "storage_class", invalidState.StorageClass)
+	return fmt.Errorf("object %s is archived in %s: %w", key, invalidState.StorageClass, syscall.EIO)
+}
@@ -0,0 +1,51 @@
+package s3client
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestParseStorageClass(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected types.StorageClass
+	}{
+		{"", ""},
+		{"STANDARD_IA", types.StorageClassStandardIa},
+		{"intelligent_tiering", types.StorageClassIntelligentTiering},
+		{"GLACIER_IR", types.StorageClassGlacierIr},
+	}
+	for _, tt := range tests {
+		got, err := ParseStorageClass(tt.value)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.value, tt.expected, got)
+		}
+	}
+
+	if _, err := ParseStorageClass("COLD_STORAGE"); err == nil {
+		t.Error("Expected error for unknown storage class")
+	}
+}
+
+func TestArchivedErrorIsEIO(t *testing.T) {
+	apiErr := &types.InvalidObjectState{StorageClass: types.StorageClassGlacier}
+	err := archivedError("archive/data.bin", fmt.Errorf("failed to get object: %w", apiErr))
+	if err == nil {
+		t.Fatal("Expected InvalidObjectState to be translated")
+	}
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("Expected EIO, got %v", err)
+	}
+
+	if archivedError("data.bin", errors.New("connection reset")) != nil {
+		t.Error("Unrelated errors should not be translated")
+	}
+}
@@ -0,0 +1,64 @@
+package s3client
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Connection pool defaults. The SDK keeps 10 idle connections per host, fewer
+// than a busy mount has requests in flight, so every connection past the tenth
+// was closed after its request and dialed (with a TLS handshake) again
+const (
+	DefaultMaxIdleConns        = 200
+	DefaultMaxIdleConnsPerHost = 100
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// TransportOptions tunes how the HTTP client reuses connections to S3
+type TransportOptions struct {
+	MaxIdleConns        int           // Idle connections kept open across all hosts (0 = no limit)
+	MaxIdleConnsPerHost int           // Idle connections kept open per host
+	IdleConnTimeout     time.Duration // How long an unused connection stays open (0 = no limit)
+}
+
+// DefaultTransportOptions returns the connection pool settings new clients use
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		MaxIdleConns:        DefaultMaxIdleConns,
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     DefaultIdleConnTimeout,
+	}
+}
+
+// newHTTPClient returns the SDK's HTTP client with the connection pool set
+// from opts, keeping its dial, TLS and redirect settings
+func newHTTPClient(opts TransportOptions) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.MaxIdleConns = opts.MaxIdleConns
+		tr.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+		tr.IdleConnTimeout = opts.IdleConnTimeout
+	})
+}
+
+// SetTransportOptions replaces the connection pool settings
+// Connections already open in the old pool are not reused
+func (c *Client) SetTransportOptions(opts TransportOptions) error {
+	if opts.MaxIdleConns < 0 || opts.MaxIdleConnsPerHost < 0 || opts.IdleConnTimeout < 0 {
+		return fmt.Errorf("connection pool settings must not be negative")
+	}
+	// net/http reads 0 idle connections per host as its own default of 2
+	if opts.MaxIdleConnsPerHost == 0 {
+		return fmt.Errorf("at least one idle connection per host must be kept")
+	}
+	if c.s3Client == nil {
+		return nil
+	}
+	c.s3Client = s3.New(c.s3Client.Options(), func(o *s3.Options) {
+		o.HTTPClient = newHTTPClient(opts)
+	})
+	return nil
+}
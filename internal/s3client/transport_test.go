@@ -0,0 +1,93 @@
+package s3client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countDials runs rounds of workers concurrent GetObject calls through a
+// client with the given pool settings and returns the connections it opened
+func countDials(tb testing.TB, opts TransportOptions, workers, rounds int) int64 {
+	tb.Helper()
+	var dials atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond) // Keep the requests of a round in flight together
+		w.Write([]byte("data"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dials.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewClientWithProvider("test-bucket", "us-east-1", server.URL, &rotatingProvider{})
+	if err := client.SetTransportOptions(opts); err != nil {
+		tb.Fatalf("SetTransportOptions failed: %v", err)
+	}
+	for i := 0; i < rounds; i++ {
+		var wg sync.WaitGroup
+		for j := 0; j < workers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := client.GetObject(context.Background(), "key"); err != nil {
+					tb.Errorf("GetObject failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	return dials.Load()
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	const workers, rounds = 40, 5
+
+	// The SDK's own limit keeps 10 of each round's connections
+	sdkDials := countDials(t, TransportOptions{MaxIdleConns: 100, MaxIdleConnsPerHost: 10}, workers, rounds)
+	dials := countDials(t, DefaultTransportOptions(), workers, rounds)
+	if dials > workers {
+		t.Errorf("Expected at most %d connections with the default pool, got %d", workers, dials)
+	}
+	if dials >= sdkDials {
+		t.Errorf("Expected fewer connections than the %d with 10 idle per host, got %d", sdkDials, dials)
+	}
+}
+
+func TestSetTransportOptionsRejectsInvalid(t *testing.T) {
+	client := NewClientWithProvider("test-bucket", "us-east-1", "http://localhost", &rotatingProvider{})
+	for _, opts := range []TransportOptions{
+		{MaxIdleConns: -1, MaxIdleConnsPerHost: 10},
+		{MaxIdleConnsPerHost: 0},
+		{MaxIdleConnsPerHost: 10, IdleConnTimeout: -time.Second},
+	} {
+		if err := client.SetTransportOptions(opts); err == nil {
+			t.Errorf("Expected an error for %+v", opts)
+		}
+	}
+}
+
+// BenchmarkConcurrentGets reports the connections dialed per round of 64
+// concurrent requests, with the SDK's pool size and the default one
+func BenchmarkConcurrentGets(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts TransportOptions
+	}{
+		{"sdk", TransportOptions{MaxIdleConns: 100, MaxIdleConnsPerHost: 10}},
+		{"default", DefaultTransportOptions()},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			dials := countDials(b, bc.opts, 64, b.N)
+			b.ReportMetric(float64(dials)/float64(b.N), "dials/op")
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package s3client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
+)
+
+// MultipartUpload is a multipart upload that was started and not yet
+// completed or aborted; its parts are stored, and billed, until one happens
+type MultipartUpload struct {
+	Key       string    `json:"key"`
+	UploadID  string    `json:"upload_id"`
+	Initiated time.Time `json:"initiated"`
+}
+
+// activeUploads tracks the multipart uploads a client is performing
+type activeUploads struct {
+	mu      sync.Mutex
+	uploads map[string]MultipartUpload // By upload ID
+}
+
+func (a *activeUploads) add(upload MultipartUpload) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.uploads == nil {
+		a.uploads = make(map[string]MultipartUpload)
+	}
+	a.uploads[upload.UploadID] = upload
+}
+
+func (a *activeUploads) remove(uploadID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.uploads, uploadID)
+}
+
+func (a *activeUploads) has(uploadID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.uploads[uploadID]
+	return ok
+}
+
+// ActiveUploads returns the multipart uploads this client has started and not
+// yet completed or aborted, oldest first. External cleanup jobs can match them
+// against ListMultipartUploads to leave uploads in progress alone
+func (c *Client) ActiveUploads() []MultipartUpload {
+	c.active.mu.Lock()
+	uploads := make([]MultipartUpload, 0, len(c.active.uploads))
+	for _, upload := range c.active.uploads {
+		uploads = append(uploads, upload)
+	}
+	c.active.mu.Unlock()
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+	return uploads
+}
+
+// ActiveUploadsHandler returns an http.Handler serving ActiveUploads as JSON
+func (c *Client) ActiveUploadsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.ActiveUploads())
+	})
+}
+
+// ListMultipartUploads lists the unfinished multipart uploads of keys under prefix
+func (c *Client) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list uploads", "prefix", prefix)
+	if c.s3Client == nil {
+		return nil, fmt.Errorf("S3 client not initialized")
+	}
+
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	}
+	uploads := []MultipartUpload{}
+	for {
+		var result *s3.ListMultipartUploadsOutput
+		err := c.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			result, err = c.s3Client.ListMultipartUploads(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+
+		for _, upload := range result.Uploads {
+			uploads = append(uploads, MultipartUpload{
+				Key:       aws.ToString(upload.Key),
+				UploadID:  aws.ToString(upload.UploadId),
+				Initiated: aws.ToTime(upload.Initiated),
+			})
+		}
+
+		if !aws.ToBool(result.IsTruncated) {
+			break
+		}
+		input.KeyMarker = result.NextKeyMarker
+		input.UploadIdMarker = result.NextUploadIdMarker
+	}
+	return uploads, nil
+}
+
+// AbortStaleUploads aborts the multipart uploads under prefix started more
+// than maxAge ago, left behind by a process that stopped between starting and
+// finishing them; this client's own uploads in progress are spared. It returns how many were aborted; failures to abort single
+// uploads are logged and left for the next run
+func (c *Client) AbortStaleUploads(ctx context.Context, prefix string, maxAge time.Duration) (int, error) {
+	uploads, err := c.ListMultipartUploads(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	aborted := 0
+	for _, upload := range uploads {
+		if upload.Initiated.IsZero() || upload.Initiated.After(cutoff) || c.active.has(upload.UploadID) {
+			continue
+		}
+		if err := c.AbortMultipartUpload(ctx, upload.Key, upload.UploadID); err != nil {
+			logging.Warn("failed to abort stale multipart upload", "key", upload.Key, "upload_id", upload.UploadID, "err", err)
+			continue
+		}
+		logging.Info("aborted stale multipart upload", "key", upload.Key, "upload_id", upload.UploadID, "initiated", upload.Initiated)
+		aborted++
+	}
+	return aborted, nil
+}
+
+// StartUploadReaper aborts stale multipart uploads under prefix (see
+// AbortStaleUploads) now and then periodically, every maxAge but at least
+// hourly, until ctx is done
+func (c *Client) StartUploadReaper(ctx context.Context, prefix string, maxAge time.Duration) {
+	interval := min(maxAge, time.Hour)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := c.AbortStaleUploads(ctx, prefix, maxAge); err != nil {
+				logging.Warn("failed to clean up stale multipart uploads", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,209 @@
+package s3client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// uploadServer keeps track of unfinished multipart uploads. Parts are refused
+// while failParts is set
+type uploadServer struct {
+	mu        sync.Mutex
+	uploads   map[string]MultipartUpload
+	next      int
+	failParts bool
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	io.Copy(io.Discard, r.Body)
+	key := strings.TrimPrefix(r.URL.Path, "/test-bucket/")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.next++
+		id := fmt.Sprintf("upload-%d", s.next)
+		s.uploads[id] = MultipartUpload{Key: key, UploadID: id, Initiated: time.Now()}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>test-bucket</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"done-1"</ETag></CompleteMultipartUploadResult>`))
+	case r.Method == http.MethodGet && query.Has("uploads"):
+		var b strings.Builder
+		b.WriteString(`<ListMultipartUploadsResult><Bucket>test-bucket</Bucket><IsTruncated>false</IsTruncated>`)
+		for _, upload := range s.uploads {
+			if strings.HasPrefix(upload.Key, query.Get("prefix")) {
+				fmt.Fprintf(&b, `<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>`,
+					upload.Key, upload.UploadID, upload.Initiated.UTC().Format("2006-01-02T15:04:05.000Z"))
+			}
+		}
+		b.WriteString(`</ListMultipartUploadsResult>`)
+		w.Write([]byte(b.String()))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		if s.failParts {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		w.Header().Set("ETag", `"part"`)
+	}
+}
+
+// age makes the upload of key look as if it was started d ago
+func (s *uploadServer) age(key string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, upload := range s.uploads {
+		if upload.Key == key {
+			upload.Initiated = upload.Initiated.Add(-d)
+			s.uploads[id] = upload
+		}
+	}
+}
+
+func (s *uploadServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func newUploadClient(t *testing.T, server *uploadServer) *Client {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", httpServer.URL, provider)
+	client.SetRetryPolicy(RetryPolicy{})
+	return client
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	server := &uploadServer{uploads: map[string]MultipartUpload{}}
+	client := newUploadClient(t, server)
+	ctx := context.Background()
+
+	// Two uploads whose writers crashed before completing them, one of them
+	// long ago, and one outside the mounted prefix
+	crashed := newUploadClient(t, server)
+	for _, key := range []string{"mnt/crashed.bin", "mnt/recent.bin", "other/crashed.bin"} {
+		if _, err := crashed.CreateMultipartUpload(ctx, key); err != nil {
+			t.Fatalf("CreateMultipartUpload(%s) failed: %v", key, err)
+		}
+	}
+	server.age("mnt/crashed.bin", 2*time.Hour)
+	server.age("other/crashed.bin", 2*time.Hour)
+
+	uploads, err := client.ListMultipartUploads(ctx, "mnt/")
+	if err != nil || len(uploads) != 2 {
+		t.Fatalf("ListMultipartUploads = %v, %v; want 2 uploads", uploads, err)
+	}
+
+	aborted, err := client.AbortStaleUploads(ctx, "mnt/", time.Hour)
+	if err != nil || aborted != 1 {
+		t.Fatalf("AbortStaleUploads = %d, %v; want 1", aborted, err)
+	}
+	uploads, _ = client.ListMultipartUploads(ctx, "")
+	if len(uploads) != 2 {
+		t.Fatalf("Uploads left = %v, want the recent and the other prefix's", uploads)
+	}
+	for _, upload := range uploads {
+		if upload.Key == "mnt/crashed.bin" {
+			t.Errorf("Stale upload %s was not aborted", upload.UploadID)
+		}
+	}
+}
+
+func TestUploadReaper(t *testing.T) {
+	server := &uploadServer{uploads: map[string]MultipartUpload{}}
+	client := newUploadClient(t, server)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	crashed := newUploadClient(t, server)
+	if _, err := crashed.CreateMultipartUpload(ctx, "crashed.bin"); err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	server.age("crashed.bin", time.Minute)
+
+	client.StartUploadReaper(ctx, "", time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for server.count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Reaper did not abort the stale upload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFailedMultipartUploadIsAborted(t *testing.T) {
+	server := &uploadServer{uploads: map[string]MultipartUpload{}, failParts: true}
+	client := newUploadClient(t, server)
+	ctx := context.Background()
+
+	if err := client.PutObjectWithMetadata(ctx, "big.bin", make([]byte, MinMultipartSize), nil); err == nil {
+		t.Fatal("PutObjectWithMetadata succeeded with parts refused")
+	}
+	if n := server.count(); n != 0 {
+		t.Errorf("%d uploads left after a failed upload, want 0", n)
+	}
+	if active := client.ActiveUploads(); len(active) != 0 {
+		t.Errorf("ActiveUploads after a failed upload = %v, want none", active)
+	}
+}
+
+func TestActiveUploads(t *testing.T) {
+	server := &uploadServer{uploads: map[string]MultipartUpload{}}
+	client := newUploadClient(t, server)
+	ctx := context.Background()
+
+	uploadID, err := client.CreateMultipartUpload(ctx, "in-progress.bin")
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	active := client.ActiveUploads()
+	if len(active) != 1 || active[0].UploadID != uploadID || active[0].Key != "in-progress.bin" {
+		t.Fatalf("ActiveUploads = %v, want %s", active, uploadID)
+	}
+
+	// Served as JSON for cleanup tools
+	recorder := httptest.NewRecorder()
+	client.ActiveUploadsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/uploads", nil))
+	var served []MultipartUpload
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil || len(served) != 1 || served[0].UploadID != uploadID {
+		t.Errorf("Handler served %s (%v), want %s", recorder.Body.String(), err, uploadID)
+	}
+
+	// Old as it may be, the reaper leaves an upload in progress alone
+	server.age("in-progress.bin", 2*time.Hour)
+	if aborted, err := client.AbortStaleUploads(ctx, "", time.Hour); err != nil || aborted != 0 {
+		t.Errorf("AbortStaleUploads = %d, %v; want the active upload spared", aborted, err)
+	}
+
+	part, err := client.UploadPart(ctx, "in-progress.bin", uploadID, 1, []byte("data"))
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	parts := []types.CompletedPart{{ETag: aws.String(part), PartNumber: aws.Int32(1)}}
+	if err := client.CompleteMultipartUpload(ctx, "in-progress.bin", uploadID, parts); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	if active := client.ActiveUploads(); len(active) != 0 {
+		t.Errorf("ActiveUploads after completion = %v, want none", active)
+	}
+}
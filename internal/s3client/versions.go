@@ -0,0 +1,272 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/metrics"
+)
+
+// ListOptions controls which keys ListObjects returns on versioned buckets
+// The zero value lists current versions only and hides delete-markered keys
+type ListOptions struct {
+	IncludeNonCurrent bool // Also list keys that only have non-current versions left
+	ShowDeleteMarkers bool // List keys whose current version is a delete marker
+}
+
+// versionAware reports whether listing needs ListObjectVersions
+// The default view matches plain ListObjectsV2, which is cheaper
+func (o ListOptions) versionAware() bool {
+	return o.IncludeNonCurrent || o.ShowDeleteMarkers
+}
+
+// SetListOptions sets the version filtering used by ListObjects
+func (c *Client) SetListOptions(options ListOptions) {
+	c.listOptions = options
+}
+
+// ObjectVersion is a single entry of a ListObjectVersions response: a version
+// of a key or a delete marker
+type ObjectVersion struct {
+	Key          string
+	VersionID    string
+	LastModified time.Time
+	Size         int64 // 0 for delete markers
+	IsLatest     bool
+	DeleteMarker bool
+}
+
+// filterVersions reduces a version listing to the keys visible under options
+func filterVersions(versions []ObjectVersion, options ListOptions) []string {
+	type keyState struct {
+		current       bool // Latest version is a real object
+		deleted       bool // Latest version is a delete marker
+		hasNonCurrent bool // At least one older real version exists
+	}
+
+	states := make(map[string]*keyState)
+	for _, v := range versions {
+		state, ok := states[v.Key]
+		if !ok {
+			state = &keyState{}
+			states[v.Key] = state
+		}
+		switch {
+		case v.IsLatest && v.DeleteMarker:
+			state.deleted = true
+		case v.IsLatest:
+			state.current = true
+		case !v.DeleteMarker:
+			state.hasNonCurrent = true
+		}
+	}
+
+	keys := make([]string, 0, len(states))
+	for key, state := range states {
+		visible := state.current
+		if state.deleted && options.ShowDeleteMarkers {
+			visible = true
+		}
+		if !state.current && !state.deleted && state.hasNonCurrent && options.IncludeNonCurrent {
+			visible = true
+		}
+		if visible {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ListObjectVersions returns every version and delete marker of the keys under prefix
+func (c *Client) ListObjectVersions(ctx context.Context, prefix string) ([]ObjectVersion, error) {
+	defer metrics.StartOp(metrics.OpList)()
+	logging.Debug("s3 list versions", "prefix", prefix)
+	if c.s3Client == nil {
+		return nil, fmt.Errorf("S3 client not initialized")
+	}
+	versions, _, err := c.listVersions(ctx, prefix, "")
+	return versions, err
+}
+
+// listsVersions reports whether listings need ListObjectVersions
+func (c *Client) listsVersions() bool {
+	return c.listOptions.versionAware() || c.snapshot.pinned()
+}
+
+// listObjectVersions lists keys under prefix using ListObjectVersions, as of the
+// snapshot time if there is one, otherwise filtered by the list options
+func (c *Client) listObjectVersions(ctx context.Context, prefix string) ([]string, error) {
+	versions, _, err := c.listVersions(ctx, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.visibleKeys(versions), nil
+}
+
+// visibleKeys reduces a version listing to the keys that had an object at the
+// snapshot time if there is one, otherwise to those the list options show
+func (c *Client) visibleKeys(versions []ObjectVersion) []string {
+	if c.snapshot.pinned() {
+		return c.snapshot.keys(versions)
+	}
+	return filterVersions(versions, c.listOptions)
+}
+
+// listVersions runs ListObjectVersions under prefix, following every page
+// With a delimiter, keys below the first delimiter after prefix are returned
+// as common prefixes instead of versions
+func (c *Client) listVersions(ctx context.Context, prefix, delimiter string) ([]ObjectVersion, []string, error) {
+	var versions []ObjectVersion
+	prefixes := []string{}
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	for {
+		var result *s3.ListObjectVersionsOutput
+		err := c.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			result, err = c.s3Client.ListObjectVersions(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list object versions: %w", err)
+		}
+
+		for _, v := range result.Versions {
+			if v.Key != nil {
+				versions = append(versions, ObjectVersion{
+					Key:          *v.Key,
+					VersionID:    aws.ToString(v.VersionId),
+					LastModified: aws.ToTime(v.LastModified),
+					Size:         aws.ToInt64(v.Size),
+					IsLatest:     aws.ToBool(v.IsLatest),
+				})
+			}
+		}
+		for _, m := range result.DeleteMarkers {
+			if m.Key != nil {
+				versions = append(versions, ObjectVersion{
+					Key:          *m.Key,
+					VersionID:    aws.ToString(m.VersionId),
+					LastModified: aws.ToTime(m.LastModified),
+					IsLatest:     aws.ToBool(m.IsLatest),
+					DeleteMarker: true,
+				})
+			}
+		}
+		for _, common := range result.CommonPrefixes {
+			if common.Prefix != nil {
+				prefixes = append(prefixes, *common.Prefix)
+			}
+		}
+
+		if !aws.ToBool(result.IsTruncated) {
+			break
+		}
+		input.KeyMarker = result.NextKeyMarker
+		input.VersionIdMarker = result.NextVersionIdMarker
+	}
+
+	return versions, prefixes, nil
+}
+
+// GetObjectVersion downloads the given version of key, current or not
+func (c *Client) GetObjectVersion(ctx context.Context, key, versionID string) ([]byte, error) {
+	defer metrics.StartOp(metrics.OpGet)()
+	logging.Debug("s3 get version", "key", key, "version", versionID)
+	if c.s3Client == nil {
+		return nil, fmt.Errorf("S3 client not initialized")
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket:    aws.String(c.bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	}
+	c.sse.applyGet(input)
+	data, _, err := c.getObject(ctx, key, input)
+	return data, err
+}
+
+// RestoreObjectVersion makes the given version of key its current one again,
+// by copying it, content and metadata, onto key. The versions in between are
+// kept, so the restore can itself be undone. Like any single copy it is limited
+// to versions of up to MaxCopyObjectSize
+func (c *Client) RestoreObjectVersion(ctx context.Context, key, versionID string) error {
+	defer metrics.StartOp(metrics.OpCopy)()
+	logging.Debug("s3 restore version", "key", key, "version", versionID)
+	if c.s3Client == nil {
+		return fmt.Errorf("S3 client not initialized")
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(c.bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(fmt.Sprintf("%s/%s?versionId=%s", c.bucket, key, url.QueryEscape(versionID))),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		StorageClass:      c.storageClass,
+	}
+	c.sse.applyCopy(input)
+
+	err := c.retry.multipart().do(ctx, func(ctx context.Context) error {
+		_, err := c.s3Client.CopyObject(ctx, input)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore version %s of %s: %w", versionID, key, err)
+	}
+	return nil
+}
+
+// EnableVersioning turns on versioning for the bucket
+func (c *Client) EnableVersioning(ctx context.Context) error {
+	if c.s3Client == nil {
+		return fmt.Errorf("S3 client not initialized")
+	}
+
+	input := &s3.PutBucketVersioningInput{
+		Bucket: aws.String(c.bucket),
+		VersioningConfiguration: &types.VersioningConfiguration{
+			Status: types.BucketVersioningStatusEnabled,
+		},
+	}
+
+	_, err := c.s3Client.PutBucketVersioning(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to enable bucket versioning: %w", err)
+	}
+
+	return nil
+}
+
+// Versioned reports whether the bucket keeps object versions: versioning is
+// enabled, or was and is now suspended
+func (c *Client) Versioned(ctx context.Context) (bool, error) {
+	if c.s3Client == nil {
+		return false, fmt.Errorf("S3 client not initialized")
+	}
+
+	var result *s3.GetBucketVersioningOutput
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.s3Client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(c.bucket)})
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get bucket versioning: %w", err)
+	}
+	return result.Status != "", nil
+}
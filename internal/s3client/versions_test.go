@@ -0,0 +1,281 @@
+package s3client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestFilterVersions(t *testing.T) {
+	versions := []ObjectVersion{
+		// Plain current object with an older version
+		{Key: "live.txt", IsLatest: true},
+		{Key: "live.txt", IsLatest: false},
+		// Deleted: delete marker on top of an older version
+		{Key: "deleted.txt", IsLatest: true, DeleteMarker: true},
+		{Key: "deleted.txt", IsLatest: false},
+		// Only a non-current version left (latest version permanently removed)
+		{Key: "orphan.txt", IsLatest: false},
+	}
+
+	tests := []struct {
+		name     string
+		options  ListOptions
+		expected []string
+	}{
+		{"default", ListOptions{}, []string{"live.txt"}},
+		{"show delete markers", ListOptions{ShowDeleteMarkers: true}, []string{"deleted.txt", "live.txt"}},
+		{"include non-current", ListOptions{IncludeNonCurrent: true}, []string{"live.txt", "orphan.txt"}},
+		{"all", ListOptions{IncludeNonCurrent: true, ShowDeleteMarkers: true}, []string{"deleted.txt", "live.txt", "orphan.txt"}},
+	}
+
+	for _, tt := range tests {
+		got := filterVersions(versions, tt.options)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// storedVersion is a version or delete marker held by versionServer
+type storedVersion struct {
+	key, id  string
+	modified time.Time
+	data     string
+	deleted  bool
+}
+
+// versionServer serves a versioned bucket: ListObjectVersions, HEAD and GET of
+// a key's latest version or of a given versionId, and copies of a version onto
+// its key
+type versionServer struct {
+	mu        sync.Mutex
+	versions  []storedVersion // Newest first for each key
+	listCalls int
+	listed    []string // Keys of the versions each listing returned
+	requested []string // versionId of each HEAD and GET ("" = latest)
+}
+
+func (s *versionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	key := strings.TrimPrefix(r.URL.Path, "/test-bucket/")
+	switch {
+	case query.Has("versioning"):
+		w.Write([]byte(`<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`))
+	case query.Has("versions"):
+		s.listCalls++
+		var b strings.Builder
+		b.WriteString(`<ListVersionsResult><Name>test-bucket</Name><IsTruncated>false</IsTruncated>`)
+		latest := map[string]bool{}
+		common := map[string]bool{}
+		for _, v := range s.versions {
+			if !strings.HasPrefix(v.key, query.Get("prefix")) {
+				continue
+			}
+			rest := strings.TrimPrefix(v.key, query.Get("prefix"))
+			if i := strings.Index(rest, query.Get("delimiter")); query.Get("delimiter") != "" && i >= 0 {
+				dir := query.Get("prefix") + rest[:i+1]
+				if !common[dir] {
+					common[dir] = true
+					fmt.Fprintf(&b, `<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>`, dir)
+				}
+				continue
+			}
+			s.listed = append(s.listed, v.key)
+			element := "Version"
+			if v.deleted {
+				element = "DeleteMarker"
+			}
+			fmt.Fprintf(&b, `<%s><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%v</IsLatest><LastModified>%s</LastModified><Size>%d</Size></%s>`,
+				element, v.key, v.id, !latest[v.key], v.modified.UTC().Format("2006-01-02T15:04:05.000Z"), len(v.data), element)
+			latest[v.key] = true
+		}
+		b.WriteString(`</ListVersionsResult>`)
+		w.Write([]byte(b.String()))
+	case r.Header.Get("X-Amz-Copy-Source") != "":
+		source, err := url.Parse("/" + r.Header.Get("X-Amz-Copy-Source"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id := source.Query().Get("versionId")
+		for _, v := range s.versions {
+			if "/test-bucket/"+v.key == source.Path && v.id == id && !v.deleted {
+				copied := storedVersion{key: key, id: fmt.Sprintf("copy%d", len(s.versions)), modified: time.Now(), data: v.data}
+				s.versions = append([]storedVersion{copied}, s.versions...)
+				w.Write([]byte(`<CopyObjectResult><ETag>"` + copied.id + `"</ETag></CopyObjectResult>`))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		id := query.Get("versionId")
+		s.requested = append(s.requested, id)
+		for _, v := range s.versions {
+			if v.key != key || (id != "" && v.id != id) {
+				continue
+			}
+			if v.deleted {
+				break
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(len(v.data)))
+			w.Header().Set("Last-Modified", v.modified.UTC().Format(http.TimeFormat))
+			w.Header().Set("ETag", `"`+v.id+`"`)
+			if r.Method == http.MethodGet {
+				w.Write([]byte(v.data))
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSnapshotTime(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := &versionServer{versions: []storedVersion{
+		{key: "a.txt", id: "a2", modified: base.Add(3 * time.Hour), data: "new"},
+		{key: "a.txt", id: "a1", modified: base.Add(time.Hour), data: "old"},
+		{key: "b.txt", id: "b2", modified: base.Add(2 * time.Hour), deleted: true},
+		{key: "b.txt", id: "b1", modified: base.Add(time.Hour), data: "gone"},
+		{key: "c.txt", id: "c1", modified: base.Add(3 * time.Hour), data: "later"},
+		{key: "dir/d.txt", id: "d1", modified: base.Add(time.Hour), data: "deep"},
+	}}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", httpServer.URL, provider)
+	client.SetRetryPolicy(RetryPolicy{})
+	ctx := context.Background()
+
+	if versioned, err := client.Versioned(ctx); err != nil || !versioned {
+		t.Fatalf("Versioned = %v, %v; want true", versioned, err)
+	}
+
+	// Reads resolve the version current at the pin
+	client.SetSnapshotTime(base.Add(150 * time.Minute))
+	if data, err := client.GetObject(ctx, "dir/d.txt"); err != nil || string(data) != "deep" {
+		t.Errorf("GetObject = %q, %v; want %q", data, err, "deep")
+	}
+	if data, err := client.GetObject(ctx, "a.txt"); err != nil || string(data) != "old" {
+		t.Errorf("GetObject = %q, %v; want the version before the pin", data, err)
+	}
+	if info, err := client.HeadObjectFull(ctx, "a.txt"); err != nil || info.Size != 3 || info.ETag != `"a1"` {
+		t.Errorf("HeadObjectFull = %+v, %v; want version a1", info, err)
+	}
+	for _, key := range []string{"b.txt", "c.txt"} {
+		if _, err := client.HeadObjectFull(ctx, key); !errors.Is(err, ErrNotInSnapshot) || !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("HeadObjectFull(%s) = %v, want ErrNotInSnapshot", key, err)
+		}
+	}
+	if want := []string{"d1", "a1", "a1"}; !reflect.DeepEqual(server.requested, want) {
+		t.Errorf("Requested versions %v, want %v", server.requested, want)
+	}
+
+	// Listings show the keys that had an object at the pin
+	if keys, err := client.ListObjects(ctx, ""); err != nil || !reflect.DeepEqual(keys, []string{"a.txt", "dir/d.txt"}) {
+		t.Errorf("ListObjects = %v, %v", keys, err)
+	}
+	server.listed = nil
+	keys, prefixes, err := client.ListDelimited(ctx, "", "/")
+	if err != nil || !reflect.DeepEqual(keys, []string{"a.txt"}) || !reflect.DeepEqual(prefixes, []string{"dir/"}) {
+		t.Errorf("ListDelimited = %v, %v, %v", keys, prefixes, err)
+	}
+	for _, key := range server.listed {
+		if strings.Contains(key, "/") {
+			t.Errorf("ListDelimited fetched the versions of %s, below the listed directory", key)
+		}
+	}
+
+	// Resolved versions are remembered
+	listCalls := server.listCalls
+	client.GetObject(ctx, "a.txt")
+	client.HeadObjectFull(ctx, "c.txt")
+	if server.listCalls != listCalls {
+		t.Errorf("Reads of resolved keys listed versions %d more times", server.listCalls-listCalls)
+	}
+
+	// A full cache forgets keys to make room, and resolves them again
+	client.snapshot.limit = 2
+	client.snapshot.versions = map[string]string{}
+	for _, key := range []string{"a.txt", "b.txt", "dir/d.txt"} {
+		client.HeadObjectFull(ctx, key)
+	}
+	if n := len(client.snapshot.versions); n > 2 {
+		t.Errorf("Snapshot remembers %d keys, want at most 2", n)
+	}
+	if data, err := client.GetObject(ctx, "a.txt"); err != nil || string(data) != "old" {
+		t.Errorf("GetObject with a full cache = %q, %v; want %q", data, err, "old")
+	}
+
+	// Unpinned, reads get the latest version
+	client.SetSnapshotTime(time.Time{})
+	if data, err := client.GetObject(ctx, "a.txt"); err != nil || string(data) != "new" {
+		t.Errorf("GetObject after unpinning = %q, %v; want %q", data, err, "new")
+	}
+}
+
+func TestObjectVersions(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := &versionServer{versions: []storedVersion{
+		{key: "notes.txt", id: "n3", modified: base.Add(2 * time.Hour), deleted: true},
+		{key: "notes.txt", id: "n2", modified: base.Add(time.Hour), data: "second"},
+		{key: "notes.txt", id: "n1", modified: base, data: "first"},
+	}}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "TEST", SecretAccessKey: "TEST"}, nil
+	})
+	client := NewClientWithProvider("test-bucket", "us-east-1", httpServer.URL, provider)
+	client.SetRetryPolicy(RetryPolicy{})
+	ctx := context.Background()
+
+	versions, err := client.ListObjectVersions(ctx, "notes.txt")
+	if err != nil {
+		t.Fatalf("ListObjectVersions failed: %v", err)
+	}
+	sizes := map[string]int64{}
+	for _, v := range versions {
+		sizes[v.VersionID] = v.Size
+	}
+	if want := map[string]int64{"n1": 5, "n2": 6, "n3": 0}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Version sizes = %v, want %v", sizes, want)
+	}
+
+	// Any version can be read, even with a delete marker on top
+	if data, err := client.GetObjectVersion(ctx, "notes.txt", "n1"); err != nil || string(data) != "first" {
+		t.Errorf("GetObjectVersion = %q, %v; want %q", data, err, "first")
+	}
+	if _, err := client.GetObject(ctx, "notes.txt"); err == nil {
+		t.Error("GetObject of a deleted key succeeded")
+	}
+
+	// Restoring copies the version back on top
+	if err := client.RestoreObjectVersion(ctx, "notes.txt", "n1"); err != nil {
+		t.Fatalf("RestoreObjectVersion failed: %v", err)
+	}
+	if data, err := client.GetObject(ctx, "notes.txt"); err != nil || string(data) != "first" {
+		t.Errorf("GetObject after restore = %q, %v; want %q", data, err, "first")
+	}
+	if versions, _ := client.ListObjectVersions(ctx, "notes.txt"); len(versions) != 4 {
+		t.Errorf("Restore left %d versions, want the 3 before it and the restored one", len(versions))
+	}
+	if err := client.RestoreObjectVersion(ctx, "notes.txt", "missing"); err == nil {
+		t.Error("Restoring a missing version succeeded")
+	}
+}
@@ -0,0 +1,15 @@
+package s3client
+
+import (
+	"context"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// reportWritten passes the ETag S3 returned for a PUT or a completed multipart
+// upload on to a caller that asked for it with types.WithWritten
+func reportWritten(ctx context.Context, etag *string) {
+	if etag != nil {
+		types.ReportWritten(ctx, quoteETag(*etag))
+	}
+}
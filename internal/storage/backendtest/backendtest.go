@@ -0,0 +1,298 @@
+// Package backendtest provides a test suite that any types.Backend
+// implementation can run to check it behaves the way the filesystem expects
+package backendtest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// Run exercises backend through the types.Backend interface, plus the optional
+// capability interfaces it implements. newBackend must return an empty backend
+func Run(t *testing.T, newBackend func(t *testing.T) types.Backend) {
+	ctx := context.Background()
+
+	t.Run("WriteRead", func(t *testing.T) {
+		b := newBackend(t)
+		if err := b.Write(ctx, "dir/file.txt", []byte("hello")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		data, err := b.Read(ctx, "dir/file.txt")
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(data) != "hello" {
+			t.Errorf("Read = %q, want %q", data, "hello")
+		}
+
+		if err := b.Write(ctx, "dir/file.txt", []byte("bye")); err != nil {
+			t.Fatalf("Overwrite failed: %v", err)
+		}
+		data, _ = b.Read(ctx, "dir/file.txt")
+		if string(data) != "bye" {
+			t.Errorf("Read after overwrite = %q, want %q", data, "bye")
+		}
+
+		if _, err := b.Read(ctx, "missing.txt"); err == nil {
+			t.Error("Read of missing file should fail")
+		}
+	})
+
+	t.Run("ReadRange", func(t *testing.T) {
+		b := newBackend(t)
+		if err := b.Write(ctx, "range.txt", []byte("0123456789")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		tests := []struct {
+			start, end int64
+			want       string
+		}{
+			{0, 3, "0123"},
+			{4, 4, "4"},
+			{8, 20, "89"}, // End past EOF is clamped
+		}
+		for _, tt := range tests {
+			data, err := b.ReadRange(ctx, "range.txt", tt.start, tt.end)
+			if err != nil {
+				t.Errorf("ReadRange(%d, %d) failed: %v", tt.start, tt.end, err)
+				continue
+			}
+			if string(data) != tt.want {
+				t.Errorf("ReadRange(%d, %d) = %q, want %q", tt.start, tt.end, data, tt.want)
+			}
+		}
+	})
+
+	t.Run("Attributes", func(t *testing.T) {
+		b := newBackend(t)
+		metadata := map[string]string{
+			"mode":  "600",
+			"uid":   "1234",
+			"gid":   "5678",
+			"mtime": "1700000000",
+		}
+		if err := b.WriteWithMetadata(ctx, "attr.txt", []byte("abc"), metadata); err != nil {
+			t.Fatalf("WriteWithMetadata failed: %v", err)
+		}
+		attr, err := b.GetAttr(ctx, "attr.txt")
+		if err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+		if attr.Size != 3 || attr.Mode != 0600 || attr.DefaultMode {
+			t.Errorf("GetAttr size=%d mode=%o default=%v, want 3, 600, false", attr.Size, attr.Mode, attr.DefaultMode)
+		}
+		if attr.Uid != 1234 || attr.Gid != 5678 {
+			t.Errorf("GetAttr uid=%d gid=%d, want 1234, 5678", attr.Uid, attr.Gid)
+		}
+		if attr.Mtime.Unix() != 1700000000 {
+			t.Errorf("GetAttr mtime = %d, want 1700000000", attr.Mtime.Unix())
+		}
+
+		if err := b.Write(ctx, "plain.txt", []byte("x")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		attr, err = b.GetAttr(ctx, "plain.txt")
+		if err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+		if !attr.DefaultMode {
+			t.Error("GetAttr of a file written without metadata should report DefaultMode")
+		}
+
+		if _, err := b.GetAttr(ctx, "missing.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("GetAttr of missing file: got %v, want os.ErrNotExist", err)
+		}
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		b := newBackend(t)
+		metadata := map[string]string{"mode": "644", "xattr-user.color": "blue"}
+		if err := b.WriteWithMetadata(ctx, "meta.txt", []byte("abc"), metadata); err != nil {
+			t.Fatalf("WriteWithMetadata failed: %v", err)
+		}
+		got, err := b.GetMetadata(ctx, "meta.txt")
+		if err != nil {
+			t.Fatalf("GetMetadata failed: %v", err)
+		}
+		if got["xattr-user.color"] != "blue" {
+			t.Errorf("GetMetadata xattr = %q, want %q", got["xattr-user.color"], "blue")
+		}
+
+		updater, ok := b.(types.MetadataUpdater)
+		if !ok {
+			return
+		}
+		if err := updater.UpdateMetadata(ctx, "meta.txt", map[string]string{"mode": "600"}); err != nil {
+			t.Fatalf("UpdateMetadata failed: %v", err)
+		}
+		got, _ = b.GetMetadata(ctx, "meta.txt")
+		if _, ok := got["xattr-user.color"]; ok {
+			t.Error("UpdateMetadata should replace the metadata, but the xattr survived")
+		}
+		data, _ := b.Read(ctx, "meta.txt")
+		if string(data) != "abc" {
+			t.Errorf("Read after UpdateMetadata = %q, want %q", data, "abc")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		b := newBackend(t)
+		for _, key := range []string{"a/1.txt", "a/b/2.txt", "a.txt", "c/3.txt"} {
+			if err := b.Write(ctx, key, []byte(key)); err != nil {
+				t.Fatalf("Write %s failed: %v", key, err)
+			}
+		}
+		keys, err := b.List(ctx, "a/")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		sort.Strings(keys)
+		if got := strings.Join(keys, ","); got != "a/1.txt,a/b/2.txt" {
+			t.Errorf("List(a/) = %s, want a/1.txt,a/b/2.txt", got)
+		}
+
+		keys, _ = b.List(ctx, "a")
+		if len(keys) != 3 {
+			t.Errorf("List(a) returned %d keys, want 3: %v", len(keys), keys)
+		}
+		keys, _ = b.List(ctx, "missing/")
+		if len(keys) != 0 {
+			t.Errorf("List(missing/) = %v, want nothing", keys)
+		}
+
+		if limited, ok := b.(types.LimitedLister); ok {
+			keys, err := limited.ListLimited(ctx, "a/", 1)
+			if err != nil {
+				t.Fatalf("ListLimited failed: %v", err)
+			}
+			if len(keys) != 1 || !strings.HasPrefix(keys[0], "a/") {
+				t.Errorf("ListLimited(a/, 1) = %v, want one key under a/", keys)
+			}
+			if keys, _ := limited.ListLimited(ctx, "missing/", 1); len(keys) != 0 {
+				t.Errorf("ListLimited(missing/, 1) = %v, want nothing", keys)
+			}
+		}
+
+		if pager, ok := b.(types.PageLister); ok {
+			var paged []string
+			err := types.ListFunc(ctx, b, "a/", 1, func(keys []string) error {
+				if len(keys) > 1 {
+					t.Errorf("ListPage returned %d keys, want at most 1", len(keys))
+				}
+				paged = append(paged, keys...)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ListPage failed: %v", err)
+			}
+			if got := strings.Join(paged, ","); got != "a/1.txt,a/b/2.txt" {
+				t.Errorf("ListPage(a/) pages = %s, want a/1.txt,a/b/2.txt", got)
+			}
+			if keys, next, _ := pager.ListPage(ctx, "missing/", "", 10); len(keys) != 0 || next != "" {
+				t.Errorf("ListPage(missing/) = %v, %q; want nothing", keys, next)
+			}
+		}
+
+		lister, ok := b.(types.DelimitedLister)
+		if !ok {
+			return
+		}
+		files, prefixes, err := lister.ListDelimited(ctx, "a/", "/")
+		if err != nil {
+			t.Fatalf("ListDelimited failed: %v", err)
+		}
+		sort.Strings(files)
+		sort.Strings(prefixes)
+		if strings.Join(files, ",") != "a/1.txt" || strings.Join(prefixes, ",") != "a/b/" {
+			t.Errorf("ListDelimited(a/) = %v %v, want [a/1.txt] [a/b/]", files, prefixes)
+		}
+	})
+
+	t.Run("DirectoryMarker", func(t *testing.T) {
+		b := newBackend(t)
+		if err := b.Write(ctx, "empty/", nil); err != nil {
+			t.Fatalf("Write of directory marker failed: %v", err)
+		}
+		keys, err := b.List(ctx, "empty/")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if strings.Join(keys, ",") != "empty/" {
+			t.Errorf("List(empty/) = %v, want [empty/]", keys)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		b := newBackend(t)
+		for _, key := range []string{"del/1.txt", "del/2.txt", "keep.txt"} {
+			if err := b.Write(ctx, key, []byte(key)); err != nil {
+				t.Fatalf("Write %s failed: %v", key, err)
+			}
+		}
+		if err := b.Delete(ctx, "keep.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if exists, _ := b.Exists(ctx, "keep.txt"); exists {
+			t.Error("File still exists after Delete")
+		}
+
+		if err := b.DeleteMany(ctx, []string{"del/1.txt", "del/2.txt", "del/missing.txt"}); err != nil {
+			t.Fatalf("DeleteMany failed: %v", err)
+		}
+		keys, _ := b.List(ctx, "")
+		if len(keys) != 0 {
+			t.Errorf("List after deleting everything = %v, want nothing", keys)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		b := newBackend(t)
+		if err := b.WriteWithMetadata(ctx, "old.txt", []byte("data"), map[string]string{"mode": "600"}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := b.Write(ctx, "new.txt", []byte("replaced")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := b.Rename(ctx, "old.txt", "new.txt"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		if exists, _ := b.Exists(ctx, "old.txt"); exists {
+			t.Error("Source still exists after Rename")
+		}
+		data, _ := b.Read(ctx, "new.txt")
+		if string(data) != "data" {
+			t.Errorf("Read after Rename = %q, want %q", data, "data")
+		}
+		attr, err := b.GetAttr(ctx, "new.txt")
+		if err != nil || attr.Mode != 0600 {
+			t.Errorf("GetAttr after Rename = %+v, %v; want mode 600", attr, err)
+		}
+
+		renamer, ok := b.(types.PrefixRenamer)
+		if !ok {
+			return
+		}
+		for _, key := range []string{"src/1.txt", "src/sub/2.txt"} {
+			if err := b.Write(ctx, key, []byte(key)); err != nil {
+				t.Fatalf("Write %s failed: %v", key, err)
+			}
+		}
+		if err := renamer.RenamePrefix(ctx, "src/", "dst/"); err != nil {
+			t.Fatalf("RenamePrefix failed: %v", err)
+		}
+		keys, _ := b.List(ctx, "dst/")
+		sort.Strings(keys)
+		if got := strings.Join(keys, ","); got != "dst/1.txt,dst/sub/2.txt" {
+			t.Errorf("List(dst/) after RenamePrefix = %s, want dst/1.txt,dst/sub/2.txt", got)
+		}
+		if keys, _ := b.List(ctx, "src/"); len(keys) != 0 {
+			t.Errorf("List(src/) after RenamePrefix = %v, want nothing", keys)
+		}
+	})
+}
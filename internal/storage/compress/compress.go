@@ -0,0 +1,401 @@
+// Package compress implements a storage backend that compresses file contents
+// on top of another
+//
+// Files are compressed with gzip or zstd as they are written and decompressed
+// as they are read. Each compressed object records the algorithm and the size
+// of its content in metadata, so GetAttr reports the size a reader will see and
+// objects stored uncompressed, or with the other algorithm, remain readable.
+// Empty files and directory markers are stored as is.
+//
+// Compressed data cannot be read from the middle, so a ranged read of a
+// compressed object fetches and decompresses the whole object. The most recent
+// such object is kept in memory, so reading a file sequentially costs one fetch
+// rather than one per read.
+//
+// Delimited and limited listings, conditional and exclusive writes and copies
+// go to the inner backend's implementations where it has them. Ranged writes
+// are not offered, since they would patch compressed data
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// Algorithm names a compression format
+type Algorithm string
+
+// Supported algorithms
+const (
+	Gzip Algorithm = "gzip"
+	Zstd Algorithm = "zstd"
+)
+
+// Metadata keys of compressed objects
+const (
+	encodingKey = "compress-encoding" // Algorithm the object is stored with
+	sizeKey     = "compress-size"     // Size of the content before compression
+)
+
+// ParseAlgorithm parses an algorithm name; "" means no compression
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch algorithm := Algorithm(strings.ToLower(name)); algorithm {
+	case "", Gzip, Zstd:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("unknown compression algorithm %q (want gzip or zstd)", name)
+}
+
+// CompressBackend implements types.Backend by compressing file contents
+type CompressBackend struct {
+	inner     types.Backend
+	algorithm Algorithm
+	encoder   *zstd.Encoder
+	decoder   *zstd.Decoder
+
+	mu   sync.Mutex
+	last decompressed // Most recent object decompressed for a ranged read
+}
+
+// decompressed is the content of the object at path while its ETag is etag
+type decompressed struct {
+	path string
+	etag string
+	data []byte
+}
+
+// NewCompressBackend creates a backend storing new files in inner compressed
+// with algorithm
+func NewCompressBackend(inner types.Backend, algorithm Algorithm) *CompressBackend {
+	// Neither fails without options
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+	return &CompressBackend{inner: inner, algorithm: algorithm, encoder: encoder, decoder: decoder}
+}
+
+// stat returns the attributes of path, the algorithm it is stored with and its
+// content size; the algorithm is "" if path is not compressed. Failing to read
+// the metadata is an error, so compressed data is never passed off as content
+func (c *CompressBackend) stat(ctx context.Context, path string) (*types.Attr, Algorithm, int64, error) {
+	attr, metadata, err := types.StatMetadata(ctx, c.inner, path)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	algorithm := Algorithm(metadata[encodingKey])
+	if algorithm == "" {
+		return attr, "", 0, nil
+	}
+	size, err := strconv.ParseInt(metadata[sizeKey], 10, 64)
+	if err != nil || size < 0 {
+		return nil, "", 0, fmt.Errorf("%s has an invalid content size %q", path, metadata[sizeKey])
+	}
+	return attr, algorithm, size, nil
+}
+
+// compress returns data compressed with the backend's algorithm
+func (c *CompressBackend) compress(data []byte) ([]byte, error) {
+	if c.algorithm == Zstd {
+		return c.encoder.EncodeAll(data, nil), nil
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns the content of data stored with algorithm, checking it has
+// the recorded size
+func (c *CompressBackend) decompress(path string, data []byte, algorithm Algorithm, size int64) ([]byte, error) {
+	var content []byte
+	var err error
+	switch algorithm {
+	case Zstd:
+		content, err = c.decoder.DecodeAll(data, make([]byte, 0, size))
+	case Gzip:
+		var reader *gzip.Reader
+		if reader, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			content, err = io.ReadAll(reader)
+		}
+	default:
+		return nil, fmt.Errorf("%s is stored with unknown compression %q", path, algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	if int64(len(content)) != size {
+		return nil, fmt.Errorf("%s decompressed to %d bytes, want %d", path, len(content), size)
+	}
+	return content, nil
+}
+
+// compressedMetadata returns metadata with the keys for algorithm and size added
+func compressedMetadata(metadata map[string]string, algorithm Algorithm, size int64) map[string]string {
+	result := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		result[k] = v
+	}
+	result[encodingKey] = string(algorithm)
+	result[sizeKey] = strconv.FormatInt(size, 10)
+	return result
+}
+
+// Read reads and decompresses a file's content
+func (c *CompressBackend) Read(ctx context.Context, path string) ([]byte, error) {
+	_, algorithm, size, err := c.stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.inner.Read(ctx, path)
+	if err != nil || algorithm == "" {
+		return data, err
+	}
+	return c.decompress(path, data, algorithm, size)
+}
+
+// ReadRange reads bytes start through end (inclusive) of a file's content
+// A compressed file is read whole and decompressed, then sliced
+func (c *CompressBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	attr, algorithm, size, err := c.stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm == "" {
+		return c.inner.ReadRange(ctx, path, start, end)
+	}
+	content, err := c.content(ctx, path, attr.ETag, algorithm, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if start < 0 {
+		start = 0
+	}
+	if start >= size {
+		return []byte{}, nil
+	}
+	if end <= 0 || end >= size {
+		end = size - 1
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid range: end (%d) < start (%d)", end, start)
+	}
+	return append([]byte(nil), content[start:end+1]...), nil
+}
+
+// content returns the decompressed content of a compressed file, reusing the
+// last one decompressed while its ETag is unchanged
+func (c *CompressBackend) content(ctx context.Context, path, etag string, algorithm Algorithm, size int64) ([]byte, error) {
+	c.mu.Lock()
+	last := c.last
+	c.mu.Unlock()
+	if etag != "" && last.path == path && last.etag == etag {
+		return last.data, nil
+	}
+
+	data, err := c.inner.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := c.decompress(path, data, algorithm, size)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		c.mu.Lock()
+		c.last = decompressed{path: path, etag: etag, data: content}
+		c.mu.Unlock()
+	}
+	return content, nil
+}
+
+// forget drops the decompressed copy of path, if it is the one kept
+func (c *CompressBackend) forget(path string) {
+	c.mu.Lock()
+	if c.last.path == path {
+		c.last = decompressed{}
+	}
+	c.mu.Unlock()
+}
+
+// Write writes a file without metadata
+func (c *CompressBackend) Write(ctx context.Context, path string, data []byte) error {
+	return c.WriteWithMetadata(ctx, path, data, nil)
+}
+
+// WriteWithMetadata compresses data and stores it with metadata recording how
+func (c *CompressBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	data, metadata, err := c.encode(path, data, metadata)
+	if err != nil {
+		return err
+	}
+	return c.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// WriteIfMatch compresses data and stores it if path still has the given ETag
+// Backends without conditional writes store it unconditionally
+func (c *CompressBackend) WriteIfMatch(ctx context.Context, path string, data []byte, metadata map[string]string, etag string) error {
+	data, metadata, err := c.encode(path, data, metadata)
+	if err != nil {
+		return err
+	}
+	if writer, ok := c.inner.(types.ConditionalWriter); ok {
+		return writer.WriteIfMatch(ctx, path, data, metadata, etag)
+	}
+	return c.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// WriteIfAbsent compresses data and stores it if nothing is stored at path
+// Backends without exclusive writes are checked first, leaving a window for a
+// concurrent create
+func (c *CompressBackend) WriteIfAbsent(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	data, metadata, err := c.encode(path, data, metadata)
+	if err != nil {
+		return err
+	}
+	if writer, ok := c.inner.(types.ExclusiveWriter); ok {
+		return writer.WriteIfAbsent(ctx, path, data, metadata)
+	}
+	if exists, err := c.inner.Exists(ctx, path); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%s: %w", path, os.ErrExist)
+		}
+		return err
+	}
+	return c.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// encode returns data as it is stored at path, compressed unless it is empty
+// or a directory marker, and metadata recording how
+func (c *CompressBackend) encode(path string, data []byte, metadata map[string]string) ([]byte, map[string]string, error) {
+	c.forget(path)
+	if len(data) == 0 || strings.HasSuffix(path, "/") {
+		return data, metadata, nil
+	}
+	compressed, err := c.compress(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compress %s: %w", path, err)
+	}
+	return compressed, compressedMetadata(metadata, c.algorithm, int64(len(data))), nil
+}
+
+// Delete deletes a file
+func (c *CompressBackend) Delete(ctx context.Context, path string) error {
+	c.forget(path)
+	return c.inner.Delete(ctx, path)
+}
+
+// DeleteMany deletes several files
+func (c *CompressBackend) DeleteMany(ctx context.Context, paths []string) error {
+	for _, path := range paths {
+		c.forget(path)
+	}
+	return c.inner.DeleteMany(ctx, paths)
+}
+
+// List lists files under prefix
+func (c *CompressBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return c.inner.List(ctx, prefix)
+}
+
+// ListDelimited lists one level below prefix
+func (c *CompressBackend) ListDelimited(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	return types.ListDelimited(ctx, c.inner, prefix, delimiter)
+}
+
+// ListDelimitedPage lists a page of the level below prefix
+func (c *CompressBackend) ListDelimitedPage(ctx context.Context, prefix, delimiter, token string, limit int) ([]string, []string, string, error) {
+	return types.ListDelimitedPage(ctx, c.inner, prefix, delimiter, token, limit)
+}
+
+// ListLimited lists at most maxKeys files under prefix
+func (c *CompressBackend) ListLimited(ctx context.Context, prefix string, maxKeys int) ([]string, error) {
+	return types.ListLimited(ctx, c.inner, prefix, maxKeys)
+}
+
+// HasRootKey reports whether the root of the inner backend is a key prefix
+func (c *CompressBackend) HasRootKey() bool {
+	keyed, ok := c.inner.(types.RootKeyer)
+	return ok && keyed.HasRootKey()
+}
+
+// GetAttr gets a file's attributes, with the size of its content rather than
+// the size stored
+func (c *CompressBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	attr, algorithm, size, err := c.stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm != "" {
+		attr.Size = size
+	}
+	if attr.Metadata != nil {
+		delete(attr.Metadata, encodingKey)
+		delete(attr.Metadata, sizeKey)
+	}
+	return attr, nil
+}
+
+// Rename moves a file; the stored data and its metadata move unchanged
+func (c *CompressBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	c.forget(oldPath)
+	c.forget(newPath)
+	return c.inner.Rename(ctx, oldPath, newPath)
+}
+
+// Copy copies a file; the stored data and its metadata are copied unchanged,
+// server-side where the inner backend can
+func (c *CompressBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	c.forget(dstPath)
+	return types.Copy(ctx, c.inner, srcPath, dstPath)
+}
+
+// UpdateMetadata replaces a file's metadata, keeping the record of how it is
+// compressed
+func (c *CompressBackend) UpdateMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	_, algorithm, size, err := c.stat(ctx, path)
+	if err != nil {
+		return err
+	}
+	if algorithm != "" {
+		metadata = compressedMetadata(metadata, algorithm, size)
+	}
+	if updater, ok := c.inner.(types.MetadataUpdater); ok {
+		return updater.UpdateMetadata(ctx, path, metadata)
+	}
+	data, err := c.inner.Read(ctx, path)
+	if err != nil {
+		return err
+	}
+	return c.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// Exists checks if a file exists
+func (c *CompressBackend) Exists(ctx context.Context, path string) (bool, error) {
+	return c.inner.Exists(ctx, path)
+}
+
+// GetMetadata returns a file's metadata without the compression keys
+func (c *CompressBackend) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	metadata, err := c.inner.GetMetadata(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	delete(metadata, encodingKey)
+	delete(metadata, sizeKey)
+	return metadata, nil
+}
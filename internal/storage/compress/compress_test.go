@@ -0,0 +1,188 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/backendtest"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/localfs"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+func newTestBackend(t *testing.T, algorithm Algorithm) (*CompressBackend, *localfs.LocalBackend) {
+	inner, err := localfs.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBackend failed: %v", err)
+	}
+	return NewCompressBackend(inner, algorithm), inner
+}
+
+func TestCompressBackend(t *testing.T) {
+	for _, algorithm := range []Algorithm{Gzip, Zstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			backendtest.Run(t, func(t *testing.T) types.Backend {
+				backend, _ := newTestBackend(t, algorithm)
+				return backend
+			})
+		})
+	}
+}
+
+func TestCompressBackend_StoresCompressed(t *testing.T) {
+	content := bytes.Repeat([]byte("compressible line of text\n"), 1000)
+	for _, algorithm := range []Algorithm{Gzip, Zstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			backend, inner := newTestBackend(t, algorithm)
+			ctx := context.Background()
+
+			if err := backend.WriteWithMetadata(ctx, "log.txt", content, map[string]string{"mode": "644"}); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			stored, _ := inner.Read(ctx, "log.txt")
+			if len(stored) >= len(content)/10 {
+				t.Errorf("Stored %d bytes for %d of repetitive content", len(stored), len(content))
+			}
+			if data, err := backend.Read(ctx, "log.txt"); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("Read = %d bytes, %v; want the original %d", len(data), err, len(content))
+			}
+			if attr, err := backend.GetAttr(ctx, "log.txt"); err != nil || attr.Size != int64(len(content)) {
+				t.Errorf("GetAttr = %+v, %v; want size %d", attr, err, len(content))
+			}
+			metadata, _ := backend.GetMetadata(ctx, "log.txt")
+			if _, ok := metadata[encodingKey]; ok || metadata["mode"] != "644" {
+				t.Errorf("GetMetadata = %v, want the file's own metadata only", metadata)
+			}
+
+			// Ranges come from the decompressed content
+			data, err := backend.ReadRange(ctx, "log.txt", 26, 29)
+			if err != nil || string(data) != "comp" {
+				t.Errorf("ReadRange = %q, %v; want %q", data, err, "comp")
+			}
+
+			// Metadata updates keep the object readable
+			if err := backend.UpdateMetadata(ctx, "log.txt", map[string]string{"mode": "600"}); err != nil {
+				t.Fatalf("UpdateMetadata failed: %v", err)
+			}
+			if data, err := backend.Read(ctx, "log.txt"); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("Read after UpdateMetadata = %d bytes, %v", len(data), err)
+			}
+		})
+	}
+}
+
+func TestCompressBackend_MixedObjects(t *testing.T) {
+	backend, inner := newTestBackend(t, Zstd)
+	ctx := context.Background()
+
+	// Written before compression was enabled, and by a gzip mount
+	if err := inner.Write(ctx, "plain.txt", []byte("plain")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := NewCompressBackend(inner, Gzip).Write(ctx, "gzip.txt", []byte("gzipped")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	for path, want := range map[string]string{"plain.txt": "plain", "gzip.txt": "gzipped"} {
+		if data, err := backend.Read(ctx, path); err != nil || string(data) != want {
+			t.Errorf("Read(%s) = %q, %v; want %q", path, data, err, want)
+		}
+		if data, err := backend.ReadRange(ctx, path, 1, 3); err != nil || string(data) != want[1:4] {
+			t.Errorf("ReadRange(%s) = %q, %v; want %q", path, data, err, want[1:4])
+		}
+	}
+}
+
+func TestCompressBackend_RangeAfterOverwrite(t *testing.T) {
+	backend, _ := newTestBackend(t, Gzip)
+	ctx := context.Background()
+
+	backend.Write(ctx, "file.txt", []byte("first version"))
+	if data, _ := backend.ReadRange(ctx, "file.txt", 0, 4); string(data) != "first" {
+		t.Fatalf("ReadRange = %q, want %q", data, "first")
+	}
+	backend.Write(ctx, "file.txt", []byte("second version"))
+	if data, _ := backend.ReadRange(ctx, "file.txt", 0, 5); string(data) != "second" {
+		t.Errorf("ReadRange after overwrite = %q, want %q", data, "second")
+	}
+}
+
+// failingMetadata fails GetMetadata as a throttled or timed out HEAD would,
+// and reports no metadata with attributes
+type failingMetadata struct {
+	types.Backend
+}
+
+func (f failingMetadata) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	attr, err := f.Backend.GetAttr(ctx, path)
+	if attr != nil {
+		attr.Metadata = nil
+	}
+	return attr, err
+}
+
+func (f failingMetadata) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	return nil, errors.New("503 slow down")
+}
+
+func TestCompressBackend_MetadataErrors(t *testing.T) {
+	backend, inner := newTestBackend(t, Zstd)
+	ctx := context.Background()
+	backend.Write(ctx, "log.txt", bytes.Repeat([]byte("line\n"), 100))
+
+	failing := NewCompressBackend(failingMetadata{inner}, Zstd)
+	if data, err := failing.Read(ctx, "log.txt"); err == nil {
+		t.Errorf("Read without metadata = %d bytes, want the error", len(data))
+	}
+	if data, err := failing.ReadRange(ctx, "log.txt", 0, 10); err == nil {
+		t.Errorf("ReadRange without metadata = %d bytes, want the error", len(data))
+	}
+	if attr, err := failing.GetAttr(ctx, "log.txt"); err == nil {
+		t.Errorf("GetAttr without metadata = size %d, want the error", attr.Size)
+	}
+}
+
+func TestCompressBackend_ForwardsOptionalInterfaces(t *testing.T) {
+	backend, inner := newTestBackend(t, Gzip)
+	ctx := context.Background()
+	var b types.Backend = backend
+	if _, ok := b.(types.DelimitedLister); !ok {
+		t.Error("DelimitedLister is hidden")
+	}
+	if _, ok := b.(types.LimitedLister); !ok {
+		t.Error("LimitedLister is hidden")
+	}
+	if _, ok := b.(types.RangeWriter); ok {
+		t.Error("RangeWriter is forwarded, but would patch compressed data")
+	}
+
+	content := bytes.Repeat([]byte("abc"), 1000)
+	if err := backend.WriteIfAbsent(ctx, "new.txt", content, nil); err != nil {
+		t.Fatalf("WriteIfAbsent failed: %v", err)
+	}
+	if err := backend.WriteIfAbsent(ctx, "new.txt", content, nil); !errors.Is(err, os.ErrExist) {
+		t.Errorf("WriteIfAbsent over a file = %v, want os.ErrExist", err)
+	}
+	if err := backend.Copy(ctx, "new.txt", "copy.txt"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if data, err := backend.Read(ctx, "copy.txt"); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Read of the copy = %d bytes, %v", len(data), err)
+	}
+	if stored, _ := inner.Read(ctx, "copy.txt"); len(stored) >= len(content) {
+		t.Errorf("Copy stored %d bytes, want the compressed object", len(stored))
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, name := range []string{"", "gzip", "ZSTD"} {
+		if _, err := ParseAlgorithm(name); err != nil {
+			t.Errorf("ParseAlgorithm(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := ParseAlgorithm("lz4"); err == nil {
+		t.Error("ParseAlgorithm accepted lz4")
+	}
+}
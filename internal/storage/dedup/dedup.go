@@ -0,0 +1,433 @@
+// Package dedup implements a content-addressed storage backend on top of another
+//
+// File contents are stored once per distinct content, as blobs under the
+// .s3fs-blobs/ prefix keyed by their SHA-256. The key of each file holds an empty
+// pointer object whose metadata names the blob, alongside the file's own metadata.
+// Each blob records how many pointers refer to it and is deleted with the last
+// one. Empty files and directory markers are stored as is.
+//
+// Reference counts are only consistent while this backend is the only writer of
+// the underlying store; two mounts sharing a bucket in this mode can leak blobs
+// or delete blobs still in use. The same assumption lets the backend remember
+// which blob each path points at, so reading a file costs the inner backend one
+// read of its blob rather than a metadata lookup first
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/logging"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// BlobPrefix is where blobs are stored in the underlying backend; keys under it
+// are hidden from listings
+const BlobPrefix = ".s3fs-blobs/"
+
+// Metadata keys used by pointers and blobs
+const (
+	hashKey = "dedup-hash" // Pointer: SHA-256 of the content, in hex
+	sizeKey = "dedup-size" // Pointer: size of the content
+	refsKey = "dedup-refs" // Blob: number of pointers to it
+)
+
+// maxPointers bounds how many paths' pointers are remembered
+const maxPointers = 10000
+
+// pointerInfo is what a path's pointer records
+type pointerInfo struct {
+	hash string // SHA-256 of the content ("" = not a pointer)
+	size int64  // Size of the content
+}
+
+// DedupBackend implements types.Backend by storing identical contents once
+type DedupBackend struct {
+	inner    types.Backend
+	locks    [256]sync.Mutex // Serialize reference count updates, striped by hash
+	mu       sync.Mutex
+	pointers map[string]pointerInfo // Path -> pointer last read or written there
+}
+
+// NewDedupBackend creates a deduplicating backend storing its data in inner
+func NewDedupBackend(inner types.Backend) *DedupBackend {
+	return &DedupBackend{inner: inner, pointers: make(map[string]pointerInfo)}
+}
+
+// BlobKey returns the key the blob with the given hex hash is stored at
+func BlobKey(hash string) string {
+	return BlobPrefix + hash
+}
+
+// validHash reports whether hash is a hex-encoded SHA-256
+func validHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// lock returns the mutex guarding the reference count of hash
+func (d *DedupBackend) lock(hash string) (*sync.Mutex, error) {
+	if !validHash(hash) {
+		return nil, fmt.Errorf("invalid blob hash %q", hash)
+	}
+	b, _ := hex.DecodeString(hash[:2])
+	return &d.locks[b[0]], nil
+}
+
+// pointer returns the hash and content size recorded for path, or "" if path is
+// missing or not a pointer. Pointers read or written before are remembered
+func (d *DedupBackend) pointer(ctx context.Context, path string) (string, int64, error) {
+	d.mu.Lock()
+	known, ok := d.pointers[path]
+	d.mu.Unlock()
+	if ok {
+		return known.hash, known.size, nil
+	}
+
+	metadata, err := d.inner.GetMetadata(ctx, path)
+	if err != nil {
+		return "", 0, nil
+	}
+	return d.remember(path, metadata)
+}
+
+// remember records the pointer in metadata, the stored metadata of path, and
+// returns its hash and content size. A pointer naming an invalid hash fails
+func (d *DedupBackend) remember(path string, metadata map[string]string) (string, int64, error) {
+	hash := metadata[hashKey]
+	if hash != "" && !validHash(hash) {
+		return "", 0, fmt.Errorf("%s points at an invalid blob hash %q", path, hash)
+	}
+	size, _ := strconv.ParseInt(metadata[sizeKey], 10, 64)
+	d.setPointer(path, hash, size)
+	return hash, size, nil
+}
+
+// setPointer remembers what path points at, forgetting an arbitrary other path
+// if too many are remembered
+func (d *DedupBackend) setPointer(path, hash string, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pointers[path]; !ok && len(d.pointers) >= maxPointers {
+		for other := range d.pointers {
+			delete(d.pointers, other)
+			break
+		}
+	}
+	d.pointers[path] = pointerInfo{hash: hash, size: size}
+}
+
+// forget drops what is remembered about the pointers of paths
+func (d *DedupBackend) forget(paths ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, path := range paths {
+		delete(d.pointers, path)
+	}
+}
+
+// pointerMetadata returns metadata with the pointer keys for hash and size added
+func pointerMetadata(metadata map[string]string, hash string, size int64) map[string]string {
+	result := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		result[k] = v
+	}
+	result[hashKey] = hash
+	result[sizeKey] = strconv.FormatInt(size, 10)
+	return result
+}
+
+// refs returns the reference count of the blob at key, or 0 if it doesn't exist
+func (d *DedupBackend) refs(ctx context.Context, key string) (int, error) {
+	exists, err := d.inner.Exists(ctx, key)
+	if err != nil || !exists {
+		return 0, err
+	}
+	metadata, err := d.inner.GetMetadata(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	refs, err := strconv.Atoi(metadata[refsKey])
+	if err != nil {
+		return 0, fmt.Errorf("blob %s has an invalid reference count %q", key, metadata[refsKey])
+	}
+	return refs, nil
+}
+
+// setRefs stores a new reference count for the blob at key
+// data is the blob's content if the caller has it, saving a read on backends
+// that cannot update metadata alone
+func (d *DedupBackend) setRefs(ctx context.Context, key string, refs int, data []byte) error {
+	metadata := map[string]string{refsKey: strconv.Itoa(refs)}
+	if updater, ok := d.inner.(types.MetadataUpdater); ok {
+		return updater.UpdateMetadata(ctx, key, metadata)
+	}
+	if data == nil {
+		var err error
+		if data, err = d.inner.Read(ctx, key); err != nil {
+			return err
+		}
+	}
+	return d.inner.WriteWithMetadata(ctx, key, data, metadata)
+}
+
+// acquire adds a reference to the blob holding data, storing it if it is new
+func (d *DedupBackend) acquire(ctx context.Context, hash string, data []byte) error {
+	// The caller is told about the pointer it writes, not the blob
+	ctx = types.WithoutWritten(ctx)
+	mu, err := d.lock(hash)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := BlobKey(hash)
+	refs, err := d.refs(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	if refs == 0 {
+		return d.inner.WriteWithMetadata(ctx, key, data, map[string]string{refsKey: "1"})
+	}
+	return d.setRefs(ctx, key, refs+1, data)
+}
+
+// release drops a reference to the blob, deleting it with the last one
+func (d *DedupBackend) release(ctx context.Context, hash string) error {
+	ctx = types.WithoutWritten(ctx)
+	mu, err := d.lock(hash)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := BlobKey(hash)
+	refs, err := d.refs(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	if refs <= 1 {
+		if err := d.inner.Delete(ctx, key); err != nil && refs == 1 {
+			return err
+		}
+		return nil
+	}
+	return d.setRefs(ctx, key, refs-1, nil)
+}
+
+// releaseAfter drops a reference once the pointer to it is gone; a failure only
+// leaves the blob stored longer than needed, so it is logged rather than
+// failing an operation that already took effect
+func (d *DedupBackend) releaseAfter(ctx context.Context, path, hash string) {
+	if hash == "" {
+		return
+	}
+	if err := d.release(ctx, hash); err != nil {
+		logging.Warn("failed to release deduplicated blob", "path", path, "blob", BlobKey(hash), "err", err)
+	}
+}
+
+// Read reads a file's content from its blob
+func (d *DedupBackend) Read(ctx context.Context, path string) ([]byte, error) {
+	hash, _, err := d.pointer(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if hash != "" {
+		return d.inner.Read(ctx, BlobKey(hash))
+	}
+	return d.inner.Read(ctx, path)
+}
+
+// ReadRange reads a range of a file's content from its blob
+func (d *DedupBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	hash, _, err := d.pointer(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if hash != "" {
+		return d.inner.ReadRange(ctx, BlobKey(hash), start, end)
+	}
+	return d.inner.ReadRange(ctx, path, start, end)
+}
+
+// Write writes a file without metadata
+func (d *DedupBackend) Write(ctx context.Context, path string, data []byte) error {
+	return d.WriteWithMetadata(ctx, path, data, nil)
+}
+
+// WriteWithMetadata stores data in its blob, unless an identical blob already
+// exists, and points path at it. The blob path referred to before is released
+func (d *DedupBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	old, _, _ := d.pointer(ctx, path)
+	d.forget(path)
+	if len(data) == 0 || strings.HasSuffix(path, "/") {
+		if err := d.inner.WriteWithMetadata(ctx, path, data, metadata); err != nil {
+			return err
+		}
+		d.setPointer(path, "", 0)
+		d.releaseAfter(ctx, path, old)
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if err := d.acquire(ctx, hash, data); err != nil {
+		return err
+	}
+	if err := d.inner.WriteWithMetadata(ctx, path, nil, pointerMetadata(metadata, hash, int64(len(data)))); err != nil {
+		d.releaseAfter(ctx, path, hash)
+		return err
+	}
+	d.setPointer(path, hash, int64(len(data)))
+	d.releaseAfter(ctx, path, old)
+	return nil
+}
+
+// Delete deletes a file and releases its blob
+func (d *DedupBackend) Delete(ctx context.Context, path string) error {
+	hash, _, _ := d.pointer(ctx, path)
+	d.forget(path)
+	if err := d.inner.Delete(ctx, path); err != nil {
+		return err
+	}
+	d.releaseAfter(ctx, path, hash)
+	return nil
+}
+
+// DeleteMany deletes several files and releases the blobs of those deleted
+func (d *DedupBackend) DeleteMany(ctx context.Context, paths []string) error {
+	hashes := make(map[string]string)
+	for _, path := range paths {
+		if hash, _, _ := d.pointer(ctx, path); hash != "" {
+			hashes[path] = hash
+		}
+	}
+	d.forget(paths...)
+
+	err := d.inner.DeleteMany(ctx, paths)
+	var partial *types.DeleteManyError
+	if err != nil && !errors.As(err, &partial) {
+		return err // Nothing is known to be deleted
+	}
+	for path, hash := range hashes {
+		if partial != nil && partial.Failed[path] != nil {
+			continue
+		}
+		d.releaseAfter(ctx, path, hash)
+	}
+	return err
+}
+
+// List lists files under prefix, leaving out blobs
+func (d *DedupBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := d.inner.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	visible := keys[:0]
+	for _, key := range keys {
+		if !strings.HasPrefix(key, BlobPrefix) {
+			visible = append(visible, key)
+		}
+	}
+	return visible, nil
+}
+
+// GetAttr gets a file's attributes, with the size of its content
+// The content hash stands in for the ETag, which for the empty pointer object
+// would be the same whatever the content. The pointer is read from the same
+// request as the attributes when the inner backend reports metadata with them
+func (d *DedupBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	attr, metadata, err := types.StatMetadata(ctx, d.inner, path)
+	if err != nil {
+		return nil, err
+	}
+	hash, size, err := d.remember(path, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if hash != "" {
+		attr.Size = size
+		attr.ETag = hash
+	}
+	if attr.Metadata != nil {
+		attr.Metadata = withoutPointer(attr.Metadata)
+	}
+	return attr, nil
+}
+
+// Rename moves a file's pointer; the content stays in its blob. A file replaced
+// at newPath releases its blob
+func (d *DedupBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	replaced := ""
+	if oldPath != newPath {
+		replaced, _, _ = d.pointer(ctx, newPath)
+	}
+	d.forget(oldPath, newPath)
+	if err := d.inner.Rename(ctx, oldPath, newPath); err != nil {
+		return err
+	}
+	d.releaseAfter(ctx, newPath, replaced)
+	return nil
+}
+
+// UpdateMetadata replaces a file's metadata, keeping it pointed at its blob
+func (d *DedupBackend) UpdateMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	hash, size, err := d.pointer(ctx, path)
+	if err != nil {
+		return err
+	}
+	if hash == "" {
+		if updater, ok := d.inner.(types.MetadataUpdater); ok {
+			return updater.UpdateMetadata(ctx, path, metadata)
+		}
+		data, err := d.inner.Read(ctx, path)
+		if err != nil {
+			return err
+		}
+		return d.inner.WriteWithMetadata(ctx, path, data, metadata)
+	}
+
+	metadata = pointerMetadata(metadata, hash, size)
+	if updater, ok := d.inner.(types.MetadataUpdater); ok {
+		return updater.UpdateMetadata(ctx, path, metadata)
+	}
+	return d.inner.WriteWithMetadata(ctx, path, nil, metadata)
+}
+
+// Exists checks if a file exists
+func (d *DedupBackend) Exists(ctx context.Context, path string) (bool, error) {
+	return d.inner.Exists(ctx, path)
+}
+
+// GetMetadata returns a file's metadata without the pointer keys
+func (d *DedupBackend) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	metadata, err := d.inner.GetMetadata(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return withoutPointer(metadata), nil
+}
+
+// withoutPointer returns a copy of metadata without the pointer keys
+func withoutPointer(metadata map[string]string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if k != hashKey && k != sizeKey {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,215 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/backendtest"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/localfs"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+func newTestBackend(t *testing.T) (*DedupBackend, *localfs.LocalBackend) {
+	inner, err := localfs.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBackend failed: %v", err)
+	}
+	return NewDedupBackend(inner), inner
+}
+
+// blobRefs returns the blobs stored in inner and their reference counts
+func blobRefs(t *testing.T, inner types.Backend) map[string]string {
+	t.Helper()
+	keys, err := inner.List(context.Background(), BlobPrefix)
+	if err != nil {
+		t.Fatalf("List of blobs failed: %v", err)
+	}
+	refs := make(map[string]string)
+	for _, key := range keys {
+		metadata, err := inner.GetMetadata(context.Background(), key)
+		if err != nil {
+			t.Fatalf("GetMetadata(%s) failed: %v", key, err)
+		}
+		refs[key] = metadata[refsKey]
+	}
+	return refs
+}
+
+func contentKey(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return BlobKey(hex.EncodeToString(sum[:]))
+}
+
+func TestDedupBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) types.Backend {
+		backend, _ := newTestBackend(t)
+		return backend
+	})
+}
+
+func TestDedupBackend_IdenticalContent(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+	blob := contentKey("same content")
+
+	for _, path := range []string{"a.txt", "dir/b.txt"} {
+		if err := backend.Write(ctx, path, []byte("same content")); err != nil {
+			t.Fatalf("Write %s failed: %v", path, err)
+		}
+	}
+	if refs := blobRefs(t, inner); len(refs) != 1 || refs[blob] != "2" {
+		t.Fatalf("Blobs after writing the same content twice = %v, want %s with 2 refs", refs, blob)
+	}
+	for _, path := range []string{"a.txt", "dir/b.txt"} {
+		if data, _ := inner.Read(ctx, path); len(data) != 0 {
+			t.Errorf("Pointer %s holds %d bytes of content", path, len(data))
+		}
+		if data, err := backend.Read(ctx, path); err != nil || string(data) != "same content" {
+			t.Errorf("Read(%s) = %q, %v", path, data, err)
+		}
+		if attr, err := backend.GetAttr(ctx, path); err != nil || attr.Size != 12 {
+			t.Errorf("GetAttr(%s) = %+v, %v; want size 12", path, attr, err)
+		}
+	}
+	if keys, _ := backend.List(ctx, ""); len(keys) != 2 {
+		t.Errorf("List = %v, want only the two files", keys)
+	}
+
+	// Rename moves the pointer and keeps the reference
+	if err := backend.Rename(ctx, "a.txt", "c.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if refs := blobRefs(t, inner); refs[blob] != "2" {
+		t.Errorf("Refs after Rename = %v, want 2", refs)
+	}
+	if data, _ := backend.Read(ctx, "c.txt"); string(data) != "same content" {
+		t.Errorf("Read after Rename = %q", data)
+	}
+
+	// Overwriting with new content moves the reference to a new blob
+	if err := backend.Write(ctx, "dir/b.txt", []byte("other")); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+	refs := blobRefs(t, inner)
+	if refs[blob] != "1" || refs[contentKey("other")] != "1" {
+		t.Errorf("Blobs after overwrite = %v, want 1 ref each", refs)
+	}
+
+	// The last reference deletes the blob
+	if err := backend.Delete(ctx, "c.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if refs := blobRefs(t, inner); len(refs) != 1 {
+		t.Errorf("Blobs after deleting the last copy = %v, want only the other content", refs)
+	}
+	if err := backend.DeleteMany(ctx, []string{"dir/b.txt"}); err != nil {
+		t.Fatalf("DeleteMany failed: %v", err)
+	}
+	if refs := blobRefs(t, inner); len(refs) != 0 {
+		t.Errorf("Blobs after deleting every file = %v, want none", refs)
+	}
+}
+
+func TestDedupBackend_RenameOntoCopy(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+
+	for _, path := range []string{"a.txt", "b.txt"} {
+		if err := backend.Write(ctx, path, []byte("data")); err != nil {
+			t.Fatalf("Write %s failed: %v", path, err)
+		}
+	}
+	// Replacing a file with a copy of its own content drops one reference
+	if err := backend.Rename(ctx, "a.txt", "b.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if refs := blobRefs(t, inner); refs[contentKey("data")] != "1" {
+		t.Errorf("Refs after Rename onto a copy = %v, want 1", refs)
+	}
+
+	// Metadata updates keep the pointer
+	if err := backend.UpdateMetadata(ctx, "b.txt", map[string]string{"mode": "600"}); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if data, err := backend.Read(ctx, "b.txt"); err != nil || string(data) != "data" {
+		t.Errorf("Read after UpdateMetadata = %q, %v", data, err)
+	}
+	metadata, _ := backend.GetMetadata(ctx, "b.txt")
+	if _, ok := metadata[hashKey]; ok || metadata["mode"] != "600" {
+		t.Errorf("GetMetadata = %v, want mode only", metadata)
+	}
+}
+
+// metadataCountingBackend counts the metadata lookups made of the backend it wraps
+type metadataCountingBackend struct {
+	types.Backend
+	lookups int
+}
+
+func (b *metadataCountingBackend) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	b.lookups++
+	return b.Backend.GetMetadata(ctx, path)
+}
+
+func TestDedupBackend_ReadsRememberPointers(t *testing.T) {
+	_, local := newTestBackend(t)
+	inner := &metadataCountingBackend{Backend: local}
+	ctx := context.Background()
+	if err := NewDedupBackend(inner).Write(ctx, "a.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// A stat finds the pointer; reading the file then goes straight to its blob
+	backend := NewDedupBackend(inner)
+	if attr, err := backend.GetAttr(ctx, "a.txt"); err != nil || attr.Size != 7 {
+		t.Fatalf("GetAttr = %+v, %v; want size 7", attr, err)
+	}
+	lookups := inner.lookups
+	for i := 0; i < 3; i++ {
+		if data, err := backend.Read(ctx, "a.txt"); err != nil || string(data) != "content" {
+			t.Fatalf("Read = %q, %v", data, err)
+		}
+	}
+	if n := inner.lookups - lookups; n != 0 {
+		t.Errorf("Reads looked up metadata %d times, want 0", n)
+	}
+
+	// Writes keep what is remembered current
+	if err := backend.Write(ctx, "a.txt", []byte("changed")); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+	if data, err := backend.Read(ctx, "a.txt"); err != nil || string(data) != "changed" {
+		t.Errorf("Read after overwrite = %q, %v", data, err)
+	}
+	if err := backend.Rename(ctx, "a.txt", "b.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := backend.Read(ctx, "a.txt"); err == nil {
+		t.Error("Read of the renamed path succeeded")
+	}
+	if data, err := backend.Read(ctx, "b.txt"); err != nil || string(data) != "changed" {
+		t.Errorf("Read after Rename = %q, %v", data, err)
+	}
+}
+
+func TestDedupBackend_InvalidPointer(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+	for _, hash := range []string{"a", "zz" + contentKey("x")[len(BlobPrefix)+2:]} {
+		if err := inner.WriteWithMetadata(ctx, "bad.txt", nil, map[string]string{hashKey: hash}); err != nil {
+			t.Fatalf("Failed to write pointer: %v", err)
+		}
+		backend.forget("bad.txt")
+		if _, err := backend.Read(ctx, "bad.txt"); err == nil {
+			t.Errorf("Read of a pointer to %q succeeded", hash)
+		}
+		if _, err := backend.GetAttr(ctx, "bad.txt"); err == nil {
+			t.Errorf("GetAttr of a pointer to %q succeeded", hash)
+		}
+	}
+	if err := backend.release(ctx, "a"); err == nil {
+		t.Error("Releasing a short hash succeeded")
+	}
+}
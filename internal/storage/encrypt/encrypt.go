@@ -0,0 +1,520 @@
+// Package encrypt implements a storage backend that encrypts file contents on
+// top of another, for buckets that are not trusted with the data
+//
+// Each file is encrypted with its own random AES-256 data key, which is stored
+// in the object's metadata wrapped (AES-GCM encrypted) with the master key.
+// The object's path is authenticated with the wrapped key, so an object moved
+// or copied to another path in the bucket, other than by this backend, fails
+// to decrypt; Rename and Copy wrap the key again for the new path.
+// The content is split into chunks of ChunkSize bytes, each sealed with
+// AES-GCM under a nonce derived from a random per-object base nonce and the
+// chunk's index. The chunk index and the content size are authenticated with
+// every chunk, so chunks cannot be reordered, dropped or cut short unnoticed.
+// A ranged read fetches and decrypts only the chunks it covers.
+//
+// Empty files and directory markers are stored as is, and objects without
+// encryption metadata, such as those written before encryption was enabled,
+// are read unchanged. Object names and user metadata are not encrypted.
+//
+// Delimited and limited listings, conditional and exclusive writes and copies
+// go to the inner backend's implementations where it has them. Ranged writes
+// and prefix renames are not offered: the first would patch sealed chunks, the
+// second would move keys without wrapping them for their new paths
+package encrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// KeySize is the size of master and data keys (AES-256)
+const KeySize = 32
+
+// ChunkSize is how much content each sealed chunk holds
+const ChunkSize = 64 * 1024
+
+// Metadata keys of encrypted objects
+const (
+	keyKey   = "encrypt-key"   // Data key wrapped with the master key, base64
+	nonceKey = "encrypt-nonce" // Base nonce of the chunks, base64
+	sizeKey  = "encrypt-size"  // Size of the content before encryption
+)
+
+// chunkOverhead is the GCM tag added to each chunk
+const chunkOverhead = 16
+
+// ErrCorrupt is returned when stored data fails authentication
+var ErrCorrupt = errors.New("encrypted object failed authentication")
+
+// EncryptBackend implements types.Backend by encrypting file contents
+type EncryptBackend struct {
+	inner  types.Backend
+	master cipher.AEAD // Wraps and unwraps data keys
+}
+
+// NewEncryptBackend creates a backend storing files in inner encrypted under
+// masterKey, which must be KeySize bytes
+func NewEncryptBackend(inner types.Backend, masterKey []byte) (*EncryptBackend, error) {
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return &EncryptBackend{inner: inner, master: master}, nil
+}
+
+// LoadKeyFile reads a master key from path, holding either KeySize raw bytes
+// or their hex encoding
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if text := strings.TrimSpace(string(data)); len(text) == 2*KeySize {
+		if key, err := hex.DecodeString(text); err == nil {
+			return key, nil
+		}
+	}
+	if len(data) != KeySize {
+		return nil, fmt.Errorf("%s holds %d bytes, want a %d-byte key or %d hex digits", path, len(data), KeySize, 2*KeySize)
+	}
+	return data, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes, want %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealer encrypts and decrypts the chunks of one object
+type sealer struct {
+	aead  cipher.AEAD
+	nonce []byte // Base nonce; chunk i uses it with i XORed into the last 8 bytes
+	size  int64  // Content size, authenticated with every chunk
+}
+
+func (s *sealer) chunkNonce(index int64) []byte {
+	nonce := append([]byte(nil), s.nonce...)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(index))
+	for i, b := range counter {
+		nonce[len(nonce)-8+i] ^= b
+	}
+	return nonce
+}
+
+func (s *sealer) chunkData(index int64) []byte {
+	var ad [16]byte
+	binary.BigEndian.PutUint64(ad[:8], uint64(index))
+	binary.BigEndian.PutUint64(ad[8:], uint64(s.size))
+	return ad[:]
+}
+
+// seal encrypts content, which must be s.size bytes
+func (s *sealer) seal(content []byte) []byte {
+	out := make([]byte, 0, storedSize(s.size))
+	for index := int64(0); index*ChunkSize < s.size; index++ {
+		chunk := content[index*ChunkSize : min((index+1)*ChunkSize, s.size)]
+		out = s.aead.Seal(out, s.chunkNonce(index), chunk, s.chunkData(index))
+	}
+	return out
+}
+
+// open decrypts stored, the sealed chunks starting with chunk first
+func (s *sealer) open(stored []byte, first int64) ([]byte, error) {
+	var content []byte
+	for index := first; len(stored) > 0; index++ {
+		n := min(len(stored), ChunkSize+chunkOverhead)
+		var err error
+		content, err = s.aead.Open(content, s.chunkNonce(index), stored[:n], s.chunkData(index))
+		if err != nil {
+			return nil, fmt.Errorf("%w: chunk %d", ErrCorrupt, index)
+		}
+		stored = stored[n:]
+	}
+	return content, nil
+}
+
+// storedSize returns the size of size bytes of content once encrypted
+func storedSize(size int64) int64 {
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	return size + chunks*chunkOverhead
+}
+
+// wrapKey encrypts dataKey with the master key, bound to path
+func (e *EncryptBackend) wrapKey(path string, dataKey []byte) (string, error) {
+	wrapNonce := make([]byte, e.master.NonceSize())
+	if _, err := rand.Read(wrapNonce); err != nil {
+		return "", err
+	}
+	wrapped := e.master.Seal(wrapNonce, wrapNonce, dataKey, []byte(path))
+	return base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// unwrapKey decrypts the data key wrapped for path
+func (e *EncryptBackend) unwrapKey(path, encoded string) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(encoded)
+	nonceSize := e.master.NonceSize()
+	if err != nil || len(wrapped) < nonceSize {
+		return nil, fmt.Errorf("%s has an invalid wrapped key", path)
+	}
+	dataKey, err := e.master.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("%w: the data key of %s does not unwrap with this master key at this path", ErrCorrupt, path)
+	}
+	return dataKey, nil
+}
+
+// newSealer creates the sealer for a new object of size bytes at path,
+// returning the metadata that records its key and nonce
+func (e *EncryptBackend) newSealer(path string, size int64) (*sealer, map[string]string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := e.wrapKey(path, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata := map[string]string{
+		keyKey:   wrapped,
+		nonceKey: base64.StdEncoding.EncodeToString(nonce),
+		sizeKey:  strconv.FormatInt(size, 10),
+	}
+	return &sealer{aead: aead, nonce: nonce, size: size}, metadata, nil
+}
+
+// sealerFor returns the sealer of the object with the given metadata, or nil
+// if it is not encrypted
+func (e *EncryptBackend) sealerFor(path string, metadata map[string]string) (*sealer, error) {
+	if metadata[keyKey] == "" {
+		return nil, nil
+	}
+	dataKey, err := e.unwrapKey(path, metadata[keyKey])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(metadata[nonceKey])
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%s has an invalid nonce", path)
+	}
+	size, err := strconv.ParseInt(metadata[sizeKey], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%s has an invalid size %q", path, metadata[sizeKey])
+	}
+	return &sealer{aead: aead, nonce: nonce, size: size}, nil
+}
+
+// lookup returns the sealer of the object at path, or nil if it is missing or
+// not encrypted. Other failures to read the metadata are returned, so content
+// is never passed on undecrypted because its keys could not be fetched
+func (e *EncryptBackend) lookup(ctx context.Context, path string) (*sealer, error) {
+	metadata, err := e.inner.GetMetadata(ctx, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.sealerFor(path, metadata)
+}
+
+// Read reads and decrypts a file's content
+func (e *EncryptBackend) Read(ctx context.Context, path string) ([]byte, error) {
+	s, err := e.lookup(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := e.inner.Read(ctx, path)
+	if err != nil || s == nil {
+		return stored, err
+	}
+	if int64(len(stored)) != storedSize(s.size) {
+		return nil, fmt.Errorf("%w: %s is %d bytes, want %d", ErrCorrupt, path, len(stored), storedSize(s.size))
+	}
+	return s.open(stored, 0)
+}
+
+// ReadRange reads bytes start through end (inclusive) of a file's content,
+// fetching and decrypting only the chunks holding them
+func (e *EncryptBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	s, err := e.lookup(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return e.inner.ReadRange(ctx, path, start, end)
+	}
+
+	if start < 0 {
+		start = 0
+	}
+	if start >= s.size {
+		return []byte{}, nil
+	}
+	if end <= 0 || end >= s.size {
+		end = s.size - 1
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid range: end (%d) < start (%d)", end, start)
+	}
+
+	first, last := start/ChunkSize, end/ChunkSize
+	storedStart := first * (ChunkSize + chunkOverhead)
+	storedEnd := min((last+1)*(ChunkSize+chunkOverhead), storedSize(s.size)) - 1
+	stored, err := e.inner.ReadRange(ctx, path, storedStart, storedEnd)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(stored)) != storedEnd-storedStart+1 {
+		return nil, fmt.Errorf("%w: %s is shorter than its content", ErrCorrupt, path)
+	}
+	content, err := s.open(stored, first)
+	if err != nil {
+		return nil, err
+	}
+	offset := start - first*ChunkSize
+	return content[offset : offset+end-start+1], nil
+}
+
+// Write writes a file without metadata
+func (e *EncryptBackend) Write(ctx context.Context, path string, data []byte) error {
+	return e.WriteWithMetadata(ctx, path, data, nil)
+}
+
+// WriteWithMetadata encrypts data under a new data key and stores it with
+// metadata recording the wrapped key
+func (e *EncryptBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	data, metadata, err := e.encode(path, data, metadata)
+	if err != nil {
+		return err
+	}
+	return e.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// WriteIfMatch encrypts data and stores it if path still has the given ETag
+// Backends without conditional writes store it unconditionally
+func (e *EncryptBackend) WriteIfMatch(ctx context.Context, path string, data []byte, metadata map[string]string, etag string) error {
+	data, metadata, err := e.encode(path, data, metadata)
+	if err != nil {
+		return err
+	}
+	if writer, ok := e.inner.(types.ConditionalWriter); ok {
+		return writer.WriteIfMatch(ctx, path, data, metadata, etag)
+	}
+	return e.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// WriteIfAbsent encrypts data and stores it if nothing is stored at path
+// Backends without exclusive writes are checked first, leaving a window for a
+// concurrent create
+func (e *EncryptBackend) WriteIfAbsent(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	data, metadata, err := e.encode(path, data, metadata)
+	if err != nil {
+		return err
+	}
+	if writer, ok := e.inner.(types.ExclusiveWriter); ok {
+		return writer.WriteIfAbsent(ctx, path, data, metadata)
+	}
+	if exists, err := e.inner.Exists(ctx, path); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%s: %w", path, os.ErrExist)
+		}
+		return err
+	}
+	return e.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// encode returns data as it is stored at path, encrypted under a new data key
+// unless it is empty or a directory marker, and metadata recording the key
+func (e *EncryptBackend) encode(path string, data []byte, metadata map[string]string) ([]byte, map[string]string, error) {
+	if len(data) == 0 || strings.HasSuffix(path, "/") {
+		return data, metadata, nil
+	}
+	s, keys, err := e.newSealer(path, int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create a data key for %s: %w", path, err)
+	}
+	return s.seal(data), withKeys(metadata, keys), nil
+}
+
+// withKeys returns metadata with the encryption keys of keys added
+func withKeys(metadata, keys map[string]string) map[string]string {
+	result := make(map[string]string, len(metadata)+len(keys))
+	for k, v := range metadata {
+		result[k] = v
+	}
+	for k, v := range keys {
+		result[k] = v
+	}
+	return result
+}
+
+// Delete deletes a file
+func (e *EncryptBackend) Delete(ctx context.Context, path string) error {
+	return e.inner.Delete(ctx, path)
+}
+
+// DeleteMany deletes several files
+func (e *EncryptBackend) DeleteMany(ctx context.Context, paths []string) error {
+	return e.inner.DeleteMany(ctx, paths)
+}
+
+// List lists files under prefix
+func (e *EncryptBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return e.inner.List(ctx, prefix)
+}
+
+// ListDelimited lists one level below prefix
+func (e *EncryptBackend) ListDelimited(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	return types.ListDelimited(ctx, e.inner, prefix, delimiter)
+}
+
+// ListDelimitedPage lists a page of the level below prefix
+func (e *EncryptBackend) ListDelimitedPage(ctx context.Context, prefix, delimiter, token string, limit int) ([]string, []string, string, error) {
+	return types.ListDelimitedPage(ctx, e.inner, prefix, delimiter, token, limit)
+}
+
+// ListLimited lists at most maxKeys files under prefix
+func (e *EncryptBackend) ListLimited(ctx context.Context, prefix string, maxKeys int) ([]string, error) {
+	return types.ListLimited(ctx, e.inner, prefix, maxKeys)
+}
+
+// HasRootKey reports whether the root of the inner backend is a key prefix
+func (e *EncryptBackend) HasRootKey() bool {
+	keyed, ok := e.inner.(types.RootKeyer)
+	return ok && keyed.HasRootKey()
+}
+
+// GetAttr gets a file's attributes, with the size of its content rather than
+// the size stored
+func (e *EncryptBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	attr, metadata, err := types.StatMetadata(ctx, e.inner, path)
+	if err != nil {
+		return nil, err
+	}
+	if size, err := strconv.ParseInt(metadata[sizeKey], 10, 64); err == nil && metadata[keyKey] != "" {
+		attr.Size = size
+	}
+	if attr.Metadata != nil {
+		delete(attr.Metadata, keyKey)
+		delete(attr.Metadata, nonceKey)
+		delete(attr.Metadata, sizeKey)
+	}
+	return attr, nil
+}
+
+// Rename moves a file; its data key moves with it in the metadata and is then
+// wrapped again for the new path
+func (e *EncryptBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	if err := e.inner.Rename(ctx, oldPath, newPath); err != nil {
+		return err
+	}
+	return e.rekey(ctx, oldPath, newPath)
+}
+
+// Copy copies a file, server-side where the inner backend can, and wraps the
+// copy's data key again for its path
+func (e *EncryptBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	if err := types.Copy(ctx, e.inner, srcPath, dstPath); err != nil {
+		return err
+	}
+	return e.rekey(ctx, srcPath, dstPath)
+}
+
+// rekey wraps the data key of the object moved from oldPath to path again,
+// bound to its new path
+func (e *EncryptBackend) rekey(ctx context.Context, oldPath, path string) error {
+	if oldPath == path {
+		return nil
+	}
+	metadata, err := e.inner.GetMetadata(ctx, path)
+	if err != nil {
+		return err
+	}
+	if metadata[keyKey] == "" {
+		return nil
+	}
+	dataKey, err := e.unwrapKey(oldPath, metadata[keyKey])
+	if err != nil {
+		return err
+	}
+	if metadata[keyKey], err = e.wrapKey(path, dataKey); err != nil {
+		return err
+	}
+	return e.updateInner(ctx, path, metadata)
+}
+
+// UpdateMetadata replaces a file's metadata, keeping its wrapped data key
+func (e *EncryptBackend) UpdateMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	stored, err := e.inner.GetMetadata(ctx, path)
+	if err != nil {
+		return err
+	}
+	if stored[keyKey] != "" {
+		metadata = withKeys(metadata, map[string]string{
+			keyKey:   stored[keyKey],
+			nonceKey: stored[nonceKey],
+			sizeKey:  stored[sizeKey],
+		})
+	}
+	return e.updateInner(ctx, path, metadata)
+}
+
+// updateInner replaces the metadata of the object stored at path
+func (e *EncryptBackend) updateInner(ctx context.Context, path string, metadata map[string]string) error {
+	if updater, ok := e.inner.(types.MetadataUpdater); ok {
+		return updater.UpdateMetadata(ctx, path, metadata)
+	}
+	data, err := e.inner.Read(ctx, path)
+	if err != nil {
+		return err
+	}
+	return e.inner.WriteWithMetadata(ctx, path, data, metadata)
+}
+
+// Exists checks if a file exists
+func (e *EncryptBackend) Exists(ctx context.Context, path string) (bool, error) {
+	return e.inner.Exists(ctx, path)
+}
+
+// GetMetadata returns a file's metadata without the encryption keys
+func (e *EncryptBackend) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	metadata, err := e.inner.GetMetadata(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	delete(metadata, keyKey)
+	delete(metadata, nonceKey)
+	delete(metadata, sizeKey)
+	return metadata, nil
+}
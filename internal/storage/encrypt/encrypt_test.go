@@ -0,0 +1,247 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/backendtest"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/localfs"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, KeySize)
+
+func newTestBackend(t *testing.T) (*EncryptBackend, *localfs.LocalBackend) {
+	inner, err := localfs.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBackend failed: %v", err)
+	}
+	backend, err := NewEncryptBackend(inner, testKey)
+	if err != nil {
+		t.Fatalf("NewEncryptBackend failed: %v", err)
+	}
+	return backend, inner
+}
+
+// testContent returns size bytes that differ from chunk to chunk
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+	return content
+}
+
+func TestEncryptBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) types.Backend {
+		backend, _ := newTestBackend(t)
+		return backend
+	})
+}
+
+func TestEncryptBackend_RoundTrip(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+	content := testContent(3*ChunkSize + 100)
+
+	if err := backend.WriteWithMetadata(ctx, "secret.bin", content, map[string]string{"mode": "600"}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	stored, _ := inner.Read(ctx, "secret.bin")
+	if int64(len(stored)) != storedSize(int64(len(content))) || bytes.Contains(stored, content[:64]) {
+		t.Errorf("Stored %d bytes holding the plaintext", len(stored))
+	}
+	if data, err := backend.Read(ctx, "secret.bin"); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Read = %d bytes, %v; want the original %d", len(data), err, len(content))
+	}
+	if attr, err := backend.GetAttr(ctx, "secret.bin"); err != nil || attr.Size != int64(len(content)) {
+		t.Errorf("GetAttr = %+v, %v; want size %d", attr, err, len(content))
+	}
+	metadata, _ := backend.GetMetadata(ctx, "secret.bin")
+	if _, ok := metadata[keyKey]; ok || metadata["mode"] != "600" {
+		t.Errorf("GetMetadata = %v, want the file's own metadata only", metadata)
+	}
+
+	tests := []struct{ start, end int64 }{
+		{0, 9},
+		{ChunkSize - 5, ChunkSize + 5}, // Across a chunk boundary
+		{ChunkSize, 2*ChunkSize - 1},   // Exactly one chunk
+		{3 * ChunkSize, 3*ChunkSize + 99},
+		{3*ChunkSize + 50, 0}, // To the end
+	}
+	for _, tt := range tests {
+		end := tt.end
+		if end == 0 {
+			end = int64(len(content)) - 1
+		}
+		data, err := backend.ReadRange(ctx, "secret.bin", tt.start, tt.end)
+		if err != nil || !bytes.Equal(data, content[tt.start:end+1]) {
+			t.Errorf("ReadRange(%d, %d) = %d bytes, %v", tt.start, tt.end, len(data), err)
+		}
+	}
+
+	// Metadata updates keep the data key
+	if err := backend.UpdateMetadata(ctx, "secret.bin", map[string]string{"mode": "644"}); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if data, err := backend.Read(ctx, "secret.bin"); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Read after UpdateMetadata = %d bytes, %v", len(data), err)
+	}
+}
+
+func TestEncryptBackend_DetectsTampering(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+	content := testContent(2*ChunkSize + 10)
+	if err := backend.Write(ctx, "secret.bin", content); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	stored, _ := inner.Read(ctx, "secret.bin")
+	metadata, _ := inner.GetMetadata(ctx, "secret.bin")
+
+	// A flipped bit in the second chunk
+	flipped := append([]byte(nil), stored...)
+	flipped[ChunkSize+chunkOverhead+1] ^= 1
+	inner.WriteWithMetadata(ctx, "secret.bin", flipped, metadata)
+	if _, err := backend.Read(ctx, "secret.bin"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read of a modified chunk = %v, want ErrCorrupt", err)
+	}
+	if _, err := backend.ReadRange(ctx, "secret.bin", 0, 10); err != nil {
+		t.Errorf("ReadRange of an intact chunk failed: %v", err)
+	}
+
+	// Dropping the last chunk and shrinking the recorded size to match
+	truncated := map[string]string{}
+	for k, v := range metadata {
+		truncated[k] = v
+	}
+	truncated[sizeKey] = "131072"
+	inner.WriteWithMetadata(ctx, "secret.bin", stored[:2*(ChunkSize+chunkOverhead)], truncated)
+	if _, err := backend.Read(ctx, "secret.bin"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read of a truncated object = %v, want ErrCorrupt", err)
+	}
+
+	// Another master key cannot unwrap the data key
+	inner.WriteWithMetadata(ctx, "secret.bin", stored, metadata)
+	other, _ := NewEncryptBackend(inner, bytes.Repeat([]byte{0x24}, KeySize))
+	if _, err := other.Read(ctx, "secret.bin"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read with another master key = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestEncryptBackend_BoundToPath(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+	a, b := testContent(100), bytes.Repeat([]byte("b"), 100)
+	backend.Write(ctx, "a.bin", a)
+	backend.Write(ctx, "b.bin", b)
+
+	// Swapping the stored objects, metadata included, is detected
+	storedA, _ := inner.Read(ctx, "a.bin")
+	metadataA, _ := inner.GetMetadata(ctx, "a.bin")
+	storedB, _ := inner.Read(ctx, "b.bin")
+	metadataB, _ := inner.GetMetadata(ctx, "b.bin")
+	inner.WriteWithMetadata(ctx, "a.bin", storedB, metadataB)
+	inner.WriteWithMetadata(ctx, "b.bin", storedA, metadataA)
+	if _, err := backend.Read(ctx, "a.bin"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read of an object moved from another path = %v, want ErrCorrupt", err)
+	}
+
+	// Renames through the backend wrap the key for the new path
+	backend.Write(ctx, "c.bin", a)
+	if err := backend.Rename(ctx, "c.bin", "d.bin"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if data, err := backend.Read(ctx, "d.bin"); err != nil || !bytes.Equal(data, a) {
+		t.Errorf("Read after Rename = %d bytes, %v", len(data), err)
+	}
+	if err := backend.Copy(ctx, "d.bin", "e.bin"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	for _, path := range []string{"d.bin", "e.bin"} {
+		if data, err := backend.Read(ctx, path); err != nil || !bytes.Equal(data, a) {
+			t.Errorf("Read of %s after Copy = %d bytes, %v", path, len(data), err)
+		}
+	}
+}
+
+// failingMetadata fails GetMetadata as a throttled or timed out HEAD would,
+// and reports no metadata with attributes
+type failingMetadata struct {
+	types.Backend
+}
+
+func (f failingMetadata) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	attr, err := f.Backend.GetAttr(ctx, path)
+	if attr != nil {
+		attr.Metadata = nil
+	}
+	return attr, err
+}
+
+func (f failingMetadata) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	return nil, errors.New("503 slow down")
+}
+
+func TestEncryptBackend_MetadataErrors(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+	backend.Write(ctx, "secret.bin", testContent(100))
+
+	failing, _ := NewEncryptBackend(failingMetadata{inner}, testKey)
+	if data, err := failing.Read(ctx, "secret.bin"); err == nil {
+		t.Errorf("Read without metadata = %d bytes, want the error", len(data))
+	}
+	if data, err := failing.ReadRange(ctx, "secret.bin", 0, 10); err == nil {
+		t.Errorf("ReadRange without metadata = %d bytes, want the error", len(data))
+	}
+	if attr, err := failing.GetAttr(ctx, "secret.bin"); err == nil {
+		t.Errorf("GetAttr without metadata = size %d, want the error", attr.Size)
+	}
+	if _, err := backend.Read(ctx, "missing.bin"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Read of a missing file = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestEncryptBackend_PlainObjects(t *testing.T) {
+	backend, inner := newTestBackend(t)
+	ctx := context.Background()
+
+	// Written before encryption was enabled
+	if err := inner.Write(ctx, "plain.txt", []byte("plain")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, err := backend.Read(ctx, "plain.txt"); err != nil || string(data) != "plain" {
+		t.Errorf("Read = %q, %v; want %q", data, err, "plain")
+	}
+	if data, err := backend.ReadRange(ctx, "plain.txt", 1, 3); err != nil || string(data) != "lai" {
+		t.Errorf("ReadRange = %q, %v; want %q", data, err, "lai")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw.key")
+	hexFile := filepath.Join(dir, "hex.key")
+	short := filepath.Join(dir, "short.key")
+	os.WriteFile(raw, testKey, 0600)
+	os.WriteFile(hexFile, []byte(hex.EncodeToString(testKey)+"\n"), 0600)
+	os.WriteFile(short, []byte("too short"), 0600)
+
+	for _, path := range []string{raw, hexFile} {
+		if key, err := LoadKeyFile(path); err != nil || !bytes.Equal(key, testKey) {
+			t.Errorf("LoadKeyFile(%s) = %x, %v", filepath.Base(path), key, err)
+		}
+	}
+	if _, err := LoadKeyFile(short); err == nil {
+		t.Error("LoadKeyFile accepted a short key")
+	}
+	if _, err := NewEncryptBackend(nil, []byte("too short")); err == nil {
+		t.Error("NewEncryptBackend accepted a short key")
+	}
+}
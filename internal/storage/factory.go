@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/localfs"
 	"github.com/s3fs-fuse/s3fs-go/internal/storage/mongodb"
 	"github.com/s3fs-fuse/s3fs-go/internal/storage/postgres"
 	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
@@ -15,6 +16,7 @@ const (
 	BackendTypeS3       BackendType = "s3"
 	BackendTypePostgres BackendType = "postgres"
 	BackendTypeMongoDB  BackendType = "mongodb"
+	BackendTypeLocal    BackendType = "local"
 )
 
 // Config holds configuration for creating a backend
@@ -32,6 +34,9 @@ type Config struct {
 	MongoDatabase   string
 	MongoCollection string
 	MongoBucket     string
+
+	// Local directory config
+	LocalRoot string
 }
 
 // NewBackend creates a new storage backend based on the config
@@ -75,6 +80,12 @@ func NewBackend(config Config) (types.Backend, error) {
 		}
 		return mongodb.NewMongoBackend(config.MongoURI, database, collection, bucket)
 		
+	case BackendTypeLocal:
+		if config.LocalRoot == "" {
+			return nil, fmt.Errorf("local root directory is required")
+		}
+		return localfs.NewLocalBackend(config.LocalRoot)
+		
 	default:
 		return nil, fmt.Errorf("unknown backend type: %s", config.Type)
 	}
@@ -0,0 +1,635 @@
+// Package localfs implements a storage backend on top of a local directory
+//
+// Keys map to files under the root directory; directories are created and
+// removed as needed, so the tree mirrors the key space the way S3 prefixes do.
+// Metadata (mode, uid, gid, times and xattrs) is kept in JSON sidecar files under
+// the root's .s3fs-meta directory, leaving the data files themselves untouched.
+// The sidecar of dir/file is .s3fs-meta/dir.d/file.json: directories there get
+// a ".d" suffix and sidecars ".json", so no name can be both
+package localfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+// metaDir is the directory under the root holding metadata sidecars and
+// temporary files; keys inside it are rejected
+const metaDir = ".s3fs-meta"
+
+// Suffixes of the sidecar of a key and of the directory holding the sidecars
+// of the keys under a prefix
+const (
+	metaSuffix = ".json"
+	treeSuffix = ".d"
+)
+
+// LocalBackend implements storage.Backend using a local directory
+type LocalBackend struct {
+	root string
+}
+
+// NewLocalBackend creates a backend storing files under root, creating it if needed
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root %s: %w", root, err)
+	}
+	if err := os.MkdirAll(filepath.Join(abs, metaDir, "tmp"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create root %s: %w", abs, err)
+	}
+	return &LocalBackend{root: abs}, nil
+}
+
+// Root returns the absolute path of the root directory
+func (l *LocalBackend) Root() string {
+	return l.root
+}
+
+// filePath returns where key is stored; a trailing "/" names a directory
+func (l *LocalBackend) filePath(key string) (string, error) {
+	clean := strings.TrimSuffix(key, "/")
+	if clean == "" {
+		return l.root, nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(clean)) || clean == metaDir || strings.HasPrefix(clean, metaDir+"/") {
+		return "", fmt.Errorf("invalid path %q: %w", key, syscall.EINVAL)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(clean)), nil
+}
+
+// metaPath returns the sidecar file holding key's metadata
+func (l *LocalBackend) metaPath(key string) string {
+	key = strings.TrimSuffix(key, "/")
+	slash := strings.LastIndex(key, "/")
+	return filepath.Join(l.metaTree(key[:slash+1]), key[slash+1:]+metaSuffix)
+}
+
+// metaTree returns the directory holding the sidecars of keys under prefix
+func (l *LocalBackend) metaTree(prefix string) string {
+	tree := filepath.Join(l.root, metaDir)
+	for _, name := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if name != "" {
+			tree = filepath.Join(tree, name+treeSuffix)
+		}
+	}
+	return tree
+}
+
+// notFound maps errors for missing files (or directories where a file was
+// expected) to os.ErrNotExist
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EISDIR) || errors.Is(err, syscall.ENOTDIR) {
+		return fmt.Errorf("file not found: %w", os.ErrNotExist)
+	}
+	return err
+}
+
+// stat returns the file info for key, which must be a regular file, or a
+// directory when key ends in "/"
+func (l *LocalBackend) stat(key string) (string, os.FileInfo, error) {
+	path, err := l.filePath(key)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, notFound(err)
+	}
+	if info.IsDir() != strings.HasSuffix(key, "/") {
+		return "", nil, fmt.Errorf("file not found: %w", os.ErrNotExist)
+	}
+	return path, info, nil
+}
+
+// Read reads file data
+func (l *LocalBackend) Read(ctx context.Context, path string) ([]byte, error) {
+	p, err := l.filePath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return data, nil
+}
+
+// ReadRange reads bytes start through end (inclusive) with a positioned read
+// An end of 0 or less reads to the end of the file
+func (l *LocalBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
+	p, info, err := l.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if start < 0 {
+		start = 0
+	}
+	if start >= size {
+		return []byte{}, nil
+	}
+	if end <= 0 || end >= size {
+		end = size - 1
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid range: end (%d) < start (%d)", end, start)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	defer f.Close()
+	buf := make([]byte, end-start+1)
+	n, err := f.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return buf[:n], nil
+}
+
+// Write writes file data
+func (l *LocalBackend) Write(ctx context.Context, path string, data []byte) error {
+	return l.WriteWithMetadata(ctx, path, data, nil)
+}
+
+// WriteWithMetadata writes file data with metadata, replacing both
+// The data is written to a temporary file and renamed into place, so readers
+// never see a partly written file. A path ending in "/" creates a directory
+func (l *LocalBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
+	p, err := l.filePath(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(path, "/") {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", path, err)
+		}
+		return l.writeMeta(path, p, metadata)
+	}
+
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		return fmt.Errorf("cannot write %s: %w", path, syscall.EISDIR)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create parent of %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(l.root, metaDir, "tmp"), "write-*")
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return l.writeMeta(path, p, metadata)
+}
+
+// writeMeta stores metadata in key's sidecar (removing it when metadata is
+// empty) and sets the file's modification time from the mtime entry
+// Keys are stored without the "x-amz-meta-" prefix, as S3 returns them
+func (l *LocalBackend) writeMeta(key, filePath string, metadata map[string]string) error {
+	sidecar := l.metaPath(key)
+	if len(metadata) == 0 {
+		if err := os.Remove(sidecar); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to clear metadata of %s: %w", key, err)
+		}
+		return nil
+	}
+
+	stored := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		stored[strings.TrimPrefix(k, "x-amz-meta-")] = v
+	}
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of %s: %w", key, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(sidecar), 0755); err != nil {
+		return fmt.Errorf("failed to write metadata of %s: %w", key, err)
+	}
+	if err := os.WriteFile(sidecar, encoded, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata of %s: %w", key, err)
+	}
+
+	if mtime, ok := parseUnix(stored["mtime"]); ok {
+		os.Chtimes(filePath, mtime, mtime) // Best effort; the sidecar is authoritative
+	}
+	return nil
+}
+
+// readMeta returns key's stored metadata, or an empty map if it has none
+func (l *LocalBackend) readMeta(key string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	encoded, err := os.ReadFile(l.metaPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return metadata, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", key, err)
+	}
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, fmt.Errorf("corrupt metadata for %s: %w", key, err)
+	}
+	return metadata, nil
+}
+
+// parseUnix parses a Unix timestamp in seconds
+func parseUnix(value string) (time.Time, bool) {
+	var unixTime int64
+	if _, err := fmt.Sscanf(value, "%d", &unixTime); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unixTime, 0), true
+}
+
+// Delete deletes a file, and any directories left empty above it
+// Deleting a directory marker ("dir/") leaves a non-empty directory in place,
+// as deleting the marker object does in S3
+func (l *LocalBackend) Delete(ctx context.Context, path string) error {
+	p, _, err := l.stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EEXIST) {
+		return fmt.Errorf("failed to delete %s: %w", path, err)
+	}
+	os.Remove(l.metaPath(path))
+	l.pruneParents(p, l.root)
+	l.pruneParents(l.metaPath(path), filepath.Join(l.root, metaDir))
+	return nil
+}
+
+// pruneParents removes the empty directories above path, stopping at stop
+func (l *LocalBackend) pruneParents(path, stop string) {
+	for dir := filepath.Dir(path); dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return // Not empty (or already gone)
+		}
+	}
+}
+
+// DeleteMany deletes paths one by one; missing paths are not failures
+func (l *LocalBackend) DeleteMany(ctx context.Context, paths []string) error {
+	failed := make(map[string]error)
+	for _, path := range paths {
+		if err := l.Delete(ctx, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			failed[path] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &types.DeleteManyError{Failed: failed}
+	}
+	return nil
+}
+
+// List lists the files under prefix, plus empty directories as "dir/" keys
+func (l *LocalBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return l.list(prefix, 0)
+}
+
+// ListLimited is List, stopping the walk once maxKeys keys are found
+func (l *LocalBackend) ListLimited(ctx context.Context, prefix string, maxKeys int) ([]string, error) {
+	if maxKeys <= 0 {
+		return []string{}, nil
+	}
+	return l.list(prefix, maxKeys)
+}
+
+// list walks the tree below prefix; maxKeys > 0 stops after that many keys
+func (l *LocalBackend) list(prefix string, maxKeys int) ([]string, error) {
+	// Walk from the deepest directory the prefix names
+	base := l.root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		dir, err := l.filePath(prefix[:i+1])
+		if err != nil {
+			return nil, err
+		}
+		base = dir
+	}
+
+	var keys []string
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if p == l.root {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if key == metaDir {
+				return filepath.SkipDir
+			}
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				return nil
+			}
+			key += "/"
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+			if maxKeys > 0 && len(keys) == maxKeys {
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListDelimited returns the files directly under prefix and its subdirectories
+// (each ending in delimiter)
+func (l *LocalBackend) ListDelimited(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	if delimiter != "/" || (prefix != "" && !strings.HasSuffix(prefix, "/")) {
+		return l.splitList(ctx, prefix, delimiter)
+	}
+
+	dir, err := l.filePath(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	var keys, prefixes []string
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir():
+			if prefix == "" && entry.Name() == metaDir {
+				continue
+			}
+			prefixes = append(prefixes, prefix+entry.Name()+"/")
+		case entry.Type().IsRegular():
+			keys = append(keys, prefix+entry.Name())
+		}
+	}
+	return keys, prefixes, nil
+}
+
+// splitList groups a full listing by delimiter, for prefixes that don't end at a
+// directory boundary
+func (l *LocalBackend) splitList(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	all, err := l.List(ctx, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys, prefixes []string
+	seen := make(map[string]bool)
+	for _, key := range all {
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); i >= 0 {
+			common := prefix + rest[:i+len(delimiter)]
+			if !seen[common] {
+				seen[common] = true
+				prefixes = append(prefixes, common)
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, prefixes, nil
+}
+
+// GetAttr gets file attributes
+// Files without stored metadata report mode 0644 (flagged DefaultMode), the
+// mounting user as owner and the file's modification time
+func (l *LocalBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
+	_, info, err := l.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := l.readMeta(path)
+	if err != nil {
+		return nil, err
+	}
+
+	attr := &types.Attr{
+		Size:        info.Size(),
+		Mode:        0644,
+		DefaultMode: true,
+		Uid:         uint32(os.Getuid()),
+		Gid:         uint32(os.Getgid()),
+		Mtime:       info.ModTime(),
+		CacheTTL:    types.CacheTTLFromMetadata(metadata),
+		Metadata:    metadata,
+	}
+	if info.IsDir() {
+		attr.Size = 0
+	}
+	if mode, ok := types.DecodeMode(metadata["mode"]); ok {
+		attr.Mode = uint32(mode)
+		attr.DefaultMode = false
+	}
+	if uidStr, ok := metadata["uid"]; ok {
+		fmt.Sscanf(uidStr, "%d", &attr.Uid)
+	}
+	if gidStr, ok := metadata["gid"]; ok {
+		fmt.Sscanf(gidStr, "%d", &attr.Gid)
+	}
+	if mtime, ok := parseUnix(metadata["mtime"]); ok {
+		attr.Mtime = mtime
+	}
+	if ctime, ok := parseUnix(metadata["ctime"]); ok {
+		attr.Ctime = ctime
+	}
+	if atime, ok := parseUnix(metadata["atime"]); ok {
+		attr.Atime = atime
+	}
+	return attr, nil
+}
+
+// Rename renames a file, or a directory when oldPath ends in "/"
+// An existing file at newPath is replaced atomically, as rename(2) does
+func (l *LocalBackend) Rename(ctx context.Context, oldPath, newPath string) error {
+	if strings.HasSuffix(oldPath, "/") {
+		return l.RenamePrefix(ctx, oldPath, newPath)
+	}
+	src, _, err := l.stat(oldPath)
+	if err != nil {
+		return err
+	}
+	dst, err := l.filePath(newPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create parent of %s: %w", newPath, err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", oldPath, err)
+	}
+
+	if err := l.moveMeta(oldPath, newPath); err != nil {
+		return err
+	}
+	l.pruneParents(src, l.root)
+	return nil
+}
+
+// moveMeta moves the sidecar of oldKey to newKey, dropping whatever newKey had
+func (l *LocalBackend) moveMeta(oldKey, newKey string) error {
+	oldMeta, newMeta := l.metaPath(oldKey), l.metaPath(newKey)
+	os.Remove(newMeta)
+	if _, err := os.Stat(oldMeta); err == nil {
+		if err := os.MkdirAll(filepath.Dir(newMeta), 0755); err != nil {
+			return fmt.Errorf("failed to move metadata of %s: %w", oldKey, err)
+		}
+		if err := os.Rename(oldMeta, newMeta); err != nil {
+			return fmt.Errorf("failed to move metadata of %s: %w", oldKey, err)
+		}
+	}
+	l.pruneParents(oldMeta, filepath.Join(l.root, metaDir))
+	return nil
+}
+
+// RenamePrefix moves the directory oldPrefix to newPrefix with a single rename(2)
+// If newPrefix already holds files the trees are merged file by file instead,
+// replacing files that exist on both sides, as copying the keys in S3 would
+func (l *LocalBackend) RenamePrefix(ctx context.Context, oldPrefix, newPrefix string) error {
+	src, err := l.filePath(oldPrefix)
+	if err != nil {
+		return err
+	}
+	dst, err := l.filePath(newPrefix)
+	if err != nil {
+		return err
+	}
+	if src == l.root || dst == l.root {
+		return fmt.Errorf("cannot rename the root directory: %w", syscall.EINVAL)
+	}
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return fmt.Errorf("directory not found: %w", os.ErrNotExist)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create parent of %s: %w", newPrefix, err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+			if err := l.mergePrefix(ctx, oldPrefix, newPrefix); err != nil {
+				return err
+			}
+			return l.moveMeta(oldPrefix, newPrefix)
+		}
+		return fmt.Errorf("failed to rename %s: %w", oldPrefix, err)
+	}
+	// The directory's own metadata ("dir/" marker) sits beside its tree
+	if err := l.moveMeta(oldPrefix, newPrefix); err != nil {
+		return err
+	}
+
+	oldTree, newTree := l.metaTree(oldPrefix), l.metaTree(newPrefix)
+	os.RemoveAll(newTree)
+	if _, err := os.Stat(oldTree); err == nil {
+		if err := os.MkdirAll(filepath.Dir(newTree), 0755); err != nil {
+			return fmt.Errorf("failed to move metadata of %s: %w", oldPrefix, err)
+		}
+		if err := os.Rename(oldTree, newTree); err != nil {
+			return fmt.Errorf("failed to move metadata of %s: %w", oldPrefix, err)
+		}
+	}
+	l.pruneParents(src, l.root)
+	l.pruneParents(oldTree, filepath.Join(l.root, metaDir))
+	return nil
+}
+
+// mergePrefix moves every file under oldPrefix into newPrefix one at a time
+func (l *LocalBackend) mergePrefix(ctx context.Context, oldPrefix, newPrefix string) error {
+	keys, err := l.List(ctx, oldPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		target := newPrefix + strings.TrimPrefix(key, oldPrefix)
+		if strings.HasSuffix(key, "/") {
+			// Empty directory: recreate it on the other side, then drop the source
+			dst, err := l.filePath(target)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return fmt.Errorf("failed to rename %s: %w", key, err)
+			}
+			if err := l.moveMeta(key, target); err != nil {
+				return err
+			}
+			if err := l.Delete(ctx, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := l.Rename(ctx, key, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateMetadata replaces a file's metadata without rewriting its data
+func (l *LocalBackend) UpdateMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	p, _, err := l.stat(path)
+	if err != nil {
+		return err
+	}
+	return l.writeMeta(path, p, metadata)
+}
+
+// Exists checks if a file exists
+func (l *LocalBackend) Exists(ctx context.Context, path string) (bool, error) {
+	_, _, err := l.stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetMetadata returns the metadata stored with a file, including xattr keys
+func (l *LocalBackend) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
+	if _, _, err := l.stat(path); err != nil {
+		return nil, err
+	}
+	return l.readMeta(path)
+}
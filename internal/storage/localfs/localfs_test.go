@@ -0,0 +1,177 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/backendtest"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
+)
+
+func newTestBackend(t *testing.T) *LocalBackend {
+	backend, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBackend failed: %v", err)
+	}
+	return backend
+}
+
+func TestLocalBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) types.Backend {
+		return newTestBackend(t)
+	})
+}
+
+func TestLocalBackend_RejectsUnsafePaths(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"../escape.txt", "a/../../escape.txt", "/abs.txt", ".s3fs-meta/x.json"} {
+		if err := backend.Write(ctx, key, []byte("x")); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("Write(%q): got %v, want EINVAL", key, err)
+		}
+	}
+}
+
+func TestLocalBackend_Layout(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	if err := backend.WriteWithMetadata(ctx, "dir/file.txt", []byte("data"), map[string]string{"x-amz-meta-mode": "640"}); err != nil {
+		t.Fatalf("WriteWithMetadata failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(backend.Root(), "dir", "file.txt"))
+	if err != nil || string(data) != "data" {
+		t.Fatalf("Data file = %q, %v; want %q", data, err, "data")
+	}
+	metadata, err := backend.GetMetadata(ctx, "dir/file.txt")
+	if err != nil || metadata["mode"] != "640" {
+		t.Errorf("GetMetadata = %v, %v; want mode 640 without the x-amz-meta- prefix", metadata, err)
+	}
+
+	keys, err := backend.List(ctx, "")
+	if err != nil || len(keys) != 1 || keys[0] != "dir/file.txt" {
+		t.Errorf("List = %v, %v; want only dir/file.txt (no sidecars)", keys, err)
+	}
+
+	// Deleting the last file removes the directories created for it
+	if err := backend.Delete(ctx, "dir/file.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(backend.Root(), "dir")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Parent directory left behind after Delete: %v", err)
+	}
+	if _, err := backend.GetAttr(ctx, "dir/file.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetAttr after Delete: got %v, want os.ErrNotExist", err)
+	}
+}
+
+// TestLocalBackend_SidecarNames tests that no key's sidecar shares a name
+// with the directory holding the sidecars of other keys, or with the
+// backend's own temporary files
+func TestLocalBackend_SidecarNames(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	files := map[string]string{
+		"report":          "1",
+		"report.json/a":   "2",
+		"tmp/b":           "3",
+		"nested/c":        "4",
+		"nested/c.json/d": "5",
+	}
+	for key, uid := range files {
+		if err := backend.WriteWithMetadata(ctx, key, []byte(key), map[string]string{"uid": uid}); err != nil {
+			t.Fatalf("WriteWithMetadata(%s) failed: %v", key, err)
+		}
+	}
+	for key, uid := range files {
+		if metadata, err := backend.GetMetadata(ctx, key); err != nil || metadata["uid"] != uid {
+			t.Errorf("GetMetadata(%s) = %v, %v; want uid %s", key, metadata, err, uid)
+		}
+	}
+	if err := backend.Delete(ctx, "report"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if metadata, err := backend.GetMetadata(ctx, "report.json/a"); err != nil || metadata["uid"] != "2" {
+		t.Errorf("GetMetadata(report.json/a) after deleting report = %v, %v", metadata, err)
+	}
+}
+
+func TestLocalBackend_GetAttrDirectory(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	if err := backend.Write(ctx, "dir/file.txt", []byte("x")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// A directory is not an object; the filesystem detects it through List
+	if _, err := backend.GetAttr(ctx, "dir"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetAttr(dir): got %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLocalBackend_ReadRangeToEOF(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	if err := backend.Write(ctx, "range.txt", []byte("0123456789")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := backend.ReadRange(ctx, "range.txt", 7, 0)
+	if err != nil || string(data) != "789" {
+		t.Errorf("ReadRange(7, 0) = %q, %v; want %q", data, err, "789")
+	}
+	data, err = backend.ReadRange(ctx, "range.txt", 10, 12)
+	if err != nil || len(data) != 0 {
+		t.Errorf("ReadRange(10, 12) = %q, %v; want nothing", data, err)
+	}
+}
+
+func TestLocalBackend_Filesystem(t *testing.T) {
+	backend := newTestBackend(t)
+	filesystem := fuse.NewFilesystemWithBackend(backend)
+	ctx := context.Background()
+
+	if err := filesystem.Mkdir(ctx, "docs", 0750); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := filesystem.WriteFile(ctx, "docs/readme.txt", []byte("hello"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := filesystem.Rename(ctx, "docs", "papers"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(backend.Root(), "papers", "readme.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Renamed file on disk = %q, %v; want %q", data, err, "hello")
+	}
+	entries, err := filesystem.ReadDir(ctx, "papers")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if strings.Join(names, ",") != "readme.txt" {
+		t.Errorf("ReadDir(papers) = %v, want [readme.txt]", names)
+	}
+	attr, err := filesystem.GetAttr(ctx, "papers")
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if !attr.Mode.IsDir() || attr.Mode.Perm() != 0750 {
+		t.Errorf("GetAttr(papers) mode = %v, want drwxr-x---", attr.Mode)
+	}
+	if _, err := filesystem.GetAttr(ctx, "docs"); err == nil {
+		t.Error("Old directory still exists after Rename")
+	}
+}
@@ -4,21 +4,38 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
+const (
+	// maxInlineSize is the largest file kept in its own document; anything bigger
+	// is split into chunk documents, since a document (metadata included) must
+	// stay under MongoDB's 16MB limit
+	maxInlineSize = 8 << 20
+	// chunkSize is the amount of data per chunk document
+	chunkSize = 1 << 20
+)
+
 // FileDocument represents a file document in MongoDB
+// Files larger than maxInlineSize have no Data; their content is in the chunk
+// documents whose files_id is ChunksID
 type FileDocument struct {
 	Path     string                 `bson:"_id"`
 	Bucket   string                 `bson:"bucket"`
 	Data     []byte                 `bson:"data"`
 	Size     int64                  `bson:"size"`
-	Mode     uint32                 `bson:"mode"`
+	ChunksID  primitive.ObjectID     `bson:"chunks_id,omitempty"`
+	ChunkSize int64                  `bson:"chunk_size,omitempty"`
+	Mode     uint32                 `bson:"mode"` // POSIX st_mode (see types.PosixMode)
 	Uid      uint32                 `bson:"uid"`
 	Gid      uint32                 `bson:"gid"`
 	Mtime    time.Time              `bson:"mtime"`
@@ -28,12 +45,24 @@ type FileDocument struct {
 	UpdatedAt time.Time            `bson:"updated_at"`
 }
 
+// ChunkDocument holds one piece of a file too large for its file document
+// The layout follows GridFS: chunk n covers bytes [n*chunk_size, (n+1)*chunk_size)
+type ChunkDocument struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	FilesID primitive.ObjectID `bson:"files_id"`
+	N       int64              `bson:"n"`
+	Data    []byte             `bson:"data"`
+}
+
 // MongoBackend implements storage.Backend using MongoDB
 type MongoBackend struct {
 	client     *mongo.Client
 	db         *mongo.Database
 	collection *mongo.Collection
+	chunks     *mongo.Collection // Chunk documents of large files ("<collection>.chunks")
 	bucket     string
+	// transactions is set when the deployment supports multi-document transactions
+	transactions bool
 }
 
 // NewMongoBackend creates a new MongoDB backend
@@ -61,16 +90,37 @@ func NewMongoBackend(uri, database, collection, bucket string) (*MongoBackend, e
 	}
 	coll.Indexes().CreateOne(context.Background(), indexModel)
 
+	chunks := db.Collection(collection + ".chunks")
+	chunks.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "files_id", Value: 1}, {Key: "n", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
 	return &MongoBackend{
-		client:     client,
-		db:         db,
-		collection: coll,
-		bucket:     bucket,
+		client:       client,
+		db:           db,
+		collection:   coll,
+		chunks:       chunks,
+		bucket:       bucket,
+		transactions: supportsTransactions(db),
 	}, nil
 }
 
-// Read reads file data
-func (m *MongoBackend) Read(ctx context.Context, path string) ([]byte, error) {
+// supportsTransactions reports whether the server is a replica set member or a
+// mongos router; standalone servers reject transactions
+func supportsTransactions(db *mongo.Database) bool {
+	var hello bson.M
+	if err := db.RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false
+	}
+	if _, ok := hello["setName"]; ok {
+		return true
+	}
+	return hello["msg"] == "isdbgrid"
+}
+
+// findFile loads the document of path
+func (m *MongoBackend) findFile(ctx context.Context, path string) (*FileDocument, error) {
 	filter := bson.M{"_id": path, "bucket": m.bucket}
 	var doc FileDocument
 	err := m.collection.FindOne(ctx, filter).Decode(&doc)
@@ -80,27 +130,143 @@ func (m *MongoBackend) Read(ctx context.Context, path string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-	return doc.Data, nil
+	return &doc, nil
+}
+
+// Read reads file data
+func (m *MongoBackend) Read(ctx context.Context, path string) ([]byte, error) {
+	doc, err := m.findFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if doc.ChunksID.IsZero() || doc.Size == 0 {
+		return doc.Data, nil
+	}
+	return m.readChunks(ctx, doc, 0, doc.Size-1)
 }
 
-// ReadRange reads a range of file data
+// ReadRange reads bytes start through end (inclusive); an end of 0 or less
+// reads to the end of the file. For chunked files only the chunks covering the
+// range are fetched
 func (m *MongoBackend) ReadRange(ctx context.Context, path string, start, end int64) ([]byte, error) {
-	data, err := m.Read(ctx, path)
+	doc, err := m.findFile(ctx, path)
 	if err != nil {
 		return nil, err
 	}
-	
+
+	size := int64(len(doc.Data))
+	if !doc.ChunksID.IsZero() {
+		size = doc.Size
+	}
 	if start < 0 {
 		start = 0
 	}
-	if end < 0 || end > int64(len(data)) {
-		end = int64(len(data))
-	}
-	if start > int64(len(data)) {
+	if start >= size {
 		return []byte{}, nil
 	}
-	
-	return data[start:end], nil
+	if end <= 0 || end >= size {
+		end = size - 1
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid range: end (%d) < start (%d)", end, start)
+	}
+
+	if doc.ChunksID.IsZero() {
+		return doc.Data[start : end+1], nil
+	}
+	return m.readChunks(ctx, doc, start, end)
+}
+
+// readChunks returns bytes start through end (inclusive) of a chunked file
+func (m *MongoBackend) readChunks(ctx context.Context, doc *FileDocument, start, end int64) ([]byte, error) {
+	size := doc.ChunkSize
+	if size <= 0 {
+		size = chunkSize
+	}
+	first, last := start/size, end/size
+
+	filter := bson.M{"files_id": doc.ChunksID, "n": bson.M{"$gte": first, "$lte": last}}
+	cursor, err := m.chunks.Find(ctx, filter, options.Find().SetSort(bson.M{"n": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunks of %s: %w", doc.Path, err)
+	}
+	defer cursor.Close(ctx)
+
+	data := make([]byte, 0, (last-first+1)*size)
+	next := first
+	for cursor.Next(ctx) {
+		var chunk ChunkDocument
+		if err := cursor.Decode(&chunk); err != nil {
+			return nil, fmt.Errorf("failed to read chunks of %s: %w", doc.Path, err)
+		}
+		if chunk.N != next {
+			return nil, fmt.Errorf("chunk %d of %s is missing", next, doc.Path)
+		}
+		data = append(data, chunk.Data...)
+		next++
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chunks of %s: %w", doc.Path, err)
+	}
+	if next != last+1 {
+		return nil, fmt.Errorf("chunk %d of %s is missing", next, doc.Path)
+	}
+
+	offset := start - first*size
+	if int64(len(data)) < offset+end-start+1 {
+		return nil, fmt.Errorf("chunks of %s are shorter than its size", doc.Path)
+	}
+	return data[offset : offset+end-start+1], nil
+}
+
+// writeChunks stores data as chunk documents under a new files_id
+func (m *MongoBackend) writeChunks(ctx context.Context, data []byte) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	chunks := make([]interface{}, 0, (int64(len(data))+chunkSize-1)/chunkSize)
+	for n := int64(0); n*chunkSize < int64(len(data)); n++ {
+		end := min((n+1)*chunkSize, int64(len(data)))
+		chunks = append(chunks, ChunkDocument{FilesID: id, N: n, Data: data[n*chunkSize : end]})
+	}
+	if _, err := m.chunks.InsertMany(ctx, chunks); err != nil {
+		m.deleteChunks(ctx, id)
+		return primitive.NilObjectID, fmt.Errorf("failed to write chunks: %w", err)
+	}
+	return id, nil
+}
+
+// deleteChunks removes the chunk documents of the given files_ids
+func (m *MongoBackend) deleteChunks(ctx context.Context, ids ...primitive.ObjectID) error {
+	var live []primitive.ObjectID
+	for _, id := range ids {
+		if !id.IsZero() {
+			live = append(live, id)
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+	if _, err := m.chunks.DeleteMany(ctx, bson.M{"files_id": bson.M{"$in": live}}); err != nil {
+		return fmt.Errorf("failed to delete chunks: %w", err)
+	}
+	return nil
+}
+
+// chunkIDs returns the files_ids of the chunked files among paths
+func (m *MongoBackend) chunkIDs(ctx context.Context, paths ...string) ([]primitive.ObjectID, error) {
+	filter := bson.M{"_id": bson.M{"$in": paths}, "bucket": m.bucket, "chunks_id": bson.M{"$exists": true}}
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"chunks_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []FileDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ChunksID)
+	}
+	return ids, nil
 }
 
 // Write writes file data
@@ -109,57 +275,20 @@ func (m *MongoBackend) Write(ctx context.Context, path string, data []byte) erro
 }
 
 // WriteWithMetadata writes file data with metadata
+// For an existing file, metadata is merged into the stored map: keys not passed
+// (xattrs in particular) keep their values, and mode/uid/gid default to the
+// stored ones instead of 0644 and the current user. Use UpdateMetadata to drop keys.
+// Data over maxInlineSize is written as chunk documents first; the chunks of the
+// previous version are deleted once the file document points at the new ones
 func (m *MongoBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
-	mode := uint32(420) // 0644
-	uid := uint32(os.Getuid())
-	gid := uint32(os.Getgid())
-	mtime := time.Now()
-	ctime := mtime
-
-	// Parse metadata if provided
-	metaMap := make(map[string]interface{})
-	if metadata != nil {
-		for k, v := range metadata {
-			metaMap[k] = v
-		}
-		
-		if modeStr, ok := metadata["mode"]; ok {
-			var modeVal uint32
-			fmt.Sscanf(modeStr, "%o", &modeVal)
-			mode = modeVal
-		}
-		if uidStr, ok := metadata["uid"]; ok {
-			fmt.Sscanf(uidStr, "%d", &uid)
-		}
-		if gidStr, ok := metadata["gid"]; ok {
-			fmt.Sscanf(gidStr, "%d", &gid)
-		}
-		if mtimeStr, ok := metadata["mtime"]; ok {
-			var unixTime int64
-			if _, err := fmt.Sscanf(mtimeStr, "%d", &unixTime); err == nil {
-				mtime = time.Unix(unixTime, 0)
-			}
-		}
-		if ctimeStr, ok := metadata["ctime"]; ok {
-			var unixTime int64
-			if _, err := fmt.Sscanf(ctimeStr, "%d", &unixTime); err == nil {
-				ctime = time.Unix(unixTime, 0)
-			}
-		}
-	}
-
 	now := time.Now()
 	doc := FileDocument{
 		Path:      path,
 		Bucket:    m.bucket,
-		Data:      data,
-		Size:      int64(len(data)),
-		Mode:      mode,
-		Uid:       uid,
-		Gid:       gid,
-		Mtime:     mtime,
-		Ctime:     ctime,
-		Metadata:  metaMap,
+		Mode:      types.PosixMode(0644),
+		Uid:       uint32(os.Getuid()),
+		Gid:       uint32(os.Getgid()),
+		Metadata:  make(map[string]interface{}),
 		UpdatedAt: now,
 	}
 
@@ -167,54 +296,173 @@ func (m *MongoBackend) WriteWithMetadata(ctx context.Context, path string, data
 	filter := bson.M{"_id": path, "bucket": m.bucket}
 	var existing FileDocument
 	err := m.collection.FindOne(ctx, filter).Decode(&existing)
-	if err == mongo.ErrNoDocuments {
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to check existing file: %w", err)
+	}
+	found := err == nil
+	if found {
+		doc.Mode, doc.Uid, doc.Gid = existing.Mode, existing.Uid, existing.Gid
+		for k, v := range existing.Metadata {
+			doc.Metadata[k] = v
+		}
+	}
+	doc.Size = int64(len(data))
+	doc.Mtime = now
+	doc.Ctime = now
+	applyMetadata(&doc, metadata)
+	if doc.Size > maxInlineSize {
+		doc.ChunksID, err = m.writeChunks(ctx, data)
+		if err != nil {
+			return err
+		}
+		doc.ChunkSize = chunkSize
+	} else {
+		doc.Data = data
+	}
+
+	if !found {
 		// New document
 		doc.CreatedAt = now
 		_, err = m.collection.InsertOne(ctx, doc)
-	} else if err == nil {
+	} else {
 		// Update existing
-		update := bson.M{
-			"$set": bson.M{
-				"data":       doc.Data,
-				"size":       doc.Size,
-				"mode":       doc.Mode,
-				"uid":        doc.Uid,
-				"gid":        doc.Gid,
-				"mtime":      doc.Mtime,
-				"ctime":      doc.Ctime,
-				"metadata":   doc.Metadata,
-				"updated_at": doc.UpdatedAt,
-			},
+		set := bson.M{
+			"data":       doc.Data,
+			"size":       doc.Size,
+			"mode":       doc.Mode,
+			"uid":        doc.Uid,
+			"gid":        doc.Gid,
+			"mtime":      doc.Mtime,
+			"ctime":      doc.Ctime,
+			"metadata":   doc.Metadata,
+			"updated_at": doc.UpdatedAt,
+		}
+		update := bson.M{"$set": set}
+		if doc.ChunksID.IsZero() {
+			update["$unset"] = bson.M{"chunks_id": "", "chunk_size": ""}
+		} else {
+			set["chunks_id"] = doc.ChunksID
+			set["chunk_size"] = doc.ChunkSize
 		}
 		_, err = m.collection.UpdateOne(ctx, filter, update)
-	} else {
-		return fmt.Errorf("failed to check existing file: %w", err)
 	}
 
 	if err != nil {
+		m.deleteChunks(ctx, doc.ChunksID)
 		return fmt.Errorf("failed to write file: %w", err)
 	}
+	if found {
+		m.deleteChunks(ctx, existing.ChunksID) // Best effort; the file no longer refers to them
+	}
 	return nil
 }
 
-// Delete deletes a file
+// UpdateMetadata replaces a file's metadata without rewriting its data
+// Unlike WriteWithMetadata, keys missing from metadata are removed
+func (m *MongoBackend) UpdateMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	filter := bson.M{"_id": path, "bucket": m.bucket}
+	var existing FileDocument
+	err := m.collection.FindOne(ctx, filter).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("file not found: %w", os.ErrNotExist)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file for metadata update: %w", err)
+	}
+
+	existing.Metadata = make(map[string]interface{}, len(metadata))
+	applyMetadata(&existing, metadata)
+	update := bson.M{
+		"$set": bson.M{
+			"mode":       existing.Mode,
+			"uid":        existing.Uid,
+			"gid":        existing.Gid,
+			"mtime":      existing.Mtime,
+			"ctime":      existing.Ctime,
+			"metadata":   existing.Metadata,
+			"updated_at": time.Now(),
+		},
+	}
+	if _, err := m.collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+// applyMetadata stores metadata in doc.Metadata and copies the mode, uid, gid,
+// mtime and ctime it carries into the document fields
+func applyMetadata(doc *FileDocument, metadata map[string]string) {
+	for k, v := range metadata {
+		doc.Metadata[k] = v
+	}
+
+	if mode, ok := types.DecodeMode(metadata["mode"]); ok {
+		doc.Mode = types.PosixMode(mode)
+	}
+	if uidStr, ok := metadata["uid"]; ok {
+		fmt.Sscanf(uidStr, "%d", &doc.Uid)
+	}
+	if gidStr, ok := metadata["gid"]; ok {
+		fmt.Sscanf(gidStr, "%d", &doc.Gid)
+	}
+	if mtimeStr, ok := metadata["mtime"]; ok {
+		var unixTime int64
+		if _, err := fmt.Sscanf(mtimeStr, "%d", &unixTime); err == nil {
+			doc.Mtime = time.Unix(unixTime, 0)
+		}
+	}
+	if ctimeStr, ok := metadata["ctime"]; ok {
+		var unixTime int64
+		if _, err := fmt.Sscanf(ctimeStr, "%d", &unixTime); err == nil {
+			doc.Ctime = time.Unix(unixTime, 0)
+		}
+	}
+}
+
+// Delete deletes a file, and its chunks if it has any
 func (m *MongoBackend) Delete(ctx context.Context, path string) error {
 	filter := bson.M{"_id": path, "bucket": m.bucket}
-	result, err := m.collection.DeleteOne(ctx, filter)
+	var doc FileDocument
+	err := m.collection.FindOneAndDelete(ctx, filter, options.FindOneAndDelete().SetProjection(bson.M{"chunks_id": 1})).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("file not found: %w", os.ErrNotExist)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
-	if result.DeletedCount == 0 {
-		return fmt.Errorf("file not found: %w", os.ErrNotExist)
+	return m.deleteChunks(ctx, doc.ChunksID)
+}
+
+// DeleteMany deletes paths with a single DeleteMany command, then the chunks
+// of the chunked files among them
+func (m *MongoBackend) DeleteMany(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
 	}
-	return nil
+	fail := func(err error) error {
+		failed := make(map[string]error, len(paths))
+		for _, path := range paths {
+			failed[path] = err
+		}
+		return &types.DeleteManyError{Failed: failed}
+	}
+
+	ids, err := m.chunkIDs(ctx, paths...)
+	if err != nil {
+		return fail(err)
+	}
+	filter := bson.M{"_id": bson.M{"$in": paths}, "bucket": m.bucket}
+	if _, err := m.collection.DeleteMany(ctx, filter); err != nil {
+		return fail(err)
+	}
+	return m.deleteChunks(ctx, ids...)
 }
 
 // List lists objects with the given prefix
 func (m *MongoBackend) List(ctx context.Context, prefix string) ([]string, error) {
 	filter := bson.M{
 		"bucket": m.bucket,
-		"_id":    bson.M{"$regex": "^" + prefix},
+		"_id":    bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
 	}
 	
 	cursor, err := m.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
@@ -234,6 +482,45 @@ func (m *MongoBackend) List(ctx context.Context, prefix string) ([]string, error
 	return paths, cursor.Err()
 }
 
+// ListPage returns up to limit paths under prefix after the path token, read
+// through a cursor in batches of limit without loading the file documents
+func (m *MongoBackend) ListPage(ctx context.Context, prefix, token string, limit int) ([]string, string, error) {
+	idFilter := bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+	if token != "" {
+		idFilter["$gt"] = token
+	}
+	filter := bson.M{"bucket": m.bucket, "_id": idFilter}
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetProjection(bson.M{"_id": 1}).
+		SetLimit(int64(limit)).
+		SetBatchSize(int32(limit))
+
+	cursor, err := m.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list objects: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	paths := make([]string, 0, limit)
+	for cursor.Next(ctx) {
+		var doc struct {
+			Path string `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, "", err
+		}
+		paths = append(paths, doc.Path)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to list objects: %w", err)
+	}
+	if len(paths) == 0 || len(paths) < limit {
+		return paths, "", nil
+	}
+	return paths, paths[len(paths)-1], nil
+}
+
 // GetAttr gets file attributes
 func (m *MongoBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
 	filter := bson.M{"_id": path, "bucket": m.bucket}
@@ -248,44 +535,150 @@ func (m *MongoBackend) GetAttr(ctx context.Context, path string) (*types.Attr, e
 
 	return &types.Attr{
 		Size:  doc.Size,
-		Mode:  doc.Mode,
+		Mode:  uint32(types.ModeFromPosix(doc.Mode)),
 		Uid:   doc.Uid,
 		Gid:   doc.Gid,
 		Mtime: doc.Mtime,
+		Ctime: doc.Ctime,
 	}, nil
 }
 
-// Rename renames a file or directory
+// Rename renames a file, or a directory when oldPath ends in "/"
+// MongoDB cannot change _id in place, so the document is re-inserted under newPath
+// and the old one deleted, inside a transaction when the deployment supports them.
+// An existing file at newPath is replaced, as rename(2) does
 func (m *MongoBackend) Rename(ctx context.Context, oldPath, newPath string) error {
-	filter := bson.M{"_id": oldPath, "bucket": m.bucket}
-	update := bson.M{
-		"$set": bson.M{
-			"_id":        newPath,
-			"updated_at": time.Now(),
-		},
+	if strings.HasSuffix(oldPath, "/") {
+		return m.RenamePrefix(ctx, oldPath, newPath)
+	}
+	return m.withTransaction(ctx, func(ctx context.Context) error {
+		var doc FileDocument
+		err := m.collection.FindOne(ctx, bson.M{"_id": oldPath, "bucket": m.bucket}).Decode(&doc)
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("file not found: %w", os.ErrNotExist)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s for rename: %w", oldPath, err)
+		}
+		return m.moveDocument(ctx, &doc, newPath)
+	})
+}
+
+// RenamePrefix moves every document under oldPrefix to newPrefix
+// All documents move in one transaction when the deployment supports them; on a
+// standalone server a failure can leave the tree split, and repeating the rename
+// moves the rest
+func (m *MongoBackend) RenamePrefix(ctx context.Context, oldPrefix, newPrefix string) error {
+	return m.withTransaction(ctx, func(ctx context.Context) error {
+		filter := bson.M{
+			"bucket": m.bucket,
+			"_id":    bson.M{"$regex": "^" + regexp.QuoteMeta(oldPrefix)},
+		}
+		cursor, err := m.collection.Find(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to list %s for rename: %w", oldPrefix, err)
+		}
+		var docs []FileDocument
+		if err := cursor.All(ctx, &docs); err != nil {
+			return fmt.Errorf("failed to list %s for rename: %w", oldPrefix, err)
+		}
+		if len(docs) == 0 {
+			return fmt.Errorf("directory not found: %w", os.ErrNotExist)
+		}
+
+		for i := range docs {
+			newPath := newPrefix + strings.TrimPrefix(docs[i].Path, oldPrefix)
+			if err := m.moveDocument(ctx, &docs[i], newPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// moveDocument stores doc under newPath, replacing any document of this bucket
+// there, then deletes it from its old path
+// Fails with EEXIST if another bucket already owns newPath, since _id is shared
+// by all buckets in the collection
+func (m *MongoBackend) moveDocument(ctx context.Context, doc *FileDocument, newPath string) error {
+	oldPath := doc.Path
+	if oldPath == newPath {
+		return nil
 	}
-	result, err := m.collection.UpdateOne(ctx, filter, update)
+	doc.Path = newPath
+	doc.UpdatedAt = time.Now()
+
+	// Chunks travel with the document; those of a file being replaced go away
+	replaced, err := m.chunkIDs(ctx, newPath)
 	if err != nil {
-		return fmt.Errorf("failed to rename: %w", err)
+		return fmt.Errorf("failed to read %s: %w", newPath, err)
 	}
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("file not found: %w", os.ErrNotExist)
+
+	filter := bson.M{"_id": newPath, "bucket": m.bucket}
+	_, err = m.collection.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%s is used by another bucket: %w", newPath, syscall.EEXIST)
 	}
-	return nil
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", newPath, err)
+	}
+
+	if _, err := m.collection.DeleteOne(ctx, bson.M{"_id": oldPath, "bucket": m.bucket}); err != nil {
+		return fmt.Errorf("failed to delete %s after copying it: %w", oldPath, err)
+	}
+	return m.deleteChunks(ctx, replaced...)
 }
 
-// Exists checks if a file exists
-// GetMetadata gets raw metadata map for a file
-// TODO: Not implemented for MongoDB backend yet
-// Extended attributes (xattrs) are not currently supported with MongoDB backend
-// This would require reading from the Metadata field in FileDocument and converting
-// map[string]interface{} to map[string]string
+// withTransaction runs fn in a transaction on deployments that support them
+// (replica sets and sharded clusters); on a standalone server fn runs directly
+func (m *MongoBackend) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !m.transactions {
+		return fn(ctx)
+	}
+	session, err := m.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// GetMetadata returns the stored metadata map of a file, including xattr keys
+// Values that are not strings (e.g. written by other tools) are formatted with %v.
+// mode, uid, gid, mtime and ctime are reported from the document fields, which
+// are authoritative
 func (m *MongoBackend) GetMetadata(ctx context.Context, path string) (map[string]string, error) {
-	// Return empty metadata map for now
-	// In the future, this could read from FileDocument.Metadata field
-	return make(map[string]string), nil
+	filter := bson.M{"_id": path, "bucket": m.bucket}
+	var doc FileDocument
+	err := m.collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"data": 0})).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("file not found: %w", os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	metadata := make(map[string]string, len(doc.Metadata)+5)
+	for k, v := range doc.Metadata {
+		if str, ok := v.(string); ok {
+			metadata[k] = str
+		} else {
+			metadata[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	metadata["mode"] = types.EncodeMode(types.ModeFromPosix(doc.Mode))
+	metadata["uid"] = fmt.Sprintf("%d", doc.Uid)
+	metadata["gid"] = fmt.Sprintf("%d", doc.Gid)
+	metadata["mtime"] = fmt.Sprintf("%d", doc.Mtime.Unix())
+	metadata["ctime"] = fmt.Sprintf("%d", doc.Ctime.Unix())
+	return metadata, nil
 }
 
+// Exists checks if a file exists
 func (m *MongoBackend) Exists(ctx context.Context, path string) (bool, error) {
 	filter := bson.M{"_id": path, "bucket": m.bucket}
 	count, err := m.collection.CountDocuments(ctx, filter)
@@ -7,7 +7,7 @@ import (
 	"os"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/s3fs-fuse/s3fs-go/internal/storage/types"
 )
 
@@ -106,7 +106,7 @@ func (p *PostgresBackend) Write(ctx context.Context, path string, data []byte) e
 
 // WriteWithMetadata writes file data with metadata
 func (p *PostgresBackend) WriteWithMetadata(ctx context.Context, path string, data []byte, metadata map[string]string) error {
-	mode := 420 // 0644
+	mode := types.PosixMode(0644) // Stored as the POSIX st_mode
 	uid := uint32(os.Getuid())
 	gid := uint32(os.Getgid())
 	mtime := time.Now()
@@ -114,10 +114,8 @@ func (p *PostgresBackend) WriteWithMetadata(ctx context.Context, path string, da
 
 	// Parse metadata if provided
 	if metadata != nil {
-		if modeStr, ok := metadata["mode"]; ok {
-			var modeVal uint32
-			fmt.Sscanf(modeStr, "%o", &modeVal)
-			mode = int(modeVal)
+		if stored, ok := types.DecodeMode(metadata["mode"]); ok {
+			mode = types.PosixMode(stored)
 		}
 		if uidStr, ok := metadata["uid"]; ok {
 			fmt.Sscanf(uidStr, "%d", &uid)
@@ -176,6 +174,22 @@ func (p *PostgresBackend) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// DeleteMany deletes paths in a single statement
+func (p *PostgresBackend) DeleteMany(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE bucket = $1 AND path = ANY($2)", p.table)
+	if _, err := p.db.ExecContext(ctx, query, p.bucket, pq.Array(paths)); err != nil {
+		failed := make(map[string]error, len(paths))
+		for _, path := range paths {
+			failed[path] = err
+		}
+		return &types.DeleteManyError{Failed: failed}
+	}
+	return nil
+}
+
 // List lists objects with the given prefix
 func (p *PostgresBackend) List(ctx context.Context, prefix string) ([]string, error) {
 	query := fmt.Sprintf("SELECT path FROM %s WHERE bucket = $1 AND path LIKE $2 ORDER BY path", p.table)
@@ -198,13 +212,13 @@ func (p *PostgresBackend) List(ctx context.Context, prefix string) ([]string, er
 
 // GetAttr gets file attributes
 func (p *PostgresBackend) GetAttr(ctx context.Context, path string) (*types.Attr, error) {
-	query := fmt.Sprintf("SELECT size, mode, uid, gid, mtime FROM %s WHERE path = $1 AND bucket = $2", p.table)
+	query := fmt.Sprintf("SELECT size, mode, uid, gid, mtime, ctime FROM %s WHERE path = $1 AND bucket = $2", p.table)
 	var size int64
-	var mode int
+	var mode uint32
 	var uid, gid uint32
-	var mtime time.Time
+	var mtime, ctime time.Time
 
-	err := p.db.QueryRowContext(ctx, query, path, p.bucket).Scan(&size, &mode, &uid, &gid, &mtime)
+	err := p.db.QueryRowContext(ctx, query, path, p.bucket).Scan(&size, &mode, &uid, &gid, &mtime, &ctime)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("file not found: %w", os.ErrNotExist)
 	}
@@ -214,10 +228,11 @@ func (p *PostgresBackend) GetAttr(ctx context.Context, path string) (*types.Attr
 
 	return &types.Attr{
 		Size:  size,
-		Mode:  uint32(mode),
+		Mode:  uint32(types.ModeFromPosix(mode)),
 		Uid:   uid,
 		Gid:   gid,
 		Mtime: mtime,
+		Ctime: ctime,
 	}, nil
 }
 
@@ -2,16 +2,79 @@ package types
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// CacheTTLXattr is the extended attribute that overrides the stat cache TTL for a single path
+const CacheTTLXattr = "user.s3fs.cache_ttl"
+
 // Attr represents file attributes
 type Attr struct {
-	Mode  uint32
-	Size  int64
-	Mtime time.Time
-	Uid   uint32
-	Gid   uint32
+	Mode     uint32
+	Size     int64
+	Mtime    time.Time
+	Ctime    time.Time // Last content or metadata change (zero = same as Mtime)
+	Atime    time.Time // Last read (zero = same as Mtime)
+	Uid      uint32
+	Gid      uint32
+	CacheTTL time.Duration // Per-path stat cache TTL override (0 = use cache default)
+	ETag     string        // Changes whenever the content does (empty = not reported by the backend)
+	// MIME type of the stored content (empty = not reported by the backend)
+	ContentType string
+	// Storage class of the object, e.g. "STANDARD" (empty = not reported)
+	StorageClass string
+	// DefaultMode is set when Mode is a backend fallback because the object
+	// carries no mode metadata (e.g. created outside the filesystem)
+	DefaultMode bool
+	// Raw metadata read along with the attributes, as GetMetadata returns it
+	// (nil = not reported by the backend; use GetMetadata)
+	Metadata map[string]string
+}
+
+// ParseCacheTTL parses a cache TTL xattr value.
+// Accepts a Go duration ("30s", "5m") or a plain number of seconds ("30").
+// The TTL must be positive, since 0 already means the cache default.
+func ParseCacheTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0, strconv.ErrRange
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, strconv.ErrRange
+	}
+	return ttl, nil
+}
+
+// CacheTTLFromMetadata extracts the per-path cache TTL override from raw object metadata.
+// Returns 0 if the xattr is not set or cannot be parsed.
+func CacheTTLFromMetadata(metadata map[string]string) time.Duration {
+	value, ok := metadata["xattr-"+CacheTTLXattr]
+	if !ok {
+		value, ok = metadata["x-amz-meta-xattr-"+CacheTTLXattr]
+	}
+	if !ok {
+		return 0
+	}
+	ttl, err := ParseCacheTTL(value)
+	if err != nil {
+		return 0
+	}
+	return ttl
 }
 
 // Backend defines the interface for storage backends
@@ -32,6 +95,11 @@ type Backend interface {
 	// Delete deletes a file
 	Delete(ctx context.Context, path string) error
 	
+	// DeleteMany deletes several files, batching where the backend allows
+	// Paths that don't exist are not failures; paths that could not be deleted
+	// are reported in a *DeleteManyError so the caller can retry them
+	DeleteMany(ctx context.Context, paths []string) error
+	
 	// List lists objects with the given prefix (for directory listing)
 	List(ctx context.Context, prefix string) ([]string, error)
 	
@@ -49,3 +117,334 @@ type Backend interface {
 	// For backends that don't support raw metadata, returns empty map or error
 	GetMetadata(ctx context.Context, path string) (map[string]string, error)
 }
+
+// DeleteManyError lists the paths a DeleteMany call failed to delete
+type DeleteManyError struct {
+	Failed map[string]error // Path -> reason
+}
+
+func (e *DeleteManyError) Error() string {
+	paths := e.Paths()
+	if len(paths) == 1 {
+		return fmt.Sprintf("failed to delete %s: %v", paths[0], e.Failed[paths[0]])
+	}
+	return fmt.Sprintf("failed to delete %d paths (first %s: %v)", len(paths), paths[0], e.Failed[paths[0]])
+}
+
+// Paths returns the paths that were not deleted, sorted
+func (e *DeleteManyError) Paths() []string {
+	paths := make([]string, 0, len(e.Failed))
+	for path := range e.Failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ErrConflict is returned by conditional writes when the file was changed by
+// another client since the version the write was based on
+var ErrConflict = errors.New("file was modified by another client")
+
+// IsUnavailable reports whether err means the backend could not be reached
+// (refused or reset connections, unreachable hosts, timeouts) rather than that
+// the request itself failed
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
+
+// Copier is implemented by backends that can copy a file without removing the source
+// Directory rename copies the whole tree first, then deletes the old keys in bulk
+type Copier interface {
+	Copy(ctx context.Context, srcPath, dstPath string) error
+}
+
+// PrefixRenamer is implemented by backends that can move a whole directory themselves
+// (e.g. in one database transaction); directory rename prefers it over copying
+type PrefixRenamer interface {
+	// RenamePrefix moves every path under oldPrefix to the same place under newPrefix
+	RenamePrefix(ctx context.Context, oldPrefix, newPrefix string) error
+}
+
+// MetadataUpdater is implemented by backends that can replace a file's metadata
+// without transferring its content (e.g. an S3 copy onto itself)
+type MetadataUpdater interface {
+	UpdateMetadata(ctx context.Context, path string, metadata map[string]string) error
+}
+
+// RangeWriter is implemented by backends that can rewrite part of a file without
+// uploading the rest of it (e.g. S3 multipart uploads copying untouched parts)
+type RangeWriter interface {
+	// WriteRange replaces the bytes of path from offset with data, growing the
+	// file if data runs past its end, and replaces its metadata. It reports
+	// false, changing nothing, when rewriting the whole file costs no more
+	WriteRange(ctx context.Context, path string, offset int64, data []byte, metadata map[string]string) (bool, error)
+}
+
+// ConditionalWriter is implemented by backends that can make a write depend on
+// the stored version, so a file changed by another client is not overwritten
+type ConditionalWriter interface {
+	// WriteIfMatch writes like WriteWithMetadata if path still has the given ETag,
+	// and fails with ErrConflict otherwise. An empty etag writes unconditionally
+	WriteIfMatch(ctx context.Context, path string, data []byte, metadata map[string]string, etag string) error
+}
+
+// ConditionalReader is implemented by backends that can make a read depend on
+// the stored version, so a file read in several ranges is not mixed from two
+type ConditionalReader interface {
+	// ReadRangeIfMatch reads like ReadRange if path still has the given ETag,
+	// and fails with ErrConflict otherwise
+	ReadRangeIfMatch(ctx context.Context, path string, start, end int64, etag string) ([]byte, error)
+}
+
+// ExclusiveWriter is implemented by backends that can create a file only if
+// nothing is stored at its path yet, so of two clients creating it one fails
+type ExclusiveWriter interface {
+	// WriteIfAbsent writes like WriteWithMetadata if path doesn't exist, and
+	// fails with an error wrapping os.ErrExist otherwise
+	WriteIfAbsent(ctx context.Context, path string, data []byte, metadata map[string]string) error
+}
+
+// DelimitedLister is implemented by backends that can list a single directory level
+// ReadDir prefers it over List, which returns every object in the subtree
+type DelimitedLister interface {
+	// ListDelimited returns the keys directly under prefix and the common prefixes
+	// (immediate subdirectories, each ending in delimiter) one level below it
+	ListDelimited(ctx context.Context, prefix, delimiter string) (keys []string, prefixes []string, err error)
+}
+
+// DelimitedPageLister is implemented by backends that can list a single directory
+// level a page at a time. Callers go through ListDelimitedFunc
+type DelimitedPageLister interface {
+	// ListDelimitedPage returns up to limit of the entries ListDelimited would,
+	// keys and common prefixes together, in key order, starting after the
+	// position token describes ("" for the first page). next is the token for
+	// the following page, or "" when there are no more entries
+	ListDelimitedPage(ctx context.Context, prefix, delimiter, token string, limit int) (keys []string, prefixes []string, next string, err error)
+}
+
+// LimitedLister is implemented by backends that can stop listing after a few keys
+// GetAttr and Rmdir only need to know whether a directory has any children
+type LimitedLister interface {
+	// ListLimited returns at most maxKeys keys under prefix
+	ListLimited(ctx context.Context, prefix string, maxKeys int) ([]string, error)
+}
+
+// PageLister is implemented by backends that can list a prefix a page at a time
+// Callers go through ListFunc, which falls back to List for other backends
+type PageLister interface {
+	// ListPage returns up to limit keys under prefix, in key order, starting after
+	// the position token describes ("" for the first page). next is the token for
+	// the following page, or "" when there are no more keys
+	ListPage(ctx context.Context, prefix, token string, limit int) (keys []string, next string, err error)
+}
+
+// SizeLister is implemented by backends that report object sizes in listings
+// Totalling the bytes under a prefix then takes no GetAttr per object
+type SizeLister interface {
+	// ListSizes returns the size of every object under prefix, by path
+	ListSizes(ctx context.Context, prefix string) (map[string]int64, error)
+}
+
+// RootKeyer is implemented by backends whose root is a key prefix, which can
+// have a "prefix/" marker of its own as any other directory does
+type RootKeyer interface {
+	HasRootKey() bool
+}
+
+// Version is one stored version of a file, or a delete marker
+type Version struct {
+	Path     string
+	ID       string
+	Modified time.Time
+	Size     int64
+	Latest   bool // The version reads currently get (or, for a delete marker, don't)
+	Deleted  bool // A delete marker rather than content
+}
+
+// Versioner is implemented by backends that keep the earlier versions of files
+// (e.g. S3 buckets with versioning enabled)
+type Versioner interface {
+	// ListVersions returns every version and delete marker of the paths under
+	// prefix, newest first for each path
+	ListVersions(ctx context.Context, prefix string) ([]Version, error)
+	// ReadVersion returns the content of the given version of path
+	ReadVersion(ctx context.Context, path, id string) ([]byte, error)
+	// RestoreVersion makes the given version of path the current one again,
+	// also for a path deleted since
+	RestoreVersion(ctx context.Context, path, id string) error
+}
+
+// ListFunc calls fn with the keys under prefix, at most pageSize at a time, so a
+// huge prefix is never held in memory whole. Backends without PageLister are
+// listed with List and handed to fn in a single call. An error from fn stops the
+// listing and is returned as is
+func ListFunc(ctx context.Context, backend Backend, prefix string, pageSize int, fn func(keys []string) error) error {
+	lister, ok := backend.(PageLister)
+	if !ok {
+		keys, err := backend.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		return fn(keys)
+	}
+
+	token := ""
+	for {
+		keys, next, err := lister.ListPage(ctx, prefix, token, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+// StatMetadata returns the attributes and raw metadata of path, asking for the
+// metadata separately only from backends whose GetAttr doesn't report it
+func StatMetadata(ctx context.Context, backend Backend, path string) (*Attr, map[string]string, error) {
+	attr, err := backend.GetAttr(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if attr.Metadata != nil {
+		return attr, attr.Metadata, nil
+	}
+	metadata, err := backend.GetMetadata(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attr, metadata, nil
+}
+
+// ListDelimited lists one level below prefix with the backend's DelimitedLister,
+// or by grouping a full listing of prefix for backends without one
+func ListDelimited(ctx context.Context, backend Backend, prefix, delimiter string) ([]string, []string, error) {
+	if lister, ok := backend.(DelimitedLister); ok {
+		return lister.ListDelimited(ctx, prefix, delimiter)
+	}
+	keys, err := backend.List(ctx, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, prefixes := SplitDelimited(keys, prefix, delimiter)
+	return keys, prefixes, nil
+}
+
+// ListDelimitedPage returns a page of the level below prefix with the backend's
+// DelimitedPageLister when it has one; other backends return the whole level
+// as a single page
+func ListDelimitedPage(ctx context.Context, backend Backend, prefix, delimiter, token string, limit int) ([]string, []string, string, error) {
+	if lister, ok := backend.(DelimitedPageLister); ok {
+		return lister.ListDelimitedPage(ctx, prefix, delimiter, token, limit)
+	}
+	keys, prefixes, err := ListDelimited(ctx, backend, prefix, delimiter)
+	return keys, prefixes, "", err
+}
+
+// ListDelimitedFunc calls fn with the keys and common prefixes one level below
+// prefix, up to pageSize entries at a time, stopping at the first error fn
+// returns. Backends without DelimitedPageLister are listed in a single call
+func ListDelimitedFunc(ctx context.Context, backend Backend, prefix, delimiter string, pageSize int, fn func(keys, prefixes []string) error) error {
+	token := ""
+	for {
+		keys, prefixes, next, err := ListDelimitedPage(ctx, backend, prefix, delimiter, token, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 || len(prefixes) > 0 {
+			if err := fn(keys, prefixes); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+// ListLimited returns at most maxKeys keys under prefix, with the backend's
+// LimitedLister when it has one
+func ListLimited(ctx context.Context, backend Backend, prefix string, maxKeys int) ([]string, error) {
+	if lister, ok := backend.(LimitedLister); ok {
+		return lister.ListLimited(ctx, prefix, maxKeys)
+	}
+	keys, err := backend.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return keys[:min(len(keys), maxKeys)], nil
+}
+
+// Copy copies srcPath to dstPath with its metadata, with the backend's Copier
+// or by reading the object and writing it again
+func Copy(ctx context.Context, backend Backend, srcPath, dstPath string) error {
+	if copier, ok := backend.(Copier); ok {
+		return copier.Copy(ctx, srcPath, dstPath)
+	}
+	metadata, err := backend.GetMetadata(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if !strings.HasSuffix(srcPath, "/") {
+		if data, err = backend.Read(ctx, srcPath); err != nil {
+			return err
+		}
+	}
+	return backend.WriteWithMetadata(ctx, dstPath, data, metadata)
+}
+
+// SplitDelimited groups a flat key listing the way a delimited list request would:
+// keys directly under prefix are returned as-is, deeper keys collapse into their
+// first-level common prefix (ending in delimiter)
+func SplitDelimited(allKeys []string, prefix, delimiter string) ([]string, []string) {
+	keys := []string{}
+	prefixes := []string{}
+	seen := make(map[string]bool)
+	for _, key := range allKeys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := key[len(prefix):]
+		if i := strings.Index(rest, delimiter); i >= 0 {
+			common := prefix + rest[:i+len(delimiter)]
+			if !seen[common] {
+				seen[common] = true
+				prefixes = append(prefixes, common)
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sort.Strings(prefixes)
+	return keys, prefixes
+}
@@ -0,0 +1,119 @@
+package types
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PermissionBits are the mode bits chmod sets: the permissions plus the
+// setuid, setgid and sticky bits
+const PermissionBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// POSIX st_mode bits (see inode(7))
+const (
+	posixTypeMask = 0170000
+	posixSocket   = 0140000
+	posixSymlink  = 0120000
+	posixRegular  = 0100000
+	posixBlock    = 0060000
+	posixDir      = 0040000
+	posixChar     = 0020000
+	posixFIFO     = 0010000
+	posixSetuid   = 04000
+	posixSetgid   = 02000
+	posixSticky   = 01000
+)
+
+// PosixMode returns the POSIX st_mode for mode, file type included. A mode
+// without a type bit is a regular file
+func PosixMode(mode os.FileMode) uint32 {
+	posix := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		posix |= posixSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		posix |= posixSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		posix |= posixSticky
+	}
+	switch {
+	case mode&os.ModeDir != 0:
+		posix |= posixDir
+	case mode&os.ModeSymlink != 0:
+		posix |= posixSymlink
+	case mode&os.ModeNamedPipe != 0:
+		posix |= posixFIFO
+	case mode&os.ModeSocket != 0:
+		posix |= posixSocket
+	case mode&os.ModeCharDevice != 0:
+		posix |= posixChar
+	case mode&os.ModeDevice != 0:
+		posix |= posixBlock
+	default:
+		posix |= posixRegular
+	}
+	return posix
+}
+
+// ModeFromPosix returns the os.FileMode for a POSIX st_mode. Values too large
+// for an st_mode are os.FileMode bits, which earlier versions stored as is
+func ModeFromPosix(posix uint32) os.FileMode {
+	if posix > 0177777 {
+		return os.FileMode(posix)
+	}
+	mode := os.FileMode(posix) & os.ModePerm
+	if posix&posixSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if posix&posixSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if posix&posixSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	switch posix & posixTypeMask {
+	case posixDir:
+		mode |= os.ModeDir
+	case posixSymlink:
+		mode |= os.ModeSymlink
+	case posixFIFO:
+		mode |= os.ModeNamedPipe
+	case posixSocket:
+		mode |= os.ModeSocket
+	case posixChar:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case posixBlock:
+		mode |= os.ModeDevice
+	}
+	return mode
+}
+
+// EncodeMode returns the "mode" metadata value for mode: the POSIX st_mode in
+// decimal, as C++ s3fs stores it, so either can read the other's objects
+func EncodeMode(mode os.FileMode) string {
+	return strconv.FormatUint(uint64(PosixMode(mode)), 10)
+}
+
+// DecodeMode parses a "mode" metadata value written by EncodeMode or by C++
+// s3fs. Earlier versions wrote octal instead, either the permission bits
+// ("0644", "644") or the os.FileMode bits, and those still decode. A decimal
+// value is told apart by its file type bits, which the octal values never
+// carried; values of up to four digits are always taken as octal, which only
+// misreads FIFOs and devices, as the filesystem never creates those. ok is
+// false for values that aren't a mode at all
+func DecodeMode(value string) (mode os.FileMode, ok bool) {
+	value = strings.TrimSpace(value)
+	if len(value) > 4 && value[0] != '0' {
+		posix, err := strconv.ParseUint(value, 10, 32)
+		if err == nil && posix <= 0177777 && posix&posixTypeMask != 0 {
+			return ModeFromPosix(uint32(posix)), true
+		}
+	}
+	legacy, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return ModeFromPosix(uint32(legacy)), true
+}
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"os"
+	"testing"
+)
+
+func TestModeRoundTrip(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want string // Decimal st_mode, as C++ s3fs stores it
+	}{
+		{0644, "33188"},
+		{0600, "33152"},
+		{os.ModeDir | 0755, "16877"},
+		{os.ModeSymlink | 0777, "41471"},
+		{os.ModeSetuid | 0755, "35309"},
+		{os.ModeSetgid | os.ModeDir | 0775, "17917"},
+		{os.ModeSticky | os.ModeDir | 0777, "17407"},
+		{os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0700, "36800"},
+		{os.ModeSocket | 0755, "49645"},
+	}
+	for _, tt := range tests {
+		encoded := EncodeMode(tt.mode)
+		if encoded != tt.want {
+			t.Errorf("EncodeMode(%v) = %q, want %q", tt.mode, encoded, tt.want)
+		}
+		if decoded, ok := DecodeMode(encoded); !ok || decoded != tt.mode {
+			t.Errorf("DecodeMode(%q) = %v, %v; want %v", encoded, decoded, ok, tt.mode)
+		}
+		if fromPosix := ModeFromPosix(PosixMode(tt.mode)); fromPosix != tt.mode {
+			t.Errorf("ModeFromPosix(PosixMode(%v)) = %v", tt.mode, fromPosix)
+		}
+	}
+}
+
+func TestDecodeLegacyMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  os.FileMode
+	}{
+		{"0644", 0644},                        // Create and Chmod wrote %04o
+		{"644", 0644},                         // Octal without the leading zero
+		{"1777", os.ModeSticky | 0777},        // Octal sticky bit
+		{"20000000755", os.ModeDir | 0755},    // Mkdir wrote %o of os.ModeDir|perm
+		{"1000000777", os.ModeSymlink | 0777}, // Symlink wrote %o of os.ModeSymlink|perm
+		{"40000755", os.ModeSetuid | 0755},    // %o of os.ModeSetuid|perm
+		{"0100644", 0644},                     // Octal st_mode
+		{" 33188 ", 0644},                     // Surrounding space
+	}
+	for _, tt := range tests {
+		if mode, ok := DecodeMode(tt.value); !ok || mode != tt.want {
+			t.Errorf("DecodeMode(%q) = %v, %v; want %v", tt.value, mode, ok, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "rw-r--r--", "0x1a4", "-1"} {
+		if mode, ok := DecodeMode(value); ok {
+			t.Errorf("DecodeMode(%q) = %v, want not ok", value, mode)
+		}
+	}
+
+	// Numeric columns written before the st_mode encoding hold plain permissions
+	if mode := ModeFromPosix(420); mode != 0644 {
+		t.Errorf("ModeFromPosix(420) = %v, want %v", mode, os.FileMode(0644))
+	}
+}
@@ -0,0 +1,33 @@
+package types
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DecodeTime parses an "mtime", "ctime" or "atime" metadata value: Unix
+// seconds, which C++ s3fs may write with a fractional part
+// ("1700000000.123456789"). ok is false for values that aren't a time at all
+func DecodeTime(value string) (t time.Time, ok bool) {
+	value = strings.TrimSpace(value)
+	secs, frac, hasFrac := strings.Cut(value, ".")
+	unix, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !hasFrac || frac == "" {
+		return time.Unix(unix, 0), true
+	}
+	// Nanoseconds are the most a time.Time holds; finer digits are dropped
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	nsec, err := strconv.ParseUint(frac, 10, 32)
+	if err != nil {
+		return time.Time{}, false
+	}
+	nsec *= uint64(math.Pow10(9 - len(frac)))
+	return time.Unix(unix, int64(nsec)), true
+}
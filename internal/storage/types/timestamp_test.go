@@ -0,0 +1,31 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"1700000000", time.Unix(1700000000, 0)},
+		{" 1700000000 ", time.Unix(1700000000, 0)},
+		{"1700000000.", time.Unix(1700000000, 0)},
+		{"1700000000.5", time.Unix(1700000000, 500000000)},
+		{"1700000000.123456789", time.Unix(1700000000, 123456789)},
+		{"1700000000.1234567891", time.Unix(1700000000, 123456789)},
+	}
+	for _, tt := range tests {
+		if got, ok := DecodeTime(tt.value); !ok || !got.Equal(tt.want) {
+			t.Errorf("DecodeTime(%q) = %v, %v; want %v", tt.value, got, ok, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "1700000000.x", "1.2.3"} {
+		if got, ok := DecodeTime(value); ok {
+			t.Errorf("DecodeTime(%q) = %v, want not ok", value, got)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package types
+
+import "context"
+
+// writtenKey is the context key WithWritten stores its Written under
+type writtenKey struct{}
+
+// Written describes the object a write stored, as storage reported it in its
+// response to the write
+type Written struct {
+	ETag string // ETag of the stored object (empty = not reported)
+}
+
+// WithWritten returns a context under which writes report what they stored
+// into the returned Written, so the caller learns the new ETag without asking
+// storage again. Wrapping backends pass the context on to the write that
+// stores the path's object; the last write made under it is the one reported
+func WithWritten(ctx context.Context) (context.Context, *Written) {
+	written := &Written{}
+	return context.WithValue(ctx, writtenKey{}, written), written
+}
+
+// WithoutWritten returns a context whose writes report nothing, for writes a
+// backend makes to objects other than the one the caller is writing
+func WithoutWritten(ctx context.Context) context.Context {
+	if ctx.Value(writtenKey{}) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, writtenKey{}, (*Written)(nil))
+}
+
+// ReportWritten records the ETag storage returned for a write made under ctx;
+// it does nothing unless ctx came from WithWritten
+func ReportWritten(ctx context.Context, etag string) {
+	if written, _ := ctx.Value(writtenKey{}).(*Written); written != nil {
+		written.ETag = etag
+	}
+}
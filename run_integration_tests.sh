@@ -19,7 +19,7 @@ if [ "$PROVIDER" = "localstack" ]; then
         echo "Start LocalStack with:"
         echo "  docker-compose -f docker-compose.localstack.yml up -d"
         echo ""
-        echo "Or set S3_PROVIDER=s3 or S3_PROVIDER=r2 to use production services"
+        echo "Or set S3_PROVIDER=s3, S3_PROVIDER=r2 or S3_PROVIDER=compat (with S3_ENDPOINT) to use other services"
         exit 1
     fi
     
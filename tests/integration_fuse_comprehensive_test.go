@@ -302,16 +302,9 @@ func TestReadDirEmpty(t *testing.T) {
 		t.Fatalf("Failed to read directory: %v", err)
 	}
 
-	// Should be empty (or only contain .keep)
-	realEntries := 0
-	for _, entry := range entries {
-		if entry.Name != ".keep" {
-			realEntries++
-		}
-	}
-
-	if realEntries != 0 {
-		t.Errorf("Expected empty directory, found %d entries", realEntries)
+	// Should be empty; directory markers are never listed
+	if len(entries) != 0 {
+		t.Errorf("Expected empty directory, found %d entries", len(entries))
 	}
 
 	// Cleanup
@@ -353,9 +346,7 @@ func TestReadDirWithFiles(t *testing.T) {
 	// Verify all files are present
 	foundFiles := make(map[string]bool)
 	for _, entry := range entries {
-		if entry.Name != ".keep" {
-			foundFiles[entry.Name] = true
-		}
+		foundFiles[entry.Name] = true
 	}
 
 	for _, fileName := range fileNames {
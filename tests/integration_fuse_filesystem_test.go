@@ -5,9 +5,11 @@ package tests
 import (
 	"context"
 	"fmt"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
 )
 
 // TestRename tests renaming a file
@@ -105,6 +107,84 @@ func TestRenameNonExistent(t *testing.T) {
 	}
 }
 
+// TestRenameOntoExisting mirrors how coreutils mv treats an existing destination:
+// `mv a b` replaces file b, `mv dir empty` replaces an empty directory, and
+// `mv dir full` fails with "Directory not empty"
+func TestRenameOntoExisting(t *testing.T) {
+	fs := SetupTestFilesystem(t, LocalStackBucket, LocalStackRegion)
+	ctx := context.Background()
+
+	base := fmt.Sprintf("test-mv-%d", time.Now().UnixNano())
+	defer fs.RemoveAll(ctx, base)
+	mkdir := func(path string) {
+		if err := fs.Mkdir(ctx, path, 0755); err != nil {
+			t.Fatalf("Failed to create directory %s: %v", path, err)
+		}
+	}
+	write := func(path, content string) {
+		if err := fs.WriteFile(ctx, path, []byte(content), 0); err != nil {
+			t.Fatalf("Failed to create file %s: %v", path, err)
+		}
+	}
+
+	mkdir(base)
+	write(base+"/a.txt", "a")
+	write(base+"/b.txt", "b")
+	mkdir(base + "/dir")
+	write(base+"/dir/x.txt", "x")
+	mkdir(base + "/empty")
+	mkdir(base + "/full")
+	write(base+"/full/y.txt", "y")
+
+	// mv a.txt b.txt
+	if err := fs.Rename(ctx, base+"/a.txt", base+"/b.txt"); err != nil {
+		t.Fatalf("File over file failed: %v", err)
+	}
+	data, err := fs.ReadFile(ctx, base+"/b.txt", 0, 0)
+	if err != nil || string(data) != "a" {
+		t.Errorf("b.txt = %q (err %v), want %q", data, err, "a")
+	}
+	if _, err := fs.GetAttr(ctx, base+"/a.txt"); err == nil {
+		t.Error("a.txt should be gone after mv")
+	}
+
+	// mv -T b.txt dir: cannot overwrite directory with non-directory
+	if err := fs.Rename(ctx, base+"/b.txt", base+"/dir"); err != syscall.EISDIR {
+		t.Errorf("File over directory = %v, want EISDIR", err)
+	}
+
+	// mv -T dir b.txt: cannot overwrite non-directory with directory
+	if err := fs.Rename(ctx, base+"/dir", base+"/b.txt"); err != syscall.ENOTDIR {
+		t.Errorf("Directory over file = %v, want ENOTDIR", err)
+	}
+
+	// mv -T dir full: Directory not empty
+	if err := fs.Rename(ctx, base+"/dir", base+"/full"); err != syscall.ENOTEMPTY {
+		t.Errorf("Directory over non-empty directory = %v, want ENOTEMPTY", err)
+	}
+	data, err = fs.ReadFile(ctx, base+"/full/y.txt", 0, 0)
+	if err != nil || string(data) != "y" {
+		t.Errorf("full/y.txt changed by failed rename: %q (err %v)", data, err)
+	}
+
+	// mv dir dir/sub: cannot move a directory to a subdirectory of itself
+	if err := fs.Rename(ctx, base+"/dir", base+"/dir/sub"); err != syscall.EINVAL {
+		t.Errorf("Directory into itself = %v, want EINVAL", err)
+	}
+
+	// mv -T dir empty
+	if err := fs.Rename(ctx, base+"/dir", base+"/empty"); err != nil {
+		t.Fatalf("Directory over empty directory failed: %v", err)
+	}
+	data, err = fs.ReadFile(ctx, base+"/empty/x.txt", 0, 0)
+	if err != nil || string(data) != "x" {
+		t.Errorf("empty/x.txt = %q (err %v), want %q", data, err, "x")
+	}
+	if _, err := fs.GetAttr(ctx, base+"/dir"); err == nil {
+		t.Error("dir should be gone after mv")
+	}
+}
+
 // TestUtimens tests setting file times
 func TestUtimens(t *testing.T) {
 	fs := SetupTestFilesystem(t, LocalStackBucket, LocalStackRegion)
@@ -504,8 +584,15 @@ func TestListXattr(t *testing.T) {
 		t.Fatalf("Failed to list xattrs: %v", err)
 	}
 
-	if len(names) != len(xattrs) {
-		t.Errorf("Expected %d xattrs, got %d", len(xattrs), len(names))
+	// Besides the ones set, S3 objects have the read-only ETag and content type
+	var userNames []string
+	for _, name := range names {
+		if name != fuse.ETagXattr && name != fuse.ContentTypeXattr {
+			userNames = append(userNames, name)
+		}
+	}
+	if len(userNames) != len(xattrs) {
+		t.Errorf("Expected %d xattrs, got %d", len(xattrs), len(userNames))
 	}
 
 	// Verify all xattrs are listed
@@ -672,14 +759,14 @@ func TestMkdirNested(t *testing.T) {
 	fs.Rmdir(ctx, parentDir)
 }
 
-// TestRmdirWithMarker tests removing directory with .keep marker
+// TestRmdirWithMarker tests removing directory with its "dir/" marker
 func TestRmdirWithMarker(t *testing.T) {
 	fs := SetupTestFilesystem(t, LocalStackBucket, LocalStackRegion)
 	ctx := context.Background()
 
 	testDir := fmt.Sprintf("test-rmdir-marker-%d", time.Now().UnixNano())
 
-	// Create directory (creates .keep marker)
+	// Create directory (creates the "dir/" marker)
 	err := fs.Mkdir(ctx, testDir, 0755)
 	if err != nil {
 		t.Fatalf("Failed to create directory: %v", err)
@@ -0,0 +1,270 @@
+//go:build integration && mongodb
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/mongodb"
+)
+
+// SetupMongoBackend connects to MONGODB_URI (default mongodb://localhost:27017)
+// using a bucket unique to the test, and removes the test's documents afterwards
+func SetupMongoBackend(t *testing.T) *mongodb.MongoBackend {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+	bucket := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	backend, err := mongodb.NewMongoBackend(uri, "s3fs_test", "files", bucket)
+	if err != nil {
+		t.Fatalf("MongoDB is not available at %s: %v", uri, err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if paths, err := backend.List(ctx, ""); err == nil {
+			backend.DeleteMany(ctx, paths)
+		}
+		backend.Close()
+	})
+	return backend
+}
+
+// TestMongoRenameFile tests renaming a file on the MongoDB backend
+func TestMongoRenameFile(t *testing.T) {
+	backend := SetupMongoBackend(t)
+	ctx := context.Background()
+	prefix := fmt.Sprintf("mongo-rename-%d/", time.Now().UnixNano())
+
+	if err := backend.WriteWithMetadata(ctx, prefix+"old.txt", []byte("hello"), map[string]string{"mode": "600"}); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := backend.Rename(ctx, prefix+"old.txt", prefix+"new.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	if exists, _ := backend.Exists(ctx, prefix+"old.txt"); exists {
+		t.Error("Old document still exists")
+	}
+	data, err := backend.Read(ctx, prefix+"new.txt")
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Renamed file = %q (err %v)", data, err)
+	}
+	attr, err := backend.GetAttr(ctx, prefix+"new.txt")
+	if err != nil || attr.Mode != 0600 {
+		t.Errorf("Renamed file lost its attributes: %+v (err %v)", attr, err)
+	}
+
+	if err := backend.Rename(ctx, prefix+"missing.txt", prefix+"other.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Renaming a missing file = %v, want os.ErrNotExist", err)
+	}
+}
+
+// TestMongoRenameOverwrite tests that renaming onto an existing file replaces it
+func TestMongoRenameOverwrite(t *testing.T) {
+	backend := SetupMongoBackend(t)
+	ctx := context.Background()
+	prefix := fmt.Sprintf("mongo-overwrite-%d/", time.Now().UnixNano())
+
+	if err := backend.Write(ctx, prefix+"a.txt", []byte("new content")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := backend.Write(ctx, prefix+"b.txt", []byte("old content")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := backend.Rename(ctx, prefix+"a.txt", prefix+"b.txt"); err != nil {
+		t.Fatalf("Rename over existing file failed: %v", err)
+	}
+
+	data, err := backend.Read(ctx, prefix+"b.txt")
+	if err != nil || string(data) != "new content" {
+		t.Errorf("b.txt = %q (err %v), want %q", data, err, "new content")
+	}
+	paths, _ := backend.List(ctx, prefix)
+	if len(paths) != 1 {
+		t.Errorf("Expected only b.txt to remain, got %v", paths)
+	}
+}
+
+// TestMongoRenameDirectory tests renaming a directory through the filesystem
+func TestMongoRenameDirectory(t *testing.T) {
+	backend := SetupMongoBackend(t)
+	fs := fuse.NewFilesystemWithBackend(backend)
+	ctx := context.Background()
+	base := fmt.Sprintf("mongo-dir-%d", time.Now().UnixNano())
+
+	keys := []string{"/.keep", "/a.txt", "/sub/.keep", "/sub/b.txt"}
+	for _, key := range keys {
+		if err := backend.Write(ctx, base+"-old"+key, []byte(key)); err != nil {
+			t.Fatalf("Failed to write %s: %v", key, err)
+		}
+	}
+
+	if err := fs.Rename(ctx, base+"-old", base+"-new"); err != nil {
+		t.Fatalf("Directory rename failed: %v", err)
+	}
+
+	if remaining, _ := backend.List(ctx, base+"-old/"); len(remaining) != 0 {
+		t.Errorf("Documents left under the old prefix: %v", remaining)
+	}
+	moved, err := backend.List(ctx, base+"-new/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	sort.Strings(moved)
+	var want []string
+	for _, key := range keys {
+		want = append(want, base+"-new"+key)
+	}
+	sort.Strings(want)
+	if strings.Join(moved, ",") != strings.Join(want, ",") {
+		t.Errorf("Moved documents = %v, want %v", moved, want)
+	}
+	data, err := backend.Read(ctx, base+"-new/sub/b.txt")
+	if err != nil || string(data) != "/sub/b.txt" {
+		t.Errorf("Moved file = %q (err %v)", data, err)
+	}
+}
+
+// TestMongoXattrs tests setting, listing and removing xattrs on a MongoDB-backed file
+func TestMongoXattrs(t *testing.T) {
+	backend := SetupMongoBackend(t)
+	fs := fuse.NewFilesystemWithBackend(backend)
+	ctx := context.Background()
+	path := fmt.Sprintf("mongo-xattr-%d.txt", time.Now().UnixNano())
+
+	if err := backend.WriteWithMetadata(ctx, path, []byte("content"), map[string]string{"mode": "600"}); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	attrs := map[string]string{"user.color": "blue", "user.size": "large", "user.owner": "ops"}
+	for name, value := range attrs {
+		if err := fs.SetXattr(ctx, path, name, []byte(value)); err != nil {
+			t.Fatalf("SetXattr %s failed: %v", name, err)
+		}
+	}
+
+	names, err := fs.ListXattr(ctx, path)
+	if err != nil {
+		t.Fatalf("ListXattr failed: %v", err)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "user.color,user.owner,user.size" {
+		t.Errorf("ListXattr = %s, want all three xattrs", got)
+	}
+
+	// Rewriting the content must not drop the xattrs or the mode
+	if err := backend.Write(ctx, path, []byte("new content")); err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+	if value, err := fs.GetXattr(ctx, path, "user.color"); err != nil || string(value) != "blue" {
+		t.Errorf("user.color after rewrite = %q (err %v), want %q", value, err, "blue")
+	}
+	if attr, err := backend.GetAttr(ctx, path); err != nil || attr.Mode != 0600 {
+		t.Errorf("Rewrite lost the mode: %+v (err %v)", attr, err)
+	}
+
+	if err := fs.RemoveXattr(ctx, path, "user.size"); err != nil {
+		t.Fatalf("RemoveXattr failed: %v", err)
+	}
+	names, err = fs.ListXattr(ctx, path)
+	if err != nil {
+		t.Fatalf("ListXattr failed: %v", err)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "user.color,user.owner" {
+		t.Errorf("ListXattr after removal = %s, want user.color,user.owner", got)
+	}
+	for _, name := range []string{"user.color", "user.owner"} {
+		if value, err := fs.GetXattr(ctx, path, name); err != nil || string(value) != attrs[name] {
+			t.Errorf("%s = %q (err %v), want %q", name, value, err, attrs[name])
+		}
+	}
+	if _, err := fs.GetXattr(ctx, path, "user.size"); err == nil {
+		t.Error("Removed xattr user.size is still readable")
+	}
+	data, err := backend.Read(ctx, path)
+	if err != nil || string(data) != "new content" {
+		t.Errorf("File content = %q (err %v) after xattr changes", data, err)
+	}
+}
+
+// TestMongoLargeFile tests a file too large for a single MongoDB document
+func TestMongoLargeFile(t *testing.T) {
+	backend := SetupMongoBackend(t)
+	ctx := context.Background()
+	path := fmt.Sprintf("mongo-large-%d.bin", time.Now().UnixNano())
+
+	const size = 40 << 20
+	const chunk = 1 << 20
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := backend.WriteWithMetadata(ctx, path, data, map[string]string{"mode": "600"}); err != nil {
+		t.Fatalf("Failed to write 40MB file: %v", err)
+	}
+
+	attr, err := backend.GetAttr(ctx, path)
+	if err != nil || attr.Size != size || attr.Mode != 0600 {
+		t.Fatalf("GetAttr = %+v (err %v), want size %d and mode 600", attr, err, size)
+	}
+	got, err := backend.Read(ctx, path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Read returned %d bytes that differ from the %d written", len(got), size)
+	}
+
+	ranges := []struct{ start, end int64 }{
+		{0, 99},
+		{chunk - 10, chunk + 10},   // Across a chunk boundary
+		{5*chunk + 3, 7*chunk + 5}, // Spanning several chunks
+		{size - 100, size - 1},     // Last bytes
+		{size - 50, 0},             // To EOF
+	}
+	for _, r := range ranges {
+		got, err := backend.ReadRange(ctx, path, r.start, r.end)
+		if err != nil {
+			t.Errorf("ReadRange(%d, %d) failed: %v", r.start, r.end, err)
+			continue
+		}
+		end := r.end
+		if end <= 0 {
+			end = size - 1
+		}
+		if !bytes.Equal(got, data[r.start:end+1]) {
+			t.Errorf("ReadRange(%d, %d) returned %d bytes that differ from the written data", r.start, r.end, len(got))
+		}
+	}
+
+	// Shrinking the file stores it inline again
+	if err := backend.Write(ctx, path, []byte("small")); err != nil {
+		t.Fatalf("Failed to overwrite with small content: %v", err)
+	}
+	if got, err := backend.Read(ctx, path); err != nil || string(got) != "small" {
+		t.Errorf("Read after overwrite = %q (err %v), want %q", got, err, "small")
+	}
+	if attr, err := backend.GetAttr(ctx, path); err != nil || attr.Size != 5 {
+		t.Errorf("GetAttr after overwrite = %+v (err %v), want size 5", attr, err)
+	}
+
+	if err := backend.Write(ctx, path, data); err != nil {
+		t.Fatalf("Failed to rewrite 40MB file: %v", err)
+	}
+	if err := backend.Delete(ctx, path); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := backend.ReadRange(ctx, path, 0, 99); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadRange after Delete = %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build integration
+
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
+)
+
+// TestPrefixMount mounts a prefix of the bucket and checks that files land
+// under it and that a sibling prefix sharing its name stays invisible
+func TestPrefixMount(t *testing.T) {
+	if GetProvider() == ProviderLocal {
+		t.Skip("Prefix mounts need an S3 bucket")
+	}
+	client := SetupTestClient(t, LocalStackBucket, LocalStackRegion)
+	ctx := context.Background()
+
+	base := fmt.Sprintf("test-prefix-%d/", time.Now().UnixNano())
+	mounted, sibling := base+"data/", base+"data2/"
+	if err := client.PutObject(ctx, sibling+"secret.txt", []byte("sibling")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	defer func() {
+		keys, _ := client.ListObjects(ctx, base)
+		for _, key := range keys {
+			client.DeleteObject(ctx, key)
+		}
+	}()
+
+	fs, err := fuse.NewFilesystemWithPrefix(client, mounted)
+	if err != nil {
+		t.Fatalf("NewFilesystemWithPrefix failed: %v", err)
+	}
+	if err := fs.WriteFile(ctx, "/file.txt", []byte("data"), 0); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.Flush(ctx, "/file.txt"); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.Rename(ctx, "/file.txt", "/dir/file.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	keys, err := client.ListObjects(ctx, base)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	sort.Strings(keys)
+	want := []string{mounted + "dir/", mounted + "dir/file.txt", sibling + "secret.txt"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected keys %v, got %v", want, keys)
+	}
+
+	entries, err := fs.ReadDir(ctx, "/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "dir" || !entries[0].IsDir {
+		t.Errorf("Expected the mount to list only dir, got %v", entries)
+	}
+	if _, err := fs.GetAttr(ctx, "/"); err != nil {
+		t.Errorf("GetAttr of the root failed: %v", err)
+	}
+	if _, err := fs.GetAttr(ctx, "/secret.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the sibling prefix's file to be invisible, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build integration
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+// rangeCountingClient counts the ranged GETs sent to S3
+type rangeCountingClient struct {
+	*s3client.Client
+	ranges int64
+}
+
+func (c *rangeCountingClient) GetObjectRange(ctx context.Context, key string, start, end int64) ([]byte, error) {
+	atomic.AddInt64(&c.ranges, 1)
+	return c.Client.GetObjectRange(ctx, key, start, end)
+}
+
+// TestReadAheadSequentialRead reads a 64MB file in kernel-sized requests with and
+// without readahead and compares the number of range requests and the time taken
+func TestReadAheadSequentialRead(t *testing.T) {
+	client := SetupTestClient(t, LocalStackBucket, LocalStackRegion)
+	ctx := context.Background()
+
+	key := fmt.Sprintf("test-readahead-%d.bin", time.Now().UnixNano())
+	content := make([]byte, 64*1024*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	if err := client.PutObjectMultipart(ctx, key, content); err != nil {
+		t.Fatalf("Failed to upload test file: %v", err)
+	}
+	defer client.DeleteObject(ctx, key)
+
+	read := func(readAhead int64) (int64, time.Duration) {
+		counting := &rangeCountingClient{Client: client}
+		fs := fuse.NewFilesystem(counting)
+		fs.SetReadAheadSize(readAhead)
+
+		const chunk = 128 * 1024
+		start := time.Now()
+		for offset := 0; offset < len(content); offset += chunk {
+			data, err := fs.ReadFile(ctx, key, int64(offset), chunk)
+			if err != nil {
+				t.Fatalf("ReadFile at %d failed: %v", offset, err)
+			}
+			if !bytes.Equal(data, content[offset:offset+chunk]) {
+				t.Fatalf("ReadFile at %d returned the wrong data", offset)
+			}
+		}
+		return atomic.LoadInt64(&counting.ranges), time.Since(start)
+	}
+
+	before, beforeTime := read(0)
+	after, afterTime := read(8 * 1024 * 1024)
+	t.Logf("64MB sequential read: %d range requests in %v without readahead, %d in %v with 8MB readahead",
+		before, beforeTime, after, afterTime)
+
+	if before != 512 {
+		t.Errorf("Expected one range request per 128KB read without readahead, got %d", before)
+	}
+	if after > 20 {
+		t.Errorf("Expected about one range request per 4MB with 8MB readahead, got %d", after)
+	}
+}
@@ -0,0 +1,141 @@
+//go:build integration
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
+	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
+)
+
+// Checks of the places where S3-compatible servers are known to differ from
+// AWS. Run them against MinIO or Ceph RGW with S3_PROVIDER=compat
+
+// TestCompatMultipartETag checks that a multipart upload completes with the
+// part ETags the server returned and that its ETag works for conditional writes
+func TestCompatMultipartETag(t *testing.T) {
+	client := SetupTestClient(t, LocalStackBucket, LocalStackRegion)
+	ctx := context.Background()
+
+	key := fmt.Sprintf("compat-multipart-%d", time.Now().UnixNano())
+	data := bytes.Repeat([]byte("m"), s3client.MinMultipartSize+1024*1024)
+	if err := client.PutObjectWithMetadata(ctx, key, data, map[string]string{"mode": "0100644"}); err != nil {
+		t.Fatalf("Multipart upload failed: %v", err)
+	}
+	defer client.DeleteObject(ctx, key)
+
+	etag, err := client.HeadObjectETag(ctx, key)
+	if err != nil {
+		t.Fatalf("HeadObjectETag failed: %v", err)
+	}
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `-2"`) {
+		t.Errorf("Expected the quoted ETag of a 2 part upload, got %s", etag)
+	}
+	stored, err := client.GetObject(ctx, key)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("Multipart object has %d bytes, want %d", len(stored), len(data))
+	}
+
+	if err := client.PutObjectIfMatch(ctx, key, []byte("replaced"), nil, etag); err != nil {
+		t.Errorf("Write conditional on the current ETag failed: %v", err)
+	}
+	err = client.PutObjectIfMatch(ctx, key, []byte("stale"), nil, etag)
+	if err != nil && !errors.Is(err, s3client.ErrPreconditionFailed) {
+		t.Errorf("Write conditional on a stale ETag: expected ErrPreconditionFailed, got %v", err)
+	}
+}
+
+// TestCompatMetadataKeyCase checks that metadata written with any key case is
+// read back under lower-case keys and parsed into file attributes
+func TestCompatMetadataKeyCase(t *testing.T) {
+	client := SetupTestClient(t, LocalStackBucket, LocalStackRegion)
+	ctx := context.Background()
+
+	key := fmt.Sprintf("compat-metadata-%d", time.Now().UnixNano())
+	err := client.PutObjectWithMetadata(ctx, key, []byte("data"), map[string]string{
+		"X-Amz-Meta-Mode": "0100600",
+		"Uid":             "1234",
+		"x-amz-meta-gid":  "5678",
+	})
+	if err != nil {
+		t.Fatalf("PutObjectWithMetadata failed: %v", err)
+	}
+	defer client.DeleteObject(ctx, key)
+
+	metadata, err := client.HeadObject(ctx, key)
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	for k, v := range map[string]string{"mode": "0100600", "uid": "1234", "gid": "5678"} {
+		if metadata[k] != v {
+			t.Errorf("Expected metadata %s=%s, got %v", k, v, metadata)
+		}
+	}
+
+	attr, err := fuse.NewFilesystem(client).GetAttr(ctx, key)
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if attr.Mode != os.FileMode(0600) || attr.Uid != 1234 || attr.Gid != 5678 {
+		t.Errorf("Expected mode 0600 owned by 1234:5678, got %v owned by %d:%d", attr.Mode, attr.Uid, attr.Gid)
+	}
+}
+
+// TestCompatListPagination checks that listings follow continuation tokens to
+// the last page and stop there
+func TestCompatListPagination(t *testing.T) {
+	client := SetupTestClient(t, LocalStackBucket, LocalStackRegion)
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("compat-list-%d/", time.Now().UnixNano())
+	var want []string
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("%sfile-%d", prefix, i)
+		if err := client.PutObject(ctx, key, []byte("x")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		defer client.DeleteObject(ctx, key)
+		want = append(want, key)
+	}
+
+	var paged []string
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatalf("Listing did not end after %d pages", pages)
+		}
+		keys, next, err := client.ListPage(ctx, prefix, token, 2)
+		if err != nil {
+			t.Fatalf("ListPage failed: %v", err)
+		}
+		paged = append(paged, keys...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	sort.Strings(paged)
+	if strings.Join(paged, ",") != strings.Join(want, ",") {
+		t.Errorf("Paged listing returned %v, want %v", paged, want)
+	}
+
+	keys, err := client.ListObjects(ctx, prefix)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(keys) != len(want) {
+		t.Errorf("ListObjects returned %d keys, want %d", len(keys), len(want))
+	}
+}
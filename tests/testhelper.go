@@ -6,6 +6,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/s3fs-fuse/s3fs-go/internal/credentials"
 	"github.com/s3fs-fuse/s3fs-go/internal/s3client"
 	"github.com/s3fs-fuse/s3fs-go/internal/fuse"
+	"github.com/s3fs-fuse/s3fs-go/internal/storage/localfs"
 )
 
 const (
@@ -28,6 +30,8 @@ const (
 	ProviderLocalStack Provider = "localstack"
 	ProviderS3         Provider = "s3"
 	ProviderR2         Provider = "r2"
+	ProviderCompat     Provider = "compat" // Any S3-compatible server, e.g. MinIO or Ceph RGW
+	ProviderLocal      Provider = "local" // Directory-backed; filesystem tests only
 )
 
 // GetProvider returns the S3 provider from environment variable
@@ -38,6 +42,10 @@ func GetProvider() Provider {
 		return ProviderS3
 	case "r2", "cloudflare":
 		return ProviderR2
+	case "compat", "minio", "ceph", "rgw":
+		return ProviderCompat
+	case "local":
+		return ProviderLocal
 	default:
 		return ProviderLocalStack // Default to LocalStack
 	}
@@ -55,7 +63,11 @@ func IsLocalStackAvailable() bool {
 }
 
 // RequireLocalStack checks if LocalStack is available and fails the test if not
+// Other providers are set up and checked by SetupTestClient
 func RequireLocalStack(t *testing.T) {
+	if GetProvider() != ProviderLocalStack {
+		return
+	}
 	if !IsLocalStackAvailable() {
 		t.Fatalf("LocalStack is not available. Start it with: docker-compose -f docker-compose.localstack.yml up -d")
 	}
@@ -72,22 +84,7 @@ func SetupTestClient(t *testing.T, bucket, region string) *s3client.Client {
 		creds.AccessKeyID = "test"
 		creds.SecretAccessKey = "test"
 		client := s3client.NewClientWithEndpoint(bucket, region, LocalStackEndpoint, creds)
-		
-		// Create bucket if it doesn't exist
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		
-		_, err := client.ListObjects(ctx, "")
-		if err != nil {
-			err = client.CreateBucket(ctx)
-			if err != nil {
-				if !strings.Contains(err.Error(), "BucketAlreadyOwnedByYou") &&
-					!strings.Contains(err.Error(), "BucketAlreadyExists") {
-					t.Fatalf("Failed to create bucket: %v", err)
-				}
-			}
-			time.Sleep(500 * time.Millisecond)
-		}
+		ensureBucket(t, client)
 		return client
 
 	case ProviderS3:
@@ -116,14 +113,72 @@ func SetupTestClient(t *testing.T, bucket, region string) *s3client.Client {
 		}
 		return s3client.NewClientWithEndpoint(bucket, region, endpoint, creds)
 
+	case ProviderCompat:
+		// S3_ENDPOINT, plus S3_PATH_STYLE=true/false to override the addressing
+		// the client picks for it
+		endpoint := os.Getenv("S3_ENDPOINT")
+		if endpoint == "" {
+			t.Fatal("S3_ENDPOINT environment variable is required for the compat provider")
+		}
+		creds := credentials.NewCredentials()
+		if err := creds.LoadFromEnvironment(); err != nil {
+			t.Fatalf("Failed to load credentials: %v", err)
+		}
+		if !creds.IsValid() {
+			t.Fatal("Invalid credentials. Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		if b := os.Getenv("S3_BUCKET"); b != "" {
+			bucket = b
+		}
+		client := s3client.NewClientWithEndpoint(bucket, region, endpoint, creds)
+		if value := os.Getenv("S3_PATH_STYLE"); value != "" {
+			pathStyle, err := strconv.ParseBool(value)
+			if err != nil {
+				t.Fatalf("Invalid S3_PATH_STYLE %q: %v", value, err)
+			}
+			client.SetPathStyle(pathStyle)
+		}
+		ensureBucket(t, client)
+		return client
+
+	case ProviderLocal:
+		t.Skip("Test talks to S3 directly; not supported with S3_PROVIDER=local")
+		return nil
+
 	default:
 		t.Fatalf("Unknown provider: %s", provider)
 		return nil
 	}
 }
 
+// ensureBucket creates the client's bucket if it doesn't exist yet
+func ensureBucket(t *testing.T, client *s3client.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.ListObjects(ctx, ""); err == nil {
+		return
+	}
+	err := client.CreateBucket(ctx)
+	if err != nil {
+		if !strings.Contains(err.Error(), "BucketAlreadyOwnedByYou") &&
+			!strings.Contains(err.Error(), "BucketAlreadyExists") {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+}
+
 // SetupTestFilesystem sets up a filesystem for testing
+// With S3_PROVIDER=local it is backed by a fresh temporary directory instead of a bucket
 func SetupTestFilesystem(t *testing.T, bucket, region string) *fuse.Filesystem {
+	if GetProvider() == ProviderLocal {
+		backend, err := localfs.NewLocalBackend(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create local backend: %v", err)
+		}
+		return fuse.NewFilesystemWithBackend(backend)
+	}
 	client := SetupTestClient(t, bucket, region)
 	return fuse.NewFilesystem(client)
 }